@@ -16,6 +16,8 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -29,6 +31,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/assetgc"
 	"github.com/skygeario/skygear-server/pkg/server/audit"
 	"github.com/skygeario/skygear-server/pkg/server/authtoken"
 	"github.com/skygeario/skygear-server/pkg/server/handler"
@@ -44,11 +47,17 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/pubsub"
 	"github.com/skygeario/skygear-server/pkg/server/push"
 	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/schemafile"
 	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	_ "github.com/skygeario/skygear-server/pkg/server/skydb/mem"
 	_ "github.com/skygeario/skygear-server/pkg/server/skydb/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/querycache"
+	_ "github.com/skygeario/skygear-server/pkg/server/skydb/sqlite"
 	"github.com/skygeario/skygear-server/pkg/server/skyversion"
 	"github.com/skygeario/skygear-server/pkg/server/subscription"
+	"github.com/skygeario/skygear-server/pkg/server/tenant"
+	"github.com/skygeario/skygear-server/pkg/server/trash"
 )
 
 var log = logging.LoggerEntry("main")
@@ -70,9 +79,49 @@ func main() {
 
 	initLogger(config)
 
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		os.Exit(runSchemaCommand(config, os.Args[2:]))
+	}
+
 	mainLogger := logging.LoggerEntryWithTag("main", "") // untagged logger
 	mainLogger.Infof("Starting Skygear Server(%s)...", skyversion.Version())
-	connOpener := ensureDB(config) // Fatal on DB failed
+
+	reloader := &serverReloader{config: config}
+	var appHandler http.Handler
+	if config.MultiTenant() {
+		reloader.mux = initTenantMux(config)
+		appHandler = reloader.mux
+	} else {
+		server, err := initApp(config, reloader)
+		if err != nil {
+			mainLogger.Fatalf("Failed to start skygear server: %v", err)
+		}
+		reloader.app = server.reloader
+		appHandler = server
+	}
+	go reloader.Listen()
+
+	finalMux := &router.RequestIDMiddleware{
+		Next: appHandler,
+	}
+
+	mainLogger.Printf("Listening on %v...", config.HTTP.Host)
+	err := http.ListenAndServe(config.HTTP.Host, finalMux)
+	if err != nil {
+		mainLogger.Printf("Failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+// initApp sets up the services of the app of config, and returns the
+// server of the requests of the app. The config:reload action reloads
+// the configuration by configReloader.
+func initApp(config skyconfig.Configuration, configReloader handler.ConfigReloader) (*appServer, error) {
+	mainLogger := logging.LoggerEntryWithTag("main", "") // untagged logger
+	connOpener, err := ensureDB(config)
+	if err != nil {
+		return nil, err
+	}
 
 	initUserAuthRecordKeys(connOpener, config.App.AuthRecordKeys)
 
@@ -84,7 +133,29 @@ func main() {
 	r := router.NewRouter()
 	r.ResponseTimeout = time.Duration(config.App.ResponseTimeout) * time.Second
 	serveMux := http.NewServeMux()
-	pushSender := initPushSender(config, connOpener)
+	routeSender, apnsPusher, err := initPushSender(config, connOpener)
+	if err != nil {
+		return nil, err
+	}
+	pushSender := push.NewReloadableSender(routeSender)
+
+	assetStore := initAssetStore(config)
+	chunkAssembler := asset.NewChunkAssembler(assetStore, config.AssetStore.UploadStagingDir)
+	assetCollector := &assetgc.Collector{
+		ConnOpener:  connOpener,
+		Store:       assetStore,
+		GracePeriod: time.Duration(config.AssetStore.GC.GracePeriod) * time.Second,
+		Interval:    time.Duration(config.AssetStore.GC.Interval) * time.Second,
+	}
+	uploadReaper := &assetgc.UploadReaper{
+		ConnOpener: connOpener,
+		Assembler:  chunkAssembler,
+	}
+	trashPurger := &trash.Purger{
+		ConnOpener: connOpener,
+		Retention:  time.Duration(config.DB.Trash.Retention) * time.Second,
+		Interval:   time.Duration(config.DB.Trash.Interval) * time.Second,
+	}
 
 	tokenStore := authtoken.InitTokenStore(authtoken.Configuration{
 		Implementation: config.TokenStore.ImplName,
@@ -95,20 +166,9 @@ func main() {
 	})
 
 	dbConfig := baseDBConfig(config)
+	dbOpener := initDBOpener(config)
 
-	passwordChecker := &audit.PasswordChecker{
-		PwMinLength:            config.UserAudit.PwMinLength,
-		PwUppercaseRequired:    config.UserAudit.PwUppercaseRequired,
-		PwLowercaseRequired:    config.UserAudit.PwLowercaseRequired,
-		PwDigitRequired:        config.UserAudit.PwDigitRequired,
-		PwSymbolRequired:       config.UserAudit.PwSymbolRequired,
-		PwMinGuessableLevel:    config.UserAudit.PwMinGuessableLevel,
-		PwExcludedKeywords:     config.UserAudit.PwExcludedKeywords,
-		PwExcludedFields:       config.UserAudit.PwExcludedFields,
-		PwHistorySize:          config.UserAudit.PwHistorySize,
-		PwHistoryDays:          config.UserAudit.PwHistoryDays,
-		PasswordHistoryEnabled: dbConfig.PasswordHistoryEnabled,
-	}
+	passwordChecker := initPasswordChecker(config)
 
 	pwHousekeeper := &audit.PwHousekeeper{
 		AppName:       config.App.Name,
@@ -138,11 +198,26 @@ func main() {
 		Config:           config,
 	}
 
+	// stops are the functions stopping the services of the app, called
+	// in order when the app is removed from a server hosting multiple
+	// apps
+	var stops []func()
 	var internalHub *pubsub.Hub
 	if !config.App.Slave {
 		internalHub = pubsub.NewHub()
-		initSubscription(config, connOpener, internalHub, pushSender)
+		subscriptionService := initSubscription(config, connOpener, internalHub, pushSender)
 		initDevice(config, connOpener)
+		pushScheduler := initPushScheduler(config, connOpener, pushSender)
+		initAssetCollector(config, assetCollector)
+		initUploadReaper(uploadReaper)
+		initTrashPurger(config, trashPurger)
+		stops = append(stops,
+			subscriptionService.Stop,
+			pushScheduler.Stop,
+			assetCollector.Stop,
+			uploadReaper.Stop,
+			trashPurger.Stop,
+		)
 	}
 
 	// Preprocessor
@@ -171,11 +246,21 @@ func main() {
 	preprocessorRegistry["dbconn"] = &pp.ConnPreprocessor{
 		AppName:       config.App.Name,
 		AccessControl: config.App.AccessControl,
-		DBOpener:      skydb.Open,
+		DBOpener:      dbOpener,
 		DBImpl:        config.DB.ImplName,
 		Option:        config.DB.Option,
 		DBConfig:      dbConfig,
 	}
+	migrationDBConfig := dbConfig
+	migrationDBConfig.CanMigrate = true
+	preprocessorRegistry["migration_dbconn"] = &pp.ConnPreprocessor{
+		AppName:       config.App.Name,
+		AccessControl: config.App.AccessControl,
+		DBOpener:      dbOpener,
+		DBImpl:        config.DB.ImplName,
+		Option:        config.DB.Option,
+		DBConfig:      migrationDBConfig,
+	}
 	preprocessorRegistry["plugin_ready"] = &pp.EnsurePluginReadyPreprocessor{
 		PluginContext: &pluginContext,
 		ClientKey:     config.App.APIKey,
@@ -230,10 +315,30 @@ func main() {
 			Name:     "TokenStore",
 		},
 		&inject.Object{
-			Value:    initAssetStore(config),
+			Value:    assetStore,
 			Complete: true,
 			Name:     "AssetStore",
 		},
+		&inject.Object{
+			Value:    chunkAssembler,
+			Complete: true,
+			Name:     "AssetChunkAssembler",
+		},
+		&inject.Object{
+			Value:    assetCollector,
+			Complete: true,
+			Name:     "AssetCollector",
+		},
+		&inject.Object{
+			Value:    initAssetPolicies(config),
+			Complete: true,
+			Name:     "AssetPolicies",
+		},
+		&inject.Object{
+			Value:    time.Duration(config.DB.SlowQueryThreshold) * time.Millisecond,
+			Complete: true,
+			Name:     "SlowQueryThreshold",
+		},
 		&inject.Object{
 			Value:    pushSender,
 			Complete: true,
@@ -264,6 +369,11 @@ func main() {
 			Complete: true,
 			Name:     "PwHousekeeper",
 		},
+		&inject.Object{
+			Value:    configReloader,
+			Complete: true,
+			Name:     "ConfigReloader",
+		},
 	)
 	if injectErr != nil {
 		panic(fmt.Sprintf("Unable to set up handler: %v", injectErr))
@@ -293,11 +403,17 @@ func main() {
 	}))
 
 	r.Map("asset:put", "asset", injector.Inject(&handler.AssetUploadHandler{}))
+	r.Map("asset:gc", "asset", injector.Inject(&handler.AssetGCHandler{}))
+
+	r.Map("config:reload", "config", injector.Inject(&handler.ConfigReloadHandler{}))
 
 	r.Map("record:fetch", "record", injector.Inject(&handler.RecordFetchHandler{}))
 	r.Map("record:query", "record", injector.Inject(&handler.RecordQueryHandler{}))
 	r.Map("record:save", "record", injector.Inject(&handler.RecordSaveHandler{}))
 	r.Map("record:delete", "record", injector.Inject(&handler.RecordDeleteHandler{}))
+	r.Map("record:undelete", "record", injector.Inject(&handler.RecordUndeleteHandler{}))
+	r.Map("record:history", "record", injector.Inject(&handler.RecordHistoryHandler{}))
+	r.Map("record:restore", "record", injector.Inject(&handler.RecordRestoreHandler{}))
 
 	r.Map("device:register", "device", injector.Inject(&handler.DeviceRegisterHandler{}))
 	r.Map("device:unregister", "device", injector.Inject(&handler.DeviceUnregisterHandler{}))
@@ -323,15 +439,24 @@ func main() {
 
 	r.Map("push:user", "push", injector.Inject(&handler.PushToUserHandler{}))
 	r.Map("push:device", "push", injector.Inject(&handler.PushToDeviceHandler{}))
+	r.Map("push:cancel", "push", injector.Inject(&handler.PushCancelHandler{}))
 
 	r.Map("schema:rename", "schema", injector.Inject(&handler.SchemaRenameHandler{}))
 	r.Map("schema:delete", "schema", injector.Inject(&handler.SchemaDeleteHandler{}))
+	r.Map("schema:alter_type", "schema", injector.Inject(&handler.SchemaAlterTypeHandler{}))
 	r.Map("schema:create", "schema", injector.Inject(&handler.SchemaCreateHandler{}))
 	r.Map("schema:fetch", "schema", injector.Inject(&handler.SchemaFetchHandler{}))
 	r.Map("schema:access", "schema", injector.Inject(&handler.SchemaAccessHandler{}))
 	r.Map("schema:default_access", "schema", injector.Inject(&handler.SchemaDefaultAccessHandler{}))
+	r.Map("schema:history:set", "schema", injector.Inject(&handler.SchemaHistorySetHandler{}))
+	r.Map("schema:history:fetch", "schema", injector.Inject(&handler.SchemaHistoryFetchHandler{}))
+	r.Map("schema:soft_delete", "schema", injector.Inject(&handler.SchemaSoftDeleteHandler{}))
 	r.Map("schema:field_access:get", "schema", injector.Inject(&handler.SchemaFieldAccessGetHandler{}))
 	r.Map("schema:field_access:update", "schema", injector.Inject(&handler.SchemaFieldAccessUpdateHandler{}))
+	r.Map("schema:index:fetch", "schema", injector.Inject(&handler.SchemaIndexFetchHandler{}))
+	r.Map("schema:index:create", "schema", injector.Inject(&handler.SchemaIndexCreateHandler{}))
+	r.Map("schema:index:delete", "schema", injector.Inject(&handler.SchemaIndexDeleteHandler{}))
+	r.Map("schema:apply", "schema", injector.Inject(&handler.SchemaApplyHandler{}))
 
 	serveMux.Handle("/", r)
 
@@ -358,22 +483,36 @@ func main() {
 	fileGateway.PUT(uploadFileHandler)
 	fileGateway.POST(uploadFileHandler)
 
-	corsHost := config.App.CORSHost
-
-	var finalMux http.Handler
-	if corsHost != "" {
-		finalMux = &router.CORSMiddleware{
-			Origin: corsHost,
-			Next:   serveMux,
-		}
-	} else {
-		finalMux = serveMux
+	uploadGateway := router.NewGateway("uploads/(.+)", "/uploads/", "asset", serveMux)
+	uploadGateway.ResponseTimeout = time.Duration(config.App.ResponseTimeout) * time.Second
+	chunkUploadHandler := injector.Inject(&handler.ChunkUploadHandler{})
+	uploadGateway.POST(chunkUploadHandler)
+	uploadGateway.Handle(http.MethodPatch, chunkUploadHandler)
+	uploadGateway.Handle(http.MethodHead, chunkUploadHandler)
+	uploadGateway.Handle(http.MethodDelete, chunkUploadHandler)
+
+	// record export and import are streamed and not subject to the
+	// response timeout
+	recordExportGateway := router.NewGateway("records/export", "/records/export", "record", serveMux)
+	recordExportGateway.POST(injector.Inject(&handler.RecordExportHandler{}))
+
+	recordImportGateway := router.NewGateway("records/import", "/records/import", "record", serveMux)
+	recordImportGateway.POST(injector.Inject(&handler.RecordImportHandler{}))
+
+	// CORS is set up even without the CORS host, which can be set by
+	// reloading the configuration
+	cors := &router.CORSMiddleware{
+		Origin: config.App.CORSHost,
+		Next:   serveMux,
 	}
 
+	var finalMux http.Handler = cors
+
 	if config.LOG.Level == "debug" {
 		loggingMiddleware := &router.LoggingMiddleware{
 			Skips: []string{
 				"/files/",
+				"/uploads/",
 				"/_/pubsub/",
 				"/pubsub/",
 			},
@@ -393,19 +532,100 @@ func main() {
 		finalMux = loggingMiddleware
 	}
 
-	finalMux = &router.RequestIDMiddleware{
-		Next: finalMux,
-	}
-
 	// Bootstrap finished, starting services
 	initPlugin(config, &pluginContext)
 
-	mainLogger.Printf("Listening on %v...", config.HTTP.Host)
-	err := http.ListenAndServe(config.HTTP.Host, finalMux)
-	if err != nil {
-		mainLogger.Printf("Failed: %v", err)
-		os.Exit(1)
+	reloader := &appReloader{
+		config:          config,
+		live:            skyconfig.LiveSettings,
+		connOpener:      connOpener,
+		cors:            cors,
+		passwordChecker: passwordChecker,
+		tokenStore:      tokenStore,
+		pushSender:      pushSender,
+		apnsPusher:      apnsPusher,
+	}
+	stops = append(stops, pluginContext.Stop, reloader.Stop, func() {
+		// the database is closed after the services using it are stopped
+		if err := skydb.CloseApp(config.DB.ImplName, config.App.Name, config.DB.Option); err != nil {
+			mainLogger.WithField("err", err).Errorf("Failed to close database of app %s", config.App.Name)
+		}
+	})
+
+	return &appServer{
+		Handler:  finalMux,
+		reloader: reloader,
+		stops:    stops,
+	}, nil
+}
+
+// initTenantMux returns the handler of the requests of the apps hosted by
+// the server, which reloads the apps periodically to set up the added
+// apps.
+func initTenantMux(config skyconfig.Configuration) *tenant.Mux {
+	logger := logging.LoggerEntryWithTag("main", "tenant")
+
+	var loader tenant.Loader
+	if config.Tenant.AppDir != "" {
+		loader = &tenant.DirLoader{
+			Dir:  config.Tenant.AppDir,
+			Base: config,
+		}
+	} else {
+		// the postgres driver is registered by the pq skydb driver
+		db, err := sql.Open("postgres", config.DB.Option)
+		if err != nil {
+			logger.Fatalf("Failed to open app registry: %v", err)
+		}
+		loader = &tenant.RegistryLoader{
+			DB:    db,
+			Table: config.Tenant.RegistryTable,
+			Base:  config,
+		}
+	}
+
+	mux := &tenant.Mux{
+		Loader:   loader,
+		Interval: time.Duration(config.Tenant.ReloadInterval) * time.Second,
+
+		// the apps are not known to preflight requests without host
+		NotFound: &router.CORSMiddleware{
+			Origin: config.App.CORSHost,
+			Next:   http.HandlerFunc(tenant.AppNotFound),
+		},
 	}
+	mux.NewHandler = func(app tenant.App) (tenant.Handler, error) {
+		configReloader := &tenantAppReloader{
+			loader: loader,
+			name:   app.Config.App.Name,
+		}
+		server, err := initApp(app.Config, configReloader)
+		if err != nil {
+			return nil, err
+		}
+
+		// the loggers are shared among the apps
+		server.reloader.live = skyconfig.AppSettings
+		configReloader.app = server.reloader
+		return server, nil
+	}
+	mux.Reconfigure = func(handler tenant.Handler, app tenant.App) {
+		reloader := handler.(*appServer).reloader
+		report, err := reloader.Reload(app.Config)
+		if err != nil {
+			logger.WithField("err", err).Errorf("Failed to reload config of app %s", app.Config.App.Name)
+			return
+		}
+		logReloadReport(logger.WithField("app", app.Config.App.Name), report)
+	}
+
+	if err := mux.Reload(); err != nil {
+		logger.Fatalf("Failed to load apps: %v", err)
+	}
+	if config.Tenant.ReloadInterval > 0 {
+		go mux.Run()
+	}
+	return mux
 }
 
 func baseDBConfig(config skyconfig.Configuration) skydb.DBConfig {
@@ -415,10 +635,12 @@ func baseDBConfig(config skyconfig.Configuration) skydb.DBConfig {
 	return skydb.DBConfig{
 		CanMigrate:             config.App.DevMode,
 		PasswordHistoryEnabled: passwordHistoryEnabled,
+		ReplicaOptions:         config.DB.Replicas,
+		ReplicaMaxLag:          time.Duration(config.DB.ReplicaMaxLag) * time.Millisecond,
 	}
 }
 
-func ensureDB(config skyconfig.Configuration) func() (skydb.Conn, error) {
+func ensureDB(config skyconfig.Configuration) (func() (skydb.Conn, error), error) {
 	logger := logging.LoggerEntryWithTag("main", "skydb")
 	connOpener := func() (skydb.Conn, error) {
 		return skydb.Open(
@@ -438,13 +660,13 @@ func ensureDB(config skyconfig.Configuration) func() (skydb.Conn, error) {
 		conn, connError := connOpener()
 		if connError == nil {
 			conn.Close()
-			return connOpener
+			return connOpener, nil
 		}
 
 		attempt++
 		logger.Errorf("Failed to start skygear: %v", connError)
 		if attempt >= 5 {
-			logger.Fatalf("Failed to start skygear server because connection to database cannot be opened.")
+			return nil, fmt.Errorf("connection to database of app %s cannot be opened: %v", config.App.Name, connError)
 		}
 
 		logger.Info("Retrying in 1 second...")
@@ -452,6 +674,73 @@ func ensureDB(config skyconfig.Configuration) func() (skydb.Conn, error) {
 	}
 }
 
+// runSchemaCommand applies a schema file to the database. The plan is
+// printed before applying.
+//
+//	skygear-server schema apply [-dry-run] [-prune] schema.json
+func runSchemaCommand(config skyconfig.Configuration, args []string) int {
+	usage := "usage: skygear-server schema apply [-dry-run] [-prune] FILE"
+	if len(args) == 0 || args[0] != "apply" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("schema apply", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the plan without applying it")
+	prune := flags.Bool("prune", false, "delete fields and indexes not defined in the file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	file, err := schemafile.LoadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read schema file: %v\n", err)
+		return 1
+	}
+
+	dbConfig := baseDBConfig(config)
+	dbConfig.CanMigrate = true
+	conn, err := skydb.Open(
+		context.Background(),
+		config.DB.ImplName,
+		config.App.Name,
+		config.App.AccessControl,
+		config.DB.Option,
+		dbConfig,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	db := conn.PublicDB()
+	plan, err := schemafile.Diff(db, file, schemafile.Options{
+		Prune:          *prune,
+		AuthRecordKeys: config.App.AuthRecordKeys,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compute schema changes: %v\n", err)
+		return 1
+	}
+
+	fmt.Print(plan)
+	if *dryRun || plan.IsEmpty() {
+		return 0
+	}
+
+	if err := schemafile.Apply(db, plan); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to apply schema: %v\n", err)
+		return 1
+	}
+	fmt.Println("Schema applied.")
+	return 0
+}
+
 func initUserAuthRecordKeys(connOpener func() (skydb.Conn, error), authRecordKeys [][]string) {
 	logger := logging.LoggerEntryWithTag("main", "auth")
 	conn, err := connOpener()
@@ -512,6 +801,38 @@ func initAssetStore(config skyconfig.Configuration) asset.Store {
 	return store
 }
 
+// initDBOpener returns the DBOpener of request connections, which caches
+// query results if query cache is configured.
+func initDBOpener(config skyconfig.Configuration) skydb.DBOpener {
+	var store querycache.Store
+	switch config.QueryCache.ImplName {
+	case "":
+		return skydb.Open
+	case "memory":
+		store = querycache.NewMemoryStore()
+	case "redis":
+		store = querycache.NewRedisStore(config.QueryCache.Path, config.QueryCache.Prefix)
+	default:
+		panic("unrecgonized query cache implementation: " + config.QueryCache.ImplName)
+	}
+
+	cache := querycache.NewCache(store, time.Duration(config.QueryCache.Expiry)*time.Second)
+	return cache.Opener(skydb.Open)
+}
+
+func initAssetPolicies(config skyconfig.Configuration) asset.Policies {
+	policies := asset.Policies{}
+	for field, policy := range config.AssetStore.Policies {
+		policies[field] = asset.Policy{
+			ContentTypes: policy.ContentTypes,
+			MaxSize:      policy.MaxSize,
+			MaxWidth:     policy.MaxWidth,
+			MaxHeight:    policy.MaxHeight,
+		}
+	}
+	return policies
+}
+
 func initDevice(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) {
 	logger := logging.LoggerEntryWithTag("main", "device")
 	// TODO: Create a device service to check APNs to remove obsolete devices.
@@ -525,10 +846,16 @@ func initDevice(config skyconfig.Configuration, connOpener func() (skydb.Conn, e
 	conn.DeleteEmptyDevicesByTime(time.Now().AddDate(0, 0, -1))
 }
 
-func initPushSender(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) push.Sender {
+// initPushSender returns the sender of notifications of the enabled push
+// services, and the APNS pusher if APNS is enabled.
+func initPushSender(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) (push.Sender, push.APNSPusher, error) {
 	routeSender := push.NewRouteSender()
+	var apns push.APNSPusher
 	if config.APNS.Enable {
-		apns := initAPNSPusher(config, connOpener)
+		var err error
+		if apns, err = initAPNSPusher(config, connOpener); err != nil {
+			return nil, nil, err
+		}
 		routeSender.Route("aps", apns)
 		routeSender.Route("ios", apns)
 	}
@@ -541,37 +868,39 @@ func initPushSender(config skyconfig.Configuration, connOpener func() (skydb.Con
 		baidu := initBaiduPusher(config)
 		routeSender.Route("baidu-android", baidu)
 	}
-	return routeSender
+	return routeSender, apns, nil
 }
 
-func initAPNSPusher(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) push.APNSPusher {
-	logger := logging.LoggerEntryWithTag("main", "push")
+func initAPNSPusher(config skyconfig.Configuration, connOpener func() (skydb.Conn, error)) (push.APNSPusher, error) {
 	var pushSender push.APNSPusher
+	var err error
 
 	switch config.APNS.Type {
 	case "cert":
-		pushSender = initCertBasedAPNSPusher(config, connOpener)
+		pushSender, err = initCertBasedAPNSPusher(config, connOpener)
 	case "token":
-		pushSender = initTokenBasedAPNSPusher(config, connOpener)
+		pushSender, err = initTokenBasedAPNSPusher(config, connOpener)
 	default:
-		logger.Fatalf("Unknown APNS Type: %s", config.APNS.Type)
+		err = fmt.Errorf("Unknown APNS Type: %s", config.APNS.Type)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	go pushSender.Start()
-	return pushSender
+	return pushSender, nil
 }
 
 func initCertBasedAPNSPusher(
 	config skyconfig.Configuration,
 	connOpener func() (skydb.Conn, error),
-) push.APNSPusher {
-	logger := logging.LoggerEntryWithTag("main", "push")
+) (push.APNSPusher, error) {
 	cert := config.APNS.CertConfig.Cert
 	key := config.APNS.CertConfig.Key
 	if config.APNS.CertConfig.Cert == "" && config.APNS.CertConfig.CertPath != "" {
 		certPEMBlock, err := ioutil.ReadFile(config.APNS.CertConfig.CertPath)
 		if err != nil {
-			logger.Fatalf("Failed to load the APNS Cert: %v", err)
+			return nil, fmt.Errorf("Failed to load the APNS Cert: %v", err)
 		}
 		cert = string(certPEMBlock)
 	}
@@ -579,7 +908,7 @@ func initCertBasedAPNSPusher(
 	if config.APNS.CertConfig.Key == "" && config.APNS.CertConfig.KeyPath != "" {
 		keyPEMBlock, err := ioutil.ReadFile(config.APNS.CertConfig.KeyPath)
 		if err != nil {
-			logger.Fatalf("Failed to load the APNS Key: %v", err)
+			return nil, fmt.Errorf("Failed to load the APNS Key: %v", err)
 		}
 		key = string(keyPEMBlock)
 	}
@@ -591,23 +920,22 @@ func initCertBasedAPNSPusher(
 		key,
 	)
 	if err != nil {
-		logger.Fatalf("Failed to set up push sender: %v", err)
+		return nil, fmt.Errorf("Failed to set up push sender: %v", err)
 	}
 
-	return pushSender
+	return pushSender, nil
 }
 
 func initTokenBasedAPNSPusher(
 	config skyconfig.Configuration,
 	connOpener func() (skydb.Conn, error),
-) push.APNSPusher {
-	logger := logging.LoggerEntryWithTag("main", "push")
+) (push.APNSPusher, error) {
 	key := config.APNS.TokenConfig.Key
 	keyPath := config.APNS.TokenConfig.KeyPath
 	if key == "" && keyPath != "" {
 		keyBytes, err := ioutil.ReadFile(keyPath)
 		if err != nil {
-			logger.Fatalf("Failed to load APNS key: %v", err)
+			return nil, fmt.Errorf("Failed to load APNS key: %v", err)
 		}
 
 		key = string(keyBytes)
@@ -621,10 +949,10 @@ func initTokenBasedAPNSPusher(
 		key,
 	)
 	if err != nil {
-		logger.Fatalf("Failed to set up push sender: %v", err)
+		return nil, fmt.Errorf("Failed to set up push sender: %v", err)
 	}
 
-	return pushSender
+	return pushSender, nil
 }
 
 func initGCMPusher(config skyconfig.Configuration) *push.GCMPusher {
@@ -635,7 +963,23 @@ func initBaiduPusher(config skyconfig.Configuration) *push.BaiduPusher {
 	return push.NewBaiduPusher(config.Baidu.APIKey, config.Baidu.SecretKey)
 }
 
-func initSubscription(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), hub *pubsub.Hub, pushSender push.Sender) {
+func initPasswordChecker(config skyconfig.Configuration) *audit.PasswordChecker {
+	return &audit.PasswordChecker{
+		PwMinLength:            config.UserAudit.PwMinLength,
+		PwUppercaseRequired:    config.UserAudit.PwUppercaseRequired,
+		PwLowercaseRequired:    config.UserAudit.PwLowercaseRequired,
+		PwDigitRequired:        config.UserAudit.PwDigitRequired,
+		PwSymbolRequired:       config.UserAudit.PwSymbolRequired,
+		PwMinGuessableLevel:    config.UserAudit.PwMinGuessableLevel,
+		PwExcludedKeywords:     config.UserAudit.PwExcludedKeywords,
+		PwExcludedFields:       config.UserAudit.PwExcludedFields,
+		PwHistorySize:          config.UserAudit.PwHistorySize,
+		PwHistoryDays:          config.UserAudit.PwHistoryDays,
+		PasswordHistoryEnabled: baseDBConfig(config).PasswordHistoryEnabled,
+	}
+}
+
+func initSubscription(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), hub *pubsub.Hub, pushSender push.Sender) *subscription.Service {
 	logger := logging.LoggerEntryWithTag("main", "subscription")
 	notifiers := []subscription.Notifier{subscription.NewHubNotifier(hub)}
 	if pushSender != nil {
@@ -648,6 +992,50 @@ func initSubscription(config skyconfig.Configuration, connOpener func() (skydb.C
 	}
 	logger.Infoln("Subscription Service listening...")
 	go subscriptionService.Run()
+	return subscriptionService
+}
+
+func initPushScheduler(config skyconfig.Configuration, connOpener func() (skydb.Conn, error), pushSender push.Sender) *push.Scheduler {
+	logger := logging.LoggerEntryWithTag("main", "push")
+	scheduler := &push.Scheduler{
+		ConnOpener: connOpener,
+		Sender:     pushSender,
+		Interval:   time.Minute,
+	}
+	logger.Infoln("Push Scheduler running...")
+	go scheduler.Run()
+	return scheduler
+}
+
+func initAssetCollector(config skyconfig.Configuration, collector *assetgc.Collector) {
+	if config.AssetStore.GC.Interval <= 0 {
+		return
+	}
+
+	logger := logging.LoggerEntryWithTag("main", "asset")
+	if _, ok := collector.Store.(asset.FileDeleter); !ok {
+		logger.Warnln("Asset store does not support deleting files, asset GC is disabled")
+		return
+	}
+
+	logger.Infoln("Asset GC running...")
+	go collector.Run()
+}
+
+func initUploadReaper(reaper *assetgc.UploadReaper) {
+	logger := logging.LoggerEntryWithTag("main", "asset")
+	logger.Infoln("Upload reaper running...")
+	go reaper.Run()
+}
+
+func initTrashPurger(config skyconfig.Configuration, purger *trash.Purger) {
+	if config.DB.Trash.Interval <= 0 {
+		return
+	}
+
+	logger := logging.LoggerEntryWithTag("main", "trash")
+	logger.Infoln("Trash purger running...")
+	go purger.Run()
 }
 
 func initPlugin(config skyconfig.Configuration, ctx *plugin.Context) {
@@ -665,12 +1053,15 @@ func initPlugin(config skyconfig.Configuration, ctx *plugin.Context) {
 	ctx.InitPlugins()
 }
 
+// sentryHook is the sentry hook shared among all loggers, which is
+// created when the loggers are first configured.
+var sentryHook logrus.Hook
+
 func initLogger(config skyconfig.Configuration) {
 	mainLogger := logging.LoggerEntryWithTag("main", "") // untagged logger
 
 	// Create a sentry hook. This hook will be shared among all
 	// loggers.
-	var sentryHook logrus.Hook
 	if config.LogHook.SentryDSN != "" {
 		hook, err := newSentryHook(config)
 		if err != nil {
@@ -680,29 +1071,61 @@ func initLogger(config skyconfig.Configuration) {
 		sentryHook = hook
 	}
 
-	// Create a function for configuring logger, this will be used
-	// to configure the standard logger and configure all other loggers
-	// created via the `logging` package.
-	configure := func(name string, logger *logrus.Logger) {
+	// Register the function, and the update the standard logger and all
+	// loggers configured before.
+	configure := loggerConfigurer(config)
+	logging.SetConfigureLoggerHandler(configure)
+	configure("", logrus.StandardLogger())
+	for loggerName, logger := range logging.Loggers() {
+		configure(loggerName, logger)
+	}
+
+	// Configure audit handlers.
+	err := audit.InitTrailHandler(config.UserAudit.Enabled, config.UserAudit.TrailHandlerURL)
+	if err != nil {
+		mainLogger.Fatalf("user-audit: error when initializing trail handler %v", err)
+		return
+	}
+}
+
+// reloadLogger applies the levels and formatter of config to the
+// standard logger and all loggers configured before.
+func reloadLogger(config skyconfig.Configuration) {
+	configure := loggerConfigurer(config)
+	logging.SetConfigureLoggerHandler(configure)
+	configure("", logrus.StandardLogger())
+	for loggerName, logger := range logging.Loggers() {
+		configure(loggerName, logger)
+	}
+}
+
+// loggerConfigurer returns a function for configuring logger, this will
+// be used to configure the standard logger and configure all other
+// loggers created via the `logging` package. The loggers may be logging
+// while being configured again by reloadLogger.
+func loggerConfigurer(config skyconfig.Configuration) func(name string, logger *logrus.Logger) {
+	mainLogger := logging.LoggerEntryWithTag("main", "") // untagged logger
+
+	return func(name string, logger *logrus.Logger) {
 		// Set stderr
 		logger.Out = os.Stderr
 
 		// Set global log level
-		if level, err := logrus.ParseLevel(config.LOG.Level); err == nil {
-			logger.Level = level
-		} else {
+		level, err := logrus.ParseLevel(config.LOG.Level)
+		if err != nil {
 			logger.Warnf("log: error parsing config: %v", err)
 			logger.Warnln("log: fall back to `debug`")
-			logger.Level = logrus.DebugLevel
+			level = logrus.DebugLevel
 		}
 
 		// Set logger specific level
 		sanitized := strings.Replace(strings.ToLower(name), ".", "_", -1)
 		if loggerLevel, ok := config.LOG.LoggersLevel[sanitized]; ok {
-			if level, err := logrus.ParseLevel(loggerLevel); err == nil {
-				logger.Level = level
+			if l, err := logrus.ParseLevel(loggerLevel); err == nil {
+				level = l
 			}
 		}
+		logger.SetLevel(level)
 
 		// Set format
 		var formatter logrus.Formatter
@@ -716,26 +1139,22 @@ func initLogger(config skyconfig.Configuration) {
 		}
 		logger.Formatter = formatter
 
-		// Set sentry hook
-		if sentryHook != nil {
+		// Set sentry hook, which is added once
+		if sentryHook != nil && !hasHook(logger, sentryHook) {
 			logger.Hooks.Add(sentryHook)
 		}
 	}
+}
 
-	// Register the function, and the update the standard logger and all
-	// loggers configured before.
-	logging.SetConfigureLoggerHandler(configure)
-	configure("", logrus.StandardLogger())
-	for loggerName, logger := range logging.Loggers() {
-		configure(loggerName, logger)
-	}
-
-	// Configure audit handlers.
-	err := audit.InitTrailHandler(config.UserAudit.Enabled, config.UserAudit.TrailHandlerURL)
-	if err != nil {
-		mainLogger.Fatalf("user-audit: error when initializing trail handler %v", err)
-		return
+func hasHook(logger *logrus.Logger, hook logrus.Hook) bool {
+	for _, level := range hook.Levels() {
+		for _, h := range logger.Hooks[level] {
+			if h == hook {
+				return true
+			}
+		}
 	}
+	return false
 }
 
 func higherLogLevels(minLevel logrus.Level) []logrus.Level {
@@ -76,6 +76,13 @@ type FilePostRequestGenerator interface {
 	) (*PostFileRequest, error)
 }
 
+// FileDeleter defines the interface of a deleter for files
+type FileDeleter interface {
+	// DeleteFile deletes the named file and its cached transforms.
+	// Deleting a file that does not exist is not an error.
+	DeleteFile(name string) error
+}
+
 // Store specify the interfaces of an asset store
 type Store interface {
 	FileGetter
@@ -92,6 +99,12 @@ type URLSigner interface {
 	IsSignatureRequired() bool
 }
 
+// TransformedURLSigner signs a signature and returns a URL accessible to
+// the transformed image of an asset.
+type TransformedURLSigner interface {
+	SignedTransformedURL(name string, transform ImageTransform) (string, error)
+}
+
 // URLSignerStore is an interface that is a union of Store and URLSigner.
 //go:generate mockgen -destination=mock_asset/mock_url_signer_store.go github.com/skygeario/skygear-server/pkg/server/asset URLSignerStore
 type URLSignerStore interface {
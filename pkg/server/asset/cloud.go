@@ -22,6 +22,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -178,6 +179,62 @@ func (s cloudStore) PutFileReader(
 	)
 }
 
+// DeleteFile deletes a file and its cached transforms from the cloud
+// asset store
+func (s cloudStore) DeleteFile(name string) error {
+	if err := s.deleteAsset(name); err != nil {
+		return err
+	}
+
+	// the cached transforms are deleted with their directory, as in
+	// fileStore
+	return s.deleteAsset(path.Join(TransformedAssetDir, name))
+}
+
+func (s cloudStore) deleteAsset(name string) error {
+	urlString := strings.Join(
+		[]string{
+			s.host,
+			"asset",
+			url.PathEscape(s.appName),
+			url.PathEscape(name),
+		},
+		"/",
+	)
+
+	req := goreq.Request{
+		Method:  http.MethodDelete,
+		Uri:     urlString,
+		Timeout: 10 * time.Second,
+	}.WithHeader("Authorization", "Bearer "+s.authToken)
+
+	res, err := req.Do()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"url":   urlString,
+			"error": err,
+		}).Error("Fail to request to delete Cloud Asset")
+
+		return errors.New("Fail to request to delete Cloud Asset")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK &&
+		res.StatusCode != http.StatusNoContent &&
+		res.StatusCode != http.StatusNotFound {
+		body, _ := res.Body.ToString()
+		log.WithFields(logrus.Fields{
+			"url":    urlString,
+			"status": res.StatusCode,
+			"body":   body,
+		}).Error("Fail to request to delete Cloud Asset")
+
+		return errors.New("Fail to request to delete Cloud Asset")
+	}
+
+	return nil
+}
+
 // GeneratePostFileRequest return a PostFileRequest for uploading asset
 func (s cloudStore) GeneratePostFileRequest(name string, contentType string, length int64) (*PostFileRequest, error) {
 	log.
@@ -364,3 +364,45 @@ func TestCloudStoreGeneratePostFileRequest(t *testing.T) {
 		})
 	})
 }
+
+func TestCloudStoreDeleteFile(t *testing.T) {
+	Convey("Delete File", t, func() {
+		deletedPaths := []string{}
+		testServer := httptest.NewServer(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete ||
+					r.Header.Get("Authorization") != "Bearer correct-auth-token" {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("Bad Request"))
+					return
+				}
+
+				deletedPaths = append(deletedPaths, r.URL.EscapedPath())
+				w.WriteHeader(http.StatusNoContent)
+			}),
+		)
+		defer testServer.Close()
+
+		store := &cloudStore{
+			appName:   "testapp",
+			host:      testServer.URL,
+			authToken: "correct-auth-token",
+			urlPrefix: "http://localhost:12345/public",
+			public:    true,
+		}
+
+		Convey("deletes the file and its cached transforms", func() {
+			So(store.DeleteFile("file001"), ShouldBeNil)
+			So(deletedPaths, ShouldResemble, []string{
+				"/asset/testapp/file001",
+				"/asset/testapp/_transform%2Ffile001",
+			})
+		})
+
+		Convey("fails on error response", func() {
+			store.authToken = "wrong-auth-token"
+			So(store.DeleteFile("file001"), ShouldNotBeNil)
+			So(deletedPaths, ShouldBeEmpty)
+		})
+	})
+}
@@ -119,6 +119,16 @@ func (s *fileStore) PutFileReader(name string, src io.Reader, length int64, cont
 	return nil
 }
 
+// DeleteFile deletes a file and its cached transforms from file system
+func (s *fileStore) DeleteFile(name string) error {
+	path := filepath.Join(s.dir, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.RemoveAll(filepath.Join(s.dir, TransformedAssetDir, name))
+}
+
 // GeneratePostFileRequest return a PostFileRequest for uploading asset
 func (s *fileStore) GeneratePostFileRequest(name string, contentType string, length int64) (*PostFileRequest, error) {
 	return &PostFileRequest{
@@ -128,15 +138,26 @@ func (s *fileStore) GeneratePostFileRequest(name string, contentType string, len
 
 // SignedURL returns a signed url with expiry date
 func (s *fileStore) SignedURL(name string) (string, error) {
+	return s.SignedTransformedURL(name, ImageTransform{})
+}
+
+// SignedTransformedURL returns a signed url with expiry date to the
+// transformed image of the named asset
+func (s *fileStore) SignedTransformedURL(name string, transform ImageTransform) (string, error) {
+	url := fmt.Sprintf("%s/%s", s.prefix, name)
+	if !transform.IsZero() {
+		url = url + "?" + transform.String()
+	}
+
 	if !s.IsSignatureRequired() {
-		return fmt.Sprintf("%s/%s", s.prefix, name), nil
+		return url, nil
 	}
 
 	expiredAt := time.Now().Add(time.Minute * time.Duration(15))
 	expiredAtStr := strconv.FormatInt(expiredAt.Unix(), 10)
 
 	h := hmac.New(sha256.New, []byte(s.secret))
-	io.WriteString(h, name)
+	io.WriteString(h, transform.SignedName(name))
 	io.WriteString(h, expiredAtStr)
 
 	buf := bytes.Buffer{}
@@ -144,9 +165,13 @@ func (s *fileStore) SignedURL(name string) (string, error) {
 	base64Encoder.Write(h.Sum(nil))
 	base64Encoder.Close()
 
+	separator := "?"
+	if !transform.IsZero() {
+		separator = "&"
+	}
 	return fmt.Sprintf(
-		"%s/%s?expiredAt=%s&signature=%s",
-		s.prefix, name, expiredAtStr, buf.String(),
+		"%s%sexpiredAt=%s&signature=%s",
+		url, separator, expiredAtStr, buf.String(),
 	), nil
 }
 
@@ -1,8 +1,12 @@
 package asset
 
 import (
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,6 +40,40 @@ func TestFileStore(t *testing.T) {
 			So(valid, ShouldBeTrue)
 		})
 
+		Convey("Sign the transformed URL with transform parameters", func() {
+			transform := ImageTransform{Width: 100, Format: "png"}
+			s, err := fsStore.SignedTransformedURL("image.jpg", transform)
+			So(err, ShouldBeNil)
+			parsedURL, urlErr := url.Parse(s)
+			So(urlErr, ShouldBeNil)
+			qs := parsedURL.Query()
+			So(qs.Get("width"), ShouldEqual, "100")
+			So(qs.Get("format"), ShouldEqual, "png")
+
+			parsedTransform, parseErr := ParseImageTransform(qs)
+			So(parseErr, ShouldBeNil)
+			So(parsedTransform, ShouldResemble, transform)
+
+			expiredAtUnix, expiredErr := strconv.ParseInt(qs.Get("expiredAt"), 10, 64)
+			So(expiredErr, ShouldBeNil)
+			expiredAt := time.Unix(expiredAtUnix, 0)
+			valid, matchErr := fsStore.ParseSignature(
+				qs.Get("signature"),
+				parsedTransform.SignedName("image.jpg"),
+				expiredAt,
+			)
+			So(matchErr, ShouldBeNil)
+			So(valid, ShouldBeTrue)
+
+			valid, matchErr = fsStore.ParseSignature(
+				qs.Get("signature"),
+				"image.jpg",
+				expiredAt,
+			)
+			So(matchErr, ShouldBeNil)
+			So(valid, ShouldBeFalse)
+		})
+
 		Convey("Parse Signature correctly", func() {
 			expiredAt := time.Unix(1481096834, 0)
 			valid, matchErr := fsStore.ParseSignature(
@@ -58,5 +96,23 @@ func TestFileStore(t *testing.T) {
 			So(valid, ShouldBeFalse)
 		})
 
+		Convey("Delete file and its cached transforms", func() {
+			dir, err := ioutil.TempDir("", "skygear-asset-test")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			store := &fileStore{dir, "http://skygear.dev/files", "asset_secret", false}
+			cachedName := ImageTransform{Width: 100}.CachedName("image.png")
+			So(store.PutFileReader("image.png", strings.NewReader("image"), 5, "image/png"), ShouldBeNil)
+			So(store.PutFileReader(cachedName, strings.NewReader("thumb"), 5, "image/png"), ShouldBeNil)
+
+			So(store.DeleteFile("image.png"), ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, "image.png"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = os.Stat(filepath.Join(dir, cachedName))
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			So(store.DeleteFile("image.png"), ShouldBeNil)
+		})
 	})
 }
@@ -0,0 +1,160 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the number of bytes needed to detect the content type
+// of a file.
+const sniffLength = 512
+
+// Policy restricts the files that can be uploaded as an asset. A zero
+// value of a field means there is no restriction.
+type Policy struct {
+	// ContentTypes is the list of allowed content types. A content type
+	// may be a wildcard such as "image/*".
+	ContentTypes []string
+	MaxSize      int64
+	MaxWidth     int
+	MaxHeight    int
+}
+
+// PolicyViolation is returned when a file violates a Policy.
+type PolicyViolation struct {
+	Message string
+	Info    map[string]interface{}
+}
+
+func (e *PolicyViolation) Error() string {
+	return e.Message
+}
+
+// CheckMetadata checks the declared content type and size of a file
+// against the policy, without reading its content.
+func (p Policy) CheckMetadata(contentType string, size int64) error {
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return &PolicyViolation{
+			Message: fmt.Sprintf("File size %d exceeds the maximum size %d", size, p.MaxSize),
+			Info: map[string]interface{}{
+				"size":     size,
+				"max_size": p.MaxSize,
+			},
+		}
+	}
+
+	contentType = mediaType(contentType)
+	if !p.allowsContentType(contentType) {
+		return &PolicyViolation{
+			Message: fmt.Sprintf("Content type %s is not allowed", contentType),
+			Info: map[string]interface{}{
+				"content_type":  contentType,
+				"content_types": p.ContentTypes,
+			},
+		}
+	}
+
+	return nil
+}
+
+// Check checks the content of a file of the specified size against the
+// policy. The content type is detected from the content instead of
+// trusting the one declared by the uploader. The detected content type
+// is returned.
+func (p Policy) Check(src io.Reader, size int64) (string, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	head = head[:n]
+
+	contentType := mediaType(http.DetectContentType(head))
+	if err := p.CheckMetadata(contentType, size); err != nil {
+		return contentType, err
+	}
+
+	if (p.MaxWidth > 0 || p.MaxHeight > 0) && strings.HasPrefix(contentType, "image/") {
+		config, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), src))
+		if err != nil {
+			return contentType, &PolicyViolation{
+				Message: "Unable to decode the image",
+				Info: map[string]interface{}{
+					"content_type": contentType,
+				},
+			}
+		}
+
+		if (p.MaxWidth > 0 && config.Width > p.MaxWidth) ||
+			(p.MaxHeight > 0 && config.Height > p.MaxHeight) {
+			return contentType, &PolicyViolation{
+				Message: fmt.Sprintf(
+					"Image dimension %dx%d exceeds the maximum dimension %dx%d",
+					config.Width, config.Height, p.MaxWidth, p.MaxHeight,
+				),
+				Info: map[string]interface{}{
+					"width":      config.Width,
+					"height":     config.Height,
+					"max_width":  p.MaxWidth,
+					"max_height": p.MaxHeight,
+				},
+			}
+		}
+	}
+
+	return contentType, nil
+}
+
+func (p Policy) allowsContentType(contentType string) bool {
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.ContentTypes {
+		allowed = mediaType(allowed)
+		if allowed == contentType || allowed == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") &&
+			strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the content type without parameters.
+func mediaType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Policies maps a record field to the policy of assets saved to the
+// field. The key is in the form of "<record type>.<field name>".
+type Policies map[string]Policy
+
+// Get returns the policy of assets saved to the specified record field.
+func (p Policies) Get(recordType string, field string) (Policy, bool) {
+	policy, ok := p[recordType+"."+field]
+	return policy, ok
+}
@@ -0,0 +1,102 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	Convey("Policy", t, func() {
+		pngData := func(width, height int) []byte {
+			buf := bytes.Buffer{}
+			png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+			return buf.Bytes()
+		}
+
+		Convey("checks metadata", func() {
+			policy := Policy{
+				ContentTypes: []string{"image/*", "application/pdf"},
+				MaxSize:      100,
+			}
+
+			So(policy.CheckMetadata("image/png", 100), ShouldBeNil)
+			So(policy.CheckMetadata("application/pdf; charset=binary", 10), ShouldBeNil)
+
+			err := policy.CheckMetadata("text/plain", 10)
+			So(err, ShouldHaveSameTypeAs, &PolicyViolation{})
+			So(err.Error(), ShouldEqual, "Content type text/plain is not allowed")
+
+			err = policy.CheckMetadata("image/png", 101)
+			So(err, ShouldHaveSameTypeAs, &PolicyViolation{})
+			So(err.(*PolicyViolation).Info, ShouldResemble, map[string]interface{}{
+				"size":     int64(101),
+				"max_size": int64(100),
+			})
+		})
+
+		Convey("allows anything without restriction", func() {
+			policy := Policy{}
+			contentType, err := policy.Check(strings.NewReader("hello"), 5)
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "text/plain")
+		})
+
+		Convey("detects content type from content", func() {
+			policy := Policy{ContentTypes: []string{"image/png"}}
+
+			data := pngData(1, 1)
+			contentType, err := policy.Check(bytes.NewReader(data), int64(len(data)))
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "image/png")
+
+			contentType, err = policy.Check(strings.NewReader("<html></html>"), 13)
+			So(err, ShouldHaveSameTypeAs, &PolicyViolation{})
+			So(contentType, ShouldEqual, "text/html")
+		})
+
+		Convey("checks image dimension", func() {
+			policy := Policy{MaxWidth: 20, MaxHeight: 10}
+
+			data := pngData(20, 10)
+			_, err := policy.Check(bytes.NewReader(data), int64(len(data)))
+			So(err, ShouldBeNil)
+
+			data = pngData(10, 11)
+			_, err = policy.Check(bytes.NewReader(data), int64(len(data)))
+			So(err, ShouldHaveSameTypeAs, &PolicyViolation{})
+			So(err.Error(), ShouldEqual, "Image dimension 10x11 exceeds the maximum dimension 20x10")
+		})
+
+		Convey("gets policy of a record field", func() {
+			policies := Policies{
+				"note.image": Policy{MaxSize: 100},
+			}
+
+			policy, ok := policies.Get("note", "image")
+			So(ok, ShouldBeTrue)
+			So(policy.MaxSize, ShouldEqual, 100)
+
+			_, ok = policies.Get("note", "attachment")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -133,6 +134,110 @@ func (s *s3Store) PutFileReader(
 	return err
 }
 
+// DeleteFile deletes a file and its cached transforms from s3
+func (s *s3Store) DeleteFile(name string) error {
+	keys := []string{name}
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: s.bucket,
+		Prefix: aws.String(path.Join(TransformedAssetDir, name) + "/"),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range output.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		// s3 does not return error for deleting a non-existent object
+		if _, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: s.bucket,
+			Key:    aws.String(key),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3MinPartSize is the minimum size of a part in s3 multipart upload,
+// except the last part
+const s3MinPartSize = 5 * 1024 * 1024
+
+// CreateMultipartUpload initiates a multipart upload of a file to s3
+func (s *s3Store) CreateMultipartUpload(name string, contentType string) (string, error) {
+	output, err := s.svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      s.bucket,
+		Key:         aws.String(name),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *output.UploadId, nil
+}
+
+// UploadPart uploads a part of a file in a multipart upload
+func (s *s3Store) UploadPart(
+	name string,
+	uploadID string,
+	number int64,
+	src io.ReadSeeker,
+	length int64,
+) (string, error) {
+	output, err := s.svc.UploadPart(&s3.UploadPartInput{
+		Body:          src,
+		Bucket:        s.bucket,
+		ContentLength: aws.Int64(length),
+		Key:           aws.String(name),
+		PartNumber:    aws.Int64(number),
+		UploadId:      aws.String(uploadID),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *output.ETag, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into a file
+func (s *s3Store) CompleteMultipartUpload(name string, uploadID string, parts []UploadedPart) error {
+	completedParts := make([]*s3.CompletedPart, len(parts))
+	for i, part := range parts {
+		completedParts[i] = &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		}
+	}
+
+	_, err := s.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket: s.bucket,
+		Key:    aws.String(name),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// AbortMultipartUpload aborts a multipart upload and discards the
+// uploaded parts
+func (s *s3Store) AbortMultipartUpload(name string, uploadID string) error {
+	_, err := s.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   s.bucket,
+		Key:      aws.String(name),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// MinPartSize returns the minimum size of a part in s3 multipart upload
+func (s *s3Store) MinPartSize() int64 {
+	return s3MinPartSize
+}
+
 // GeneratePostFileRequest return a PostFileRequest for uploading asset
 func (s *s3Store) GeneratePostFileRequest(name string, contentType string, length int64) (*PostFileRequest, error) {
 	return &PostFileRequest{
@@ -0,0 +1,367 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+
+	// register decoder of GIF images
+	_ "image/gif"
+)
+
+// TransformedAssetDir is the directory in an asset store where transformed
+// images are cached.
+const TransformedAssetDir = "_transform"
+
+// MaxImageTransformDimension is the maximum width or height of an image
+// produced by ImageTransform.
+const MaxImageTransformDimension = 4096
+
+// MaxImageTransformSourcePixels is the maximum number of pixels of an
+// image accepted by TransformImage. The whole source image is decoded in
+// memory, so a small file declaring a huge dimension would otherwise
+// exhaust the memory of the server.
+const MaxImageTransformSourcePixels = 8192 * 8192
+
+// ImageFit specifies how an image is fitted into the requested dimension.
+type ImageFit string
+
+// See the definition of ImageFit
+const (
+	// ImageFitContain scales the image to fit within the dimension,
+	// preserving the aspect ratio.
+	ImageFitContain ImageFit = "contain"
+	// ImageFitCover scales the image to cover the dimension, preserving
+	// the aspect ratio. Overflowing parts are cropped at center.
+	ImageFitCover ImageFit = "cover"
+	// ImageFitFill stretches the image to the dimension.
+	ImageFitFill ImageFit = "fill"
+)
+
+// ImageTransform specifies the transformation applied to an image asset.
+type ImageTransform struct {
+	Width   int
+	Height  int
+	Fit     ImageFit
+	Format  string
+	Quality int
+}
+
+// ParseImageTransform parses an ImageTransform from query parameters
+// `width`, `height`, `fit`, `format` and `quality`.
+func ParseImageTransform(values url.Values) (ImageTransform, error) {
+	t := ImageTransform{}
+
+	parseInt := func(key string, max int) (int, error) {
+		s := values.Get(key)
+		if s == "" {
+			return 0, nil
+		}
+		i, err := strconv.Atoi(s)
+		if err != nil || i <= 0 || i > max {
+			return 0, fmt.Errorf("expect %s to be an integer between 1 and %d", key, max)
+		}
+		return i, nil
+	}
+
+	var err error
+	if t.Width, err = parseInt("width", MaxImageTransformDimension); err != nil {
+		return ImageTransform{}, err
+	}
+	if t.Height, err = parseInt("height", MaxImageTransformDimension); err != nil {
+		return ImageTransform{}, err
+	}
+	if t.Quality, err = parseInt("quality", 100); err != nil {
+		return ImageTransform{}, err
+	}
+
+	t.Fit = ImageFit(values.Get("fit"))
+	switch t.Fit {
+	case "", ImageFitContain, ImageFitCover, ImageFitFill:
+	default:
+		return ImageTransform{}, fmt.Errorf("unknown fit = %s", t.Fit)
+	}
+
+	t.Format = values.Get("format")
+	if t.Format == "jpg" {
+		t.Format = "jpeg"
+	}
+	if t.Format != "" {
+		if _, ok := getImageEncoder(t.Format); !ok {
+			return ImageTransform{}, fmt.Errorf("unsupported format = %s", t.Format)
+		}
+	}
+
+	return t, nil
+}
+
+// IsZero returns whether the transform leaves the image unchanged.
+func (t ImageTransform) IsZero() bool {
+	return t == ImageTransform{}
+}
+
+// Query returns the transform as query parameters.
+func (t ImageTransform) Query() url.Values {
+	values := url.Values{}
+	if t.Width > 0 {
+		values.Set("width", strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		values.Set("height", strconv.Itoa(t.Height))
+	}
+	if t.Fit != "" {
+		values.Set("fit", string(t.Fit))
+	}
+	if t.Format != "" {
+		values.Set("format", t.Format)
+	}
+	if t.Quality > 0 {
+		values.Set("quality", strconv.Itoa(t.Quality))
+	}
+	return values
+}
+
+// String returns the canonical form of the transform.
+func (t ImageTransform) String() string {
+	return t.Query().Encode()
+}
+
+// SignedName returns the name to be signed for accessing the transformed
+// image of the named asset, so that a signature for an asset is not
+// valid for other transforms of the same asset.
+func (t ImageTransform) SignedName(name string) string {
+	if t.IsZero() {
+		return name
+	}
+	return name + "?" + t.String()
+}
+
+// CachedName returns the name of the transformed image of the named asset
+// in an asset store.
+func (t ImageTransform) CachedName(name string) string {
+	sum := sha256.Sum256([]byte(t.String()))
+	return path.Join(TransformedAssetDir, name, hex.EncodeToString(sum[:16]))
+}
+
+// ImageEncoder encodes an image of a specific format.
+type ImageEncoder struct {
+	ContentType string
+	Encode      func(w io.Writer, m image.Image, quality int) error
+}
+
+var (
+	imageEncodersMutex sync.RWMutex
+	imageEncoders      = map[string]ImageEncoder{}
+)
+
+// RegisterImageEncoder registers an encoder for the named image format.
+// Formats other than jpeg, png and webp can be supported by registering
+// an encoder.
+func RegisterImageEncoder(format string, encoder ImageEncoder) {
+	imageEncodersMutex.Lock()
+	defer imageEncodersMutex.Unlock()
+	imageEncoders[format] = encoder
+}
+
+func getImageEncoder(format string) (ImageEncoder, bool) {
+	imageEncodersMutex.RLock()
+	defer imageEncodersMutex.RUnlock()
+	encoder, ok := imageEncoders[format]
+	return encoder, ok
+}
+
+func init() {
+	RegisterImageEncoder("jpeg", ImageEncoder{
+		ContentType: "image/jpeg",
+		Encode: func(w io.Writer, m image.Image, quality int) error {
+			if quality <= 0 {
+				quality = jpeg.DefaultQuality
+			}
+			return jpeg.Encode(w, m, &jpeg.Options{Quality: quality})
+		},
+	})
+	RegisterImageEncoder("png", ImageEncoder{
+		ContentType: "image/png",
+		Encode: func(w io.Writer, m image.Image, quality int) error {
+			return png.Encode(w, m)
+		},
+	})
+	// webp is encoded losslessly, so the quality is ignored
+	RegisterImageEncoder("webp", ImageEncoder{
+		ContentType: "image/webp",
+		Encode: func(w io.Writer, m image.Image, quality int) error {
+			return encodeWebP(w, m)
+		},
+	})
+}
+
+// ErrImageFormatNotSupported is returned by TransformImage if the source
+// is not an image of a supported format.
+var ErrImageFormatNotSupported = errors.New("image format is not supported")
+
+// ErrImageTooLarge is returned by TransformImage if the source image has
+// more than MaxImageTransformSourcePixels pixels, or the transformed image
+// is too large to be encoded in the requested format.
+var ErrImageTooLarge = errors.New("image is too large to be transformed")
+
+// TransformImage decodes the image read from src, applies the transform
+// and returns the encoded image and its content type.
+//
+// Source formats are those registered with image.RegisterFormat. If
+// Format of the transform is empty, the image is encoded in its source
+// format, or png if the source format has no registered encoder.
+//
+// The dimension of the source image is checked before the image is
+// decoded, and an image with more than MaxImageTransformSourcePixels
+// pixels is rejected with ErrImageTooLarge.
+func TransformImage(src io.Reader, t ImageTransform) ([]byte, string, error) {
+	// the header read by DecodeConfig is replayed to Decode
+	header := bytes.Buffer{}
+	config, _, err := image.DecodeConfig(io.TeeReader(src, &header))
+	if err != nil {
+		return nil, "", ErrImageFormatNotSupported
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImageTransformSourcePixels {
+		return nil, "", ErrImageTooLarge
+	}
+
+	m, sourceFormat, err := image.Decode(io.MultiReader(&header, src))
+	if err != nil {
+		return nil, "", ErrImageFormatNotSupported
+	}
+
+	format := t.Format
+	if format == "" {
+		format = sourceFormat
+	}
+	encoder, ok := getImageEncoder(format)
+	if !ok {
+		if t.Format != "" {
+			return nil, "", fmt.Errorf("unsupported format = %s", t.Format)
+		}
+		encoder, _ = getImageEncoder("png")
+	}
+
+	m = resizeImage(m, t)
+
+	buf := bytes.Buffer{}
+	if err := encoder.Encode(&buf, m, t.Quality); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), encoder.ContentType, nil
+}
+
+// resizeImage resizes the image according to the width, height and fit of
+// the transform.
+func resizeImage(m image.Image, t ImageTransform) image.Image {
+	bounds := m.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 || (t.Width == 0 && t.Height == 0) {
+		return m
+	}
+
+	dstW, dstH := t.Width, t.Height
+	if dstW == 0 {
+		dstW = maxInt(1, srcW*dstH/srcH)
+	} else if dstH == 0 {
+		dstH = maxInt(1, srcH*dstW/srcW)
+	}
+
+	// the source rectangle to be scaled into the destination
+	crop := bounds
+	switch t.Fit {
+	case ImageFitFill:
+	case ImageFitCover:
+		if srcW*dstH > srcH*dstW {
+			w := srcH * dstW / dstH
+			crop.Min.X += (srcW - w) / 2
+			crop.Max.X = crop.Min.X + w
+		} else {
+			h := srcW * dstH / dstW
+			crop.Min.Y += (srcH - h) / 2
+			crop.Max.Y = crop.Min.Y + h
+		}
+	default:
+		if srcW*dstH > srcH*dstW {
+			dstH = maxInt(1, srcH*dstW/srcW)
+		} else {
+			dstW = maxInt(1, srcW*dstH/srcH)
+		}
+	}
+
+	return scaleImage(m, crop, dstW, dstH)
+}
+
+// scaleImage scales the src rectangle of the image to the specified
+// dimension by averaging the source pixels covered by each destination
+// pixel.
+func scaleImage(m image.Image, src image.Rectangle, dstW, dstH int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+	srcW, srcH := src.Dx(), src.Dy()
+
+	for y := 0; y < dstH; y++ {
+		y0 := src.Min.Y + y*srcH/dstH
+		y1 := src.Min.Y + (y+1)*srcH/dstH
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dstW; x++ {
+			x0 := src.Min.X + x*srcW/dstW
+			x1 := src.Min.X + (x+1)*srcW/dstW
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(m.At(sx, sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					b += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,192 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestPNG(width, height int) []byte {
+	m := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	png.Encode(&buf, m)
+	return buf.Bytes()
+}
+
+func TestParseImageTransform(t *testing.T) {
+	Convey("ParseImageTransform", t, func() {
+		Convey("parses all parameters", func() {
+			transform, err := ParseImageTransform(url.Values{
+				"width":   []string{"100"},
+				"height":  []string{"50"},
+				"fit":     []string{"cover"},
+				"format":  []string{"jpg"},
+				"quality": []string{"80"},
+			})
+			So(err, ShouldBeNil)
+			So(transform, ShouldResemble, ImageTransform{
+				Width:   100,
+				Height:  50,
+				Fit:     ImageFitCover,
+				Format:  "jpeg",
+				Quality: 80,
+			})
+			So(transform.String(), ShouldEqual, "fit=cover&format=jpeg&height=50&quality=80&width=100")
+		})
+
+		Convey("parses empty parameters", func() {
+			transform, err := ParseImageTransform(url.Values{
+				"expiredAt": []string{"1436431130"},
+			})
+			So(err, ShouldBeNil)
+			So(transform.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("rejects invalid dimension", func() {
+			_, err := ParseImageTransform(url.Values{"width": []string{"-1"}})
+			So(err, ShouldNotBeNil)
+
+			_, err = ParseImageTransform(url.Values{"height": []string{"10000"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects unknown fit", func() {
+			_, err := ParseImageTransform(url.Values{"fit": []string{"stretch"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects format without encoder", func() {
+			_, err := ParseImageTransform(url.Values{"format": []string{"bmp"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestImageTransformNames(t *testing.T) {
+	Convey("ImageTransform names", t, func() {
+		transform := ImageTransform{Width: 100}
+
+		Convey("signs the name with transform", func() {
+			So(transform.SignedName("image.png"), ShouldEqual, "image.png?width=100")
+			So(ImageTransform{}.SignedName("image.png"), ShouldEqual, "image.png")
+		})
+
+		Convey("caches different transforms in different names", func() {
+			name := transform.CachedName("image.png")
+			So(strings.HasPrefix(name, "_transform/image.png/"), ShouldBeTrue)
+			So(ImageTransform{Width: 200}.CachedName("image.png"), ShouldNotEqual, name)
+		})
+	})
+}
+
+func TestTransformImage(t *testing.T) {
+	Convey("TransformImage", t, func() {
+		src := newTestPNG(200, 100)
+
+		decode := func(data []byte) image.Image {
+			m, _, err := image.Decode(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			return m
+		}
+
+		Convey("resizes to fit within dimension", func() {
+			data, contentType, err := TransformImage(bytes.NewReader(src), ImageTransform{
+				Width:  50,
+				Height: 50,
+			})
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "image/png")
+			So(decode(data).Bounds(), ShouldResemble, image.Rect(0, 0, 50, 25))
+		})
+
+		Convey("resizes with only width", func() {
+			data, _, err := TransformImage(bytes.NewReader(src), ImageTransform{
+				Width: 100,
+			})
+			So(err, ShouldBeNil)
+			So(decode(data).Bounds(), ShouldResemble, image.Rect(0, 0, 100, 50))
+		})
+
+		Convey("resizes to cover dimension", func() {
+			data, _, err := TransformImage(bytes.NewReader(src), ImageTransform{
+				Width:  50,
+				Height: 50,
+				Fit:    ImageFitCover,
+			})
+			So(err, ShouldBeNil)
+			m := decode(data)
+			So(m.Bounds(), ShouldResemble, image.Rect(0, 0, 50, 50))
+			r, g, b, a := m.At(25, 25).RGBA()
+			So([]uint32{r >> 8, g >> 8, b >> 8, a >> 8}, ShouldResemble, []uint32{255, 0, 0, 255})
+		})
+
+		Convey("resizes to fill dimension", func() {
+			data, _, err := TransformImage(bytes.NewReader(src), ImageTransform{
+				Width:  30,
+				Height: 40,
+				Fit:    ImageFitFill,
+			})
+			So(err, ShouldBeNil)
+			So(decode(data).Bounds(), ShouldResemble, image.Rect(0, 0, 30, 40))
+		})
+
+		Convey("converts format", func() {
+			data, contentType, err := TransformImage(bytes.NewReader(src), ImageTransform{
+				Format:  "jpeg",
+				Quality: 50,
+			})
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "image/jpeg")
+			_, err = jpeg.Decode(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("rejects non-image", func() {
+			_, _, err := TransformImage(strings.NewReader("I am a boy"), ImageTransform{
+				Width: 100,
+			})
+			So(err, ShouldEqual, ErrImageFormatNotSupported)
+		})
+
+		Convey("rejects image with too many pixels before decoding", func() {
+			// declare a huge dimension in the IHDR chunk of the png
+			huge := append([]byte{}, src...)
+			binary.BigEndian.PutUint32(huge[16:20], 100000)
+			binary.BigEndian.PutUint32(huge[20:24], 100000)
+			binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+
+			_, _, err := TransformImage(bytes.NewReader(huge), ImageTransform{
+				Width: 100,
+			})
+			So(err, ShouldEqual, ErrImageTooLarge)
+		})
+	})
+}
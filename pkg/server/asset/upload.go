@@ -0,0 +1,432 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrChunkOffsetMismatch is returned when the offset of a chunk is not the
+// current offset of the upload.
+var ErrChunkOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+
+// ErrChunkUploadIncomplete is returned when completing an upload before all
+// content is uploaded.
+var ErrChunkUploadIncomplete = errors.New("chunk upload is incomplete")
+
+// StagedUploadDir is the directory in an asset store where chunks of
+// uploads are staged before being assembled.
+const StagedUploadDir = "_upload"
+
+// UploadedPart is a part of a file uploaded to a MultipartUploader, or
+// a chunk staged in the store if Staged is true.
+type UploadedPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	Staged bool   `json:"staged,omitempty"`
+}
+
+// MultipartUploader defines the interface of a store supporting assembling
+// a file from parts uploaded separately.
+type MultipartUploader interface {
+	CreateMultipartUpload(name string, contentType string) (uploadID string, err error)
+	UploadPart(
+		name string,
+		uploadID string,
+		number int64,
+		src io.ReadSeeker,
+		length int64,
+	) (etag string, err error)
+	CompleteMultipartUpload(name string, uploadID string, parts []UploadedPart) error
+	AbortMultipartUpload(name string, uploadID string) error
+	// MinPartSize returns the minimum size of all parts except the last.
+	MinPartSize() int64
+}
+
+// ChunkUpload is the state of a file being assembled from chunks uploaded
+// in sequence.
+type ChunkUpload struct {
+	ID          string
+	Name        string
+	ContentType string
+	Length      int64
+	Offset      int64
+
+	// MultipartID is the ID of the multipart upload if the store is
+	// a MultipartUploader.
+	MultipartID string
+
+	// Parts are the parts uploaded to the multipart upload, followed by
+	// the chunks staged in the store which are not yet uploaded as
+	// a part.
+	Parts []UploadedPart
+}
+
+// IsComplete returns whether all content of the file is uploaded.
+func (u *ChunkUpload) IsComplete() bool {
+	return u.Offset >= u.Length
+}
+
+func (u *ChunkUpload) uploadedParts() []UploadedPart {
+	parts := []UploadedPart{}
+	for _, part := range u.Parts {
+		if !part.Staged {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func (u *ChunkUpload) stagedChunks() []UploadedPart {
+	chunks := []UploadedPart{}
+	for _, part := range u.Parts {
+		if part.Staged {
+			chunks = append(chunks, part)
+		}
+	}
+	return chunks
+}
+
+func (u *ChunkUpload) stagedSize() int64 {
+	var size int64
+	for _, chunk := range u.stagedChunks() {
+		size += chunk.Size
+	}
+	return size
+}
+
+// stagedChunkName returns the name of the chunk staged in the store,
+// which starts at the offset of the upload. A chunk staged by a write
+// interrupted before the offset was recorded is overwritten by the next
+// chunk written at the same offset.
+func stagedChunkName(u *ChunkUpload, offset int64) string {
+	return path.Join(StagedUploadDir, u.ID, strconv.FormatInt(offset, 10))
+}
+
+// ChunkAssembler assembles files uploaded in chunks into a Store.
+//
+// Chunks are staged in the store, so that an upload can be resumed on
+// any server sharing the store and the database of upload sessions. If
+// the store is a MultipartUploader, staged content is uploaded as a part
+// once it reaches the minimum part size. Otherwise, the staged chunks are
+// put to the store as one file when the upload completes.
+//
+// The local StagingDir only holds the content of a request being
+// processed, such as a chunk received by StageChunk, or a part being
+// uploaded.
+//
+// ChunkAssembler does not serialize writes to the same upload. The caller
+// is expected to lock the upload, such as by locking the upload session
+// in the database, before calling WriteChunk, Complete or Abort. A chunk
+// can be received with StageChunk before the upload is locked, so that
+// the lock is not held while waiting for the client.
+type ChunkAssembler struct {
+	Store      Store
+	StagingDir string
+}
+
+// NewChunkAssembler returns a new ChunkAssembler. If stagingDir is empty,
+// requests are staged in a directory under the system temporary directory.
+func NewChunkAssembler(store Store, stagingDir string) *ChunkAssembler {
+	if stagingDir == "" {
+		stagingDir = filepath.Join(os.TempDir(), "skygear-upload")
+	}
+	return &ChunkAssembler{
+		Store:      store,
+		StagingDir: stagingDir,
+	}
+}
+
+// Begin prepares the store for the upload.
+func (a *ChunkAssembler) Begin(u *ChunkUpload) error {
+	if uploader, ok := a.Store.(MultipartUploader); ok {
+		multipartID, err := uploader.CreateMultipartUpload(u.Name, u.ContentType)
+		if err != nil {
+			return err
+		}
+		u.MultipartID = multipartID
+	}
+	return nil
+}
+
+// StagedChunk is a chunk of an upload received by StageChunk. It is read
+// by WriteChunk, and is removed when closed.
+type StagedChunk struct {
+	file *os.File
+
+	// Size is the number of bytes received.
+	Size int64
+
+	// Err is the error occurred when receiving the chunk. The content
+	// received before the error is still staged, so that the upload can
+	// be resumed from there.
+	Err error
+}
+
+func (c *StagedChunk) Read(p []byte) (int, error) {
+	return c.file.Read(p)
+}
+
+// Close removes the staged chunk.
+func (c *StagedChunk) Close() error {
+	c.file.Close()
+	return os.Remove(c.file.Name())
+}
+
+func (c *StagedChunk) rewind() error {
+	_, err := c.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// StageChunk receives a chunk of the upload from src into the staging
+// directory. Content exceeding the length of the upload is discarded.
+func (a *ChunkAssembler) StageChunk(u *ChunkUpload, src io.Reader) (*StagedChunk, error) {
+	if err := os.MkdirAll(a.StagingDir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(a.StagingDir, u.ID+"-chunk-")
+	if err != nil {
+		return nil, err
+	}
+
+	size, copyErr := io.Copy(f, io.LimitReader(src, u.Length-u.Offset))
+	chunk := &StagedChunk{
+		file: f,
+		Size: size,
+		Err:  copyErr,
+	}
+	if err := chunk.rewind(); err != nil {
+		chunk.Close()
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// WriteChunk writes a chunk starting from the specified offset, which must
+// be the current offset of the upload. The offset of the upload is
+// advanced by the bytes written, even if an error occurred when reading
+// from src, so that the upload can be resumed from there.
+func (a *ChunkAssembler) WriteChunk(u *ChunkUpload, offset int64, src io.Reader) (int64, error) {
+	if offset != u.Offset {
+		return 0, ErrChunkOffsetMismatch
+	}
+
+	chunk, ok := src.(*StagedChunk)
+	var readErr error
+	if ok {
+		if err := chunk.rewind(); err != nil {
+			return 0, err
+		}
+	} else {
+		var err error
+		if chunk, err = a.StageChunk(u, src); err != nil {
+			return 0, err
+		}
+		defer chunk.Close()
+		readErr = chunk.Err
+	}
+
+	size := chunk.Size
+	if size > u.Length-u.Offset {
+		size = u.Length - u.Offset
+	}
+	if size == 0 {
+		return 0, readErr
+	}
+
+	uploader, ok := a.Store.(MultipartUploader)
+	if ok && u.stagedSize()+size >= uploader.MinPartSize() {
+		if err := a.uploadPart(uploader, u, io.LimitReader(chunk, size)); err != nil {
+			return 0, err
+		}
+	} else {
+		if err := a.Store.PutFileReader(
+			stagedChunkName(u, u.Offset),
+			io.LimitReader(chunk, size),
+			size,
+			"application/octet-stream",
+		); err != nil {
+			return 0, err
+		}
+		u.Parts = append(u.Parts, UploadedPart{
+			Size:   size,
+			Staged: true,
+		})
+	}
+
+	u.Offset += size
+	return size, readErr
+}
+
+// readStagedChunks returns a reader of the content of the chunks staged
+// in the store.
+func (a *ChunkAssembler) readStagedChunks(u *ChunkUpload) (io.ReadCloser, error) {
+	readers := []io.Reader{}
+	closers := multiCloser{}
+	offset := u.Offset - u.stagedSize()
+	for _, chunk := range u.stagedChunks() {
+		r, err := a.Store.GetFileReader(stagedChunkName(u, offset))
+		if err != nil {
+			closers.Close()
+			return nil, err
+		}
+		readers = append(readers, io.LimitReader(r, chunk.Size))
+		closers = append(closers, r)
+		offset += chunk.Size
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), closers}, nil
+}
+
+// deleteStagedChunks deletes the chunks staged in the store.
+func (a *ChunkAssembler) deleteStagedChunks(u *ChunkUpload) error {
+	chunks := u.stagedChunks()
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	deleter, ok := a.Store.(FileDeleter)
+	if !ok {
+		return errors.New("asset store does not support deleting staged chunks")
+	}
+
+	offset := u.Offset - u.stagedSize()
+	for _, chunk := range chunks {
+		if err := deleter.DeleteFile(stagedChunkName(u, offset)); err != nil {
+			return err
+		}
+		offset += chunk.Size
+	}
+	return nil
+}
+
+// uploadPart uploads the staged chunks followed by the content of src as
+// a part. The part is assembled in the local staging directory because
+// the uploader requires a seekable source.
+func (a *ChunkAssembler) uploadPart(uploader MultipartUploader, u *ChunkUpload, src io.Reader) error {
+	staged, err := a.readStagedChunks(u)
+	if err != nil {
+		return err
+	}
+	defer staged.Close()
+
+	if err := os.MkdirAll(a.StagingDir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(a.StagingDir, u.ID+"-part-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, io.MultiReader(staged, src))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	parts := u.uploadedParts()
+	number := int64(len(parts) + 1)
+	etag, err := uploader.UploadPart(u.Name, u.MultipartID, number, f, size)
+	if err != nil {
+		return err
+	}
+
+	if err := a.deleteStagedChunks(u); err != nil {
+		log.WithError(err).Warnf("Failed to delete staged chunks of upload %s", u.ID)
+	}
+	u.Parts = append(parts, UploadedPart{
+		Number: number,
+		ETag:   etag,
+		Size:   size,
+	})
+	return nil
+}
+
+// Complete assembles the uploaded chunks into a file in the store.
+func (a *ChunkAssembler) Complete(u *ChunkUpload) error {
+	if !u.IsComplete() {
+		return ErrChunkUploadIncomplete
+	}
+
+	if uploader, ok := a.Store.(MultipartUploader); ok {
+		if u.stagedSize() > 0 || len(u.Parts) == 0 {
+			if err := a.uploadPart(uploader, u, &bytes.Buffer{}); err != nil {
+				return err
+			}
+		}
+		return uploader.CompleteMultipartUpload(u.Name, u.MultipartID, u.Parts)
+	}
+
+	staged, err := a.readStagedChunks(u)
+	if err != nil {
+		return err
+	}
+	defer staged.Close()
+
+	if err := a.Store.PutFileReader(
+		u.Name,
+		staged,
+		u.stagedSize(),
+		u.ContentType,
+	); err != nil {
+		return fmt.Errorf("failed to put assembled file: %v", err)
+	}
+
+	if err := a.deleteStagedChunks(u); err != nil {
+		log.WithError(err).Warnf("Failed to delete staged chunks of upload %s", u.ID)
+	}
+	return nil
+}
+
+// Abort discards the uploaded chunks.
+func (a *ChunkAssembler) Abort(u *ChunkUpload) error {
+	err := a.deleteStagedChunks(u)
+
+	if uploader, ok := a.Store.(MultipartUploader); ok && u.MultipartID != "" {
+		if abortErr := uploader.AbortMultipartUpload(u.Name, u.MultipartID); abortErr != nil {
+			return abortErr
+		}
+	}
+	return err
+}
+
+type multiCloser []io.Closer
+
+func (c multiCloser) Close() error {
+	var err error
+	for _, closer := range c {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,269 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type memoryStore struct {
+	files map[string]string
+	Store
+}
+
+func (s *memoryStore) GetFileReader(name string) (io.ReadCloser, error) {
+	data, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", name)
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+func (s *memoryStore) PutFileReader(name string, src io.Reader, length int64, contentType string) error {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	s.files[name] = string(data)
+	return nil
+}
+
+func (s *memoryStore) DeleteFile(name string) error {
+	delete(s.files, name)
+	return nil
+}
+
+type multipartStore struct {
+	memoryStore
+	parts     map[int64]string
+	completed bool
+	aborted   bool
+}
+
+func (s *multipartStore) CreateMultipartUpload(name string, contentType string) (string, error) {
+	return "multipartid", nil
+}
+
+func (s *multipartStore) UploadPart(name string, uploadID string, number int64, src io.ReadSeeker, length int64) (string, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(src, length))
+	if err != nil {
+		return "", err
+	}
+	s.parts[number] = string(data)
+	return fmt.Sprintf("etag%d", number), nil
+}
+
+func (s *multipartStore) CompleteMultipartUpload(name string, uploadID string, parts []UploadedPart) error {
+	data := ""
+	for _, part := range parts {
+		data += s.parts[part.Number]
+	}
+	s.files[name] = data
+	s.completed = true
+	return nil
+}
+
+func (s *multipartStore) AbortMultipartUpload(name string, uploadID string) error {
+	s.aborted = true
+	return nil
+}
+
+func (s *multipartStore) MinPartSize() int64 {
+	return 4
+}
+
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestChunkAssembler(t *testing.T) {
+	Convey("ChunkAssembler", t, func() {
+		stagingDir, err := ioutil.TempDir("", "skygear-upload-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(stagingDir)
+
+		u := ChunkUpload{
+			ID:          "uploadid",
+			Name:        "hello.txt",
+			ContentType: "text/plain",
+			Length:      10,
+		}
+
+		Convey("assembles chunks into a store", func() {
+			store := &memoryStore{files: map[string]string{}}
+			assembler := NewChunkAssembler(store, stagingDir)
+			So(assembler.Begin(&u), ShouldBeNil)
+
+			written, err := assembler.WriteChunk(&u, 0, strings.NewReader("I am "))
+			So(err, ShouldBeNil)
+			So(written, ShouldEqual, 5)
+			So(u.Offset, ShouldEqual, 5)
+			So(assembler.Complete(&u), ShouldEqual, ErrChunkUploadIncomplete)
+
+			// content exceeding the length is discarded
+			written, err = assembler.WriteChunk(&u, 5, strings.NewReader("a boy!!"))
+			So(err, ShouldBeNil)
+			So(written, ShouldEqual, 5)
+			So(u.IsComplete(), ShouldBeTrue)
+
+			So(assembler.Complete(&u), ShouldBeNil)
+			So(store.files["hello.txt"], ShouldEqual, "I am a boy")
+			So(store.files, ShouldHaveLength, 1)
+		})
+
+		Convey("resumes an upload on another server", func() {
+			store := &memoryStore{files: map[string]string{}}
+			So(NewChunkAssembler(store, stagingDir).Begin(&u), ShouldBeNil)
+
+			_, err := NewChunkAssembler(store, stagingDir).WriteChunk(&u, 0, strings.NewReader("I am "))
+			So(err, ShouldBeNil)
+			So(store.files["_upload/uploadid/0"], ShouldEqual, "I am ")
+
+			otherStagingDir, err := ioutil.TempDir("", "skygear-upload-test")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(otherStagingDir)
+
+			other := NewChunkAssembler(store, otherStagingDir)
+			_, err = other.WriteChunk(&u, 5, strings.NewReader("a boy"))
+			So(err, ShouldBeNil)
+			So(other.Complete(&u), ShouldBeNil)
+			So(store.files, ShouldResemble, map[string]string{
+				"hello.txt": "I am a boy",
+			})
+		})
+
+		Convey("rejects chunk with mismatched offset", func() {
+			store := &memoryStore{files: map[string]string{}}
+			assembler := NewChunkAssembler(store, stagingDir)
+			So(assembler.Begin(&u), ShouldBeNil)
+
+			_, err := assembler.WriteChunk(&u, 3, strings.NewReader("am a boy"))
+			So(err, ShouldEqual, ErrChunkOffsetMismatch)
+			So(u.Offset, ShouldEqual, 0)
+		})
+
+		Convey("resumes from content received before an interruption", func() {
+			store := &memoryStore{files: map[string]string{}}
+			assembler := NewChunkAssembler(store, stagingDir)
+			So(assembler.Begin(&u), ShouldBeNil)
+
+			written, err := assembler.WriteChunk(&u, 0, &failingReader{"I am"})
+			So(err, ShouldNotBeNil)
+			So(written, ShouldEqual, 4)
+			So(u.Offset, ShouldEqual, 4)
+
+			_, err = assembler.WriteChunk(&u, 4, strings.NewReader(" a boy"))
+			So(err, ShouldBeNil)
+			So(assembler.Complete(&u), ShouldBeNil)
+			So(store.files["hello.txt"], ShouldEqual, "I am a boy")
+		})
+
+		Convey("writes a staged chunk", func() {
+			store := &memoryStore{files: map[string]string{}}
+			assembler := NewChunkAssembler(store, stagingDir)
+			So(assembler.Begin(&u), ShouldBeNil)
+
+			chunk, err := assembler.StageChunk(&u, &failingReader{"I am"})
+			So(err, ShouldBeNil)
+			So(chunk.Size, ShouldEqual, 4)
+			So(chunk.Err, ShouldNotBeNil)
+
+			written, err := assembler.WriteChunk(&u, 0, chunk)
+			So(err, ShouldBeNil)
+			So(written, ShouldEqual, 4)
+			So(chunk.Close(), ShouldBeNil)
+
+			chunk, err = assembler.StageChunk(&u, strings.NewReader(" a boy!!"))
+			So(err, ShouldBeNil)
+			So(chunk.Size, ShouldEqual, 6)
+			So(chunk.Err, ShouldBeNil)
+
+			_, err = assembler.WriteChunk(&u, 4, chunk)
+			So(err, ShouldBeNil)
+			So(chunk.Close(), ShouldBeNil)
+
+			So(assembler.Complete(&u), ShouldBeNil)
+			So(store.files["hello.txt"], ShouldEqual, "I am a boy")
+
+			files, err := ioutil.ReadDir(stagingDir)
+			So(err, ShouldBeNil)
+			So(files, ShouldBeEmpty)
+		})
+
+		Convey("uploads parts to a multipart uploader", func() {
+			store := &multipartStore{
+				memoryStore: memoryStore{files: map[string]string{}},
+				parts:       map[int64]string{},
+			}
+			assembler := NewChunkAssembler(store, stagingDir)
+			So(assembler.Begin(&u), ShouldBeNil)
+			So(u.MultipartID, ShouldEqual, "multipartid")
+
+			_, err := assembler.WriteChunk(&u, 0, strings.NewReader("I a"))
+			So(err, ShouldBeNil)
+			So(u.Parts, ShouldResemble, []UploadedPart{
+				{Size: 3, Staged: true},
+			})
+			So(store.files["_upload/uploadid/0"], ShouldEqual, "I a")
+
+			_, err = assembler.WriteChunk(&u, 3, strings.NewReader("m a b"))
+			So(err, ShouldBeNil)
+			So(u.Parts, ShouldResemble, []UploadedPart{
+				{Number: 1, ETag: "etag1", Size: 8},
+			})
+
+			_, err = assembler.WriteChunk(&u, 8, strings.NewReader("oy"))
+			So(err, ShouldBeNil)
+			So(assembler.Complete(&u), ShouldBeNil)
+			So(u.Parts, ShouldHaveLength, 2)
+			So(store.completed, ShouldBeTrue)
+			So(store.files, ShouldResemble, map[string]string{
+				"hello.txt": "I am a boy",
+			})
+		})
+
+		Convey("aborts a multipart upload", func() {
+			store := &multipartStore{
+				memoryStore: memoryStore{files: map[string]string{}},
+				parts:       map[int64]string{},
+			}
+			assembler := NewChunkAssembler(store, stagingDir)
+			So(assembler.Begin(&u), ShouldBeNil)
+			_, err := assembler.WriteChunk(&u, 0, strings.NewReader("I a"))
+			So(err, ShouldBeNil)
+
+			So(assembler.Abort(&u), ShouldBeNil)
+			So(store.aborted, ShouldBeTrue)
+			So(store.files, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,369 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// maxWebPDimension is the maximum width or height of a webp image.
+const maxWebPDimension = 1 << 14
+
+const (
+	vp8lSignature        = 0x2f
+	vp8lSubtractGreen    = 2
+	vp8lNumLiteralCodes  = 256
+	vp8lNumLengthCodes   = 24
+	vp8lNumDistanceCodes = 40
+	vp8lMaxCodeLength    = 15
+	vp8lMaxCodeLengthCL  = 7
+)
+
+// vp8lCodeLengthCodeOrder is the order in which the code lengths of the
+// code length code are written.
+var vp8lCodeLengthCodeOrder = []int{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// encodeWebP writes the image to w in lossless webp (VP8L). An image
+// wider or taller than maxWebPDimension is rejected with ErrImageTooLarge.
+//
+// The encoder is kept simple: pixels are written as literals after the
+// subtract green transform, without predictors, color cache or backward
+// references. The output is larger than that of libwebp, but is decoded
+// by any webp decoder.
+func encodeWebP(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return errors.New("cannot encode an empty image in webp")
+	}
+	if width > maxWebPDimension || height > maxWebPDimension {
+		return ErrImageTooLarge
+	}
+
+	// each pixel is written as green, red, blue and alpha, with green
+	// subtracted from red and blue
+	pixel := func(x, y int) ([4]int, bool) {
+		c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+		return [4]int{int(c.G), int(c.R - c.G), int(c.B - c.G), int(c.A)}, c.A != 0xff
+	}
+
+	var histograms [4][]int
+	histograms[0] = make([]int, vp8lNumLiteralCodes+vp8lNumLengthCodes)
+	for i := 1; i < 4; i++ {
+		histograms[i] = make([]int, vp8lNumLiteralCodes)
+	}
+	alphaUsed := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p, translucent := pixel(x, y)
+			for i, v := range p {
+				histograms[i][v]++
+			}
+			alphaUsed = alphaUsed || translucent
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if alphaUsed {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3) // version
+
+	// subtract green transform, followed by the end of transforms
+	bw.writeBits(1, 1)
+	bw.writeBits(vp8lSubtractGreen, 2)
+	bw.writeBits(0, 1)
+
+	bw.writeBits(0, 1) // no color cache
+	bw.writeBits(0, 1) // no meta prefix codes
+
+	var codes [4]prefixCode
+	for i, histogram := range histograms {
+		codes[i] = writePrefixCode(bw, histogram)
+	}
+	// the distance code is not used without backward references
+	writePrefixCode(bw, make([]int, vp8lNumDistanceCodes))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p, _ := pixel(x, y)
+			for i, v := range p {
+				codes[i].write(bw, v)
+			}
+		}
+	}
+
+	data := bw.bytes()
+	chunkSize := len(data)
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(12+len(data)))
+	copy(header[8:16], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(chunkSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// bitWriter writes bits to a byte slice, least significant bit first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) writeBits(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc = 0
+		w.nbits = 0
+	}
+	return w.buf
+}
+
+// prefixCode is a canonical prefix code, with the codes of the symbols
+// stored bit reversed to be written least significant bit first.
+type prefixCode struct {
+	codes   []uint32
+	lengths []int
+}
+
+func (c prefixCode) write(w *bitWriter, symbol int) {
+	w.writeBits(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// newPrefixCode returns the canonical prefix code of the code lengths.
+// A code with a single symbol is written with zero bits, as decoders do
+// not read any bit for it.
+func newPrefixCode(lengths []int) prefixCode {
+	c := prefixCode{
+		codes:   make([]uint32, len(lengths)),
+		lengths: make([]int, len(lengths)),
+	}
+
+	used := 0
+	var count [vp8lMaxCodeLength + 1]int
+	for _, length := range lengths {
+		if length > 0 {
+			count[length]++
+			used++
+		}
+	}
+	if used <= 1 {
+		return c
+	}
+
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for length := 1; length <= vp8lMaxCodeLength; length++ {
+		code = (code + uint32(count[length-1])) << 1
+		next[length] = code
+	}
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		c.codes[symbol] = reverseBits(next[length], uint(length))
+		c.lengths[symbol] = length
+		next[length]++
+	}
+	return c
+}
+
+func reverseBits(v uint32, n uint) uint32 {
+	r := uint32(0)
+	for i := uint(0); i < n; i++ {
+		r = r<<1 | (v>>i)&1
+	}
+	return r
+}
+
+// writePrefixCode writes the prefix code of the histogram and returns it.
+func writePrefixCode(w *bitWriter, histogram []int) prefixCode {
+	symbols := []int{}
+	for symbol, count := range histogram {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	// a simple code holds up to two 8-bit symbols
+	if len(symbols) <= 2 && (len(symbols) == 0 || symbols[len(symbols)-1] < 256) {
+		if len(symbols) == 0 {
+			symbols = []int{0}
+		}
+		w.writeBits(1, 1)
+		w.writeBits(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			w.writeBits(0, 1)
+			w.writeBits(uint32(symbols[0]), 1)
+		} else {
+			w.writeBits(1, 1)
+			w.writeBits(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			w.writeBits(uint32(symbols[1]), 8)
+		}
+
+		lengths := make([]int, len(histogram))
+		for _, symbol := range symbols {
+			lengths[symbol] = 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := huffmanCodeLengths(histogram, vp8lMaxCodeLength)
+
+	// the code lengths are written as literals with the code length code
+	clHistogram := make([]int, len(vp8lCodeLengthCodeOrder))
+	for _, length := range lengths {
+		clHistogram[length]++
+	}
+	clLengths := huffmanCodeLengths(clHistogram, vp8lMaxCodeLengthCL)
+	numCodes := 4
+	for i, symbol := range vp8lCodeLengthCodeOrder {
+		if clLengths[symbol] > 0 && i+1 > numCodes {
+			numCodes = i + 1
+		}
+	}
+
+	w.writeBits(0, 1)
+	w.writeBits(uint32(numCodes-4), 4)
+	for _, symbol := range vp8lCodeLengthCodeOrder[:numCodes] {
+		w.writeBits(uint32(clLengths[symbol]), 3)
+	}
+	w.writeBits(0, 1) // code lengths of all symbols are written
+
+	clCode := newPrefixCode(clLengths)
+	for _, length := range lengths {
+		clCode.write(w, length)
+	}
+	return newPrefixCode(lengths)
+}
+
+// huffmanCodeLengths returns the lengths of the Huffman code of the
+// histogram, limited to maxLength. The counts are raised to a minimum
+// until the code fits, as done by libwebp.
+func huffmanCodeLengths(histogram []int, maxLength int) []int {
+	lengths := make([]int, len(histogram))
+	symbols := []int{}
+	for symbol, count := range histogram {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) == 0 {
+		return lengths
+	}
+	if len(symbols) == 1 {
+		lengths[symbols[0]] = 1
+		return lengths
+	}
+
+	for countMin := 1; ; countMin *= 2 {
+		nodes := make(huffmanHeap, 0, len(symbols))
+		for _, symbol := range symbols {
+			count := histogram[symbol]
+			if count < countMin {
+				count = countMin
+			}
+			nodes = append(nodes, &huffmanNode{count: count, symbols: []int{symbol}})
+		}
+		heap.Init(&nodes)
+
+		for i := range lengths {
+			lengths[i] = 0
+		}
+		for nodes.Len() > 1 {
+			a := heap.Pop(&nodes).(*huffmanNode)
+			b := heap.Pop(&nodes).(*huffmanNode)
+			merged := &huffmanNode{
+				count:   a.count + b.count,
+				symbols: append(append([]int{}, a.symbols...), b.symbols...),
+			}
+			for _, symbol := range merged.symbols {
+				lengths[symbol]++
+			}
+			heap.Push(&nodes, merged)
+		}
+
+		fits := true
+		for _, length := range lengths {
+			if length > maxLength {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return lengths
+		}
+	}
+}
+
+type huffmanNode struct {
+	count   int
+	symbols []int
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count == h[j].count {
+		return h[i].symbols[0] < h[j].symbols[0]
+	}
+	return h[i].count < h[j].count
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *huffmanHeap) Push(x interface{}) {
+	*h = append(*h, x.(*huffmanNode))
+}
+
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,71 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package asset
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	Convey("TransformImage", t, func() {
+		src := newTestPNG(200, 100)
+
+		Convey("converts to lossless webp", func() {
+			data, contentType, err := TransformImage(bytes.NewReader(src), ImageTransform{
+				Width:  50,
+				Format: "webp",
+			})
+			So(err, ShouldBeNil)
+			So(contentType, ShouldEqual, "image/webp")
+
+			So(string(data[0:4]), ShouldEqual, "RIFF")
+			So(int(binary.LittleEndian.Uint32(data[4:8])), ShouldEqual, len(data)-8)
+			So(string(data[8:16]), ShouldEqual, "WEBPVP8L")
+			So(int(data[20]), ShouldEqual, vp8lSignature)
+
+			header := binary.LittleEndian.Uint32(data[21:25])
+			So(int(header&0x3fff)+1, ShouldEqual, 50)
+			So(int(header>>14&0x3fff)+1, ShouldEqual, 25)
+		})
+
+		Convey("rejects image too large for webp", func() {
+			err := encodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, maxWebPDimension+1, 1)))
+			So(err, ShouldEqual, ErrImageTooLarge)
+		})
+	})
+
+	Convey("huffmanCodeLengths", t, func() {
+		// counts of the fibonacci sequence give the deepest Huffman tree
+		histogram := []int{1, 1}
+		for len(histogram) < 30 {
+			histogram = append(histogram, histogram[len(histogram)-1]+histogram[len(histogram)-2])
+		}
+
+		lengths := huffmanCodeLengths(histogram, vp8lMaxCodeLength)
+
+		// the code is complete and limited to the maximum length
+		kraft := 0
+		for _, length := range lengths {
+			So(length, ShouldBeBetweenOrEqual, 1, vp8lMaxCodeLength)
+			kraft += 1 << uint(vp8lMaxCodeLength-length)
+		}
+		So(kraft, ShouldEqual, 1<<vp8lMaxCodeLength)
+	})
+}
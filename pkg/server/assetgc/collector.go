@@ -0,0 +1,204 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package assetgc deletes assets that are no longer referenced by any
+// record, and aborts uploads that are abandoned.
+package assetgc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var log = logging.LoggerEntry("assetgc")
+
+var timeNow = time.Now
+
+// DefaultGracePeriod is the grace period of a Collector if not specified.
+const DefaultGracePeriod = 24 * time.Hour
+
+// ErrDeleteNotSupported is returned by Collector.Collect if the asset store
+// does not support deleting files.
+var ErrDeleteNotSupported = errors.New("assetgc: asset store does not support deleting files")
+
+var errTransactionNotSupported = errors.New("assetgc: database does not support transaction")
+
+// CollectedAsset is an unreferenced asset found by a Collector.
+type CollectedAsset struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Deleted     bool   `json:"deleted"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Result reports the assets found and deleted by a Collector.
+type Result struct {
+	DryRun       bool             `json:"dry_run"`
+	Assets       []CollectedAsset `json:"assets"`
+	DeletedCount int              `json:"deleted_count"`
+	DeletedSize  int64            `json:"deleted_size"`
+}
+
+// Collector deletes assets that are not referenced by any asset field of
+// any record type.
+//
+// An asset is kept if it was saved within the grace period, so that an
+// asset just uploaded is not deleted before it is saved into a record.
+type Collector struct {
+	ConnOpener  func() (skydb.Conn, error)
+	Store       asset.Store
+	GracePeriod time.Duration
+	Interval    time.Duration
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Collect finds the unreferenced assets and deletes them from both the
+// database and the asset store. If dryRun is true, the assets are only
+// reported.
+//
+// Each asset is deleted in a transaction. The asset information is
+// deleted first, which fails if the asset is referenced by a record after
+// it is found, and locks the asset against new references. The file and
+// its transformed variants are then deleted, and the transaction is
+// committed only after that succeeds, so the asset information is kept
+// if the file cannot be deleted.
+func (c *Collector) Collect(conn skydb.Conn, dryRun bool) (Result, error) {
+	result := Result{
+		DryRun: dryRun,
+		Assets: []CollectedAsset{},
+	}
+
+	deleter, ok := c.Store.(asset.FileDeleter)
+	if !ok && !dryRun {
+		return result, ErrDeleteNotSupported
+	}
+
+	txDB, ok := conn.PublicDB().(skydb.Transactional)
+	if !ok && !dryRun {
+		return result, errTransactionNotSupported
+	}
+
+	gracePeriod := c.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+
+	assets, err := conn.QueryUnreferencedAssets(timeNow().Add(-gracePeriod))
+	if err != nil {
+		return result, err
+	}
+
+	for _, a := range assets {
+		collected := CollectedAsset{
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+		}
+
+		if !dryRun {
+			if err := c.delete(conn, txDB, deleter, a.Name); err != nil {
+				collected.Error = err.Error()
+			} else {
+				collected.Deleted = true
+				result.DeletedCount++
+				result.DeletedSize += a.Size
+			}
+		}
+
+		result.Assets = append(result.Assets, collected)
+	}
+
+	return result, nil
+}
+
+func (c *Collector) delete(conn skydb.Conn, txDB skydb.Transactional, deleter asset.FileDeleter, name string) error {
+	return skydb.WithTransaction(txDB, func() error {
+		if err := conn.DeleteAsset(name); err != nil {
+			return err
+		}
+
+		if err := deleter.DeleteFile(name); err != nil {
+			log.WithField("err", err).WithField("asset", name).
+				Errorln("assetgc: failed to delete file of an unreferenced asset")
+			return err
+		}
+		return nil
+	})
+}
+
+// Run collects unreferenced assets periodically until Stop is called.
+func (c *Collector) Run() {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stop := c.stopChan()
+	for {
+		select {
+		case <-ticker.C:
+			c.collect()
+		case <-stop:
+			log.Infoln("assetgc: stopping the collector")
+			return
+		}
+	}
+}
+
+// Stop stops the collector started by Run. A collector stopped before it
+// runs does not collect.
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopChan())
+	})
+}
+
+func (c *Collector) stopChan() chan struct{} {
+	c.initOnce.Do(func() {
+		c.stop = make(chan struct{})
+	})
+	return c.stop
+}
+
+func (c *Collector) collect() {
+	conn, err := c.ConnOpener()
+	if err != nil {
+		log.WithField("err", err).Errorln("assetgc: failed to open skydb.Conn")
+		return
+	}
+	defer conn.Close()
+
+	result, err := c.Collect(conn, false)
+	if err != nil {
+		log.WithField("err", err).Errorln("assetgc: failed to collect unreferenced assets")
+		return
+	}
+
+	if len(result.Assets) > 0 {
+		log.Infof("assetgc: deleted %d of %d unreferenced assets (%d bytes)",
+			result.DeletedCount, len(result.Assets), result.DeletedSize)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assetgc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type assetConn struct {
+	assets     map[string]skydb.Asset
+	savedAt    map[string]time.Time
+	referenced map[string]bool
+	db         *skydbtest.MockTxDatabase
+	skydb.Conn
+}
+
+func (conn *assetConn) PublicDB() skydb.Database {
+	return conn.db
+}
+
+func (conn *assetConn) QueryUnreferencedAssets(savedBefore time.Time) ([]skydb.Asset, error) {
+	result := []skydb.Asset{}
+	for name, a := range conn.assets {
+		if !conn.referenced[name] && conn.savedAt[name].Before(savedBefore) {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (conn *assetConn) DeleteAsset(name string) error {
+	if conn.referenced[name] {
+		return skydb.ErrAssetReferenced
+	}
+	delete(conn.assets, name)
+	return nil
+}
+
+type deletingStore struct {
+	deleted []string
+	err     error
+	asset.Store
+}
+
+func (s *deletingStore) DeleteFile(name string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+func TestCollector(t *testing.T) {
+	Convey("Collector", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		conn := &assetConn{
+			assets: map[string]skydb.Asset{
+				"old.png": {Name: "old.png", ContentType: "image/png", Size: 10},
+				"new.png": {Name: "new.png", ContentType: "image/png", Size: 20},
+				"ref.png": {Name: "ref.png", ContentType: "image/png", Size: 30},
+			},
+			savedAt: map[string]time.Time{
+				"old.png": now.Add(-48 * time.Hour),
+				"new.png": now.Add(-time.Hour),
+				"ref.png": now.Add(-48 * time.Hour),
+			},
+			referenced: map[string]bool{
+				"ref.png": true,
+			},
+			db: skydbtest.NewMockTxDatabase(nil),
+		}
+		store := &deletingStore{}
+		collector := &Collector{
+			Store:       store,
+			GracePeriod: 24 * time.Hour,
+		}
+
+		Convey("reports unreferenced assets in dry run", func() {
+			result, err := collector.Collect(conn, true)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, Result{
+				DryRun: true,
+				Assets: []CollectedAsset{
+					{Name: "old.png", ContentType: "image/png", Size: 10},
+				},
+			})
+			So(conn.assets, ShouldContainKey, "old.png")
+			So(store.deleted, ShouldBeEmpty)
+		})
+
+		Convey("deletes unreferenced assets after grace period", func() {
+			result, err := collector.Collect(conn, false)
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, Result{
+				Assets: []CollectedAsset{
+					{Name: "old.png", ContentType: "image/png", Size: 10, Deleted: true},
+				},
+				DeletedCount: 1,
+				DeletedSize:  10,
+			})
+			So(conn.assets, ShouldNotContainKey, "old.png")
+			So(conn.assets, ShouldContainKey, "new.png")
+			So(store.deleted, ShouldResemble, []string{"old.png"})
+			So(conn.db.DidCommit, ShouldBeTrue)
+		})
+
+		Convey("does not delete file of asset referenced after found", func() {
+			conn.referenced["old.png"] = true
+
+			err := collector.delete(conn, conn.db, store, "old.png")
+			So(err, ShouldEqual, skydb.ErrAssetReferenced)
+			So(conn.assets, ShouldContainKey, "old.png")
+			So(store.deleted, ShouldBeEmpty)
+			So(conn.db.DidRollback, ShouldBeTrue)
+		})
+
+		Convey("keeps asset information if file cannot be deleted", func() {
+			store.err = errors.New("store unavailable")
+
+			result, err := collector.Collect(conn, false)
+			So(err, ShouldBeNil)
+			So(result.Assets, ShouldResemble, []CollectedAsset{
+				{Name: "old.png", ContentType: "image/png", Size: 10, Error: "store unavailable"},
+			})
+			So(result.DeletedCount, ShouldEqual, 0)
+			So(conn.db.DidRollback, ShouldBeTrue)
+			So(conn.db.DidCommit, ShouldBeFalse)
+		})
+
+		Convey("refuses to delete with store not supporting deletion", func() {
+			collector.Store = &struct{ asset.Store }{}
+			_, err := collector.Collect(conn, false)
+			So(err, ShouldEqual, ErrDeleteNotSupported)
+		})
+
+		Convey("stops collector stopped before it runs", func() {
+			collector.Stop()
+
+			done := make(chan struct{})
+			go func() {
+				collector.Run()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected collector stopped")
+			}
+		})
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assetgc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// UploadReaper aborts the uploads of expired upload sessions, so that the
+// staged chunks and the parts of multipart uploads abandoned by clients
+// are discarded.
+type UploadReaper struct {
+	ConnOpener func() (skydb.Conn, error)
+	Assembler  *asset.ChunkAssembler
+	Interval   time.Duration
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Reap aborts the uploads of expired upload sessions and deletes the
+// sessions. It returns the number of uploads aborted.
+//
+// Each session is locked before it is aborted, and is skipped if it is no
+// longer expired, as a chunk may be written after the session is found.
+func (r *UploadReaper) Reap(conn skydb.Conn) (int, error) {
+	now := timeNow()
+	sessions, err := conn.QueryExpiredUploadSessions(now)
+	if err != nil {
+		return 0, err
+	}
+
+	txDB, ok := conn.PublicDB().(skydb.Transactional)
+	if !ok {
+		return 0, errTransactionNotSupported
+	}
+
+	aborted := 0
+	for _, expired := range sessions {
+		err := skydb.WithTransaction(txDB, func() error {
+			session := skydb.UploadSession{}
+			if err := conn.GetUploadSessionForUpdate(expired.ID, &session); err == skydb.ErrUploadSessionNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if !session.IsExpired(now) {
+				return nil
+			}
+
+			if err := conn.DeleteUploadSession(session.ID); err != nil {
+				return err
+			}
+			if err := r.Assembler.Abort(&session.ChunkUpload); err != nil {
+				return err
+			}
+			aborted++
+			return nil
+		})
+		if err != nil {
+			log.WithField("err", err).WithField("session", expired.ID).
+				Errorln("assetgc: failed to abort expired upload")
+		}
+	}
+	return aborted, nil
+}
+
+// Run aborts expired uploads periodically until Stop is called.
+func (r *UploadReaper) Run() {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stop := r.stopChan()
+	for {
+		select {
+		case <-ticker.C:
+			r.reap()
+		case <-stop:
+			log.Infoln("assetgc: stopping the upload reaper")
+			return
+		}
+	}
+}
+
+// Stop stops the reaper started by Run. A reaper stopped before it runs
+// does not reap.
+func (r *UploadReaper) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan())
+	})
+}
+
+func (r *UploadReaper) stopChan() chan struct{} {
+	r.initOnce.Do(func() {
+		r.stop = make(chan struct{})
+	})
+	return r.stop
+}
+
+func (r *UploadReaper) reap() {
+	conn, err := r.ConnOpener()
+	if err != nil {
+		log.WithField("err", err).Errorln("assetgc: failed to open skydb.Conn")
+		return
+	}
+	defer conn.Close()
+
+	aborted, err := r.Reap(conn)
+	if err != nil {
+		log.WithField("err", err).Errorln("assetgc: failed to abort expired uploads")
+		return
+	}
+
+	if aborted > 0 {
+		log.Infof("assetgc: aborted %d expired uploads", aborted)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package assetgc
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type uploadSessionConn struct {
+	sessions map[string]skydb.UploadSession
+	skydb.Conn
+}
+
+func (conn *uploadSessionConn) PublicDB() skydb.Database {
+	return &txDatabase{}
+}
+
+func (conn *uploadSessionConn) QueryExpiredUploadSessions(t time.Time) ([]skydb.UploadSession, error) {
+	result := []skydb.UploadSession{}
+	for _, session := range conn.sessions {
+		if session.IsExpired(t) {
+			result = append(result, session)
+		}
+	}
+	return result, nil
+}
+
+func (conn *uploadSessionConn) GetUploadSessionForUpdate(id string, session *skydb.UploadSession) error {
+	saved, ok := conn.sessions[id]
+	if !ok {
+		return skydb.ErrUploadSessionNotFound
+	}
+	*session = saved
+	return nil
+}
+
+func (conn *uploadSessionConn) DeleteUploadSession(id string) error {
+	delete(conn.sessions, id)
+	return nil
+}
+
+type txDatabase struct {
+	skydb.Database
+}
+
+func (db *txDatabase) Begin() error    { return nil }
+func (db *txDatabase) Commit() error   { return nil }
+func (db *txDatabase) Rollback() error { return nil }
+
+type abortingStore struct {
+	aborted []string
+	asset.Store
+	asset.MultipartUploader
+}
+
+func (s *abortingStore) AbortMultipartUpload(name string, uploadID string) error {
+	s.aborted = append(s.aborted, uploadID)
+	return nil
+}
+
+func TestUploadReaper(t *testing.T) {
+	Convey("UploadReaper", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		stagingDir, err := ioutil.TempDir("", "skygear-upload-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(stagingDir)
+
+		store := &abortingStore{}
+		conn := &uploadSessionConn{
+			sessions: map[string]skydb.UploadSession{
+				"expired": {
+					ChunkUpload: asset.ChunkUpload{
+						ID:          "expired",
+						Name:        "expired.mp4",
+						MultipartID: "expired.mp4-multipart",
+					},
+					ExpiresAt: now.Add(-time.Minute),
+				},
+				"active": {
+					ChunkUpload: asset.ChunkUpload{
+						ID:          "active",
+						Name:        "active.mp4",
+						MultipartID: "active.mp4-multipart",
+					},
+					ExpiresAt: now.Add(time.Hour),
+				},
+			},
+		}
+		reaper := &UploadReaper{
+			Assembler: asset.NewChunkAssembler(store, stagingDir),
+		}
+
+		Convey("aborts expired uploads", func() {
+			aborted, err := reaper.Reap(conn)
+			So(err, ShouldBeNil)
+			So(aborted, ShouldEqual, 1)
+			So(store.aborted, ShouldResemble, []string{"expired.mp4-multipart"})
+			So(conn.sessions, ShouldContainKey, "active")
+			So(conn.sessions, ShouldNotContainKey, "expired")
+		})
+
+		Convey("stops reaper stopped before it runs", func() {
+			reaper.Stop()
+
+			done := make(chan struct{})
+			go func() {
+				reaper.Run()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected reaper stopped")
+			}
+		})
+	})
+}
@@ -17,6 +17,7 @@ package audit
 import (
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/nbutton23/zxcvbn-go"
 	"golang.org/x/crypto/bcrypt"
@@ -251,6 +252,25 @@ type PasswordChecker struct {
 	PwHistorySize          int
 	PwHistoryDays          int
 	PasswordHistoryEnabled bool
+
+	mutex sync.RWMutex
+}
+
+// UpdatePolicy changes the password policy of the checker to that of
+// policy while passwords are being validated. The settings of password
+// history are not changed.
+func (pc *PasswordChecker) UpdatePolicy(policy *PasswordChecker) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+
+	pc.PwMinLength = policy.PwMinLength
+	pc.PwUppercaseRequired = policy.PwUppercaseRequired
+	pc.PwLowercaseRequired = policy.PwLowercaseRequired
+	pc.PwDigitRequired = policy.PwDigitRequired
+	pc.PwSymbolRequired = policy.PwSymbolRequired
+	pc.PwMinGuessableLevel = policy.PwMinGuessableLevel
+	pc.PwExcludedKeywords = policy.PwExcludedKeywords
+	pc.PwExcludedFields = policy.PwExcludedFields
 }
 
 func (pc *PasswordChecker) checkPasswordLength(password string) skyerr.Error {
@@ -391,6 +411,9 @@ func (pc *PasswordChecker) checkPasswordHistory(password, authID string, conn sk
 }
 
 func (pc *PasswordChecker) ValidatePassword(payload ValidatePasswordPayload) skyerr.Error {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
+
 	password := payload.PlainPassword
 	userData := payload.UserData
 	conn := payload.Conn
@@ -423,11 +446,13 @@ func (pc *PasswordChecker) ValidatePassword(payload ValidatePasswordPayload) sky
 }
 
 func (pc *PasswordChecker) ShouldSavePasswordHistory() bool {
+	pc.mutex.RLock()
+	defer pc.mutex.RUnlock()
 	return pc.PasswordHistoryEnabled
 }
 
 func (pc *PasswordChecker) shouldCheckPasswordHistory() bool {
-	return pc.ShouldSavePasswordHistory()
+	return pc.PasswordHistoryEnabled
 }
 
 func IsSamePassword(hashedPassword []byte, password string) bool {
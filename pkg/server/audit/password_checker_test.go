@@ -358,4 +358,36 @@ func TestValidatePassword(t *testing.T) {
 			nil,
 		)
 	})
+	Convey("validate password after policy updated", t, func() {
+		pc := &PasswordChecker{
+			PwMinLength:            2,
+			PwHistorySize:          3,
+			PasswordHistoryEnabled: true,
+		}
+		pc.UpdatePolicy(&PasswordChecker{
+			PwMinLength:     4,
+			PwDigitRequired: true,
+		})
+		So(pc.PwHistorySize, ShouldEqual, 3)
+		So(pc.ShouldSavePasswordHistory(), ShouldBeTrue)
+		So(
+			pc.ValidatePassword(ValidatePasswordPayload{
+				PlainPassword: "abc",
+			}),
+			ShouldEqualSkyError,
+			skyerr.PasswordPolicyViolated,
+			"password too short",
+			map[string]interface{}{
+				"reason":     PasswordTooShort.String(),
+				"min_length": 4,
+				"pw_length":  3,
+			},
+		)
+		So(
+			pc.ValidatePassword(ValidatePasswordPayload{
+				PlainPassword: "abcd",
+			}),
+			ShouldNotBeNil,
+		)
+	})
 }
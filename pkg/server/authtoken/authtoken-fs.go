@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,12 +32,17 @@ func NewFileStore(address string, expiry int64) *FileStore {
 // NewToken creates a new token for this token store.
 func (f *FileStore) NewToken(appName string, authInfoID string) (Token, error) {
 	var expireAt time.Time
-	if f.expiry > 0 {
-		expireAt = time.Now().Add(time.Duration(f.expiry) * time.Second)
+	if expiry := atomic.LoadInt64(&f.expiry); expiry > 0 {
+		expireAt = time.Now().Add(time.Duration(expiry) * time.Second)
 	}
 	return New(appName, authInfoID, expireAt), nil
 }
 
+// SetExpiry sets the expiry of new tokens in seconds.
+func (f *FileStore) SetExpiry(expiry int64) {
+	atomic.StoreInt64(&f.expiry, expiry)
+}
+
 // Get tries to read the specified access token from file and
 // writes to the supplied Token.
 //
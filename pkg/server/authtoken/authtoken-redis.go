@@ -1,6 +1,7 @@
 package authtoken
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -98,13 +99,18 @@ func (r RedisToken) ToToken() *Token {
 // NewToken creates a new token for this token store.
 func (r *RedisStore) NewToken(appName string, authInfoID string) (Token, error) {
 	var expireAt time.Time
-	if r.expiry > 0 {
-		expireAt = time.Now().Add(time.Duration(r.expiry) * time.Second)
+	if expiry := atomic.LoadInt64(&r.expiry); expiry > 0 {
+		expireAt = time.Now().Add(time.Duration(expiry) * time.Second)
 	}
 	return New(appName, authInfoID, expireAt), nil
 
 }
 
+// SetExpiry sets the expiry of new tokens in seconds.
+func (r *RedisStore) SetExpiry(expiry int64) {
+	atomic.StoreInt64(&r.expiry, expiry)
+}
+
 // Get tries to read the specified access token from redis store and
 // writes to the supplied Token.
 func (r *RedisStore) Get(accessToken string, token *Token) error {
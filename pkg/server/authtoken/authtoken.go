@@ -149,6 +149,12 @@ type Store interface {
 	Delete(accessToken string) error
 }
 
+// ExpirySetter is implemented by a Store of which the expiry of new
+// tokens can be changed while tokens are being created.
+type ExpirySetter interface {
+	SetExpiry(expiry int64)
+}
+
 var errInvalidToken = errors.New("invalid access token")
 
 func validateToken(base string) error {
@@ -67,6 +67,25 @@ func TestNewTokenWithExpiry(t *testing.T) {
 	}
 }
 
+func TestFileStoreSetExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-token")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(dir, 0)
+	store.SetExpiry(60)
+
+	token, err := store.NewToken("com_oursky_skygear", "46709394")
+	if err != nil {
+		t.Fatalf("got err = %v, want nil", err)
+	}
+	if token.ExpiredAt.IsZero() || token.ExpiredAt.After(time.Now().Add(60*time.Second)) {
+		t.Fatalf("got token.ExpiredAt = %v, want within 60 seconds", token.ExpiredAt)
+	}
+}
+
 func TestTokenIsExpired(t *testing.T) {
 	now := time.Now()
 	token := Token{}
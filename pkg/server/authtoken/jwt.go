@@ -16,6 +16,7 @@ package authtoken
 
 import (
 	"errors"
+	"sync/atomic"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -50,8 +51,8 @@ func (r *JWTStore) NewToken(appName string, authInfoID string) (Token, error) {
 		Subject:  authInfoID,
 	}
 
-	if r.expiry > 0 {
-		claims.ExpiresAt = time.Now().Unix() + r.expiry
+	if expiry := atomic.LoadInt64(&r.expiry); expiry > 0 {
+		claims.ExpiresAt = time.Now().Unix() + expiry
 	}
 
 	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -66,6 +67,11 @@ func (r *JWTStore) NewToken(appName string, authInfoID string) (Token, error) {
 	return token, nil
 }
 
+// SetExpiry sets the expiry of new tokens in seconds.
+func (r *JWTStore) SetExpiry(expiry int64) {
+	atomic.StoreInt64(&r.expiry, expiry)
+}
+
 // Get decodes and verifies the access token for user information. It returns
 // the access token containing information about the user.
 func (r *JWTStore) Get(accessToken string, token *Token) error {
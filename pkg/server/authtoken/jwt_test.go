@@ -37,6 +37,13 @@ func TestJWTStore(t *testing.T) {
 			So(claims.ExpiresAt, ShouldEqual, 0)
 		})
 
+		Convey("should create new token with changed expiry", func() {
+			store.SetExpiry(3600)
+			token, err := store.NewToken("exampleapp", "userid1")
+			So(err, ShouldBeNil)
+			So(token.ExpiredAt.Unix(), ShouldEqual, token.IssuedAt().Unix()+3600)
+		})
+
 		Convey("should get a token", func() {
 			issuedAt := time.Now()
 			claims := jwt.StandardClaims{
@@ -17,9 +17,13 @@ package handler
 import (
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	skyAsset "github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/assetgc"
 	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
@@ -27,11 +31,17 @@ import (
 )
 
 // AssetUploadHandler models the handler for asset upload request
+//
+// If record_type and field are specified, the declared content type and
+// size of the asset are checked against the asset policy of the record
+// field before the upload is allowed. The content of the asset is checked
+// again when the asset is saved to the record.
 type AssetUploadHandler struct {
-	AssetStore    skyAsset.Store   `inject:"AssetStore"`
-	AccessKey     router.Processor `preprocessor:"accesskey"`
-	DBConn        router.Processor `preprocessor:"dbconn"`
-	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	AssetStore    skyAsset.Store    `inject:"AssetStore"`
+	AssetPolicies skyAsset.Policies `inject:"AssetPolicies"`
+	AccessKey     router.Processor  `preprocessor:"accesskey"`
+	DBConn        router.Processor  `preprocessor:"dbconn"`
+	PluginReady   router.Processor  `preprocessor:"plugin_ready"`
 	preprocessors []router.Processor
 }
 
@@ -88,6 +98,17 @@ func (h *AssetUploadHandler) Handle(
 	}
 	contentSize := int64(contentSizeFloat)
 
+	recordType, _ := payload.Data["record_type"].(string)
+	field, _ := payload.Data["field"].(string)
+	if recordType != "" && field != "" {
+		if policy, ok := h.AssetPolicies.Get(recordType, field); ok {
+			if err := policy.CheckMetadata(contentType, contentSize); err != nil {
+				response.Err = recordutil.NewAssetPolicyError(field, err)
+				return
+			}
+		}
+	}
+
 	// Add UUID to Filename
 	dir, file := filepath.Split(filename)
 	file = strings.Join([]string{uuidNew(), file}, "-")
@@ -132,3 +153,80 @@ func (h *AssetUploadHandler) Handle(
 		Asset:       &assetMap,
 	}
 }
+
+type assetGCPayload struct {
+	DryRun      bool  `mapstructure:"dry_run"`
+	GracePeriod int64 `mapstructure:"grace_period"`
+}
+
+func (payload *assetGCPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *assetGCPayload) Validate() skyerr.Error {
+	if payload.GracePeriod < 0 {
+		return skyerr.NewInvalidArgument("grace period cannot be negative", []string{"grace_period"})
+	}
+	return nil
+}
+
+// AssetGCHandler deletes assets that are not referenced by any record and
+// were saved before the grace period (in seconds). With dry_run, the
+// assets are reported without being deleted.
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	  -d @- http://localhost:3000/ <<EOF
+//	{
+//		"action": "asset:gc",
+//		"api_key": "some-master-key",
+//		"dry_run": true,
+//		"grace_period": 86400
+//	}
+//	EOF
+//
+type AssetGCHandler struct {
+	Collector        *assetgc.Collector `inject:"AssetCollector"`
+	AccessKey        router.Processor   `preprocessor:"accesskey"`
+	RequireMasterKey router.Processor   `preprocessor:"require_master_key"`
+	DBConn           router.Processor   `preprocessor:"dbconn"`
+	preprocessors    []router.Processor
+}
+
+func (h *AssetGCHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.RequireMasterKey,
+		h.DBConn,
+	}
+}
+
+func (h *AssetGCHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *AssetGCHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := assetGCPayload{}
+	if skyErr := payload.Decode(rpayload.Data); skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	collector := *h.Collector
+	if payload.GracePeriod > 0 {
+		collector.GracePeriod = time.Duration(payload.GracePeriod) * time.Second
+	}
+
+	result, err := collector.Collect(rpayload.DBConn, payload.DryRun)
+	if err == assetgc.ErrDeleteNotSupported {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "Asset store does not support deleting files")
+		return
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = result
+}
@@ -20,11 +20,15 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/assetgc"
 	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -171,3 +175,171 @@ func TestAssetUploadHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestAssetUploadHandlerPolicy(t *testing.T) {
+	Convey("Asset Upload Handler with asset policy", t, func() {
+		uuidNew = func() string {
+			return "7b0e2a7c-7135-4912-a6c9-7c1dbec0f5ef"
+		}
+
+		assetDBConn := &saveAssetDBConn{}
+		assetDBConn.savedAsset = map[string]*skydb.Asset{}
+
+		assetRouter := handlertest.NewSingleRouteRouter(
+			&AssetUploadHandler{
+				AssetStore: generatePostFileRequestAssetStore{},
+				AssetPolicies: asset.Policies{
+					"note.image": asset.Policy{
+						ContentTypes: []string{"image/*"},
+						MaxSize:      1024,
+					},
+				},
+			},
+			func(p *router.Payload) {
+				p.DBConn = assetDBConn
+			},
+		)
+
+		Convey("rejects declared content type violating the policy", func() {
+			res := assetRouter.POST(`{
+        "filename": "file001",
+        "content-type": "text/plain",
+        "content-size": 100,
+        "record_type": "note",
+        "field": "image"
+      }`)
+
+			So(res.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 130,
+		"name": "AssetPolicyViolated",
+		"message": "Content type text/plain is not allowed",
+		"info": {
+			"field": "image",
+			"content_type": "text/plain",
+			"content_types": ["image/*"]
+		}
+	}
+}`)
+			So(assetDBConn.savedAsset, ShouldBeEmpty)
+		})
+
+		Convey("rejects declared size violating the policy", func() {
+			res := assetRouter.POST(`{
+        "filename": "file001",
+        "content-type": "image/png",
+        "content-size": 2048,
+        "record_type": "note",
+        "field": "image"
+      }`)
+
+			So(res.Code, ShouldEqual, http.StatusBadRequest)
+			So(assetDBConn.savedAsset, ShouldBeEmpty)
+		})
+
+		Convey("accepts asset conforming to the policy", func() {
+			res := assetRouter.POST(`{
+        "filename": "file001",
+        "content-type": "image/png",
+        "content-size": 100,
+        "record_type": "note",
+        "field": "image"
+      }`)
+
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(assetDBConn.savedAsset, ShouldContainKey, "7b0e2a7c-7135-4912-a6c9-7c1dbec0f5ef-file001")
+		})
+	})
+}
+
+type unreferencedAssetDBConn struct {
+	skydb.Conn
+	assets  []skydb.Asset
+	deleted []string
+}
+
+func (db *unreferencedAssetDBConn) PublicDB() skydb.Database {
+	return skydbtest.NewMockTxDatabase(nil)
+}
+
+func (db *unreferencedAssetDBConn) QueryUnreferencedAssets(savedBefore time.Time) ([]skydb.Asset, error) {
+	return db.assets, nil
+}
+
+func (db *unreferencedAssetDBConn) DeleteAsset(name string) error {
+	db.deleted = append(db.deleted, name)
+	return nil
+}
+
+type deleteFileAssetStore struct {
+	generatePostFileRequestAssetStore
+	deleted []string
+}
+
+func (s *deleteFileAssetStore) DeleteFile(name string) error {
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+func TestAssetGCHandler(t *testing.T) {
+	Convey("Asset GC Handler", t, func() {
+		conn := &unreferencedAssetDBConn{
+			assets: []skydb.Asset{
+				{Name: "unreferenced.png", ContentType: "image/png", Size: 10},
+			},
+		}
+		store := &deleteFileAssetStore{}
+
+		r := handlertest.NewSingleRouteRouter(
+			&AssetGCHandler{Collector: &assetgc.Collector{Store: store}},
+			func(p *router.Payload) {
+				p.DBConn = conn
+			},
+		)
+
+		Convey("reports unreferenced assets in dry run", func() {
+			resp := r.POST(`{"dry_run": true}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": true,
+					"assets": [{
+						"name": "unreferenced.png",
+						"content_type": "image/png",
+						"size": 10,
+						"deleted": false
+					}],
+					"deleted_count": 0,
+					"deleted_size": 0
+				}
+			}`)
+			So(conn.deleted, ShouldBeEmpty)
+			So(store.deleted, ShouldBeEmpty)
+		})
+
+		Convey("deletes unreferenced assets", func() {
+			resp := r.POST(`{}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"dry_run": false,
+					"assets": [{
+						"name": "unreferenced.png",
+						"content_type": "image/png",
+						"size": 10,
+						"deleted": true
+					}],
+					"deleted_count": 1,
+					"deleted_size": 10
+				}
+			}`)
+			So(conn.deleted, ShouldResemble, []string{"unreferenced.png"})
+			So(store.deleted, ShouldResemble, []string{"unreferenced.png"})
+		})
+
+		Convey("rejects negative grace period", func() {
+			resp := r.POST(`{"grace_period": -1}`)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
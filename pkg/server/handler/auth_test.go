@@ -927,6 +927,10 @@ func (conn *singleUserConn) GetRecordFieldAccess() (skydb.FieldACL, error) {
 	return skydb.FieldACL{}, nil
 }
 
+func (conn *singleUserConn) GetRecordHistoryTypes() ([]string, error) {
+	return []string{}, nil
+}
+
 func (conn *singleUserConn) EnsureAuthRecordKeysValid(authRecordKeys [][]string) error {
 	return nil
 }
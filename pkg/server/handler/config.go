@@ -0,0 +1,66 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// ConfigReloader reloads the configuration of the server.
+type ConfigReloader interface {
+	ReloadConfig() (skyconfig.ReloadReport, error)
+}
+
+// ConfigReloadHandler reloads the configuration of the server, and
+// reports the changed settings which are applied and those which require
+// restarting the server.
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	  -d @- http://localhost:3000/ <<EOF
+//	{
+//		"action": "config:reload",
+//		"api_key": "some-master-key"
+//	}
+//	EOF
+//
+type ConfigReloadHandler struct {
+	Reloader         ConfigReloader   `inject:"ConfigReloader"`
+	AccessKey        router.Processor `preprocessor:"accesskey"`
+	RequireMasterKey router.Processor `preprocessor:"require_master_key"`
+	preprocessors    []router.Processor
+}
+
+func (h *ConfigReloadHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.RequireMasterKey,
+	}
+}
+
+func (h *ConfigReloadHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *ConfigReloadHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	report, err := h.Reloader.ReloadConfig()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = report
+}
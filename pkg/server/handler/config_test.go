@@ -0,0 +1,75 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeConfigReloader struct {
+	report skyconfig.ReloadReport
+	err    error
+}
+
+func (r *fakeConfigReloader) ReloadConfig() (skyconfig.ReloadReport, error) {
+	return r.report, r.err
+}
+
+func TestConfigReloadHandler(t *testing.T) {
+	Convey("ConfigReloadHandler", t, func() {
+		reloader := &fakeConfigReloader{}
+		r := handlertest.NewSingleRouteRouter(&ConfigReloadHandler{
+			Reloader: reloader,
+		}, func(p *router.Payload) {})
+
+		Convey("reports changed settings", func() {
+			reloader.report = skyconfig.ReloadReport{
+				Applied:         []string{"App.CORSHost"},
+				RestartRequired: []string{"DB.Option"},
+			}
+
+			resp := r.POST(`{}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"applied": ["App.CORSHost"],
+					"restart_required": ["DB.Option"]
+				}
+			}`)
+		})
+
+		Convey("reports error of reloading", func() {
+			reloader.err = errors.New("cannot read APNS key")
+
+			resp := r.POST(`{}`)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 10000,
+					"message": "cannot read APNS key",
+					"name": "UnexpectedError"
+				}
+			}`)
+		})
+	})
+}
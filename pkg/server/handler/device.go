@@ -16,6 +16,7 @@ package handler
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
@@ -32,6 +33,7 @@ type deviceRegisterPayload struct {
 	Type        string
 	Topic       string
 	DeviceToken string `mapstructure:"device_token"`
+	TimeZone    string `mapstructure:"time_zone"`
 }
 
 func (payload *deviceRegisterPayload) Decode(data map[string]interface{}) skyerr.Error {
@@ -48,6 +50,12 @@ func (payload *deviceRegisterPayload) Validate() skyerr.Error {
 		return skyerr.NewInvalidArgument(fmt.Sprintf("unknown device type = %v", payload.Type), []string{"type"})
 	}
 
+	if payload.TimeZone != "" {
+		if _, err := time.LoadLocation(payload.TimeZone); err != nil {
+			return skyerr.NewInvalidArgument(fmt.Sprintf("unknown time zone = %v", payload.TimeZone), []string{"time_zone"})
+		}
+	}
+
 	return nil
 }
 
@@ -87,7 +95,8 @@ type DeviceReigsterResult struct {
 //		"access_token": "some-access-token",
 //		"type": "ios",
 //		"topic": "io.skygear.sample.topic",
-//		"device_token": "some-device-token"
+//		"device_token": "some-device-token",
+//		"time_zone": "Asia/Hong_Kong"
 //	}
 //	EOF
 //
@@ -172,6 +181,9 @@ func (h *DeviceRegisterHandler) Handle(rpayload *router.Payload, response *route
 	device.Type = payload.Type
 	device.Token = payload.DeviceToken
 	device.Topic = payload.Topic
+	if payload.TimeZone != "" {
+		device.TimeZone = payload.TimeZone
+	}
 	device.AuthInfoID = rpayload.AuthInfoID
 	device.LastRegisteredAt = timeNow()
 
@@ -197,6 +197,34 @@ func TestDeviceRegisterHandler(t *testing.T) {
 			So(conn.devices["existing_id"], ShouldResemble, skydb.Device{})
 		})
 
+		Convey("creates new device with time zone", func() {
+			payload.Data = map[string]interface{}{
+				"type":         "ios",
+				"device_token": "some-awesome-token",
+				"time_zone":    "Asia/Hong_Kong",
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			result := resp.Result.(DeviceReigsterResult)
+			So(conn.devices[result.ID].TimeZone, ShouldEqual, "Asia/Hong_Kong")
+		})
+
+		Convey("complains on unknown time zone", func() {
+			payload.Data = map[string]interface{}{
+				"type":         "ios",
+				"device_token": "token",
+				"time_zone":    "Mars/Olympus_Mons",
+			}
+
+			handler := &DeviceRegisterHandler{}
+			handler.Handle(&payload, &resp)
+
+			err := resp.Err.(skyerr.Error)
+			So(err, ShouldResemble, skyerr.NewInvalidArgument("unknown time zone = Mars/Olympus_Mons", []string{"time_zone"}))
+		})
+
 		Convey("complains on empty device type", func() {
 			payload.Data = map[string]interface{}{
 				"device_token": "token",
@@ -15,6 +15,9 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -32,6 +35,8 @@ import (
 
 	skyAsset "github.com/skygeario/skygear-server/pkg/server/asset"
 	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
@@ -48,16 +53,16 @@ func clean(p string) string {
 	return sanitized
 }
 
-func validateAssetGetRequest(assetStore skyAsset.Store, fileName string, expiredAtUnix int64, signature string) skyerr.Error {
+func validateAssetGetRequest(assetStore skyAsset.Store, fileName string, transform skyAsset.ImageTransform, expiredAtUnix int64, signature string) skyerr.Error {
 	// check whether the request is expired
 	expiredAt := time.Unix(expiredAtUnix, 0)
 	if timeNow().After(expiredAt) {
 		return skyerr.NewError(skyerr.PermissionDenied, "Access denied")
 	}
 
-	// check the signature of the URL
+	// check the signature of the URL, which covers the image transform
 	signatureParser := assetStore.(skyAsset.SignatureParser)
-	valid, err := signatureParser.ParseSignature(signature, fileName, expiredAt)
+	valid, err := signatureParser.ParseSignature(signature, transform.SignedName(fileName), expiredAt)
 	if err != nil {
 		logrus.WithError(err).Errorf("Failed to parse signature")
 
@@ -104,6 +109,17 @@ func parseRangeHeader(rangeHeader string) (skyAsset.FileRange, error) {
 }
 
 // GetFileHandler models the handler for getting asset file
+//
+// An image asset can be transformed by specifying query parameters
+// `width`, `height`, `fit` (contain, cover or fill), `format` and
+// `quality`. For a private asset store, the transform parameters are
+// covered by the URL signature. The output format can be jpeg, png or
+// webp, which is encoded losslessly. Images larger than
+// asset.MaxImageTransformSourcePixels are not transformed.
+//
+// Example curl:
+//	curl 'http://localhost:3000/files/image.jpg?width=100&height=100&fit=cover'
+//
 type GetFileHandler struct {
 	AssetStore    skyAsset.Store   `inject:"AssetStore"`
 	DBConn        router.Processor `preprocessor:"dbconn"`
@@ -129,6 +145,12 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 
 	store := h.AssetStore
 	fileName := clean(payload.Params[0])
+	transform, err := skyAsset.ParseImageTransform(payload.Req.Form)
+	if err != nil {
+		response.Err = skyerr.NewError(skyerr.InvalidArgument, err.Error())
+		return
+	}
+
 	if store.(skyAsset.URLSigner).IsSignatureRequired() {
 		expiredAtUnix, err := strconv.ParseInt(payload.Req.Form.Get("expiredAt"), 10, 64)
 		if err != nil {
@@ -137,7 +159,7 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 		}
 
 		signature := payload.Req.Form.Get("signature")
-		requestErr := validateAssetGetRequest(h.AssetStore, fileName, expiredAtUnix, signature)
+		requestErr := validateAssetGetRequest(h.AssetStore, fileName, transform, expiredAtUnix, signature)
 		if requestErr != nil {
 			response.Err = requestErr
 			return
@@ -153,6 +175,11 @@ func (h *GetFileHandler) Handle(payload *router.Payload, response *router.Respon
 		return
 	}
 
+	if !transform.IsZero() {
+		h.handleTransformRequest(asset, transform, response, logger)
+		return
+	}
+
 	rangeHeader := payload.Req.Header.Get("Range")
 	if rangeHeader != "" {
 		byteRange, err := parseRangeHeader(payload.Req.Header.Get("Range"))
@@ -261,6 +288,102 @@ func (h *GetFileHandler) handleFullRangedRequest(
 	}
 }
 
+// handleTransformRequest writes the transformed image of the asset.
+// The transformed image is cached in the asset store, and is read from the
+// cache on subsequent requests of the same transform.
+func (h *GetFileHandler) handleTransformRequest(
+	asset *skydb.Asset,
+	transform skyAsset.ImageTransform,
+	response *router.Response,
+	logger *logrus.Entry,
+) {
+	store := h.AssetStore
+	fileName := asset.Name
+	cachedName := transform.CachedName(fileName)
+
+	if reader, err := store.GetFileReader(cachedName); err == nil {
+		defer reader.Close()
+		h.writeTransformedImage(bufio.NewReader(reader), response, logger)
+		return
+	}
+
+	if !strings.HasPrefix(asset.ContentType, "image/") {
+		response.Err = skyerr.NewError(
+			skyerr.InvalidArgument,
+			"Only image asset can be transformed",
+		)
+		return
+	}
+
+	reader, err := store.GetFileReader(fileName)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to get file reader")
+
+		response.Err = skyerr.NewResourceFetchFailureErr("asset", fileName)
+		return
+	}
+	defer reader.Close()
+
+	data, contentType, err := skyAsset.TransformImage(reader, transform)
+	if err == skyAsset.ErrImageFormatNotSupported {
+		response.Err = skyerr.NewError(skyerr.NotSupported, err.Error())
+		return
+	} else if err == skyAsset.ErrImageTooLarge {
+		response.Err = skyerr.NewError(skyerr.InvalidArgument, err.Error())
+		return
+	} else if err != nil {
+		logger.WithError(err).Errorf("Failed to transform image")
+
+		response.Err = skyerr.NewError(skyerr.UnexpectedError, "Failed to transform image")
+		return
+	}
+
+	if err := store.PutFileReader(
+		cachedName,
+		bytes.NewReader(data),
+		int64(len(data)),
+		contentType,
+	); err != nil {
+		// the transformed image is still served if it cannot be cached
+		logger.WithError(err).Warnf("Failed to cache transformed image")
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if _, err := writer.Write(data); err != nil {
+		logger.WithError(err).Errorf("Error writing file to response")
+	}
+}
+
+func (h *GetFileHandler) writeTransformedImage(
+	reader *bufio.Reader,
+	response *router.Response,
+	logger *logrus.Entry,
+) {
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+
+	// content type of the cached image is detected from its content
+	// since asset store does not keep the content type
+	head, _ := reader.Peek(512)
+	writer.Header().Set("Content-Type", http.DetectContentType(head))
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		// there is nothing we can do if error occurred after started
+		// writing a response. Log.
+		logger.WithError(err).Errorf("Error writing file to response")
+	}
+}
+
 // UploadFileHandler receives and persists a file to be associated by Record.
 //
 // Example curl (PUT):
@@ -276,10 +399,15 @@ func (h *GetFileHandler) handleFullRangedRequest(
 //    -F 'file=@file.txt' \
 //    http://localhost:3000/files/filename
 //
+// If the record_type and field query parameters are specified, the file
+// is checked against the asset policy of the record field.
+//
 type UploadFileHandler struct {
-	AssetStore    skyAsset.Store   `inject:"AssetStore"`
-	AccessKey     router.Processor `preprocessor:"accesskey"`
-	DBConn        router.Processor `preprocessor:"dbconn"`
+	AssetStore    skyAsset.Store    `inject:"AssetStore"`
+	AssetPolicies skyAsset.Policies `inject:"AssetPolicies"`
+	HookRegistry  *hook.Registry    `inject:"HookRegistry"`
+	AccessKey     router.Processor  `preprocessor:"accesskey"`
+	DBConn        router.Processor  `preprocessor:"dbconn"`
 	preprocessors []router.Processor
 }
 
@@ -338,6 +466,17 @@ func (h *UploadFileHandler) Handle(
 		return
 	}
 
+	if field, policy, ok := uploadAssetPolicy(h.AssetPolicies, payload.Req); ok {
+		if _, err := policy.Check(tempFile, written); err != nil {
+			response.Err = recordutil.NewAssetPolicyError(field, err)
+			return
+		}
+		if _, err := tempFile.Seek(0, 0); err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+	}
+
 	asset := skydb.Asset{}
 	conn := payload.DBConn
 	if err := conn.GetAsset(uploadRequest.filename, &asset); err != nil {
@@ -362,6 +501,11 @@ func (h *UploadFileHandler) Handle(
 	}
 
 	asset.Size = written
+	if err := scanUploadedAsset(payload.Context(), h.HookRegistry, assetStore, &asset); err != nil {
+		response.Err = err
+		return
+	}
+
 	if err := conn.SaveAsset(&asset); err != nil {
 		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("asset", asset.Name)
 		return
@@ -433,6 +577,56 @@ func parseUploadFileRequest(payload *router.Payload) (*uploadFileRequest, error)
 	}, nil
 }
 
+// uploadAssetPolicy returns the asset policy of the record field specified
+// by the record_type and field query parameters of an upload request.
+//
+// Checking an upload against the policy rejects a violating file early.
+// The policy is enforced again when the asset is saved to the record.
+func uploadAssetPolicy(policies skyAsset.Policies, req *http.Request) (string, skyAsset.Policy, bool) {
+	query := req.URL.Query()
+	recordType := query.Get("record_type")
+	field := query.Get("field")
+	if recordType == "" || field == "" {
+		return "", skyAsset.Policy{}, false
+	}
+
+	policy, ok := policies.Get(recordType, field)
+	return field, policy, ok
+}
+
+// scanUploadedAsset executes the asset hooks on a file put to the asset
+// store before the asset is saved. The file is deleted if it is rejected.
+func scanUploadedAsset(ctx context.Context, registry *hook.Registry, store skyAsset.Store, asset *skydb.Asset) skyerr.Error {
+	if registry == nil {
+		return nil
+	}
+
+	scanned := *asset
+	if signer, ok := store.(skyAsset.URLSigner); ok {
+		scanned.Signer = signer
+	}
+
+	if err := registry.ExecuteAssetHooks(ctx, hook.BeforeAssetSave, &scanned); err != nil {
+		discardUploadedFile(ctx, store, asset.Name)
+		return err
+	}
+	return nil
+}
+
+// discardUploadedFile deletes a file put to the asset store if the store
+// supports deleting files.
+func discardUploadedFile(ctx context.Context, store skyAsset.Store, name string) {
+	deleter, ok := store.(skyAsset.FileDeleter)
+	if !ok {
+		return
+	}
+
+	if err := deleter.DeleteFile(name); err != nil {
+		logger := logging.CreateLogger(ctx, "handler")
+		logger.WithError(err).Warnf("Failed to delete rejected file %s", name)
+	}
+}
+
 func copyToTempFile(src io.Reader) (written int64, tempFile *os.File, err error) {
 	tempFile, err = ioutil.TempFile("", "")
 	if err != nil {
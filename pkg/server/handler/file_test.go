@@ -16,7 +16,11 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -26,8 +30,10 @@ import (
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/skygeario/skygear-server/pkg/server/skytest"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -264,6 +270,96 @@ func TestUploadFileHandler(t *testing.T) {
 	})
 }
 
+func TestUploadFileHandlerAssetPolicy(t *testing.T) {
+	Convey("UploadFileHandler with asset policy", t, func() {
+		assetConn := &naiveAssetConn{}
+		assetConn.savedAsset = map[string]*skydb.Asset{}
+
+		store := &mapAssetStore{files: map[string][]byte{}}
+		registry := hook.NewRegistry()
+
+		r := newmodGateway("(.+)")
+		r.Handle("PUT", &UploadFileHandler{
+			AssetStore: store,
+			AssetPolicies: asset.Policies{
+				"note.image": asset.Policy{ContentTypes: []string{"image/*"}},
+			},
+			HookRegistry: registry,
+		}, func(p *router.Payload) {
+			p.DBConn = assetConn
+		})
+
+		// assets are created by asset:put before uploading
+		for _, name := range []string{"image.png", "file.txt"} {
+			assetConn.savedAsset[name] = &skydb.Asset{Name: name}
+		}
+
+		imageData := &bytes.Buffer{}
+		png.Encode(imageData, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+
+		Convey("uploads a file allowed by the policy", func() {
+			req, _ := http.NewRequest(
+				"PUT",
+				"http://skygear.test/image.png?record_type=note&field=image",
+				bytes.NewReader(imageData.Bytes()),
+			)
+			req.Header.Set("Content-Type", "image/png")
+			resp := r.Do(req)
+
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(assetConn.savedAsset["image.png"].Size, ShouldEqual, imageData.Len())
+		})
+
+		Convey("rejects a file with disguised content type", func() {
+			req, _ := http.NewRequest(
+				"PUT",
+				"http://skygear.test/image.png?record_type=note&field=image",
+				strings.NewReader("<html><body></body></html>"),
+			)
+			req.Header.Set("Content-Type", "image/png")
+			resp := r.Do(req)
+
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 130,
+					"name": "AssetPolicyViolated",
+					"message": "Content type text/html is not allowed",
+					"info": {
+						"field": "image",
+						"content_type": "text/html",
+						"content_types": ["image/*"]
+					}
+				}
+			}`)
+			So(store.files, ShouldBeEmpty)
+			So(assetConn.savedAsset["image.png"].Size, ShouldEqual, 0)
+		})
+
+		Convey("deletes a file rejected by asset hook", func() {
+			var scanned *skydb.Asset
+			registry.RegisterAssetHook(hook.BeforeAssetSave, func(ctx context.Context, asset *skydb.Asset) skyerr.Error {
+				scanned = asset
+				return skyerr.NewError(skyerr.AssetPolicyViolated, "infected")
+			})
+
+			req, _ := http.NewRequest(
+				"PUT",
+				"http://skygear.test/file.txt",
+				strings.NewReader("I am a virus"),
+			)
+			req.Header.Set("Content-Type", "text/plain")
+			resp := r.Do(req)
+
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(scanned.Name, ShouldEqual, "file.txt")
+			So(scanned.Size, ShouldEqual, 12)
+			So(store.files, ShouldBeEmpty)
+			So(assetConn.savedAsset["file.txt"].Size, ShouldEqual, 0)
+		})
+	})
+}
+
 type naiveStoreSignatureParser struct {
 	valid     bool
 	signed    string
@@ -335,6 +431,27 @@ func TestGetFileHandler(t *testing.T) {
 			So(signparser.expiredAt.Unix(), ShouldEqual, 1436431130)
 		})
 
+		Convey("GET a signed URL with transform", func() {
+			realTimeNow := timeNow
+			timeNow = func() time.Time {
+				return time.Unix(1436431129, 999)
+			}
+			defer func() {
+				timeNow = realTimeNow
+			}()
+			signparser.valid = true
+			assetConn.savedAsset["assetName"] = &skydb.Asset{
+				Name:        "assetName",
+				ContentType: "image/png",
+				Size:        10,
+			}
+
+			r.GET("assetName?width=10&fit=cover&signature=signedSignature&expiredAt=1436431130")
+			So(signparser.signed, ShouldEqual, "signedSignature")
+			So(signparser.name, ShouldEqual, "assetName?fit=cover&width=10")
+			So(signparser.expiredAt.Unix(), ShouldEqual, 1436431130)
+		})
+
 		Convey("errors if signature expired", func() {
 			realTimeNow := timeNow
 			timeNow = func() time.Time {
@@ -374,3 +491,116 @@ func TestGetFileHandler(t *testing.T) {
 		})
 	})
 }
+
+type mapAssetStore struct {
+	files map[string][]byte
+	asset.Store
+}
+
+func (store *mapAssetStore) GetFileReader(name string) (io.ReadCloser, error) {
+	data, ok := store.files[name]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (store *mapAssetStore) PutFileReader(name string, src io.Reader, length int64, contentType string) error {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	store.files[name] = data
+	return nil
+}
+
+func (store *mapAssetStore) DeleteFile(name string) error {
+	delete(store.files, name)
+	return nil
+}
+
+func (store *mapAssetStore) SignedURL(name string) (string, error) {
+	return name, nil
+}
+
+func (store *mapAssetStore) IsSignatureRequired() bool {
+	return false
+}
+
+func TestGetFileHandlerTransform(t *testing.T) {
+	Convey("GetFileHandler with transform", t, func() {
+		assetConn := &naiveAssetConn{}
+		assetConn.savedAsset = map[string]*skydb.Asset{}
+
+		store := &mapAssetStore{files: map[string][]byte{}}
+
+		r := newmodGateway("(.+)")
+		r.Handle("GET", &GetFileHandler{
+			AssetStore: store,
+		}, func(p *router.Payload) {
+			p.DBConn = assetConn
+		})
+
+		m := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+		buf := bytes.Buffer{}
+		png.Encode(&buf, m)
+		store.files["image.png"] = buf.Bytes()
+		assetConn.savedAsset["image.png"] = &skydb.Asset{
+			Name:        "image.png",
+			ContentType: "image/png",
+			Size:        int64(buf.Len()),
+		}
+
+		Convey("transforms and caches the image", func() {
+			resp := r.GET("image.png?width=20&format=jpeg")
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "image/jpeg")
+
+			transformed, err := jpeg.Decode(bytes.NewReader(resp.Body.Bytes()))
+			So(err, ShouldBeNil)
+			So(transformed.Bounds(), ShouldResemble, image.Rect(0, 0, 20, 10))
+
+			cachedName := asset.ImageTransform{Width: 20, Format: "jpeg"}.CachedName("image.png")
+			So(store.files[cachedName], ShouldResemble, resp.Body.Bytes())
+		})
+
+		Convey("serves the cached image", func() {
+			transform := asset.ImageTransform{Width: 20}
+			store.files[transform.CachedName("image.png")] = []byte("\x89PNG\r\n\x1a\ncached")
+
+			resp := r.GET("image.png?width=20")
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "image/png")
+			So(resp.Body.String(), ShouldEqual, "\x89PNG\r\n\x1a\ncached")
+		})
+
+		Convey("errors on invalid transform", func() {
+			resp := r.GET("image.png?width=abc")
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "expect width to be an integer between 1 and 4096"
+				}
+			}`)
+		})
+
+		Convey("errors on transforming non-image asset", func() {
+			store.files["text.txt"] = []byte("I am a boy")
+			assetConn.savedAsset["text.txt"] = &skydb.Asset{
+				Name:        "text.txt",
+				ContentType: "text/plain",
+				Size:        10,
+			}
+
+			resp := r.GET("text.txt?width=20")
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"name": "InvalidArgument",
+					"message": "Only image asset can be transformed"
+				}
+			}`)
+		})
+	})
+}
@@ -17,6 +17,7 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
@@ -25,6 +26,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
 )
 
 // Remarks: this variable is for mocking in test cases
@@ -70,10 +72,110 @@ func (e *sendPushResponseItem) MarshalJSON() ([]byte, error) {
 	}{e.id})
 }
 
+// localTimeLayout is the layout of send_at without time zone offset, which
+// is accepted when the notification is sent at device local time.
+const localTimeLayout = "2006-01-02T15:04:05"
+
+type pushSchedulePayload struct {
+	SendAt    string `mapstructure:"send_at"`
+	LocalTime bool   `mapstructure:"local_time"`
+	TimeZone  string `mapstructure:"time_zone"`
+	sendAt    time.Time
+}
+
+func (payload *pushSchedulePayload) Validate() skyerr.Error {
+	if payload.SendAt == "" {
+		if payload.LocalTime {
+			return skyerr.NewInvalidArgument("local_time requires send_at", []string{"send_at"})
+		}
+		return nil
+	}
+
+	sendAt, err := time.Parse(time.RFC3339, payload.SendAt)
+	if err != nil && payload.LocalTime {
+		sendAt, err = time.Parse(localTimeLayout, payload.SendAt)
+	}
+	if err != nil {
+		return skyerr.NewInvalidArgument("send_at is not a valid datetime", []string{"send_at"})
+	}
+
+	if payload.TimeZone != "" {
+		if _, err := time.LoadLocation(payload.TimeZone); err != nil {
+			return skyerr.NewInvalidArgument(fmt.Sprintf("unknown time zone = %v", payload.TimeZone), []string{"time_zone"})
+		}
+	}
+
+	if payload.LocalTime {
+		// keep the wall-clock time only, it is interpreted in the time zone
+		// of each device when the notification is sent
+		payload.sendAt = time.Date(
+			sendAt.Year(), sendAt.Month(), sendAt.Day(),
+			sendAt.Hour(), sendAt.Minute(), sendAt.Second(), 0,
+			time.UTC,
+		)
+	} else {
+		payload.sendAt = sendAt.UTC()
+	}
+	return nil
+}
+
+func (payload *pushSchedulePayload) IsScheduled() bool {
+	return payload.SendAt != ""
+}
+
+type scheduledPushResult struct {
+	ID        string `json:"id"`
+	SendAt    string `json:"send_at"`
+	LocalTime bool   `json:"local_time"`
+	Status    string `json:"status"`
+}
+
+func newScheduledPushResult(p skydb.ScheduledPush) scheduledPushResult {
+	sendAt := p.SendAt.Format(time.RFC3339)
+	if p.LocalTime {
+		sendAt = p.SendAt.Format(localTimeLayout)
+	}
+	return scheduledPushResult{
+		ID:        p.ID,
+		SendAt:    sendAt,
+		LocalTime: p.LocalTime,
+		Status:    string(p.Status),
+	}
+}
+
+func schedulePush(
+	conn skydb.Conn,
+	targetType skydb.PushTargetType,
+	targetIDs []string,
+	topic string,
+	notification map[string]interface{},
+	schedule pushSchedulePayload,
+) (*skydb.ScheduledPush, skyerr.Error) {
+	p := skydb.ScheduledPush{
+		ID:           uuid.New(),
+		TargetType:   targetType,
+		TargetIDs:    targetIDs,
+		Topic:        topic,
+		Notification: notification,
+		SendAt:       schedule.sendAt,
+		LocalTime:    schedule.LocalTime,
+		TimeZone:     schedule.TimeZone,
+		Status:       skydb.ScheduledPushPending,
+		CreatedAt:    timeNow(),
+	}
+
+	if err := conn.SaveScheduledPush(&p); err != nil {
+		logrus.Errorf("Failed to save scheduled push: %v", err)
+		return nil, skyerr.NewResourceSaveFailureErrWithStringID("scheduled push", p.ID)
+	}
+	return &p, nil
+}
+
 type pushToUserPayload struct {
-	UserIDs      []string               `mapstructure:"user_ids"`
-	Topic        string                 `mapstructure:"topic"`
-	Notification map[string]interface{} `mapstructure:"notification"`
+	UserIDs             []string               `mapstructure:"user_ids"`
+	Topic               string                 `mapstructure:"topic"`
+	Notification        map[string]interface{} `mapstructure:"notification"`
+	pushSchedulePayload `mapstructure:",squash"`
 }
 
 func (payload *pushToUserPayload) Decode(data map[string]interface{}) skyerr.Error {
@@ -90,7 +192,7 @@ func (payload *pushToUserPayload) Validate() skyerr.Error {
 	if payload.Notification == nil {
 		return skyerr.NewInvalidArgument("no notification specified", []string{"notification"})
 	}
-	return nil
+	return payload.pushSchedulePayload.Validate()
 }
 
 type PushToUserHandler struct {
@@ -126,6 +228,17 @@ func (h *PushToUserHandler) Handle(rpayload *router.Payload, response *router.Re
 	}
 
 	conn := rpayload.DBConn
+	if payload.IsScheduled() {
+		p, err := schedulePush(conn, skydb.PushTargetUser, payload.UserIDs,
+			payload.Topic, payload.Notification, payload.pushSchedulePayload)
+		if err != nil {
+			response.Err = err
+			return
+		}
+		response.Result = newScheduledPushResult(*p)
+		return
+	}
+
 	resultItems := make([]sendPushResponseItem, len(payload.UserIDs))
 	for i, userID := range payload.UserIDs {
 		resultItems[i].id = userID
@@ -157,9 +270,10 @@ func (h *PushToUserHandler) Handle(rpayload *router.Payload, response *router.Re
 }
 
 type pushToDevicePayload struct {
-	DeviceIDs    []string               `mapstructure:"device_ids"`
-	Topic        string                 `mapstructure:"topic"`
-	Notification map[string]interface{} `mapstructure:"notification"`
+	DeviceIDs           []string               `mapstructure:"device_ids"`
+	Topic               string                 `mapstructure:"topic"`
+	Notification        map[string]interface{} `mapstructure:"notification"`
+	pushSchedulePayload `mapstructure:",squash"`
 }
 
 func (payload *pushToDevicePayload) Decode(data map[string]interface{}) skyerr.Error {
@@ -176,7 +290,7 @@ func (payload *pushToDevicePayload) Validate() skyerr.Error {
 	if payload.Notification == nil {
 		return skyerr.NewInvalidArgument("no notification specified", []string{"notification"})
 	}
-	return nil
+	return payload.pushSchedulePayload.Validate()
 }
 
 type PushToDeviceHandler struct {
@@ -212,6 +326,17 @@ func (h *PushToDeviceHandler) Handle(rpayload *router.Payload, response *router.
 	}
 
 	conn := rpayload.DBConn
+	if payload.IsScheduled() {
+		p, err := schedulePush(conn, skydb.PushTargetDevice, payload.DeviceIDs,
+			payload.Topic, payload.Notification, payload.pushSchedulePayload)
+		if err != nil {
+			response.Err = err
+			return
+		}
+		response.Result = newScheduledPushResult(*p)
+		return
+	}
+
 	resultItems := []sendPushResponseItem{}
 	for _, deviceID := range payload.DeviceIDs {
 		device := skydb.Device{}
@@ -230,3 +355,92 @@ func (h *PushToDeviceHandler) Handle(rpayload *router.Payload, response *router.
 	}
 	response.Result = resultItems
 }
+
+type pushCancelPayload struct {
+	ID string `mapstructure:"id"`
+}
+
+func (payload *pushCancelPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *pushCancelPayload) Validate() skyerr.Error {
+	if payload.ID == "" {
+		return skyerr.NewInvalidArgument("empty scheduled push id", []string{"id"})
+	}
+	return nil
+}
+
+// PushCancelHandler cancels a scheduled push notification which is not
+// yet sent to all recipients. A push being sent is not sent to the rest of
+// the recipients.
+//
+//	curl -X POST -H "Content-Type: application/json" \
+//	  -d @- http://localhost:3000/ <<EOF
+//	{
+//		"action": "push:cancel",
+//		"api_key": "some-master-key",
+//		"id": "some-scheduled-push-id"
+//	}
+//	EOF
+type PushCancelHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	Notification  router.Processor `preprocessor:"notification"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *PushCancelHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DBConn,
+		h.InjectDB,
+		h.Notification,
+		h.PluginReady,
+	}
+}
+
+func (h *PushCancelHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *PushCancelHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := pushCancelPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	// The push is cancelled in one conditional update, so that a push
+	// sent meanwhile by the scheduler is not marked cancelled.
+	conn := rpayload.DBConn
+	p := skydb.ScheduledPush{}
+	if err := conn.CancelScheduledPush(payload.ID, &p); err == skydb.ErrScheduledPushNotFound {
+		response.Err = cancelScheduledPushError(conn, payload.ID)
+		return
+	} else if err != nil {
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("scheduled push", payload.ID)
+		return
+	}
+
+	response.Result = newScheduledPushResult(p)
+}
+
+// cancelScheduledPushError returns the error of cancelling the scheduled
+// push which is not pending or dispatching.
+func cancelScheduledPushError(conn skydb.Conn, id string) skyerr.Error {
+	p := skydb.ScheduledPush{}
+	if err := conn.GetScheduledPush(id, &p); err == skydb.ErrScheduledPushNotFound {
+		return skyerr.NewErrorWithInfo(skyerr.ResourceNotFound, fmt.Sprintf(`cannot find scheduled push "%s"`, id), map[string]interface{}{"id": id})
+	} else if err != nil {
+		return skyerr.NewResourceFetchFailureErr("scheduled push", id)
+	}
+
+	return skyerr.NewErrorWithInfo(skyerr.ResourceConflict, fmt.Sprintf("scheduled push is already %s", p.Status), map[string]interface{}{"id": id})
+}
@@ -16,6 +16,7 @@ package handler
 
 import (
 	"testing"
+	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
 	. "github.com/skygeario/skygear-server/pkg/server/skytest"
@@ -108,6 +109,137 @@ func TestPushToDevice(t *testing.T) {
 }`)
 			So(called, ShouldBeFalse)
 		})
+
+		Convey("schedule push to device", func() {
+			realTimeNow := timeNow
+			timeNow = func() time.Time { return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC) }
+			defer func() {
+				timeNow = realTimeNow
+			}()
+
+			called := false
+			sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
+				called = true
+			}
+
+			resp := r.POST(`{
+					"device_ids": ["device"],
+					"notification": {
+						"aps": {
+							"alert": "This is a message."
+						}
+					},
+					"send_at": "2006-01-03T08:00:00+08:00"
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(called, ShouldBeFalse)
+			So(conn.scheduledPushes, ShouldHaveLength, 1)
+
+			p := conn.scheduledPushes[conn.lastScheduledPushID]
+			So(p, ShouldResemble, skydb.ScheduledPush{
+				ID:         p.ID,
+				TargetType: skydb.PushTargetDevice,
+				TargetIDs:  []string{"device"},
+				Notification: map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": "This is a message.",
+					},
+				},
+				SendAt:    time.Date(2006, 1, 3, 0, 0, 0, 0, time.UTC),
+				Status:    skydb.ScheduledPushPending,
+				CreatedAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			})
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"id": "`+p.ID+`",
+		"send_at": "2006-01-03T00:00:00Z",
+		"local_time": false,
+		"status": "pending"
+	}
+}`)
+		})
+
+		Convey("schedule push to device at local time", func() {
+			resp := r.POST(`{
+					"device_ids": ["device"],
+					"notification": {
+						"aps": {
+							"alert": "This is a message."
+						}
+					},
+					"send_at": "2006-01-03T08:00:00",
+					"local_time": true,
+					"time_zone": "Asia/Hong_Kong"
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(conn.scheduledPushes, ShouldHaveLength, 1)
+
+			p := conn.scheduledPushes[conn.lastScheduledPushID]
+			So(p.LocalTime, ShouldBeTrue)
+			So(p.TimeZone, ShouldEqual, "Asia/Hong_Kong")
+			So(p.SendAt, ShouldResemble, time.Date(2006, 1, 3, 8, 0, 0, 0, time.UTC))
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"id": "`+p.ID+`",
+		"send_at": "2006-01-03T08:00:00",
+		"local_time": true,
+		"status": "pending"
+	}
+}`)
+		})
+
+		Convey("complains on invalid send_at", func() {
+			resp := r.POST(`{
+					"device_ids": ["device"],
+					"notification": {},
+					"send_at": "tomorrow"
+				}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "send_at is not a valid datetime",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["send_at"]}
+	}
+}`)
+		})
+
+		Convey("complains on local_time without send_at", func() {
+			resp := r.POST(`{
+					"device_ids": ["device"],
+					"notification": {},
+					"local_time": true
+				}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "local_time requires send_at",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["send_at"]}
+	}
+}`)
+		})
+
+		Convey("complains on unknown time zone", func() {
+			resp := r.POST(`{
+					"device_ids": ["device"],
+					"notification": {},
+					"send_at": "2006-01-03T08:00:00",
+					"local_time": true,
+					"time_zone": "Mars/Olympus_Mons"
+				}`)
+			So(resp.Code, ShouldEqual, 400)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "unknown time zone = Mars/Olympus_Mons",
+		"name": "InvalidArgument",
+		"info": {"arguments": ["time_zone"]}
+	}
+}`)
+		})
 	})
 
 }
@@ -206,15 +338,152 @@ func TestPushToUser(t *testing.T) {
 }`)
 			So(called, ShouldBeFalse)
 		})
+
+		Convey("schedule push to user", func() {
+			called := false
+			sendPushNotification = func(sender push.Sender, device skydb.Device, m push.Mapper) {
+				called = true
+			}
+
+			resp := r.POST(`{
+					"user_ids": ["johndoe"],
+					"topic": "some-topic",
+					"notification": {
+						"acme": "interesting"
+					},
+					"send_at": "2006-01-03T00:00:00Z"
+				}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(called, ShouldBeFalse)
+			So(conn.scheduledPushes, ShouldHaveLength, 1)
+
+			p := conn.scheduledPushes[conn.lastScheduledPushID]
+			So(p.TargetType, ShouldEqual, skydb.PushTargetUser)
+			So(p.TargetIDs, ShouldResemble, []string{"johndoe"})
+			So(p.Topic, ShouldEqual, "some-topic")
+			So(p.SendAt, ShouldResemble, time.Date(2006, 1, 3, 0, 0, 0, 0, time.UTC))
+			So(p.Status, ShouldEqual, skydb.ScheduledPushPending)
+		})
 	})
 
 }
 
+func TestPushCancel(t *testing.T) {
+	Convey("cancel scheduled push", t, func() {
+		conn := simpleDeviceConn{
+			scheduledPushes: map[string]skydb.ScheduledPush{
+				"pending": skydb.ScheduledPush{
+					ID:         "pending",
+					TargetType: skydb.PushTargetUser,
+					TargetIDs:  []string{"johndoe"},
+					SendAt:     time.Date(2006, 1, 3, 0, 0, 0, 0, time.UTC),
+					Status:     skydb.ScheduledPushPending,
+				},
+				"dispatching": skydb.ScheduledPush{
+					ID:         "dispatching",
+					TargetType: skydb.PushTargetUser,
+					TargetIDs:  []string{"johndoe"},
+					SendAt:     time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+					Status:     skydb.ScheduledPushDispatching,
+				},
+				"sent": skydb.ScheduledPush{
+					ID:         "sent",
+					TargetType: skydb.PushTargetUser,
+					TargetIDs:  []string{"johndoe"},
+					SendAt:     time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+					Status:     skydb.ScheduledPushSent,
+				},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&PushCancelHandler{}, func(p *router.Payload) {
+			p.DBConn = &conn
+		})
+
+		Convey("cancel pending push", func() {
+			resp := r.POST(`{"id": "pending"}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"id": "pending",
+		"send_at": "2006-01-03T00:00:00Z",
+		"local_time": false,
+		"status": "cancelled"
+	}
+}`)
+			So(conn.scheduledPushes["pending"].Status, ShouldEqual, skydb.ScheduledPushCancelled)
+		})
+
+		Convey("cancel push being sent", func() {
+			resp := r.POST(`{"id": "dispatching"}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(conn.scheduledPushes["dispatching"].Status, ShouldEqual, skydb.ScheduledPushCancelled)
+		})
+
+		Convey("complains on sent push", func() {
+			resp := r.POST(`{"id": "sent"}`)
+			So(resp.Code, ShouldEqual, 409)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 132,
+		"message": "scheduled push is already sent",
+		"name": "ResourceConflict",
+		"info": {"id": "sent"}
+	}
+}`)
+			So(conn.scheduledPushes["sent"].Status, ShouldEqual, skydb.ScheduledPushSent)
+		})
+
+		Convey("complains on non-existent push", func() {
+			resp := r.POST(`{"id": "nonexistent"}`)
+			So(resp.Code, ShouldEqual, 404)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "cannot find scheduled push \"nonexistent\"",
+		"name": "ResourceNotFound",
+		"info": {"id": "nonexistent"}
+	}
+}`)
+		})
+	})
+}
+
 type simpleDeviceConn struct {
-	devices []skydb.Device
+	devices             []skydb.Device
+	scheduledPushes     map[string]skydb.ScheduledPush
+	lastScheduledPushID string
 	skydb.Conn
 }
 
+func (conn *simpleDeviceConn) GetScheduledPush(id string, push *skydb.ScheduledPush) error {
+	if p, ok := conn.scheduledPushes[id]; ok {
+		*push = p
+		return nil
+	}
+	return skydb.ErrScheduledPushNotFound
+}
+
+func (conn *simpleDeviceConn) SaveScheduledPush(push *skydb.ScheduledPush) error {
+	if conn.scheduledPushes == nil {
+		conn.scheduledPushes = map[string]skydb.ScheduledPush{}
+	}
+	conn.scheduledPushes[push.ID] = *push
+	conn.lastScheduledPushID = push.ID
+	return nil
+}
+
+func (conn *simpleDeviceConn) CancelScheduledPush(id string, push *skydb.ScheduledPush) error {
+	p, ok := conn.scheduledPushes[id]
+	if !ok || p.Status != skydb.ScheduledPushPending && p.Status != skydb.ScheduledPushDispatching {
+		return skydb.ErrScheduledPushNotFound
+	}
+	p.Status = skydb.ScheduledPushCancelled
+	conn.scheduledPushes[id] = p
+	*push = p
+	return nil
+}
+
 func (conn *simpleDeviceConn) GetDevice(id string, device *skydb.Device) error {
 	for _, prospectiveDevice := range conn.devices {
 		if prospectiveDevice.ID == id {
@@ -112,6 +112,8 @@ func (parser *QueryParser) predicateOperatorFromString(operatorString string) sk
 		return skydb.In
 	case "func":
 		return skydb.Functional
+	case "exists":
+		return skydb.Exists
 	default:
 		panic(fmt.Errorf("unrecognized operator = %s", operatorString))
 	}
@@ -161,10 +163,18 @@ func (parser *QueryParser) predicateFromRaw(rawPredicate []interface{}) skydb.Pr
 //
 // * { "$type": "keypath", "$val": "_key_path_name_" }    // key path
 // * [ "_func_name_" , _expression_1_ , _expression_2_ ]  // function
+// * { "$type": "subquery", "$record_type": ... }         // subquery
 // * 42                                                   // literal
 func (parser *QueryParser) parseExpression(i interface{}) skydb.Expression {
 	switch v := i.(type) {
 	case map[string]interface{}:
+		if v["$type"] == "subquery" {
+			return skydb.Expression{
+				Type:  skydb.Subquery,
+				Value: parser.subqueryFromRaw(v),
+			}
+		}
+
 		var keyPath string
 		if err := skyconv.MapFrom(i, (*skyconv.MapKeyPath)(&keyPath)); err == nil {
 			if keyPath == "_owner" {
@@ -300,6 +310,13 @@ func (parser *QueryParser) queryFromRaw(rawQuery map[string]interface{}, query *
 	if transientIncludes, ok := rawQuery["include"].(map[string]interface{}); ok {
 		query.ComputedKeys = map[string]skydb.Expression{}
 		for key, value := range transientIncludes {
+			if rawInclude, ok := value.(map[string]interface{}); ok && rawInclude["$type"] == "reverse" {
+				if query.ReverseIncludes == nil {
+					query.ReverseIncludes = map[string]skydb.ReverseInclude{}
+				}
+				query.ReverseIncludes[key] = parser.reverseIncludeFromRaw(key, rawInclude)
+				continue
+			}
 			query.ComputedKeys[key] = parser.parseExpression(value)
 		}
 	}
@@ -320,6 +337,10 @@ func (parser *QueryParser) queryFromRaw(rawQuery map[string]interface{}, query *
 		query.GetCount = getCount
 	}
 
+	if includeDeleted, ok := rawQuery["include_deleted"].(bool); ok {
+		query.IncludeDeleted = includeDeleted
+	}
+
 	if offset, _ := rawQuery["offset"].(float64); offset > 0 {
 		query.Offset = uint64(offset)
 	}
@@ -331,6 +352,73 @@ func (parser *QueryParser) queryFromRaw(rawQuery map[string]interface{}, query *
 	return nil
 }
 
+// subqueryFromRaw parses a subquery in the following format:
+//
+//     {
+//         "$type": "subquery",
+//         "$record_type": "comment",
+//         "$key": "post",
+//         "$predicate": [ _predicate_ ]
+//     }
+//
+// $predicate is optional.
+func (parser *QueryParser) subqueryFromRaw(rawSubquery map[string]interface{}) skydb.RecordSubquery {
+	subquery := skydb.RecordSubquery{}
+	subquery.Type, _ = rawSubquery["$record_type"].(string)
+	subquery.KeyPath, _ = rawSubquery["$key"].(string)
+	if subquery.Type == "" || subquery.KeyPath == "" {
+		panic(errors.New("subquery requires $record_type and $key"))
+	}
+	if subquery.KeyPath == "_owner" {
+		subquery.KeyPath = "_owner_id"
+	}
+
+	mustDoSlice(rawSubquery, "$predicate", func(rawPredicate []interface{}) skyerr.Error {
+		subquery.Predicate = parser.predicateFromRaw(rawPredicate)
+		return nil
+	})
+	return subquery
+}
+
+// reverseIncludeFromRaw parses a reverse include in the following format:
+//
+//     {
+//         "$type": "reverse",
+//         "$record_type": "comment",
+//         "$field": "post",
+//         "$sort": [ _sort_descriptor_ ],
+//         "$limit": 10
+//     }
+//
+// $sort and $limit are optional.
+func (parser *QueryParser) reverseIncludeFromRaw(key string, rawInclude map[string]interface{}) skydb.ReverseInclude {
+	include := skydb.ReverseInclude{}
+	include.Type, _ = rawInclude["$record_type"].(string)
+	include.Field, _ = rawInclude["$field"].(string)
+	if include.Type == "" || include.Field == "" {
+		panic(skyerr.NewInvalidArgument(
+			fmt.Sprintf(`reverse include "%s" requires $record_type and $field`, key),
+			[]string{"include"}))
+	}
+
+	mustDoSlice(rawInclude, "$sort", func(rawSorts []interface{}) skyerr.Error {
+		include.Sorts = parser.sortsFromRaw(rawSorts)
+		return nil
+	})
+
+	if rawLimit, ok := rawInclude["$limit"]; ok {
+		limit, ok := rawLimit.(float64)
+		if !ok || limit < 0 {
+			panic(skyerr.NewInvalidArgument(
+				fmt.Sprintf(`$limit of reverse include "%s" must be a non-negative number`, key),
+				[]string{"include"}))
+		}
+		include.Limit = new(uint64)
+		*include.Limit = uint64(limit)
+	}
+	return include
+}
+
 // execute do when if the value of key in m is []interface{}. If value exists
 // for key but its type is not []interface{} or do returns an error, it panics.
 func mustDoSlice(m map[string]interface{}, key string, do func(value []interface{}) skyerr.Error) {
@@ -501,6 +589,8 @@ func (c *ExpressionACLChecker) Check(expr skydb.Expression, accessMode skydb.Fie
 		return c.checkKeyPath(expr.Value.(string), accessMode)
 	case skydb.Function:
 		return c.checkFunc(expr.Value.(skydb.Func), accessMode)
+	case skydb.Subquery:
+		return c.checkSubquery(expr.Value.(skydb.RecordSubquery))
 	case skydb.Literal:
 		return nil
 	default:
@@ -519,6 +609,32 @@ func (c *ExpressionACLChecker) checkFunc(fn skydb.Func, accessMode skydb.FieldAc
 	return nil
 }
 
+// checkSubquery checks the keypath and the predicate of a subquery
+// against the Field ACL of the record type of the subquery.
+func (c *ExpressionACLChecker) checkSubquery(subquery skydb.RecordSubquery) skyerr.Error {
+	checker := ExpressionACLChecker{
+		FieldACL:   c.FieldACL,
+		RecordType: subquery.Type,
+		AuthInfo:   c.AuthInfo,
+		Database:   c.Database,
+	}
+	if err := checker.checkKeyPath(subquery.KeyPath, skydb.DiscoverOrCompareFieldAccessMode); err != nil {
+		return err
+	}
+
+	if subquery.Predicate.IsEmpty() {
+		return nil
+	}
+	visitor := &queryAccessVisitor{
+		FieldACL:             c.FieldACL,
+		RecordType:           subquery.Type,
+		AuthInfo:             c.AuthInfo,
+		ExpressionACLChecker: checker,
+	}
+	subquery.Predicate.Accept(visitor)
+	return visitor.Error()
+}
+
 func (c *ExpressionACLChecker) checkKeyPath(keyPath string, accessMode skydb.FieldAccessMode) skyerr.Error {
 	recordType := c.RecordType
 	components := strings.Split(keyPath, ".")
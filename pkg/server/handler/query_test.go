@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -107,6 +108,140 @@ func TestQueryFromRaw(t *testing.T) {
 				},
 			})
 		})
+
+		Convey("should parse exists predicate with subquery", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "post",
+				"predicate": []interface{}{
+					"exists",
+					map[string]interface{}{
+						"$type":        "subquery",
+						"$record_type": "comment",
+						"$key":         "post",
+						"$predicate": []interface{}{
+							"eq",
+							map[string]interface{}{"$type": "keypath", "$val": "_owner"},
+							"USER_ID",
+						},
+					},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+			So(query, ShouldResemble, skydb.Query{
+				Type: "post",
+				Predicate: skydb.Predicate{
+					Operator: skydb.Exists,
+					Children: []interface{}{
+						skydb.Expression{
+							Type: skydb.Subquery,
+							Value: skydb.RecordSubquery{
+								Type:    "comment",
+								KeyPath: "post",
+								Predicate: skydb.Predicate{
+									Operator: skydb.Equal,
+									Children: []interface{}{
+										skydb.Expression{
+											Type:  skydb.KeyPath,
+											Value: "_owner_id",
+										},
+										skydb.Expression{
+											Type:  skydb.Literal,
+											Value: "USER_ID",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			})
+		})
+
+		Convey("should reject subquery in equal predicate", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "post",
+				"predicate": []interface{}{
+					"eq",
+					map[string]interface{}{"$type": "keypath", "$val": "_id"},
+					map[string]interface{}{
+						"$type":        "subquery",
+						"$record_type": "comment",
+						"$key":         "post",
+					},
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.NotSupported)
+		})
+
+		Convey("should parse nested keypath and reverse include", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "post",
+				"include": map[string]interface{}{
+					"company": map[string]interface{}{"$type": "keypath", "$val": "author.company"},
+					"comments": map[string]interface{}{
+						"$type":        "reverse",
+						"$record_type": "comment",
+						"$field":       "post",
+						"$sort": []interface{}{
+							[]interface{}{
+								map[string]interface{}{"$type": "keypath", "$val": "_created_at"},
+								"desc",
+							},
+						},
+						"$limit": float64(5),
+					},
+				},
+			}, &query)
+			So(err, ShouldBeNil)
+
+			limit := uint64(5)
+			So(query, ShouldResemble, skydb.Query{
+				Type: "post",
+				ComputedKeys: map[string]skydb.Expression{
+					"company": skydb.Expression{
+						Type:  skydb.KeyPath,
+						Value: "author.company",
+					},
+				},
+				ReverseIncludes: map[string]skydb.ReverseInclude{
+					"comments": skydb.ReverseInclude{
+						Type:  "comment",
+						Field: "post",
+						Sorts: []skydb.Sort{
+							skydb.Sort{
+								Expression: skydb.Expression{
+									Type:  skydb.KeyPath,
+									Value: "_created_at",
+								},
+								Order: skydb.Descending,
+							},
+						},
+						Limit: &limit,
+					},
+				},
+			})
+		})
+
+		Convey("should reject reverse include with negative limit", func() {
+			query := skydb.Query{}
+			err := parser.queryFromRaw(map[string]interface{}{
+				"record_type": "post",
+				"include": map[string]interface{}{
+					"comments": map[string]interface{}{
+						"$type":        "reverse",
+						"$record_type": "comment",
+						"$field":       "post",
+						"$limit":       float64(-1),
+					},
+				},
+			}, &query)
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
 	})
 
 }
@@ -17,8 +17,11 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
@@ -99,6 +102,10 @@ type recordSavePayload struct {
 	// Records contains the successfully de-serialized record
 	Records []*skydb.Record
 
+	// Preconditions contains the expected state of records specified with
+	// `_expected_updated_at` or `_expected_revision`
+	Preconditions map[skydb.RecordID]recordutil.RecordPrecondition
+
 	// Errs is the array of de-serialization errors
 	Errs []skyerr.Error
 
@@ -127,9 +134,17 @@ func (payload *recordSavePayload) Validate() skyerr.Error {
 	payload.Errs = []skyerr.Error{}
 	payload.IncomingItems = []interface{}{}
 	payload.Records = []*skydb.Record{}
+	payload.Preconditions = map[skydb.RecordID]recordutil.RecordPrecondition{}
 	for _, recordMap := range payload.RawMaps {
 		var record skydb.Record
-		if err := (*skyconv.JSONRecord)(&record).FromMap(recordMap); err != nil {
+		precondition, hasPrecondition, err := preconditionFromMap(recordMap)
+		if err == nil {
+			err = (*skyconv.JSONRecord)(&record).FromMap(recordMap)
+		}
+		if err == nil {
+			err = skyconv.ParseFieldOperations(record.Data)
+		}
+		if err != nil {
 			payload.Clean = false
 			skyErr := skyerr.NewError(skyerr.InvalidArgument, err.Error())
 			payload.Errs = append(payload.Errs, skyErr)
@@ -138,12 +153,45 @@ func (payload *recordSavePayload) Validate() skyerr.Error {
 			record.SanitizeForInput()
 			payload.IncomingItems = append(payload.IncomingItems, record.ID)
 			payload.Records = append(payload.Records, &record)
+			if hasPrecondition {
+				payload.Preconditions[record.ID] = precondition
+			}
 		}
 	}
 
 	return nil
 }
 
+// preconditionFromMap parses the precondition of a record in the save
+// payload. The record is saved only if it matches the precondition.
+func preconditionFromMap(m map[string]interface{}) (precondition recordutil.RecordPrecondition, ok bool, err error) {
+	if rawUpdatedAt, exists := m["_expected_updated_at"]; exists {
+		updatedAtStr, isString := rawUpdatedAt.(string)
+		if !isString {
+			err = errors.New("_expected_updated_at is not a string")
+			return
+		}
+		if precondition.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAtStr); err != nil {
+			err = fmt.Errorf("_expected_updated_at is not a time: %s", err)
+			return
+		}
+		ok = true
+	}
+
+	if rawRevision, exists := m["_expected_revision"]; exists {
+		revision, isNumber := rawRevision.(float64)
+		if !isNumber || revision < 0 || revision != float64(int(revision)) {
+			err = errors.New("_expected_revision is not a non-negative integer")
+			return
+		}
+		precondition.Revision = new(int)
+		*precondition.Revision = int(revision)
+		ok = true
+	}
+
+	return
+}
+
 /*
 RecordSaveHandler is dummy implementation on save/modify Records
 curl -X POST -H "Content-Type: application/json" \
@@ -188,10 +236,53 @@ curl -X POST -H "Content-Type: application/json" \
   ]
 }
 EOF
+
+Save only if the record has not been modified since it was fetched
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:save",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "records": [{
+        "_id": "note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8",
+        "_expected_updated_at": "2006-01-02T15:04:05.000000Z",
+        "content": "ewdsa"
+    }]
+}
+EOF
+
+A record can also be saved only if the latest revision in its history is
+`_expected_revision`, which is 0 for a record without revisions. A record
+not matching the expectation is rejected with RecordConflict, and the
+current record on the server is returned in the error info. The
+expectation is checked again with the record locked in the transaction
+saving it, so only one of the concurrent saves of the same expectation
+succeeds.
+
+Increment a counter and append to a list atomically
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:save",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "records": [{
+        "_id": "note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8",
+        "viewCount": {"$inc": 1},
+        "tags": {"$push": ["draft"]}
+    }]
+}
+EOF
+
+`$inc` and `$max` apply to number fields, `$push` and `$pull` apply to
+JSON array fields. The saved value is computed by the database from the
+current value of the field.
 */
 type RecordSaveHandler struct {
 	HookRegistry   *hook.Registry     `inject:"HookRegistry"`
 	AssetStore     asset.Store        `inject:"AssetStore"`
+	AssetPolicies  asset.Policies     `inject:"AssetPolicies"`
 	AccessModel    skydb.AccessModel  `inject:"AccessModel"`
 	EventSender    pluginEvent.Sender `inject:"PluginEventSender"`
 	AuthRecordKeys [][]string         `inject:"AuthRecordKeys"`
@@ -250,9 +341,11 @@ func (h *RecordSaveHandler) Handle(payload *router.Payload, response *router.Res
 		Db:            payload.Database,
 		Conn:          payload.DBConn,
 		AssetStore:    h.AssetStore,
+		AssetPolicies: h.AssetPolicies,
 		HookRegistry:  h.HookRegistry,
 		AuthInfo:      payload.AuthInfo,
 		RecordsToSave: p.Records,
+		Preconditions: p.Preconditions,
 		Atomic:        p.Atomic,
 		WithMasterKey: payload.HasMasterKey(),
 		Context:       payload.Context(),
@@ -424,6 +517,9 @@ func (h *RecordFetchHandler) Handle(payload *router.Payload, response *router.Re
 		return
 	}
 
+	// Fetching is read-only, so it may read from a replica.
+	defer skydb.RouteReadsToReplica(payload.DBConn)()
+
 	db := payload.Database
 	resultFilter, err := recordutil.NewRecordResultFilter(
 		payload.DBConn,
@@ -456,6 +552,10 @@ func (h *RecordFetchHandler) Handle(payload *router.Payload, response *router.Re
 
 type recordQueryPayload struct {
 	Query skydb.Query
+
+	// Explain requests the generated statement and its execution plan
+	// to be returned in the result info.
+	Explain bool
 }
 
 func (payload *recordQueryPayload) Decode(data map[string]interface{}, parser *QueryParser) skyerr.Error {
@@ -468,6 +568,12 @@ func (payload *recordQueryPayload) Decode(data map[string]interface{}, parser *Q
 		return err
 	}
 
+	if explain, ok := data["explain"]; ok {
+		if payload.Explain, ok = explain.(bool); !ok {
+			return skyerr.NewInvalidArgument("explain must be a boolean", []string{"explain"})
+		}
+	}
+
 	return payload.Validate()
 }
 
@@ -489,17 +595,25 @@ curl -X POST -H "Content-Type: application/json" \
     ]
 }
 EOF
+
+With master key, "explain": true returns the generated SQL, the bound args
+and the execution plan of the query in info.explain. The plan is reported
+by EXPLAIN ANALYZE on PostgreSQL, which executes the query a second time
+after the records are queried, so an explained query takes about twice as
+long. Queries taking longer than SlowQueryThreshold are logged with the
+request ID.
 */
 type RecordQueryHandler struct {
-	AssetStore    asset.Store       `inject:"AssetStore"`
-	AccessModel   skydb.AccessModel `inject:"AccessModel"`
-	Authenticator router.Processor  `preprocessor:"authenticator"`
-	DBConn        router.Processor  `preprocessor:"dbconn"`
-	InjectAuth    router.Processor  `preprocessor:"inject_auth"`
-	InjectDB      router.Processor  `preprocessor:"inject_db"`
-	CheckUser     router.Processor  `preprocessor:"check_user"`
-	PluginReady   router.Processor  `preprocessor:"plugin_ready"`
-	preprocessors []router.Processor
+	AssetStore         asset.Store       `inject:"AssetStore"`
+	AccessModel        skydb.AccessModel `inject:"AccessModel"`
+	SlowQueryThreshold time.Duration     `inject:"SlowQueryThreshold"`
+	Authenticator      router.Processor  `preprocessor:"authenticator"`
+	DBConn             router.Processor  `preprocessor:"dbconn"`
+	InjectAuth         router.Processor  `preprocessor:"inject_auth"`
+	InjectDB           router.Processor  `preprocessor:"inject_db"`
+	CheckUser          router.Processor  `preprocessor:"check_user"`
+	PluginReady        router.Processor  `preprocessor:"plugin_ready"`
+	preprocessors      []router.Processor
 }
 
 func (h *RecordQueryHandler) Setup() {
@@ -526,6 +640,19 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		return
 	}
 
+	if p.Query.IncludeDeleted && !payload.HasMasterKey() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "include_deleted requires master key")
+		return
+	}
+
+	if p.Explain && !payload.HasMasterKey() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "explain requires master key")
+		return
+	}
+
+	// Querying is read-only, so it may read from a replica.
+	defer skydb.RouteReadsToReplica(payload.DBConn)()
+
 	accessControlOptions := &skydb.AccessControlOptions{
 		ViewAsUser:          payload.AuthInfo,
 		BypassAccessControl: payload.HasMasterKey(),
@@ -558,8 +685,14 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		}
 	}
 
+	if err := checkReverseIncludes(payload, p.Query, fieldACL, accessControlOptions); err != nil {
+		response.Err = err
+		return
+	}
+
 	db := payload.Database
 
+	startTime := time.Now()
 	results, err := db.Query(&p.Query, accessControlOptions)
 	if err != nil {
 		response.Err = skyerr.MakeError(err)
@@ -577,14 +710,13 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		response.Err = skyerr.MakeError(results.Err())
 		return
 	}
+	h.logSlowQuery(payload, p.Query, time.Since(startTime))
 
 	// Scan does not query assets,
 	// it only replaces them with assets then only have name,
 	// so we replace them with some complete assets.
 	recordutil.MakeAssetsComplete(db, payload.DBConn, records)
 
-	eagerRecords := recordutil.DoQueryEager(payload.Context(), db, recordutil.EagerIDs(db, records, p.Query), accessControlOptions)
-
 	recordResultFilter, err := recordutil.NewRecordResultFilter(
 		payload.DBConn,
 		h.AssetStore,
@@ -596,6 +728,8 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		return
 	}
 
+	eagerRecords := recordutil.DoQueryEager(payload.Context(), db, records, p.Query, accessControlOptions, recordResultFilter)
+
 	resultFilter := recordutil.QueryResultFilter{
 		Database:           db,
 		Query:              p.Query,
@@ -616,11 +750,106 @@ func (h *RecordQueryHandler) Handle(payload *router.Payload, response *router.Re
 		response.Err = skyerr.MakeError(err)
 		return
 	}
+	if p.Explain {
+		explanation, err := db.ExplainQuery(&p.Query, accessControlOptions)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		resultInfo["explain"] = map[string]interface{}{
+			"sql":  explanation.Statement,
+			"args": explanation.Args,
+			"plan": explanation.Plan,
+		}
+	}
 	if len(resultInfo) > 0 {
 		response.Info = resultInfo
 	}
 }
 
+// logSlowQuery logs the query if it takes longer than the slow query
+// threshold.
+func (h *RecordQueryHandler) logSlowQuery(payload *router.Payload, query skydb.Query, elapsed time.Duration) {
+	if h.SlowQueryThreshold <= 0 || elapsed < h.SlowQueryThreshold {
+		return
+	}
+
+	logger := logging.CreateLogger(payload.Context(), "handler")
+	logger.WithFields(logrus.Fields{
+		"action":      payload.RouteAction(),
+		"user_id":     payload.AuthInfoID,
+		"record_type": query.Type,
+		"predicate":   payload.Data["predicate"],
+		"sort":        payload.Data["sort"],
+		"elapsed":     elapsed.String(),
+	}).Warnln("Slow record query")
+}
+
+// checkReverseIncludes checks that the field of each reverse include of
+// query references the queried record type, and that the field and the
+// sorts are accessible according to the field ACL.
+func checkReverseIncludes(payload *router.Payload, query skydb.Query, fieldACL skydb.FieldACL, accessControlOptions *skydb.AccessControlOptions) skyerr.Error {
+	names := make([]string, 0, len(query.ReverseIncludes))
+	for name := range query.ReverseIncludes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		include := query.ReverseIncludes[name]
+		schema, err := payload.Database.GetSchema(include.Type)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		field, ok := schema[include.Field]
+		if !ok || field.Type != skydb.TypeReference || field.ReferenceType != query.Type {
+			return skyerr.NewErrorf(
+				skyerr.RecordQueryInvalid,
+				`field "%s" of record type "%s" in reverse include "%s" is not a reference to "%s"`,
+				include.Field,
+				include.Type,
+				name,
+				query.Type,
+			)
+		}
+
+		if accessControlOptions.BypassAccessControl {
+			continue
+		}
+
+		// The referencing records are queried by comparing the field,
+		// so it is checked in the same way as the predicate and sorts
+		// of a query on the referencing record type.
+		reverseQuery := skydb.Query{
+			Type: include.Type,
+			Predicate: skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: include.Field},
+					skydb.Expression{Type: skydb.Literal, Value: nil},
+				},
+			},
+			Sorts: include.Sorts,
+		}
+		visitor := &queryAccessVisitor{
+			FieldACL:   fieldACL,
+			RecordType: include.Type,
+			AuthInfo:   accessControlOptions.ViewAsUser,
+			ExpressionACLChecker: ExpressionACLChecker{
+				FieldACL:   fieldACL,
+				RecordType: include.Type,
+				AuthInfo:   payload.AuthInfo,
+				Database:   payload.Database,
+			},
+		}
+		reverseQuery.Accept(visitor)
+		if err := visitor.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type recordDeleteRecordPayload struct {
 	Type string `mapstructure:"_recordType"`
 	Key  string `mapstructure:"_recordID"`
@@ -705,6 +934,29 @@ curl -X POST -H "Content-Type: application/json" \
     "ids": ["note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8"]
 }
 EOF
+
+Records referencing a deleted record are handled according to the
+on_delete constraint of the reference field: `restrict` fails the
+deletion, `cascade` deletes the referencing records and `set_null` sets
+the field to null. A record is deleted together with its cascaded
+records in a transaction. The cascaded records are subject to access
+control and hooks, and are reported in the result:
+
+{
+    "_id": "note/EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8",
+    "_recordType": "note",
+    "_recordID": "EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8",
+    "_type": "record",
+    "_cascaded": [
+        {
+            "_id": "comment/1",
+            "_recordType": "comment",
+            "_recordID": "1",
+            "field": "note",
+            "on_delete": "cascade"
+        }
+    ]
+}
 */
 type RecordDeleteHandler struct {
 	HookRegistry  *hook.Registry    `inject:"HookRegistry"`
@@ -755,6 +1007,7 @@ func (h *RecordDeleteHandler) Handle(payload *router.Payload, response *router.R
 		WithMasterKey:     payload.HasMasterKey(),
 		Context:           payload.Context(),
 		AuthInfo:          payload.AuthInfo,
+		ModifyAt:          timeNow(),
 	}
 	resp := recordutil.RecordModifyResponse{
 		ErrMap: map[skydb.RecordID]skyerr.Error{},
@@ -789,11 +1042,12 @@ func (h *RecordDeleteHandler) Handle(payload *router.Payload, response *router.R
 			)
 		} else {
 			result = struct {
-				ID         skydb.RecordID `json:"_id"`
-				RecordKey  string         `json:"_recordID"`
-				RecordType string         `json:"_recordType"`
-				Type       string         `json:"_type"`
-			}{recordID, recordID.Key, recordID.Type, "record"}
+				ID         skydb.RecordID         `json:"_id"`
+				RecordKey  string                 `json:"_recordID"`
+				RecordType string                 `json:"_recordType"`
+				Type       string                 `json:"_type"`
+				Cascaded   []cascadedRecordResult `json:"_cascaded,omitempty"`
+			}{recordID, recordID.Key, recordID.Type, "record", newCascadedRecordResults(resp.CascadedRecords[recordID])}
 		}
 
 		results = append(results, result)
@@ -802,6 +1056,180 @@ func (h *RecordDeleteHandler) Handle(payload *router.Payload, response *router.R
 	response.Result = results
 }
 
+type cascadedRecordResult struct {
+	ID         skydb.RecordID        `json:"_id"`
+	RecordKey  string                `json:"_recordID"`
+	RecordType string                `json:"_recordType"`
+	Field      string                `json:"field"`
+	OnDelete   skydb.ReferenceAction `json:"on_delete"`
+}
+
+func newCascadedRecordResults(cascaded []recordutil.CascadedRecord) []cascadedRecordResult {
+	if len(cascaded) == 0 {
+		return nil
+	}
+
+	results := make([]cascadedRecordResult, len(cascaded))
+	for i, record := range cascaded {
+		results[i] = cascadedRecordResult{
+			ID:         record.ID,
+			RecordKey:  record.ID.Key,
+			RecordType: record.ID.Type,
+			Field:      record.Field,
+			OnDelete:   record.OnDelete,
+		}
+	}
+	return results
+}
+
+type recordUndeletePayload struct {
+	RawRecords      []recordDeleteRecordPayload `mapstructure:"records"`
+	Atomic          bool                        `mapstructure:"atomic"`
+	parsedRecordIDs []skydb.RecordID
+}
+
+func (payload *recordUndeletePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordUndeletePayload) Validate() skyerr.Error {
+	if len(payload.RawRecords) == 0 {
+		return skyerr.NewInvalidArgument("expected list of records", []string{"records"})
+	}
+
+	payload.parsedRecordIDs = make([]skydb.RecordID, len(payload.RawRecords))
+	for i, rawRecord := range payload.RawRecords {
+		payload.parsedRecordIDs[i] = rawRecord.RecordID()
+	}
+	return nil
+}
+
+/*
+RecordUndeleteHandler restores soft-deleted records
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:undelete",
+    "master_key": "MASTER_KEY",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "records": [
+        {
+            "_recordType": "note",
+            "_recordID": "EA6A3E68-90F3-49B5-B470-5FFDB7A0D4E8"
+        }
+    ]
+}
+EOF
+
+Undeleting records requires master key, because soft-deleted records are
+only visible with master key. Before and after save hooks are executed on
+the undeleted records.
+*/
+type RecordUndeleteHandler struct {
+	HookRegistry  *hook.Registry   `inject:"HookRegistry"`
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"require_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	CheckUser     router.Processor `preprocessor:"check_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordUndeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.CheckUser,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordUndeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordUndeleteHandler) Handle(payload *router.Payload, response *router.Response) {
+	if !payload.HasMasterKey() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "undeleting records requires master key")
+		return
+	}
+
+	p := &recordUndeletePayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	if payload.Database.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		true,
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	req := recordutil.RecordModifyRequest{
+		Db:                  payload.Database,
+		Conn:                payload.DBConn,
+		HookRegistry:        h.HookRegistry,
+		RecordIDsToUndelete: p.parsedRecordIDs,
+		Atomic:              p.Atomic,
+		WithMasterKey:       true,
+		Context:             payload.Context(),
+		AuthInfo:            payload.AuthInfo,
+	}
+	resp := recordutil.RecordModifyResponse{
+		ErrMap: map[skydb.RecordID]skyerr.Error{},
+	}
+
+	var undeleteFunc recordModifyFunc
+	if p.Atomic {
+		undeleteFunc = atomicModifyFunc(&req, &resp, recordutil.RecordUndeleteHandler)
+	} else {
+		undeleteFunc = recordutil.RecordUndeleteHandler
+	}
+
+	logger := logging.CreateLogger(payload.Context(), "handler")
+	if err := undeleteFunc(&req, &resp); err != nil {
+		logger.WithError(err).Debugf("Failed to undelete records")
+		response.Err = err
+		return
+	}
+
+	undeletedRecords := map[skydb.RecordID]*skydb.Record{}
+	for _, record := range resp.UndeletedRecords {
+		undeletedRecords[record.ID] = record
+	}
+
+	results := make([]interface{}, 0, len(p.parsedRecordIDs))
+	for _, recordID := range p.parsedRecordIDs {
+		if err, ok := resp.ErrMap[recordID]; ok {
+			results = append(results, newSerializedError(recordID.String(), err))
+		} else {
+			results = append(results, resultFilter.JSONResult(undeletedRecords[recordID]))
+		}
+	}
+
+	response.Result = results
+}
+
 type recordModifyFunc func(*recordutil.RecordModifyRequest, *recordutil.RecordModifyResponse) skyerr.Error
 
 func atomicModifyFunc(req *recordutil.RecordModifyRequest, resp *recordutil.RecordModifyResponse, mFunc recordModifyFunc) recordModifyFunc {
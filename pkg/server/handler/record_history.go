@@ -0,0 +1,363 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	pluginEvent "github.com/skygeario/skygear-server/pkg/server/plugin/event"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+type recordHistoryPayload struct {
+	RecordType string `mapstructure:"record_type"`
+	RecordKey  string `mapstructure:"record_id"`
+}
+
+func (payload *recordHistoryPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordHistoryPayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.RecordKey == "" {
+		missingArgs = append(missingArgs, "record_id")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+	return nil
+}
+
+func (payload *recordHistoryPayload) RecordID() skydb.RecordID {
+	return skydb.NewRecordID(payload.RecordType, payload.RecordKey)
+}
+
+/*
+RecordHistoryHandler fetches the revisions of a record, latest first.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:history",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "record_type": "note",
+    "record_id": "1004"
+}
+EOF
+
+The record, or its latest revision if it has been deleted, must be readable
+by the user. Revisions are filtered by their own access control, so that a
+revision not readable by the user is left out, and fields not readable by
+the user are removed from the record and diff of a revision.
+*/
+type RecordHistoryHandler struct {
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	CheckUser     router.Processor `preprocessor:"check_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordHistoryHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.CheckUser,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordHistoryHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordHistoryHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordHistoryPayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := payload.Database
+	recordID := p.RecordID()
+	revisions, err := payload.DBConn.GetRecordRevisions(db.ID(), recordID)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	record := skydb.Record{}
+	if err := db.Get(recordID, &record); err == skydb.ErrRecordNotFound {
+		if len(revisions) == 0 {
+			response.Err = skyerr.NewError(skyerr.ResourceNotFound, "record not found")
+			return
+		}
+		record = revisions[0].Record
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	if !payload.HasMasterKey() && !record.Accessible(payload.AuthInfo, skydb.ReadLevel) {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "no permission to perform operation")
+		return
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	results := []interface{}{}
+	for i := range revisions {
+		revision := &revisions[i]
+		if !payload.HasMasterKey() && !revision.Record.Accessible(payload.AuthInfo, skydb.ReadLevel) {
+			continue
+		}
+
+		results = append(results, map[string]interface{}{
+			"version":    revision.Version,
+			"action":     revision.Action,
+			"actor_id":   revision.ActorID,
+			"created_at": revision.CreatedAt,
+			"record":     resultFilter.JSONResult(&revision.Record),
+			"diff":       resultFilter.DiffResult(&revision.Record, revision.Diff),
+		})
+	}
+
+	response.Result = results
+}
+
+type recordRestorePayload struct {
+	recordHistoryPayload `mapstructure:",squash"`
+	Version              int `mapstructure:"version"`
+}
+
+func (payload *recordRestorePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *recordRestorePayload) Validate() skyerr.Error {
+	if err := payload.recordHistoryPayload.Validate(); err != nil {
+		return err
+	}
+	if payload.Version <= 0 {
+		return skyerr.NewInvalidArgument("version must be a positive integer", []string{"version"})
+	}
+	return nil
+}
+
+/*
+RecordRestoreHandler rolls a record back to the specified revision.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/ <<EOF
+{
+    "action": "record:restore",
+    "access_token": "validToken",
+    "database_id": "_public",
+    "record_type": "note",
+    "record_id": "1004",
+    "version": 2
+}
+EOF
+
+The data and access control of the revision are saved to the record as in
+record:save, so write access and hooks apply. Fields that do not exist in
+the revision are set to null.
+*/
+type RecordRestoreHandler struct {
+	HookRegistry  *hook.Registry     `inject:"HookRegistry"`
+	AssetStore    asset.Store        `inject:"AssetStore"`
+	AssetPolicies asset.Policies     `inject:"AssetPolicies"`
+	EventSender   pluginEvent.Sender `inject:"PluginEventSender"`
+	Authenticator router.Processor   `preprocessor:"authenticator"`
+	DBConn        router.Processor   `preprocessor:"dbconn"`
+	InjectAuth    router.Processor   `preprocessor:"require_auth"`
+	InjectDB      router.Processor   `preprocessor:"inject_db"`
+	CheckUser     router.Processor   `preprocessor:"check_user"`
+	PluginReady   router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordRestoreHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.CheckUser,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordRestoreHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordRestoreHandler) Handle(payload *router.Payload, response *router.Response) {
+	p := &recordRestorePayload{}
+	skyErr := p.Decode(payload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := payload.Database
+	if db.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "modifying the selected database is not supported")
+		return
+	}
+
+	recordID := p.RecordID()
+	revision := skydb.RecordRevision{}
+	if err := payload.DBConn.GetRecordRevision(db.ID(), recordID, p.Version, &revision); err == skydb.ErrRecordRevisionNotFound {
+		response.Err = skyerr.NewError(skyerr.ResourceNotFound, "record revision not found")
+		return
+	} else if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	record, skyErr := restoredRecord(db, recordID, &revision)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	resultFilter, err := recordutil.NewRecordResultFilter(
+		payload.DBConn,
+		h.AssetStore,
+		payload.AuthInfo,
+		payload.HasMasterKey(),
+	)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	logger := logging.CreateLogger(payload.Context(), "handler")
+	records := []*skydb.Record{record}
+	schemaUpdated, err := recordutil.ExtendRecordSchema(payload.Context(), db, records)
+	if err != nil {
+		logger.WithError(err).Errorln("failed to migrate record schema")
+		if myerr, ok := err.(skyerr.Error); ok {
+			response.Err = myerr
+			return
+		}
+
+		response.Err = skyerr.NewError(skyerr.IncompatibleSchema, "failed to migrate record schema")
+		return
+	}
+
+	req := recordutil.RecordModifyRequest{
+		Db:            db,
+		Conn:          payload.DBConn,
+		AssetStore:    h.AssetStore,
+		AssetPolicies: h.AssetPolicies,
+		HookRegistry:  h.HookRegistry,
+		AuthInfo:      payload.AuthInfo,
+		RecordsToSave: records,
+		WithMasterKey: payload.HasMasterKey(),
+		Context:       payload.Context(),
+		ModifyAt:      timeNow(),
+	}
+	resp := recordutil.RecordModifyResponse{
+		ErrMap: map[skydb.RecordID]skyerr.Error{},
+	}
+
+	if err := recordutil.RecordSaveHandler(&req, &resp); err != nil {
+		response.Err = err
+		return
+	}
+	if err, ok := resp.ErrMap[recordID]; ok {
+		response.Err = err
+		return
+	}
+
+	response.Result = resultFilter.JSONResult(resp.SavedRecords[0])
+
+	if schemaUpdated && h.EventSender != nil {
+		err := sendSchemaChangedEvent(h.EventSender, db)
+		if err != nil {
+			logger.WithError(err).Warn("Fail to send schema changed event")
+		}
+	}
+}
+
+// restoredRecord returns the record to be saved for restoring the record
+// to revision. Fields of the current record absent from the revision are
+// set to nil, and sequence fields are left untouched.
+func restoredRecord(db skydb.Database, recordID skydb.RecordID, revision *skydb.RecordRevision) (*skydb.Record, skyerr.Error) {
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		return nil, skyerr.MakeError(err)
+	}
+
+	record := skydb.Record{
+		ID:   recordID,
+		ACL:  revision.Record.ACL,
+		Data: skydb.Data{},
+	}
+	for key, value := range revision.Record.Data {
+		record.Data[key] = value
+	}
+
+	current := skydb.Record{}
+	if err := db.Get(recordID, &current); err == nil {
+		for key := range current.Data {
+			if _, ok := record.Data[key]; !ok {
+				record.Data[key] = nil
+			}
+		}
+	} else if err != skydb.ErrRecordNotFound {
+		return nil, skyerr.MakeError(err)
+	}
+
+	for key, fieldType := range schemas[recordID.Type] {
+		if fieldType.Type == skydb.TypeSequence {
+			delete(record.Data, key)
+		}
+	}
+
+	return &record, nil
+}
@@ -0,0 +1,315 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/handler/handlertest"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recordHistoryResult struct {
+	Result []struct {
+		Version int                    `json:"version"`
+		Action  string                 `json:"action"`
+		ActorID string                 `json:"actor_id"`
+		Record  map[string]interface{} `json:"record"`
+		Diff    map[string]interface{} `json:"diff"`
+	} `json:"result"`
+}
+
+func TestRecordHistory(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("Record history", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+		db.RecordSchemaMap["note"] = skydb.RecordSchema{
+			"title":   skydb.FieldType{Type: skydb.TypeString},
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		}
+		So(conn.SetRecordHistoryEnabled("note", true), ShouldBeNil)
+
+		authInfo := &skydb.AuthInfo{ID: "user0"}
+		payloadFunc := func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AuthInfo = authInfo
+		}
+		saveRouter := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, payloadFunc)
+		deleteRouter := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, payloadFunc)
+		historyRouter := handlertest.NewSingleRouteRouter(&RecordHistoryHandler{}, payloadFunc)
+		restoreRouter := handlertest.NewSingleRouteRouter(&RecordRestoreHandler{}, payloadFunc)
+
+		fetchHistory := func() recordHistoryResult {
+			resp := historyRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note1"
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			result := recordHistoryResult{}
+			So(json.Unmarshal(resp.Body.Bytes(), &result), ShouldBeNil)
+			return result
+		}
+
+		resp := saveRouter.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "note1",
+		"title": "Hello",
+		"content": "World"
+	}]
+}`)
+		So(resp.Code, ShouldEqual, 200)
+		resp = saveRouter.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "note1",
+		"title": "Bonjour"
+	}]
+}`)
+		So(resp.Code, ShouldEqual, 200)
+
+		Convey("keeps a revision for each save", func() {
+			result := fetchHistory()
+			So(result.Result, ShouldHaveLength, 2)
+
+			latest := result.Result[0]
+			So(latest.Version, ShouldEqual, 2)
+			So(latest.Action, ShouldEqual, "save")
+			So(latest.ActorID, ShouldEqual, "user0")
+			So(latest.Record["title"], ShouldEqual, "Bonjour")
+			So(latest.Record["content"], ShouldEqual, "World")
+			So(latest.Diff, ShouldResemble, map[string]interface{}{
+				"title": map[string]interface{}{
+					"old": "Hello",
+					"new": "Bonjour",
+				},
+			})
+
+			first := result.Result[1]
+			So(first.Version, ShouldEqual, 1)
+			So(first.Diff, ShouldResemble, map[string]interface{}{
+				"title": map[string]interface{}{
+					"old": nil,
+					"new": "Hello",
+				},
+				"content": map[string]interface{}{
+					"old": nil,
+					"new": "World",
+				},
+			})
+		})
+
+		Convey("keeps a revision for delete", func() {
+			resp := deleteRouter.POST(`{
+	"ids": ["note/note1"]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			result := fetchHistory()
+			So(result.Result, ShouldHaveLength, 3)
+			So(result.Result[0].Action, ShouldEqual, "delete")
+			So(result.Result[0].Record["title"], ShouldEqual, "Bonjour")
+		})
+
+		Convey("does not keep revisions of types without history", func() {
+			So(conn.SetRecordHistoryEnabled("note", false), ShouldBeNil)
+			resp := saveRouter.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "note1",
+		"title": "Hola"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(fetchHistory().Result, ShouldHaveLength, 2)
+		})
+
+		Convey("rejects user without read access", func() {
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "note1"), &record), ShouldBeNil)
+			record.ACL = skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user0", skydb.WriteLevel),
+			}
+			So(db.Save(&record), ShouldBeNil)
+
+			authInfo.ID = "user1"
+			resp := historyRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note1"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 102,
+		"message": "no permission to perform operation",
+		"name": "PermissionDenied"
+	}
+}`)
+		})
+
+		Convey("leaves out revisions not readable by user", func() {
+			resp := saveRouter.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "note1",
+		"_access": [{"user_id": "user0", "level": "write"}],
+		"title": "Secret"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+			resp = saveRouter.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "note1",
+		"_access": [{"user_id": "user0", "level": "write"}, {"public": true, "level": "read"}],
+		"title": "Public"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			So(fetchHistory().Result, ShouldHaveLength, 4)
+
+			authInfo.ID = "user1"
+			result := fetchHistory()
+			So(result.Result, ShouldHaveLength, 3)
+			So(result.Result[0].Version, ShouldEqual, 4)
+			So(result.Result[1].Version, ShouldEqual, 2)
+			So(result.Result[2].Version, ShouldEqual, 1)
+		})
+
+		Convey("removes fields not readable by user from diffs", func() {
+			So(conn.SetRecordFieldAccess(skydb.NewFieldACL(skydb.FieldACLEntryList{
+				{
+					RecordType:  "note",
+					RecordField: "content",
+					UserRole:    skydb.FieldUserRole{Type: skydb.PublicFieldUserRoleType},
+					Writable:    true,
+					Readable:    false,
+				},
+			})), ShouldBeNil)
+
+			result := fetchHistory()
+			So(result.Result, ShouldHaveLength, 2)
+			So(result.Result[1].Record, ShouldNotContainKey, "content")
+			So(result.Result[1].Diff, ShouldResemble, map[string]interface{}{
+				"title": map[string]interface{}{
+					"old": nil,
+					"new": "Hello",
+				},
+			})
+		})
+
+		Convey("returns not found for record without history", func() {
+			resp := historyRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note2"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "record not found",
+		"name": "ResourceNotFound"
+	}
+}`)
+		})
+
+		Convey("restores record to a version", func() {
+			resp := restoreRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note1",
+	"version": 1
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "note1"), &record), ShouldBeNil)
+			So(record.Get("title"), ShouldEqual, "Hello")
+			So(record.Get("content"), ShouldEqual, "World")
+
+			result := fetchHistory()
+			So(result.Result, ShouldHaveLength, 3)
+			So(result.Result[0].Diff, ShouldResemble, map[string]interface{}{
+				"title": map[string]interface{}{
+					"old": "Bonjour",
+					"new": "Hello",
+				},
+			})
+		})
+
+		Convey("restores deleted record", func() {
+			resp := deleteRouter.POST(`{
+	"ids": ["note/note1"]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			resp = restoreRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note1",
+	"version": 2
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "note1"), &record), ShouldBeNil)
+			So(record.Get("title"), ShouldEqual, "Bonjour")
+		})
+
+		Convey("returns not found for non-existent version", func() {
+			resp := restoreRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note1",
+	"version": 10
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 110,
+		"message": "record revision not found",
+		"name": "ResourceNotFound"
+	}
+}`)
+		})
+
+		Convey("rejects invalid version", func() {
+			resp := restoreRouter.POST(`{
+	"record_type": "note",
+	"record_id": "note1",
+	"version": 0
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "version must be a positive integer",
+		"name": "InvalidArgument",
+		"info": {
+			"arguments": ["version"]
+		}
+	}
+}`)
+		})
+	})
+}
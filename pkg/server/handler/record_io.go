@@ -0,0 +1,382 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordio"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// exportFlushInterval is the number of records written to an export
+// before the response is flushed to the client.
+const exportFlushInterval = 1000
+
+type recordExportPayload struct {
+	Query  skydb.Query
+	Format recordio.Format
+	Fields []string
+}
+
+func (payload *recordExportPayload) Decode(data map[string]interface{}, parser *QueryParser) skyerr.Error {
+	if err := parser.queryFromRaw(data, &payload.Query); err != nil {
+		return err
+	}
+
+	formatName, _ := data["format"].(string)
+	format, err := recordio.ParseFormat(formatName)
+	if err != nil {
+		return skyerr.NewInvalidArgument(err.Error(), []string{"format"})
+	}
+	payload.Format = format
+
+	if rawFields, ok := data["fields"].([]interface{}); ok {
+		payload.Fields = make([]string, len(rawFields))
+		for i, rawField := range rawFields {
+			field, ok := rawField.(string)
+			if !ok {
+				return skyerr.NewInvalidArgument("unexpected value in fields", []string{"fields"})
+			}
+			payload.Fields[i] = field
+		}
+	}
+
+	return payload.Validate()
+}
+
+func (payload *recordExportPayload) Validate() skyerr.Error {
+	if len(payload.Query.ComputedKeys) > 0 {
+		return skyerr.NewInvalidArgument("include is not supported in export", []string{"include"})
+	}
+	return nil
+}
+
+/*
+RecordExportHandler streams the records matching a query as NDJSON or CSV.
+curl -X POST -H "Content-Type: application/json" \
+  -H "X-Skygear-Api-Key: MASTER_KEY" \
+  -d @- http://localhost:3000/records/export <<EOF
+{
+    "record_type": "note",
+    "predicate": ["gt", {"$type": "keypath", "$val": "noteOrder"}, 10],
+    "sort": [[{"$type": "keypath", "$val": "noteOrder"}, "asc"]],
+    "format": "csv",
+    "fields": ["title", "noteOrder"]
+}
+EOF
+
+The format is either `ndjson` (default) or `csv`. For CSV, fields are the
+columns written after the metadata columns, which default to all fields
+in the schema of the record type. Records are written to the response
+as they are read from the database, so the export is not subject to the
+response timeout of the action API.
+
+Exporting records requires master key and is only available for the
+public database.
+*/
+type RecordExportHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"require_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	CheckUser     router.Processor `preprocessor:"check_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordExportHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.CheckUser,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordExportHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordExportHandler) Handle(payload *router.Payload, response *router.Response) {
+	if !payload.HasMasterKey() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "exporting records requires master key")
+		return
+	}
+
+	data := map[string]interface{}{}
+	if err := json.NewDecoder(payload.Req.Body).Decode(&data); err != nil {
+		response.Err = skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+		return
+	}
+
+	p := &recordExportPayload{}
+	parser := QueryParser{UserID: payload.AuthInfoID}
+	if err := p.Decode(data, &parser); err != nil {
+		response.Err = err
+		return
+	}
+
+	db := payload.Database
+	if db.DatabaseType() != skydb.PublicDatabase {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "exporting records is only supported for the public database")
+		return
+	}
+
+	fields := p.Fields
+	if p.Format == recordio.CSV && fields == nil {
+		schema, err := db.GetSchema(p.Query.Type)
+		if err != nil {
+			response.Err = skyerr.MakeError(err)
+			return
+		}
+		fields = make([]string, 0, len(schema))
+		for field := range schema {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+
+	// the exported records are not worth caching
+	defer skydb.BypassQueryCache(payload.DBConn)()
+	results, err := db.Query(&p.Query, &skydb.AccessControlOptions{
+		ViewAsUser:          payload.AuthInfo,
+		BypassAccessControl: true,
+	})
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	defer results.Close()
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+
+	extension := "ndjson"
+	if p.Format == recordio.CSV {
+		extension = "csv"
+	}
+	writer.Header().Set("Content-Type", p.Format.ContentType())
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, p.Query.Type, extension))
+	writer.WriteHeader(http.StatusOK)
+
+	logger := logging.CreateLogger(payload.Context(), "handler")
+	flusher, _ := writer.(http.Flusher)
+	recordWriter := recordio.NewWriter(writer, p.Format, fields)
+	count := 0
+	for results.Scan() {
+		record := results.Record()
+		if err := recordWriter.Write(&record); err != nil {
+			// there is nothing we can do if error occurred after started
+			// writing a response. Log.
+			logger.WithError(err).Errorf("Failed to write exported record")
+			return
+		}
+
+		count++
+		if count%exportFlushInterval == 0 {
+			if err := recordWriter.Flush(); err != nil {
+				logger.WithError(err).Errorf("Failed to write exported records")
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	if err := results.Err(); err != nil {
+		logger.WithError(err).Errorf("Failed to query exported records")
+	}
+	if err := recordWriter.Flush(); err != nil {
+		logger.WithError(err).Errorf("Failed to write exported records")
+	}
+}
+
+type recordImportPayload struct {
+	RecordType  string
+	Format      recordio.Format
+	Conflict    recordio.ConflictPolicy
+	BypassHooks bool
+	BatchSize   int
+}
+
+func (payload *recordImportPayload) Decode(req *http.Request) skyerr.Error {
+	query := req.URL.Query()
+	payload.RecordType = query.Get("record_type")
+
+	var err error
+	if payload.Format, err = recordio.ParseFormat(query.Get("format")); err != nil {
+		return skyerr.NewInvalidArgument(err.Error(), []string{"format"})
+	}
+	if payload.Conflict, err = recordio.ParseConflictPolicy(query.Get("conflict")); err != nil {
+		return skyerr.NewInvalidArgument(err.Error(), []string{"conflict"})
+	}
+	if bypassHooks := query.Get("bypass_hooks"); bypassHooks != "" {
+		if payload.BypassHooks, err = strconv.ParseBool(bypassHooks); err != nil {
+			return skyerr.NewInvalidArgument("expect bypass_hooks to be a boolean", []string{"bypass_hooks"})
+		}
+	}
+	if batchSize := query.Get("batch_size"); batchSize != "" {
+		if payload.BatchSize, err = strconv.Atoi(batchSize); err != nil || payload.BatchSize <= 0 {
+			return skyerr.NewInvalidArgument("expect batch_size to be a positive integer", []string{"batch_size"})
+		}
+	}
+
+	return payload.Validate()
+}
+
+func (payload *recordImportPayload) Validate() skyerr.Error {
+	if payload.Format == recordio.CSV && payload.RecordType == "" {
+		return skyerr.NewInvalidArgument("record_type is required for csv", []string{"record_type"})
+	}
+	return nil
+}
+
+/*
+RecordImportHandler saves records streamed in the request body as NDJSON
+or CSV.
+curl -X POST -H "Content-Type: text/csv" \
+  -H "X-Skygear-Api-Key: MASTER_KEY" \
+  --data-binary @note.csv \
+  'http://localhost:3000/records/import?record_type=note&format=csv&conflict=skip'
+
+The import is configured by query parameters:
+
+  - format: `ndjson` (default) or `csv`
+  - record_type: the record type of the records in CSV
+  - conflict: what to do with a record which already exists, which is
+    `skip`, `overwrite` or `fail` (default)
+  - bypass_hooks: whether record save hooks are skipped
+  - batch_size: the number of records saved at a time
+
+Records are saved in batches as the body is read, so the records of the
+previous batches are saved even if the import is aborted. The result
+reports the number of records imported, skipped and failed, with the
+errors of failed rows:
+
+{
+    "result": {
+        "imported": 2,
+        "skipped": 1,
+        "failed": 1,
+        "errors": [{
+            "row": 4,
+            "_id": "note/4",
+            "error": {"name": "InvalidArgument", "code": 108, "message": "..."}
+        }]
+    }
+}
+
+Importing records requires master key and is only available for the
+public database. Assets saved to the imported records are checked against
+the asset policies of their fields.
+*/
+type RecordImportHandler struct {
+	AssetStore    asset.Store      `inject:"AssetStore"`
+	AssetPolicies asset.Policies   `inject:"AssetPolicies"`
+	HookRegistry  *hook.Registry   `inject:"HookRegistry"`
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"require_auth"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	CheckUser     router.Processor `preprocessor:"check_user"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *RecordImportHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.InjectDB,
+		h.CheckUser,
+		h.PluginReady,
+	}
+}
+
+func (h *RecordImportHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *RecordImportHandler) Handle(payload *router.Payload, response *router.Response) {
+	if !payload.HasMasterKey() {
+		response.Err = skyerr.NewError(skyerr.PermissionDenied, "importing records requires master key")
+		return
+	}
+
+	p := &recordImportPayload{}
+	if err := p.Decode(payload.Req); err != nil {
+		response.Err = err
+		return
+	}
+
+	db := payload.Database
+	if db.DatabaseType() != skydb.PublicDatabase || db.IsReadOnly() {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "importing records is only supported for the public database")
+		return
+	}
+
+	var schema skydb.RecordSchema
+	if p.Format == recordio.CSV {
+		// the record type may not exist before the import
+		schema, _ = db.GetSchema(p.RecordType)
+	}
+
+	importer := recordio.Importer{
+		Context:       payload.Context(),
+		Conn:          payload.DBConn,
+		Database:      db,
+		AuthInfo:      payload.AuthInfo,
+		AssetStore:    h.AssetStore,
+		AssetPolicies: h.AssetPolicies,
+		HookRegistry:  h.HookRegistry,
+		Conflict:      p.Conflict,
+		BatchSize:     p.BatchSize,
+	}
+	if p.BypassHooks {
+		importer.HookRegistry = nil
+	}
+
+	reader := recordio.NewReader(payload.Req.Body, p.Format, p.RecordType, schema)
+	result, err := importer.Import(reader)
+	if err != nil {
+		logger := logging.CreateLogger(payload.Context(), "handler")
+		logger.WithError(err).Errorf("Failed to import records")
+
+		skyErr := skyerr.MakeError(err)
+		response.Err = skyerr.NewErrorWithInfo(skyErr.Code(), skyErr.Message(), map[string]interface{}{
+			"result": result,
+		})
+		return
+	}
+
+	response.Result = result
+}
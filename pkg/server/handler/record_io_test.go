@@ -0,0 +1,204 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type exportDatabase struct {
+	queryResultsDatabase
+	lastquery *skydb.Query
+}
+
+func (db *exportDatabase) DatabaseType() skydb.DatabaseType {
+	return skydb.PublicDatabase
+}
+
+func (db *exportDatabase) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	db.lastquery = query
+	return db.queryResultsDatabase.Query(query, accessControlOptions)
+}
+
+func TestRecordExportHandler(t *testing.T) {
+	Convey("RecordExportHandler", t, func() {
+		db := &exportDatabase{
+			queryResultsDatabase: queryResultsDatabase{
+				records: []skydb.Record{
+					{
+						ID:   skydb.NewRecordID("note", "0"),
+						Data: skydb.Data{"title": "Zero", "order": float64(0)},
+					},
+					{
+						ID:   skydb.NewRecordID("note", "1"),
+						Data: skydb.Data{"title": "One", "order": float64(1)},
+					},
+				},
+				typemap: map[string]skydb.RecordSchema{
+					"note": {
+						"title": skydb.FieldType{Type: skydb.TypeString},
+						"order": skydb.FieldType{Type: skydb.TypeNumber},
+					},
+				},
+			},
+		}
+		accessKey := router.MasterAccessKey
+
+		gateway := newmodGateway("records/export")
+		gateway.Handle("POST", &RecordExportHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AccessKey = accessKey
+			p.AuthInfo = &skydb.AuthInfo{ID: "_god"}
+		})
+
+		Convey("exports records in NDJSON", func() {
+			resp := gateway.makeRequest("POST", "records/export", `{
+	"record_type": "note",
+	"predicate": ["gt", {"$type": "keypath", "$val": "order"}, -1]
+}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "application/x-ndjson")
+			So(resp.Header().Get("Content-Disposition"), ShouldEqual, `attachment; filename="note.ndjson"`)
+			So(db.lastquery.Type, ShouldEqual, "note")
+			So(db.lastquery.Predicate.Operator, ShouldEqual, skydb.GreaterThan)
+
+			lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+			So(lines, ShouldHaveLength, 2)
+			So([]byte(lines[1]), ShouldEqualJSON, `{
+	"_id": "note/1",
+	"_type": "record",
+	"_recordType": "note",
+	"_recordID": "1",
+	"_access": null,
+	"title": "One",
+	"order": 1
+}`)
+		})
+
+		Convey("exports records in CSV with fields in schema", func() {
+			resp := gateway.makeRequest("POST", "records/export", `{
+	"record_type": "note",
+	"format": "csv"
+}`)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Content-Type"), ShouldEqual, "text/csv")
+			So(resp.Body.String(), ShouldEqual, `_recordID,_ownerID,_created_at,_created_by,_updated_at,_updated_by,_access,order,title
+0,,,,,,null,0,Zero
+1,,,,,,null,1,One
+`)
+		})
+
+		Convey("rejects unknown format", func() {
+			resp := gateway.makeRequest("POST", "records/export", `{
+	"record_type": "note",
+	"format": "xml"
+}`)
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("requires master key", func() {
+			accessKey = router.ClientAccessKey
+			resp := gateway.makeRequest("POST", "records/export", `{
+	"record_type": "note"
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 102,
+		"message": "exporting records requires master key",
+		"name": "PermissionDenied"
+	}
+}`)
+		})
+	})
+}
+
+func TestRecordImportHandler(t *testing.T) {
+	Convey("RecordImportHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+		So(db.Save(&skydb.Record{
+			ID:   skydb.NewRecordID("note", "1"),
+			Data: skydb.Data{"title": "Existing"},
+		}), ShouldBeNil)
+		accessKey := router.MasterAccessKey
+
+		gateway := newmodGateway("records/import")
+		gateway.Handle("POST", &RecordImportHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AccessKey = accessKey
+			p.AuthInfo = &skydb.AuthInfo{ID: "_god"}
+		})
+
+		Convey("imports records in CSV", func() {
+			resp := gateway.makeRequest("POST", "records/import?record_type=note&format=csv&conflict=skip&bypass_hooks=true", `_recordID,title
+0,Zero
+1,One
+2
+`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"imported": 1,
+		"skipped": 1,
+		"failed": 1,
+		"errors": [{
+			"row": 3,
+			"error": {
+				"code": 108,
+				"message": "expected 2 columns, got 1",
+				"name": "InvalidArgument"
+			}
+		}]
+	}
+}`)
+			So(db.RecordMap["note/0"].Data["title"], ShouldEqual, "Zero")
+			So(db.RecordMap["note/1"].Data["title"], ShouldEqual, "Existing")
+		})
+
+		Convey("imports records in NDJSON", func() {
+			resp := gateway.makeRequest("POST", "records/import?conflict=overwrite", `{"_id":"note/1","title":"One"}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"imported": 1,
+		"skipped": 0,
+		"failed": 0,
+		"errors": []
+	}
+}`)
+			So(db.RecordMap["note/1"].Data["title"], ShouldEqual, "One")
+		})
+
+		Convey("requires record type for CSV", func() {
+			resp := gateway.makeRequest("POST", "records/import?format=csv", "_recordID\n0\n")
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("requires master key", func() {
+			accessKey = router.ClientAccessKey
+			resp := gateway.makeRequest("POST", "records/import", `{"_id":"note/0"}`)
+			So(resp.Code, ShouldEqual, http.StatusForbidden)
+			So(db.RecordMap, ShouldNotContainKey, "note/0")
+		})
+	})
+}
@@ -16,9 +16,14 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -30,6 +35,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/recordutil"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/mem"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/skygeario/skygear-server/pkg/server/skytest"
@@ -138,6 +144,35 @@ func TestRecordDeleteHandler(t *testing.T) {
 
 		})
 
+		Convey("soft-deletes records when soft delete is enabled", func() {
+			So(db.SetRecordSoftDelete("note", true), ShouldBeNil)
+			resp := router.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0","_recordType": "note","_recordID": "0", "_type": "record"}
+	]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "0"), &record), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.RecordMap["note/0"].DeletedAt.IsZero(), ShouldBeFalse)
+
+			resp = router.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0","_recordType": "note","_recordID": "0", "_type": "error", "code": 110, "message": "record not found", "name": "ResourceNotFound"}
+	]
+}`)
+		})
+
 		Convey("permission denied on delete a readonly record", func() {
 			resp := router.POST(`{
 				"records": [
@@ -160,6 +195,405 @@ func TestRecordDeleteHandler(t *testing.T) {
 	})
 }
 
+// referenceQueryDatabase is a MapDB which supports querying records by
+// the value of a reference field.
+type referenceQueryDatabase struct {
+	*skydbtest.MapDB
+}
+
+func (db *referenceQueryDatabase) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	field := query.Predicate.Children[0].(skydb.Expression).Value.(string)
+	ref := query.Predicate.Children[1].(skydb.Expression).Value.(skydb.Reference)
+
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		if record.ID.Type == query.Type && record.DeletedAt.IsZero() && record.Data[field] == ref {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID.Key < records[j].ID.Key
+	})
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func TestRecordDeleteHandlerOnDelete(t *testing.T) {
+	Convey("RecordDeleteHandler with on_delete constraints", t, func() {
+		noteRef := skydb.NewReference("note", "0")
+		conn := skydbtest.NewMapConn()
+		db := &referenceQueryDatabase{skydbtest.NewMapDB()}
+		onDelete := func(action skydb.ReferenceAction) skydb.FieldType {
+			return skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "note",
+				Constraints:   &skydb.FieldConstraints{OnDelete: action},
+			}
+		}
+		db.RecordSchemaMap["comment"] = skydb.RecordSchema{"note": onDelete(skydb.CascadeReference)}
+		db.RecordSchemaMap["reply"] = skydb.RecordSchema{
+			"comment": skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "comment",
+				Constraints:   &skydb.FieldConstraints{OnDelete: skydb.CascadeReference},
+			},
+		}
+		db.RecordSchemaMap["bookmark"] = skydb.RecordSchema{"note": onDelete(skydb.SetNullReference)}
+		db.RecordSchemaMap["pin"] = skydb.RecordSchema{"note": onDelete(skydb.RestrictReference)}
+
+		So(db.Save(&skydb.Record{ID: skydb.NewRecordID("note", "0")}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:   skydb.NewRecordID("comment", "0"),
+			Data: skydb.Data{"note": noteRef},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:   skydb.NewRecordID("reply", "0"),
+			Data: skydb.Data{"comment": skydb.NewReference("comment", "0")},
+		}), ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID:   skydb.NewRecordID("bookmark", "0"),
+			Data: skydb.Data{"note": noteRef, "title": "Saved"},
+		}), ShouldBeNil)
+
+		registry := hook.NewRegistry()
+		beforeDeleteHook := hooktest.StackingHook{}
+		afterSaveHook := hooktest.StackingHook{}
+		registry.Register(hook.BeforeDelete, "reply", beforeDeleteHook.Func)
+		registry.Register(hook.AfterSave, "bookmark", afterSaveHook.Func)
+
+		r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{
+			HookRegistry: registry,
+		}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("cascades deletion and sets null", func() {
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/0",
+		"_recordType": "note",
+		"_recordID": "0",
+		"_type": "record",
+		"_cascaded": [
+			{"_id": "bookmark/0", "_recordType": "bookmark", "_recordID": "0", "field": "note", "on_delete": "set_null"},
+			{"_id": "comment/0", "_recordType": "comment", "_recordID": "0", "field": "note", "on_delete": "cascade"},
+			{"_id": "reply/0", "_recordType": "reply", "_recordID": "0", "field": "comment", "on_delete": "cascade"}
+		]
+	}]
+}`)
+			So(db.RecordMap, ShouldNotContainKey, "note/0")
+			So(db.RecordMap, ShouldNotContainKey, "comment/0")
+			So(db.RecordMap, ShouldNotContainKey, "reply/0")
+			So(db.RecordMap["bookmark/0"].Data, ShouldResemble, skydb.Data{
+				"note":  nil,
+				"title": "Saved",
+			})
+
+			So(beforeDeleteHook.Records, ShouldHaveLength, 1)
+			So(beforeDeleteHook.Records[0].ID, ShouldResemble, skydb.NewRecordID("reply", "0"))
+			So(afterSaveHook.Records, ShouldHaveLength, 1)
+			So(afterSaveHook.OriginalRecords[0].Data["note"], ShouldResemble, noteRef)
+		})
+
+		Convey("fails if a restrict rule applies", func() {
+			So(db.Save(&skydb.Record{
+				ID:   skydb.NewRecordID("pin", "0"),
+				Data: skydb.Data{"note": noteRef},
+			}), ShouldBeNil)
+
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/0",
+		"_recordType": "note",
+		"_recordID": "0",
+		"_type": "error",
+		"code": 113,
+		"message": "cannot delete note/0 because pin/0 references it by field note",
+		"name": "ConstraintViolated",
+		"info": {
+			"id": "pin/0",
+			"field": "note",
+			"constraint": "on_delete"
+		}
+	}]
+}`)
+			So(db.RecordMap, ShouldContainKey, "note/0")
+			So(db.RecordMap, ShouldContainKey, "comment/0")
+			So(beforeDeleteHook.Records, ShouldBeEmpty)
+		})
+
+		Convey("does not identify restricting record not readable by user", func() {
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("pin", "0"),
+				OwnerID: "user1",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("user1", skydb.WriteLevel),
+				},
+				Data: skydb.Data{"note": noteRef},
+			}), ShouldBeNil)
+
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/0",
+		"_recordType": "note",
+		"_recordID": "0",
+		"_type": "error",
+		"code": 113,
+		"message": "cannot delete note/0 because a pin record references it by field note",
+		"name": "ConstraintViolated",
+		"info": {
+			"field": "note",
+			"constraint": "on_delete"
+		}
+	}]
+}`)
+			So(db.RecordMap, ShouldContainKey, "note/0")
+		})
+
+		Convey("fails if a cascaded record is not writable", func() {
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("comment", "1"),
+				OwnerID: "user1",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("user0", skydb.ReadLevel),
+				},
+				Data: skydb.Data{"note": noteRef},
+			}), ShouldBeNil)
+
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/0",
+		"_recordType": "note",
+		"_recordID": "0",
+		"_type": "error",
+		"code": 102,
+		"message": "cannot delete note/0 because comment/1 references it and is not writable",
+		"name": "PermissionDenied"
+	}]
+}`)
+			So(db.RecordMap, ShouldContainKey, "note/0")
+			So(db.RecordMap, ShouldContainKey, "comment/0")
+			So(db.RecordMap["bookmark/0"].Data["note"], ShouldResemble, noteRef)
+		})
+
+		Convey("deletes records cascaded by another record once", func() {
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" },
+		{ "_recordType": "comment", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/0",
+		"_recordType": "note",
+		"_recordID": "0",
+		"_type": "record",
+		"_cascaded": [
+			{"_id": "bookmark/0", "_recordType": "bookmark", "_recordID": "0", "field": "note", "on_delete": "set_null"},
+			{"_id": "comment/0", "_recordType": "comment", "_recordID": "0", "field": "note", "on_delete": "cascade"},
+			{"_id": "reply/0", "_recordType": "reply", "_recordID": "0", "field": "comment", "on_delete": "cascade"}
+		]
+	}, {
+		"_id": "comment/0",
+		"_recordType": "comment",
+		"_recordID": "0",
+		"_type": "record",
+		"_cascaded": [
+			{"_id": "reply/0", "_recordType": "reply", "_recordID": "0", "field": "comment", "on_delete": "cascade"}
+		]
+	}]
+}`)
+			So(db.RecordMap, ShouldNotContainKey, "comment/0")
+		})
+
+		Convey("cascades deletion in a transaction", func() {
+			txdb := skydbtest.NewMockTxDatabase(db)
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, func(p *router.Payload) {
+				p.DBConn = conn
+				p.Database = txdb
+				p.AuthInfo = &skydb.AuthInfo{
+					ID: "user0",
+				}
+			})
+
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+			So(txdb.DidBegin, ShouldBeTrue)
+			So(txdb.DidCommit, ShouldBeTrue)
+			So(txdb.DidRollback, ShouldBeFalse)
+			So(db.RecordMap, ShouldNotContainKey, "note/0")
+			So(db.RecordMap, ShouldNotContainKey, "reply/0")
+		})
+	})
+}
+
+func TestRecordUndeleteHandler(t *testing.T) {
+	Convey("RecordUndeleteHandler", t, func() {
+		note0 := skydb.Record{
+			ID:      skydb.NewRecordID("note", "0"),
+			OwnerID: "user0",
+			Data: map[string]interface{}{
+				"title": "Hello",
+			},
+		}
+		note1 := skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "user0",
+		}
+
+		conn := skydbtest.NewMapConn()
+		db := skydbtest.NewMapDB()
+		So(db.SetRecordSoftDelete("note", true), ShouldBeNil)
+		So(db.Save(&note0), ShouldBeNil)
+		So(db.Save(&note1), ShouldBeNil)
+		So(db.Delete(note0.ID), ShouldBeNil)
+
+		masterKey := router.MasterAccessKey
+		registry := hook.NewRegistry()
+		r := handlertest.NewSingleRouteRouter(&RecordUndeleteHandler{
+			HookRegistry: registry,
+		}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AccessKey = masterKey
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("undeletes soft-deleted records", func() {
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" },
+		{ "_recordType": "note", "_recordID": "1" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{
+			"_id": "note/0",
+			"_recordType": "note",
+			"_recordID": "0",
+			"_type": "record",
+			"_access": null,
+			"_ownerID": "user0",
+			"title": "Hello"
+		},
+		{"_id": "note/1","_recordType": "note","_recordID": "1", "_type": "error", "code": 110, "message": "record not found", "name": "ResourceNotFound"}
+	]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(note0.ID, &record), ShouldBeNil)
+			So(record.Data["title"], ShouldEqual, "Hello")
+		})
+
+		Convey("executes before and after save hooks", func() {
+			beforeHook := hooktest.StackingHook{}
+			afterHook := hooktest.StackingHook{}
+			registry.Register(hook.BeforeSave, "note", beforeHook.Func)
+			registry.Register(hook.AfterSave, "note", afterHook.Func)
+
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			So(beforeHook.Records, ShouldHaveLength, 1)
+			So(beforeHook.Records[0].ID, ShouldResemble, note0.ID)
+			So(beforeHook.OriginalRecords[0], ShouldBeNil)
+			So(afterHook.Records, ShouldHaveLength, 1)
+			So(afterHook.Records[0].ID, ShouldResemble, note0.ID)
+			So(afterHook.Records[0].DeletedAt.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("does not undelete record rejected by before save hook", func() {
+			registry.Register(hook.BeforeSave, "note", func(context.Context, *skydb.Record, *skydb.Record) skyerr.Error {
+				return skyerr.NewError(skyerr.PermissionDenied, "no undelete")
+			})
+
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [
+		{"_id": "note/0","_recordType": "note","_recordID": "0", "_type": "error", "code": 102, "message": "no undelete", "name": "PermissionDenied"}
+	]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(note0.ID, &record), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("rejects request without master key", func() {
+			masterKey = router.NoAccessKey
+			resp := r.POST(`{
+	"records": [
+		{ "_recordType": "note", "_recordID": "0" }
+	]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 102,
+		"message": "undeleting records requires master key",
+		"name": "PermissionDenied"
+	}
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(note0.ID, &record), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("rejects empty records", func() {
+			resp := r.POST(`{
+	"records": []
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "expected list of records",
+		"name": "InvalidArgument",
+		"info": {
+			"arguments": ["records"]
+		}
+	}
+}`)
+		})
+	})
+}
+
 // trueStore is a TokenStore that always noop on Put and assign itself on Get
 type trueStore authtoken.Token
 
@@ -222,6 +656,37 @@ func TestRecordSaveHandler(t *testing.T) {
 			}
 		})
 
+		Convey("does not restore soft-deleted record", func() {
+			So(db.SetRecordSoftDelete("note", true), ShouldBeNil)
+			db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", "deleted"),
+				OwnerID: "owner",
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("owner", skydb.WriteLevel),
+				},
+			})
+			So(db.Delete(skydb.NewRecordID("note", "deleted")), ShouldBeNil)
+
+			resp := r.POST(`{
+				"records": [{
+					"_id": "note/deleted",
+					"title": "Hijacked"
+				}]
+			}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": [{
+					"_id": "note/deleted",
+					"_recordType": "note",
+					"_recordID": "deleted",
+					"_type": "error",
+					"code": 131,
+					"message": "record has been deleted",
+					"name": "RecordConflict"
+				}]
+			}`)
+			So(db.RecordMap["note/deleted"].DeletedAt.IsZero(), ShouldBeFalse)
+		})
+
 		Convey("Saves multiple records", func() {
 			resp := r.POST(`{
 				"records": [{
@@ -879,6 +1344,10 @@ func (db bogusFieldDatabaseConnection) GetRecordFieldAccess() (skydb.FieldACL, e
 	return skydb.FieldACL{}, nil
 }
 
+func (db bogusFieldDatabaseConnection) GetRecordHistoryTypes() ([]string, error) {
+	return []string{}, nil
+}
+
 func (db bogusFieldDatabaseConnection) EnsureAuthRecordKeysValid(authRecordKeys [][]string) error {
 	return nil
 }
@@ -905,32 +1374,255 @@ func (db bogusFieldDatabase) Save(record *skydb.Record) error {
 	return db.SaveFunc(record)
 }
 
+func (db bogusFieldDatabase) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	return skydb.RecordSchema{}, nil
+}
+
 func (db bogusFieldDatabase) GetSchema(recordType string) (skydb.RecordSchema, error) {
 	return skydb.RecordSchema{}, nil
 }
 
-func TestRecordSaveBogusField(t *testing.T) {
-	realTimeNow := timeNow
-	timeNow = func() time.Time {
-		return ZeroTime
-	}
+func TestRecordSaveAssetPolicy(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
 	defer func() {
-		timeNow = realTimeNow
+		timeNow = realTime
 	}()
 
-	Convey("RecordSaveHandler", t, func() {
-		db := bogusFieldDatabase{}
-		conn := bogusFieldDatabaseConnection{}
-		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(payload *router.Payload) {
-			payload.DBConn = conn
-			payload.Database = db
-			payload.AuthInfo = &skydb.AuthInfo{
-				ID: "user0",
-			}
-		})
-
-		Convey("parse sequence field correctly", func(c C) {
-			db.SaveFunc = func(record *skydb.Record) error {
+	Convey("RecordSaveHandler with asset policy", t, func() {
+		db := skydbtest.NewMapDB()
+		conn := skydbtest.NewMapConn()
+		conn.AssetMap = map[string]skydb.Asset{
+			"image.png": skydb.Asset{
+				Name:        "image.png",
+				ContentType: "image/png",
+				Size:        10,
+			},
+		}
+		store := &mapAssetStore{files: map[string][]byte{
+			"image.png": []byte("I am a boy"),
+		}}
+
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{
+			AssetStore: store,
+			AssetPolicies: asset.Policies{
+				"note.image": asset.Policy{ContentTypes: []string{"image/*"}},
+			},
+		}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("rejects asset violating the policy of the field", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "id1",
+		"image": {"$type": "asset", "$name": "image.png"}
+	}]
+}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/id1",
+		"_recordType": "note",
+		"_recordID": "id1",
+		"_type": "error",
+		"code": 130,
+		"name": "AssetPolicyViolated",
+		"message": "Content type text/plain is not allowed",
+		"info": {
+			"field": "image",
+			"content_type": "text/plain",
+			"content_types": ["image/*"]
+		}
+	}]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "id1"), &record), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("rejects asset whose content cannot be read", func() {
+			conn.AssetMap["remote.png"] = skydb.Asset{
+				Name:        "remote.png",
+				ContentType: "image/png",
+				Size:        10,
+			}
+
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "id1",
+		"image": {"$type": "asset", "$name": "remote.png"}
+	}]
+}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/id1",
+		"_recordType": "note",
+		"_recordID": "id1",
+		"_type": "error",
+		"code": 130,
+		"name": "AssetPolicyViolated",
+		"message": "Content of the asset cannot be verified against the asset policy",
+		"info": {
+			"field": "image",
+			"name": "remote.png"
+		}
+	}]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "id1"), &record), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("saves asset to field without policy", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "id1",
+		"attachment": {"$type": "asset", "$name": "image.png"}
+	}]
+}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "id1"), &record), ShouldBeNil)
+		})
+	})
+}
+
+func TestRecordSaveFieldConstraints(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("RecordSaveHandler with field constraints", t, func() {
+		db := skydbtest.NewMapDB()
+		db.RecordSchemaMap["note"] = skydb.RecordSchema{
+			"title": skydb.FieldType{
+				Type:        skydb.TypeString,
+				Constraints: &skydb.FieldConstraints{Required: true},
+			},
+			"status": skydb.FieldType{
+				Type: skydb.TypeString,
+				Constraints: &skydb.FieldConstraints{
+					Default: "draft",
+					Enum:    []interface{}{"draft", "published"},
+				},
+			},
+		}
+
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("applies default value on create", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "id1",
+		"title": "Hello"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "id1"), &record), ShouldBeNil)
+			So(record.Get("status"), ShouldEqual, "draft")
+		})
+
+		Convey("rejects record without required field", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "id1",
+		"status": "published"
+	}]
+}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/id1",
+		"_recordType": "note",
+		"_recordID": "id1",
+		"_type": "error",
+		"code": 113,
+		"name": "ConstraintViolated",
+		"message": "title: value is required",
+		"info": {
+			"field": "title",
+			"constraint": "required"
+		}
+	}]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "id1"), &record), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("rejects value not in enum", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "id1",
+		"title": "Hello",
+		"status": "deleted"
+	}]
+}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/id1",
+		"_recordType": "note",
+		"_recordID": "id1",
+		"_type": "error",
+		"code": 113,
+		"name": "ConstraintViolated",
+		"message": "status: value is not one of [draft published]",
+		"info": {
+			"field": "status",
+			"constraint": "enum"
+		}
+	}]
+}`)
+		})
+	})
+}
+
+func TestRecordSaveBogusField(t *testing.T) {
+	realTimeNow := timeNow
+	timeNow = func() time.Time {
+		return ZeroTime
+	}
+	defer func() {
+		timeNow = realTimeNow
+	}()
+
+	Convey("RecordSaveHandler", t, func() {
+		db := bogusFieldDatabase{}
+		conn := bogusFieldDatabaseConnection{}
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(payload *router.Payload) {
+			payload.DBConn = conn
+			payload.Database = db
+			payload.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("parse sequence field correctly", func(c C) {
+			db.SaveFunc = func(record *skydb.Record) error {
 				c.So(record, ShouldResemble, &skydb.Record{
 					ID:        skydb.NewRecordID("record", "id"),
 					Data:      skydb.Data{},
@@ -1083,6 +1775,14 @@ func (db *queryDatabase) Query(query *skydb.Query, accessControlOptions *skydb.A
 	return skydb.EmptyRows, nil
 }
 
+func (db *queryDatabase) ExplainQuery(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (skydb.QueryExplanation, error) {
+	return skydb.QueryExplanation{
+		Statement: `SELECT * FROM "note"`,
+		Args:      []interface{}{},
+		Plan:      []interface{}{map[string]interface{}{"Execution Time": 0.1}},
+	}, nil
+}
+
 type queryResultsDatabase struct {
 	records    []skydb.Record
 	databaseID string
@@ -1243,6 +1943,110 @@ func TestRecordQuery(t *testing.T) {
 			})
 		})
 
+		Convey("Queries deleted records with master key", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type":     "note",
+					"include_deleted": true,
+				},
+				DBConn:    conn,
+				Database:  db,
+				AccessKey: router.MasterAccessKey,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldBeNil)
+			So(db.lastquery, ShouldResemble, &skydb.Query{
+				Type:           "note",
+				IncludeDeleted: true,
+			})
+		})
+
+		Convey("Rejects querying deleted records without master key", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type":     "note",
+					"include_deleted": true,
+				},
+				DBConn:   conn,
+				Database: db,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldNotBeNil)
+			So(response.Err.Code(), ShouldEqual, skyerr.PermissionDenied)
+			So(db.lastquery, ShouldBeNil)
+		})
+
+		Convey("Explains query with master key", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type": "note",
+					"explain":     true,
+				},
+				DBConn:    conn,
+				Database:  db,
+				AccessKey: router.MasterAccessKey,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldBeNil)
+			So(response.Info, ShouldResemble, map[string]interface{}{
+				"explain": map[string]interface{}{
+					"sql":  `SELECT * FROM "note"`,
+					"args": []interface{}{},
+					"plan": []interface{}{map[string]interface{}{"Execution Time": 0.1}},
+				},
+			})
+		})
+
+		Convey("Rejects explaining query without master key", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type": "note",
+					"explain":     true,
+				},
+				DBConn:   conn,
+				Database: db,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldNotBeNil)
+			So(response.Err.Code(), ShouldEqual, skyerr.PermissionDenied)
+			So(db.lastquery, ShouldBeNil)
+		})
+
+		Convey("Rejects non-boolean explain", func() {
+			payload := router.Payload{
+				Data: map[string]interface{}{
+					"record_type": "note",
+					"explain":     "yes",
+				},
+				DBConn:    conn,
+				Database:  db,
+				AccessKey: router.MasterAccessKey,
+			}
+			response := router.Response{}
+
+			handler := &RecordQueryHandler{}
+			handler.Handle(&payload, &response)
+
+			So(response.Err, ShouldNotBeNil)
+			So(response.Err.Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
 		Convey("Queries records with sorting", func() {
 			payload := router.Payload{
 				Data: map[string]interface{}{
@@ -1810,22 +2614,89 @@ func TestRecordQuery(t *testing.T) {
 				handler.Handle(&payload, &response)
 				So(response.Err, ShouldNotBeNil)
 			})
-		})
-	})
-}
-
-// a very naive Database that alway returns the single record set onto it
-type singleRecordDatabase struct {
-	record       skydb.Record
-	databaseID   string
-	recordSchema skydb.RecordSchema
-	skydb.Database
-}
 
-func (db *singleRecordDatabase) IsReadOnly() bool { return false }
+			Convey("should block non-comparable field in subquery", func() {
+				payload := router.Payload{
+					Data: map[string]interface{}{
+						"record_type": "note",
+						"predicate": []interface{}{
+							"exists",
+							map[string]interface{}{
+								"$type":        "subquery",
+								"$record_type": "note",
+								"$key":         "_id",
+								"$predicate": []interface{}{
+									"gt",
+									map[string]interface{}{
+										"$type": "keypath",
+										"$val":  "index",
+									},
+									float64(1),
+								},
+							},
+						},
+					},
+					DBConn:   conn,
+					Database: db,
+				}
+				response := router.Response{}
 
-func (db *singleRecordDatabase) ID() string {
-	if db.databaseID == "" {
+				handler := &RecordQueryHandler{}
+				handler.Handle(&payload, &response)
+				So(response.Err, ShouldNotBeNil)
+				So(response.Err.Code(), ShouldEqual, skyerr.RecordQueryDenied)
+			})
+
+			Convey("should allow discoverable field in subquery", func() {
+				payload := router.Payload{
+					Data: map[string]interface{}{
+						"record_type": "note",
+						"predicate": []interface{}{
+							"in",
+							map[string]interface{}{
+								"$type": "keypath",
+								"$val":  "_id",
+							},
+							map[string]interface{}{
+								"$type":        "subquery",
+								"$record_type": "note",
+								"$key":         "_id",
+								"$predicate": []interface{}{
+									"eq",
+									map[string]interface{}{
+										"$type": "keypath",
+										"$val":  "category",
+									},
+									"interesting",
+								},
+							},
+						},
+					},
+					DBConn:   conn,
+					Database: db,
+				}
+				response := router.Response{}
+
+				handler := &RecordQueryHandler{}
+				handler.Handle(&payload, &response)
+				So(response.Err, ShouldBeNil)
+			})
+		})
+	})
+}
+
+// a very naive Database that alway returns the single record set onto it
+type singleRecordDatabase struct {
+	record       skydb.Record
+	databaseID   string
+	recordSchema skydb.RecordSchema
+	skydb.Database
+}
+
+func (db *singleRecordDatabase) IsReadOnly() bool { return false }
+
+func (db *singleRecordDatabase) ID() string {
+	if db.databaseID == "" {
 		return skydb.PublicDatabaseIdentifier
 	}
 	return db.databaseID
@@ -2619,6 +3490,318 @@ func TestRecordQueryWithEagerLoad(t *testing.T) {
 	})
 }
 
+// includeQueryDatabase is a MapDB which supports fetching records by IDs
+// and querying records by a list of references with a partition limit, as
+// used in loading included records. It counts the number of fetches and
+// queries.
+type includeQueryDatabase struct {
+	*skydbtest.MapDB
+	fetches int
+	queries int
+}
+
+func (db *includeQueryDatabase) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	schema, ok := db.RecordSchemaMap[recordType]
+	if !ok {
+		return nil, skydb.ErrRecordNotFound
+	}
+	return schema, nil
+}
+
+func (db *includeQueryDatabase) GetByIDs(ids []skydb.RecordID, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	db.fetches++
+	records := []skydb.Record{}
+	for _, id := range ids {
+		record, ok := db.RecordMap[id.String()]
+		if ok && db.accessible(&record, accessControlOptions) {
+			records = append(records, record)
+		}
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *includeQueryDatabase) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	db.queries++
+	var (
+		field string
+		refs  []interface{}
+	)
+	if query.Predicate.Operator == skydb.In {
+		field = query.Predicate.Children[0].(skydb.Expression).Value.(string)
+		refs = query.Predicate.Children[1].(skydb.Expression).Value.([]interface{})
+	}
+
+	records := []skydb.Record{}
+	for _, record := range db.RecordMap {
+		if record.ID.Type != query.Type || !db.accessible(&record, accessControlOptions) {
+			continue
+		}
+		if field != "" && !referenceIn(record.Data[field], refs) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if len(query.Sorts) == 0 {
+			return records[i].ID.Key < records[j].ID.Key
+		}
+		keyPath := query.Sorts[0].Expression.Value.(string)
+		if query.Sorts[0].Order == skydb.Descending {
+			return records[i].Data[keyPath].(float64) > records[j].Data[keyPath].(float64)
+		}
+		return records[i].Data[keyPath].(float64) < records[j].Data[keyPath].(float64)
+	})
+
+	if query.PartitionLimit != nil {
+		counts := map[interface{}]uint64{}
+		limited := []skydb.Record{}
+		for _, record := range records {
+			value := record.Data[query.PartitionLimit.Field]
+			if counts[value] < query.PartitionLimit.Limit {
+				counts[value]++
+				limited = append(limited, record)
+			}
+		}
+		records = limited
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+func (db *includeQueryDatabase) accessible(record *skydb.Record, accessControlOptions *skydb.AccessControlOptions) bool {
+	return accessControlOptions.BypassAccessControl ||
+		record.Accessible(accessControlOptions.ViewAsUser, skydb.ReadLevel)
+}
+
+func referenceIn(value interface{}, refs []interface{}) bool {
+	for _, ref := range refs {
+		if value == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRecordQueryWithNestedAndReverseInclude(t *testing.T) {
+	Convey("Given posts with authors and comments in DB", t, func() {
+		conn := skydbtest.NewMapConn()
+		db := &includeQueryDatabase{MapDB: skydbtest.NewMapDB()}
+		db.RecordSchemaMap["post"] = skydb.RecordSchema{
+			"author": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "author"},
+		}
+		db.RecordSchemaMap["author"] = skydb.RecordSchema{
+			"company": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "company"},
+		}
+		db.RecordSchemaMap["company"] = skydb.RecordSchema{
+			"name": skydb.FieldType{Type: skydb.TypeString},
+		}
+		db.RecordSchemaMap["comment"] = skydb.RecordSchema{
+			"post":  skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "post"},
+			"order": skydb.FieldType{Type: skydb.TypeNumber},
+			"text":  skydb.FieldType{Type: skydb.TypeString},
+		}
+
+		records := []skydb.Record{
+			{
+				ID:   skydb.NewRecordID("post", "p1"),
+				Data: skydb.Data{"author": skydb.NewReference("author", "a1")},
+			},
+			{
+				ID:   skydb.NewRecordID("post", "p2"),
+				Data: skydb.Data{"author": skydb.NewReference("author", "a2")},
+			},
+			{
+				ID:   skydb.NewRecordID("post", "p3"),
+				Data: skydb.Data{"author": nil},
+			},
+			{
+				ID:   skydb.NewRecordID("author", "a1"),
+				Data: skydb.Data{"company": skydb.NewReference("company", "c1")},
+			},
+			{
+				ID:   skydb.NewRecordID("author", "a2"),
+				Data: skydb.Data{"company": skydb.NewReference("company", "c2")},
+			},
+			{
+				ID:   skydb.NewRecordID("company", "c1"),
+				Data: skydb.Data{"name": "Oursky"},
+			},
+			{
+				ID:   skydb.NewRecordID("company", "c2"),
+				Data: skydb.Data{"name": "Secret"},
+				ACL: skydb.RecordACL{
+					skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+				},
+			},
+		}
+		for i, order := range []float64{1, 2, 3, 4} {
+			records = append(records, skydb.Record{
+				ID: skydb.NewRecordID("comment", strconv.Itoa(i)),
+				Data: skydb.Data{
+					"post":  skydb.NewReference("post", "p1"),
+					"order": order,
+					"text":  "Comment " + strconv.Itoa(i),
+				},
+			})
+		}
+		// the latest comment of p1 is not readable by user0
+		records[len(records)-1].ACL = skydb.RecordACL{
+			skydb.NewRecordACLEntryDirect("user1", skydb.ReadLevel),
+		}
+		records = append(records, skydb.Record{
+			ID: skydb.NewRecordID("comment", "4"),
+			Data: skydb.Data{
+				"post":  skydb.NewReference("post", "p2"),
+				"order": float64(1),
+				"text":  "Comment 4",
+			},
+		})
+		for i := range records {
+			So(db.Save(&records[i]), ShouldBeNil)
+		}
+
+		r := handlertest.NewSingleRouteRouter(&RecordQueryHandler{}, func(p *router.Payload) {
+			p.Database = db
+			p.DBConn = conn
+			p.AuthInfo = &skydb.AuthInfo{ID: "user0"}
+		})
+
+		transients := func(resp *httptest.ResponseRecorder, key string) []interface{} {
+			body := struct {
+				Result []map[string]interface{} `json:"result"`
+			}{}
+			So(json.Unmarshal(resp.Body.Bytes(), &body), ShouldBeNil)
+			values := []interface{}{}
+			for _, record := range body.Result {
+				values = append(values, record["_transient"].(map[string]interface{})[key])
+			}
+			return values
+		}
+
+		Convey("includes records at a nested keypath with a fetch per level", func() {
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"author": {"$type": "keypath", "$val": "author"},
+					"company": {"$type": "keypath", "$val": "author.company"}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			So(db.fetches, ShouldEqual, 2)
+			So(transients(resp, "company"), ShouldResemble, []interface{}{
+				map[string]interface{}{
+					"_id":         "company/c1",
+					"_recordType": "company",
+					"_recordID":   "c1",
+					"_type":       "record",
+					"_access":     nil,
+					"name":        "Oursky",
+				},
+				// c2 is not readable by user0
+				nil,
+				nil,
+			})
+			So(transients(resp, "author")[1], ShouldContainKey, "company")
+		})
+
+		Convey("includes records referencing the queried records", func() {
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"comments": {
+						"$type": "reverse",
+						"$record_type": "comment",
+						"$field": "post",
+						"$sort": [[{"$type": "keypath", "$val": "order"}, "desc"]],
+						"$limit": 2
+					}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			So(db.queries, ShouldEqual, 2)
+
+			ids := [][]string{}
+			for _, comments := range transients(resp, "comments") {
+				commentIDs := []string{}
+				for _, comment := range comments.([]interface{}) {
+					commentIDs = append(commentIDs, comment.(map[string]interface{})["_id"].(string))
+				}
+				ids = append(ids, commentIDs)
+			}
+			So(ids, ShouldResemble, [][]string{
+				{"comment/2", "comment/1"},
+				{"comment/4"},
+				{},
+			})
+		})
+
+		Convey("scrubs fields of included records by field ACL", func() {
+			publicRole := skydb.FieldUserRole{Type: skydb.PublicFieldUserRoleType}
+			conn.SetRecordFieldAccess(skydb.NewFieldACL(skydb.FieldACLEntryList{
+				{
+					RecordType:   "comment",
+					RecordField:  "text",
+					UserRole:     publicRole,
+					Writable:     true,
+					Readable:     false,
+					Comparable:   true,
+					Discoverable: true,
+				},
+			}))
+
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"comments": {"$type": "reverse", "$record_type": "comment", "$field": "post"}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			comments := transients(resp, "comments")[1].([]interface{})
+			So(comments, ShouldHaveLength, 1)
+			So(comments[0], ShouldNotContainKey, "text")
+			So(comments[0], ShouldContainKey, "order")
+		})
+
+		Convey("rejects reverse include on a field not referencing the queried type", func() {
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"comments": {"$type": "reverse", "$record_type": "comment", "$field": "text"}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 120,
+					"message": "field \"text\" of record type \"comment\" in reverse include \"comments\" is not a reference to \"post\"",
+					"name": "RecordQueryInvalid"
+				}
+			}`)
+		})
+
+		Convey("rejects reverse include without field", func() {
+			resp := r.POST(`{
+				"record_type": "post",
+				"include": {
+					"comments": {"$type": "reverse", "$record_type": "comment"}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "reverse include \"comments\" requires $record_type and $field",
+					"name": "InvalidArgument",
+					"info": {"arguments": ["include"]}
+				}
+			}`)
+		})
+	})
+}
+
 func TestRecordQueryWithCount(t *testing.T) {
 	Convey("Given a Database with records", t, func() {
 		record0 := skydb.Record{
@@ -2783,6 +3966,33 @@ func TestHookExecution(t *testing.T) {
 				So(afterHook.Records, ShouldBeEmpty)
 			})
 		}
+
+		Convey("executes BeforeDelete and AfterDelete action hooks on soft delete", func() {
+			registry.Register(hook.BeforeDelete, "record", beforeHook.Func)
+			registry.Register(hook.AfterDelete, "record", afterHook.Func)
+
+			db := skydbtest.NewMapDB()
+			So(db.SetRecordSoftDelete("record", true), ShouldBeNil)
+			So(db.Save(record), ShouldBeNil)
+
+			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{
+				HookRegistry: registry,
+			}, func(p *router.Payload) {
+				p.Database = db
+				p.DBConn = skydbtest.NewMapConn()
+				p.AuthInfo = &skydb.AuthInfo{
+					ID: "user0",
+				}
+			})
+
+			r.POST(`{"ids": ["record/id"]}`)
+
+			So(len(beforeHook.Records), ShouldEqual, 1)
+			So(beforeHook.Records[0].ID, ShouldResemble, record.ID)
+			So(len(afterHook.Records), ShouldEqual, 1)
+			So(afterHook.Records[0].ID, ShouldResemble, record.ID)
+			So(db.RecordMap["record/id"].DeletedAt.IsZero(), ShouldBeFalse)
+		})
 	})
 
 	Convey("HookRegistry", t, func() {
@@ -3007,6 +4217,479 @@ func (db *selectiveDatabase) Rollback() error {
 	return db.Database.(skydb.TxDatabase).Rollback()
 }
 
+func TestRecordSaveHandlerPrecondition(t *testing.T) {
+	realTime := timeNow
+	timeNow = func() time.Time { return ZeroTime }
+	defer func() {
+		timeNow = realTime
+	}()
+
+	Convey("RecordSaveHandler with precondition", t, func() {
+		updatedAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		conn := skydbtest.NewMapConn()
+		backingDB := skydbtest.NewMapDB()
+		So(backingDB.Save(&skydb.Record{
+			ID:        skydb.NewRecordID("note", "1"),
+			OwnerID:   "user0",
+			CreatedAt: updatedAt,
+			CreatorID: "user0",
+			UpdatedAt: updatedAt,
+			UpdaterID: "user0",
+			Data: skydb.Data{
+				"title": "Hello",
+			},
+		}), ShouldBeNil)
+
+		var db skydb.Database = backingDB
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("saves record matching the expected updated at", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_updated_at": "2006-01-02T15:04:05Z",
+		"title": "Bonjour"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data["title"], ShouldEqual, "Bonjour")
+		})
+
+		Convey("rejects record modified since the expected updated at", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_updated_at": "2006-01-01T00:00:00Z",
+		"title": "Bonjour"
+	}, {
+		"_recordType": "note",
+		"_recordID": "2",
+		"title": "World"
+	}]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/1",
+		"_recordType": "note",
+		"_recordID": "1",
+		"_type": "error",
+		"code": 131,
+		"name": "RecordConflict",
+		"message": "record has been modified since it was fetched",
+		"info": {
+			"record": {
+				"_id": "note/1",
+				"_type": "record",
+				"_recordType": "note",
+				"_recordID": "1",
+				"_access": null,
+				"_ownerID": "user0",
+				"_created_at": "2006-01-02T15:04:05Z",
+				"_created_by": "user0",
+				"_updated_at": "2006-01-02T15:04:05Z",
+				"_updated_by": "user0",
+				"title": "Hello"
+			}
+		}
+	}, {
+		"_id": "note/2",
+		"_type": "record",
+		"_recordType": "note",
+		"_recordID": "2",
+		"_access": null,
+		"_ownerID": "user0",
+		"_created_by": "user0",
+		"_updated_by": "user0",
+		"title": "World"
+	}]
+}`)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data["title"], ShouldEqual, "Hello")
+		})
+
+		Convey("rejects new record with expected updated at", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "2",
+		"_expected_updated_at": "2006-01-02T15:04:05Z",
+		"title": "World"
+	}]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/2",
+		"_recordType": "note",
+		"_recordID": "2",
+		"_type": "error",
+		"code": 131,
+		"name": "RecordConflict",
+		"message": "record has been modified since it was fetched",
+		"info": {
+			"record": null
+		}
+	}]
+}`)
+		})
+
+		Convey("checks the expected revision", func() {
+			So(conn.SetRecordHistoryEnabled("note", true), ShouldBeNil)
+			So(conn.SaveRecordRevision(&skydb.RecordRevision{
+				RecordID: skydb.NewRecordID("note", "1"),
+				Action:   skydb.RecordRevisionSave,
+			}), ShouldBeNil)
+
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_revision": 1,
+		"title": "Bonjour"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			resp = r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_revision": 1,
+		"title": "Hola"
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+			result := struct {
+				Result []map[string]interface{} `json:"result"`
+			}{}
+			So(json.Unmarshal(resp.Body.Bytes(), &result), ShouldBeNil)
+			So(result.Result[0]["code"], ShouldEqual, skyerr.RecordConflict)
+			info := result.Result[0]["info"].(map[string]interface{})
+			So(info["revision"], ShouldEqual, 2)
+			So(info["record"].(map[string]interface{})["title"], ShouldEqual, "Bonjour")
+		})
+
+		Convey("rejects expected revision without record history", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_revision": 0,
+		"title": "Bonjour"
+	}]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/1",
+		"_recordType": "note",
+		"_recordID": "1",
+		"_type": "error",
+		"code": 108,
+		"name": "InvalidArgument",
+		"message": "record history of note is not enabled",
+		"info": {
+			"arguments": ["_expected_revision"]
+		}
+	}]
+}`)
+		})
+
+		Convey("rejects malformed precondition", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_revision": -1
+	}]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_type": "error",
+		"code": 108,
+		"name": "InvalidArgument",
+		"message": "_expected_revision is not a non-negative integer"
+	}]
+}`)
+		})
+
+		Convey("rolls back atomic save on conflict", func() {
+			txDB := skydbtest.NewMockTxDatabase(backingDB)
+			db = txDB
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "2",
+		"title": "World"
+	}, {
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_updated_at": "2006-01-01T00:00:00Z",
+		"title": "Bonjour"
+	}],
+	"atomic": true
+}`)
+			result := struct {
+				Error struct {
+					Code skyerr.ErrorCode                  `json:"code"`
+					Info map[string]map[string]interface{} `json:"info"`
+				} `json:"error"`
+			}{}
+			So(json.Unmarshal(resp.Body.Bytes(), &result), ShouldBeNil)
+			So(result.Error.Code, ShouldEqual, skyerr.AtomicOperationFailure)
+			So(result.Error.Info["note/1"]["code"], ShouldEqual, skyerr.RecordConflict)
+			So(result.Error.Info["note/1"]["info"], ShouldContainKey, "record")
+			So(txDB.DidCommit, ShouldBeFalse)
+			So(txDB.DidRollback, ShouldBeTrue)
+		})
+	})
+}
+
+func TestRecordSaveHandlerConcurrentPrecondition(t *testing.T) {
+	Convey("RecordSaveHandler with concurrent saves", t, func() {
+		updatedAt := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		conns := make(chan skydb.Conn, 2)
+		for i := 0; i < cap(conns); i++ {
+			c, err := mem.Open(context.Background(), "app", skydb.RoleBasedAccess, "TestRecordSaveHandlerConcurrentPrecondition", skydb.DBConfig{
+				CanMigrate: true,
+			})
+			So(err, ShouldBeNil)
+			conns <- c
+		}
+
+		c := <-conns
+		_, err := c.PublicDB().Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		So(c.PublicDB().Save(&skydb.Record{
+			ID:        skydb.NewRecordID("note", "1"),
+			OwnerID:   "user0",
+			CreatedAt: updatedAt,
+			CreatorID: "user0",
+			UpdatedAt: updatedAt,
+			UpdaterID: "user0",
+			Data: skydb.Data{
+				"title": "Hello",
+			},
+		}), ShouldBeNil)
+		conns <- c
+
+		// both saves pass the precondition check before either of them
+		// is written
+		var fetched sync.WaitGroup
+		fetched.Add(cap(conns))
+		registry := hook.NewRegistry()
+		registry.Register(hook.BeforeSave, "note", func(context.Context, *skydb.Record, *skydb.Record) skyerr.Error {
+			fetched.Done()
+			fetched.Wait()
+			return nil
+		})
+
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{
+			HookRegistry: registry,
+		}, func(p *router.Payload) {
+			c := <-conns
+			p.DBConn = c
+			p.Database = c.PublicDB()
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("saves only one record with the same expected updated at", func() {
+			results := make(chan []map[string]interface{}, cap(conns))
+			for _, title := range []string{"Bonjour", "Hola"} {
+				go func(title string) {
+					resp := r.POST(fmt.Sprintf(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"_expected_updated_at": "2006-01-02T15:04:05Z",
+		"title": "%s"
+	}]
+}`, title))
+					result := struct {
+						Result []map[string]interface{} `json:"result"`
+					}{}
+					json.Unmarshal(resp.Body.Bytes(), &result)
+					results <- result.Result
+				}(title)
+			}
+
+			saved := []string{}
+			conflicted := 0
+			for i := 0; i < cap(conns); i++ {
+				result := <-results
+				So(result, ShouldHaveLength, 1)
+				if result[0]["_type"] == "record" {
+					saved = append(saved, result[0]["title"].(string))
+				} else {
+					So(result[0]["code"], ShouldEqual, skyerr.RecordConflict)
+					conflicted++
+				}
+			}
+			So(saved, ShouldHaveLength, 1)
+			So(conflicted, ShouldEqual, 1)
+
+			record := skydb.Record{}
+			c, err := mem.Open(context.Background(), "app", skydb.RoleBasedAccess, "TestRecordSaveHandlerConcurrentPrecondition", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+			So(c.PublicDB().Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data["title"], ShouldEqual, saved[0])
+		})
+	})
+}
+
+func TestRecordSaveHandlerFieldOperation(t *testing.T) {
+	Convey("RecordSaveHandler with field operations", t, func() {
+		db := skydbtest.NewMapDB()
+		So(db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "user0",
+			Data: skydb.Data{
+				"title": "Hello",
+				"count": float64(1),
+				"tags":  []interface{}{"a", "b", "a"},
+			},
+		}), ShouldBeNil)
+
+		r := handlertest.NewSingleRouteRouter(&RecordSaveHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+			p.AuthInfo = &skydb.AuthInfo{
+				ID: "user0",
+			}
+		})
+
+		Convey("increments number and pushes items", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"count": {"$inc": 2},
+		"tags": {"$push": ["c"]}
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data["count"], ShouldEqual, 3)
+			So(record.Data["tags"], ShouldResemble, []interface{}{"a", "b", "a", "c"})
+			So(record.Data["title"], ShouldEqual, "Hello")
+		})
+
+		Convey("pulls items and takes the max number", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"count": {"$max": 0},
+		"tags": {"$pull": ["a"]}
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data["count"], ShouldEqual, 1)
+			So(record.Data["tags"], ShouldResemble, []interface{}{"b"})
+		})
+
+		Convey("applies operations on new record", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "2",
+		"count": {"$inc": 2},
+		"tags": {"$pull": ["a"]}
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "2"), &record), ShouldBeNil)
+			So(record.Data["count"], ShouldEqual, 2)
+			So(record.Data["tags"], ShouldResemble, []interface{}{})
+		})
+
+		Convey("saves dictionary which is not an operation as is", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"meta": {"$inc": 1, "other": 2}
+	}]
+}`)
+			So(resp.Code, ShouldEqual, 200)
+
+			record := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "1"), &record), ShouldBeNil)
+			So(record.Data["meta"], ShouldResemble, map[string]interface{}{
+				"$inc":  float64(1),
+				"other": float64(2),
+			})
+		})
+
+		Convey("rejects operation on field of wrong type", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"title": {"$inc": 1}
+	}]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_id": "note/1",
+		"_recordType": "note",
+		"_recordID": "1",
+		"_type": "error",
+		"code": 108,
+		"name": "InvalidArgument",
+		"message": "$inc cannot be applied to field title of type string",
+		"info": {
+			"arguments": ["title"],
+			"field": "title",
+			"operator": "$inc",
+			"type": "string"
+		}
+	}]
+}`)
+		})
+
+		Convey("rejects malformed operation", func() {
+			resp := r.POST(`{
+	"records": [{
+		"_recordType": "note",
+		"_recordID": "1",
+		"count": {"$inc": "1"}
+	}]
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": [{
+		"_type": "error",
+		"code": 108,
+		"name": "InvalidArgument",
+		"message": "invalid field operation of count: $inc expects a number, got string"
+	}]
+}`)
+		})
+	})
+}
+
 func TestAtomicOperation(t *testing.T) {
 	realTime := timeNow
 	timeNow = func() time.Time { return ZeroTime }
@@ -3196,6 +4879,7 @@ func TestAtomicOperation(t *testing.T) {
 			}), ShouldBeNil)
 
 			r := handlertest.NewSingleRouteRouter(&RecordDeleteHandler{}, func(payload *router.Payload) {
+				payload.DBConn = conn
 				payload.Database = db
 				payload.AuthInfo = &skydb.AuthInfo{
 					ID: "user0",
@@ -22,6 +22,7 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/logging"
 	pluginEvent "github.com/skygeario/skygear-server/pkg/server/plugin/event"
 	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/schemafile"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
@@ -241,6 +242,158 @@ func (h *SchemaDeleteHandler) Handle(rpayload *router.Payload, response *router.
 	}
 }
 
+/*
+SchemaAlterTypeHandler handles the action of changing the type of a column.
+Existing values are converted by the conversion rule of the types. With
+dry_run, the schema is left unchanged and the records that would fail the
+conversion are reported.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/alter_type <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:alter_type",
+	"record_type": "student",
+	"item_name": "score",
+	"type": "integer",
+	"dry_run": true
+}
+EOF
+*/
+type SchemaAlterTypeHandler struct {
+	EventSender   pluginEvent.Sender `inject:"PluginEventSender"`
+	AccessKey     router.Processor   `preprocessor:"accesskey"`
+	DevOnly       router.Processor   `preprocessor:"dev_only"`
+	DBConn        router.Processor   `preprocessor:"dbconn"`
+	InjectDB      router.Processor   `preprocessor:"inject_db"`
+	PluginReady   router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaAlterTypeHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaAlterTypeHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaAlterTypePayload struct {
+	RecordType string `mapstructure:"record_type"`
+	ColumnName string `mapstructure:"item_name"`
+	TypeName   string `mapstructure:"type"`
+	DryRun     bool   `mapstructure:"dry_run"`
+
+	FieldType skydb.FieldType `mapstructure:"-"`
+}
+
+func (payload *schemaAlterTypePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaAlterTypePayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.ColumnName == "" {
+		missingArgs = append(missingArgs, "item_name")
+	}
+	if payload.TypeName == "" {
+		missingArgs = append(missingArgs, "type")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+	if strings.HasPrefix(payload.RecordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	if strings.HasPrefix(payload.ColumnName, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved key", []string{"item_name"})
+	}
+
+	fieldType, err := skydb.SimpleNameToFieldType(payload.TypeName)
+	if err != nil {
+		return skyerr.NewInvalidArgument("unexpected field type", []string{payload.TypeName})
+	}
+	payload.FieldType = fieldType
+	return nil
+}
+
+type schemaAlterTypeResponse struct {
+	RecordType    string                     `json:"record_type"`
+	ColumnName    string                     `json:"item_name"`
+	From          string                     `json:"from"`
+	To            string                     `json:"to"`
+	Conversion    string                     `json:"conversion"`
+	FailedCount   uint64                     `json:"failed_count"`
+	FailedRecords []string                   `json:"failed_records"`
+	Applied       bool                       `json:"applied"`
+	Schemas       map[string]schemaFieldList `json:"record_types,omitempty"`
+}
+
+func (h *SchemaAlterTypeHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	logger := logging.CreateLogger(rpayload.Context(), "handler")
+	payload := &schemaAlterTypePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	report, err := db.AlterSchemaType(payload.RecordType, payload.ColumnName, payload.FieldType, payload.DryRun)
+	if skyErr, ok := err.(skyerr.Error); ok {
+		response.Err = skyErr
+		return
+	} else if err != nil {
+		response.Err = skyerr.NewError(skyerr.IncompatibleSchema, err.Error())
+		return
+	}
+
+	result := &schemaAlterTypeResponse{
+		RecordType:    payload.RecordType,
+		ColumnName:    payload.ColumnName,
+		From:          skydb.FieldType{Type: report.Conversion.From}.ToSimpleName(),
+		To:            payload.FieldType.ToSimpleName(),
+		Conversion:    report.Conversion.Description,
+		FailedCount:   report.FailedCount,
+		FailedRecords: []string{},
+		Applied:       !payload.DryRun,
+	}
+	for _, id := range report.FailedRecordIDs {
+		result.FailedRecords = append(result.FailedRecords, id.String())
+	}
+
+	if payload.DryRun {
+		response.Result = result
+		return
+	}
+
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	result.Schemas = encodeRecordSchemas(schemas)
+	response.Result = result
+
+	if h.EventSender != nil {
+		err := sendSchemaChangedEvent(h.EventSender, db)
+		if err != nil {
+			logger.WithError(err).Warn("Fail to send schema changed event")
+		}
+	}
+}
+
 /*
 SchemaCreateHandler handles the action of creating new columns
 curl -X POST -H "Content-Type: application/json" \
@@ -298,11 +451,19 @@ func (payload *schemaCreatePayload) Decode(data map[string]interface{}) skyerr.E
 	for recordType, schema := range payload.RawSchemas {
 		payload.Schemas[recordType] = make(skydb.RecordSchema)
 		for _, field := range schema.Fields {
-			var err error
-			payload.Schemas[recordType][field.Name], err = skydb.SimpleNameToFieldType(field.TypeName)
+			fieldType, err := skydb.SimpleNameToFieldType(field.TypeName)
 			if err != nil {
 				return skyerr.NewInvalidArgument("unexpected field type", []string{field.TypeName})
 			}
+
+			if !field.FieldConstraints.IsEmpty() {
+				if err := field.FieldConstraints.ValidateDefinition(fieldType); err != nil {
+					return skyerr.NewInvalidArgument(err.Error(), []string{field.Name})
+				}
+				constraints := field.FieldConstraints
+				fieldType.Constraints = &constraints
+			}
+			payload.Schemas[recordType][field.Name] = fieldType
 		}
 	}
 
@@ -337,7 +498,10 @@ func (h *SchemaCreateHandler) Handle(rpayload *router.Payload, response *router.
 	db := rpayload.Database
 	for recordType, recordSchema := range payload.Schemas {
 		_, err := db.Extend(recordType, recordSchema)
-		if err != nil {
+		if skyErr, ok := err.(skyerr.Error); ok && skyErr.Code() == skyerr.ConstraintViolated {
+			response.Err = skyErr
+			return
+		} else if err != nil {
 			response.Err = skyerr.NewError(skyerr.IncompatibleSchema, err.Error())
 			return
 		}
@@ -605,172 +769,899 @@ func (h *SchemaDefaultAccessHandler) Handle(rpayload *router.Payload, response *
 	}
 }
 
-type schemaFieldAccessResponse struct {
-	Access skydb.FieldACLEntryList `json:"access"`
-}
-
-func (r schemaFieldAccessResponse) WithAccess(fieldACL skydb.FieldACL) schemaFieldAccessResponse {
-	fieldACLEntries := fieldACL.AllEntries()
-	if len(fieldACLEntries) == 0 {
-		// Make sure the response contains array with 0 items rather than nil.
-		fieldACLEntries = make(skydb.FieldACLEntryList, 0)
-	} else {
-		sort.Sort(fieldACLEntries)
-	}
-	r.Access = fieldACLEntries
-	return r
-}
-
 /*
-SchemaFieldAccessGetHandler fetches the entire Field ACL settings.
+SchemaHistorySetHandler enables or disables keeping history of records
+of a type
 curl -X POST -H "Content-Type: application/json" \
-  -d @- http://localhost:3000/schema/default_access <<EOF
-{
-	"api_key": "MASTER_KEY",
-	"action": "schema:field_access:get",
-}
-
+  -d @- http://localhost:3000/schema/history/set <<EOF
 {
-  "result": [
-    {
-      "record_type": "User",
-      "record_field": "*",
-      "user_role": "_owner",
-      "writable": true,
-      "readable": true,
-      "comparable": true,
-      "discoverable": true
-    }
-  ]
+	"master_key": "MASTER_KEY",
+	"action": "schema:history:set",
+	"type": "note",
+	"enabled": true
 }
 EOF
 */
-type SchemaFieldAccessGetHandler struct {
-	Authenticator router.Processor `preprocessor:"authenticator"`
+type SchemaHistorySetHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
 	DBConn        router.Processor `preprocessor:"dbconn"`
-	InjectAuth    router.Processor `preprocessor:"inject_auth"`
-	RequireAdmin  router.Processor `preprocessor:"require_admin"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
 	PluginReady   router.Processor `preprocessor:"plugin_ready"`
 	preprocessors []router.Processor
 }
 
-func (h *SchemaFieldAccessGetHandler) Setup() {
+type schemaHistorySetPayload struct {
+	Type    string `mapstructure:"type"`
+	Enabled bool   `mapstructure:"enabled"`
+}
+
+type schemaHistoryResponse struct {
+	RecordTypes []string `json:"record_types"`
+}
+
+func (h *SchemaHistorySetHandler) Setup() {
 	h.preprocessors = []router.Processor{
-		h.Authenticator,
+		h.AccessKey,
+		h.DevOnly,
 		h.DBConn,
-		h.InjectAuth,
-		h.RequireAdmin,
+		h.InjectDB,
 		h.PluginReady,
 	}
 }
 
-func (h *SchemaFieldAccessGetHandler) GetPreprocessors() []router.Processor {
+func (h *SchemaHistorySetHandler) GetPreprocessors() []router.Processor {
 	return h.preprocessors
 }
 
-func (h *SchemaFieldAccessGetHandler) Handle(rpayload *router.Payload, response *router.Response) {
-	c := rpayload.DBConn
-	fieldACL, err := c.GetRecordFieldAccess()
-
-	if err != nil {
-		response.Err = skyerr.MakeError(err)
-		return
+func (payload *schemaHistorySetPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
 	}
 
-	response.Result = schemaFieldAccessResponse{}.WithAccess(fieldACL)
+	return payload.Validate()
 }
 
-// SchemaFieldAcccssUpdateHandler
+func (payload *schemaHistorySetPayload) Validate() skyerr.Error {
+	if payload.Type == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"type"})
+	}
 
-type schemaFieldAccessUpdatePayload struct {
-	RawAccess []map[string]interface{} `mapstructure:"access"`
-	FieldACL  skydb.FieldACL
+	return nil
 }
 
-func (payload *schemaFieldAccessUpdatePayload) Decode(data map[string]interface{}) skyerr.Error {
-	if err := mapstructure.Decode(data, payload); err != nil {
-		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+func (h *SchemaHistorySetHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := schemaHistorySetPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
 	}
 
-	entries := skydb.FieldACLEntryList{}
-	for _, v := range payload.RawAccess {
-		ace := skydb.FieldACLEntry{}
-		if err := (*skyconv.MapFieldACLEntry)(&ace).FromMap(v); err != nil {
-			return skyerr.NewInvalidArgument("invalid access entry", []string{"access"})
-		}
-		entries = append(entries, ace)
+	c := rpayload.DBConn
+	if err := c.SetRecordHistoryEnabled(payload.Type, payload.Enabled); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
 	}
 
-	payload.FieldACL = skydb.NewFieldACL(entries)
-	return payload.Validate()
-}
+	recordTypes, err := c.GetRecordHistoryTypes()
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
 
-func (payload *schemaFieldAccessUpdatePayload) Validate() skyerr.Error {
-	return nil
+	response.Result = schemaHistoryResponse{
+		RecordTypes: recordTypes,
+	}
 }
 
 /*
-SchemaFieldAccessUpdateHandler fetches the entire Field ACL settings.
+SchemaHistoryFetchHandler returns the record types with history enabled
 curl -X POST -H "Content-Type: application/json" \
-  -d @- http://localhost:3000/schema/default_access <<EOF
-{
-	"api_key": "MASTER_KEY",
-	"action": "schema:field_access:get",
-}
-
+  -d @- http://localhost:3000/schema/history/fetch <<EOF
 {
-  "result": [
-    {
-      "record_type": "User",
-      "record_field": "*",
-      "user_role": "_owner",
-      "writable": true,
-      "readable": true,
-      "comparable": true,
-      "discoverable": true
-    }
-  ]
+	"master_key": "MASTER_KEY",
+	"action": "schema:history:fetch"
 }
 EOF
 */
-type SchemaFieldAccessUpdateHandler struct {
-	Authenticator router.Processor `preprocessor:"authenticator"`
+type SchemaHistoryFetchHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
 	DBConn        router.Processor `preprocessor:"dbconn"`
-	InjectAuth    router.Processor `preprocessor:"inject_auth"`
-	RequireAdmin  router.Processor `preprocessor:"require_admin"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
 	PluginReady   router.Processor `preprocessor:"plugin_ready"`
 	preprocessors []router.Processor
 }
 
-func (h *SchemaFieldAccessUpdateHandler) Setup() {
+func (h *SchemaHistoryFetchHandler) Setup() {
 	h.preprocessors = []router.Processor{
-		h.Authenticator,
+		h.AccessKey,
+		h.DevOnly,
 		h.DBConn,
-		h.InjectAuth,
-		h.RequireAdmin,
+		h.InjectDB,
 		h.PluginReady,
 	}
 }
 
-func (h *SchemaFieldAccessUpdateHandler) GetPreprocessors() []router.Processor {
+func (h *SchemaHistoryFetchHandler) GetPreprocessors() []router.Processor {
 	return h.preprocessors
 }
 
-func (h *SchemaFieldAccessUpdateHandler) Handle(rpayload *router.Payload, response *router.Response) {
-	payload := schemaFieldAccessUpdatePayload{}
-	skyErr := payload.Decode(rpayload.Data)
-	if skyErr != nil {
-		response.Err = skyErr
-		return
-	}
-
-	c := rpayload.DBConn
-	err := c.SetRecordFieldAccess(payload.FieldACL)
-
+func (h *SchemaHistoryFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	recordTypes, err := rpayload.DBConn.GetRecordHistoryTypes()
 	if err != nil {
 		response.Err = skyerr.MakeError(err)
 		return
 	}
 
-	response.Result = schemaFieldAccessResponse{}.WithAccess(payload.FieldACL)
+	response.Result = schemaHistoryResponse{
+		RecordTypes: recordTypes,
+	}
+}
+
+/*
+SchemaSoftDeleteHandler enables or disables soft delete of records of a type
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/soft_delete <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:soft_delete",
+	"type": "note",
+	"enabled": true
+}
+EOF
+
+Soft delete cannot be disabled while the record type has soft-deleted
+records.
+*/
+type SchemaSoftDeleteHandler struct {
+	AccessKey     router.Processor `preprocessor:"accesskey"`
+	DevOnly       router.Processor `preprocessor:"dev_only"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectDB      router.Processor `preprocessor:"inject_db"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+type schemaSoftDeletePayload struct {
+	Type    string `mapstructure:"type"`
+	Enabled bool   `mapstructure:"enabled"`
+}
+
+type schemaSoftDeleteResponse struct {
+	Type    string `json:"type"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (h *SchemaSoftDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DevOnly,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaSoftDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (payload *schemaSoftDeletePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+
+	return payload.Validate()
+}
+
+func (payload *schemaSoftDeletePayload) Validate() skyerr.Error {
+	if payload.Type == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"type"})
+	}
+	if strings.HasPrefix(payload.Type, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"type"})
+	}
+
+	return nil
+}
+
+func (h *SchemaSoftDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := schemaSoftDeletePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	if payload.Type == db.UserRecordType() {
+		response.Err = skyerr.NewInvalidArgument("soft delete is not supported for user records", []string{"type"})
+		return
+	}
+
+	if err := db.SetRecordSoftDelete(payload.Type, payload.Enabled); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = schemaSoftDeleteResponse{
+		Type:    payload.Type,
+		Enabled: payload.Enabled,
+	}
+}
+
+type schemaFieldAccessResponse struct {
+	Access skydb.FieldACLEntryList `json:"access"`
+}
+
+func (r schemaFieldAccessResponse) WithAccess(fieldACL skydb.FieldACL) schemaFieldAccessResponse {
+	fieldACLEntries := fieldACL.AllEntries()
+	if len(fieldACLEntries) == 0 {
+		// Make sure the response contains array with 0 items rather than nil.
+		fieldACLEntries = make(skydb.FieldACLEntryList, 0)
+	} else {
+		sort.Sort(fieldACLEntries)
+	}
+	r.Access = fieldACLEntries
+	return r
+}
+
+/*
+SchemaFieldAccessGetHandler fetches the entire Field ACL settings.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/default_access <<EOF
+{
+	"api_key": "MASTER_KEY",
+	"action": "schema:field_access:get",
+}
+
+{
+  "result": [
+    {
+      "record_type": "User",
+      "record_field": "*",
+      "user_role": "_owner",
+      "writable": true,
+      "readable": true,
+      "comparable": true,
+      "discoverable": true
+    }
+  ]
+}
+EOF
+*/
+type SchemaFieldAccessGetHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	RequireAdmin  router.Processor `preprocessor:"require_admin"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaFieldAccessGetHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.RequireAdmin,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaFieldAccessGetHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaFieldAccessGetHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	c := rpayload.DBConn
+	fieldACL, err := c.GetRecordFieldAccess()
+
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = schemaFieldAccessResponse{}.WithAccess(fieldACL)
+}
+
+// SchemaFieldAcccssUpdateHandler
+
+type schemaFieldAccessUpdatePayload struct {
+	RawAccess []map[string]interface{} `mapstructure:"access"`
+	FieldACL  skydb.FieldACL
+}
+
+func (payload *schemaFieldAccessUpdatePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+
+	entries := skydb.FieldACLEntryList{}
+	for _, v := range payload.RawAccess {
+		ace := skydb.FieldACLEntry{}
+		if err := (*skyconv.MapFieldACLEntry)(&ace).FromMap(v); err != nil {
+			return skyerr.NewInvalidArgument("invalid access entry", []string{"access"})
+		}
+		entries = append(entries, ace)
+	}
+
+	payload.FieldACL = skydb.NewFieldACL(entries)
+	return payload.Validate()
+}
+
+func (payload *schemaFieldAccessUpdatePayload) Validate() skyerr.Error {
+	return nil
+}
+
+/*
+SchemaFieldAccessUpdateHandler fetches the entire Field ACL settings.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/default_access <<EOF
+{
+	"api_key": "MASTER_KEY",
+	"action": "schema:field_access:get",
+}
+
+{
+  "result": [
+    {
+      "record_type": "User",
+      "record_field": "*",
+      "user_role": "_owner",
+      "writable": true,
+      "readable": true,
+      "comparable": true,
+      "discoverable": true
+    }
+  ]
+}
+EOF
+*/
+type SchemaFieldAccessUpdateHandler struct {
+	Authenticator router.Processor `preprocessor:"authenticator"`
+	DBConn        router.Processor `preprocessor:"dbconn"`
+	InjectAuth    router.Processor `preprocessor:"inject_auth"`
+	RequireAdmin  router.Processor `preprocessor:"require_admin"`
+	PluginReady   router.Processor `preprocessor:"plugin_ready"`
+	preprocessors []router.Processor
+}
+
+func (h *SchemaFieldAccessUpdateHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.Authenticator,
+		h.DBConn,
+		h.InjectAuth,
+		h.RequireAdmin,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaFieldAccessUpdateHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+func (h *SchemaFieldAccessUpdateHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := schemaFieldAccessUpdatePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	c := rpayload.DBConn
+	err := c.SetRecordFieldAccess(payload.FieldACL)
+
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = schemaFieldAccessResponse{}.WithAccess(payload.FieldACL)
+}
+
+type schemaIndexResponse struct {
+	RecordType string                     `json:"record_type"`
+	Indexes    map[string]schemaIndexItem `json:"indexes"`
+}
+
+type schemaIndexItem struct {
+	Fields    []string `json:"fields"`
+	Unique    bool     `json:"unique"`
+	Condition string   `json:"condition,omitempty"`
+}
+
+// makeSchemaIndexResponse returns the indexes of the record type, except
+// the indexes managed by the server, which cannot be changed by the
+// schema:index actions.
+func makeSchemaIndexResponse(db skydb.Database, recordType string, authRecordKeys [][]string) (*schemaIndexResponse, skyerr.Error) {
+	indexes, err := db.GetIndexesByRecordType(recordType)
+	if err != nil {
+		return nil, skyerr.MakeError(err)
+	}
+
+	items := map[string]schemaIndexItem{}
+	for name, index := range indexes {
+		if schemafile.IsManagedIndex(db, recordType, name, index, authRecordKeys) {
+			continue
+		}
+		items[name] = schemaIndexItem{
+			Fields:    index.Fields,
+			Unique:    index.Unique,
+			Condition: index.Condition,
+		}
+	}
+	return &schemaIndexResponse{
+		RecordType: recordType,
+		Indexes:    items,
+	}, nil
+}
+
+func validateIndexRecordType(recordType string) skyerr.Error {
+	if recordType == "" {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"record_type"})
+	}
+	if strings.HasPrefix(recordType, "_") {
+		return skyerr.NewInvalidArgument("attempts to change reserved table", []string{"record_type"})
+	}
+	return nil
+}
+
+/*
+SchemaIndexFetchHandler handles the action of fetching indexes of a record type.
+The indexes managed by the server, which are the indexes of auth record keys
+and of unique field constraints, are not fetched, and cannot be created or
+deleted by the schema:index actions.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/index/fetch <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:index:fetch",
+	"record_type": "note"
+}
+EOF
+*/
+type SchemaIndexFetchHandler struct {
+	AuthRecordKeys   [][]string       `inject:"AuthRecordKeys"`
+	AccessKey        router.Processor `preprocessor:"accesskey"`
+	RequireMasterKey router.Processor `preprocessor:"require_master_key"`
+	DBConn           router.Processor `preprocessor:"dbconn"`
+	InjectDB         router.Processor `preprocessor:"inject_db"`
+	PluginReady      router.Processor `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *SchemaIndexFetchHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.RequireMasterKey,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaIndexFetchHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaIndexFetchPayload struct {
+	RecordType string `mapstructure:"record_type"`
+}
+
+func (payload *schemaIndexFetchPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaIndexFetchPayload) Validate() skyerr.Error {
+	return validateIndexRecordType(payload.RecordType)
+}
+
+func (h *SchemaIndexFetchHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	payload := &schemaIndexFetchPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	result, skyErr := makeSchemaIndexResponse(rpayload.Database, payload.RecordType, h.AuthRecordKeys)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+	response.Result = result
+}
+
+/*
+SchemaIndexCreateHandler handles the action of creating an index on a record
+type. The optional predicate creates a partial index covering only the
+records matching the predicate.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/index/create <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:index:create",
+	"record_type": "note",
+	"name": "note_title_key",
+	"fields": ["title"],
+	"unique": true,
+	"predicate": ["eq", {"$type": "keypath", "$val": "archived"}, false]
+}
+EOF
+*/
+type SchemaIndexCreateHandler struct {
+	AuthRecordKeys   [][]string       `inject:"AuthRecordKeys"`
+	AccessKey        router.Processor `preprocessor:"accesskey"`
+	RequireMasterKey router.Processor `preprocessor:"require_master_key"`
+	DBConn           router.Processor `preprocessor:"dbconn"`
+	InjectDB         router.Processor `preprocessor:"inject_db"`
+	PluginReady      router.Processor `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *SchemaIndexCreateHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.RequireMasterKey,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaIndexCreateHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaIndexCreatePayload struct {
+	RecordType   string          `mapstructure:"record_type"`
+	Name         string          `mapstructure:"name"`
+	Fields       []string        `mapstructure:"fields"`
+	Unique       bool            `mapstructure:"unique"`
+	RawPredicate []interface{}   `mapstructure:"predicate"`
+	Predicate    skydb.Predicate `mapstructure:"-"`
+}
+
+func (payload *schemaIndexCreatePayload) Decode(data map[string]interface{}, parser *QueryParser) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if err := payload.Validate(); err != nil {
+		return err
+	}
+
+	if len(payload.RawPredicate) > 0 {
+		query := skydb.Query{}
+		if err := parser.queryFromRaw(map[string]interface{}{
+			"record_type": payload.RecordType,
+			"predicate":   payload.RawPredicate,
+		}, &query); err != nil {
+			return err
+		}
+		payload.Predicate = query.Predicate
+	}
+	return nil
+}
+
+func (payload *schemaIndexCreatePayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.Name == "" {
+		missingArgs = append(missingArgs, "name")
+	}
+	if len(payload.Fields) == 0 {
+		missingArgs = append(missingArgs, "fields")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+	return validateIndexRecordType(payload.RecordType)
+}
+
+func (h *SchemaIndexCreateHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	logger := logging.CreateLogger(rpayload.Context(), "handler")
+	payload := &schemaIndexCreatePayload{}
+	skyErr := payload.Decode(rpayload.Data, &QueryParser{UserID: rpayload.AuthInfoID})
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	schema, err := db.GetSchema(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.NewError(skyerr.ResourceNotFound, err.Error())
+		return
+	}
+	for _, field := range payload.Fields {
+		if _, ok := schema[field]; !ok && !strings.HasPrefix(field, "_") {
+			response.Err = skyerr.NewInvalidArgument("field does not exist: "+field, []string{"fields"})
+			return
+		}
+	}
+
+	indexes, err := db.GetIndexesByRecordType(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	if _, ok := indexes[payload.Name]; ok {
+		response.Err = skyerr.NewErrorf(skyerr.Duplicated, "index %s already exists", payload.Name)
+		return
+	}
+
+	index := skydb.Index{
+		Fields: payload.Fields,
+		Unique: payload.Unique,
+	}
+	if !payload.Predicate.IsEmpty() {
+		index.Predicate = &payload.Predicate
+	}
+	if schemafile.IsManagedIndex(db, payload.RecordType, payload.Name, index, h.AuthRecordKeys) {
+		response.Err = skyerr.NewInvalidArgument("attempts to change index managed by the server", []string{"name"})
+		return
+	}
+
+	logger.WithField("index", payload.Name).Infoln("Creating index")
+	if err := db.SaveIndex(payload.RecordType, payload.Name, index); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	result, skyErr := makeSchemaIndexResponse(db, payload.RecordType, h.AuthRecordKeys)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+	response.Result = result
+}
+
+/*
+SchemaIndexDeleteHandler handles the action of deleting an index of a
+record type
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/index/delete <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:index:delete",
+	"record_type": "note",
+	"name": "note_title_key"
+}
+EOF
+*/
+type SchemaIndexDeleteHandler struct {
+	AuthRecordKeys   [][]string       `inject:"AuthRecordKeys"`
+	AccessKey        router.Processor `preprocessor:"accesskey"`
+	RequireMasterKey router.Processor `preprocessor:"require_master_key"`
+	DBConn           router.Processor `preprocessor:"dbconn"`
+	InjectDB         router.Processor `preprocessor:"inject_db"`
+	PluginReady      router.Processor `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *SchemaIndexDeleteHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.RequireMasterKey,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaIndexDeleteHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaIndexDeletePayload struct {
+	RecordType string `mapstructure:"record_type"`
+	Name       string `mapstructure:"name"`
+}
+
+func (payload *schemaIndexDeletePayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	return payload.Validate()
+}
+
+func (payload *schemaIndexDeletePayload) Validate() skyerr.Error {
+	missingArgs := []string{}
+	if payload.RecordType == "" {
+		missingArgs = append(missingArgs, "record_type")
+	}
+	if payload.Name == "" {
+		missingArgs = append(missingArgs, "name")
+	}
+	if len(missingArgs) > 0 {
+		return skyerr.NewInvalidArgument("missing required fields", missingArgs)
+	}
+	return validateIndexRecordType(payload.RecordType)
+}
+
+func (h *SchemaIndexDeleteHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	logger := logging.CreateLogger(rpayload.Context(), "handler")
+	payload := &schemaIndexDeletePayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	indexes, err := db.GetIndexesByRecordType(payload.RecordType)
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	index, ok := indexes[payload.Name]
+	if !ok {
+		response.Err = skyerr.NewErrorf(skyerr.ResourceNotFound, "index %s does not exist", payload.Name)
+		return
+	}
+	if schemafile.IsManagedIndex(db, payload.RecordType, payload.Name, index, h.AuthRecordKeys) {
+		response.Err = skyerr.NewInvalidArgument("attempts to change index managed by the server", []string{"name"})
+		return
+	}
+
+	logger.WithField("index", payload.Name).Infoln("Deleting index")
+	if err := db.DeleteIndex(payload.RecordType, payload.Name); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	result, skyErr := makeSchemaIndexResponse(db, payload.RecordType, h.AuthRecordKeys)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+	response.Result = result
+}
+
+/*
+SchemaApplyHandler handles the action of applying a schema file to the
+database. The changes are computed against the current schema and applied
+in a transaction. Nothing is applied if dry_run is true.
+curl -X POST -H "Content-Type: application/json" \
+  -d @- http://localhost:3000/schema/apply <<EOF
+{
+	"master_key": "MASTER_KEY",
+	"action": "schema:apply",
+	"schema": {
+		"record_types": {
+			"note": {
+				"fields": [
+					{"name": "title", "type": "string"}
+				],
+				"indexes": {
+					"note_title_key": {"fields": ["title"], "unique": true}
+				}
+			}
+		}
+	},
+	"prune": false,
+	"dry_run": true
+}
+EOF
+*/
+type SchemaApplyHandler struct {
+	EventSender      pluginEvent.Sender `inject:"PluginEventSender"`
+	AuthRecordKeys   [][]string         `inject:"AuthRecordKeys"`
+	AccessKey        router.Processor   `preprocessor:"accesskey"`
+	RequireMasterKey router.Processor   `preprocessor:"require_master_key"`
+	DBConn           router.Processor   `preprocessor:"migration_dbconn"`
+	InjectDB         router.Processor   `preprocessor:"inject_db"`
+	PluginReady      router.Processor   `preprocessor:"plugin_ready"`
+	preprocessors    []router.Processor
+}
+
+func (h *SchemaApplyHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.RequireMasterKey,
+		h.DBConn,
+		h.InjectDB,
+		h.PluginReady,
+	}
+}
+
+func (h *SchemaApplyHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+type schemaApplyPayload struct {
+	RawSchema map[string]interface{} `mapstructure:"schema"`
+	Prune     bool                   `mapstructure:"prune"`
+	DryRun    bool                   `mapstructure:"dry_run"`
+	File      *schemafile.File       `mapstructure:"-"`
+}
+
+type schemaApplyResponse struct {
+	Changes []schemafile.Change `json:"changes"`
+	Applied bool                `json:"applied"`
+}
+
+func (payload *schemaApplyPayload) Decode(data map[string]interface{}) skyerr.Error {
+	if err := mapstructure.Decode(data, payload); err != nil {
+		return skyerr.NewError(skyerr.BadRequest, "fails to decode the request payload")
+	}
+	if err := payload.Validate(); err != nil {
+		return err
+	}
+
+	file, err := schemafile.Parse(payload.RawSchema)
+	if err != nil {
+		return skyerr.NewInvalidArgument(err.Error(), []string{"schema"})
+	}
+	payload.File = file
+	return nil
+}
+
+func (payload *schemaApplyPayload) Validate() skyerr.Error {
+	if payload.RawSchema == nil {
+		return skyerr.NewInvalidArgument("missing required fields", []string{"schema"})
+	}
+	return nil
+}
+
+func (h *SchemaApplyHandler) Handle(rpayload *router.Payload, response *router.Response) {
+	logger := logging.CreateLogger(rpayload.Context(), "handler")
+	payload := &schemaApplyPayload{}
+	skyErr := payload.Decode(rpayload.Data)
+	if skyErr != nil {
+		response.Err = skyErr
+		return
+	}
+
+	db := rpayload.Database
+	plan, err := schemafile.Diff(db, payload.File, schemafile.Options{
+		Prune:          payload.Prune,
+		AuthRecordKeys: h.AuthRecordKeys,
+	})
+	if err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	if payload.DryRun || plan.IsEmpty() {
+		response.Result = schemaApplyResponse{
+			Changes: plan.Changes,
+		}
+		return
+	}
+
+	if plan.HasConflict() {
+		response.Err = skyerr.NewErrorWithInfo(
+			skyerr.IncompatibleSchema,
+			"schema file conflicts with the database schema",
+			map[string]interface{}{"changes": plan.Changes},
+		)
+		return
+	}
+
+	logger.Infof("Applying schema changes:\n%s", plan)
+	if err := schemafile.Apply(db, plan); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	response.Result = schemaApplyResponse{
+		Changes: plan.Changes,
+		Applied: true,
+	}
+
+	if h.EventSender != nil {
+		err := sendSchemaChangedEvent(h.EventSender, db)
+		if err != nil {
+			logger.WithError(err).Warn("Fail to send schema changed event")
+		}
+	}
 }
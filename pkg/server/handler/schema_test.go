@@ -259,6 +259,84 @@ func TestSchemaCreateHandler(t *testing.T) {
 			}`)
 		})
 
+		Convey("create field with constraints", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field3", "type": "string", "required": true, "pattern": "^[a-z]+$"},
+							{"name": "field4", "type": "number", "default": 1, "min": 0, "max": 10}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "field1", "type": "string"},
+								{"name": "field2", "type": "datetime"},
+								{"name": "field3", "type": "string", "required": true, "pattern": "^[a-z]+$"},
+								{"name": "field4", "type": "number", "default": 1, "min": 0, "max": 10}
+							]
+						}
+					}
+				}
+			}`)
+
+			min := 0.0
+			max := 10.0
+			So(db.RecordSchemaMap["note"]["field4"].Constraints, ShouldResemble, &skydb.FieldConstraints{
+				Default: float64(1),
+				Min:     &min,
+				Max:     &max,
+			})
+		})
+
+		Convey("create field with invalid constraints", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "field3", "type": "number", "pattern": "^[a-z]+$"}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "pattern is only applicable to string field",
+					"info": {
+						"arguments": [
+							"field3"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("create reference field with on_delete", func() {
+			resp := router.POST(`{
+				"record_types": {
+					"comment": {
+						"fields": [
+							{"name": "note", "type": "ref(note)", "on_delete": "cascade"}
+						]
+					}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			So(db.RecordSchemaMap["comment"]["note"].Constraints, ShouldResemble, &skydb.FieldConstraints{
+				OnDelete: skydb.CascadeReference,
+			})
+		})
+
 		Convey("create reserved field", func() {
 			resp := router.POST(`{
 				"record_types": {
@@ -646,6 +724,212 @@ func TestSchemaDeleteHandler(t *testing.T) {
 	})
 }
 
+func TestSchemaAlterTypeHandler(t *testing.T) {
+	Convey("SchemaAlterTypeHandler", t, func() {
+		note := skydb.RecordSchema{
+			"field1": skydb.FieldType{
+				Type: skydb.TypeString,
+			},
+			"field2": skydb.FieldType{
+				Type: skydb.TypeLocation,
+			},
+		}
+
+		db := skydbtest.NewMapDB()
+		_, err := db.Extend("note", note)
+		So(err, ShouldBeNil)
+
+		router := handlertest.NewSingleRouteRouter(&SchemaAlterTypeHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("dry run type change", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "field1",
+				"type": "integer",
+				"dry_run": true
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"item_name": "field1",
+					"from": "string",
+					"to": "integer",
+					"conversion": "strings are parsed as decimal integers",
+					"failed_count": 0,
+					"failed_records": [],
+					"applied": false
+				}
+			}`)
+			So(db.RecordSchemaMap["note"]["field1"].Type, ShouldEqual, skydb.TypeString)
+		})
+
+		Convey("change type", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "field1",
+				"type": "integer"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"item_name": "field1",
+					"from": "string",
+					"to": "integer",
+					"conversion": "strings are parsed as decimal integers",
+					"failed_count": 0,
+					"failed_records": [],
+					"applied": true,
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "field1", "type": "integer"},
+								{"name": "field2", "type": "location"}
+							]
+						}
+					}
+				}
+			}`)
+		})
+
+		Convey("unsupported type change", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "field2",
+				"type": "string"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 114,
+					"message": "conversion from location to string is not supported",
+					"name": "IncompatibleSchema"
+				}
+			}`)
+		})
+
+		Convey("change reserved field", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "_id",
+				"type": "string"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "attempts to change reserved key",
+					"info": {
+						"arguments": [
+							"item_name"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("unknown type", func() {
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "field1",
+				"type": "text"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "unexpected field type",
+					"info": {
+						"arguments": [
+							"text"
+						]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+	})
+
+	Convey("SchemaAlterTypeHandler with failing records", t, func() {
+		ctrl := gomock.NewController(handlertest.NewGoroutineAwareTestReporter(t))
+		defer ctrl.Finish()
+		db := mock_skydb.NewMockDatabase(ctrl)
+
+		router := handlertest.NewSingleRouteRouter(&SchemaAlterTypeHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("reports failing records in dry run", func() {
+			db.EXPECT().AlterSchemaType("note", "field1", skydb.FieldType{Type: skydb.TypeNumber}, true).
+				Return(skydb.TypeConversionReport{
+					Conversion: skydb.TypeConversion{
+						From:        skydb.TypeString,
+						To:          skydb.TypeNumber,
+						Description: "strings are parsed as decimal numbers",
+					},
+					FailedCount: 1,
+					FailedRecordIDs: []skydb.RecordID{
+						skydb.NewRecordID("note", "id1"),
+					},
+				}, nil)
+
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "field1",
+				"type": "number",
+				"dry_run": true
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"item_name": "field1",
+					"from": "string",
+					"to": "number",
+					"conversion": "strings are parsed as decimal numbers",
+					"failed_count": 1,
+					"failed_records": ["note/id1"],
+					"applied": false
+				}
+			}`)
+		})
+
+		Convey("returns error of failing records", func() {
+			db.EXPECT().AlterSchemaType("note", "field1", skydb.FieldType{Type: skydb.TypeNumber}, false).
+				Return(skydb.TypeConversionReport{}, skyerr.NewErrorWithInfo(
+					skyerr.IncompatibleSchema,
+					"1 records cannot be converted from string to number",
+					map[string]interface{}{
+						"failed_count":   1,
+						"failed_records": []string{"note/id1"},
+					},
+				))
+
+			resp := router.POST(`{
+				"record_type": "note",
+				"item_name": "field1",
+				"type": "number"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 114,
+					"message": "1 records cannot be converted from string to number",
+					"info": {
+						"failed_count": 1,
+						"failed_records": ["note/id1"]
+					},
+					"name": "IncompatibleSchema"
+				}
+			}`)
+		})
+	})
+}
+
 func TestSchemaFetchHandler(t *testing.T) {
 	Convey("SchemaFetchHandler", t, func() {
 		note := skydb.RecordSchema{
@@ -692,6 +976,338 @@ func TestSchemaFetchHandler(t *testing.T) {
 	})
 }
 
+func TestSchemaIndexHandlers(t *testing.T) {
+	Convey("Schema index handlers", t, func() {
+		db := skydbtest.NewMapDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{
+				Type: skydb.TypeString,
+			},
+			"archived": skydb.FieldType{
+				Type: skydb.TypeBoolean,
+			},
+		})
+		So(err, ShouldBeNil)
+		So(db.SaveIndex("note", "note_title_key", skydb.Index{
+			Fields: []string{"title"},
+			Unique: true,
+		}), ShouldBeNil)
+
+		prepare := func(p *router.Payload) {
+			p.Database = db
+		}
+
+		Convey("fetch indexes", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexFetchHandler{}, prepare).POST(`{
+				"record_type": "note"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"indexes": {
+						"note_title_key": {"fields": ["title"], "unique": true}
+					}
+				}
+			}`)
+		})
+
+		Convey("create partial index", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, prepare).POST(`{
+				"record_type": "note",
+				"name": "note_archived_title_idx",
+				"fields": ["archived", "title"],
+				"predicate": ["eq", {"$type": "keypath", "$val": "archived"}, false]
+			}`)
+
+			So(resp.Code, ShouldEqual, 200)
+			index := db.IndexMap["note"]["note_archived_title_idx"]
+			So(index.Fields, ShouldResemble, []string{"archived", "title"})
+			So(index.Unique, ShouldBeFalse)
+			So(*index.Predicate, ShouldResemble, skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "archived"},
+					skydb.Expression{Type: skydb.Literal, Value: false},
+				},
+			})
+		})
+
+		Convey("create index with existing name", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, prepare).POST(`{
+				"record_type": "note",
+				"name": "note_title_key",
+				"fields": ["title"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 109,
+					"message": "index note_title_key already exists",
+					"name": "Duplicated"
+				}
+			}`)
+		})
+
+		Convey("create index on nonexisting field", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, prepare).POST(`{
+				"record_type": "note",
+				"name": "note_content_idx",
+				"fields": ["content"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "field does not exist: content",
+					"info": {
+						"arguments": ["fields"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("create index on reserved table", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{}, prepare).POST(`{
+				"record_type": "_user",
+				"name": "user_idx",
+				"fields": ["id"]
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 108,
+					"message": "attempts to change reserved table",
+					"info": {
+						"arguments": ["record_type"]
+					},
+					"name": "InvalidArgument"
+				}
+			}`)
+		})
+
+		Convey("delete index", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexDeleteHandler{}, prepare).POST(`{
+				"record_type": "note",
+				"name": "note_title_key"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"record_type": "note",
+					"indexes": {}
+				}
+			}`)
+		})
+
+		Convey("index managed by the server", func() {
+			_, err := db.Extend("user", skydb.RecordSchema{
+				"username": skydb.FieldType{
+					Type: skydb.TypeString,
+				},
+			})
+			So(err, ShouldBeNil)
+			So(db.SaveIndex("user", "auth_record_keys_user_username_key", skydb.Index{
+				Fields: []string{"username"},
+				Unique: true,
+			}), ShouldBeNil)
+			authRecordKeys := [][]string{[]string{"username"}}
+
+			Convey("is not fetched", func() {
+				resp := handlertest.NewSingleRouteRouter(&SchemaIndexFetchHandler{
+					AuthRecordKeys: authRecordKeys,
+				}, prepare).POST(`{
+					"record_type": "user"
+				}`)
+
+				So(resp.Body.Bytes(), ShouldEqualJSON, `{
+					"result": {
+						"record_type": "user",
+						"indexes": {}
+					}
+				}`)
+			})
+
+			Convey("cannot be created", func() {
+				resp := handlertest.NewSingleRouteRouter(&SchemaIndexCreateHandler{
+					AuthRecordKeys: authRecordKeys,
+				}, prepare).POST(`{
+					"record_type": "user",
+					"name": "user_username_key",
+					"fields": ["username"],
+					"unique": true
+				}`)
+
+				So(resp.Body.Bytes(), ShouldEqualJSON, `{
+					"error": {
+						"code": 108,
+						"message": "attempts to change index managed by the server",
+						"info": {
+							"arguments": ["name"]
+						},
+						"name": "InvalidArgument"
+					}
+				}`)
+			})
+
+			Convey("cannot be deleted", func() {
+				resp := handlertest.NewSingleRouteRouter(&SchemaIndexDeleteHandler{
+					AuthRecordKeys: authRecordKeys,
+				}, prepare).POST(`{
+					"record_type": "user",
+					"name": "auth_record_keys_user_username_key"
+				}`)
+
+				So(resp.Body.Bytes(), ShouldEqualJSON, `{
+					"error": {
+						"code": 108,
+						"message": "attempts to change index managed by the server",
+						"info": {
+							"arguments": ["name"]
+						},
+						"name": "InvalidArgument"
+					}
+				}`)
+				So(db.IndexMap["user"], ShouldContainKey, "auth_record_keys_user_username_key")
+			})
+		})
+
+		Convey("delete nonexisting index", func() {
+			resp := handlertest.NewSingleRouteRouter(&SchemaIndexDeleteHandler{}, prepare).POST(`{
+				"record_type": "note",
+				"name": "notexist"
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 110,
+					"message": "index notexist does not exist",
+					"name": "ResourceNotFound"
+				}
+			}`)
+		})
+	})
+}
+
+func TestSchemaApplyHandler(t *testing.T) {
+	Convey("SchemaApplyHandler", t, func() {
+		db := skydbtest.NewMapDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{
+				Type: skydb.TypeString,
+			},
+		})
+		So(err, ShouldBeNil)
+
+		r := handlertest.NewSingleRouteRouter(&SchemaApplyHandler{}, func(p *router.Payload) {
+			p.Database = db
+		})
+
+		Convey("prints plan without applying in dry run", func() {
+			resp := r.POST(`{
+				"schema": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "title", "type": "string"},
+								{"name": "done", "type": "boolean"}
+							]
+						}
+					}
+				},
+				"dry_run": true
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"changes": [{
+						"action": "add_field",
+						"record_type": "note",
+						"name": "done",
+						"description": "+ add field note.done (boolean)"
+					}],
+					"applied": false
+				}
+			}`)
+			So(db.RecordSchemaMap["note"], ShouldNotContainKey, "done")
+		})
+
+		Convey("applies schema", func() {
+			resp := r.POST(`{
+				"schema": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "title", "type": "string"}
+							],
+							"indexes": {
+								"note_title_key": {"fields": ["title"], "unique": true}
+							}
+						}
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"result": {
+					"changes": [{
+						"action": "create_index",
+						"record_type": "note",
+						"name": "note_title_key",
+						"description": "+ create index note_title_key on note (title) unique"
+					}],
+					"applied": true
+				}
+			}`)
+			So(db.IndexMap["note"], ShouldContainKey, "note_title_key")
+		})
+
+		Convey("rejects conflicting schema", func() {
+			resp := r.POST(`{
+				"schema": {
+					"record_types": {
+						"note": {
+							"fields": [
+								{"name": "title", "type": "number"}
+							]
+						}
+					}
+				}
+			}`)
+
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+				"error": {
+					"code": 114,
+					"message": "schema file conflicts with the database schema",
+					"name": "IncompatibleSchema",
+					"info": {
+						"changes": [{
+							"action": "change_field_type",
+							"record_type": "note",
+							"name": "title",
+							"description": "! change type of field note.title from string to number (not supported)",
+							"conflict": true
+						}]
+					}
+				}
+			}`)
+		})
+
+		Convey("rejects invalid schema", func() {
+			resp := r.POST(`{
+				"schema": {
+					"record_types": {
+						"_user": {"fields": []}
+					}
+				}
+			}`)
+
+			So(resp.Code, ShouldEqual, 400)
+		})
+	})
+}
+
 func TestSchemaAccessPayload(t *testing.T) {
 	Convey("SchemaAccessPayload", t, func() {
 		Convey("Valid Data", func() {
@@ -1117,3 +1733,136 @@ func TestSchemaFieldAccessUpdateHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestSchemaHistoryHandler(t *testing.T) {
+	Convey("SchemaHistoryHandler", t, func() {
+		conn := skydbtest.NewMapConn()
+		payloadFunc := func(p *router.Payload) {
+			p.DBConn = conn
+			p.Database = skydbtest.NewMapDB()
+		}
+		setRouter := handlertest.NewSingleRouteRouter(&SchemaHistorySetHandler{}, payloadFunc)
+		fetchRouter := handlertest.NewSingleRouteRouter(&SchemaHistoryFetchHandler{}, payloadFunc)
+
+		Convey("enables history", func() {
+			resp := setRouter.POST(`{
+	"type": "note",
+	"enabled": true
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"record_types": ["note"]
+	}
+}`)
+
+			resp = fetchRouter.POST(`{}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"record_types": ["note"]
+	}
+}`)
+		})
+
+		Convey("disables history", func() {
+			So(conn.SetRecordHistoryEnabled("note", true), ShouldBeNil)
+			resp := setRouter.POST(`{
+	"type": "note",
+	"enabled": false
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"record_types": []
+	}
+}`)
+		})
+
+		Convey("rejects missing type", func() {
+			resp := setRouter.POST(`{
+	"enabled": true
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "missing required fields",
+		"name": "InvalidArgument",
+		"info": {
+			"arguments": ["type"]
+		}
+	}
+}`)
+		})
+	})
+}
+
+func TestSchemaSoftDeleteHandler(t *testing.T) {
+	Convey("SchemaSoftDeleteHandler", t, func() {
+		db := skydbtest.NewMapDB()
+		r := handlertest.NewSingleRouteRouter(&SchemaSoftDeleteHandler{}, func(p *router.Payload) {
+			p.DBConn = skydbtest.NewMapConn()
+			p.Database = db
+		})
+
+		Convey("enables soft delete", func() {
+			resp := r.POST(`{
+	"type": "note",
+	"enabled": true
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"type": "note",
+		"enabled": true
+	}
+}`)
+			So(db.SoftDeleteTypes["note"], ShouldBeTrue)
+		})
+
+		Convey("disables soft delete", func() {
+			So(db.SetRecordSoftDelete("note", true), ShouldBeNil)
+			resp := r.POST(`{
+	"type": "note",
+	"enabled": false
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"result": {
+		"type": "note",
+		"enabled": false
+	}
+}`)
+			So(db.SoftDeleteTypes["note"], ShouldBeFalse)
+		})
+
+		Convey("rejects user record type", func() {
+			resp := r.POST(`{
+	"type": "user",
+	"enabled": true
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "soft delete is not supported for user records",
+		"name": "InvalidArgument",
+		"info": {
+			"arguments": ["type"]
+		}
+	}
+}`)
+		})
+
+		Convey("rejects reserved record type", func() {
+			resp := r.POST(`{
+	"type": "_asset",
+	"enabled": true
+}`)
+			So(resp.Body.Bytes(), ShouldEqualJSON, `{
+	"error": {
+		"code": 108,
+		"message": "attempts to change reserved table",
+		"name": "InvalidArgument",
+		"info": {
+			"arguments": ["type"]
+		}
+	}
+}`)
+		})
+	})
+}
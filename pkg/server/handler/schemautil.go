@@ -42,6 +42,8 @@ func (s schemaFieldList) Less(i, j int) bool {
 type schemaField struct {
 	Name     string `mapstructure:"name" json:"name"`
 	TypeName string `mapstructure:"type" json:"type"`
+
+	skydb.FieldConstraints `mapstructure:",squash"`
 }
 
 func encodeRecordSchemas(data map[string]skydb.RecordSchema) map[string]schemaFieldList {
@@ -57,10 +59,14 @@ func encodeRecordSchemas(data map[string]skydb.RecordSchema) map[string]schemaFi
 				continue
 			}
 
-			fieldList.Fields = append(fieldList.Fields, schemaField{
+			field := schemaField{
 				Name:     fieldName,
 				TypeName: val.ToSimpleName(),
-			})
+			}
+			if val.Constraints != nil {
+				field.FieldConstraints = *val.Constraints
+			}
+			fieldList.Fields = append(fieldList.Fields, field)
 		}
 		sort.Sort(fieldList)
 		schemaMap[recordType] = fieldList
@@ -0,0 +1,487 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	skyAsset "github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+const chunkContentType = "application/offset+octet-stream"
+
+// uploadSessionLifetime is the time an upload session is kept since it is
+// created or last written, after which the upload is aborted.
+const uploadSessionLifetime = 24 * time.Hour
+
+// ChunkUploadHandler receives a file uploaded in chunks, so that an
+// interrupted upload can be resumed from the last received offset.
+//
+// The protocol follows the core protocol of tus (https://tus.io):
+//
+// POST /uploads/<filename> creates an upload session of the total length
+// specified in the Upload-Length header. The session URL is returned in
+// the Location header.
+//
+// PATCH /uploads/<id> appends a chunk to the upload session. The
+// Upload-Offset header must be the current offset of the session. When
+// all content is received, the file is saved as an asset, which is
+// returned in the response.
+//
+// HEAD /uploads/<id> returns the current offset of the upload session
+// in the Upload-Offset header.
+//
+// DELETE /uploads/<id> aborts the upload session.
+//
+// An upload session expires a day after it is created or last appended,
+// as returned in the Upload-Expires header. Expired uploads are aborted
+// by the asset collector.
+//
+// If the record_type and field query parameters are specified when
+// creating the session, the file is checked against the asset policy of
+// the record field. The parameters are kept in the returned session URL.
+//
+// Example curl (create):
+//	curl -XPOST -i \
+//		-H 'X-Skygear-API-Key: apiKey' \
+//		-H 'Content-Type: video/mp4' \
+//		-H 'Upload-Length: 10485760' \
+//		http://localhost:3000/uploads/video.mp4
+//
+// Example curl (append):
+//	curl -XPATCH -i \
+//		-H 'X-Skygear-API-Key: apiKey' \
+//		-H 'Content-Type: application/offset+octet-stream' \
+//		-H 'Upload-Offset: 0' \
+//		--data-binary '@chunk' \
+//		http://localhost:3000/uploads/<id>
+//
+type ChunkUploadHandler struct {
+	AssetStore     skyAsset.Store           `inject:"AssetStore"`
+	AssetPolicies  skyAsset.Policies        `inject:"AssetPolicies"`
+	HookRegistry   *hook.Registry           `inject:"HookRegistry"`
+	ChunkAssembler *skyAsset.ChunkAssembler `inject:"AssetChunkAssembler"`
+	AccessKey      router.Processor         `preprocessor:"accesskey"`
+	DBConn         router.Processor         `preprocessor:"dbconn"`
+	preprocessors  []router.Processor
+}
+
+// Setup sets preprocessors being used
+func (h *ChunkUploadHandler) Setup() {
+	h.preprocessors = []router.Processor{
+		h.AccessKey,
+		h.DBConn,
+	}
+}
+
+// GetPreprocessors returns all preprocessors
+func (h *ChunkUploadHandler) GetPreprocessors() []router.Processor {
+	return h.preprocessors
+}
+
+// Handle handles the chunk upload request
+func (h *ChunkUploadHandler) Handle(
+	payload *router.Payload,
+	response *router.Response,
+) {
+	switch payload.Req.Method {
+	case http.MethodPost:
+		h.create(payload, response)
+	case http.MethodPatch:
+		h.writeChunk(payload, response)
+	case http.MethodHead:
+		h.head(payload, response)
+	case http.MethodDelete:
+		h.abort(payload, response)
+	default:
+		response.Err = skyerr.NewError(
+			skyerr.NotSupported,
+			"Method "+payload.Req.Method+" is not supported",
+		)
+	}
+}
+
+func (h *ChunkUploadHandler) create(payload *router.Payload, response *router.Response) {
+	filename := clean(payload.Params[0])
+	if filename == "" {
+		response.Err = skyerr.NewInvalidArgument(
+			"Missing filename or filename is invalid",
+			[]string{"filename"},
+		)
+		return
+	}
+
+	contentType := payload.Req.Header.Get("Content-Type")
+	if contentType == "" {
+		response.Err = skyerr.NewError(
+			skyerr.InvalidArgument,
+			"Content-Type cannot be empty",
+		)
+		return
+	}
+
+	length, err := strconv.ParseInt(payload.Req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		response.Err = skyerr.NewError(
+			skyerr.InvalidArgument,
+			"Upload-Length must be a positive integer",
+		)
+		return
+	}
+
+	location := url.URL{Path: "/uploads/"}
+	if field, policy, ok := uploadAssetPolicy(h.AssetPolicies, payload.Req); ok {
+		if err := policy.CheckMetadata(contentType, length); err != nil {
+			response.Err = recordutil.NewAssetPolicyError(field, err)
+			return
+		}
+
+		query := payload.Req.URL.Query()
+		location.RawQuery = url.Values{
+			"record_type": {query.Get("record_type")},
+			"field":       {field},
+		}.Encode()
+	}
+
+	dir, file := filepath.Split(filename)
+	file = strings.Join([]string{uuidNew(), file}, "-")
+
+	session := skydb.UploadSession{
+		ChunkUpload: skyAsset.ChunkUpload{
+			ID:          uuidNew(),
+			Name:        filepath.Join(dir, file),
+			ContentType: contentType,
+			Length:      length,
+		},
+		ExpiresAt: timeNow().Add(uploadSessionLifetime),
+	}
+
+	if err := h.ChunkAssembler.Begin(&session.ChunkUpload); err != nil {
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+
+	if err := payload.DBConn.SaveUploadSession(&session); err != nil {
+		h.ChunkAssembler.Abort(&session.ChunkUpload)
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("upload session", session.ID)
+		return
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+	exposeUploadHeaders(writer)
+
+	location.Path += session.ID
+	writer.Header().Set("Location", location.String())
+	writer.Header().Set("Upload-Offset", "0")
+	writer.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	h.writeJSON(writer, http.StatusCreated, map[string]interface{}{
+		"result": map[string]interface{}{
+			"id":     session.ID,
+			"offset": session.Offset,
+			"length": session.Length,
+		},
+	})
+}
+
+func (h *ChunkUploadHandler) writeChunk(payload *router.Payload, response *router.Response) {
+	logger := logging.CreateLogger(payload.Context(), "handler")
+
+	if payload.Req.Header.Get("Content-Type") != chunkContentType {
+		response.Err = skyerr.NewError(
+			skyerr.InvalidArgument,
+			"Content-Type must be "+chunkContentType,
+		)
+		return
+	}
+
+	offset, err := strconv.ParseInt(payload.Req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		response.Err = skyerr.NewError(
+			skyerr.InvalidArgument,
+			"Upload-Offset must be a non-negative integer",
+		)
+		return
+	}
+
+	id := payload.Params[0]
+	conn := payload.DBConn
+	session := skydb.UploadSession{}
+	if err := h.fetchSession(conn.GetUploadSession, id, &session); err != nil {
+		response.Err = err
+		return
+	}
+	if offset != session.Offset {
+		response.Err = offsetMismatchError(session.Offset)
+		return
+	}
+
+	// the chunk is received before the session is locked, so that the
+	// lock is not held while waiting for the client
+	chunk, err := h.ChunkAssembler.StageChunk(&session.ChunkUpload, payload.Req.Body)
+	if err != nil {
+		logger.WithError(err).Error("Failed to stage upload chunk")
+		response.Err = skyerr.MakeError(err)
+		return
+	}
+	defer chunk.Close()
+
+	txDB, ok := conn.PublicDB().(skydb.Transactional)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database impl does not support transaction")
+		return
+	}
+
+	// errors after the session is saved are reported after commit, so
+	// that the content written is kept for a retry
+	var writeErr, completeErr error
+	txErr := skydb.WithTransaction(txDB, func() error {
+		if err := h.fetchSession(conn.GetUploadSessionForUpdate, id, &session); err != nil {
+			return err
+		}
+
+		written, err := h.ChunkAssembler.WriteChunk(&session.ChunkUpload, offset, chunk)
+		if err == skyAsset.ErrChunkOffsetMismatch {
+			return offsetMismatchError(session.Offset)
+		}
+		writeErr = err
+		if writeErr == nil {
+			writeErr = chunk.Err
+		}
+
+		if written > 0 {
+			session.ExpiresAt = timeNow().Add(uploadSessionLifetime)
+			if err := conn.SaveUploadSession(&session); err != nil {
+				logger.WithError(err).Error("Failed to save upload session")
+				return skyerr.NewResourceSaveFailureErrWithStringID("upload session", id)
+			}
+		}
+
+		if writeErr != nil || !session.IsComplete() {
+			return nil
+		}
+
+		if err := h.ChunkAssembler.Complete(&session.ChunkUpload); err != nil {
+			// parts uploaded before the failure are kept for a retry
+			completeErr = err
+			return conn.SaveUploadSession(&session)
+		}
+		return conn.DeleteUploadSession(id)
+	})
+	if txErr != nil {
+		if _, ok := txErr.(skyerr.Error); !ok {
+			logger.WithError(txErr).Error("Failed to update upload session")
+		}
+		response.Err = skyerr.MakeError(txErr)
+		return
+	}
+
+	if writeErr != nil {
+		logger.WithError(writeErr).Error("Failed to write upload chunk")
+		response.Err = skyerr.MakeError(writeErr)
+		return
+	}
+
+	if completeErr != nil {
+		logger.WithError(completeErr).Error("Failed to assemble uploaded chunks")
+		response.Err = skyerr.MakeError(completeErr)
+		return
+	}
+
+	if !session.IsComplete() {
+		writer := response.Writer()
+		if writer == nil {
+			// The response is already written.
+			return
+		}
+		exposeUploadHeaders(writer)
+
+		writer.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		writer.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	asset := skydb.Asset{
+		Name:        session.Name,
+		ContentType: session.ContentType,
+		Size:        session.Length,
+	}
+
+	if field, policy, ok := uploadAssetPolicy(h.AssetPolicies, payload.Req); ok {
+		if err := recordutil.CheckAssetPolicy(h.AssetStore, policy, &asset); err != nil {
+			discardUploadedFile(payload.Context(), h.AssetStore, asset.Name)
+			response.Err = recordutil.NewAssetPolicyError(field, err)
+			return
+		}
+	}
+
+	if err := scanUploadedAsset(payload.Context(), h.HookRegistry, h.AssetStore, &asset); err != nil {
+		response.Err = err
+		return
+	}
+
+	if err := conn.SaveAsset(&asset); err != nil {
+		response.Err = skyerr.NewResourceSaveFailureErrWithStringID("asset", asset.Name)
+		return
+	}
+
+	if signer, ok := h.AssetStore.(skyAsset.URLSigner); ok {
+		asset.Signer = signer
+	} else {
+		logger.Warnf("Failed to acquire asset URLSigner, please check configuration")
+		response.Err = skyerr.NewError(skyerr.UnexpectedError, "Failed to sign the url")
+		return
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+	exposeUploadHeaders(writer)
+
+	writer.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	h.writeJSON(writer, http.StatusOK, map[string]interface{}{
+		"result": skyconv.ToMap((*skyconv.MapAsset)(&asset)),
+	})
+}
+
+func (h *ChunkUploadHandler) head(payload *router.Payload, response *router.Response) {
+	id := payload.Params[0]
+	session := skydb.UploadSession{}
+	if err := h.fetchSession(payload.DBConn.GetUploadSession, id, &session); err != nil {
+		response.Err = err
+		return
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+	exposeUploadHeaders(writer)
+
+	writer.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	writer.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	writer.Header().Set("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (h *ChunkUploadHandler) abort(payload *router.Payload, response *router.Response) {
+	id := payload.Params[0]
+	conn := payload.DBConn
+	txDB, ok := conn.PublicDB().(skydb.Transactional)
+	if !ok {
+		response.Err = skyerr.NewError(skyerr.NotSupported, "database impl does not support transaction")
+		return
+	}
+
+	txErr := skydb.WithTransaction(txDB, func() error {
+		session := skydb.UploadSession{}
+		if err := h.fetchSession(conn.GetUploadSessionForUpdate, id, &session); err != nil {
+			return err
+		}
+
+		if err := h.ChunkAssembler.Abort(&session.ChunkUpload); err != nil {
+			logger := logging.CreateLogger(payload.Context(), "handler")
+			logger.WithError(err).Warnf("Failed to abort upload")
+		}
+
+		if err := conn.DeleteUploadSession(id); err != nil {
+			return h.sessionError(id, err)
+		}
+		return nil
+	})
+	if txErr != nil {
+		response.Err = skyerr.MakeError(txErr)
+		return
+	}
+
+	writer := response.Writer()
+	if writer == nil {
+		// The response is already written.
+		return
+	}
+	exposeUploadHeaders(writer)
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// fetchSession fetches the upload session with fetch. An expired session
+// is not found, since its upload is aborted by the asset collector.
+func (h *ChunkUploadHandler) fetchSession(
+	fetch func(id string, session *skydb.UploadSession) error,
+	id string,
+	session *skydb.UploadSession,
+) skyerr.Error {
+	err := fetch(id, session)
+	if err == nil && session.IsExpired(timeNow()) {
+		err = skydb.ErrUploadSessionNotFound
+	}
+	if err != nil {
+		return h.sessionError(id, err)
+	}
+	return nil
+}
+
+func (h *ChunkUploadHandler) sessionError(id string, err error) skyerr.Error {
+	if err == skydb.ErrUploadSessionNotFound {
+		return skyerr.NewError(skyerr.ResourceNotFound, "Upload session not found")
+	}
+	return skyerr.NewResourceFetchFailureErr("upload session", id)
+}
+
+func offsetMismatchError(offset int64) skyerr.Error {
+	return skyerr.NewErrorWithInfo(
+		skyerr.ConstraintViolated,
+		"Upload-Offset does not match the offset of the upload",
+		map[string]interface{}{
+			"offset": offset,
+		},
+	)
+}
+
+func (h *ChunkUploadHandler) writeJSON(writer http.ResponseWriter, status int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(body)
+}
+
+// exposeUploadHeaders allows cross-origin clients to read the headers of
+// the upload protocol.
+func exposeUploadHeaders(writer http.ResponseWriter) {
+	writer.Header().Set(
+		"Access-Control-Expose-Headers",
+		"Location, Upload-Offset, Upload-Length, Upload-Expires",
+	)
+}
@@ -0,0 +1,334 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type uploadSessionConn struct {
+	naiveAssetConn
+	sessions map[string]skydb.UploadSession
+	locked   []string
+}
+
+// PublicDB returns a database of which a rolled back transaction
+// restores the upload sessions.
+func (c *uploadSessionConn) PublicDB() skydb.Database {
+	return &uploadSessionDatabase{c: c}
+}
+
+func (c *uploadSessionConn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	saved, ok := c.sessions[id]
+	if !ok {
+		return skydb.ErrUploadSessionNotFound
+	}
+	*session = saved
+	return nil
+}
+
+func (c *uploadSessionConn) GetUploadSessionForUpdate(id string, session *skydb.UploadSession) error {
+	c.locked = append(c.locked, id)
+	return c.GetUploadSession(id, session)
+}
+
+func (c *uploadSessionConn) SaveUploadSession(session *skydb.UploadSession) error {
+	c.sessions[session.ID] = *session
+	return nil
+}
+
+func (c *uploadSessionConn) DeleteUploadSession(id string) error {
+	if _, ok := c.sessions[id]; !ok {
+		return skydb.ErrUploadSessionNotFound
+	}
+	delete(c.sessions, id)
+	return nil
+}
+
+type uploadSessionDatabase struct {
+	skydb.Database
+	c        *uploadSessionConn
+	sessions map[string]skydb.UploadSession
+}
+
+func (db *uploadSessionDatabase) Begin() error {
+	db.sessions = map[string]skydb.UploadSession{}
+	for id, session := range db.c.sessions {
+		db.sessions[id] = session
+	}
+	return nil
+}
+
+func (db *uploadSessionDatabase) Commit() error {
+	return nil
+}
+
+func (db *uploadSessionDatabase) Rollback() error {
+	db.c.sessions = db.sessions
+	return nil
+}
+
+// errorReader returns the data and then an error, like an interrupted
+// request body.
+type errorReader struct {
+	data string
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestChunkUploadHandler(t *testing.T) {
+	Convey("ChunkUploadHandler", t, func() {
+		conn := &uploadSessionConn{
+			naiveAssetConn: naiveAssetConn{savedAsset: map[string]*skydb.Asset{}},
+			sessions:       map[string]skydb.UploadSession{},
+		}
+
+		stagingDir, err := ioutil.TempDir("", "skygear-upload-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(stagingDir)
+
+		store := &mapAssetStore{files: map[string][]byte{}}
+		h := &ChunkUploadHandler{
+			AssetStore:     store,
+			ChunkAssembler: asset.NewChunkAssembler(store, stagingDir),
+		}
+
+		r := newmodGateway("(.+)")
+		prepare := func(p *router.Payload) {
+			p.DBConn = conn
+		}
+		r.Handle("POST", h, prepare)
+		r.Handle("PATCH", h, prepare)
+		r.Handle("HEAD", h, prepare)
+		r.Handle("DELETE", h, prepare)
+
+		originalUUIDNew := uuidNew
+		uuidNew = func() string {
+			return "9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e"
+		}
+		defer func() {
+			uuidNew = originalUUIDNew
+		}()
+
+		realTimeNow := timeNow
+		timeNow = func() time.Time { return time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC) }
+		defer func() {
+			timeNow = realTimeNow
+		}()
+
+		create := func() {
+			req, _ := http.NewRequest("POST", "http://skygear.test/hello.txt", nil)
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Upload-Length", "10")
+			resp := r.Do(req)
+			So(resp.Code, ShouldEqual, http.StatusCreated)
+		}
+
+		patch := func(offset string, body string) *http.Request {
+			req, _ := http.NewRequest(
+				"PATCH",
+				"http://skygear.test/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e",
+				strings.NewReader(body),
+			)
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			req.Header.Set("Upload-Offset", offset)
+			return req
+		}
+
+		Convey("creates an upload session", func() {
+			req, _ := http.NewRequest("POST", "http://skygear.test/hello.txt", nil)
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Upload-Length", "10")
+			resp := r.Do(req)
+
+			So(resp.Code, ShouldEqual, http.StatusCreated)
+			So(resp.Header().Get("Location"), ShouldEqual, "/uploads/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e")
+			So(resp.Header().Get("Upload-Offset"), ShouldEqual, "0")
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": {
+					"id": "9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e",
+					"offset": 0,
+					"length": 10
+				}
+			}`)
+
+			session := conn.sessions["9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e"]
+			So(session.Name, ShouldEqual, "9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e-hello.txt")
+			So(session.ContentType, ShouldEqual, "text/plain")
+			So(session.Length, ShouldEqual, 10)
+			So(session.ExpiresAt, ShouldResemble, time.Date(2006, 1, 3, 15, 4, 5, 0, time.UTC))
+			So(resp.Header().Get("Upload-Expires"), ShouldEqual, "Tue, 03 Jan 2006 15:04:05 GMT")
+		})
+
+		Convey("rejects session without upload length", func() {
+			req, _ := http.NewRequest("POST", "http://skygear.test/hello.txt", nil)
+			req.Header.Set("Content-Type", "text/plain")
+			resp := r.Do(req)
+
+			So(resp.Code, ShouldEqual, http.StatusBadRequest)
+			So(conn.sessions, ShouldBeEmpty)
+		})
+
+		Convey("uploads a file in chunks", func() {
+			create()
+
+			resp := r.Do(patch("0", "I am "))
+			So(resp.Code, ShouldEqual, http.StatusNoContent)
+			So(resp.Header().Get("Upload-Offset"), ShouldEqual, "5")
+			So(conn.locked, ShouldResemble, []string{"9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e"})
+
+			req, _ := http.NewRequest("HEAD", "http://skygear.test/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e", nil)
+			resp = r.Do(req)
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Upload-Offset"), ShouldEqual, "5")
+			So(resp.Header().Get("Upload-Length"), ShouldEqual, "10")
+
+			resp = r.Do(patch("5", "a boy"))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(resp.Header().Get("Upload-Offset"), ShouldEqual, "10")
+			So(resp.Body.String(), ShouldEqualJSON, `{
+				"result": {
+					"$type": "asset",
+					"$name": "9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e-hello.txt",
+					"$url": "9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e-hello.txt",
+					"$content_type": "text/plain"
+				}
+			}`)
+
+			So(string(store.files["9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e-hello.txt"]), ShouldEqual, "I am a boy")
+			savedAsset := conn.savedAsset["9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e-hello.txt"]
+			So(savedAsset, ShouldNotBeNil)
+			So(savedAsset.Size, ShouldEqual, 10)
+			So(conn.sessions, ShouldBeEmpty)
+		})
+
+		Convey("rejects chunk with mismatched offset", func() {
+			create()
+
+			resp := r.Do(patch("3", "am a boy"))
+			So(resp.Code, ShouldEqual, http.StatusConflict)
+			So(conn.sessions["9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e"].Offset, ShouldEqual, 0)
+		})
+
+		Convey("rejects chunk of non-existent session", func() {
+			resp := r.Do(patch("0", "I am a boy"))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("rejects expired session", func() {
+			create()
+
+			timeNow = func() time.Time { return time.Date(2006, 1, 3, 15, 4, 5, 0, time.UTC) }
+			resp := r.Do(patch("0", "I am a boy"))
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+
+			req, _ := http.NewRequest("HEAD", "http://skygear.test/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e", nil)
+			resp = r.Do(req)
+			So(resp.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("keeps session of a failed chunk", func() {
+			create()
+
+			req, _ := http.NewRequest(
+				"PATCH",
+				"http://skygear.test/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e",
+				&errorReader{data: "I am"},
+			)
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			req.Header.Set("Upload-Offset", "0")
+			resp := r.Do(req)
+			So(resp.Code, ShouldEqual, http.StatusInternalServerError)
+			So(conn.sessions["9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e"].Offset, ShouldEqual, 4)
+
+			resp = r.Do(patch("4", " a boy"))
+			So(resp.Code, ShouldEqual, http.StatusOK)
+			So(string(store.files["9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e-hello.txt"]), ShouldEqual, "I am a boy")
+		})
+
+		Convey("aborts an upload session", func() {
+			create()
+
+			req, _ := http.NewRequest("DELETE", "http://skygear.test/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e", nil)
+			resp := r.Do(req)
+			So(resp.Code, ShouldEqual, http.StatusNoContent)
+			So(conn.sessions, ShouldBeEmpty)
+		})
+
+		Convey("with asset policy", func() {
+			h.AssetPolicies = asset.Policies{
+				"note.image": asset.Policy{
+					ContentTypes: []string{"image/*"},
+					MaxSize:      100,
+				},
+			}
+
+			Convey("keeps record field in session URL", func() {
+				req, _ := http.NewRequest("POST", "http://skygear.test/image.png?record_type=note&field=image", nil)
+				req.Header.Set("Content-Type", "image/png")
+				req.Header.Set("Upload-Length", "10")
+				resp := r.Do(req)
+
+				So(resp.Code, ShouldEqual, http.StatusCreated)
+				So(resp.Header().Get("Location"), ShouldEqual, "/uploads/9a6b0f3e-7d6c-4c9a-8d58-9e8c06b1cf2e?field=image&record_type=note")
+			})
+
+			Convey("rejects session exceeding max size", func() {
+				req, _ := http.NewRequest("POST", "http://skygear.test/image.png?record_type=note&field=image", nil)
+				req.Header.Set("Content-Type", "image/png")
+				req.Header.Set("Upload-Length", "101")
+				resp := r.Do(req)
+
+				So(resp.Code, ShouldEqual, http.StatusBadRequest)
+				So(conn.sessions, ShouldBeEmpty)
+			})
+
+			Convey("rejects completed file with disguised content type", func() {
+				req, _ := http.NewRequest("POST", "http://skygear.test/image.png?record_type=note&field=image", nil)
+				req.Header.Set("Content-Type", "image/png")
+				req.Header.Set("Upload-Length", "10")
+				r.Do(req)
+
+				req = patch("0", "I am a boy")
+				req.URL.RawQuery = "record_type=note&field=image"
+				resp := r.Do(req)
+
+				So(resp.Code, ShouldEqual, http.StatusBadRequest)
+				So(store.files, ShouldBeEmpty)
+				So(conn.savedAsset, ShouldBeEmpty)
+				So(conn.sessions, ShouldBeEmpty)
+			})
+		})
+	})
+}
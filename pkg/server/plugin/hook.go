@@ -16,11 +16,13 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/router"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -67,3 +69,28 @@ func CreateHookFunc(p *Plugin, hookInfo pluginHookInfo) hook.Func {
 
 	return hookFunc
 }
+
+// CreateAssetHookFunc returns a hook.AssetFunc that run the asset hook
+// registered by a plugin. The hook receives the uploaded asset with a URL
+// for downloading the file, and rejects the asset by returning an error.
+//
+// Asset hooks are always executed synchronously.
+func CreateAssetHookFunc(p *Plugin, hookInfo pluginHookInfo) hook.AssetFunc {
+	return func(ctx context.Context, asset *skydb.Asset) skyerr.Error {
+		in, err := json.Marshal(map[string]interface{}{
+			"asset": skyconv.ToMap((*skyconv.MapAsset)(asset)),
+		})
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+
+		if _, err := p.transport.RunLambda(ctx, hookInfo.Name, in); err != nil {
+			if pluginError, ok := err.(skyerr.Error); ok {
+				return pluginError
+			}
+			return skyerr.MakeError(err)
+		}
+
+		return nil
+	}
+}
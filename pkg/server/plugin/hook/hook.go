@@ -34,6 +34,11 @@ const (
 	AfterDelete  Kind = "afterDelete"
 )
 
+// BeforeAssetSave is executed on an uploaded file after it is put to the
+// asset store but before the asset is saved, so that the file can be
+// scanned and rejected.
+const BeforeAssetSave Kind = "beforeAssetSave"
+
 // Func defines the interface of a function that can be hooked.
 //
 // The supplied record is fully fetched for all four kind of hooks.
@@ -41,6 +46,10 @@ type Func func(context.Context, *skydb.Record, *skydb.Record) skyerr.Error
 
 type recordTypeHookMap map[string][]Func
 
+// AssetFunc defines the interface of a function that can be hooked on
+// uploaded assets.
+type AssetFunc func(context.Context, *skydb.Asset) skyerr.Error
+
 // Registry is a registry of hooks by record type.
 //
 // It provides method to execute hooks but is not responsible to execute
@@ -54,6 +63,7 @@ type Registry struct {
 	afterSaveHooks    recordTypeHookMap
 	beforeDeleteHooks recordTypeHookMap
 	afterDeleteHooks  recordTypeHookMap
+	beforeAssetSave   []AssetFunc
 }
 
 // NewRegistry returns a Registry ready for use.
@@ -64,6 +74,7 @@ func NewRegistry() *Registry {
 		recordTypeHookMap{},
 		recordTypeHookMap{},
 		recordTypeHookMap{},
+		nil,
 	}
 }
 
@@ -101,6 +112,43 @@ func (r *Registry) ExecuteHooks(ctx context.Context, kind Kind, record *skydb.Re
 	return nil
 }
 
+// RegisterAssetHook adds the specific hook to be executed at the moment
+// provided by kind on uploaded assets.
+func (r *Registry) RegisterAssetHook(kind Kind, hook AssetFunc) error {
+	if kind != BeforeAssetSave {
+		return fmt.Errorf("unrecgonized kind of asset hook = %#v", string(kind))
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.beforeAssetSave = append(r.beforeAssetSave, hook)
+	return nil
+}
+
+// ExecuteAssetHooks executes registered hooks on the supplied asset to be
+// executed at the specific kind of moment.
+//
+// If one of the hooks returns an error, it halts execution of other hooks and
+// returns that error untouched.
+func (r *Registry) ExecuteAssetHooks(ctx context.Context, kind Kind, asset *skydb.Asset) skyerr.Error {
+	if kind != BeforeAssetSave {
+		return skyerr.NewError(skyerr.UnexpectedError, "Error getting asset hooks")
+	}
+
+	r.mutex.RLock()
+	hooks := make([]AssetFunc, len(r.beforeAssetSave))
+	copy(hooks, r.beforeAssetSave)
+	r.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(ctx, asset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *Registry) hooks(kind Kind, recordType string) (m []Func, err error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -20,6 +20,7 @@ import (
 
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook/hooktest"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -139,5 +140,28 @@ func TestHookRegistry(t *testing.T) {
 				registry.ExecuteHooks(ctx, AfterDelete, nil, nil)
 			}, ShouldPanic)
 		})
+
+		Convey("executes asset hooks until one rejects", func() {
+			scanned := []string{}
+			registry.RegisterAssetHook(BeforeAssetSave, func(ctx context.Context, asset *skydb.Asset) skyerr.Error {
+				scanned = append(scanned, asset.Name)
+				return skyerr.NewError(skyerr.AssetPolicyViolated, "infected")
+			})
+			registry.RegisterAssetHook(BeforeAssetSave, func(ctx context.Context, asset *skydb.Asset) skyerr.Error {
+				scanned = append(scanned, "unexpected")
+				return nil
+			})
+
+			err := registry.ExecuteAssetHooks(ctx, BeforeAssetSave, &skydb.Asset{Name: "file.txt"})
+			So(err, ShouldResemble, skyerr.NewError(skyerr.AssetPolicyViolated, "infected"))
+			So(scanned, ShouldResemble, []string{"file.txt"})
+		})
+
+		Convey("rejects asset hooks of record kinds", func() {
+			err := registry.RegisterAssetHook(BeforeSave, func(ctx context.Context, asset *skydb.Asset) skyerr.Error {
+				return nil
+			})
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -19,8 +19,11 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/golang/mock/gomock"
 	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -119,3 +122,48 @@ func TestCreateHookFunc(t *testing.T) {
 		})
 	})
 }
+
+func TestCreateAssetHookFunc(t *testing.T) {
+	Convey("CreateAssetHookFunc", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		transport := NewMockTransport(ctrl)
+		plugin := Plugin{transport: transport}
+
+		hookFunc := CreateAssetHookFunc(&plugin, pluginHookInfo{
+			Trigger: string(hook.BeforeAssetSave),
+			Name:    "scan_asset",
+		})
+
+		asset := skydb.Asset{
+			Name:        "file.txt",
+			ContentType: "text/plain",
+			Size:        10,
+		}
+
+		Convey("sends asset to plugin", func() {
+			transport.EXPECT().RunLambda(gomock.Any(), "scan_asset", gomock.Any()).
+				Do(func(ctx context.Context, name string, in []byte) {
+					So(in, ShouldEqualJSON, `{
+						"asset": {
+							"$type": "asset",
+							"$name": "file.txt",
+							"$content_type": "text/plain"
+						}
+					}`)
+				}).
+				Return([]byte(`{"result": null}`), nil)
+
+			So(hookFunc(context.Background(), &asset), ShouldBeNil)
+		})
+
+		Convey("returns error from plugin", func() {
+			transport.EXPECT().RunLambda(gomock.Any(), "scan_asset", gomock.Any()).
+				Return(nil, skyerr.NewError(skyerr.AssetPolicyViolated, "infected"))
+
+			err := hookFunc(context.Background(), &asset)
+			So(err, ShouldResemble, skyerr.NewError(skyerr.AssetPolicyViolated, "infected"))
+		})
+	})
+}
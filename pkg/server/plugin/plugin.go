@@ -175,6 +175,20 @@ func (c *Context) InitPlugins() {
 	}()
 }
 
+// Stop stops the timers and the transports of the plugins, after which
+// the plugins cannot serve requests.
+func (c *Context) Stop() {
+	if c.Scheduler != nil {
+		c.Scheduler.Stop()
+	}
+
+	for _, eachPlugin := range c.plugins {
+		if transport, ok := eachPlugin.transport.(StoppableTransport); ok {
+			transport.Stop()
+		}
+	}
+}
+
 // IsInitialized returns true if all the plugins have been initialized
 func (c *Context) IsInitialized() bool {
 	for _, eachPlugin := range c.plugins {
@@ -340,6 +354,11 @@ func (p *Plugin) initLambda(r *router.Router, injector router.HandlerInjector, l
 func (p *Plugin) initHook(registry *hook.Registry, hookInfos []pluginHookInfo) {
 	for _, hookInfo := range hookInfos {
 		kind := hook.Kind(hookInfo.Trigger)
+		if kind == hook.BeforeAssetSave {
+			registry.RegisterAssetHook(kind, CreateAssetHookFunc(p, hookInfo))
+			continue
+		}
+
 		recordType := hookInfo.Type
 
 		registry.Register(kind, recordType, CreateHookFunc(p, hookInfo))
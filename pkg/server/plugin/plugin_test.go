@@ -145,4 +145,27 @@ func TestPlugin(t *testing.T) {
 		})
 	})
 
+	Convey("stop context", t, func() {
+		stoppable := &stoppableTransport{}
+		ctx := Context{
+			plugins: []*Plugin{
+				{transport: &nullTransport{}},
+				{transport: stoppable},
+			},
+			Scheduler: cron.New(),
+		}
+		ctx.Scheduler.Start()
+
+		ctx.Stop()
+		So(stoppable.stopped, ShouldBeTrue)
+	})
+}
+
+type stoppableTransport struct {
+	nullTransport
+	stopped bool
+}
+
+func (t *stoppableTransport) Stop() {
+	t.stopped = true
 }
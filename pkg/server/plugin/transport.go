@@ -103,6 +103,12 @@ type BidirectionalTransport interface {
 	SetRouter(*router.Router)
 }
 
+// StoppableTransport is a transport that runs in the background, such as
+// listening on a socket, until it is stopped.
+type StoppableTransport interface {
+	Stop()
+}
+
 // ContextMap returns a map of the user request context.
 func ContextMap(ctx context.Context) map[string]interface{} {
 	if ctx == nil {
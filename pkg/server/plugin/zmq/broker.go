@@ -509,6 +509,12 @@ func (lb *Broker) sendChannelParcelToZMQ(p *parcel, push *zmq.Socket) {
 	go lb.setTimeout(key)
 }
 
+// Stop stops the Channeler, and the Run which closes the zmq sockets
+// after the current poll.
+func (lb *Broker) Stop() {
+	lb.stop <- 1
+}
+
 func (lb *Broker) RPC(requestChan chan chan []byte, in []byte) {
 	lb.RPCWithWorker(requestChan, in, make(map[string]string), "", 0)
 }
@@ -42,6 +42,7 @@ type zmqTransport struct {
 	logger      *logrus.Entry
 	config      skyconfig.Configuration
 	router      *router.Router
+	stop        chan struct{}
 }
 
 const ZMQWorkerIDsContextKey string = "ZMQWorkerIDsContextKey"
@@ -219,11 +220,22 @@ func (p *zmqTransport) handleRequest(parcel *parcel) {
 
 func (p *zmqTransport) listenRequests() {
 	for {
-		parcel := <-p.broker.ReqChan
-		go p.handleRequest(parcel)
+		select {
+		case parcel := <-p.broker.ReqChan:
+			go p.handleRequest(parcel)
+		case <-p.stop:
+			return
+		}
 	}
 }
 
+// Stop stops listening to the requests of the plugin and stops the
+// broker.
+func (p *zmqTransport) Stop() {
+	close(p.stop)
+	p.broker.Stop()
+}
+
 type zmqTransportFactory struct {
 }
 
@@ -241,6 +253,7 @@ func (f zmqTransportFactory) Open(name string, args []string, config skyconfig.C
 		broker: broker,
 		logger: logger,
 		config: config,
+		stop:   make(chan struct{}),
 	}
 	go p.listenRequests()
 
@@ -27,7 +27,12 @@ type NotificationPreprocessor struct {
 }
 
 func (p NotificationPreprocessor) Preprocess(payload *router.Payload, response *router.Response) int {
-	routeSender, ok := p.NotificationSender.(push.RouteSender)
+	sender := p.NotificationSender
+	if reloadableSender, ok := sender.(*push.ReloadableSender); ok {
+		sender = reloadableSender.Sender()
+	}
+
+	routeSender, ok := sender.(push.RouteSender)
 	if !ok {
 		response.Err = skyerr.NewError(skyerr.UnexpectedPushNotificationNotConfigured, "Unknown notification sender.")
 		return http.StatusInternalServerError
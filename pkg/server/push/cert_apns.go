@@ -144,6 +144,10 @@ func (pusher *certBasedAPNSPusher) Start() {
 
 // Stop stops and cleans up the pusher
 func (pusher *certBasedAPNSPusher) Stop() {
+	if pusher.failed == nil {
+		return
+	}
+
 	close(pusher.failed)
 	pusher.failed = nil
 }
@@ -236,6 +236,10 @@ func TestCertBaseAPNSPusherFeedbackInterface(t *testing.T) {
 			So(failed, ShouldNotBeNil)
 		})
 
+		Convey("stops without being started", func() {
+			So(pusher.Stop, ShouldNotPanic)
+		})
+
 		Convey("can unregister devices", func() {
 			pusher.deleteDeviceToken(
 				"token-to-be-deleted-1",
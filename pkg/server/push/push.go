@@ -16,6 +16,7 @@ package push
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
@@ -93,3 +94,41 @@ func (s RouteSender) Send(m Mapper, device skydb.Device) error {
 
 	return sender.Send(m, device)
 }
+
+// ReloadableSender sends notifications by a sender which can be replaced
+// while notifications are being sent, such as when the push credentials
+// are reloaded.
+type ReloadableSender struct {
+	mutex  sync.RWMutex
+	sender Sender
+}
+
+// NewReloadableSender returns a ReloadableSender sending notifications by
+// sender.
+func NewReloadableSender(sender Sender) *ReloadableSender {
+	return &ReloadableSender{
+		sender: sender,
+	}
+}
+
+// Sender returns the sender of notifications.
+func (s *ReloadableSender) Sender() Sender {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.sender
+}
+
+// Replace replaces the sender of notifications, and returns the replaced
+// sender.
+func (s *ReloadableSender) Replace(sender Sender) Sender {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	replaced := s.sender
+	s.sender = sender
+	return replaced
+}
+
+// Send sends the notification by the sender.
+func (s *ReloadableSender) Send(m Mapper, device skydb.Device) error {
+	return s.Sender().Send(m, device)
+}
@@ -92,6 +92,35 @@ func TestRouteSender(t *testing.T) {
 	})
 }
 
+func TestReloadableSender(t *testing.T) {
+	Convey("ReloadableSender", t, func() {
+		oldSender := mockSender{}
+		newSender := mockSender{}
+		sender := NewReloadableSender(&oldSender)
+
+		device := skydb.Device{
+			Type: "aps",
+		}
+		message := map[string]interface{}{
+			"alert": "hello",
+		}
+
+		Convey("sends notification by sender", func() {
+			So(sender.Send(MapMapper(message), device), ShouldBeNil)
+			So(oldSender.note, ShouldResemble, message)
+		})
+
+		Convey("sends notification by replaced sender", func() {
+			So(sender.Replace(&newSender), ShouldEqual, &oldSender)
+			So(sender.Sender(), ShouldEqual, &newSender)
+
+			So(sender.Send(MapMapper(message), device), ShouldBeNil)
+			So(oldSender.note, ShouldBeNil)
+			So(newSender.note, ShouldResemble, message)
+		})
+	})
+}
+
 func jsonToMap(j string) map[string]interface{} {
 	m := map[string]interface{}{}
 	if err := json.Unmarshal([]byte(j), &m); err != nil {
@@ -0,0 +1,222 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var timeNow = time.Now
+
+// defaultClaimDuration is the default duration of the claim of a due
+// scheduled push.
+const defaultClaimDuration = 10 * time.Minute
+
+// Scheduler sends scheduled push notifications when they are due.
+//
+// Scheduled pushes are persisted in skydb, so that pending notifications
+// are sent after the server restarts. A due push is claimed before it is
+// sent, so that it is sent by one of the servers sharing the database.
+// If a server stops while sending a push, the push is claimed by another
+// server after ClaimDuration, and sent to the devices not yet sent.
+type Scheduler struct {
+	ConnOpener    func() (skydb.Conn, error)
+	Sender        Sender
+	Interval      time.Duration
+	ClaimDuration time.Duration
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Run checks for due scheduled pushes periodically until Stop is called.
+func (s *Scheduler) Run() {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stop := s.stopChan()
+	for {
+		select {
+		case <-stop:
+			log.Infoln("push: stopping the scheduler")
+			return
+		default:
+		}
+
+		s.dispatch(timeNow())
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			log.Infoln("push: stopping the scheduler")
+			return
+		}
+	}
+}
+
+// Stop stops the scheduler without waiting for the pushes being
+// dispatched. A scheduler stopped before it runs does not dispatch any
+// push.
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan())
+	})
+}
+
+func (s *Scheduler) stopChan() chan struct{} {
+	s.initOnce.Do(func() {
+		s.stop = make(chan struct{})
+	})
+	return s.stop
+}
+
+func (s *Scheduler) dispatch(now time.Time) {
+	conn, err := s.ConnOpener()
+	if err != nil {
+		log.WithField("err", err).Errorln("push: failed to open skydb.Conn")
+		return
+	}
+	defer conn.Close()
+
+	claimDuration := s.ClaimDuration
+	if claimDuration <= 0 {
+		claimDuration = defaultClaimDuration
+	}
+
+	pushes, err := conn.ClaimDueScheduledPushes(now, now.Add(claimDuration))
+	if err != nil {
+		log.WithField("err", err).Errorln("push: failed to claim scheduled pushes")
+		return
+	}
+
+	for i := range pushes {
+		s.dispatchPush(conn, &pushes[i], now)
+	}
+}
+
+func (s *Scheduler) dispatchPush(conn skydb.Conn, p *skydb.ScheduledPush, now time.Time) {
+	logger := log.WithField("id", p.ID)
+
+	// A push failed to be dispatched is left claimed, and is dispatched
+	// again after the claim expires.
+	devices, err := scheduledPushDevices(conn, p)
+	if err != nil {
+		logger.WithField("err", err).Errorln("push: failed to query devices of scheduled push")
+		return
+	}
+
+	// A push still pending after being dispatched is due again when it
+	// is due to the next device, or to devices registered meanwhile when
+	// it is due to all devices.
+	nextDueAt := p.LatestDueAt()
+	m := MapMapper(p.Notification)
+	for _, device := range devices {
+		if p.IsSentToDevice(device.ID) {
+			continue
+		}
+		if dueAt := p.DueAt(device.TimeZone); dueAt.After(now) {
+			if dueAt.Before(nextDueAt) {
+				nextDueAt = dueAt
+			}
+			continue
+		}
+
+		// The device is marked sent before sending, so that the
+		// notification is not sent again to the device if the server
+		// stops after sending.
+		sentDeviceIDs := append(append([]string{}, p.SentDeviceIDs...), device.ID)
+		if err := conn.MarkScheduledPushSent(p.ID, sentDeviceIDs); err == skydb.ErrScheduledPushNotFound {
+			logger.Infoln("push: scheduled push is cancelled while being sent")
+			return
+		} else if err != nil {
+			logger.WithField("err", err).Errorln("push: failed to save scheduled push")
+			return
+		}
+		p.SentDeviceIDs = sentDeviceIDs
+
+		if err := s.Sender.Send(m, device); err != nil {
+			logger.WithFields(logrus.Fields{
+				"device": device.ID,
+				"err":    err,
+			}).Warnln("push: failed to send scheduled notification")
+		}
+	}
+
+	status := skydb.ScheduledPushPending
+	if !now.Before(p.LatestDueAt()) {
+		status = skydb.ScheduledPushSent
+	}
+
+	if err := conn.ReleaseScheduledPush(p.ID, status, nextDueAt); err == skydb.ErrScheduledPushNotFound {
+		logger.Infoln("push: scheduled push is cancelled while being sent")
+	} else if err != nil {
+		logger.WithField("err", err).Errorln("push: failed to save scheduled push")
+	}
+}
+
+func scheduledPushDevices(conn skydb.Conn, p *skydb.ScheduledPush) ([]skydb.Device, error) {
+	devices := []skydb.Device{}
+
+	switch p.TargetType {
+	case skydb.PushTargetUser:
+		for _, userID := range p.TargetIDs {
+			var userDevices []skydb.Device
+			var err error
+			if p.Topic != "" {
+				userDevices, err = conn.QueryDevicesByUserAndTopic(userID, p.Topic)
+			} else {
+				userDevices, err = conn.QueryDevicesByUser(userID)
+			}
+			if err != nil && err != skydb.ErrUserNotFound {
+				return nil, err
+			}
+			devices = append(devices, userDevices...)
+		}
+	case skydb.PushTargetDevice:
+		for _, deviceID := range p.TargetIDs {
+			device := skydb.Device{}
+			if err := conn.GetDevice(deviceID, &device); err == skydb.ErrDeviceNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if p.Topic == "" || p.Topic == device.Topic {
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	// FIXME: The deduplication should be done at device register.
+	tokens := map[string]bool{}
+	dedupedDevices := []skydb.Device{}
+	for _, device := range devices {
+		if _, ok := tokens[device.Token]; !ok {
+			tokens[device.Token] = true
+			dedupedDevices = append(dedupedDevices, device)
+		}
+	}
+	return dedupedDevices, nil
+}
@@ -0,0 +1,307 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type scheduledPushConn struct {
+	devices      []skydb.Device
+	pushes       map[string]skydb.ScheduledPush
+	claimedUntil map[string]time.Time
+	dueAt        map[string]time.Time
+	skydb.Conn
+}
+
+func (conn *scheduledPushConn) Close() error {
+	return nil
+}
+
+func (conn *scheduledPushConn) GetDevice(id string, device *skydb.Device) error {
+	for _, d := range conn.devices {
+		if d.ID == id {
+			*device = d
+			return nil
+		}
+	}
+	return skydb.ErrDeviceNotFound
+}
+
+func (conn *scheduledPushConn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
+	result := []skydb.Device{}
+	for _, d := range conn.devices {
+		if d.AuthInfoID == user {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+func (conn *scheduledPushConn) ClaimDueScheduledPushes(t time.Time, claimUntil time.Time) ([]skydb.ScheduledPush, error) {
+	result := []skydb.ScheduledPush{}
+	for id, p := range conn.pushes {
+		dueAt, ok := conn.dueAt[id]
+		if !ok {
+			dueAt = p.EarliestDueAt()
+		}
+		if dueAt.After(t) {
+			continue
+		}
+		if p.Status == skydb.ScheduledPushPending ||
+			p.Status == skydb.ScheduledPushDispatching && !conn.claimedUntil[id].After(t) {
+			p.Status = skydb.ScheduledPushDispatching
+			conn.pushes[id] = p
+			conn.claimedUntil[id] = claimUntil
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func (conn *scheduledPushConn) MarkScheduledPushSent(id string, deviceIDs []string) error {
+	p := conn.pushes[id]
+	if p.Status != skydb.ScheduledPushDispatching {
+		return skydb.ErrScheduledPushNotFound
+	}
+	p.SentDeviceIDs = deviceIDs
+	conn.pushes[id] = p
+	return nil
+}
+
+func (conn *scheduledPushConn) ReleaseScheduledPush(id string, status skydb.ScheduledPushStatus, dueAt time.Time) error {
+	p := conn.pushes[id]
+	if p.Status != skydb.ScheduledPushDispatching {
+		return skydb.ErrScheduledPushNotFound
+	}
+	p.Status = status
+	conn.pushes[id] = p
+	conn.dueAt[id] = dueAt
+	delete(conn.claimedUntil, id)
+	return nil
+}
+
+type recordingSender struct {
+	devices []skydb.Device
+	onSend  func(device skydb.Device)
+}
+
+func (s *recordingSender) Send(m Mapper, device skydb.Device) error {
+	s.devices = append(s.devices, device)
+	if s.onSend != nil {
+		s.onSend(device)
+	}
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	Convey("Scheduler", t, func() {
+		conn := &scheduledPushConn{
+			devices: []skydb.Device{
+				{ID: "hk", Token: "hk-token", AuthInfoID: "johndoe", TimeZone: "Asia/Hong_Kong"},
+				{ID: "ny", Token: "ny-token", AuthInfoID: "johndoe", TimeZone: "America/New_York"},
+				{ID: "utc", Token: "utc-token", AuthInfoID: "janedoe"},
+			},
+			pushes:       map[string]skydb.ScheduledPush{},
+			claimedUntil: map[string]time.Time{},
+			dueAt:        map[string]time.Time{},
+		}
+		sender := &recordingSender{}
+		scheduler := &Scheduler{
+			ConnOpener: func() (skydb.Conn, error) { return conn, nil },
+			Sender:     sender,
+		}
+
+		Convey("sends due push", func() {
+			conn.pushes["push"] = skydb.ScheduledPush{
+				ID:           "push",
+				TargetType:   skydb.PushTargetDevice,
+				TargetIDs:    []string{"hk", "utc"},
+				Notification: map[string]interface{}{"acme": "interesting"},
+				SendAt:       time.Date(2006, 1, 2, 15, 0, 0, 0, time.UTC),
+				Status:       skydb.ScheduledPushPending,
+			}
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 14, 59, 0, 0, time.UTC))
+			So(sender.devices, ShouldBeEmpty)
+			So(conn.pushes["push"].Status, ShouldEqual, skydb.ScheduledPushPending)
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 15, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldHaveLength, 2)
+			So(conn.pushes["push"].Status, ShouldEqual, skydb.ScheduledPushSent)
+			So(conn.pushes["push"].SentDeviceIDs, ShouldResemble, []string{"hk", "utc"})
+		})
+
+		Convey("sends local time push per device time zone", func() {
+			conn.pushes["push"] = skydb.ScheduledPush{
+				ID:           "push",
+				TargetType:   skydb.PushTargetUser,
+				TargetIDs:    []string{"johndoe", "janedoe"},
+				Notification: map[string]interface{}{"acme": "interesting"},
+				SendAt:       time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC),
+				LocalTime:    true,
+				Status:       skydb.ScheduledPushPending,
+			}
+
+			// 09:00 in Hong Kong
+			scheduler.dispatch(time.Date(2006, 1, 2, 1, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldHaveLength, 1)
+			So(sender.devices[0].ID, ShouldEqual, "hk")
+
+			// 09:00 in UTC
+			scheduler.dispatch(time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldHaveLength, 2)
+			So(sender.devices[1].ID, ShouldEqual, "utc")
+			So(conn.pushes["push"].Status, ShouldEqual, skydb.ScheduledPushPending)
+
+			// 09:00 in New York
+			scheduler.dispatch(time.Date(2006, 1, 2, 14, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldHaveLength, 3)
+			So(sender.devices[2].ID, ShouldEqual, "ny")
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 21, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldHaveLength, 3)
+			So(conn.pushes["push"].Status, ShouldEqual, skydb.ScheduledPushSent)
+		})
+
+		Convey("releases local time push until it is due to the next device", func() {
+			conn.pushes["push"] = skydb.ScheduledPush{
+				ID:         "push",
+				TargetType: skydb.PushTargetUser,
+				TargetIDs:  []string{"johndoe", "janedoe"},
+				SendAt:     time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC),
+				LocalTime:  true,
+				Status:     skydb.ScheduledPushPending,
+			}
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 1, 0, 0, 0, time.UTC))
+			So(conn.dueAt["push"], ShouldResemble, time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC))
+
+			pushes, err := conn.ClaimDueScheduledPushes(time.Date(2006, 1, 2, 2, 0, 0, 0, time.UTC), time.Date(2006, 1, 2, 2, 10, 0, 0, time.UTC))
+			So(err, ShouldBeNil)
+			So(pushes, ShouldBeEmpty)
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 14, 0, 0, 0, time.UTC))
+			So(conn.dueAt["push"], ShouldResemble, time.Date(2006, 1, 2, 21, 0, 0, 0, time.UTC))
+		})
+
+		Convey("does not send cancelled push", func() {
+			conn.pushes["push"] = skydb.ScheduledPush{
+				ID:         "push",
+				TargetType: skydb.PushTargetDevice,
+				TargetIDs:  []string{"hk"},
+				SendAt:     time.Date(2006, 1, 2, 15, 0, 0, 0, time.UTC),
+				Status:     skydb.ScheduledPushCancelled,
+			}
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 16, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldBeEmpty)
+		})
+
+		Convey("stops sending push cancelled while being sent", func() {
+			conn.pushes["push"] = skydb.ScheduledPush{
+				ID:         "push",
+				TargetType: skydb.PushTargetDevice,
+				TargetIDs:  []string{"hk", "utc"},
+				SendAt:     time.Date(2006, 1, 2, 15, 0, 0, 0, time.UTC),
+				Status:     skydb.ScheduledPushPending,
+			}
+			sender.onSend = func(device skydb.Device) {
+				p := conn.pushes["push"]
+				p.Status = skydb.ScheduledPushCancelled
+				conn.pushes["push"] = p
+			}
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 16, 0, 0, 0, time.UTC))
+			So(sender.devices, ShouldHaveLength, 1)
+			So(conn.pushes["push"].Status, ShouldEqual, skydb.ScheduledPushCancelled)
+		})
+
+		Convey("does not send push claimed by another scheduler", func() {
+			conn.pushes["push"] = skydb.ScheduledPush{
+				ID:         "push",
+				TargetType: skydb.PushTargetDevice,
+				TargetIDs:  []string{"hk", "utc"},
+				SendAt:     time.Date(2006, 1, 2, 15, 0, 0, 0, time.UTC),
+				Status:     skydb.ScheduledPushDispatching,
+			}
+			conn.claimedUntil["push"] = time.Date(2006, 1, 2, 15, 10, 0, 0, time.UTC)
+
+			scheduler.dispatch(time.Date(2006, 1, 2, 15, 5, 0, 0, time.UTC))
+			So(sender.devices, ShouldBeEmpty)
+
+			Convey("sends push to devices not yet sent after the claim expires", func() {
+				p := conn.pushes["push"]
+				p.SentDeviceIDs = []string{"hk"}
+				conn.pushes["push"] = p
+
+				scheduler.dispatch(time.Date(2006, 1, 2, 15, 10, 0, 0, time.UTC))
+				So(sender.devices, ShouldHaveLength, 1)
+				So(sender.devices[0].ID, ShouldEqual, "utc")
+				So(conn.pushes["push"].Status, ShouldEqual, skydb.ScheduledPushSent)
+				So(conn.pushes["push"].SentDeviceIDs, ShouldResemble, []string{"hk", "utc"})
+			})
+		})
+	})
+}
+
+func TestSchedulerStop(t *testing.T) {
+	Convey("Scheduler", t, func() {
+		opened := make(chan struct{}, 10)
+		scheduler := &Scheduler{
+			ConnOpener: func() (skydb.Conn, error) {
+				opened <- struct{}{}
+				return nil, errors.New("no database")
+			},
+			Interval: time.Millisecond,
+		}
+
+		run := func() chan struct{} {
+			done := make(chan struct{})
+			go func() {
+				scheduler.Run()
+				close(done)
+			}()
+			return done
+		}
+
+		Convey("stops running", func() {
+			done := run()
+			<-opened
+			scheduler.Stop()
+			scheduler.Stop()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected scheduler stopped")
+			}
+		})
+
+		Convey("does not dispatch if stopped before run", func() {
+			scheduler.Stop()
+			select {
+			case <-run():
+			case <-time.After(time.Second):
+				t.Fatal("expected scheduler stopped")
+			}
+			So(opened, ShouldBeEmpty)
+		})
+	})
+}
@@ -172,6 +172,10 @@ func (pusher *tokenBasedAPNSPusher) Start() {
 
 // Stop stops and cleans up the pusher
 func (pusher *tokenBasedAPNSPusher) Stop() {
+	if pusher.failed == nil {
+		return
+	}
+
 	close(pusher.failed)
 	pusher.failed = nil
 
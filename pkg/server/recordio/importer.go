@@ -0,0 +1,280 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/recordutil"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// ConflictPolicy decides what to do with an imported record which
+// already exists in the database.
+type ConflictPolicy string
+
+// List of ConflictPolicy
+const (
+	// ConflictSkip leaves the existing record untouched.
+	ConflictSkip ConflictPolicy = "skip"
+
+	// ConflictOverwrite updates the existing record with the fields of
+	// the imported record.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+
+	// ConflictFail stops the import at the imported record.
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ParseConflictPolicy returns the ConflictPolicy of the specified name.
+// ConflictFail is returned if the name is empty.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch ConflictPolicy(name) {
+	case "", ConflictFail:
+		return ConflictFail, nil
+	case ConflictSkip, ConflictOverwrite:
+		return ConflictPolicy(name), nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %s", name)
+	}
+}
+
+const (
+	// DefaultBatchSize is the default number of records saved at a time.
+	DefaultBatchSize = 500
+
+	// DefaultMaxErrors is the default number of row errors kept in
+	// an ImportResult.
+	DefaultMaxErrors = 1000
+)
+
+// ImportError is the error of a row which is not imported.
+type ImportError struct {
+	Row      int          `json:"row"`
+	RecordID string       `json:"_id,omitempty"`
+	Err      skyerr.Error `json:"error"`
+}
+
+// ImportResult is the outcome of an import.
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Errors   []ImportError `json:"errors"`
+
+	// ErrorsTruncated is true if there are more failed rows than
+	// errors kept in Errors.
+	ErrorsTruncated bool `json:"errors_truncated,omitempty"`
+
+	// Aborted is true if the import is stopped by a conflict.
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+func (r *ImportResult) addError(maxErrors int, row int, recordID string, err skyerr.Error) {
+	r.Failed++
+	if len(r.Errors) >= maxErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, ImportError{
+		Row:      row,
+		RecordID: recordID,
+		Err:      err,
+	})
+}
+
+// Importer saves records read from a Reader in batches. Only a batch of
+// records is kept in memory at a time.
+type Importer struct {
+	Context    context.Context
+	Conn       skydb.Conn
+	Database   skydb.Database
+	AuthInfo   *skydb.AuthInfo
+	AssetStore asset.Store
+
+	// AssetPolicies are checked against the assets saved to imported
+	// records.
+	AssetPolicies asset.Policies
+
+	// HookRegistry executes the save hooks of imported records. Hooks
+	// are bypassed if it is nil.
+	HookRegistry *hook.Registry
+
+	Conflict  ConflictPolicy
+	BatchSize int
+	MaxErrors int
+}
+
+type importRow struct {
+	row    int
+	record *skydb.Record
+}
+
+// Import reads all records from reader and saves them. A returned error
+// means the import cannot continue, such as failing to read reader, and
+// the records in previous batches are saved.
+func (i *Importer) Import(reader Reader) (ImportResult, error) {
+	batchSize := i.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	maxErrors := i.MaxErrors
+	if maxErrors <= 0 {
+		maxErrors = DefaultMaxErrors
+	}
+
+	result := ImportResult{
+		Errors: []ImportError{},
+	}
+	batch := make([]importRow, 0, batchSize)
+	for !result.Aborted {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if rowErr, ok := err.(*RowError); ok {
+			result.addError(maxErrors, rowErr.Row, "", skyerr.NewError(skyerr.InvalidArgument, rowErr.Err.Error()))
+			continue
+		} else if err != nil {
+			return result, err
+		}
+
+		record.SanitizeForInput()
+		batch = append(batch, importRow{reader.Row(), record})
+		if len(batch) < batchSize {
+			continue
+		}
+
+		if err := i.importBatch(batch, maxErrors, &result); err != nil {
+			return result, err
+		}
+		batch = batch[:0]
+	}
+
+	if len(batch) > 0 && !result.Aborted {
+		if err := i.importBatch(batch, maxErrors, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (i *Importer) importBatch(batch []importRow, maxErrors int, result *ImportResult) error {
+	existing := map[skydb.RecordID]bool{}
+	if i.Conflict != ConflictOverwrite {
+		var err error
+		if existing, err = i.existingRecordIDs(batch); err != nil {
+			return err
+		}
+	}
+
+	rowMap := map[skydb.RecordID]int{}
+	records := make([]*skydb.Record, 0, len(batch))
+	for _, r := range batch {
+		if existing[r.record.ID] {
+			if i.Conflict == ConflictSkip {
+				result.Skipped++
+				continue
+			}
+			result.addError(maxErrors, r.row, r.record.ID.String(), skyerr.NewErrorf(
+				skyerr.Duplicated,
+				"record %s already exists",
+				r.record.ID,
+			))
+			result.Aborted = true
+			break
+		}
+
+		rowMap[r.record.ID] = r.row
+		records = append(records, r.record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if _, err := recordutil.ExtendRecordSchema(i.Context, i.Database, records); err != nil {
+		return err
+	}
+
+	req := recordutil.RecordModifyRequest{
+		Db:            i.Database,
+		Conn:          i.Conn,
+		AssetStore:    i.AssetStore,
+		AssetPolicies: i.AssetPolicies,
+		HookRegistry:  i.HookRegistry,
+		AuthInfo:      i.AuthInfo,
+		RecordsToSave: records,
+		WithMasterKey: true,
+		Context:       i.Context,
+		ModifyAt:      time.Now().UTC(),
+	}
+	resp := recordutil.RecordModifyResponse{
+		ErrMap: map[skydb.RecordID]skyerr.Error{},
+	}
+	if err := recordutil.RecordSaveHandler(&req, &resp); err != nil {
+		return err
+	}
+
+	result.Imported += len(resp.SavedRecords)
+	for _, record := range records {
+		if err, ok := resp.ErrMap[record.ID]; ok {
+			result.addError(maxErrors, rowMap[record.ID], record.ID.String(), err)
+		}
+	}
+	return nil
+}
+
+// existingRecordIDs returns the IDs of the records of a batch which exist
+// in the database. The records of each record type are fetched at once.
+func (i *Importer) existingRecordIDs(batch []importRow) (map[skydb.RecordID]bool, error) {
+	recordTypes := []string{}
+	idsByType := map[string][]skydb.RecordID{}
+	for _, r := range batch {
+		recordType := r.record.ID.Type
+		if _, ok := idsByType[recordType]; !ok {
+			recordTypes = append(recordTypes, recordType)
+		}
+		idsByType[recordType] = append(idsByType[recordType], r.record.ID)
+	}
+
+	existing := map[skydb.RecordID]bool{}
+	accessControlOptions := &skydb.AccessControlOptions{
+		BypassAccessControl: true,
+	}
+	for _, recordType := range recordTypes {
+		rows, err := i.Database.GetByIDs(idsByType[recordType], accessControlOptions)
+		if err == skydb.ErrRecordNotFound {
+			// the record type does not exist yet
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for rows.Scan() {
+			existing[rows.Record().ID] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordio
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestImporter(t *testing.T) {
+	Convey("Importer", t, func() {
+		db := skydbtest.NewMapDB()
+		conn := skydbtest.NewMapConn()
+		db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "owner",
+			Data:    skydb.Data{"title": "Existing"},
+		})
+		importer := Importer{
+			Context:  context.Background(),
+			Conn:     conn,
+			Database: db,
+			AuthInfo: &skydb.AuthInfo{ID: "_god"},
+		}
+		input := `{"_id":"note/0","title":"Zero"}
+{"_id":"note/1","title":"One"}
+{"_id":"note/2","title":"Two"}
+`
+
+		Convey("fails on conflict by default", func() {
+			result, err := importer.Import(NewReader(strings.NewReader(input), NDJSON, "", nil))
+			So(err, ShouldBeNil)
+			So(result.Imported, ShouldEqual, 1)
+			So(result.Failed, ShouldEqual, 1)
+			So(result.Aborted, ShouldBeTrue)
+			So(result.Errors, ShouldHaveLength, 1)
+			So(result.Errors[0].Row, ShouldEqual, 2)
+			So(result.Errors[0].RecordID, ShouldEqual, "note/1")
+			So(result.Errors[0].Err.Code(), ShouldEqual, skyerr.Duplicated)
+
+			So(db.RecordMap, ShouldContainKey, "note/0")
+			So(db.RecordMap, ShouldNotContainKey, "note/2")
+			So(db.RecordMap["note/1"].Data["title"], ShouldEqual, "Existing")
+		})
+
+		Convey("skips existing records", func() {
+			importer.Conflict = ConflictSkip
+			importer.BatchSize = 2
+			result, err := importer.Import(NewReader(strings.NewReader(input), NDJSON, "", nil))
+			So(err, ShouldBeNil)
+			So(result.Imported, ShouldEqual, 2)
+			So(result.Skipped, ShouldEqual, 1)
+			So(result.Errors, ShouldBeEmpty)
+			So(db.RecordMap["note/1"].Data["title"], ShouldEqual, "Existing")
+			So(db.RecordMap["note/2"].Data["title"], ShouldEqual, "Two")
+		})
+
+		Convey("overwrites existing records", func() {
+			importer.Conflict = ConflictOverwrite
+			result, err := importer.Import(NewReader(strings.NewReader(input), NDJSON, "", nil))
+			So(err, ShouldBeNil)
+			So(result.Imported, ShouldEqual, 3)
+			So(db.RecordMap["note/1"].Data["title"], ShouldEqual, "One")
+			So(db.RecordMap["note/1"].OwnerID, ShouldEqual, "owner")
+		})
+
+		Convey("reports rows which cannot be read", func() {
+			importer.Conflict = ConflictOverwrite
+			importer.MaxErrors = 1
+			result, err := importer.Import(NewReader(strings.NewReader(`not json
+{"_id":"note/3","title":"Three"}
+{"title":"no id"}
+`), NDJSON, "", nil))
+			So(err, ShouldBeNil)
+			So(result.Imported, ShouldEqual, 1)
+			So(result.Failed, ShouldEqual, 2)
+			So(result.Errors, ShouldHaveLength, 1)
+			So(result.Errors[0].Row, ShouldEqual, 1)
+			So(result.Errors[0].Err.Code(), ShouldEqual, skyerr.InvalidArgument)
+			So(result.ErrorsTruncated, ShouldBeTrue)
+		})
+
+		Convey("checks assets against asset policies", func() {
+			dir, err := ioutil.TempDir("", "recordio")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "image.png"), []byte("I am a boy"), 0644), ShouldBeNil)
+
+			conn.AssetMap = map[string]skydb.Asset{
+				"image.png": skydb.Asset{
+					Name:        "image.png",
+					ContentType: "image/png",
+					Size:        10,
+				},
+			}
+			importer.AssetStore = asset.NewFileStore(dir, "", "", true)
+			importer.AssetPolicies = asset.Policies{
+				"note.image": asset.Policy{ContentTypes: []string{"image/*"}},
+			}
+			importer.Conflict = ConflictOverwrite
+			result, err := importer.Import(NewReader(strings.NewReader(`{"_id":"note/3","image":{"$type":"asset","$name":"image.png"}}`), NDJSON, "", nil))
+			So(err, ShouldBeNil)
+			So(result.Imported, ShouldEqual, 0)
+			So(result.Failed, ShouldEqual, 1)
+			So(result.Errors[0].Err.Code(), ShouldEqual, skyerr.AssetPolicyViolated)
+			So(db.RecordMap, ShouldNotContainKey, "note/3")
+		})
+
+		Convey("extends the schema of imported records", func() {
+			importer.Conflict = ConflictOverwrite
+			_, err := importer.Import(NewReader(strings.NewReader(`{"_id":"note/3","order":1}`), NDJSON, "", nil))
+			So(err, ShouldBeNil)
+			So(db.RecordSchemaMap["note"], ShouldContainKey, "order")
+		})
+	})
+}
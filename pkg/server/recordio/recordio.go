@@ -0,0 +1,393 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recordio reads and writes streams of records in NDJSON and CSV.
+//
+// In NDJSON, each line is a record serialized in the same format as the
+// record API. In CSV, the first row is the header, and each column is
+// either a metadata column such as _recordID, or a field of the record.
+// Values which are not strings, numbers or booleans are written as JSON
+// with skyconv literal encoding, such as {"$type":"date","$date":"..."}.
+package recordio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+)
+
+// Format is the serialization format of a record stream.
+type Format string
+
+// List of Format
+const (
+	NDJSON Format = "ndjson"
+	CSV    Format = "csv"
+)
+
+// ParseFormat returns the Format of the specified name. NDJSON is
+// returned if the name is empty.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", NDJSON:
+		return NDJSON, nil
+	case CSV:
+		return CSV, nil
+	default:
+		return "", fmt.Errorf("unknown format %s", name)
+	}
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
+
+// csvMetaColumns are the metadata columns written before the fields of
+// a record in CSV. Only _recordID and _access are read on import.
+var csvMetaColumns = []string{
+	"_recordID",
+	"_ownerID",
+	"_created_at",
+	"_created_by",
+	"_updated_at",
+	"_updated_by",
+	"_access",
+}
+
+// Writer writes records to a stream.
+type Writer interface {
+	Write(record *skydb.Record) error
+
+	// Flush writes any buffered data to the underlying io.Writer.
+	Flush() error
+}
+
+// NewWriter returns a Writer writing records in the format to w. fields
+// is the list of fields written as columns in CSV. It is ignored for
+// NDJSON, in which all fields of a record are written.
+func NewWriter(w io.Writer, format Format, fields []string) Writer {
+	if format == CSV {
+		return &csvWriter{
+			w:      csv.NewWriter(w),
+			fields: fields,
+		}
+	}
+	buffered := bufio.NewWriter(w)
+	return &ndjsonWriter{
+		w:   buffered,
+		enc: json.NewEncoder(buffered),
+	}
+}
+
+type ndjsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(record *skydb.Record) error {
+	// json.Encoder appends a newline after each value.
+	return w.enc.Encode((*skyconv.JSONRecord)(record))
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.w.Flush()
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	fields        []string
+	headerWritten bool
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.headerWritten {
+		return nil
+	}
+	w.headerWritten = true
+	header := append([]string{}, csvMetaColumns...)
+	return w.w.Write(append(header, w.fields...))
+}
+
+func (w *csvWriter) Write(record *skydb.Record) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	row := []string{
+		record.ID.Key,
+		record.OwnerID,
+		formatTime(record.CreatedAt),
+		record.CreatorID,
+		formatTime(record.UpdatedAt),
+		record.UpdaterID,
+	}
+
+	access, err := json.Marshal(record.ACL)
+	if err != nil {
+		return err
+	}
+	row = append(row, string(access))
+
+	for _, field := range w.fields {
+		cell, err := formatCSVValue(record.Data[field])
+		if err != nil {
+			return fmt.Errorf("field %s: %v", field, err)
+		}
+		row = append(row, cell)
+	}
+	return w.w.Write(row)
+}
+
+func (w *csvWriter) Flush() error {
+	// the header is written even if there are no records
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatCSVValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	b, err := json.Marshal(skyconv.ToLiteral(value))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// RowError is an error of a single row of a stream. Reading can continue
+// after a RowError.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+// Reader reads records from a stream.
+type Reader interface {
+	// Read returns the next record, or io.EOF at the end of the stream.
+	// A *RowError is returned for a row which cannot be read.
+	Read() (*skydb.Record, error)
+
+	// Row returns the 1-based index of the row last read, excluding
+	// the header row of CSV.
+	Row() int
+}
+
+// NewReader returns a Reader reading records in the format from r.
+//
+// recordType is the type of records read from CSV, and schema is used
+// to decode the value of each field. It is ignored for NDJSON, in which
+// each record specifies its record type.
+func NewReader(r io.Reader, format Format, recordType string, schema skydb.RecordSchema) Reader {
+	if format == CSV {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		return &csvReader{
+			r:          reader,
+			recordType: recordType,
+			schema:     schema,
+		}
+	}
+	return &ndjsonReader{
+		r: bufio.NewReader(r),
+	}
+}
+
+type ndjsonReader struct {
+	r   *bufio.Reader
+	row int
+}
+
+func (r *ndjsonReader) Read() (*skydb.Record, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			// blank lines are not counted as rows
+			continue
+		}
+
+		r.row++
+		record := skydb.Record{}
+		if err := json.Unmarshal(line, (*skyconv.JSONRecord)(&record)); err != nil {
+			return nil, &RowError{r.row, err}
+		}
+		return &record, nil
+	}
+}
+
+func (r *ndjsonReader) Row() int {
+	return r.row
+}
+
+type csvReader struct {
+	r          *csv.Reader
+	recordType string
+	schema     skydb.RecordSchema
+	header     []string
+	row        int
+}
+
+func (r *csvReader) Read() (*skydb.Record, error) {
+	if r.header == nil {
+		header, err := r.r.Read()
+		if err == io.EOF {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read header: %v", err)
+		}
+		if !containsString(header, "_recordID") {
+			return nil, fmt.Errorf("missing _recordID column in header")
+		}
+		r.header = header
+	}
+
+	cells, err := r.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	r.row++
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return nil, &RowError{r.row, err}
+		}
+		return nil, err
+	}
+	if len(cells) != len(r.header) {
+		return nil, &RowError{r.row, fmt.Errorf("expected %d columns, got %d", len(r.header), len(cells))}
+	}
+
+	m := map[string]interface{}{
+		"_recordType": r.recordType,
+	}
+	for i, column := range r.header {
+		value, ok, err := r.parseCell(column, cells[i])
+		if err != nil {
+			return nil, &RowError{r.row, fmt.Errorf("column %s: %v", column, err)}
+		}
+		if ok {
+			m[column] = value
+		}
+	}
+
+	record := skydb.Record{}
+	if err := (*skyconv.JSONRecord)(&record).FromMap(m); err != nil {
+		return nil, &RowError{r.row, err}
+	}
+	return &record, nil
+}
+
+func (r *csvReader) Row() int {
+	return r.row
+}
+
+// parseCell returns the value of a cell in the form of decoded JSON.
+// ok is false if the column is not read.
+func (r *csvReader) parseCell(column string, cell string) (value interface{}, ok bool, err error) {
+	if cell == "" {
+		return nil, false, nil
+	}
+
+	switch column {
+	case "_recordID":
+		return cell, true, nil
+	case "_access":
+		err = json.Unmarshal([]byte(cell), &value)
+		return value, true, err
+	}
+	if strings.HasPrefix(column, "_") {
+		// other metadata is maintained by the server
+		return nil, false, nil
+	}
+
+	fieldType, typed := r.schema[column]
+	if !typed {
+		// guess the type for fields not in the schema
+		if json.Unmarshal([]byte(cell), &value) != nil {
+			value = cell
+		}
+		return value, true, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeString:
+		value = cell
+	case skydb.TypeNumber, skydb.TypeInteger, skydb.TypeSequence:
+		value, err = strconv.ParseFloat(cell, 64)
+	case skydb.TypeBoolean:
+		value, err = strconv.ParseBool(cell)
+	case skydb.TypeDateTime:
+		if strings.HasPrefix(cell, "{") {
+			err = json.Unmarshal([]byte(cell), &value)
+		} else {
+			value = map[string]interface{}{
+				"$type": "date",
+				"$date": cell,
+			}
+		}
+	default:
+		err = json.Unmarshal([]byte(cell), &value)
+	}
+	return value, true, err
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func readAll(reader Reader) ([]*skydb.Record, []error) {
+	records := []*skydb.Record{}
+	errs := []error{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, errs
+		} else if err != nil {
+			errs = append(errs, err)
+			if _, ok := err.(*RowError); !ok {
+				return records, errs
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	Convey("NDJSON", t, func() {
+		createdAt := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		record := skydb.Record{
+			ID:        skydb.NewRecordID("note", "1"),
+			OwnerID:   "owner",
+			CreatedAt: createdAt,
+			Data: skydb.Data{
+				"title":    "Hello",
+				"order":    float64(1),
+				"date":     createdAt,
+				"location": skydb.NewLocation(1, 2),
+				"category": skydb.NewReference("category", "a"),
+			},
+		}
+
+		Convey("writes a record per line with literal encoding", func() {
+			buf := bytes.Buffer{}
+			writer := NewWriter(&buf, NDJSON, nil)
+			So(writer.Write(&record), ShouldBeNil)
+			So(writer.Write(&record), ShouldBeNil)
+			So(writer.Flush(), ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			So(lines, ShouldHaveLength, 2)
+			So(lines[0], ShouldContainSubstring, `"date":{"$date":"2017-01-02T03:04:05Z","$type":"date"}`)
+			So(lines[0], ShouldContainSubstring, `"category":{"$id":"category/a","$recordID":"a","$recordType":"category","$type":"ref"}`)
+			So(lines[0], ShouldContainSubstring, `"location":{"$lat":2,"$lng":1,"$type":"geo"}`)
+		})
+
+		Convey("reads the written records", func() {
+			buf := bytes.Buffer{}
+			writer := NewWriter(&buf, NDJSON, nil)
+			So(writer.Write(&record), ShouldBeNil)
+			So(writer.Flush(), ShouldBeNil)
+
+			records, errs := readAll(NewReader(&buf, NDJSON, "", nil))
+			So(errs, ShouldBeEmpty)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].ID, ShouldResemble, record.ID)
+			So(records[0].Data, ShouldResemble, record.Data)
+		})
+
+		Convey("reports malformed rows and continues", func() {
+			reader := NewReader(strings.NewReader(`{"_id":"note/1"}
+
+not json
+{"_id":"note/2"}`), NDJSON, "", nil)
+			records, errs := readAll(reader)
+			So(records, ShouldHaveLength, 2)
+			So(records[1].ID, ShouldResemble, skydb.NewRecordID("note", "2"))
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].(*RowError).Row, ShouldEqual, 2)
+			So(reader.Row(), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestCSV(t *testing.T) {
+	Convey("CSV", t, func() {
+		createdAt := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		schema := skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"order":    skydb.FieldType{Type: skydb.TypeNumber},
+			"done":     skydb.FieldType{Type: skydb.TypeBoolean},
+			"date":     skydb.FieldType{Type: skydb.TypeDateTime},
+			"category": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "category"},
+		}
+		record := skydb.Record{
+			ID:        skydb.NewRecordID("note", "1"),
+			OwnerID:   "owner",
+			CreatedAt: createdAt,
+			ACL: skydb.RecordACL{
+				skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+			},
+			Data: skydb.Data{
+				"title":    "Hello, world",
+				"order":    float64(1.5),
+				"done":     true,
+				"date":     createdAt,
+				"category": skydb.NewReference("category", "a"),
+			},
+		}
+		fields := []string{"title", "order", "done", "date", "category"}
+
+		Convey("writes a header and a row per record", func() {
+			buf := bytes.Buffer{}
+			writer := NewWriter(&buf, CSV, fields)
+			So(writer.Write(&record), ShouldBeNil)
+			So(writer.Flush(), ShouldBeNil)
+
+			So(buf.String(), ShouldEqual, `_recordID,_ownerID,_created_at,_created_by,_updated_at,_updated_by,_access,title,order,done,date,category
+1,owner,2017-01-02T03:04:05Z,,,,"[{""level"":""read"",""public"":true}]","Hello, world",1.5,true,"{""$date"":""2017-01-02T03:04:05Z"",""$type"":""date""}","{""$id"":""category/a"",""$recordID"":""a"",""$recordType"":""category"",""$type"":""ref""}"
+`)
+		})
+
+		Convey("writes a header without records", func() {
+			buf := bytes.Buffer{}
+			writer := NewWriter(&buf, CSV, []string{"title"})
+			So(writer.Flush(), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "_recordID,_ownerID,_created_at,_created_by,_updated_at,_updated_by,_access,title\n")
+		})
+
+		Convey("reads the written records", func() {
+			buf := bytes.Buffer{}
+			writer := NewWriter(&buf, CSV, fields)
+			So(writer.Write(&record), ShouldBeNil)
+			So(writer.Flush(), ShouldBeNil)
+
+			records, errs := readAll(NewReader(&buf, CSV, "note", schema))
+			So(errs, ShouldBeEmpty)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].ID, ShouldResemble, record.ID)
+			So(records[0].ACL, ShouldResemble, record.ACL)
+			So(records[0].Data, ShouldResemble, record.Data)
+		})
+
+		Convey("reads plain dates and skips empty cells", func() {
+			reader := NewReader(strings.NewReader(`_recordID,title,date,order,extra
+1,,2017-01-02T03:04:05Z,,"{""a"":1}"
+`), CSV, "note", schema)
+			records, errs := readAll(reader)
+			So(errs, ShouldBeEmpty)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Data, ShouldResemble, skydb.Data{
+				"date":  createdAt,
+				"extra": map[string]interface{}{"a": float64(1)},
+			})
+		})
+
+		Convey("reports malformed rows and continues", func() {
+			reader := NewReader(strings.NewReader(`_recordID,order
+1,abc
+2
+3,3
+`), CSV, "note", schema)
+			records, errs := readAll(reader)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].Data, ShouldResemble, skydb.Data{"order": float64(3)})
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].(*RowError).Row, ShouldEqual, 1)
+			So(errs[1].(*RowError).Row, ShouldEqual, 2)
+		})
+
+		Convey("requires _recordID in header", func() {
+			_, err := NewReader(strings.NewReader("title\nHello\n"), CSV, "note", schema).Read()
+			So(err, ShouldNotBeNil)
+			_, isRowError := err.(*RowError)
+			So(isRowError, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordutil
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// EagerRecords holds the records included in the result of a query.
+type EagerRecords struct {
+	// KeyPaths maps each included keypath, and each prefix of it, to the
+	// records at the keypath by record key. For the keypath
+	// post.author, records are kept for both post and post.author.
+	KeyPaths map[string]map[string]*skydb.Record
+
+	// Reverses maps the name of each reverse include to the referencing
+	// records by the key of the referenced record.
+	Reverses map[string]map[string][]*skydb.Record
+}
+
+// DoQueryEager loads the records included by the keypaths in the
+// ComputedKeys and by the ReverseIncludes of query, for records returned
+// by query.
+//
+// Keypaths are loaded one level at a time, with a single fetch for each
+// keypath prefix, and each reverse include is loaded with a single
+// query, so the number of database calls does not grow with the number
+// of records.
+//
+// Records not accessible with accessControlOptions are not loaded, and a
+// reference is not followed if the field is not readable according to
+// the field ACL of filter.
+func DoQueryEager(ctx context.Context, db skydb.Database, records []skydb.Record, query skydb.Query, accessControlOptions *skydb.AccessControlOptions, filter RecordResultFilter) EagerRecords {
+	eagerRecords := EagerRecords{
+		KeyPaths: map[string]map[string]*skydb.Record{},
+		Reverses: map[string]map[string][]*skydb.Record{},
+	}
+	logger := logging.CreateLogger(ctx, "handler")
+
+	for _, keyPaths := range eagerKeyPathLevels(query) {
+		for _, keyPath := range keyPaths {
+			logger.Debugf("Getting value for keypath %v", keyPath)
+			ids := eagerRecords.referencedIDs(db, records, keyPath, filter)
+			eagerRecords.KeyPaths[keyPath] = map[string]*skydb.Record{}
+			if len(ids) == 0 {
+				continue
+			}
+
+			eagerScanner, err := db.GetByIDs(ids, accessControlOptions)
+			if err != nil {
+				logger.Debugf("No Records found in the eager load key path: %s", keyPath)
+				continue
+			}
+			for eagerScanner.Scan() {
+				er := eagerScanner.Record()
+				eagerRecords.KeyPaths[keyPath][er.ID.Key] = &er
+			}
+			eagerScanner.Close()
+		}
+	}
+
+	for name, include := range query.ReverseIncludes {
+		logger.Debugf("Getting %s records referencing %s for %v", include.Type, query.Type, name)
+		reverses, err := queryReverseInclude(db, records, query.Type, include, accessControlOptions, filter)
+		if err != nil {
+			logger.WithError(err).Debugf("Failed to query reverse include: %s", name)
+			reverses = map[string][]*skydb.Record{}
+		}
+		eagerRecords.Reverses[name] = reverses
+	}
+
+	return eagerRecords
+}
+
+// eagerKeyPathLevels returns the included keypaths and their prefixes
+// grouped by the number of components, such that the records of a
+// keypath are loaded after the records of its prefixes.
+func eagerKeyPathLevels(query skydb.Query) [][]string {
+	levels := [][]string{}
+	seen := map[string]bool{}
+	for _, transientExpression := range query.ComputedKeys {
+		if transientExpression.Type != skydb.KeyPath {
+			continue
+		}
+
+		components := strings.Split(transientExpression.Value.(string), ".")
+		for i := range components {
+			prefix := strings.Join(components[:i+1], ".")
+			if seen[prefix] {
+				continue
+			}
+			seen[prefix] = true
+			for len(levels) <= i {
+				levels = append(levels, []string{})
+			}
+			levels[i] = append(levels[i], prefix)
+		}
+	}
+
+	for _, keyPaths := range levels {
+		sort.Strings(keyPaths)
+	}
+	return levels
+}
+
+// referencedIDs returns the IDs of the records referenced at keyPath
+// by the records at the parent keypath, which must be loaded already.
+func (e *EagerRecords) referencedIDs(db skydb.Database, records []skydb.Record, keyPath string, filter RecordResultFilter) []skydb.RecordID {
+	parents := []*skydb.Record{}
+	field := keyPath
+	if i := strings.LastIndex(keyPath, "."); i >= 0 {
+		field = keyPath[i+1:]
+		for _, record := range e.KeyPaths[keyPath[:i]] {
+			parents = append(parents, record)
+		}
+	} else {
+		for i := range records {
+			parents = append(parents, &records[i])
+		}
+	}
+
+	ids := []skydb.RecordID{}
+	seen := map[skydb.RecordID]bool{}
+	for _, parent := range parents {
+		if !filter.readable(parent, field) {
+			continue
+		}
+		ref := getReferenceWithKeyPath(db, parent, field)
+		if ref.IsEmpty() || seen[ref.ID] {
+			continue
+		}
+		seen[ref.ID] = true
+		ids = append(ids, ref.ID)
+	}
+	return ids
+}
+
+// recordAtKeyPath returns the loaded record at keyPath of record, or nil
+// if any record on the keypath is not loaded or not readable.
+func (e *EagerRecords) recordAtKeyPath(db skydb.Database, record *skydb.Record, keyPath string, filter RecordResultFilter) *skydb.Record {
+	current := record
+	components := strings.Split(keyPath, ".")
+	for i, component := range components {
+		if !filter.readable(current, component) {
+			return nil
+		}
+		ref := getReferenceWithKeyPath(db, current, component)
+		if ref.IsEmpty() {
+			return nil
+		}
+		current = e.KeyPaths[strings.Join(components[:i+1], ".")][ref.ID.Key]
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// queryReverseInclude returns the records of include referencing the
+// records, by the key of the referenced record.
+//
+// All referencing records are queried at once in the order of the sorts
+// of include, with the limit of include applied to each referenced record
+// by the partition limit of the query.
+func queryReverseInclude(db skydb.Database, records []skydb.Record, recordType string, include skydb.ReverseInclude, accessControlOptions *skydb.AccessControlOptions, filter RecordResultFilter) (map[string][]*skydb.Record, error) {
+	reverses := map[string][]*skydb.Record{}
+	if len(records) == 0 {
+		return reverses, nil
+	}
+
+	refs := make([]interface{}, len(records))
+	for i, record := range records {
+		refs[i] = skydb.NewReference(recordType, record.ID.Key)
+	}
+
+	query := skydb.Query{
+		Type: include.Type,
+		Predicate: skydb.Predicate{
+			Operator: skydb.In,
+			Children: []interface{}{
+				skydb.Expression{
+					Type:  skydb.KeyPath,
+					Value: include.Field,
+				},
+				skydb.Expression{
+					Type:  skydb.Literal,
+					Value: refs,
+				},
+			},
+		},
+		Sorts: include.Sorts,
+	}
+	if include.Limit != nil {
+		query.PartitionLimit = &skydb.PartitionLimit{
+			Field: include.Field,
+			Limit: *include.Limit,
+		}
+	}
+	results, err := db.Query(&query, accessControlOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	for results.Scan() {
+		record := results.Record()
+		if !filter.readable(&record, include.Field) {
+			continue
+		}
+
+		ref, ok := record.Data[include.Field].(skydb.Reference)
+		if !ok || ref.ID.Type != recordType {
+			continue
+		}
+
+		reverses[ref.ID.Key] = append(reverses[ref.ID.Key], &record)
+	}
+	return reverses, results.Err()
+}
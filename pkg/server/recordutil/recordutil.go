@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -72,6 +74,7 @@ type RecordModifyRequest struct {
 	Db            skydb.Database
 	Conn          skydb.Conn
 	AssetStore    asset.Store
+	AssetPolicies asset.Policies
 	HookRegistry  *hook.Registry
 	Atomic        bool
 	WithMasterKey bool
@@ -81,15 +84,24 @@ type RecordModifyRequest struct {
 
 	// Save only
 	RecordsToSave []*skydb.Record
+	Preconditions map[skydb.RecordID]RecordPrecondition
 
 	// Delete Only
 	RecordIDsToDelete []skydb.RecordID
+
+	// Undelete Only
+	RecordIDsToUndelete []skydb.RecordID
 }
 
 type RecordModifyResponse struct {
 	ErrMap           map[skydb.RecordID]skyerr.Error
 	SavedRecords     []*skydb.Record
 	DeletedRecordIDs []skydb.RecordID
+	UndeletedRecords []*skydb.Record
+
+	// CascadedRecords are the records deleted or updated by the on_delete
+	// constraints of reference fields, keyed by the deleted record.
+	CascadedRecords map[skydb.RecordID][]CascadedRecord
 }
 
 type RecordFetcher struct {
@@ -168,6 +180,13 @@ func (f RecordFetcher) FetchRecord(recordID skydb.RecordID, authInfo *skydb.Auth
 	return
 }
 
+// FetchOrCreateRecord fetches the record to be saved, or returns an empty
+// record if the record is to be created.
+//
+// If soft delete of the record type is enabled, a soft-deleted record is
+// not created again, as the record would be modified without checking its
+// access control. The record should be restored by record:undelete
+// instead.
 func (f RecordFetcher) FetchOrCreateRecord(recordID skydb.RecordID, authInfo *skydb.AuthInfo) (record skydb.Record, created bool, err skyerr.Error) {
 	fetchedRecord, err := f.FetchRecord(recordID, authInfo, skydb.WriteLevel)
 	if err == nil {
@@ -176,6 +195,19 @@ func (f RecordFetcher) FetchOrCreateRecord(recordID skydb.RecordID, authInfo *sk
 	}
 
 	if err.Code() == skyerr.ResourceNotFound {
+		deletedRecord, dbErr := f.fetchDeletedRecordIfSoftDelete(recordID)
+		if dbErr != nil {
+			err = skyerr.MakeError(dbErr)
+			return
+		}
+		if deletedRecord != nil {
+			err = skyerr.NewError(
+				skyerr.RecordConflict,
+				"record has been deleted",
+			)
+			return
+		}
+
 		allowCreation := func() bool {
 			if f.withMasterKey {
 				return true
@@ -201,6 +233,52 @@ func (f RecordFetcher) FetchOrCreateRecord(recordID skydb.RecordID, authInfo *sk
 	return
 }
 
+// fetchDeletedRecordIfSoftDelete fetches the soft-deleted record
+// identified by recordID if soft delete of the record type is enabled.
+func (f RecordFetcher) fetchDeletedRecordIfSoftDelete(recordID skydb.RecordID) (*skydb.Record, error) {
+	typemap, err := f.db.RemoteColumnTypes(recordID.Type)
+	if err != nil {
+		return nil, err
+	}
+	if !typemap.SoftDeleteEnabled() {
+		return nil, nil
+	}
+	return f.fetchDeletedRecord(recordID)
+}
+
+// fetchDeletedRecord fetches the soft-deleted record identified by
+// recordID regardless of access control. A nil record is returned if the
+// record does not exist or is not deleted.
+func (f RecordFetcher) fetchDeletedRecord(recordID skydb.RecordID) (*skydb.Record, error) {
+	query := skydb.Query{
+		Type: recordID.Type,
+		Predicate: skydb.Predicate{
+			Operator: skydb.Equal,
+			Children: []interface{}{
+				skydb.Expression{Type: skydb.KeyPath, Value: "_id"},
+				skydb.Expression{Type: skydb.Literal, Value: recordID.Key},
+			},
+		},
+		IncludeDeleted: true,
+	}
+	rows, err := f.db.Query(&query, &skydb.AccessControlOptions{
+		BypassAccessControl: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletedRecord *skydb.Record
+	for rows.Scan() {
+		record := rows.Record()
+		if !record.DeletedAt.IsZero() {
+			deletedRecord = &record
+		}
+	}
+	return deletedRecord, rows.Err()
+}
+
 func removeRecordFieldTypeHints(r *skydb.Record) {
 	for k, v := range r.Data {
 		switch v.(type) {
@@ -214,10 +292,14 @@ func removeRecordFieldTypeHints(r *skydb.Record) {
 
 // RecordSaveHandler iterate the record to perform the following:
 // 1. Query the db for original record
-// 2. Execute before save hooks with original record and new record
-// 3. Clean up some transport only data (sequence for example) away from record
-// 4. Populate meta data and save the record (like updated_at/by)
-// 5. Execute after save hooks with original record and new record
+// 2. Apply default values and check the record against field constraints
+// 3. Check assets saved to the record against asset policies
+// 4. Execute before save hooks with original record and new record
+// 5. Clean up some transport only data (sequence for example) away from record
+// 6. Populate meta data and save the record (like updated_at/by), together
+//    with a revision if history is enabled for the record type. The
+//    precondition of the record is checked again with the record locked
+// 7. Execute after save hooks with original record and new record
 func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) skyerr.Error {
 	db := req.Db
 	records := req.RecordsToSave
@@ -228,14 +310,44 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		return skyerr.MakeError(err)
 	}
 
+	historyTypes, err := getRecordHistoryTypes(req.Conn)
+	if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	schemas := map[string]skydb.RecordSchema{}
+	getSchema := func(recordType string) (skydb.RecordSchema, skyerr.Error) {
+		schema, ok := schemas[recordType]
+		if !ok {
+			var err error
+			if schema, err = db.GetSchema(recordType); err != nil {
+				return nil, skyerr.MakeError(err)
+			}
+			schemas[recordType] = schema
+		}
+		return schema, nil
+	}
+
 	// fetch records
 	originalRecordMap := map[skydb.RecordID]*skydb.Record{}
+	fieldOperationMap := map[skydb.RecordID]map[string]pendingFieldOperation{}
 	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
 		dbRecord, created, err := fetcher.FetchOrCreateRecord(record.ID, req.AuthInfo)
 		if err != nil {
 			return err
 		}
 
+		if precondition, ok := req.Preconditions[record.ID]; ok {
+			var existingRecord *skydb.Record
+			if !created {
+				existingRecord = &dbRecord
+			}
+			err = checkRecordPrecondition(req, record.ID, existingRecord, precondition, historyTypes)
+			if err != nil {
+				return err
+			}
+		}
+
 		now := req.ModifyAt
 		if created {
 			dbRecord.ID = record.ID
@@ -265,11 +377,30 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 			originalRecordMap[origRecord.ID] = &origRecord
 		}
 
+		var fieldOperations map[string]pendingFieldOperation
+		if hasFieldOperations(record) {
+			schema, err := getSchema(record.ID.Type)
+			if err != nil {
+				return err
+			}
+			if fieldOperations, err = extractFieldOperations(schema, record, &dbRecord); err != nil {
+				return err
+			}
+		}
+
 		dbRecord.Apply(record)
 		*record = dbRecord
 		record.UpdatedAt = now
 		record.UpdaterID = req.AuthInfo.ID
 
+		for key, pending := range fieldOperations {
+			pending.predicted = record.Data[key]
+			fieldOperations[key] = pending
+		}
+		if len(fieldOperations) > 0 {
+			fieldOperationMap[record.ID] = fieldOperations
+		}
+
 		return
 	})
 
@@ -282,6 +413,23 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		return nil
 	})
 
+	// Apply default values and check field constraints
+	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) skyerr.Error {
+		schema, err := getSchema(record.ID.Type)
+		if err != nil {
+			return err
+		}
+
+		_, updating := originalRecordMap[record.ID]
+		return checkFieldConstraints(schema, record, !updating)
+	})
+
+	if len(req.AssetPolicies) > 0 {
+		records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) skyerr.Error {
+			return checkAssetPolicies(req.Conn, req.AssetStore, req.AssetPolicies, record, originalRecordMap[record.ID])
+		})
+	}
+
 	makeAssetsCompleteAndInjectSigner(db, req.Conn, records, req.AssetStore)
 
 	// execute before save hooks
@@ -301,8 +449,48 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 		originalRecord, _ := originalRecordMap[record.ID]
 		DeriveDeltaRecord(&deltaRecord, originalRecord, record)
 
-		if dbErr := db.Save(&deltaRecord); dbErr != nil {
-			err = skyerr.MakeError(dbErr)
+		// Let the database apply the field operations atomically, unless
+		// the value is changed by before save hooks.
+		for key, pending := range fieldOperationMap[record.ID] {
+			if reflect.DeepEqual(record.Data[key], pending.predicted) {
+				deltaRecord.Data[key] = pending.op
+			}
+		}
+
+		precondition, hasPrecondition := req.Preconditions[record.ID]
+		save := func() skyerr.Error {
+			if hasPrecondition {
+				// the precondition is checked again with the record
+				// locked, as the record may be modified after it is
+				// fetched
+				var lockedRecord *skydb.Record
+				dbRecord := skydb.Record{}
+				if dbErr := db.GetForUpdate(record.ID, &dbRecord); dbErr == nil {
+					lockedRecord = &dbRecord
+				} else if dbErr != skydb.ErrRecordNotFound {
+					return skyerr.MakeError(dbErr)
+				}
+				if err := checkRecordPrecondition(req, record.ID, lockedRecord, precondition, historyTypes); err != nil {
+					return err
+				}
+			}
+
+			if dbErr := db.Save(&deltaRecord); dbErr != nil {
+				return skyerr.MakeError(dbErr)
+			}
+			if historyTypes[record.ID.Type] {
+				savedRecord := record.MergedCopy(&deltaRecord)
+				return saveRecordRevision(req.Conn, db, skydb.RecordRevisionSave, &savedRecord, originalRecord, req.AuthInfo)
+			}
+			return nil
+		}
+
+		// the revision is saved in the transaction of the record, so
+		// that its version is allocated under the lock of the record
+		if hasPrecondition || historyTypes[record.ID.Type] {
+			err = withRecordTransaction(db, save)
+		} else {
+			err = save()
 		}
 		*record = deltaRecord
 
@@ -326,6 +514,326 @@ func RecordSaveHandler(req *RecordModifyRequest, resp *RecordModifyResponse) sky
 	return nil
 }
 
+// pendingFieldOperation is a field operation to be applied by the
+// database, together with the value of the field predicted by applying
+// the operation on the fetched record.
+type pendingFieldOperation struct {
+	op        skydb.FieldOperation
+	predicted interface{}
+}
+
+// extractFieldOperations returns the field operations in the data of
+// record, keyed by field name. An error is returned if an operation
+// cannot be applied on the field of dbRecord, whose type is the type of
+// its current value, or else the type in schema.
+func extractFieldOperations(schema skydb.RecordSchema, record *skydb.Record, dbRecord *skydb.Record) (map[string]pendingFieldOperation, skyerr.Error) {
+	fieldOperations := map[string]pendingFieldOperation{}
+	for key, value := range record.Data {
+		op, ok := value.(skydb.FieldOperation)
+		if !ok {
+			continue
+		}
+
+		fieldType, ok := schema[key]
+		if current := dbRecord.Data[key]; current != nil {
+			if derived, err := skydb.DeriveFieldType(current); err == nil {
+				fieldType, ok = derived, true
+			}
+		}
+		if ok {
+			if err := op.CheckFieldType(key, fieldType); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := op.Apply(dbRecord.Data[key]); err != nil {
+			return nil, skyerr.NewInvalidArgument(
+				fmt.Sprintf("cannot apply field operation on %s: %v", key, err),
+				[]string{key},
+			)
+		}
+		fieldOperations[key] = pendingFieldOperation{op: op}
+	}
+	return fieldOperations, nil
+}
+
+func hasFieldOperations(record *skydb.Record) bool {
+	for _, value := range record.Data {
+		if _, ok := value.(skydb.FieldOperation); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func getRecordHistoryTypes(conn skydb.Conn) (map[string]bool, error) {
+	recordTypes, err := conn.GetRecordHistoryTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	historyTypes := map[string]bool{}
+	for _, recordType := range recordTypes {
+		historyTypes[recordType] = true
+	}
+	return historyTypes, nil
+}
+
+// withRecordTransaction runs do in a transaction of db, so that the
+// writes of a record, such as the record and its revision, are committed
+// together. If a transaction is already in progress, such as that of an
+// atomic operation, do runs in it. If db does not support transaction,
+// do runs without one.
+func withRecordTransaction(db skydb.Database, do func() skyerr.Error) skyerr.Error {
+	txDB, ok := db.(skydb.Transactional)
+	if !ok {
+		return do()
+	}
+
+	if err := txDB.Begin(); err == skydb.ErrDatabaseTxDidBegin {
+		return do()
+	} else if err != nil {
+		return skyerr.MakeError(err)
+	}
+
+	if err := do(); err != nil {
+		if rbErr := txDB.Rollback(); rbErr != nil {
+			logrus.Errorf("Failed to rollback: %v", rbErr)
+		}
+		return err
+	}
+	if err := txDB.Commit(); err != nil {
+		return skyerr.MakeError(err)
+	}
+	return nil
+}
+
+// RecordPrecondition is the state of a record expected by the client. The
+// record is saved only if the record on the server matches it.
+type RecordPrecondition struct {
+	// UpdatedAt is the expected _updated_at of the record. It is not
+	// checked if zero.
+	UpdatedAt time.Time
+
+	// Revision is the expected version of the latest revision in the
+	// record history, zero if the record has no revisions. It is not
+	// checked if nil.
+	Revision *int
+}
+
+// checkRecordPrecondition returns a RecordConflict error if the record on
+// the server does not match the precondition. dbRecord is nil if the record
+// does not exist.
+func checkRecordPrecondition(req *RecordModifyRequest, recordID skydb.RecordID, dbRecord *skydb.Record, precondition RecordPrecondition, historyTypes map[string]bool) skyerr.Error {
+	conflicted := false
+	if !precondition.UpdatedAt.IsZero() {
+		conflicted = dbRecord == nil || !dbRecord.UpdatedAt.Equal(precondition.UpdatedAt)
+	}
+
+	revision := 0
+	if precondition.Revision != nil {
+		if !historyTypes[recordID.Type] {
+			return skyerr.NewInvalidArgument(
+				fmt.Sprintf("record history of %s is not enabled", recordID.Type),
+				[]string{"_expected_revision"},
+			)
+		}
+
+		revisions, err := req.Conn.GetRecordRevisions(req.Db.ID(), recordID)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		if len(revisions) > 0 {
+			revision = revisions[0].Version
+		}
+		conflicted = conflicted || revision != *precondition.Revision
+	}
+
+	if !conflicted {
+		return nil
+	}
+
+	info := map[string]interface{}{
+		"record": nil,
+	}
+	if precondition.Revision != nil {
+		info["revision"] = revision
+	}
+	if dbRecord != nil {
+		resultFilter, err := NewRecordResultFilter(req.Conn, req.AssetStore, req.AuthInfo, req.WithMasterKey)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		info["record"] = resultFilter.JSONResult(dbRecord)
+	}
+
+	return skyerr.NewErrorWithInfo(
+		skyerr.RecordConflict,
+		"record has been modified since it was fetched",
+		info,
+	)
+}
+
+// saveRecordRevision appends a revision of record to its history. For
+// a delete revision, record is the record before it is deleted.
+func saveRecordRevision(conn skydb.Conn, db skydb.Database, action skydb.RecordRevisionAction, record *skydb.Record, origRecord *skydb.Record, authInfo *skydb.AuthInfo) skyerr.Error {
+	var oldData, newData skydb.Data
+	if origRecord != nil {
+		oldData = origRecord.Data
+	}
+	if action == skydb.RecordRevisionSave {
+		newData = record.Data
+	}
+
+	revision := skydb.RecordRevision{
+		RecordID:   record.ID,
+		DatabaseID: db.ID(),
+		Action:     action,
+		Record:     record.Copy(),
+		Diff:       skydb.DiffRecordData(oldData, newData),
+	}
+	if authInfo != nil {
+		revision.ActorID = authInfo.ID
+	}
+
+	if err := conn.SaveRecordRevision(&revision); err != nil {
+		return skyerr.MakeError(err)
+	}
+	return nil
+}
+
+// checkFieldConstraints applies the default values of fields to a
+// record being created, and checks the fields of the record against their
+// constraints. Unique constraints are left to the database.
+//
+// The value of a field operation is predicted from the fetched record, so
+// the check is only advisory for fields saved with $inc or $max, which
+// may be changed concurrently. Such values are checked again when the
+// record is saved: by the CHECK constraint of the field in PostgreSQL,
+// and by the driver within the locked save in the other drivers.
+func checkFieldConstraints(schema skydb.RecordSchema, record *skydb.Record, created bool) skyerr.Error {
+	fields := []string{}
+	for field, fieldType := range schema {
+		if fieldType.Constraints != nil {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		constraints := schema[field].Constraints
+		if created && constraints.Default != nil {
+			if _, ok := record.Data[field]; !ok {
+				record.Set(field, constraints.Default)
+			}
+		}
+
+		value := record.Get(field)
+		switch value.(type) {
+		case skydb.Sequence, skydb.Unknown:
+			// type hints are not values
+			continue
+		}
+
+		if err := constraints.Validate(value); err != nil {
+			return NewConstraintViolationError(field, err)
+		}
+	}
+	return nil
+}
+
+// NewConstraintViolationError returns a ConstraintViolated error if err is
+// a skydb.ConstraintViolation on the specified field.
+func NewConstraintViolationError(field string, err error) skyerr.Error {
+	violation, ok := err.(*skydb.ConstraintViolation)
+	if !ok {
+		return skyerr.MakeError(err)
+	}
+
+	return skyerr.NewErrorWithInfo(
+		skyerr.ConstraintViolated,
+		fmt.Sprintf("%s: %s", field, violation.Message),
+		map[string]interface{}{
+			"field":      field,
+			"constraint": violation.Constraint,
+		},
+	)
+}
+
+// checkAssetPolicies checks the assets newly saved to the fields of a
+// record against the asset policies of the fields.
+func checkAssetPolicies(conn skydb.Conn, store asset.Store, policies asset.Policies, record *skydb.Record, origRecord *skydb.Record) skyerr.Error {
+	for field, value := range record.Data {
+		recordAsset, ok := value.(*skydb.Asset)
+		if !ok {
+			continue
+		}
+
+		policy, ok := policies.Get(record.ID.Type, field)
+		if !ok {
+			continue
+		}
+
+		if origRecord != nil {
+			if origAsset, ok := origRecord.Get(field).(*skydb.Asset); ok && origAsset.Name == recordAsset.Name {
+				continue
+			}
+		}
+
+		assets, err := conn.GetAssets([]string{recordAsset.Name})
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+		if len(assets) == 0 {
+			return skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("asset %s not found", recordAsset.Name))
+		}
+
+		if err := CheckAssetPolicy(store, policy, &assets[0]); err != nil {
+			return NewAssetPolicyError(field, err)
+		}
+	}
+	return nil
+}
+
+// CheckAssetPolicy checks the content of a saved asset against the policy.
+//
+// The asset is rejected if its content cannot be read from the store,
+// such as from the cloud store, because the declared content type and size
+// of an asset are not verified.
+func CheckAssetPolicy(store asset.Store, policy asset.Policy, savedAsset *skydb.Asset) error {
+	reader, err := store.GetFileReader(savedAsset.Name)
+	if err != nil {
+		return &asset.PolicyViolation{
+			Message: "Content of the asset cannot be verified against the asset policy",
+			Info: map[string]interface{}{
+				"name": savedAsset.Name,
+			},
+		}
+	}
+	defer reader.Close()
+
+	_, err = policy.Check(reader, savedAsset.Size)
+	return err
+}
+
+// NewAssetPolicyError returns an AssetPolicyViolated error if err is an
+// asset.PolicyViolation on the specified field.
+func NewAssetPolicyError(field string, err error) skyerr.Error {
+	violation, ok := err.(*asset.PolicyViolation)
+	if !ok {
+		return skyerr.MakeError(err)
+	}
+
+	info := map[string]interface{}{}
+	for key, value := range violation.Info {
+		info[key] = value
+	}
+	if field != "" {
+		info["field"] = field
+	}
+	return skyerr.NewErrorWithInfo(skyerr.AssetPolicyViolated, violation.Message, info)
+}
+
 type saveHookTriggerer struct {
 	Context           context.Context
 	HookRegistry      *hook.Registry
@@ -451,6 +959,10 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 	recordIDs := req.RecordIDsToDelete
 
 	fetcher := NewRecordFetcher(req.Context, db, req.Conn, req.WithMasterKey)
+	historyTypes, historyErr := getRecordHistoryTypes(req.Conn)
+	if historyErr != nil {
+		return skyerr.MakeError(historyErr)
+	}
 
 	var records []*skydb.Record
 	for _, recordID := range recordIDs {
@@ -467,19 +979,79 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 		records = append(records, record)
 	}
 
+	// find the records to be deleted or updated with the records by the
+	// on_delete constraints of reference fields
+	plans := map[skydb.RecordID]*cascadePlan{}
+	if len(records) > 0 {
+		rules, err := getReferenceRules(db)
+		if err != nil {
+			return skyerr.MakeError(err)
+		}
+
+		if len(rules) > 0 {
+			resolver := referenceResolver{req, rules}
+			records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) skyerr.Error {
+				plan, err := resolver.resolve(record)
+				if err != nil {
+					return err
+				}
+				plans[record.ID] = plan
+				return nil
+			})
+		}
+	}
+
 	if req.HookRegistry != nil {
 		records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
+			if plan, ok := plans[record.ID]; ok {
+				if err = plan.executeBeforeHooks(req); err != nil {
+					return
+				}
+			}
 			err = req.HookRegistry.ExecuteHooks(req.Context, hook.BeforeDelete, record, nil)
 			return
 		})
 	}
 
+	deleted := map[skydb.RecordID]bool{}
 	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
+		if deleted[record.ID] {
+			// deleted by the plan of another record
+			return nil
+		}
+
+		// records deleted with the record are added to deleted only if
+		// the deletion is committed
+		deleting := map[skydb.RecordID]bool{}
+		plan, hasPlan := plans[record.ID]
+		del := func() skyerr.Error {
+			if hasPlan {
+				if err := plan.execute(req, historyTypes, deleted, deleting); err != nil {
+					return err
+				}
+			}
 
-		if dbErr := db.Delete(record.ID); dbErr != nil {
-			return skyerr.MakeError(dbErr)
+			if dbErr := db.Delete(record.ID); dbErr != nil {
+				return skyerr.MakeError(dbErr)
+			}
+			deleting[record.ID] = true
+			if historyTypes[record.ID.Type] {
+				return saveRecordRevision(req.Conn, db, skydb.RecordRevisionDelete, record, record, req.AuthInfo)
+			}
+			return nil
 		}
-		return nil
+
+		if hasPlan || historyTypes[record.ID.Type] {
+			err = withRecordTransaction(db, del)
+		} else {
+			err = del()
+		}
+		if err == nil {
+			for id := range deleting {
+				deleted[id] = true
+			}
+		}
+		return
 	})
 
 	if req.Atomic && len(resp.ErrMap) > 0 {
@@ -488,6 +1060,9 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 
 	if req.HookRegistry != nil {
 		records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) (err skyerr.Error) {
+			if plan, ok := plans[record.ID]; ok {
+				plan.executeAfterHooks(req)
+			}
 			err = req.HookRegistry.ExecuteHooks(req.Context, hook.AfterDelete, record, nil)
 			if err != nil {
 				logger := logging.CreateLogger(req.Context, "handler")
@@ -499,10 +1074,93 @@ func RecordDeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) s
 
 	for _, record := range records {
 		resp.DeletedRecordIDs = append(resp.DeletedRecordIDs, record.ID)
+		if plan, ok := plans[record.ID]; ok && len(plan.cascaded) > 0 {
+			if resp.CascadedRecords == nil {
+				resp.CascadedRecords = map[skydb.RecordID][]CascadedRecord{}
+			}
+			resp.CascadedRecords[record.ID] = plan.cascaded
+		}
 	}
 	return nil
 }
 
+// RecordUndeleteHandler restores soft-deleted records. The caller is
+// responsible for checking that the user is allowed to undelete records,
+// as the access control of a deleted record is not checked.
+//
+// Before and after save hooks are executed on the restored records, as the
+// records are saved again. Before save hooks may reject a record, but
+// changes made by them are not saved.
+func RecordUndeleteHandler(req *RecordModifyRequest, resp *RecordModifyResponse) skyerr.Error {
+	db := req.Db
+
+	historyTypes, historyErr := getRecordHistoryTypes(req.Conn)
+	if historyErr != nil {
+		return skyerr.MakeError(historyErr)
+	}
+
+	fetcher := NewRecordFetcher(req.Context, db, req.Conn, req.WithMasterKey)
+	records := []*skydb.Record{}
+	for _, recordID := range req.RecordIDsToUndelete {
+		record, err := fetcher.fetchDeletedRecord(recordID)
+		if err != nil {
+			resp.ErrMap[recordID] = skyerr.MakeError(err)
+			continue
+		}
+		if record == nil {
+			resp.ErrMap[recordID] = skyerr.NewError(skyerr.ResourceNotFound, "record not found")
+			continue
+		}
+		records = append(records, record)
+	}
+
+	// the records are undeleted as if they are created again
+	originalRecordMap := map[skydb.RecordID]*skydb.Record{}
+	if req.HookRegistry != nil {
+		records = newSaveHookTriggerer(req.Context, req.HookRegistry, originalRecordMap, resp.ErrMap, false).
+			trigger(records, hook.BeforeSave)
+	}
+
+	records = executeRecordFunc(records, resp.ErrMap, func(record *skydb.Record) skyerr.Error {
+		recordID := record.ID
+		undelete := func() skyerr.Error {
+			if err := db.Undelete(recordID); err == skydb.ErrRecordNotFound {
+				return skyerr.NewError(skyerr.ResourceNotFound, "record not found")
+			} else if err != nil {
+				return skyerr.MakeError(err)
+			}
+
+			undeletedRecord := skydb.Record{}
+			if err := db.Get(recordID, &undeletedRecord); err != nil {
+				return skyerr.MakeError(err)
+			}
+			*record = undeletedRecord
+
+			if historyTypes[recordID.Type] {
+				return saveRecordRevision(req.Conn, db, skydb.RecordRevisionSave, record, nil, req.AuthInfo)
+			}
+			return nil
+		}
+
+		if historyTypes[recordID.Type] {
+			return withRecordTransaction(db, undelete)
+		}
+		return undelete()
+	})
+
+	if req.Atomic && len(resp.ErrMap) > 0 {
+		return skyerr.NewError(skyerr.UnexpectedError, "atomic operation failed")
+	}
+
+	if req.HookRegistry != nil {
+		records = newSaveHookTriggerer(req.Context, req.HookRegistry, originalRecordMap, resp.ErrMap, true).
+			trigger(records, hook.AfterSave)
+	}
+
+	resp.UndeletedRecords = records
+	return nil
+}
+
 type schemaMerger struct {
 	finalSchema skydb.RecordSchema
 	err         error
@@ -553,28 +1211,6 @@ func deriveRecordSchema(m skydb.Data) skydb.RecordSchema {
 	return schema
 }
 
-func EagerIDs(db skydb.Database, records []skydb.Record, query skydb.Query) map[string][]skydb.RecordID {
-	eagers := map[string][]skydb.RecordID{}
-	for _, transientExpression := range query.ComputedKeys {
-		if transientExpression.Type != skydb.KeyPath {
-			continue
-		}
-		keyPath := transientExpression.Value.(string)
-		eagers[keyPath] = make([]skydb.RecordID, len(records))
-	}
-
-	for i, record := range records {
-		for keyPath := range eagers {
-			ref := getReferenceWithKeyPath(db, &record, keyPath)
-			if ref.IsEmpty() {
-				continue
-			}
-			eagers[keyPath][i] = ref.ID
-		}
-	}
-	return eagers
-}
-
 // getReferenceWithKeyPath returns a reference for use in eager loading
 // It handles the case where reserved attribute is a string ID instead of
 // a referenced ID.
@@ -602,30 +1238,6 @@ func getReferenceWithKeyPath(db skydb.Database, record *skydb.Record, keyPath st
 	}
 }
 
-func DoQueryEager(ctx context.Context, db skydb.Database, eagersIDs map[string][]skydb.RecordID, accessControlOptions *skydb.AccessControlOptions) map[string]map[string]*skydb.Record {
-	eagerRecords := map[string]map[string]*skydb.Record{}
-	logger := logging.CreateLogger(ctx, "handler")
-	for keyPath, ids := range eagersIDs {
-		logger.Debugf("Getting value for keypath %v", keyPath)
-		eagerScanner, err := db.GetByIDs(ids, accessControlOptions)
-		if err != nil {
-			logger.Debugf("No Records found in the eager load key path: %s", keyPath)
-			eagerRecords[keyPath] = map[string]*skydb.Record{}
-			continue
-		}
-		for eagerScanner.Scan() {
-			er := eagerScanner.Record()
-			if eagerRecords[keyPath] == nil {
-				eagerRecords[keyPath] = map[string]*skydb.Record{}
-			}
-			eagerRecords[keyPath][er.ID.Key] = &er
-		}
-		eagerScanner.Close()
-	}
-
-	return eagerRecords
-}
-
 func getRecordCount(db skydb.Database, query *skydb.Query, accessControlOptions *skydb.AccessControlOptions, results *skydb.Rows) (uint64, error) {
 	if results != nil {
 		recordCount := results.OverallRecordCount()
@@ -766,10 +1378,39 @@ func (f *RecordResultFilter) JSONResult(record *skydb.Record) *skyconv.JSONRecor
 	return (*skyconv.JSONRecord)(&recordCopy)
 }
 
+// readable returns whether the field of record is readable to the
+// provided authInfo.
+func (f *RecordResultFilter) readable(record *skydb.Record, field string) bool {
+	if f.BypassAccessControl || strings.HasPrefix(field, "_") {
+		return true
+	}
+	return f.FieldACL.Accessible(record.ID.Type, field, skydb.ReadFieldAccessMode, f.AuthInfo, record)
+}
+
+// DiffResult returns the serializable form of the diff of a revision of
+// record, with fields that are not readable to the provided authInfo
+// removed.
+func (f *RecordResultFilter) DiffResult(record *skydb.Record, diff map[string]skydb.RecordFieldChange) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, change := range diff {
+		if !f.BypassAccessControl && !f.FieldACL.Accessible(record.ID.Type, key, skydb.ReadFieldAccessMode, f.AuthInfo, record) {
+			continue
+		}
+		injectSigner(&skydb.Record{
+			Data: skydb.Data{"old": change.Old, "new": change.New},
+		}, f.AssetStore)
+		result[key] = map[string]interface{}{
+			"old": skyconv.ToLiteral(change.Old),
+			"new": skyconv.ToLiteral(change.New),
+		}
+	}
+	return result
+}
+
 type QueryResultFilter struct {
 	Database           skydb.Database
 	Query              skydb.Query
-	EagerRecords       map[string]map[string]*skydb.Record
+	EagerRecords       EagerRecords
 	RecordResultFilter RecordResultFilter
 }
 
@@ -785,9 +1426,8 @@ func (f *QueryResultFilter) JSONResult(record *skydb.Record) *skyconv.JSONRecord
 		}
 
 		keyPath := transientExpression.Value.(string)
-		ref := getReferenceWithKeyPath(f.Database, &recordCopy, keyPath)
 		var transientValue interface{}
-		eagerRecord := f.EagerRecords[keyPath][ref.ID.Key]
+		eagerRecord := f.EagerRecords.recordAtKeyPath(f.Database, &recordCopy, keyPath, f.RecordResultFilter)
 		if eagerRecord != nil {
 			transientValue = f.RecordResultFilter.JSONResult(eagerRecord)
 		}
@@ -798,5 +1438,18 @@ func (f *QueryResultFilter) JSONResult(record *skydb.Record) *skyconv.JSONRecord
 		recordCopy.Transient[transientKey] = transientValue
 	}
 
+	for transientKey := range f.Query.ReverseIncludes {
+		reverseRecords := f.EagerRecords.Reverses[transientKey][recordCopy.ID.Key]
+		transientValue := make([]interface{}, len(reverseRecords))
+		for i, reverseRecord := range reverseRecords {
+			transientValue[i] = f.RecordResultFilter.JSONResult(reverseRecord)
+		}
+
+		if recordCopy.Transient == nil {
+			recordCopy.Transient = map[string]interface{}{}
+		}
+		recordCopy.Transient[transientKey] = transientValue
+	}
+
 	return f.RecordResultFilter.JSONResult(&recordCopy)
 }
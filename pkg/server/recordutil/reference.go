@@ -0,0 +1,327 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recordutil
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/plugin/hook"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// CascadedRecord is a record deleted or updated because it references a
+// deleted record, according to the on_delete constraint of Field.
+type CascadedRecord struct {
+	ID       skydb.RecordID
+	Field    string
+	OnDelete skydb.ReferenceAction
+}
+
+// referenceRule is the on_delete constraint of a reference field.
+type referenceRule struct {
+	RecordType string
+	Field      string
+	OnDelete   skydb.ReferenceAction
+}
+
+// getReferenceRules returns the reference rules of all record types,
+// keyed by the referenced record type.
+func getReferenceRules(db skydb.Database) (map[string][]referenceRule, error) {
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := map[string][]referenceRule{}
+	for recordType, schema := range schemas {
+		for field, fieldType := range schema {
+			if fieldType.Type != skydb.TypeReference || fieldType.Constraints == nil || fieldType.Constraints.OnDelete == "" {
+				continue
+			}
+			rules[fieldType.ReferenceType] = append(rules[fieldType.ReferenceType], referenceRule{
+				RecordType: recordType,
+				Field:      field,
+				OnDelete:   fieldType.Constraints.OnDelete,
+			})
+		}
+	}
+
+	for _, typeRules := range rules {
+		sort.Slice(typeRules, func(i, j int) bool {
+			if typeRules[i].RecordType != typeRules[j].RecordType {
+				return typeRules[i].RecordType < typeRules[j].RecordType
+			}
+			return typeRules[i].Field < typeRules[j].Field
+		})
+	}
+	return rules, nil
+}
+
+// cascadePlan is the records to be deleted and updated before a record
+// is deleted.
+type cascadePlan struct {
+	// deletes are ordered such that a record comes after the records
+	// referencing it.
+	deletes []*skydb.Record
+
+	// updates are the referencing records with fields set to null,
+	// with the original records in origRecords.
+	updates     []*skydb.Record
+	origRecords map[skydb.RecordID]*skydb.Record
+
+	cascaded []CascadedRecord
+}
+
+type referenceResolver struct {
+	req   *RecordModifyRequest
+	rules map[string][]referenceRule
+}
+
+// resolve returns the cascadePlan of deleting the record. An error is
+// returned if a restrict rule applies, or if the user cannot modify a
+// referencing record.
+func (r *referenceResolver) resolve(record *skydb.Record) (*cascadePlan, skyerr.Error) {
+	plan := &cascadePlan{
+		origRecords: map[skydb.RecordID]*skydb.Record{},
+	}
+	deleting := map[skydb.RecordID]bool{record.ID: true}
+	if err := r.resolveRecord(record, plan, deleting); err != nil {
+		return nil, err
+	}
+
+	// a record updated by a rule may be deleted by another rule
+	updates := plan.updates[:0]
+	for _, update := range plan.updates {
+		if !deleting[update.ID] {
+			updates = append(updates, update)
+		}
+	}
+	plan.updates = updates
+	return plan, nil
+}
+
+func (r *referenceResolver) resolveRecord(record *skydb.Record, plan *cascadePlan, deleting map[skydb.RecordID]bool) skyerr.Error {
+	for _, rule := range r.rules[record.ID.Type] {
+		referencing, err := r.queryReferencing(rule, record.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range referencing {
+			if deleting[ref.ID] {
+				continue
+			}
+
+			if rule.OnDelete == skydb.RestrictReference {
+				return r.restrictError(record, ref, rule)
+			}
+
+			if !r.req.WithMasterKey && !ref.Accessible(r.req.AuthInfo, skydb.WriteLevel) {
+				return skyerr.NewErrorf(
+					skyerr.PermissionDenied,
+					"cannot delete %s because %s references it and is not writable",
+					record.ID,
+					ref.ID,
+				)
+			}
+
+			plan.cascaded = append(plan.cascaded, CascadedRecord{
+				ID:       ref.ID,
+				Field:    rule.Field,
+				OnDelete: rule.OnDelete,
+			})
+
+			if rule.OnDelete == skydb.SetNullReference {
+				update := r.pendingUpdate(ref, plan)
+				update.Data[rule.Field] = nil
+				continue
+			}
+
+			if ref.ID.Type == r.req.Db.UserRecordType() {
+				return skyerr.NewErrorf(
+					skyerr.PermissionDenied,
+					"cannot delete %s because user record %s references it",
+					record.ID,
+					ref.ID,
+				)
+			}
+
+			deleting[ref.ID] = true
+			if err := r.resolveRecord(ref, plan, deleting); err != nil {
+				return err
+			}
+			plan.deletes = append(plan.deletes, ref)
+		}
+	}
+	return nil
+}
+
+// restrictError returns the error of deleting a record referenced by a
+// restrict rule. The referencing record is identified only if the user
+// can read it.
+func (r *referenceResolver) restrictError(record *skydb.Record, ref *skydb.Record, rule referenceRule) skyerr.Error {
+	if !r.req.WithMasterKey && !ref.Accessible(r.req.AuthInfo, skydb.ReadLevel) {
+		return skyerr.NewErrorWithInfo(
+			skyerr.ConstraintViolated,
+			fmt.Sprintf("cannot delete %s because a %s record references it by field %s", record.ID, ref.ID.Type, rule.Field),
+			map[string]interface{}{
+				"field":      rule.Field,
+				"constraint": skydb.OnDeleteConstraint,
+			},
+		)
+	}
+
+	return skyerr.NewErrorWithInfo(
+		skyerr.ConstraintViolated,
+		fmt.Sprintf("cannot delete %s because %s references it by field %s", record.ID, ref.ID, rule.Field),
+		map[string]interface{}{
+			"id":         ref.ID.String(),
+			"field":      rule.Field,
+			"constraint": skydb.OnDeleteConstraint,
+		},
+	)
+}
+
+// pendingUpdate returns the record in the plan to be updated, so that
+// fields nulled by different rules are saved at once.
+func (r *referenceResolver) pendingUpdate(record *skydb.Record, plan *cascadePlan) *skydb.Record {
+	for _, update := range plan.updates {
+		if update.ID == record.ID {
+			return update
+		}
+	}
+
+	update := record.Copy()
+	plan.origRecords[record.ID] = record
+	plan.updates = append(plan.updates, &update)
+	return &update
+}
+
+func (r *referenceResolver) queryReferencing(rule referenceRule, id skydb.RecordID) ([]*skydb.Record, skyerr.Error) {
+	query := skydb.Query{
+		Type: rule.RecordType,
+		Predicate: skydb.Predicate{
+			Operator: skydb.Equal,
+			Children: []interface{}{
+				skydb.Expression{Type: skydb.KeyPath, Value: rule.Field},
+				skydb.Expression{Type: skydb.Literal, Value: skydb.NewReference(id.Type, id.Key)},
+			},
+		},
+	}
+
+	// referencing records are queried regardless of access control, so
+	// that records not readable by the user are not left behind
+	rows, err := r.req.Db.Query(&query, &skydb.AccessControlOptions{
+		ViewAsUser:          r.req.AuthInfo,
+		BypassAccessControl: true,
+	})
+	if err != nil {
+		return nil, skyerr.MakeError(err)
+	}
+	defer rows.Close()
+
+	records := []*skydb.Record{}
+	for rows.Scan() {
+		record := rows.Record()
+		records = append(records, &record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, skyerr.MakeError(err)
+	}
+	return records, nil
+}
+
+// executeBeforeHooks executes the before hooks of the records in the plan.
+func (plan *cascadePlan) executeBeforeHooks(req *RecordModifyRequest) skyerr.Error {
+	for _, record := range plan.updates {
+		if err := req.HookRegistry.ExecuteHooks(req.Context, hook.BeforeSave, record, plan.origRecords[record.ID]); err != nil {
+			return err
+		}
+	}
+	for _, record := range plan.deletes {
+		if err := req.HookRegistry.ExecuteHooks(req.Context, hook.BeforeDelete, record, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute saves the updated records and deletes the cascaded records of
+// the plan, adding the deleted records to deleting. Records in deleted are
+// skipped, as they are deleted by the plan of another record.
+//
+// The caller should call execute in the transaction deleting the record
+// of the plan, so that the cascaded records are not left modified if the
+// record is not deleted.
+func (plan *cascadePlan) execute(req *RecordModifyRequest, historyTypes map[string]bool, deleted map[skydb.RecordID]bool, deleting map[skydb.RecordID]bool) skyerr.Error {
+	db := req.Db
+	for _, record := range plan.updates {
+		if deleted[record.ID] {
+			continue
+		}
+
+		record.UpdatedAt = req.ModifyAt
+		record.UpdaterID = req.AuthInfo.ID
+
+		// only the nulled fields are saved, as the record may be
+		// updated by the plan of another record
+		var deltaRecord skydb.Record
+		DeriveDeltaRecord(&deltaRecord, plan.origRecords[record.ID], record)
+		if err := db.Save(&deltaRecord); err != nil {
+			return skyerr.MakeError(err)
+		}
+		if historyTypes[record.ID.Type] {
+			if err := saveRecordRevision(req.Conn, db, skydb.RecordRevisionSave, record, plan.origRecords[record.ID], req.AuthInfo); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, record := range plan.deletes {
+		if deleted[record.ID] {
+			continue
+		}
+
+		if err := db.Delete(record.ID); err != nil {
+			return skyerr.MakeError(err)
+		}
+		deleting[record.ID] = true
+		if historyTypes[record.ID.Type] {
+			if err := saveRecordRevision(req.Conn, db, skydb.RecordRevisionDelete, record, record, req.AuthInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// executeAfterHooks executes the after hooks of the records in the plan.
+// Errors are logged as the records are already modified.
+func (plan *cascadePlan) executeAfterHooks(req *RecordModifyRequest) {
+	logger := logging.CreateLogger(req.Context, "handler")
+	for _, record := range plan.updates {
+		if err := req.HookRegistry.ExecuteHooks(req.Context, hook.AfterSave, record, plan.origRecords[record.ID]); err != nil {
+			logger.Errorf("Error occurred while executing hooks: %s", err)
+		}
+	}
+	for _, record := range plan.deletes {
+		if err := req.HookRegistry.ExecuteHooks(req.Context, hook.AfterDelete, record, nil); err != nil {
+			logger.Errorf("Error occurred while executing hooks: %s", err)
+		}
+	}
+}
@@ -16,22 +16,43 @@ package router
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/skygeario/skygear-server/pkg/server/logging"
 )
 
+// CORSMiddleware allows cross-origin requests from Origin. Requests are
+// passed to Next without CORS headers if Origin is empty.
 type CORSMiddleware struct {
 	Origin string
 	Next   http.Handler
+
+	mutex sync.RWMutex
+}
+
+// SetOrigin changes the Origin of requests being served.
+func (cors *CORSMiddleware) SetOrigin(origin string) {
+	cors.mutex.Lock()
+	defer cors.mutex.Unlock()
+	cors.Origin = origin
 }
 
 func (cors *CORSMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cors.mutex.RLock()
+	origin := cors.Origin
+	cors.mutex.RUnlock()
+
+	if origin == "" {
+		cors.Next.ServeHTTP(w, r)
+		return
+	}
+
 	logger := logging.CreateLogger(r.Context(), "router")
 	requestMethod := r.Method
 	corsMethod := r.Header.Get("Access-Control-Request-Method")
 	corsHeaders := r.Header.Get("Access-Control-Request-Headers")
 
-	w.Header().Set("Access-Control-Allow-Origin", cors.Origin)
+	w.Header().Set("Access-Control-Allow-Origin", origin)
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 	if corsMethod != "" {
@@ -104,5 +104,30 @@ func TestCORSMiddleware(t *testing.T) {
 			})
 		})
 
+		Convey("Handle Request After Origin Changed", func() {
+			routeWithMiddleware.SetOrigin("http://skygear.dev3")
+
+			req, _ := http.NewRequest("OPTIONS", "http://skygear.dev/", nil)
+			resp := httptest.NewRecorder()
+			routeWithMiddleware.ServeHTTP(resp, req)
+
+			So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "http://skygear.dev3")
+		})
+
+		Convey("Pass Request Without Origin", func() {
+			routeWithMiddleware.SetOrigin("")
+
+			req, _ := http.NewRequest(
+				"POST",
+				"http://skygear.dev/",
+				strings.NewReader(mockJSON),
+			)
+			resp := httptest.NewRecorder()
+			routeWithMiddleware.ServeHTTP(resp, req)
+
+			So(resp.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+			mockRespJSON, _ := json.Marshal(&mockResp)
+			So(resp.Body.String(), ShouldEqualJSON, mockRespJSON)
+		})
 	})
 }
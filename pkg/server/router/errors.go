@@ -51,6 +51,8 @@ func defaultStatusCode(err skyerr.Error) int {
 		skyerr.NotConfigured:           http.StatusServiceUnavailable,
 		skyerr.UserDisabled:            http.StatusForbidden,
 		skyerr.VerificationRequired:    http.StatusForbidden,
+		skyerr.AssetPolicyViolated:     http.StatusBadRequest,
+		skyerr.ResourceConflict:        http.StatusConflict,
 	}[err.Code()]
 	if !ok {
 		if err.Code() < 10000 {
@@ -0,0 +1,463 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemafile
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// Action is the kind of a Change.
+type Action string
+
+// List of actions of a Change.
+const (
+	CreateRecordType Action = "create_record_type"
+	AddField         Action = "add_field"
+	DeleteField      Action = "delete_field"
+	ChangeFieldType  Action = "change_field_type"
+	CreateIndex      Action = "create_index"
+	DeleteIndex      Action = "delete_index"
+	SetDefaultAccess Action = "set_default_access"
+	SetFieldAccess   Action = "set_field_access"
+)
+
+// Change is a single change to the schema of a database.
+type Change struct {
+	Action      Action `json:"action"`
+	RecordType  string `json:"record_type,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description"`
+
+	// Conflict is true if the change cannot be applied, such as changing
+	// the type of an existing field.
+	Conflict bool `json:"conflict,omitempty"`
+
+	apply func(db skydb.Database) error
+}
+
+func (c Change) String() string {
+	return c.Description
+}
+
+// Plan is the list of changes to make the schema of a database match a
+// schema file.
+type Plan struct {
+	Changes []Change `json:"changes"`
+}
+
+// IsEmpty returns true if the database already matches the schema file.
+func (p *Plan) IsEmpty() bool {
+	return len(p.Changes) == 0
+}
+
+// HasConflict returns true if any change of the plan cannot be applied.
+func (p *Plan) HasConflict() bool {
+	for _, change := range p.Changes {
+		if change.Conflict {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plan) String() string {
+	if p.IsEmpty() {
+		return "No changes.\n"
+	}
+
+	lines := []string{}
+	for _, change := range p.Changes {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Options controls how a Plan is computed.
+type Options struct {
+	// Prune deletes fields and indexes of the record types defined in
+	// the file if they are not defined in the file. Indexes on reserved
+	// fields, indexes of auth record keys and indexes of unique field
+	// constraints are always kept.
+	Prune bool
+
+	// AuthRecordKeys is the auth record keys of the user record type.
+	AuthRecordKeys [][]string
+}
+
+// Diff computes the plan to make the schema of the database match the
+// schema file.
+//
+// New record types are created before adding reference fields, so that
+// record types in the file may reference each other.
+func Diff(db skydb.Database, file *File, options Options) (*Plan, error) {
+	remoteSchemas, err := db.GetRecordSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	var creations, fieldChanges, indexChanges, accessChanges []Change
+	for _, recordType := range sortedRecordTypes(file.RecordTypes) {
+		def := file.RecordTypes[recordType]
+		remoteSchema, exists := remoteSchemas[recordType]
+		if !exists {
+			schema := skydb.RecordSchema{}
+			for name, fieldType := range def.Fields {
+				if fieldType.Type != skydb.TypeReference {
+					schema[name] = fieldType
+				}
+			}
+			creations = append(creations, createRecordTypeChange(recordType, schema))
+			remoteSchema = schema
+		}
+
+		for _, name := range sortedFieldNames(def.Fields) {
+			fieldType := def.Fields[name]
+			if fieldType.Type == skydb.TypeReference {
+				_, remoteOK := remoteSchemas[fieldType.ReferenceType]
+				_, fileOK := file.RecordTypes[fieldType.ReferenceType]
+				if !remoteOK && !fileOK {
+					return nil, fmt.Errorf(
+						"schemafile: field %s.%s references unknown record type %s",
+						recordType, name, fieldType.ReferenceType,
+					)
+				}
+			}
+
+			remoteType, ok := remoteSchema[name]
+			if !ok {
+				fieldChanges = append(fieldChanges, addFieldChange(recordType, name, fieldType))
+			} else if !remoteType.DefinitionCompatibleTo(fieldType) {
+				fieldChanges = append(fieldChanges, Change{
+					Action:     ChangeFieldType,
+					RecordType: recordType,
+					Name:       name,
+					Description: fmt.Sprintf(
+						"! change type of field %s.%s from %s to %s (not supported)",
+						recordType, name, remoteType.ToSimpleName(), fieldType.ToSimpleName(),
+					),
+					Conflict: true,
+				})
+			}
+		}
+
+		if options.Prune && exists {
+			for _, name := range sortedFieldNames(remoteSchema) {
+				if _, ok := def.Fields[name]; !ok && !strings.HasPrefix(name, "_") {
+					fieldChanges = append(fieldChanges, deleteFieldChange(recordType, name, remoteSchema[name]))
+				}
+			}
+		}
+
+		remoteIndexes := map[string]skydb.Index{}
+		if exists {
+			remoteIndexes, err = db.GetIndexesByRecordType(recordType)
+			if err != nil {
+				return nil, err
+			}
+		}
+		indexChanges = append(
+			indexChanges,
+			diffIndexes(db, recordType, def.Indexes, remoteIndexes, options)...,
+		)
+
+		if def.DefaultAccess != nil {
+			remoteACL, err := db.Conn().GetRecordDefaultAccess(recordType)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(remoteACL, def.DefaultAccess) {
+				accessChanges = append(accessChanges, setDefaultAccessChange(recordType, def.DefaultAccess))
+			}
+		}
+	}
+
+	if file.FieldAccess != nil {
+		remoteFieldACL, err := db.Conn().GetRecordFieldAccess()
+		if err != nil {
+			return nil, err
+		}
+		if !fieldACLEqual(remoteFieldACL, *file.FieldAccess) {
+			accessChanges = append(accessChanges, setFieldAccessChange(*file.FieldAccess))
+		}
+	}
+
+	plan := &Plan{Changes: []Change{}}
+	plan.Changes = append(plan.Changes, creations...)
+	plan.Changes = append(plan.Changes, fieldChanges...)
+	plan.Changes = append(plan.Changes, indexChanges...)
+	plan.Changes = append(plan.Changes, accessChanges...)
+	return plan, nil
+}
+
+// Apply applies the changes of the plan to the database. If the database
+// supports transaction, all changes are applied in a single transaction.
+// A plan having conflicts is not applied.
+func Apply(db skydb.Database, plan *Plan) error {
+	if plan.HasConflict() {
+		return skyerr.NewError(
+			skyerr.IncompatibleSchema,
+			"schema file conflicts with the database schema",
+		)
+	}
+
+	apply := func() error {
+		for _, change := range plan.Changes {
+			if err := change.apply(db); err != nil {
+				return fmt.Errorf("failed to %s: %v", strings.TrimLeft(change.Description, "+-~ "), err)
+			}
+		}
+		return nil
+	}
+
+	if tx, ok := db.(skydb.Transactional); ok {
+		return skydb.WithTransaction(tx, apply)
+	}
+	return apply()
+}
+
+func diffIndexes(
+	db skydb.Database,
+	recordType string,
+	indexes map[string]skydb.Index,
+	remoteIndexes map[string]skydb.Index,
+	options Options,
+) []Change {
+	changes := []Change{}
+
+	if options.Prune {
+		for _, name := range sortedIndexNames(remoteIndexes) {
+			index := remoteIndexes[name]
+			if _, ok := indexes[name]; ok {
+				continue
+			}
+			if isReservedIndex(db, recordType, name, index, options.AuthRecordKeys) {
+				continue
+			}
+			changes = append(changes, deleteIndexChange(recordType, name, index))
+		}
+	}
+
+	for _, name := range sortedIndexNames(indexes) {
+		index := indexes[name]
+		remoteIndex, ok := remoteIndexes[name]
+		if ok && indexEqual(remoteIndex, index) {
+			continue
+		}
+		if ok {
+			changes = append(changes, deleteIndexChange(recordType, name, remoteIndex))
+		}
+		changes = append(changes, createIndexChange(recordType, name, index))
+	}
+
+	return changes
+}
+
+// isReservedIndex returns true if the index is maintained by the server
+// rather than the schema file.
+func isReservedIndex(db skydb.Database, recordType string, name string, index skydb.Index, authRecordKeys [][]string) bool {
+	for _, field := range index.Fields {
+		if strings.HasPrefix(field, "_") {
+			return true
+		}
+	}
+	return IsManagedIndex(db, recordType, name, index, authRecordKeys)
+}
+
+// managedIndexPrefix is the prefix of the names of the indexes of auth
+// record keys created by the server.
+const managedIndexPrefix = "auth_record_keys_"
+
+// IsManagedIndex returns true if the index is managed by the server, which
+// are the indexes of the auth record keys of the user record type and the
+// indexes of unique field constraints. Removing such an index removes the
+// uniqueness guaranteed by the server, so it is changed by neither the
+// schema file nor the schema:index actions.
+func IsManagedIndex(db skydb.Database, recordType string, name string, index skydb.Index, authRecordKeys [][]string) bool {
+	if len(index.Fields) == 1 && index.Unique {
+		if schema, err := db.GetSchema(recordType); err == nil {
+			if constraints := schema[index.Fields[0]].Constraints; constraints != nil && constraints.Unique {
+				return true
+			}
+		}
+	}
+
+	if recordType != db.UserRecordType() {
+		return false
+	}
+	if strings.HasPrefix(name, managedIndexPrefix) {
+		return true
+	}
+	if !index.Unique || index.Condition != "" {
+		return false
+	}
+	// the fields of an index of auth record keys are in any order
+	fields := joinFields(index.Fields)
+	for _, keys := range authRecordKeys {
+		if joinFields(keys) == fields {
+			return true
+		}
+	}
+	return false
+}
+
+// joinFields joins the sorted fields, as the index of auth record keys is
+// named by the server.
+func joinFields(fields []string) string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func indexEqual(remote skydb.Index, index skydb.Index) bool {
+	return remote.Unique == index.Unique &&
+		remote.Condition == "" &&
+		reflect.DeepEqual(remote.Fields, index.Fields)
+}
+
+func fieldACLEqual(a skydb.FieldACL, b skydb.FieldACL) bool {
+	entriesA := a.AllEntries()
+	entriesB := b.AllEntries()
+	if len(entriesA) == 0 && len(entriesB) == 0 {
+		return true
+	}
+	sort.Sort(entriesA)
+	sort.Sort(entriesB)
+	return reflect.DeepEqual(entriesA, entriesB)
+}
+
+func createRecordTypeChange(recordType string, schema skydb.RecordSchema) Change {
+	fields := []string{}
+	for _, name := range sortedFieldNames(schema) {
+		fields = append(fields, fmt.Sprintf("%s: %s", name, schema[name].ToSimpleName()))
+	}
+
+	return Change{
+		Action:      CreateRecordType,
+		RecordType:  recordType,
+		Description: fmt.Sprintf("+ create record type %s (%s)", recordType, strings.Join(fields, ", ")),
+		apply: func(db skydb.Database) error {
+			_, err := db.Extend(recordType, schema)
+			return err
+		},
+	}
+}
+
+func addFieldChange(recordType string, name string, fieldType skydb.FieldType) Change {
+	return Change{
+		Action:      AddField,
+		RecordType:  recordType,
+		Name:        name,
+		Description: fmt.Sprintf("+ add field %s.%s (%s)", recordType, name, fieldType.ToSimpleName()),
+		apply: func(db skydb.Database) error {
+			_, err := db.Extend(recordType, skydb.RecordSchema{name: fieldType})
+			return err
+		},
+	}
+}
+
+func deleteFieldChange(recordType string, name string, fieldType skydb.FieldType) Change {
+	return Change{
+		Action:      DeleteField,
+		RecordType:  recordType,
+		Name:        name,
+		Description: fmt.Sprintf("- delete field %s.%s (%s)", recordType, name, fieldType.ToSimpleName()),
+		apply: func(db skydb.Database) error {
+			return db.DeleteSchema(recordType, name)
+		},
+	}
+}
+
+func createIndexChange(recordType string, name string, index skydb.Index) Change {
+	description := fmt.Sprintf("+ create index %s on %s (%s)", name, recordType, strings.Join(index.Fields, ", "))
+	if index.Unique {
+		description += " unique"
+	}
+
+	return Change{
+		Action:      CreateIndex,
+		RecordType:  recordType,
+		Name:        name,
+		Description: description,
+		apply: func(db skydb.Database) error {
+			return db.SaveIndex(recordType, name, index)
+		},
+	}
+}
+
+func deleteIndexChange(recordType string, name string, index skydb.Index) Change {
+	return Change{
+		Action:      DeleteIndex,
+		RecordType:  recordType,
+		Name:        name,
+		Description: fmt.Sprintf("- delete index %s on %s (%s)", name, recordType, strings.Join(index.Fields, ", ")),
+		apply: func(db skydb.Database) error {
+			return db.DeleteIndex(recordType, name)
+		},
+	}
+}
+
+func setDefaultAccessChange(recordType string, acl skydb.RecordACL) Change {
+	return Change{
+		Action:      SetDefaultAccess,
+		RecordType:  recordType,
+		Description: fmt.Sprintf("~ set default access of %s", recordType),
+		apply: func(db skydb.Database) error {
+			return db.Conn().SetRecordDefaultAccess(recordType, acl)
+		},
+	}
+}
+
+func setFieldAccessChange(acl skydb.FieldACL) Change {
+	return Change{
+		Action:      SetFieldAccess,
+		Description: "~ set field access",
+		apply: func(db skydb.Database) error {
+			return db.Conn().SetRecordFieldAccess(acl)
+		},
+	}
+}
+
+func sortedRecordTypes(recordTypes map[string]RecordType) []string {
+	names := []string{}
+	for name := range recordTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedFieldNames(schema skydb.RecordSchema) []string {
+	names := []string{}
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedIndexNames(indexes map[string]skydb.Index) []string {
+	names := []string{}
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
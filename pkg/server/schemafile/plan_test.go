@@ -0,0 +1,232 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemafile
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiff(t *testing.T) {
+	Convey("Diff", t, func() {
+		db := skydbtest.NewMapDB()
+		db.Extend("note", skydb.RecordSchema{
+			"title":   skydb.FieldType{Type: skydb.TypeString},
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		db.SaveIndex("note", "note_content_idx", skydb.Index{
+			Fields: []string{"content"},
+		})
+		db.SaveIndex("note", "note__id_key", skydb.Index{
+			Fields: []string{"_id"},
+			Unique: true,
+		})
+
+		file := &File{
+			RecordTypes: map[string]RecordType{
+				"note": RecordType{
+					Fields: skydb.RecordSchema{
+						"title": skydb.FieldType{Type: skydb.TypeString},
+						"category": skydb.FieldType{
+							Type:          skydb.TypeReference,
+							ReferenceType: "category",
+						},
+					},
+					Indexes: map[string]skydb.Index{
+						"note_title_key": skydb.Index{
+							Fields: []string{"title"},
+							Unique: true,
+						},
+					},
+					DefaultAccess: skydb.RecordACL{
+						skydb.RecordACLEntry{Public: true, Level: skydb.ReadLevel},
+					},
+				},
+				"category": RecordType{
+					Fields: skydb.RecordSchema{
+						"name": skydb.FieldType{Type: skydb.TypeString},
+					},
+				},
+			},
+		}
+
+		Convey("computes plan", func() {
+			plan, err := Diff(db, file, Options{})
+			So(err, ShouldBeNil)
+			So(plan.String(), ShouldEqual, ""+
+				"+ create record type category (name: string)\n"+
+				"+ add field note.category (ref(category))\n"+
+				"+ create index note_title_key on note (title) unique\n"+
+				"~ set default access of note\n")
+			So(plan.HasConflict(), ShouldBeFalse)
+		})
+
+		Convey("computes plan with pruning", func() {
+			plan, err := Diff(db, file, Options{Prune: true})
+			So(err, ShouldBeNil)
+			So(plan.String(), ShouldEqual, ""+
+				"+ create record type category (name: string)\n"+
+				"+ add field note.category (ref(category))\n"+
+				"- delete field note.content (string)\n"+
+				"- delete index note_content_idx on note (content)\n"+
+				"+ create index note_title_key on note (title) unique\n"+
+				"~ set default access of note\n")
+		})
+
+		Convey("applies plan in transaction", func() {
+			plan, err := Diff(db, file, Options{Prune: true})
+			So(err, ShouldBeNil)
+
+			txDB := skydbtest.NewMockTxDatabase(db)
+			err = Apply(txDB, plan)
+			So(err, ShouldBeNil)
+			So(txDB.DidBegin, ShouldBeTrue)
+			So(txDB.DidCommit, ShouldBeTrue)
+
+			So(db.RecordSchemaMap["note"], ShouldResemble, skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeString},
+				"category": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "category",
+				},
+			})
+			So(db.IndexMap["note"], ShouldContainKey, "note_title_key")
+			So(db.IndexMap["note"], ShouldContainKey, "note__id_key")
+			So(db.IndexMap["note"], ShouldNotContainKey, "note_content_idx")
+
+			acl, err := db.Conn().GetRecordDefaultAccess("note")
+			So(err, ShouldBeNil)
+			So(acl, ShouldResemble, file.RecordTypes["note"].DefaultAccess)
+
+			plan, err = Diff(db, file, Options{Prune: true})
+			So(err, ShouldBeNil)
+			So(plan.IsEmpty(), ShouldBeTrue)
+			So(plan.String(), ShouldEqual, "No changes.\n")
+		})
+
+		Convey("replaces changed index", func() {
+			db.SaveIndex("note", "note_title_key", skydb.Index{
+				Fields: []string{"title"},
+			})
+
+			plan, err := Diff(db, file, Options{})
+			So(err, ShouldBeNil)
+			So(plan.Changes[2].String(), ShouldEqual, "- delete index note_title_key on note (title)")
+			So(plan.Changes[3].String(), ShouldEqual, "+ create index note_title_key on note (title) unique")
+		})
+
+		Convey("keeps indexes of auth record keys", func() {
+			db.Extend("user", skydb.RecordSchema{
+				"username": skydb.FieldType{Type: skydb.TypeString},
+			})
+			db.SaveIndex("user", "auth_record_keys_user_username_key", skydb.Index{
+				Fields: []string{"username"},
+				Unique: true,
+			})
+
+			plan, err := Diff(db, &File{
+				RecordTypes: map[string]RecordType{
+					"user": RecordType{
+						Fields: skydb.RecordSchema{
+							"username": skydb.FieldType{Type: skydb.TypeString},
+						},
+					},
+				},
+			}, Options{
+				Prune:          true,
+				AuthRecordKeys: [][]string{[]string{"username"}},
+			})
+			So(err, ShouldBeNil)
+			So(plan.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("keeps indexes of auth record keys in any order", func() {
+			db.Extend("user", skydb.RecordSchema{
+				"username": skydb.FieldType{Type: skydb.TypeString},
+				"email":    skydb.FieldType{Type: skydb.TypeString},
+			})
+			db.SaveIndex("user", "user_email_username_key", skydb.Index{
+				Fields: []string{"email", "username"},
+				Unique: true,
+			})
+
+			plan, err := Diff(db, &File{
+				RecordTypes: map[string]RecordType{
+					"user": RecordType{
+						Fields: skydb.RecordSchema{
+							"username": skydb.FieldType{Type: skydb.TypeString},
+							"email":    skydb.FieldType{Type: skydb.TypeString},
+						},
+					},
+				},
+			}, Options{
+				Prune:          true,
+				AuthRecordKeys: [][]string{[]string{"username", "email"}},
+			})
+			So(err, ShouldBeNil)
+			So(plan.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("reports conflicting field type", func() {
+			file.RecordTypes["note"].Fields["title"] = skydb.FieldType{Type: skydb.TypeNumber}
+
+			plan, err := Diff(db, file, Options{})
+			So(err, ShouldBeNil)
+			So(plan.HasConflict(), ShouldBeTrue)
+			So(plan.Changes[2].String(), ShouldEqual, "! change type of field note.title from string to number (not supported)")
+
+			txDB := skydbtest.NewMockTxDatabase(db)
+			So(Apply(txDB, plan), ShouldNotBeNil)
+			So(txDB.DidBegin, ShouldBeFalse)
+		})
+
+		Convey("sets field access", func() {
+			fieldACL := skydb.NewFieldACL(skydb.FieldACLEntryList{
+				skydb.FieldACLEntry{
+					RecordType:   "note",
+					RecordField:  "title",
+					UserRole:     skydb.NewFieldUserRole("_any_user"),
+					Writable:     false,
+					Readable:     true,
+					Comparable:   true,
+					Discoverable: true,
+				},
+			})
+			file.FieldAccess = &fieldACL
+
+			plan, err := Diff(db, file, Options{})
+			So(err, ShouldBeNil)
+			So(plan.Changes[len(plan.Changes)-1].Action, ShouldEqual, SetFieldAccess)
+
+			So(Apply(db, plan), ShouldBeNil)
+			remoteFieldACL, err := db.Conn().GetRecordFieldAccess()
+			So(err, ShouldBeNil)
+			So(remoteFieldACL.AllEntries(), ShouldResemble, fieldACL.AllEntries())
+		})
+
+		Convey("rejects reference to unknown record type", func() {
+			file.RecordTypes["note"].Fields["author"] = skydb.FieldType{
+				Type:          skydb.TypeReference,
+				ReferenceType: "author",
+			}
+
+			_, err := Diff(db, file, Options{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
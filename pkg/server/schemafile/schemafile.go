@@ -0,0 +1,206 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schemafile reads a declarative schema definition and applies it
+// to a database.
+//
+// A schema file is a JSON document like the following:
+//
+//	{
+//		"record_types": {
+//			"note": {
+//				"fields": [
+//					{"name": "title", "type": "string"},
+//					{"name": "category", "type": "ref(category)"}
+//				],
+//				"indexes": {
+//					"note_title_key": {"fields": ["title"], "unique": true}
+//				},
+//				"default_access": [
+//					{"public": true, "level": "read"}
+//				]
+//			},
+//			"category": {
+//				"fields": [
+//					{"name": "name", "type": "string"}
+//				]
+//			}
+//		},
+//		"field_access": [
+//			{
+//				"record_type": "note",
+//				"record_field": "title",
+//				"user_role": "_any_user",
+//				"writable": true,
+//				"readable": true,
+//				"comparable": true,
+//				"discoverable": true
+//			}
+//		]
+//	}
+//
+// Default access and field access are left untouched when they are
+// absent from the file.
+package schemafile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+)
+
+// File is a declarative definition of the schema of a database.
+type File struct {
+	RecordTypes map[string]RecordType
+
+	// FieldAccess is the field ACL of all record types, nil if the
+	// field ACL is not defined in the file.
+	FieldAccess *skydb.FieldACL
+}
+
+// RecordType is the definition of a record type in a File.
+type RecordType struct {
+	Fields  skydb.RecordSchema
+	Indexes map[string]skydb.Index
+
+	// DefaultAccess is the default access of records of the type, nil
+	// if the default access is not defined in the file.
+	DefaultAccess skydb.RecordACL
+}
+
+type rawFile struct {
+	RecordTypes map[string]rawRecordType  `mapstructure:"record_types"`
+	FieldAccess *[]map[string]interface{} `mapstructure:"field_access"`
+}
+
+type rawRecordType struct {
+	Fields []struct {
+		Name     string `mapstructure:"name"`
+		TypeName string `mapstructure:"type"`
+	} `mapstructure:"fields"`
+	Indexes map[string]struct {
+		Fields []string `mapstructure:"fields"`
+		Unique bool     `mapstructure:"unique"`
+	} `mapstructure:"indexes"`
+	DefaultAccess *[]map[string]interface{} `mapstructure:"default_access"`
+}
+
+// LoadFile reads a schema file at the specified path.
+func LoadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// Load reads a schema file in JSON.
+func Load(r io.Reader) (*File, error) {
+	data := map[string]interface{}{}
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, fmt.Errorf("schemafile: unable to decode JSON: %v", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses an unmarshalled JSON of a schema file.
+func Parse(data map[string]interface{}) (*File, error) {
+	raw := rawFile{}
+	if err := mapstructure.Decode(data, &raw); err != nil {
+		return nil, fmt.Errorf("schemafile: %v", err)
+	}
+
+	file := &File{
+		RecordTypes: map[string]RecordType{},
+	}
+	for recordType, rawType := range raw.RecordTypes {
+		if strings.HasPrefix(recordType, "_") {
+			return nil, fmt.Errorf("schemafile: record type %s is reserved", recordType)
+		}
+
+		def := RecordType{
+			Fields:  skydb.RecordSchema{},
+			Indexes: map[string]skydb.Index{},
+		}
+		for _, field := range rawType.Fields {
+			if field.Name == "" || strings.HasPrefix(field.Name, "_") {
+				return nil, fmt.Errorf(`schemafile: invalid field name "%s" in %s`, field.Name, recordType)
+			}
+			if _, ok := def.Fields[field.Name]; ok {
+				return nil, fmt.Errorf("schemafile: duplicated field %s.%s", recordType, field.Name)
+			}
+
+			fieldType, err := skydb.SimpleNameToFieldType(field.TypeName)
+			if err != nil {
+				return nil, fmt.Errorf("schemafile: field %s.%s: %v", recordType, field.Name, err)
+			}
+			def.Fields[field.Name] = fieldType
+		}
+
+		for name, index := range rawType.Indexes {
+			if len(index.Fields) == 0 {
+				return nil, fmt.Errorf("schemafile: index %s has no fields", name)
+			}
+			def.Indexes[name] = skydb.Index{
+				Fields: index.Fields,
+				Unique: index.Unique,
+			}
+		}
+
+		if rawType.DefaultAccess != nil {
+			acl, err := parseRecordACL(*rawType.DefaultAccess)
+			if err != nil {
+				return nil, fmt.Errorf("schemafile: default access of %s: %v", recordType, err)
+			}
+			def.DefaultAccess = acl
+		}
+
+		file.RecordTypes[recordType] = def
+	}
+
+	if raw.FieldAccess != nil {
+		entries := skydb.FieldACLEntryList{}
+		for _, v := range *raw.FieldAccess {
+			ace := skydb.FieldACLEntry{}
+			if err := (*skyconv.MapFieldACLEntry)(&ace).FromMap(v); err != nil {
+				return nil, fmt.Errorf("schemafile: field access: %v", err)
+			}
+			entries = append(entries, ace)
+		}
+		fieldACL := skydb.NewFieldACL(entries)
+		file.FieldAccess = &fieldACL
+	}
+
+	return file, nil
+}
+
+func parseRecordACL(rawACL []map[string]interface{}) (skydb.RecordACL, error) {
+	acl := skydb.RecordACL{}
+	for _, v := range rawACL {
+		ace := skydb.RecordACLEntry{}
+		if err := (*skyconv.MapACLEntry)(&ace).FromMap(v); err != nil {
+			return nil, err
+		}
+		acl = append(acl, ace)
+	}
+	return acl, nil
+}
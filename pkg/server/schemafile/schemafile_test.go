@@ -0,0 +1,124 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemafile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoad(t *testing.T) {
+	Convey("Load", t, func() {
+		Convey("loads schema file", func() {
+			file, err := Load(strings.NewReader(`{
+				"record_types": {
+					"note": {
+						"fields": [
+							{"name": "title", "type": "string"},
+							{"name": "category", "type": "ref(category)"}
+						],
+						"indexes": {
+							"note_title_key": {"fields": ["title"], "unique": true}
+						},
+						"default_access": [
+							{"public": true, "level": "read"}
+						]
+					},
+					"category": {
+						"fields": [
+							{"name": "name", "type": "string"}
+						]
+					}
+				},
+				"field_access": [
+					{
+						"record_type": "note",
+						"record_field": "title",
+						"user_role": "_any_user",
+						"writable": false,
+						"readable": true,
+						"comparable": true,
+						"discoverable": true
+					}
+				]
+			}`))
+			So(err, ShouldBeNil)
+
+			So(file.RecordTypes["note"].Fields, ShouldResemble, skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeString},
+				"category": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "category",
+				},
+			})
+			So(file.RecordTypes["note"].Indexes, ShouldResemble, map[string]skydb.Index{
+				"note_title_key": skydb.Index{
+					Fields: []string{"title"},
+					Unique: true,
+				},
+			})
+			So(file.RecordTypes["note"].DefaultAccess, ShouldResemble, skydb.RecordACL{
+				skydb.RecordACLEntry{Public: true, Level: skydb.ReadLevel},
+			})
+			So(file.RecordTypes["category"].DefaultAccess, ShouldBeNil)
+			So(file.FieldAccess, ShouldNotBeNil)
+			So(file.FieldAccess.AllEntries(), ShouldHaveLength, 1)
+		})
+
+		Convey("leaves access undefined if absent", func() {
+			file, err := Load(strings.NewReader(`{
+				"record_types": {
+					"note": {"fields": []}
+				}
+			}`))
+			So(err, ShouldBeNil)
+			So(file.RecordTypes["note"].DefaultAccess, ShouldBeNil)
+			So(file.FieldAccess, ShouldBeNil)
+		})
+
+		Convey("rejects reserved record type", func() {
+			_, err := Load(strings.NewReader(`{
+				"record_types": {"_user": {"fields": []}}
+			}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects reserved field", func() {
+			_, err := Load(strings.NewReader(`{
+				"record_types": {
+					"note": {"fields": [{"name": "_id", "type": "string"}]}
+				}
+			}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects unknown field type", func() {
+			_, err := Load(strings.NewReader(`{
+				"record_types": {
+					"note": {"fields": [{"name": "title", "type": "text"}]}
+				}
+			}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects invalid JSON", func() {
+			_, err := Load(strings.NewReader(`{`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -24,7 +24,6 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/joho/godotenv"
 	"github.com/skygeario/skygear-server/pkg/server/uuid"
 )
 
@@ -115,6 +114,15 @@ type PluginConfig struct {
 	Args      []string
 }
 
+// AssetPolicyConfig restricts the files that can be saved to a record
+// field as an asset.
+type AssetPolicyConfig struct {
+	ContentTypes []string `json:"content_types"`
+	MaxSize      int64    `json:"max_size"`
+	MaxWidth     int      `json:"max_width"`
+	MaxHeight    int      `json:"max_height"`
+}
+
 // Configuration is Skygear's configuration
 // The configuration will load in following order:
 // 1. The ENV
@@ -138,6 +146,25 @@ type Configuration struct {
 	DB struct {
 		ImplName string `json:"implementation"`
 		Option   string `json:"option"`
+
+		// Replicas are the option strings of the read replicas. Reads
+		// fall back to the primary if a replica lags behind longer than
+		// ReplicaMaxLag in milliseconds.
+		Replicas      []string `json:"replicas"`
+		ReplicaMaxLag int64    `json:"replica_max_lag"`
+
+		// Trash purges records soft-deleted longer than the retention
+		// period. Intervals are in seconds. The periodic job is disabled
+		// if Interval is zero.
+		Trash struct {
+			Interval  int64 `json:"interval"`
+			Retention int64 `json:"retention"`
+		} `json:"trash"`
+
+		// SlowQueryThreshold is the duration in milliseconds beyond
+		// which a record query is logged as slow. Slow queries are not
+		// logged if SlowQueryThreshold is zero.
+		SlowQueryThreshold int64 `json:"slow_query_threshold"`
 	} `json:"database"`
 	TokenStore struct {
 		ImplName string `json:"implementation"`
@@ -146,6 +173,16 @@ type Configuration struct {
 		Expiry   int64  `json:"expiry"`
 		Secret   string `json:"secret"`
 	} `json:"-"`
+	// QueryCache caches the results of queries against the public
+	// database. ImplName is either "memory" or "redis", and the cache is
+	// disabled if ImplName is empty. Path is the url of the redis server.
+	// Expiry is in seconds.
+	QueryCache struct {
+		ImplName string `json:"implementation"`
+		Path     string `json:"path"`
+		Prefix   string `json:"prefix"`
+		Expiry   int64  `json:"expiry"`
+	} `json:"query_cache"`
 	Auth struct {
 		CustomTokenSecret string `json:"custom_token_secret"`
 	} `json:"auth"`
@@ -153,6 +190,25 @@ type Configuration struct {
 		ImplName string `json:"implementation"`
 		Public   bool   `json:"public"`
 
+		// UploadStagingDir is the directory where chunks of resumable
+		// uploads are received before being put to the store. Chunks
+		// are staged in the store, so an upload resumes on any server.
+		UploadStagingDir string `json:"upload_staging_dir"`
+
+		// GC deletes assets not referenced by any record. Intervals are
+		// in seconds. The periodic job is disabled if Interval is zero.
+		GC struct {
+			Interval    int64 `json:"interval"`
+			GracePeriod int64 `json:"grace_period"`
+		} `json:"gc"`
+
+		// Policies restricts the files of assets saved to record
+		// fields. The key is in the form of "<record type>.<field name>".
+		// The content of an asset is checked when it is saved, so assets
+		// in a store which cannot read its files, such as the cloud
+		// store, are rejected from the fields with a policy.
+		Policies map[string]*AssetPolicyConfig `json:"policies"`
+
 		FileSystemStore struct {
 			Path      string `json:"-"`
 			URLPrefix string `json:"url_prefix"`
@@ -235,6 +291,17 @@ type Configuration struct {
 	Verification struct {
 		Required bool `json:"required"`
 	} `json:"verification"`
+	// Tenant hosts multiple apps in one server process. The apps are
+	// loaded from the JSON files in AppDir, or from the rows of
+	// RegistryTable in the database of DB.Option. The apps are reloaded
+	// every ReloadInterval seconds, so that apps can be added without
+	// restart. The server hosts the app of App if neither AppDir nor
+	// RegistryTable is set.
+	Tenant struct {
+		AppDir         string `json:"-"`
+		RegistryTable  string `json:"-"`
+		ReloadInterval int64  `json:"-"`
+	} `json:"-"`
 }
 
 func NewConfiguration() Configuration {
@@ -249,12 +316,17 @@ func NewConfiguration() Configuration {
 	config.App.ResponseTimeout = 60
 	config.DB.ImplName = "pq"
 	config.DB.Option = "postgres://postgres:@localhost/postgres?sslmode=disable"
+	config.DB.ReplicaMaxLag = 5000
+	config.DB.Trash.Retention = 2592000
 	config.TokenStore.ImplName = "fs"
 	config.TokenStore.Path = "data/token"
 	config.TokenStore.Expiry = 0
+	config.QueryCache.Expiry = 60
 	config.AssetStore.ImplName = "fs"
 	config.AssetStore.FileSystemStore.Path = "data/asset"
 	config.AssetStore.FileSystemStore.URLPrefix = "http://localhost:3000/files"
+	config.AssetStore.GC.GracePeriod = 86400
+	config.AssetStore.Policies = map[string]*AssetPolicyConfig{}
 	config.APNS.Enable = false
 	config.APNS.Type = "cert"
 	config.APNS.Env = "sandbox"
@@ -270,6 +342,7 @@ func NewConfiguration() Configuration {
 	config.Zmq.Timeout = 30
 	config.Zmq.MaxBounce = 10
 	config.Plugin = map[string]*PluginConfig{}
+	config.Tenant.ReloadInterval = 30
 	return config
 }
 
@@ -280,7 +353,16 @@ func NewConfigurationWithKeys() Configuration {
 	return config
 }
 
+// MultiTenant returns whether the server hosts multiple apps.
+func (config *Configuration) MultiTenant() bool {
+	return config.Tenant.AppDir != "" || config.Tenant.RegistryTable != ""
+}
+
 func (config *Configuration) Validate() error {
+	if config.MultiTenant() {
+		// The apps are validated when they are loaded.
+		return config.validateTenant()
+	}
 	if config.App.Name == "" {
 		return errors.New("APP_NAME is not set")
 	}
@@ -305,6 +387,21 @@ func (config *Configuration) Validate() error {
 	return config.checkAuthRecordKeysDuplication()
 }
 
+func (config *Configuration) validateTenant() error {
+	if config.Tenant.AppDir != "" && config.Tenant.RegistryTable != "" {
+		return errors.New("TENANT_APP_DIR and TENANT_REGISTRY_TABLE cannot be both set")
+	}
+	if config.Tenant.RegistryTable != "" {
+		if config.DB.ImplName != "pq" {
+			return errors.New("TENANT_REGISTRY_TABLE is only supported by DB_IMPL_NAME pq")
+		}
+		if !regexp.MustCompile("^[A-Za-z0-9_]+(\\.[A-Za-z0-9_]+)?$").MatchString(config.Tenant.RegistryTable) {
+			return fmt.Errorf("TENANT_REGISTRY_TABLE '%s' is not a valid table name", config.Tenant.RegistryTable)
+		}
+	}
+	return nil
+}
+
 func (config *Configuration) checkAuthRecordKeysDuplication() error {
 	check := map[string]interface{}{}
 	for _, result := range config.App.AuthRecordKeys {
@@ -321,7 +418,7 @@ func (config *Configuration) checkAuthRecordKeysDuplication() error {
 // ReadFromEnv reads from environment variable and update the configuration.
 // nolint: gocyclo
 func (config *Configuration) ReadFromEnv() {
-	envErr := godotenv.Load()
+	envErr := loadDotEnv()
 	if envErr != nil {
 		log.Print("Error in loading .env file")
 	}
@@ -370,6 +467,26 @@ func (config *Configuration) ReadFromEnv() {
 		config.DB.Option = os.Getenv("DATABASE_URL")
 	}
 
+	if replicas := os.Getenv("DATABASE_REPLICA_URLS"); replicas != "" {
+		config.DB.Replicas = parseCommaSeparatedString(replicas)
+	}
+
+	if maxLag, err := strconv.ParseInt(os.Getenv("DB_REPLICA_MAX_LAG"), 10, 64); err == nil {
+		config.DB.ReplicaMaxLag = maxLag
+	}
+
+	if interval, err := strconv.ParseInt(os.Getenv("DB_TRASH_PURGE_INTERVAL"), 10, 64); err == nil {
+		config.DB.Trash.Interval = interval
+	}
+
+	if retention, err := strconv.ParseInt(os.Getenv("DB_TRASH_RETENTION"), 10, 64); err == nil {
+		config.DB.Trash.Retention = retention
+	}
+
+	if threshold, err := strconv.ParseInt(os.Getenv("DB_SLOW_QUERY_THRESHOLD"), 10, 64); err == nil {
+		config.DB.SlowQueryThreshold = threshold
+	}
+
 	if slave, err := parseBool(os.Getenv("SLAVE")); err == nil {
 		config.App.Slave = slave
 	}
@@ -387,6 +504,7 @@ func (config *Configuration) ReadFromEnv() {
 	}
 
 	config.readTokenStore()
+	config.readQueryCache()
 	config.readAssetStore()
 	config.readAPNS()
 	config.readGCM()
@@ -395,6 +513,7 @@ func (config *Configuration) ReadFromEnv() {
 	config.readPlugins()
 	config.readUserAudit()
 	config.readUserVerification()
+	config.readTenant()
 }
 
 func (config *Configuration) readHost() {
@@ -438,6 +557,27 @@ func (config *Configuration) readTokenStore() {
 	}
 }
 
+func (config *Configuration) readQueryCache() {
+	queryCache := os.Getenv("QUERY_CACHE")
+	if queryCache != "" {
+		config.QueryCache.ImplName = queryCache
+	}
+
+	queryCachePath := os.Getenv("QUERY_CACHE_PATH")
+	if queryCachePath != "" {
+		config.QueryCache.Path = queryCachePath
+	}
+
+	queryCachePrefix := os.Getenv("QUERY_CACHE_PREFIX")
+	if queryCachePrefix != "" {
+		config.QueryCache.Prefix = queryCachePrefix
+	}
+
+	if expiry, err := strconv.ParseInt(os.Getenv("QUERY_CACHE_EXPIRY"), 10, 64); err == nil {
+		config.QueryCache.Expiry = expiry
+	}
+}
+
 func (config *Configuration) readAssetStore() {
 	assetStore := os.Getenv("ASSET_STORE")
 	if assetStore != "" {
@@ -448,6 +588,20 @@ func (config *Configuration) readAssetStore() {
 		config.AssetStore.Public = assetStorePublic
 	}
 
+	if stagingDir := os.Getenv("ASSET_STORE_UPLOAD_STAGING_DIR"); stagingDir != "" {
+		config.AssetStore.UploadStagingDir = stagingDir
+	}
+
+	if interval, err := strconv.ParseInt(os.Getenv("ASSET_STORE_GC_INTERVAL"), 10, 64); err == nil {
+		config.AssetStore.GC.Interval = interval
+	}
+
+	if gracePeriod, err := strconv.ParseInt(os.Getenv("ASSET_STORE_GC_GRACE_PERIOD"), 10, 64); err == nil {
+		config.AssetStore.GC.GracePeriod = gracePeriod
+	}
+
+	config.readAssetPolicies()
+
 	// Local Storage related
 	assetStorePath := os.Getenv("ASSET_STORE_PATH")
 	if assetStorePath != "" {
@@ -503,6 +657,25 @@ func (config *Configuration) readAssetStore() {
 	}
 }
 
+func (config *Configuration) readAssetPolicies() {
+	policies := parseCommaSeparatedString(os.Getenv("ASSET_STORE_POLICIES"))
+	for _, field := range policies {
+		prefix := "ASSET_STORE_POLICY_" + strings.ToUpper(strings.Replace(field, ".", "_", -1))
+		policy := &AssetPolicyConfig{}
+		policy.ContentTypes = parseCommaSeparatedString(os.Getenv(prefix + "_CONTENT_TYPES"))
+		if v, err := strconv.ParseInt(os.Getenv(prefix+"_MAX_SIZE"), 10, 64); err == nil {
+			policy.MaxSize = v
+		}
+		if v, err := strconv.Atoi(os.Getenv(prefix + "_MAX_WIDTH")); err == nil {
+			policy.MaxWidth = v
+		}
+		if v, err := strconv.Atoi(os.Getenv(prefix + "_MAX_HEIGHT")); err == nil {
+			policy.MaxHeight = v
+		}
+		config.AssetStore.Policies[field] = policy
+	}
+}
+
 func (config *Configuration) readAPNS() {
 	if shouldEnableAPNS, err := parseBool(os.Getenv("APNS_ENABLE")); err == nil {
 		config.APNS.Enable = shouldEnableAPNS
@@ -704,3 +877,17 @@ func (config *Configuration) readUserVerification() {
 		config.Verification.Required = v
 	}
 }
+
+func (config *Configuration) readTenant() {
+	if appDir := os.Getenv("TENANT_APP_DIR"); appDir != "" {
+		config.Tenant.AppDir = appDir
+	}
+
+	if registryTable := os.Getenv("TENANT_REGISTRY_TABLE"); registryTable != "" {
+		config.Tenant.RegistryTable = registryTable
+	}
+
+	if interval, err := strconv.ParseInt(os.Getenv("TENANT_RELOAD_INTERVAL"), 10, 64); err == nil {
+		config.Tenant.ReloadInterval = interval
+	}
+}
@@ -128,6 +128,44 @@ func TestRequiredConfig(t *testing.T) {
 			os.Setenv("TOKEN_STORE_EXPIRY", "")
 		})
 
+		Convey("Read tenant config correctly", func() {
+			config := NewConfiguration()
+			So(config.MultiTenant(), ShouldBeFalse)
+
+			os.Setenv("TENANT_APP_DIR", "apps")
+			os.Setenv("TENANT_RELOAD_INTERVAL", "10")
+
+			config.readTenant()
+			So(config.Tenant.AppDir, ShouldEqual, "apps")
+			So(config.Tenant.ReloadInterval, ShouldEqual, 10)
+			So(config.MultiTenant(), ShouldBeTrue)
+
+			os.Setenv("TENANT_APP_DIR", "")
+			os.Setenv("TENANT_RELOAD_INTERVAL", "")
+		})
+
+		Convey("Validate the tenant config", func() {
+			config := NewConfiguration()
+			config.Tenant.AppDir = "apps"
+			So(config.Validate(), ShouldBeNil)
+
+			config.Tenant.RegistryTable = "apps"
+			So(config.Validate(), ShouldNotBeNil)
+
+			config.Tenant.AppDir = ""
+			So(config.Validate(), ShouldBeNil)
+
+			config.Tenant.RegistryTable = "public.apps"
+			So(config.Validate(), ShouldBeNil)
+
+			config.Tenant.RegistryTable = "apps; DROP TABLE apps"
+			So(config.Validate(), ShouldNotBeNil)
+
+			config.Tenant.RegistryTable = "apps"
+			config.DB.ImplName = "mem"
+			So(config.Validate(), ShouldNotBeNil)
+		})
+
 		Convey("Read plugin config correctly", func() {
 			config := NewConfigurationWithKeys()
 			os.Setenv("PLUGINS", "CAT")
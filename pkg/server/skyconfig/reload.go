@@ -0,0 +1,141 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skyconfig
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// dotEnvKeys are the environment variables set from the .env file.
+var dotEnvKeys = map[string]bool{}
+
+// loadDotEnv sets the environment variables in the .env file, except the
+// ones set before the server starts. Unlike godotenv.Load, the variables
+// set from the .env file before are updated, so that the changes to the
+// .env file take effect when the configuration is read again.
+func loadDotEnv() error {
+	env, err := godotenv.Read()
+	if err != nil {
+		return err
+	}
+
+	for key := range dotEnvKeys {
+		if _, ok := env[key]; !ok {
+			os.Unsetenv(key)
+			delete(dotEnvKeys, key)
+		}
+	}
+
+	for key, value := range env {
+		if _, ok := os.LookupEnv(key); ok && !dotEnvKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		dotEnvKeys[key] = true
+	}
+	return nil
+}
+
+// LoggerSettings are the settings of loggers which can be applied
+// without restarting the server.
+var LoggerSettings = []string{
+	"LOG.Level",
+	"LOG.LoggersLevel",
+	"LOG.Formatter",
+}
+
+// AppSettings are the settings of an app which can be applied without
+// restarting the server. A setting is the path of a field of
+// Configuration, and includes the fields of the field.
+var AppSettings = []string{
+	"App.CORSHost",
+	"TokenStore.Expiry",
+	"UserAudit.PwMinLength",
+	"UserAudit.PwUppercaseRequired",
+	"UserAudit.PwLowercaseRequired",
+	"UserAudit.PwDigitRequired",
+	"UserAudit.PwSymbolRequired",
+	"UserAudit.PwMinGuessableLevel",
+	"UserAudit.PwExcludedKeywords",
+	"UserAudit.PwExcludedFields",
+	"APNS",
+	"GCM",
+	"Baidu",
+}
+
+// LiveSettings are the settings which can be applied without restarting
+// a server hosting a single app.
+var LiveSettings = append(append([]string{}, AppSettings...), LoggerSettings...)
+
+// ReloadReport reports the changed settings of a reloaded configuration.
+type ReloadReport struct {
+	// Applied are the changed settings which are applied.
+	Applied []string `json:"applied"`
+
+	// RestartRequired are the changed settings which are not applied
+	// until the server is restarted.
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Reload returns the configuration in effect after reloading config, in
+// which the settings in live are changed to those of config, and the
+// other settings are those of running.
+func Reload(running Configuration, config Configuration, live []string) (Configuration, ReloadReport) {
+	report := ReloadReport{
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+	reload(reflect.ValueOf(&running).Elem(), reflect.ValueOf(config), "", live, &report)
+	return running, report
+}
+
+// reload updates the settings of running in live to those of config,
+// and reports the changed settings.
+func reload(running reflect.Value, config reflect.Value, path string, live []string, report *ReloadReport) {
+	if reflect.DeepEqual(running.Interface(), config.Interface()) {
+		return
+	}
+
+	if running.Kind() != reflect.Struct {
+		if isLiveSetting(path, live) {
+			running.Set(config)
+			report.Applied = append(report.Applied, path)
+		} else {
+			report.RestartRequired = append(report.RestartRequired, path)
+		}
+		return
+	}
+
+	for i := 0; i < running.NumField(); i++ {
+		name := running.Type().Field(i).Name
+		if path != "" {
+			name = path + "." + name
+		}
+		reload(running.Field(i), config.Field(i), name, live, report)
+	}
+}
+
+func isLiveSetting(path string, live []string) bool {
+	for _, setting := range live {
+		if path == setting || strings.HasPrefix(path, setting+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skyconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReload(t *testing.T) {
+	Convey("Reload", t, func() {
+		running := NewConfigurationWithKeys()
+
+		Convey("applies live settings", func() {
+			config := running
+			config.LOG.Level = "info"
+			config.LOG.LoggersLevel = map[string]string{"router": "warn"}
+			config.App.CORSHost = "example.com"
+			config.UserAudit.PwMinLength = 8
+			config.APNS.CertConfig.Cert = "cert"
+
+			reloaded, report := Reload(running, config, LiveSettings)
+			So(reloaded.LOG.Level, ShouldEqual, "info")
+			So(reloaded.LOG.LoggersLevel, ShouldResemble, map[string]string{"router": "warn"})
+			So(reloaded.App.CORSHost, ShouldEqual, "example.com")
+			So(reloaded.UserAudit.PwMinLength, ShouldEqual, 8)
+			So(reloaded.APNS.CertConfig.Cert, ShouldEqual, "cert")
+			So(report.Applied, ShouldResemble, []string{
+				"App.CORSHost",
+				"APNS.CertConfig.Cert",
+				"LOG.Level",
+				"LOG.LoggersLevel",
+				"UserAudit.PwMinLength",
+			})
+			So(report.RestartRequired, ShouldBeEmpty)
+		})
+
+		Convey("reports settings requiring restart", func() {
+			config := running
+			config.App.APIKey = "new-api-key"
+			config.DB.Replicas = []string{"replica"}
+			config.UserAudit.PwHistorySize = 3
+			config.LOG.Level = "info"
+
+			reloaded, report := Reload(running, config, LiveSettings)
+			So(reloaded.App.APIKey, ShouldEqual, running.App.APIKey)
+			So(reloaded.DB.Replicas, ShouldBeNil)
+			So(reloaded.UserAudit.PwHistorySize, ShouldEqual, 0)
+			So(reloaded.LOG.Level, ShouldEqual, "info")
+			So(report.Applied, ShouldResemble, []string{"LOG.Level"})
+			So(report.RestartRequired, ShouldResemble, []string{
+				"App.APIKey",
+				"DB.Replicas",
+				"UserAudit.PwHistorySize",
+			})
+
+			Convey("until restart", func() {
+				_, report := Reload(reloaded, config, LiveSettings)
+				So(report.Applied, ShouldBeEmpty)
+				So(report.RestartRequired, ShouldResemble, []string{
+					"App.APIKey",
+					"DB.Replicas",
+					"UserAudit.PwHistorySize",
+				})
+			})
+		})
+
+		Convey("reports nothing without changes", func() {
+			_, report := Reload(running, running, LiveSettings)
+			So(report.Applied, ShouldBeEmpty)
+			So(report.RestartRequired, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	Convey("loadDotEnv", t, func() {
+		wd, err := os.Getwd()
+		So(err, ShouldBeNil)
+		dir, err := ioutil.TempDir("", "skygear-config")
+		So(err, ShouldBeNil)
+		So(os.Chdir(dir), ShouldBeNil)
+		defer func() {
+			os.Chdir(wd)
+			os.RemoveAll(dir)
+			os.Unsetenv("DOTENV_TEST_FILE")
+			os.Unsetenv("DOTENV_TEST_ENV")
+			dotEnvKeys = map[string]bool{}
+		}()
+
+		writeDotEnv := func(content string) {
+			So(ioutil.WriteFile(".env", []byte(content), 0644), ShouldBeNil)
+		}
+		os.Setenv("DOTENV_TEST_ENV", "env")
+
+		Convey("updates variables set from .env file", func() {
+			writeDotEnv("DOTENV_TEST_FILE=old\nDOTENV_TEST_ENV=file\n")
+			So(loadDotEnv(), ShouldBeNil)
+			So(os.Getenv("DOTENV_TEST_FILE"), ShouldEqual, "old")
+			So(os.Getenv("DOTENV_TEST_ENV"), ShouldEqual, "env")
+
+			writeDotEnv("DOTENV_TEST_FILE=new\n")
+			So(loadDotEnv(), ShouldBeNil)
+			So(os.Getenv("DOTENV_TEST_FILE"), ShouldEqual, "new")
+
+			writeDotEnv("")
+			So(loadDotEnv(), ShouldBeNil)
+			_, ok := os.LookupEnv("DOTENV_TEST_FILE")
+			So(ok, ShouldBeFalse)
+			So(os.Getenv("DOTENV_TEST_ENV"), ShouldEqual, "env")
+		})
+	})
+}
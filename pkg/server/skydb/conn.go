@@ -37,6 +37,22 @@ var ErrRoleUpdatesFailed = errors.New("skydb: Update of user roles failed")
 // cannot be found in the current container
 var ErrDeviceNotFound = errors.New("skydb: Specific device not found")
 
+// ErrScheduledPushNotFound is returned by Conn.GetScheduledPush if the
+// desired ScheduledPush cannot be found in the current container
+var ErrScheduledPushNotFound = errors.New("skydb: Specific scheduled push not found")
+
+// ErrAssetReferenced is returned by Conn.DeleteAsset if the Asset is
+// referenced by a record
+var ErrAssetReferenced = errors.New("skydb: asset is referenced by a record")
+
+// ErrUploadSessionNotFound is returned by Conn.GetUploadSession if the
+// desired UploadSession cannot be found in the current container
+var ErrUploadSessionNotFound = errors.New("skydb: Specific upload session not found")
+
+// ErrRecordRevisionNotFound is returned by Conn.GetRecordRevision if the
+// desired RecordRevision cannot be found in the current container
+var ErrRecordRevisionNotFound = errors.New("skydb: Specific record revision not found")
+
 // ErrDatabaseIsReadOnly is returned by skydb.Database if the requested
 // operation modifies the database and the database is readonly.
 var ErrDatabaseIsReadOnly = errors.New("skydb: database is read only")
@@ -160,6 +176,71 @@ type Conn interface {
 	// be referenced by records.
 	SaveAsset(asset *Asset) error
 
+	// QueryUnreferencedAssets returns Assets last saved before the
+	// specified time that are not referenced by any asset field of any
+	// record type.
+	QueryUnreferencedAssets(savedBefore time.Time) ([]Asset, error)
+
+	// DeleteAsset deletes the Asset information of the specified name.
+	//
+	// DeleteAsset returns ErrAssetReferenced if the Asset is referenced
+	// by a record.
+	DeleteAsset(name string) error
+
+	// GetUploadSession fetches the UploadSession with the supplied ID.
+	//
+	// GetUploadSession returns ErrUploadSessionNotFound if no UploadSession
+	// exists for the supplied ID.
+	GetUploadSession(id string, session *UploadSession) error
+
+	// GetUploadSessionForUpdate fetches the UploadSession with the
+	// supplied ID like GetUploadSession, and locks it until the end of
+	// the current transaction, so that an upload is written by one
+	// request at a time.
+	GetUploadSessionForUpdate(id string, session *UploadSession) error
+
+	// SaveUploadSession creates or updates the supplied UploadSession.
+	SaveUploadSession(session *UploadSession) error
+
+	// DeleteUploadSession deletes the UploadSession with the supplied ID.
+	DeleteUploadSession(id string) error
+
+	// QueryExpiredUploadSessions returns the UploadSessions which have
+	// expired at the supplied time.
+	QueryExpiredUploadSessions(t time.Time) ([]UploadSession, error)
+
+	// GetRecordHistoryTypes returns the record types with history enabled.
+	GetRecordHistoryTypes() ([]string, error)
+
+	// SetRecordHistoryEnabled enables or disables keeping history of
+	// records of the specified type.
+	SetRecordHistoryEnabled(recordType string, enabled bool) error
+
+	// SaveRecordRevision appends the supplied RecordRevision to the
+	// history of its record. The ID, Version and CreatedAt of the
+	// revision are filled in.
+	//
+	// It should be called in the transaction saving the record, in which
+	// the version is allocated under a lock of the record, so that
+	// concurrent revisions of a record get distinct versions.
+	SaveRecordRevision(revision *RecordRevision) error
+
+	// GetRecordRevisions returns the history of the specified record,
+	// with the latest revision first.
+	GetRecordRevisions(databaseID string, id RecordID) ([]RecordRevision, error)
+
+	// GetRecordRevision fetches the revision of the specified record
+	// at version.
+	//
+	// GetRecordRevision returns ErrRecordRevisionNotFound if no such
+	// revision exists.
+	GetRecordRevision(databaseID string, id RecordID, version int, revision *RecordRevision) error
+
+	// PurgeDeletedRecords removes records of all record types that are
+	// soft-deleted before the specified time, and returns the number of
+	// records removed. A record referenced by other records is kept.
+	PurgeDeletedRecords(deletedBefore time.Time) (uint64, error)
+
 	QueryRelation(user string, name string, direction string, config QueryConfig) []AuthInfo
 	QueryRelationCount(user string, name string, direction string) (uint64, error)
 	AddRelation(user string, name string, targetUser string) error
@@ -186,6 +267,47 @@ type Conn interface {
 	// If such device does not exist, ErrDeviceNotFound is returned.
 	DeleteEmptyDevicesByTime(t time.Time) error
 
+	// GetScheduledPush fetches the ScheduledPush with the supplied ID.
+	//
+	// GetScheduledPush returns ErrScheduledPushNotFound if no ScheduledPush
+	// exists for the supplied ID.
+	GetScheduledPush(id string, push *ScheduledPush) error
+
+	// SaveScheduledPush creates or updates the supplied ScheduledPush.
+	SaveScheduledPush(push *ScheduledPush) error
+
+	// CancelScheduledPush sets the status of the pending or dispatching
+	// ScheduledPush with the supplied ID to ScheduledPushCancelled, and
+	// fetches the cancelled ScheduledPush into push.
+	//
+	// CancelScheduledPush returns ErrScheduledPushNotFound if no pending
+	// or dispatching ScheduledPush exists for the supplied ID.
+	CancelScheduledPush(id string, push *ScheduledPush) error
+
+	// ClaimDueScheduledPushes claims the pending ScheduledPush which are
+	// due to be sent to at least one device at or before t, and returns
+	// them with status ScheduledPushDispatching.
+	//
+	// A claimed ScheduledPush is not claimed again until claimUntil, so
+	// that it is sent by one server only. A ScheduledPush claimed by a
+	// server which stopped before releasing it is claimed again after
+	// claimUntil.
+	ClaimDueScheduledPushes(t time.Time, claimUntil time.Time) ([]ScheduledPush, error)
+
+	// MarkScheduledPushSent records that the claimed ScheduledPush is
+	// sent to the devices of deviceIDs.
+	//
+	// MarkScheduledPushSent returns ErrScheduledPushNotFound if the
+	// ScheduledPush is no longer being dispatched, such as when it is
+	// cancelled.
+	MarkScheduledPushSent(id string, deviceIDs []string) error
+
+	// ReleaseScheduledPush sets the status of the claimed ScheduledPush,
+	// unless it is no longer being dispatched, such as when it is
+	// cancelled. A ScheduledPush released as pending is not claimed
+	// again until dueAt.
+	ReleaseScheduledPush(id string, status ScheduledPushStatus, dueAt time.Time) error
+
 	PublicDB() Database
 	PrivateDB(userKey string) Database
 	UnionDB() Database
@@ -251,6 +373,44 @@ type CustomTokenConn interface {
 	DeleteCustomTokenInfo(principalID string) error
 }
 
+// ReplicaConn is implemented by a Conn which can read from the read
+// replicas of its database.
+type ReplicaConn interface {
+	// RouteReadsToReplica routes the reads of the Conn to a read replica
+	// until the returned function is called. Reads may return stale
+	// data, so it is for the operations which are read-only as a whole.
+	// Reads stay on the primary in a transaction or after the Conn has
+	// written to the primary.
+	RouteReadsToReplica() func()
+}
+
+// RouteReadsToReplica routes the reads of conn to a read replica until
+// the returned function is called, if conn is a ReplicaConn.
+func RouteReadsToReplica(conn Conn) func() {
+	if replicaConn, ok := conn.(ReplicaConn); ok {
+		return replicaConn.RouteReadsToReplica()
+	}
+	return func() {}
+}
+
+// CachedConn is implemented by a Conn caching the results of queries.
+type CachedConn interface {
+	// BypassQueryCache makes queries of the Conn neither cached nor read
+	// from cache until the returned function is called. It is for
+	// operations reading many records, whose results are not worth
+	// caching.
+	BypassQueryCache() func()
+}
+
+// BypassQueryCache bypasses the query cache of conn until the returned
+// function is called, if conn is a CachedConn.
+func BypassQueryCache(conn Conn) func() {
+	if cachedConn, ok := conn.(CachedConn); ok {
+		return cachedConn.BypassQueryCache()
+	}
+	return func() {}
+}
+
 // AccessModel indicates the type of access control model while db query.
 //go:generate stringer -type=AccessModel
 type AccessModel int
@@ -0,0 +1,278 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// Names of the constraints of a field, reported in ConstraintViolation.
+const (
+	RequiredConstraint = "required"
+	UniqueConstraint   = "unique"
+	PatternConstraint  = "pattern"
+	MinConstraint      = "min"
+	MaxConstraint      = "max"
+	EnumConstraint     = "enum"
+	OnDeleteConstraint = "on_delete"
+)
+
+// ReferenceAction is what happens to a record referencing a record which
+// is deleted.
+type ReferenceAction string
+
+// List of ReferenceAction
+const (
+	// RestrictReference fails the deletion of the referenced record.
+	RestrictReference ReferenceAction = "restrict"
+
+	// CascadeReference deletes the referencing record with the
+	// referenced record.
+	CascadeReference ReferenceAction = "cascade"
+
+	// SetNullReference sets the referencing field to null.
+	SetNullReference ReferenceAction = "set_null"
+)
+
+// FieldConstraints are the rules the value of a field must satisfy. A zero
+// value of a rule means the rule does not apply.
+type FieldConstraints struct {
+	Required bool `json:"required,omitempty" mapstructure:"required"`
+	Unique   bool `json:"unique,omitempty" mapstructure:"unique"`
+
+	// Default is the value of the field when a record is created
+	// without the field.
+	Default interface{} `json:"default,omitempty" mapstructure:"default"`
+
+	// Pattern is the regular expression a string value must match.
+	Pattern string `json:"pattern,omitempty" mapstructure:"pattern"`
+
+	// Min and Max are the inclusive range of a number value.
+	Min *float64 `json:"min,omitempty" mapstructure:"min"`
+	Max *float64 `json:"max,omitempty" mapstructure:"max"`
+
+	// Enum is the list of values the field may take.
+	Enum []interface{} `json:"enum,omitempty" mapstructure:"enum"`
+
+	// OnDelete is the action on a record when the record referenced by
+	// a reference field is deleted.
+	OnDelete ReferenceAction `json:"on_delete,omitempty" mapstructure:"on_delete"`
+}
+
+// IsEmpty returns true if no rules are specified.
+func (c FieldConstraints) IsEmpty() bool {
+	return reflect.DeepEqual(c, FieldConstraints{})
+}
+
+// Equal returns true if both constraints have the same rules. Nil
+// constraints are equal to empty constraints. Values are compared in their
+// JSON form so that numbers of different Go types are equal.
+func (c *FieldConstraints) Equal(other *FieldConstraints) bool {
+	if c == nil || c.IsEmpty() {
+		return other == nil || other.IsEmpty()
+	}
+	if other == nil {
+		return false
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return false
+	}
+	otherData, err := json.Marshal(other)
+	if err != nil {
+		return false
+	}
+	return string(data) == string(otherData)
+}
+
+// ValidateDefinition returns an error if the constraints cannot be applied
+// to a field of the specified type.
+func (c FieldConstraints) ValidateDefinition(fieldType FieldType) error {
+	if c.Pattern != "" {
+		if fieldType.Type != TypeString {
+			return fmt.Errorf("pattern is only applicable to string field")
+		}
+		if _, err := regexp.Compile(c.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+
+	if c.Min != nil || c.Max != nil {
+		if !fieldType.Type.IsNumberCompatibleType() {
+			return fmt.Errorf("min and max are only applicable to number field")
+		}
+		if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+			return fmt.Errorf("min is greater than max")
+		}
+	}
+
+	if c.Unique || c.Default != nil || len(c.Enum) > 0 {
+		switch fieldType.Type {
+		case TypeString, TypeNumber, TypeInteger, TypeBoolean:
+		default:
+			return fmt.Errorf(
+				"unique, default and enum are not applicable to %s field",
+				fieldType.ToSimpleName(),
+			)
+		}
+	}
+
+	if c.OnDelete != "" {
+		if fieldType.Type != TypeReference {
+			return fmt.Errorf("on_delete is only applicable to reference field")
+		}
+		switch c.OnDelete {
+		case RestrictReference, CascadeReference:
+		case SetNullReference:
+			if c.Required {
+				return fmt.Errorf("on_delete set_null is not applicable to required field")
+			}
+		default:
+			return fmt.Errorf("unknown on_delete action %s", c.OnDelete)
+		}
+	}
+
+	for _, value := range c.Enum {
+		if !isScalarOfType(fieldType.Type, value) {
+			return fmt.Errorf("enum value %v is not a %s", value, fieldType.ToSimpleName())
+		}
+	}
+
+	if c.Default != nil {
+		if !isScalarOfType(fieldType.Type, c.Default) {
+			return fmt.Errorf("default value %v is not a %s", c.Default, fieldType.ToSimpleName())
+		}
+		if err := c.Validate(c.Default); err != nil {
+			return fmt.Errorf("default value violates constraint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ConstraintViolation is returned when a value violates a rule of
+// FieldConstraints.
+type ConstraintViolation struct {
+	Constraint string
+	Message    string
+}
+
+func (e *ConstraintViolation) Error() string {
+	return e.Message
+}
+
+// Validate checks the value of a field against the constraints. A nil
+// value only violates the required rule. Uniqueness is not checked.
+func (c FieldConstraints) Validate(value interface{}) error {
+	if value == nil {
+		if c.Required {
+			return &ConstraintViolation{RequiredConstraint, "value is required"}
+		}
+		return nil
+	}
+
+	if c.Pattern != "" {
+		if s, ok := value.(string); ok {
+			matched, err := regexp.MatchString(c.Pattern, s)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				return &ConstraintViolation{
+					PatternConstraint,
+					fmt.Sprintf("value does not match pattern %s", c.Pattern),
+				}
+			}
+		}
+	}
+
+	if number, ok := toFloat64(value); ok {
+		if c.Min != nil && number < *c.Min {
+			return &ConstraintViolation{
+				MinConstraint,
+				fmt.Sprintf("value is less than %v", *c.Min),
+			}
+		}
+		if c.Max != nil && number > *c.Max {
+			return &ConstraintViolation{
+				MaxConstraint,
+				fmt.Sprintf("value is greater than %v", *c.Max),
+			}
+		}
+	}
+
+	if len(c.Enum) > 0 && !containsValue(c.Enum, value) {
+		return &ConstraintViolation{
+			EnumConstraint,
+			fmt.Sprintf("value is not one of %v", c.Enum),
+		}
+	}
+
+	return nil
+}
+
+func isScalarOfType(dataType DataType, value interface{}) bool {
+	switch dataType {
+	case TypeString:
+		_, ok := value.(string)
+		return ok
+	case TypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case TypeNumber:
+		_, ok := toFloat64(value)
+		return ok
+	case TypeInteger:
+		number, ok := toFloat64(value)
+		return ok && number == float64(int64(number))
+	}
+	return false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	number, isNumber := toFloat64(value)
+	for _, v := range values {
+		if isNumber {
+			if n, ok := toFloat64(v); ok && n == number {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldConstraints(t *testing.T) {
+	min := 1.0
+	max := 5.0
+
+	Convey("FieldConstraints", t, func() {
+		Convey("validates required value", func() {
+			c := FieldConstraints{Required: true}
+			So(c.Validate("a"), ShouldBeNil)
+
+			err := c.Validate(nil)
+			So(err, ShouldHaveSameTypeAs, &ConstraintViolation{})
+			So(err.(*ConstraintViolation).Constraint, ShouldEqual, RequiredConstraint)
+		})
+
+		Convey("allows nil value if not required", func() {
+			c := FieldConstraints{Pattern: "^a", Min: &min}
+			So(c.Validate(nil), ShouldBeNil)
+		})
+
+		Convey("validates pattern", func() {
+			c := FieldConstraints{Pattern: "^[a-z]+$"}
+			So(c.Validate("abc"), ShouldBeNil)
+			So(c.Validate("ABC").(*ConstraintViolation).Constraint, ShouldEqual, PatternConstraint)
+		})
+
+		Convey("validates range", func() {
+			c := FieldConstraints{Min: &min, Max: &max}
+			So(c.Validate(1), ShouldBeNil)
+			So(c.Validate(5.0), ShouldBeNil)
+			So(c.Validate(0.5).(*ConstraintViolation).Constraint, ShouldEqual, MinConstraint)
+			So(c.Validate(int64(6)).(*ConstraintViolation).Constraint, ShouldEqual, MaxConstraint)
+		})
+
+		Convey("validates enum", func() {
+			c := FieldConstraints{Enum: []interface{}{"draft", "published"}}
+			So(c.Validate("draft"), ShouldBeNil)
+			So(c.Validate("deleted").(*ConstraintViolation).Constraint, ShouldEqual, EnumConstraint)
+
+			c = FieldConstraints{Enum: []interface{}{float64(1), float64(2)}}
+			So(c.Validate(2), ShouldBeNil)
+			So(c.Validate(3), ShouldNotBeNil)
+		})
+
+		Convey("validates definition", func() {
+			stringType := FieldType{Type: TypeString}
+			numberType := FieldType{Type: TypeNumber}
+
+			So(FieldConstraints{Pattern: "^a"}.ValidateDefinition(stringType), ShouldBeNil)
+			So(FieldConstraints{Pattern: "("}.ValidateDefinition(stringType), ShouldNotBeNil)
+			So(FieldConstraints{Pattern: "^a"}.ValidateDefinition(numberType), ShouldNotBeNil)
+			So(FieldConstraints{Min: &max, Max: &min}.ValidateDefinition(numberType), ShouldNotBeNil)
+			So(FieldConstraints{Unique: true}.ValidateDefinition(FieldType{Type: TypeJSON}), ShouldNotBeNil)
+			So(FieldConstraints{Enum: []interface{}{"a", 1}}.ValidateDefinition(stringType), ShouldNotBeNil)
+			So(FieldConstraints{Default: "a"}.ValidateDefinition(numberType), ShouldNotBeNil)
+			So(FieldConstraints{Default: 10.0, Max: &max}.ValidateDefinition(numberType), ShouldNotBeNil)
+			So(FieldConstraints{Default: 3.0, Max: &max}.ValidateDefinition(numberType), ShouldBeNil)
+
+			refType := FieldType{Type: TypeReference, ReferenceType: "note"}
+			So(FieldConstraints{OnDelete: CascadeReference}.ValidateDefinition(refType), ShouldBeNil)
+			So(FieldConstraints{OnDelete: SetNullReference}.ValidateDefinition(refType), ShouldBeNil)
+			So(FieldConstraints{OnDelete: SetNullReference, Required: true}.ValidateDefinition(refType), ShouldNotBeNil)
+			So(FieldConstraints{OnDelete: "ignore"}.ValidateDefinition(refType), ShouldNotBeNil)
+			So(FieldConstraints{OnDelete: CascadeReference}.ValidateDefinition(stringType), ShouldNotBeNil)
+		})
+
+		Convey("compares constraints", func() {
+			var nilConstraints *FieldConstraints
+			So(nilConstraints.Equal(&FieldConstraints{}), ShouldBeTrue)
+			So((&FieldConstraints{Default: 1}).Equal(&FieldConstraints{Default: 1.0}), ShouldBeTrue)
+			So((&FieldConstraints{Required: true}).Equal(nil), ShouldBeFalse)
+			So((&FieldConstraints{Min: &min}).Equal(&FieldConstraints{Min: &max}), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"fmt"
+)
+
+// MaxReportedConversionFailures is the maximum number of records reported
+// in TypeConversionReport.FailedRecordIDs.
+const MaxReportedConversionFailures = 100
+
+// TypeConversion is a rule to convert existing values of a field when the
+// type of the field is altered.
+type TypeConversion struct {
+	From DataType
+	To   DataType
+
+	// Description describes how the values are converted.
+	Description string
+}
+
+// TypeConversionReport reports the records of which the value of a field
+// cannot be converted by a TypeConversion.
+type TypeConversionReport struct {
+	Conversion TypeConversion
+
+	// FailedCount is the number of records failing the conversion.
+	FailedCount uint64
+
+	// FailedRecordIDs are the IDs of the records failing the conversion,
+	// limited to MaxReportedConversionFailures records.
+	FailedRecordIDs []RecordID
+}
+
+var typeConversions = []TypeConversion{
+	{TypeString, TypeNumber, "strings are parsed as decimal numbers"},
+	{TypeString, TypeInteger, "strings are parsed as decimal integers"},
+	{TypeString, TypeBoolean, "true, false, t, f, yes, no, y, n, on, off, 1 and 0 are accepted, case-insensitive"},
+	{TypeString, TypeDateTime, "strings are parsed as ISO 8601 timestamps and converted to UTC"},
+	{TypeNumber, TypeString, "numbers are formatted as decimal"},
+	{TypeNumber, TypeInteger, "numbers are rounded to the nearest integer"},
+	{TypeNumber, TypeDateTime, "numbers are seconds since the Unix epoch"},
+	{TypeInteger, TypeString, "integers are formatted as decimal"},
+	{TypeInteger, TypeNumber, "integers are converted exactly"},
+	{TypeInteger, TypeBoolean, "zero is false and other integers are true"},
+	{TypeInteger, TypeDateTime, "integers are seconds since the Unix epoch"},
+	{TypeBoolean, TypeString, `booleans are formatted as "true" or "false"`},
+	{TypeBoolean, TypeNumber, "true is 1 and false is 0"},
+	{TypeBoolean, TypeInteger, "true is 1 and false is 0"},
+	{TypeDateTime, TypeString, "datetimes are formatted as ISO 8601 timestamps in UTC"},
+	{TypeDateTime, TypeNumber, "datetimes are converted to seconds since the Unix epoch"},
+	{TypeDateTime, TypeInteger, "datetimes are converted to whole seconds since the Unix epoch"},
+	{TypeJSON, TypeString, "JSON strings are unquoted and other JSON values are formatted as JSON text"},
+}
+
+// GetTypeConversion returns the rule to convert values of a field from a
+// type to another, or an error if the conversion is not supported.
+func GetTypeConversion(from FieldType, to FieldType) (TypeConversion, error) {
+	if from.Type == to.Type {
+		return TypeConversion{}, fmt.Errorf("field is already of type %s", to.ToSimpleName())
+	}
+
+	for _, conversion := range typeConversions {
+		if conversion.From == from.Type && conversion.To == to.Type {
+			return conversion, nil
+		}
+	}
+	return TypeConversion{}, fmt.Errorf(
+		"conversion from %s to %s is not supported",
+		from.ToSimpleName(),
+		to.ToSimpleName(),
+	)
+}
@@ -0,0 +1,46 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetTypeConversion(t *testing.T) {
+	Convey("GetTypeConversion", t, func() {
+		Convey("returns conversion of supported types", func() {
+			conversion, err := GetTypeConversion(FieldType{Type: TypeString}, FieldType{Type: TypeInteger})
+			So(err, ShouldBeNil)
+			So(conversion.From, ShouldEqual, TypeString)
+			So(conversion.To, ShouldEqual, TypeInteger)
+			So(conversion.Description, ShouldNotBeEmpty)
+		})
+
+		Convey("rejects same type", func() {
+			_, err := GetTypeConversion(FieldType{Type: TypeString}, FieldType{Type: TypeString})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects unsupported conversion", func() {
+			_, err := GetTypeConversion(
+				FieldType{Type: TypeString},
+				FieldType{Type: TypeReference, ReferenceType: "note"},
+			)
+			So(err.Error(), ShouldEqual, "conversion from string to ref(note) is not supported")
+		})
+	})
+}
@@ -110,8 +110,15 @@ type Database interface {
 	Get(id RecordID, record *Record) error
 	GetByIDs(ids []RecordID, accessControlOptions *AccessControlOptions) (*Rows, error)
 
+	// GetForUpdate fetches the Record like Get, and locks the Record
+	// until the end of the transaction, so that the Record is not
+	// modified by other transactions before it is saved. It should be
+	// called in a transaction.
+	GetForUpdate(id RecordID, record *Record) error
+
 	// Save updates the supplied Record in the Database if Record with
-	// the same key exists, else such Record is created.
+	// the same key exists, else such Record is created. A soft-deleted
+	// Record stays deleted, as it is restored only by Undelete.
 	//
 	// Save returns an error if the underlying implementation failed to
 	// create / modify the Record.
@@ -133,6 +140,14 @@ type Database interface {
 	// the number of records matching the query's predicate.
 	QueryCount(query *Query, accessControlOptions *AccessControlOptions) (uint64, error)
 
+	// ExplainQuery executes the supplied query against the Database and
+	// returns the generated statement together with its execution plan.
+	// The query is executed again even if its records are already queried.
+	//
+	// The returned QueryExplanation is empty if the record type of the
+	// query does not exist.
+	ExplainQuery(query *Query, accessControlOptions *AccessControlOptions) (QueryExplanation, error)
+
 	// Extend extends the Database record schema such that a record
 	// arrived subsequently with that schema can be saved
 	//
@@ -147,6 +162,30 @@ type Database interface {
 	// DeleteSchema removes a column of the Database record schema
 	DeleteSchema(recordType, columnName string) error
 
+	// AlterSchemaType changes the type of a column of the Database record
+	// schema, converting existing values by the TypeConversion of the
+	// types. If dryRun is true, the schema is left unchanged and the
+	// records failing the conversion are reported only.
+	//
+	// AlterSchemaType returns an error if any record fails the conversion
+	// when dryRun is false.
+	AlterSchemaType(recordType, columnName string, fieldType FieldType, dryRun bool) (TypeConversionReport, error)
+
+	// SetRecordSoftDelete enables or disables soft delete of a record
+	// type. When enabled, Delete marks a record as deleted instead of
+	// removing it, and such record is excluded from Get, GetByIDs and
+	// Query unless Query.IncludeDeleted is true.
+	//
+	// SetRecordSoftDelete returns an error when disabling soft delete of
+	// a record type that has soft-deleted records.
+	SetRecordSoftDelete(recordType string, enabled bool) error
+
+	// Undelete restores a soft-deleted Record identified by the key.
+	//
+	// Undelete returns an ErrRecordNotFound if no soft-deleted Record
+	// is identified by the supplied key.
+	Undelete(id RecordID) error
+
 	// GetSchema returns the record schema of a record type
 	GetSchema(recordType string) (RecordSchema, error)
 
@@ -17,6 +17,7 @@ package skydb
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -66,6 +67,15 @@ func GetAccessModel(accessString string) AccessModel {
 type DBConfig struct {
 	CanMigrate             bool
 	PasswordHistoryEnabled bool
+
+	// ReplicaOptions are the option strings of the read replicas.
+	// Reads within RouteReadsToReplica are routed to the replicas if
+	// supported by the implementation.
+	ReplicaOptions []string
+
+	// ReplicaMaxLag is the replication lag beyond which reads fall back
+	// to the primary. There is no limit if ReplicaMaxLag is zero.
+	ReplicaMaxLag time.Duration
 }
 
 // DBOpener aliases the function for opening Conn
@@ -84,3 +94,18 @@ func Open(ctx context.Context, implName string, appName string, accessString str
 
 	return nil, fmt.Errorf("Implementation not registered: %v", implName)
 }
+
+// CloseApp releases the resources kept by the implementation implName
+// for the database of the app, such as its connection pool. It does
+// nothing if the implementation keeps no resources.
+func CloseApp(implName string, appName string, optionString string) error {
+	driver, ok := drivers[implName]
+	if !ok {
+		return fmt.Errorf("Implementation not registered: %v", implName)
+	}
+
+	if closer, ok := driver.(AppCloser); ok {
+		return closer.CloseApp(appName, optionString)
+	}
+	return nil
+}
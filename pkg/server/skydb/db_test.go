@@ -78,3 +78,36 @@ func TestOpen(t *testing.T) {
 		}
 	}
 }
+
+type fakeAppCloser struct {
+	fakeDriver
+	closed []string
+}
+
+func (driver *fakeAppCloser) CloseApp(appName string, optionString string) error {
+	driver.closed = append(driver.closed, appName+" "+optionString)
+	return nil
+}
+
+func TestCloseApp(t *testing.T) {
+	defer unregisterAllDrivers()
+
+	closer := &fakeAppCloser{}
+	Register("fakeImpl", fakeDriver{})
+	Register("fakeCloserImpl", closer)
+
+	if err := CloseApp("fakeImpl", "app", "fakeOption"); err != nil {
+		t.Fatalf("got err: %v, want nil", err)
+	}
+
+	if err := CloseApp("fakeCloserImpl", "app", "fakeOption"); err != nil {
+		t.Fatalf("got err: %v, want nil", err)
+	}
+	if len(closer.closed) != 1 || closer.closed[0] != "app fakeOption" {
+		t.Fatalf("got closed = %v, want [\"app fakeOption\"]", closer.closed)
+	}
+
+	if err := CloseApp("missingImpl", "app", "fakeOption"); err == nil {
+		t.Fatalf("got err = nil, want an error")
+	}
+}
@@ -23,5 +23,6 @@ type Device struct {
 	Token            string
 	AuthInfoID       string
 	Topic            string
+	TimeZone         string
 	LastRegisteredAt time.Time
 }
@@ -31,3 +31,11 @@ type DriverFunc func(ctx context.Context, appName string, accessModel AccessMode
 func (f DriverFunc) Open(ctx context.Context, appName string, accessModel AccessModel, name string, config DBConfig) (Conn, error) {
 	return f(ctx, appName, accessModel, name, config)
 }
+
+// An AppCloser is a Driver which keeps resources, such as a connection
+// pool, for the database of an app across the Conns of the app.
+type AppCloser interface {
+	// CloseApp releases the resources kept for the database of the app.
+	// The database can be opened again afterwards.
+	CloseApp(appName string, optionString string) error
+}
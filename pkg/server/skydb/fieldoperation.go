@@ -0,0 +1,179 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// FieldOperator is an operator that modifies the value of a record
+// field based on its current value.
+type FieldOperator string
+
+// List of FieldOperator
+const (
+	// IncrementOperator adds a number to a number field.
+	IncrementOperator FieldOperator = "inc"
+
+	// PushOperator appends items to a JSON array field.
+	PushOperator FieldOperator = "push"
+
+	// PullOperator removes all occurrences of items from a JSON array
+	// field.
+	PullOperator FieldOperator = "pull"
+
+	// MaxOperator sets a number field to a number if the number is
+	// greater than the current value.
+	MaxOperator FieldOperator = "max"
+)
+
+// FieldOperation is a value of record data that modifies the current
+// value of the field instead of replacing it. Drivers are expected to
+// apply the operation atomically in the database.
+//
+// Value is a number for IncrementOperator and MaxOperator, and a
+// []interface{} for PushOperator and PullOperator.
+type FieldOperation struct {
+	Operator FieldOperator
+	Value    interface{}
+}
+
+// Validate checks whether the value is valid for the operator.
+func (op FieldOperation) Validate() error {
+	switch op.Operator {
+	case IncrementOperator, MaxOperator:
+		if _, ok := fieldOperationNumber(op.Value); !ok {
+			return fmt.Errorf("$%s expects a number, got %T", op.Operator, op.Value)
+		}
+	case PushOperator, PullOperator:
+		if _, ok := op.Value.([]interface{}); !ok {
+			return fmt.Errorf("$%s expects an array, got %T", op.Operator, op.Value)
+		}
+	default:
+		return fmt.Errorf("unknown field operator $%s", op.Operator)
+	}
+	return nil
+}
+
+// FieldType returns the type of field that the operation applies to.
+func (op FieldOperation) FieldType() FieldType {
+	switch op.Operator {
+	case PushOperator, PullOperator:
+		return FieldType{Type: TypeJSON}
+	default:
+		return FieldType{Type: TypeNumber}
+	}
+}
+
+// CheckFieldType returns an InvalidArgument error naming the field and
+// the operator if the operation cannot be applied to a field of the type.
+// IncrementOperator and MaxOperator are applied to number and integer
+// fields, and PushOperator and PullOperator to JSON fields holding lists.
+func (op FieldOperation) CheckFieldType(field string, fieldType FieldType) skyerr.Error {
+	ok := false
+	switch op.Operator {
+	case IncrementOperator, MaxOperator:
+		ok = fieldType.Type == TypeNumber || fieldType.Type == TypeInteger
+	case PushOperator, PullOperator:
+		ok = fieldType.Type == TypeJSON
+	}
+	if ok {
+		return nil
+	}
+
+	return skyerr.NewErrorWithInfo(
+		skyerr.InvalidArgument,
+		fmt.Sprintf("$%s cannot be applied to field %s of type %s", op.Operator, field, fieldType.ToSimpleName()),
+		map[string]interface{}{
+			"arguments": []string{field},
+			"field":     field,
+			"operator":  "$" + string(op.Operator),
+			"type":      fieldType.ToSimpleName(),
+		},
+	)
+}
+
+// Apply returns the result of applying the operation on the value. A
+// nil value is treated as a field that is not set yet, in which case
+// the result is the value of the operation, or an empty array for
+// PullOperator.
+func (op FieldOperation) Apply(value interface{}) (interface{}, error) {
+	if err := op.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch op.Operator {
+	case IncrementOperator, MaxOperator:
+		operand, _ := fieldOperationNumber(op.Value)
+		if value == nil {
+			return operand, nil
+		}
+		current, ok := fieldOperationNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("$%s cannot be applied to a value of type %T", op.Operator, value)
+		}
+		if op.Operator == IncrementOperator {
+			return current + operand, nil
+		}
+		return math.Max(current, operand), nil
+	default:
+		items := op.Value.([]interface{})
+		current := []interface{}{}
+		if value != nil {
+			var ok bool
+			if current, ok = value.([]interface{}); !ok {
+				return nil, fmt.Errorf("$%s cannot be applied to a value of type %T", op.Operator, value)
+			}
+		}
+
+		result := []interface{}{}
+		if op.Operator == PushOperator {
+			result = append(result, current...)
+			return append(result, items...), nil
+		}
+		for _, item := range current {
+			if !fieldOperationContains(items, item) {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+	}
+}
+
+func fieldOperationNumber(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int64:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	default:
+		return 0, false
+	}
+}
+
+func fieldOperationContains(items []interface{}, item interface{}) bool {
+	for _, i := range items {
+		if reflect.DeepEqual(i, item) {
+			return true
+		}
+	}
+	return false
+}
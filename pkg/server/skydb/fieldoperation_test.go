@@ -0,0 +1,122 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFieldOperation(t *testing.T) {
+	Convey("FieldOperation", t, func() {
+		apply := func(op FieldOperation, value interface{}) interface{} {
+			result, err := op.Apply(value)
+			So(err, ShouldBeNil)
+			return result
+		}
+
+		Convey("increments number", func() {
+			op := FieldOperation{IncrementOperator, float64(2)}
+			So(apply(op, float64(1)), ShouldResemble, float64(3))
+			So(apply(op, nil), ShouldResemble, float64(2))
+		})
+
+		Convey("takes the max number", func() {
+			op := FieldOperation{MaxOperator, float64(2)}
+			So(apply(op, float64(1)), ShouldResemble, float64(2))
+			So(apply(op, float64(5)), ShouldResemble, float64(5))
+			So(apply(op, nil), ShouldResemble, float64(2))
+		})
+
+		Convey("pushes items", func() {
+			op := FieldOperation{PushOperator, []interface{}{"b", "c"}}
+			So(apply(op, []interface{}{"a"}), ShouldResemble, []interface{}{"a", "b", "c"})
+			So(apply(op, nil), ShouldResemble, []interface{}{"b", "c"})
+		})
+
+		Convey("pulls all occurrences of items", func() {
+			op := FieldOperation{PullOperator, []interface{}{"a", float64(1)}}
+			So(apply(op, []interface{}{"a", "b", float64(1), "a"}), ShouldResemble, []interface{}{"b"})
+			So(apply(op, nil), ShouldResemble, []interface{}{})
+		})
+
+		Convey("errors on value of wrong type", func() {
+			_, err := FieldOperation{IncrementOperator, float64(1)}.Apply("a")
+			So(err, ShouldNotBeNil)
+
+			_, err = FieldOperation{PushOperator, []interface{}{}}.Apply(float64(1))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("checks field type", func() {
+			So(FieldOperation{IncrementOperator, float64(1)}.CheckFieldType("count", FieldType{Type: TypeNumber}), ShouldBeNil)
+			So(FieldOperation{MaxOperator, float64(1)}.CheckFieldType("count", FieldType{Type: TypeInteger}), ShouldBeNil)
+			So(FieldOperation{PushOperator, []interface{}{}}.CheckFieldType("tags", FieldType{Type: TypeJSON}), ShouldBeNil)
+
+			rejected := []struct {
+				op        FieldOperation
+				fieldType FieldType
+			}{
+				{FieldOperation{IncrementOperator, float64(1)}, FieldType{Type: TypeString}},
+				{FieldOperation{MaxOperator, float64(1)}, FieldType{Type: TypeDateTime}},
+				{FieldOperation{IncrementOperator, float64(1)}, FieldType{Type: TypeJSON}},
+				{FieldOperation{IncrementOperator, float64(1)}, FieldType{Type: TypeSequence}},
+				{FieldOperation{PushOperator, []interface{}{}}, FieldType{Type: TypeString}},
+				{FieldOperation{PullOperator, []interface{}{}}, FieldType{Type: TypeNumber}},
+				{FieldOperation{PushOperator, []interface{}{}}, FieldType{Type: TypeAsset}},
+			}
+			for _, r := range rejected {
+				err := r.op.CheckFieldType("field", r.fieldType)
+				So(err, ShouldNotBeNil)
+				So(err.Code(), ShouldEqual, skyerr.InvalidArgument)
+				So(err.Info(), ShouldResemble, map[string]interface{}{
+					"arguments": []string{"field"},
+					"field":     "field",
+					"operator":  "$" + string(r.op.Operator),
+					"type":      r.fieldType.ToSimpleName(),
+				})
+			}
+		})
+
+		Convey("validates operation", func() {
+			So(FieldOperation{IncrementOperator, "1"}.Validate(), ShouldNotBeNil)
+			So(FieldOperation{PullOperator, float64(1)}.Validate(), ShouldNotBeNil)
+			So(FieldOperation{"mul", float64(1)}.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("is applied by Record.Apply", func() {
+			record := Record{
+				Data: Data{
+					"count": float64(1),
+					"title": "Hello",
+				},
+			}
+			record.Apply(&Record{
+				Data: Data{
+					"count": FieldOperation{IncrementOperator, float64(1)},
+					"tags":  FieldOperation{PushOperator, []interface{}{"a"}},
+					"title": FieldOperation{IncrementOperator, float64(1)},
+				},
+			})
+			So(record.Data, ShouldResemble, Data{
+				"count": float64(2),
+				"tags":  []interface{}{"a"},
+				"title": "Hello",
+			})
+		})
+	})
+}
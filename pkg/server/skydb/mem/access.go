@@ -0,0 +1,77 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SetRecordAccess(recordType string, acl skydb.RecordACL) error {
+	creationRoles := []string{}
+	for _, ace := range acl {
+		if ace.Role != "" && !containsString(creationRoles, ace.Role) {
+			creationRoles = append(creationRoles, ace.Role)
+		}
+	}
+
+	return c.write(func() error {
+		c.ensureRole(creationRoles)
+		c.put(recordCreationTable, recordType, creationRoles)
+		return nil
+	})
+}
+
+func (c *conn) GetRecordAccess(recordType string) (skydb.RecordACL, error) {
+	currentCreationRoles := []skydb.RecordACLEntry{}
+	if row, ok := c.get(recordCreationTable, recordType); ok {
+		for _, role := range row.([]string) {
+			currentCreationRoles = append(currentCreationRoles,
+				skydb.NewRecordACLEntryRole(role, skydb.CreateLevel))
+		}
+	}
+
+	return skydb.NewRecordACL(currentCreationRoles), nil
+}
+
+func (c *conn) SetRecordDefaultAccess(recordType string, acl skydb.RecordACL) error {
+	return c.write(func() error {
+		c.put(recordDefaultAccessTable, recordType, copyValue(acl))
+		return nil
+	})
+}
+
+func (c *conn) GetRecordDefaultAccess(recordType string) (skydb.RecordACL, error) {
+	row, ok := c.get(recordDefaultAccessTable, recordType)
+	if !ok {
+		return nil, nil
+	}
+	return copyValue(row.(skydb.RecordACL)).(skydb.RecordACL), nil
+}
+
+func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) error {
+	entries := append(skydb.FieldACLEntryList{}, acl.AllEntries()...)
+	return c.write(func() error {
+		c.put(recordFieldAccessTable, "", entries)
+		return nil
+	})
+}
+
+func (c *conn) GetRecordFieldAccess() (skydb.FieldACL, error) {
+	entries := skydb.FieldACLEntryList{}
+	if row, ok := c.get(recordFieldAccessTable, ""); ok {
+		entries = append(entries, row.(skydb.FieldACLEntryList)...)
+	}
+	return skydb.NewFieldACL(entries), nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+type assetRow struct {
+	ContentType string
+	Size        int64
+	UpdatedAt   time.Time
+}
+
+func (c *conn) GetAsset(name string, a *skydb.Asset) error {
+	assets, err := c.GetAssets([]string{name})
+
+	if len(assets) == 0 {
+		return errors.New("asset not found")
+	}
+
+	*a = assets[0]
+
+	return err
+}
+
+func (c *conn) GetAssets(names []string) ([]skydb.Asset, error) {
+	results := []skydb.Asset{}
+	for i, name := range names {
+		if containsString(names[:i], name) {
+			continue
+		}
+		row, ok := c.get(assetTable, name)
+		if !ok {
+			continue
+		}
+		results = append(results, skydb.Asset{
+			Name:        name,
+			ContentType: row.(assetRow).ContentType,
+			Size:        row.(assetRow).Size,
+		})
+	}
+
+	return results, nil
+}
+
+func (c *conn) SaveAsset(a *skydb.Asset) error {
+	return c.write(func() error {
+		c.put(assetTable, a.Name, assetRow{
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			UpdatedAt:   timeNow(),
+		})
+		return nil
+	})
+}
+
+func (c *conn) QueryUnreferencedAssets(savedBefore time.Time) ([]skydb.Asset, error) {
+	rows := c.scan(assetTable)
+	results := []skydb.Asset{}
+	for _, name := range sortedKeys(rows) {
+		row := rows[name].(assetRow)
+		if !row.UpdatedAt.Before(savedBefore) || c.isAssetReferenced(name) {
+			continue
+		}
+		results = append(results, skydb.Asset{
+			Name:        name,
+			ContentType: row.ContentType,
+			Size:        row.Size,
+		})
+	}
+	return results, nil
+}
+
+func (c *conn) DeleteAsset(name string) error {
+	return c.write(func() error {
+		if c.isAssetReferenced(name) {
+			return skydb.ErrAssetReferenced
+		}
+		if !c.remove(assetTable, name) {
+			return errors.New("asset not found")
+		}
+		return nil
+	})
+}
+
+// isAssetReferenced returns true if an asset field of a record, including
+// a soft-deleted record, or of a revision in the record history refers to
+// the asset.
+func (c *conn) isAssetReferenced(name string) bool {
+	for _, row := range c.scan(recordHistoryTable) {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(row.(recordHistoryRow).RecordData, &data); err != nil {
+			continue
+		}
+		for _, value := range data {
+			if m, ok := value.(map[string]interface{}); ok && m["$type"] == "asset" && m["$name"] == name {
+				return true
+			}
+		}
+	}
+
+	for recordType, row := range c.scan(schemaTable) {
+		for field, fieldType := range row.(skydb.RecordSchema) {
+			if fieldType.Type != skydb.TypeAsset {
+				continue
+			}
+			for _, r := range c.scan(recordTable(recordType)) {
+				if a, ok := r.(recordRow).record.Data[field].(*skydb.Asset); ok && a.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (c *conn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	row, ok := c.get(uploadSessionTable, id)
+	if !ok {
+		return skydb.ErrUploadSessionNotFound
+	}
+
+	*session = row.(skydb.UploadSession)
+	session.Parts = append([]asset.UploadedPart{}, session.Parts...)
+	return nil
+}
+
+// GetUploadSessionForUpdate fetches the UploadSession like
+// GetUploadSession. Transactions are serialized, so the UploadSession is
+// already locked in a transaction.
+func (c *conn) GetUploadSessionForUpdate(id string, session *skydb.UploadSession) error {
+	return c.GetUploadSession(id, session)
+}
+
+func (c *conn) SaveUploadSession(session *skydb.UploadSession) error {
+	if session.ID == "" || session.Name == "" {
+		return errors.New("invalid upload session: empty id or asset name")
+	}
+
+	now := timeNow()
+	return c.write(func() error {
+		createdAt := session.CreatedAt
+		if row, ok := c.get(uploadSessionTable, session.ID); ok {
+			createdAt = row.(skydb.UploadSession).CreatedAt
+		} else if createdAt.IsZero() {
+			createdAt = now
+		}
+
+		stored := *session
+		stored.Parts = append([]asset.UploadedPart{}, session.Parts...)
+		stored.CreatedAt = createdAt.UTC()
+		stored.UpdatedAt = now
+		stored.ExpiresAt = session.ExpiresAt.UTC()
+		c.put(uploadSessionTable, session.ID, stored)
+
+		session.CreatedAt = stored.CreatedAt
+		session.UpdatedAt = now
+		return nil
+	})
+}
+
+func (c *conn) DeleteUploadSession(id string) error {
+	return c.write(func() error {
+		if !c.remove(uploadSessionTable, id) {
+			return skydb.ErrUploadSessionNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) QueryExpiredUploadSessions(t time.Time) ([]skydb.UploadSession, error) {
+	sessions := []skydb.UploadSession{}
+	rows := c.scan(uploadSessionTable)
+	for _, id := range sortedKeys(rows) {
+		session := rows[id].(skydb.UploadSession)
+		if session.IsExpired(t) {
+			session.Parts = append([]asset.UploadedPart{}, session.Parts...)
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].ExpiresAt.Before(sessions[j].ExpiresAt)
+	})
+	return sessions, nil
+}
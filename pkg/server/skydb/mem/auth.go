@@ -0,0 +1,412 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+type roleRow struct {
+	Admin   bool
+	Default bool
+}
+
+func copyAuthInfo(authinfo *skydb.AuthInfo) skydb.AuthInfo {
+	authinfoCopy := *authinfo
+	if authinfo.HashedPassword != nil {
+		authinfoCopy.HashedPassword = append([]byte{}, authinfo.HashedPassword...)
+	}
+	if authinfo.Roles != nil {
+		authinfoCopy.Roles = append([]string{}, authinfo.Roles...)
+	}
+	if authinfo.ProviderInfo != nil {
+		authinfoCopy.ProviderInfo = skydb.ProviderInfo{}
+		for principalID, data := range authinfo.ProviderInfo {
+			authinfoCopy.ProviderInfo[principalID] = copyValue(data).(map[string]interface{})
+		}
+	}
+	authinfoCopy.TokenValidSince = copyTime(authinfo.TokenValidSince)
+	authinfoCopy.LastSeenAt = copyTime(authinfo.LastSeenAt)
+	authinfoCopy.DisabledExpiry = copyTime(authinfo.DisabledExpiry)
+	if !authinfo.Disabled {
+		authinfoCopy.DisabledMessage = ""
+		authinfoCopy.DisabledExpiry = nil
+	}
+	return authinfoCopy
+}
+
+func (c *conn) CreateAuth(authinfo *skydb.AuthInfo) error {
+	return c.write(func() error {
+		if _, ok := c.get(authTable, authinfo.ID); ok {
+			return skydb.ErrUserDuplicated
+		}
+		return c.putAuth(authinfo)
+	})
+}
+
+func (c *conn) UpdateAuth(authinfo *skydb.AuthInfo) error {
+	return c.write(func() error {
+		if _, ok := c.get(authTable, authinfo.ID); !ok {
+			return skydb.ErrUserNotFound
+		}
+		return c.putAuth(authinfo)
+	})
+}
+
+func (c *conn) putAuth(authinfo *skydb.AuthInfo) error {
+	row := copyAuthInfo(authinfo)
+	row.Roles = nil
+	for _, role := range authinfo.Roles {
+		if !containsString(row.Roles, role) {
+			row.Roles = append(row.Roles, role)
+		}
+	}
+	c.ensureRole(row.Roles)
+	c.put(authTable, authinfo.ID, row)
+
+	if c.passwordHistoryEnabled && authinfo.IsPasswordChanged() {
+		id := uuid.New()
+		c.put(passwordHistoryTable, id, skydb.PasswordHistory{
+			ID:             id,
+			AuthID:         authinfo.ID,
+			HashedPassword: append([]byte{}, authinfo.HashedPassword...),
+			LoggedAt:       authinfo.TokenValidSince.UTC(),
+		})
+	}
+	return nil
+}
+
+func (c *conn) GetAuth(id string, authinfo *skydb.AuthInfo) error {
+	row, ok := c.get(authTable, id)
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+	stored := row.(skydb.AuthInfo)
+	*authinfo = copyAuthInfo(&stored)
+	return nil
+}
+
+func (c *conn) GetAuthByPrincipalID(principalID string, authinfo *skydb.AuthInfo) error {
+	rows := c.scan(authTable)
+	for _, id := range sortedKeys(rows) {
+		stored := rows[id].(skydb.AuthInfo)
+		if _, ok := stored.ProviderInfo[principalID]; ok {
+			*authinfo = copyAuthInfo(&stored)
+			return nil
+		}
+	}
+	return skydb.ErrUserNotFound
+}
+
+func (c *conn) DeleteAuth(id string) error {
+	return c.write(func() error {
+		if !c.remove(authTable, id) {
+			return skydb.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// passwordHistory returns the password history of the user, the latest
+// first.
+func (c *conn) passwordHistory(authID string) []skydb.PasswordHistory {
+	history := []skydb.PasswordHistory{}
+	for _, row := range c.scan(passwordHistoryTable) {
+		if h := row.(skydb.PasswordHistory); h.AuthID == authID {
+			h.HashedPassword = append([]byte{}, h.HashedPassword...)
+			history = append(history, h)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].LoggedAt.After(history[j].LoggedAt)
+	})
+	return history
+}
+
+func (c *conn) GetPasswordHistory(authID string, historySize, historyDays int) ([]skydb.PasswordHistory, error) {
+	var sizeHistory, daysHistory []skydb.PasswordHistory
+	t := timeNow()
+	history := c.passwordHistory(authID)
+
+	if historySize > 0 {
+		sizeHistory = history
+		if len(sizeHistory) > historySize {
+			sizeHistory = sizeHistory[:historySize]
+		}
+	}
+
+	if historyDays > 0 {
+		startOfDay := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		since := startOfDay.AddDate(0, 0, -historyDays)
+		daysHistory = []skydb.PasswordHistory{}
+		for _, h := range history {
+			if !h.LoggedAt.Before(since) {
+				daysHistory = append(daysHistory, h)
+			}
+		}
+	}
+
+	if len(sizeHistory) > len(daysHistory) {
+		return sizeHistory, nil
+	}
+
+	return daysHistory, nil
+}
+
+func (c *conn) RemovePasswordHistory(authID string, historySize, historyDays int) error {
+	history, err := c.GetPasswordHistory(authID, historySize, historyDays)
+	if err != nil {
+		return err
+	}
+
+	if len(history) <= 0 {
+		return nil
+	}
+
+	oldestTime := history[len(history)-1].LoggedAt
+	kept := map[string]bool{}
+	for _, h := range history {
+		kept[h.ID] = true
+	}
+
+	return c.write(func() error {
+		for _, h := range c.passwordHistory(authID) {
+			if !kept[h.ID] && h.LoggedAt.Before(oldestTime) {
+				c.remove(passwordHistoryTable, h.ID)
+			}
+		}
+		return nil
+	})
+}
+
+// ensureRole creates the roles not existed yet.
+func (c *conn) ensureRole(roles []string) {
+	for _, role := range roles {
+		if _, ok := c.get(roleTable, role); !ok {
+			c.put(roleTable, role, roleRow{})
+		}
+	}
+}
+
+func (c *conn) getRolesByType(isType func(roleRow) bool) []string {
+	roles := []string{}
+	rows := c.scan(roleTable)
+	for _, role := range sortedKeys(rows) {
+		if isType(rows[role].(roleRow)) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (c *conn) setRoleType(roles []string, setType func(*roleRow, bool)) error {
+	return c.write(func() error {
+		c.ensureRole(roles)
+		for role, row := range c.scan(roleTable) {
+			r := row.(roleRow)
+			setType(&r, containsString(roles, role))
+			c.put(roleTable, role, r)
+		}
+		return nil
+	})
+}
+
+func (c *conn) GetAdminRoles() ([]string, error) {
+	return c.getRolesByType(func(r roleRow) bool { return r.Admin }), nil
+}
+
+func (c *conn) SetAdminRoles(roles []string) error {
+	return c.setRoleType(roles, func(r *roleRow, value bool) { r.Admin = value })
+}
+
+func (c *conn) GetDefaultRoles() ([]string, error) {
+	return c.getRolesByType(func(r roleRow) bool { return r.Default }), nil
+}
+
+func (c *conn) SetDefaultRoles(roles []string) error {
+	return c.setRoleType(roles, func(r *roleRow, value bool) { r.Default = value })
+}
+
+func (c *conn) AssignRoles(userIDs []string, roles []string) error {
+	return c.write(func() error {
+		c.ensureRole(roles)
+		for _, userID := range userIDs {
+			row, ok := c.get(authTable, userID)
+			if !ok {
+				continue
+			}
+			authinfo := row.(skydb.AuthInfo)
+			userRoles := append([]string{}, authinfo.Roles...)
+			for _, role := range roles {
+				if !containsString(userRoles, role) {
+					userRoles = append(userRoles, role)
+				}
+			}
+			authinfo.Roles = userRoles
+			c.put(authTable, userID, authinfo)
+		}
+		return nil
+	})
+}
+
+func (c *conn) RevokeRoles(userIDs []string, roles []string) error {
+	return c.write(func() error {
+		for _, userID := range userIDs {
+			row, ok := c.get(authTable, userID)
+			if !ok {
+				continue
+			}
+			authinfo := row.(skydb.AuthInfo)
+			var userRoles []string
+			for _, role := range authinfo.Roles {
+				if !containsString(roles, role) {
+					userRoles = append(userRoles, role)
+				}
+			}
+			authinfo.Roles = userRoles
+			c.put(authTable, userID, authinfo)
+		}
+		return nil
+	})
+}
+
+func (c *conn) GetRoles(userIDs []string) (map[string][]string, error) {
+	roleMap := map[string][]string{}
+	for _, userID := range userIDs {
+		// keep an empty array even no roles found for that user
+		roleMap[userID] = []string{}
+		if row, ok := c.get(authTable, userID); ok {
+			roleMap[userID] = append(roleMap[userID], row.(skydb.AuthInfo).Roles...)
+		}
+	}
+	return roleMap, nil
+}
+
+func (c *conn) EnsureAuthRecordKeysExist(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+	schema, err := db.GetSchema(userRecordType)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve user record schema")
+	}
+
+	schemaToExtend := skydb.RecordSchema{}
+	for _, keys := range authRecordKeys {
+		for _, key := range keys {
+			if _, ok := schema[key]; ok {
+				continue
+			}
+
+			schemaToExtend[key] = skydb.FieldType{
+				Type: skydb.TypeString,
+			}
+		}
+	}
+
+	if _, err := db.Extend(userRecordType, schemaToExtend); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+
+	required := map[string]skydb.Index{}
+	for _, keys := range authRecordKeys {
+		index := skydb.Index{
+			Fields: append([]string{}, keys...),
+			Unique: true,
+		}
+		required[joinFields(index.Fields)] = index
+	}
+
+	indexesByName, err := db.GetIndexesByRecordType(userRecordType)
+	if err != nil {
+		return err
+	}
+
+	// only a unique index on all rows guarantees unique auth record keys
+	existing := map[string]bool{}
+	for _, index := range indexesByName {
+		if index.Unique && index.Condition == "" {
+			existing[joinFields(index.Fields)] = true
+		}
+	}
+
+	for _, fieldsString := range sortedIndexKeys(required) {
+		if existing[fieldsString] {
+			continue
+		}
+		index := required[fieldsString]
+		if !c.canMigrate {
+			return fmt.Errorf("Index of %v is required in user record schema", index.Fields)
+		}
+
+		if err = db.SaveIndex(userRecordType, managedIndexName(userRecordType, index), index); err != nil {
+			return err
+		}
+	}
+
+	// cleanup unused unique constraint
+	if c.canMigrate {
+		for indexName, index := range indexesByName {
+			_, isRequired := required[joinFields(index.Fields)]
+			if !isRequired && indexName == managedIndexName(userRecordType, index) {
+				db.DeleteIndex(userRecordType, indexName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func joinFields(fields []string) string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func sortedIndexKeys(indexes map[string]skydb.Index) []string {
+	keys := make([]string, 0, len(indexes))
+	for key := range indexes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func managedIndexName(recordType string, index skydb.Index) string {
+	fields := append([]string{}, index.Fields...)
+	sort.Strings(fields)
+	return fmt.Sprintf("auth_record_keys_%s_%s_key", recordType, strings.Join(fields, "_"))
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,383 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) GetDevice(id string, device *skydb.Device) error {
+	row, ok := c.get(deviceTable, id)
+	if !ok {
+		return skydb.ErrDeviceNotFound
+	}
+	*device = row.(skydb.Device)
+	return nil
+}
+
+func (c *conn) queryDevices(match func(skydb.Device) bool) []skydb.Device {
+	rows := c.scan(deviceTable)
+	results := []skydb.Device{}
+	for _, id := range sortedKeys(rows) {
+		if device := rows[id].(skydb.Device); match(device) {
+			results = append(results, device)
+		}
+	}
+	return results
+}
+
+func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
+	return c.queryDevices(func(device skydb.Device) bool {
+		return device.AuthInfoID == user
+	}), nil
+}
+
+func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, error) {
+	return c.queryDevices(func(device skydb.Device) bool {
+		return device.AuthInfoID == user && device.Topic == topic
+	}), nil
+}
+
+func (c *conn) SaveDevice(device *skydb.Device) error {
+	if device.ID == "" || device.Type == "" || device.LastRegisteredAt.IsZero() {
+		return errors.New("invalid device: empty id, type, or last registered at")
+	}
+
+	return c.write(func() error {
+		stored := skydb.Device{}
+		if row, ok := c.get(deviceTable, device.ID); ok {
+			stored = row.(skydb.Device)
+		}
+
+		stored.ID = device.ID
+		stored.Type = device.Type
+		stored.AuthInfoID = device.AuthInfoID
+		stored.LastRegisteredAt = device.LastRegisteredAt.UTC()
+		if device.Token != "" {
+			stored.Token = device.Token
+		}
+		if device.Topic != "" {
+			stored.Topic = device.Topic
+		}
+		if device.TimeZone != "" {
+			stored.TimeZone = device.TimeZone
+		}
+		c.put(deviceTable, device.ID, stored)
+		return nil
+	})
+}
+
+// deleteDevices deletes the matching devices and their subscriptions.
+func (c *conn) deleteDevices(match func(skydb.Device) bool) error {
+	return c.write(func() error {
+		deleted := map[string]bool{}
+		for id, row := range c.scan(deviceTable) {
+			if match(row.(skydb.Device)) {
+				c.remove(deviceTable, id)
+				deleted[id] = true
+			}
+		}
+		if len(deleted) == 0 {
+			return skydb.ErrDeviceNotFound
+		}
+
+		for key, row := range c.scan(subscriptionTable) {
+			if deleted[row.(subscriptionRow).Subscription.DeviceID] {
+				c.remove(subscriptionTable, key)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *conn) DeleteDevice(id string) error {
+	return c.deleteDevices(func(device skydb.Device) bool {
+		return device.ID == id
+	})
+}
+
+func (c *conn) DeleteDevicesByToken(token string, t time.Time) error {
+	return c.deleteDevices(func(device skydb.Device) bool {
+		if t != skydb.ZeroTime && !device.LastRegisteredAt.Before(t) {
+			return false
+		}
+		return device.Token != "" && device.Token == token
+	})
+}
+
+func (c *conn) DeleteEmptyDevicesByTime(t time.Time) error {
+	return c.deleteDevices(func(device skydb.Device) bool {
+		if t != skydb.ZeroTime && !device.LastRegisteredAt.Before(t) {
+			return false
+		}
+		return device.Token == ""
+	})
+}
+
+type scheduledPushRow struct {
+	Push         skydb.ScheduledPush
+	DueAt        time.Time
+	ClaimedUntil time.Time
+}
+
+func copyScheduledPush(push *skydb.ScheduledPush) skydb.ScheduledPush {
+	pushCopy := *push
+	if push.TargetIDs != nil {
+		pushCopy.TargetIDs = append([]string{}, push.TargetIDs...)
+	}
+	if push.SentDeviceIDs != nil {
+		pushCopy.SentDeviceIDs = append([]string{}, push.SentDeviceIDs...)
+	}
+	if push.Notification != nil {
+		pushCopy.Notification = copyValue(push.Notification).(map[string]interface{})
+	}
+	pushCopy.SendAt = push.SendAt.UTC()
+	pushCopy.CreatedAt = push.CreatedAt.UTC()
+	return pushCopy
+}
+
+func (c *conn) GetScheduledPush(id string, push *skydb.ScheduledPush) error {
+	row, ok := c.get(scheduledPushTable, id)
+	if !ok {
+		return skydb.ErrScheduledPushNotFound
+	}
+	stored := row.(scheduledPushRow).Push
+	*push = copyScheduledPush(&stored)
+	return nil
+}
+
+func (c *conn) SaveScheduledPush(push *skydb.ScheduledPush) error {
+	if push.ID == "" || push.TargetType == "" || push.SendAt.IsZero() {
+		return errors.New("invalid scheduled push: empty id, target type, or send at")
+	}
+
+	return c.write(func() error {
+		createdAt := push.CreatedAt
+		var claimedUntil time.Time
+		if row, ok := c.get(scheduledPushTable, push.ID); ok {
+			createdAt = row.(scheduledPushRow).Push.CreatedAt
+			claimedUntil = row.(scheduledPushRow).ClaimedUntil
+		} else if createdAt.IsZero() {
+			createdAt = timeNow()
+		}
+
+		stored := copyScheduledPush(push)
+		stored.CreatedAt = createdAt.UTC()
+		c.put(scheduledPushTable, push.ID, scheduledPushRow{
+			Push:         stored,
+			DueAt:        push.EarliestDueAt().UTC(),
+			ClaimedUntil: claimedUntil,
+		})
+
+		push.CreatedAt = createdAt
+		return nil
+	})
+}
+
+func (c *conn) CancelScheduledPush(id string, push *skydb.ScheduledPush) error {
+	return c.write(func() error {
+		row, ok := c.get(scheduledPushTable, id)
+		if !ok {
+			return skydb.ErrScheduledPushNotFound
+		}
+
+		r := row.(scheduledPushRow)
+		if r.Push.Status != skydb.ScheduledPushPending && r.Push.Status != skydb.ScheduledPushDispatching {
+			return skydb.ErrScheduledPushNotFound
+		}
+
+		r.Push = copyScheduledPush(&r.Push)
+		r.Push.Status = skydb.ScheduledPushCancelled
+		r.ClaimedUntil = time.Time{}
+		c.put(scheduledPushTable, id, r)
+		*push = copyScheduledPush(&r.Push)
+		return nil
+	})
+}
+
+func (c *conn) ClaimDueScheduledPushes(t time.Time, claimUntil time.Time) ([]skydb.ScheduledPush, error) {
+	due := []scheduledPushRow{}
+	err := c.write(func() error {
+		for _, row := range c.scan(scheduledPushTable) {
+			r := row.(scheduledPushRow)
+			if r.DueAt.After(t) {
+				continue
+			}
+
+			switch r.Push.Status {
+			case skydb.ScheduledPushPending:
+			case skydb.ScheduledPushDispatching:
+				if r.ClaimedUntil.After(t) {
+					continue
+				}
+			default:
+				continue
+			}
+
+			r.Push.Status = skydb.ScheduledPushDispatching
+			r.ClaimedUntil = claimUntil.UTC()
+			c.put(scheduledPushTable, r.Push.ID, r)
+			due = append(due, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		if due[i].DueAt.Equal(due[j].DueAt) {
+			return due[i].Push.ID < due[j].Push.ID
+		}
+		return due[i].DueAt.Before(due[j].DueAt)
+	})
+
+	results := []skydb.ScheduledPush{}
+	for _, r := range due {
+		results = append(results, copyScheduledPush(&r.Push))
+	}
+	return results, nil
+}
+
+func (c *conn) updateDispatchingScheduledPush(id string, update func(r *scheduledPushRow)) error {
+	return c.write(func() error {
+		row, ok := c.get(scheduledPushTable, id)
+		if !ok {
+			return skydb.ErrScheduledPushNotFound
+		}
+
+		r := row.(scheduledPushRow)
+		if r.Push.Status != skydb.ScheduledPushDispatching {
+			return skydb.ErrScheduledPushNotFound
+		}
+
+		r.Push = copyScheduledPush(&r.Push)
+		update(&r)
+		c.put(scheduledPushTable, id, r)
+		return nil
+	})
+}
+
+func (c *conn) MarkScheduledPushSent(id string, deviceIDs []string) error {
+	return c.updateDispatchingScheduledPush(id, func(r *scheduledPushRow) {
+		r.Push.SentDeviceIDs = append([]string{}, deviceIDs...)
+	})
+}
+
+func (c *conn) ReleaseScheduledPush(id string, status skydb.ScheduledPushStatus, dueAt time.Time) error {
+	return c.updateDispatchingScheduledPush(id, func(r *scheduledPushRow) {
+		r.Push.Status = status
+		r.DueAt = dueAt.UTC()
+		r.ClaimedUntil = time.Time{}
+	})
+}
+
+func relationKey(user string, targetUser string) string {
+	return user + "\x00" + targetUser
+}
+
+type relationRow struct {
+	Left  string
+	Right string
+}
+
+// relatedUsers returns the users related to the user in the direction,
+// or in both directions if the direction is neither outward nor inward.
+func (c *conn) relatedUsers(user string, name string, direction string) []string {
+	outward := map[string]bool{}
+	inward := map[string]bool{}
+	for _, row := range c.scan(relationTable(name)) {
+		r := row.(relationRow)
+		if r.Left == user {
+			outward[r.Right] = true
+		}
+		if r.Right == user {
+			inward[r.Left] = true
+		}
+	}
+
+	users := []string{}
+	switch direction {
+	case "outward":
+		for id := range outward {
+			users = append(users, id)
+		}
+	case "inward":
+		for id := range inward {
+			users = append(users, id)
+		}
+	default:
+		for id := range outward {
+			if inward[id] {
+				users = append(users, id)
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+func (c *conn) QueryRelation(user string, name string, direction string, config skydb.QueryConfig) []skydb.AuthInfo {
+	results := []skydb.AuthInfo{}
+	offset := config.Offset
+	for _, id := range c.relatedUsers(user, name, direction) {
+		if _, ok := c.get(authTable, id); !ok {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if config.Limit != 0 && uint64(len(results)) >= config.Limit {
+			break
+		}
+		results = append(results, skydb.AuthInfo{
+			ID: id,
+		})
+	}
+	return results
+}
+
+func (c *conn) QueryRelationCount(user string, name string, direction string) (uint64, error) {
+	return uint64(len(c.relatedUsers(user, name, direction))), nil
+}
+
+func (c *conn) AddRelation(user string, name string, targetUser string) error {
+	return c.write(func() error {
+		if _, ok := c.get(authTable, targetUser); !ok {
+			return fmt.Errorf("userID not exist")
+		}
+		c.put(relationTable(name), relationKey(user, targetUser), relationRow{
+			Left:  user,
+			Right: targetUser,
+		})
+		return nil
+	})
+}
+
+func (c *conn) RemoveRelation(user string, name string, targetUser string) error {
+	return c.write(func() error {
+		if !c.remove(relationTable(name), relationKey(user, targetUser)) {
+			return fmt.Errorf("%v relation not exist {%v} => {%v}",
+				name, user, targetUser)
+		}
+		return nil
+	})
+}
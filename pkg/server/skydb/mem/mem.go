@@ -0,0 +1,306 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mem implements a skydb driver which keeps all data in memory.
+//
+// The driver is registered as "mem". Conns opened with the same option
+// string and app name share the same data for the lifetime of the
+// process, and a different option string opens an isolated database. It
+// is meant for tests and development, where running PostgreSQL is not
+// desirable.
+//
+// Transactions are serialized: a transaction holds the write lock of the
+// database from Begin until Commit or Rollback, so that the checks made
+// by its writes, such as unique and reference constraints, still hold
+// when it is committed. A write outside of a transaction waits for the
+// transaction in progress to end.
+package mem
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var timeNow = func() time.Time { return time.Now().UTC() }
+
+func init() {
+	skydb.Register("mem", skydb.DriverFunc(Open))
+}
+
+var (
+	storesMutex sync.Mutex
+	stores      = map[string]*store{}
+)
+
+func getStore(optionString string, appName string) *store {
+	storesMutex.Lock()
+	defer storesMutex.Unlock()
+
+	key := optionString + "\x00" + appName
+	s, ok := stores[key]
+	if !ok {
+		s = newStore()
+		stores[key] = s
+	}
+	return s
+}
+
+// Open returns a Conn to the in-memory database of the app.
+func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, optionString string, config skydb.DBConfig) (skydb.Conn, error) {
+	if accessModel == skydb.RelationBasedAccess {
+		return nil, fmt.Errorf("Unsupported AccessModel: RelationBasedAccess")
+	}
+
+	return &conn{
+		store:                  getStore(optionString, appName),
+		appName:                appName,
+		accessModel:            accessModel,
+		canMigrate:             config.CanMigrate,
+		passwordHistoryEnabled: config.PasswordHistoryEnabled,
+		context:                ctx,
+	}, nil
+}
+
+type conn struct {
+	store                  *store
+	appName                string
+	accessModel            skydb.AccessModel
+	canMigrate             bool
+	passwordHistoryEnabled bool
+	context                context.Context
+
+	// tx holds the changes not yet applied to the store. It is set
+	// between Begin and Commit, and during a write operation outside
+	// of a transaction.
+	tx *txn
+
+	// inTx is true if tx is begun by Begin.
+	inTx bool
+}
+
+// Begin begins a transaction.
+func (c *conn) Begin() error {
+	logger := logging.CreateLogger(c.context, "skydb")
+	logger.Debugf("%p: Beginning transaction", c)
+	if c.inTx {
+		return skydb.ErrDatabaseTxDidBegin
+	}
+
+	c.store.writeMutex.Lock()
+	c.tx = newTxn()
+	c.inTx = true
+	return nil
+}
+
+// Commit commits a transaction.
+func (c *conn) Commit() error {
+	if !c.inTx {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	tx := c.tx
+	c.store.apply(tx)
+	c.tx = nil
+	c.inTx = false
+	c.store.writeMutex.Unlock()
+
+	c.store.emit(tx.events)
+
+	logger := logging.CreateLogger(c.context, "skydb")
+	logger.Debugf("%p: Committed transaction", c)
+	return nil
+}
+
+// Rollback rollbacks a transaction.
+func (c *conn) Rollback() error {
+	if !c.inTx {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	c.tx = nil
+	c.inTx = false
+	c.store.writeMutex.Unlock()
+
+	logger := logging.CreateLogger(c.context, "skydb")
+	logger.Debugf("%p: Rolled back transaction", c)
+	return nil
+}
+
+// write runs fn with its changes applied to the store atomically. In a
+// transaction, the changes are kept in the transaction until Commit,
+// and the write lock is already held by Begin.
+// Otherwise, they are applied only if fn returns no error.
+func (c *conn) write(fn func() error) error {
+	if c.tx != nil {
+		// Like a failed statement in a transaction, a failed write
+		// leaves no changes in the transaction.
+		savepoint := c.tx.copy()
+		err := fn()
+		if err != nil {
+			c.tx = savepoint
+		}
+		return err
+	}
+
+	c.store.writeMutex.Lock()
+	c.tx = newTxn()
+	tx := c.tx
+	err := fn()
+	c.tx = nil
+	if err == nil {
+		c.store.apply(tx)
+	}
+	c.store.writeMutex.Unlock()
+
+	if err == nil {
+		c.store.emit(tx.events)
+	}
+	return err
+}
+
+func (c *conn) get(table string, key string) (interface{}, bool) {
+	if c.tx != nil {
+		if v, ok := c.tx.tables[table][key]; ok {
+			return v.value, !v.deleted
+		}
+	}
+	return c.store.get(table, key)
+}
+
+// scan returns all rows of a table keyed by their keys.
+func (c *conn) scan(table string) map[string]interface{} {
+	rows := c.store.scan(table)
+	if c.tx != nil {
+		for key, v := range c.tx.tables[table] {
+			if v.deleted {
+				delete(rows, key)
+			} else {
+				rows[key] = v.value
+			}
+		}
+	}
+	return rows
+}
+
+func (c *conn) put(table string, key string, value interface{}) {
+	c.tx.set(table, key, txnValue{value: value})
+}
+
+func (c *conn) remove(table string, key string) bool {
+	if _, ok := c.get(table, key); !ok {
+		return false
+	}
+	c.tx.set(table, key, txnValue{deleted: true})
+	return true
+}
+
+func (c *conn) emit(record *skydb.Record, event skydb.RecordHookEvent) {
+	recordCopy := copyRecord(*record)
+	c.tx.events = append(c.tx.events, skydb.RecordEvent{
+		Record: &recordCopy,
+		Event:  event,
+	})
+}
+
+func (c *conn) PublicDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PublicDatabase,
+	}
+}
+
+func (c *conn) PrivateDB(userKey string) skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PrivateDatabase,
+		userID:       userKey,
+	}
+}
+
+func (c *conn) UnionDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.UnionDatabase,
+	}
+}
+
+func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
+	c.store.eventMutex.Lock()
+	defer c.store.eventMutex.Unlock()
+
+	c.store.channels = append(c.store.channels, recordEventChan)
+	return nil
+}
+
+// Close rolls back the transaction left open, so that the store is not
+// kept locked for writing.
+func (c *conn) Close() error {
+	if c.inTx {
+		return c.Rollback()
+	}
+	return nil
+}
+
+type database struct {
+	c            *conn
+	userID       string
+	databaseType skydb.DatabaseType
+}
+
+func (db *database) Conn() skydb.Conn       { return db.c }
+func (db *database) UserRecordType() string { return "user" }
+
+func (db *database) ID() string {
+	if db.DatabaseType() == skydb.PublicDatabase {
+		return skydb.PublicDatabaseIdentifier
+	} else if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.UnionDatabaseIdentifier
+	}
+
+	if db.userID == "" {
+		panic("Private database but userID is empty")
+	}
+	return db.userID
+}
+
+func (db *database) DatabaseType() skydb.DatabaseType { return db.databaseType }
+func (db *database) IsReadOnly() bool                 { return db.DatabaseType() == skydb.UnionDatabase }
+
+func (db *database) TableName(table string) string {
+	return recordTable(table)
+}
+
+func (db *database) Begin() error {
+	return db.c.Begin()
+}
+
+func (db *database) Commit() error {
+	return db.c.Commit()
+}
+
+func (db *database) Rollback() error {
+	return db.c.Rollback()
+}
+
+// this ensures that our structure conform to certain interfaces.
+var (
+	_ skydb.Conn          = &conn{}
+	_ skydb.Database      = &database{}
+	_ skydb.Transactional = &database{}
+)
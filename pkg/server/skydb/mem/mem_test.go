@@ -0,0 +1,235 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testStoreCount uint64
+
+// getTestConn returns a Conn to a new in-memory database.
+func getTestConn(t *testing.T) *conn {
+	optionString := fmt.Sprintf("test-%d", atomic.AddUint64(&testStoreCount, 1))
+	c, err := Open(context.Background(), "com.oursky.skygear", skydb.RoleBasedAccess, optionString, skydb.DBConfig{
+		CanMigrate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*conn)
+}
+
+func exhaustRows(rows *skydb.Rows, errin error) (records []skydb.Record, err error) {
+	if errin != nil {
+		err = errin
+		return
+	}
+
+	for rows.Scan() {
+		records = append(records, rows.Record())
+	}
+
+	err = rows.Err()
+	return
+}
+
+func TestOpen(t *testing.T) {
+	Convey("Open", t, func() {
+		Convey("shares the database of the same option string and app", func() {
+			c1, err := Open(context.Background(), "app", skydb.RoleBasedAccess, "shared", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+			c2, err := Open(context.Background(), "app", skydb.RoleBasedAccess, "shared", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+			c3, err := Open(context.Background(), "another-app", skydb.RoleBasedAccess, "shared", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+
+			So(c1.CreateAuth(&skydb.AuthInfo{ID: "shared-user"}), ShouldBeNil)
+			So(c2.GetAuth("shared-user", &skydb.AuthInfo{}), ShouldBeNil)
+			So(c3.GetAuth("shared-user", &skydb.AuthInfo{}), ShouldEqual, skydb.ErrUserNotFound)
+		})
+
+		Convey("is registered as mem", func() {
+			c, err := skydb.Open(context.Background(), "mem", "app", "role", "registered", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+			So(c, ShouldHaveSameTypeAs, &conn{})
+		})
+
+		Convey("rejects relation based access", func() {
+			_, err := Open(context.Background(), "app", skydb.RelationBasedAccess, "", skydb.DBConfig{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "note1"),
+			OwnerID: "user1",
+			Data:    skydb.Data{"content": "hello"},
+		}
+
+		Convey("hides uncommitted changes from other conns", func() {
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+
+			other := getTestConnOf(c)
+			So(other.PublicDB().Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+
+			So(c.Commit(), ShouldBeNil)
+			So(other.PublicDB().Get(record.ID, &skydb.Record{}), ShouldBeNil)
+		})
+
+		Convey("keeps a transaction after a failed write", func() {
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+
+			invalid := skydb.Record{
+				ID:      skydb.NewRecordID("note", "note2"),
+				OwnerID: "user1",
+				Data:    skydb.Data{"content": 1},
+			}
+			So(db.Save(&invalid), ShouldNotBeNil)
+			So(c.Commit(), ShouldBeNil)
+
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+			So(db.Get(invalid.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("serializes transactions", func() {
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+
+			other := getTestConnOf(c)
+			begun := make(chan error)
+			go func() {
+				begun <- other.Begin()
+			}()
+			select {
+			case <-begun:
+				t.Fatal("unexpected transaction begun before commit")
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			So(c.Commit(), ShouldBeNil)
+			select {
+			case err := <-begun:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Second):
+				t.Fatal("expected transaction begun after commit")
+			}
+
+			// the transaction begins after the commit and sees the record
+			So(other.PublicDB().Get(record.ID, &skydb.Record{}), ShouldBeNil)
+			So(other.Rollback(), ShouldBeNil)
+		})
+
+		Convey("rolls back an open transaction on close", func() {
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+			So(c.Close(), ShouldBeNil)
+
+			other := getTestConnOf(c)
+			begun := make(chan error)
+			go func() {
+				begun <- other.Begin()
+			}()
+			select {
+			case err := <-begun:
+				So(err, ShouldBeNil)
+			case <-time.After(time.Second):
+				t.Fatal("expected transaction begun after close")
+			}
+
+			So(other.PublicDB().Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(other.Rollback(), ShouldBeNil)
+		})
+
+		Convey("sends record events after commit", func() {
+			ch := make(chan skydb.RecordEvent)
+			So(c.Subscribe(ch), ShouldBeNil)
+
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+			select {
+			case <-ch:
+				t.Fatal("unexpected record event before commit")
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			So(c.Commit(), ShouldBeNil)
+			select {
+			case event := <-ch:
+				So(event.Event, ShouldEqual, skydb.RecordCreated)
+				So(event.Record.ID, ShouldResemble, record.ID)
+			case <-time.After(time.Second):
+				t.Fatal("expected record event")
+			}
+		})
+	})
+}
+
+// getTestConnOf returns another Conn to the database of the Conn.
+func getTestConnOf(c *conn) *conn {
+	return &conn{
+		store:       c.store,
+		appName:     c.appName,
+		accessModel: c.accessModel,
+		canMigrate:  c.canMigrate,
+		context:     c.context,
+	}
+}
+
+func TestAuth(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+
+		Convey("rejects duplicated auth record keys", func() {
+			db := c.PublicDB()
+			user1 := skydb.Record{
+				ID:      skydb.NewRecordID("user", "user1"),
+				OwnerID: "user1",
+				Data:    skydb.Data{"username": "John"},
+			}
+			So(db.Save(&user1), ShouldBeNil)
+
+			user2 := skydb.Record{
+				ID:      skydb.NewRecordID("user", "user2"),
+				OwnerID: "user2",
+				Data:    skydb.Data{"username": "john"},
+			}
+			err := db.Save(&user2)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.Duplicated)
+		})
+	})
+}
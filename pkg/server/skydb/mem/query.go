@@ -0,0 +1,929 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// earthRadius is the radius in meters used by ST_Distance_Sphere of
+// PostGIS.
+const earthRadius = 6370986
+
+var errCannotCompareUsingInOperator = errors.New(`cannot use "in" operator to compare the specified values`)
+
+// tri is the result of a predicate in three-valued logic. Like SQL, a
+// comparison with null is unknown, and a record matches a predicate only
+// if the result is true.
+type tri int
+
+const (
+	triUnknown tri = iota
+	triFalse
+	triTrue
+)
+
+func triOf(b bool) tri {
+	if b {
+		return triTrue
+	}
+	return triFalse
+}
+
+func (t tri) not() tri {
+	switch t {
+	case triTrue:
+		return triFalse
+	case triFalse:
+		return triTrue
+	default:
+		return triUnknown
+	}
+}
+
+// operand is the value of an expression for a record.
+type operand struct {
+	expr      skydb.Expression
+	value     interface{}
+	fieldType skydb.FieldType
+}
+
+func (o operand) caseInsensitive() bool {
+	return o.fieldType.UnderlyingType == "citext"
+}
+
+// subqueryResult is the values at the keypath of the records selected by
+// a subquery.
+type subqueryResult struct {
+	fieldType skydb.FieldType
+	values    []interface{}
+	keys      map[string]bool
+}
+
+// evaluator evaluates the predicates, sorts and computed keys of a query
+// on the records of a record type. Like the joins and subqueries of the
+// pq driver, records referenced by keypaths and selected by subqueries
+// are limited to those visible to the query.
+type evaluator struct {
+	db                   *database
+	recordType           string
+	accessControlOptions *skydb.AccessControlOptions
+
+	keyPaths   map[string][]skydb.FieldType
+	subqueries map[string]*subqueryResult
+}
+
+func (db *database) newEvaluator(recordType string, accessControlOptions *skydb.AccessControlOptions) *evaluator {
+	return &evaluator{
+		db:                   db,
+		recordType:           recordType,
+		accessControlOptions: accessControlOptions,
+		keyPaths:             map[string][]skydb.FieldType{},
+		subqueries:           map[string]*subqueryResult{},
+	}
+}
+
+// checksAccess returns whether the records are filtered by their ACL.
+func (e *evaluator) checksAccess() bool {
+	if e.db.DatabaseType() != skydb.PublicDatabase {
+		return false
+	}
+	return e.accessControlOptions == nil || !e.accessControlOptions.BypassAccessControl
+}
+
+func (e *evaluator) viewAsUser() *skydb.AuthInfo {
+	if e.accessControlOptions == nil {
+		return nil
+	}
+	return e.accessControlOptions.ViewAsUser
+}
+
+// inDatabase returns whether the record is in the database of the query.
+func (e *evaluator) inDatabase(record *skydb.Record) bool {
+	return e.db.DatabaseType() == skydb.UnionDatabase || record.DatabaseID == e.db.userID
+}
+
+// visible returns whether a record joined by a keypath or selected by a
+// subquery can be seen by the query.
+func (e *evaluator) visible(record *skydb.Record) bool {
+	if !record.DeletedAt.IsZero() || !e.inDatabase(record) {
+		return false
+	}
+	return !e.checksAccess() || accessible(record, e.viewAsUser(), skydb.ReadLevel)
+}
+
+// accessible returns whether the user has the access level to the record
+// by its ACL. A record with nil ACL is accessible to everyone, and the
+// owner always has access to the record.
+func accessible(record *skydb.Record, user *skydb.AuthInfo, level skydb.RecordACLLevel) bool {
+	if record.ACL == nil {
+		return true
+	}
+
+	if user != nil {
+		if record.OwnerID == user.ID {
+			return true
+		}
+		for _, entry := range record.ACL {
+			if entry.UserID != "" && entry.UserID == user.ID {
+				return true
+			}
+			if entry.Role != "" && containsString(user.Roles, entry.Role) {
+				return true
+			}
+		}
+	}
+
+	for _, entry := range record.ACL {
+		if !entry.Public {
+			continue
+		}
+		if level == skydb.ReadLevel || (level == skydb.WriteLevel && entry.Level == skydb.WriteLevel) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldValue returns the value of a field of the record, or nil if the
+// field has no value.
+func fieldValue(record *skydb.Record, field string) interface{} {
+	switch field {
+	case "_id":
+		return record.ID.Key
+	case "_database_id":
+		return record.DatabaseID
+	case "_owner_id":
+		return record.OwnerID
+	case "_created_by":
+		return record.CreatorID
+	case "_updated_by":
+		return record.UpdaterID
+	case "_created_at":
+		return record.CreatedAt
+	case "_updated_at":
+		return record.UpdatedAt
+	case "_deleted_at":
+		if record.DeletedAt.IsZero() {
+			return nil
+		}
+		return record.DeletedAt
+	case "_access":
+		if record.ACL == nil {
+			return nil
+		}
+		return record.ACL
+	default:
+		return record.Data[field]
+	}
+}
+
+// keyPathTypes returns the field types of the components of the keypath.
+func (e *evaluator) keyPathTypes(keyPath string) ([]skydb.FieldType, error) {
+	if fieldTypes, ok := e.keyPaths[keyPath]; ok {
+		return fieldTypes, nil
+	}
+
+	fieldTypes, err := skydb.TraverseColumnTypes(e.db, e.recordType, keyPath)
+	if err != nil {
+		return nil, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+	}
+	e.keyPaths[keyPath] = fieldTypes
+	return fieldTypes, nil
+}
+
+// keyPathValue returns the value at the keypath of the record. The value
+// is nil if a referenced record does not exist or is not visible.
+func (e *evaluator) keyPathValue(record *skydb.Record, keyPath string) (interface{}, skydb.FieldType, error) {
+	fieldTypes, err := e.keyPathTypes(keyPath)
+	if err != nil {
+		return nil, skydb.FieldType{}, err
+	}
+
+	components := strings.Split(keyPath, ".")
+	last := len(components) - 1
+	for i, component := range components[:last] {
+		ref, ok := fieldValue(record, component).(skydb.Reference)
+		if !ok {
+			return nil, fieldTypes[last], nil
+		}
+
+		row, ok := e.db.c.get(recordTable(fieldTypes[i].ReferenceType), ref.ID.Key)
+		if !ok {
+			return nil, fieldTypes[last], nil
+		}
+		referenced := row.(recordRow).record
+		if !e.visible(&referenced) {
+			return nil, fieldTypes[last], nil
+		}
+		record = &referenced
+	}
+	return fieldValue(record, components[last]), fieldTypes[last], nil
+}
+
+func (e *evaluator) evaluate(expr skydb.Expression, record *skydb.Record) (operand, error) {
+	switch expr.Type {
+	case skydb.Literal:
+		return operand{expr: expr, value: expr.Value}, nil
+	case skydb.KeyPath:
+		value, fieldType, err := e.keyPathValue(record, expr.Value.(string))
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{expr: expr, value: value, fieldType: fieldType}, nil
+	case skydb.Function:
+		value, err := e.evaluateFunc(expr.Value, record)
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{expr: expr, value: value, fieldType: skydb.FieldType{Type: skydb.TypeNumber}}, nil
+	}
+	return operand{}, skyerr.NewError(skyerr.RecordQueryInvalid, `unexpected expression type`)
+}
+
+func (e *evaluator) evaluateFunc(fn interface{}, record *skydb.Record) (interface{}, error) {
+	switch f := fn.(type) {
+	case skydb.DistanceFunc:
+		loc, ok := fieldValue(record, f.Field).(skydb.Location)
+		if !ok {
+			return nil, nil
+		}
+		return distance(loc, f.Location), nil
+	default:
+		return nil, fmt.Errorf("got unrecgonized skydb.Func = %T", fn)
+	}
+}
+
+// distance returns the distance in meters between two locations on a
+// sphere.
+func distance(a skydb.Location, b skydb.Location) float64 {
+	lat1 := a.Lat() * math.Pi / 180
+	lat2 := b.Lat() * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Lng() - a.Lng()) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// match evaluates the predicate on the record.
+func (e *evaluator) match(p skydb.Predicate, record *skydb.Record) (tri, error) {
+	switch p.Operator {
+	case skydb.And:
+		result := triTrue
+		for _, child := range p.Children {
+			t, err := e.match(child.(skydb.Predicate), record)
+			if err != nil {
+				return triUnknown, err
+			}
+			if t == triFalse {
+				return triFalse, nil
+			} else if t == triUnknown {
+				result = triUnknown
+			}
+		}
+		return result, nil
+	case skydb.Or:
+		result := triFalse
+		for _, child := range p.Children {
+			t, err := e.match(child.(skydb.Predicate), record)
+			if err != nil {
+				return triUnknown, err
+			}
+			if t == triTrue {
+				return triTrue, nil
+			} else if t == triUnknown {
+				result = triUnknown
+			}
+		}
+		return result, nil
+	case skydb.Not:
+		t, err := e.match(p.Children[0].(skydb.Predicate), record)
+		return t.not(), err
+	case skydb.Functional:
+		expr := p.Children[0].(skydb.Expression)
+		fn, ok := expr.Value.(skydb.UserRelationFunc)
+		if !ok {
+			panic("the specified function cannot be used as a functional predicate")
+		}
+		return e.matchUserRelation(fn, record)
+	case skydb.Exists:
+		return e.matchExists(p.Children[0].(skydb.Expression).Value.(skydb.RecordSubquery), record)
+	case skydb.In:
+		return e.matchIn(p, record)
+	case skydb.Equal, skydb.NotEqual, skydb.GreaterThan, skydb.GreaterThanOrEqual,
+		skydb.LessThan, skydb.LessThanOrEqual, skydb.Like, skydb.ILike:
+		return e.matchComparison(p, record)
+	}
+	return triUnknown, fmt.Errorf("operator `%v` is not supported", p.Operator)
+}
+
+func (e *evaluator) matchComparison(p skydb.Predicate, record *skydb.Record) (tri, error) {
+	lhs, err := e.evaluate(p.Children[0].(skydb.Expression), record)
+	if err != nil {
+		return triUnknown, err
+	}
+	rhs, err := e.evaluate(p.Children[1].(skydb.Expression), record)
+	if err != nil {
+		return triUnknown, err
+	}
+
+	if p.Operator == skydb.Equal || p.Operator == skydb.NotEqual {
+		if lhs.expr.IsLiteralNull() {
+			lhs, rhs = rhs, lhs
+		}
+		if rhs.expr.IsLiteralNull() {
+			// IS NULL and IS NOT NULL
+			return triOf((lhs.value == nil) == (p.Operator == skydb.Equal)), nil
+		}
+	}
+
+	if lhs.value == nil || rhs.value == nil {
+		return triUnknown, nil
+	}
+
+	caseInsensitive := lhs.caseInsensitive() || rhs.caseInsensitive()
+	switch p.Operator {
+	case skydb.Like, skydb.ILike:
+		s, sok := lhs.value.(string)
+		pattern, pok := rhs.value.(string)
+		if !sok || !pok {
+			return triUnknown, fmt.Errorf("comparison operator `%v` is not supported for %T and %T", p.Operator, lhs.value, rhs.value)
+		}
+		matched, err := like(s, pattern, caseInsensitive || p.Operator == skydb.ILike)
+		return triOf(matched), err
+	case skydb.Equal:
+		return triOf(equal(lhs.value, rhs.value, caseInsensitive)), nil
+	case skydb.NotEqual:
+		return triOf(!equal(lhs.value, rhs.value, caseInsensitive)), nil
+	}
+
+	c, ok := compare(lhs.value, rhs.value, caseInsensitive)
+	if !ok {
+		return triUnknown, fmt.Errorf("cannot compare %T with %T", lhs.value, rhs.value)
+	}
+	switch p.Operator {
+	case skydb.GreaterThan:
+		return triOf(c > 0), nil
+	case skydb.GreaterThanOrEqual:
+		return triOf(c >= 0), nil
+	case skydb.LessThan:
+		return triOf(c < 0), nil
+	default:
+		return triOf(c <= 0), nil
+	}
+}
+
+func (e *evaluator) matchIn(p skydb.Predicate, record *skydb.Record) (tri, error) {
+	lhsExpr := p.Children[0].(skydb.Expression)
+	rhsExpr := p.Children[1].(skydb.Expression)
+
+	lhs, err := e.evaluate(lhsExpr, record)
+	if err != nil {
+		return triUnknown, err
+	}
+
+	if rhsExpr.IsSubquery() {
+		result, err := e.subquery(rhsExpr.Value.(skydb.RecordSubquery))
+		if err != nil {
+			return triUnknown, err
+		}
+		return in(lhs.value, result.values, lhs.caseInsensitive()), nil
+	}
+
+	rhs, err := e.evaluate(rhsExpr, record)
+	if err != nil {
+		return triUnknown, err
+	}
+
+	if isGeometry(lhs.value) && isGeometry(rhs.value) {
+		return triOf(contains(rhs.value, lhs.value)), nil
+	} else if lhsExpr.Type == skydb.Literal && rhsExpr.Type == skydb.KeyPath {
+		if rhs.value == nil || lhs.value == nil {
+			return triUnknown, nil
+		}
+		return triOf(jsonExists(rhs.value, lhs.value)), nil
+	} else if lhsExpr.Type == skydb.KeyPath && rhsExpr.Type == skydb.Literal {
+		values, ok := rhs.value.([]interface{})
+		if !ok {
+			values = []interface{}{rhs.value}
+		}
+		return in(lhs.value, values, lhs.caseInsensitive()), nil
+	}
+	return triUnknown, errCannotCompareUsingInOperator
+}
+
+// in returns whether the value equals to any of the values, with the
+// semantics of the SQL IN operator.
+func in(value interface{}, values []interface{}, caseInsensitive bool) tri {
+	if value == nil {
+		return triUnknown
+	}
+
+	result := triFalse
+	for _, v := range values {
+		if v == nil {
+			result = triUnknown
+		} else if equal(value, v, caseInsensitive) {
+			return triTrue
+		}
+	}
+	return result
+}
+
+// jsonExists returns whether the string is an element of a JSON array, a
+// key of a JSON object or the JSON string itself, like jsonb_exists of
+// PostgreSQL.
+func jsonExists(container interface{}, value interface{}) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	switch c := container.(type) {
+	case []interface{}:
+		for _, item := range c {
+			if item == s {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		_, ok := c[s]
+		return ok
+	case string:
+		return c == s
+	}
+	return false
+}
+
+func (e *evaluator) matchUserRelation(fn skydb.UserRelationFunc, record *skydb.Record) (tri, error) {
+	keyPath := fn.KeyPath
+	if keyPath == "_owner" || keyPath == "" {
+		keyPath = "_owner_id"
+	}
+	value, _, err := e.keyPathValue(record, keyPath)
+	if err != nil {
+		return triUnknown, err
+	}
+	user, ok := normalize(value).(string)
+	if !ok {
+		return triUnknown, nil
+	}
+
+	direction := fn.RelationDirection
+	if direction == "" {
+		direction = "outward"
+	}
+
+	table := relationTable(fn.RelationName)
+	if direction == "outward" || direction == "mutual" {
+		if _, ok := e.db.c.get(table, relationKey(fn.User, user)); !ok {
+			return triFalse, nil
+		}
+	}
+	if direction == "inward" || direction == "mutual" {
+		if _, ok := e.db.c.get(table, relationKey(user, fn.User)); !ok {
+			return triFalse, nil
+		}
+	}
+	return triTrue, nil
+}
+
+func (e *evaluator) matchExists(subquery skydb.RecordSubquery, record *skydb.Record) (tri, error) {
+	keys, err := e.existsKeys(subquery)
+	if err != nil {
+		return triUnknown, err
+	}
+	return triOf(keys[record.ID.Key]), nil
+}
+
+// existsKeys returns the keys of the records referenced by the records
+// selected by the subquery of an exists predicate.
+func (e *evaluator) existsKeys(subquery skydb.RecordSubquery) (map[string]bool, error) {
+	result, err := e.subquery(subquery)
+	if err != nil {
+		return nil, err
+	}
+	if result.fieldType.Type != skydb.TypeReference || result.fieldType.ReferenceType != e.recordType {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`keypath "%s" of subquery is not a reference to "%s"`, subquery.KeyPath, e.recordType)
+	}
+	return result.keys, nil
+}
+
+// prepare checks the keypaths and subqueries of the predicate, so that an
+// invalid predicate fails even if there is no record to match.
+func (e *evaluator) prepare(p skydb.Predicate) error {
+	for _, child := range p.Children {
+		switch c := child.(type) {
+		case skydb.Predicate:
+			if err := e.prepare(c); err != nil {
+				return err
+			}
+		case skydb.Expression:
+			var err error
+			switch {
+			case c.IsKeyPath():
+				_, err = e.keyPathTypes(c.Value.(string))
+			case c.IsSubquery() && p.Operator == skydb.Exists:
+				_, err = e.existsKeys(c.Value.(skydb.RecordSubquery))
+			case c.IsSubquery():
+				_, err = e.subquery(c.Value.(skydb.RecordSubquery))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subquery returns the values at the keypath of the records selected by
+// the subquery. A subquery does not depend on the record being matched,
+// so the result is evaluated once for each query.
+func (e *evaluator) subquery(subquery skydb.RecordSubquery) (*subqueryResult, error) {
+	cacheKey := fmt.Sprintf("%#v", subquery)
+	if result, ok := e.subqueries[cacheKey]; ok {
+		return result, nil
+	}
+
+	sub := e.db.newEvaluator(subquery.Type, e.accessControlOptions)
+	fieldTypes, err := sub.keyPathTypes(subquery.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sub.prepare(subquery.Predicate); err != nil {
+		return nil, err
+	}
+	rows, err := sub.filter(sub.visibleRows(), subquery.Predicate)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &subqueryResult{
+		fieldType: fieldTypes[len(fieldTypes)-1],
+		keys:      map[string]bool{},
+	}
+	for _, row := range rows {
+		value, _, err := sub.keyPathValue(&row.record, subquery.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		result.values = append(result.values, value)
+		if ref, ok := value.(skydb.Reference); ok {
+			result.keys[ref.ID.Key] = true
+		}
+	}
+	e.subqueries[cacheKey] = result
+	return result, nil
+}
+
+// visibleRows returns the records of the record type visible to the
+// evaluator, in the order of creation.
+func (e *evaluator) visibleRows() []recordRow {
+	rows := []recordRow{}
+	for _, row := range e.db.c.scan(recordTable(e.recordType)) {
+		r := row.(recordRow)
+		if e.visible(&r.record) {
+			rows = append(rows, r)
+		}
+	}
+	sortRows(rows)
+	return rows
+}
+
+// filter returns the rows matching the predicate.
+func (e *evaluator) filter(rows []recordRow, p skydb.Predicate) ([]recordRow, error) {
+	if p.IsEmpty() {
+		return rows, nil
+	}
+
+	matched := []recordRow{}
+	for _, row := range rows {
+		t, err := e.match(p, &row.record)
+		if err != nil {
+			return nil, err
+		}
+		if t == triTrue {
+			matched = append(matched, row)
+		}
+	}
+	return matched, nil
+}
+
+// sortRows sorts rows in the order of creation.
+func sortRows(rows []recordRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].seq < rows[j].seq
+	})
+}
+
+// sort sorts the rows by the sorts of a query. Null values are placed
+// after other values in ascending order and before other values in
+// descending order, which is the default of PostgreSQL.
+func (e *evaluator) sort(rows []recordRow, sorts []skydb.Sort) error {
+	if len(sorts) == 0 {
+		return nil
+	}
+
+	keys := make([][]interface{}, len(rows))
+	for i := range rows {
+		keys[i] = make([]interface{}, len(sorts))
+		for j, s := range sorts {
+			var value interface{}
+			var err error
+			switch s.Expression.Type {
+			case skydb.KeyPath:
+				value, _, err = e.keyPathValue(&rows[i].record, s.Expression.Value.(string))
+			case skydb.Function:
+				value, err = e.evaluateFunc(s.Expression.Value, &rows[i].record)
+			default:
+				err = errors.New("invalid Sort: specify either KeyPath or Func")
+			}
+			if err != nil {
+				return err
+			}
+			keys[i][j] = value
+		}
+	}
+
+	indexes := make([]int, len(rows))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		for j, s := range sorts {
+			c := compareForSort(keys[indexes[a]][j], keys[indexes[b]][j])
+			if s.Order == skydb.Descending {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	sorted := make([]recordRow, len(rows))
+	for i, index := range indexes {
+		sorted[i] = rows[index]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+// compareForSort compares values for sorting in ascending order, where
+// null is greater than other values.
+func compareForSort(a interface{}, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	if c, ok := compare(a, b, false); ok {
+		return c
+	}
+	return strings.Compare(fmt.Sprint(normalize(a)), fmt.Sprint(normalize(b)))
+}
+
+// normalize returns the value in the form used in comparison.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case skydb.Reference:
+		return v.ID.Key
+	case *skydb.Asset:
+		if v == nil {
+			return nil
+		}
+		return v.Name
+	case time.Time:
+		return v.UTC()
+	case *skydb.Location:
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+	if number, ok := toFloat64(value); ok {
+		return number
+	}
+	return value
+}
+
+// compare compares two scalar values of the same type. The second value
+// returned is false if the values cannot be compared.
+func compare(a interface{}, b interface{}, caseInsensitive bool) (int, bool) {
+	a, b = normalize(a), normalize(b)
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1, true
+			case av > bv:
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			if caseInsensitive {
+				av, bv = strings.ToLower(av), strings.ToLower(bv)
+			}
+			return strings.Compare(av, bv), true
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1, true
+			case av.After(bv):
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, true
+			case bv:
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// equal returns whether two non-null values are equal. Values which are
+// not scalar are compared in their JSON form.
+func equal(a interface{}, b interface{}, caseInsensitive bool) bool {
+	if c, ok := compare(a, b, caseInsensitive); ok {
+		return c == 0
+	}
+
+	a, b = normalize(a), normalize(b)
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	aJSON, err := jsonValue(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := jsonValue(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aJSON, bJSON)
+}
+
+// like returns whether the string matches the pattern of the SQL LIKE
+// operator, in which % matches any sequence of characters, _ matches any
+// character and \ escapes the next character.
+func like(s string, pattern string, caseInsensitive bool) (bool, error) {
+	var buf strings.Builder
+	buf.WriteString("(?s)")
+	if caseInsensitive {
+		buf.WriteString("(?i)")
+	}
+	buf.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			buf.WriteString(".*")
+		case r == '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+func isGeometry(value interface{}) bool {
+	switch value.(type) {
+	case skydb.Location, *skydb.Location, skydb.Geometry:
+		return true
+	}
+	return false
+}
+
+// contains returns whether the geometry contains the point. Only a point
+// in a polygon or a multipolygon is supported.
+func contains(geometry interface{}, point interface{}) bool {
+	var x, y float64
+	switch p := normalize(point).(type) {
+	case skydb.Location:
+		x, y = p.Lng(), p.Lat()
+	case skydb.Geometry:
+		coordinates, ok := p["coordinates"].([]interface{})
+		if p["type"] != "Point" || !ok || len(coordinates) < 2 {
+			return false
+		}
+		x, _ = toFloat64(coordinates[0])
+		y, _ = toFloat64(coordinates[1])
+	default:
+		return false
+	}
+
+	g, ok := geometry.(skydb.Geometry)
+	if !ok {
+		return false
+	}
+	coordinates, _ := g["coordinates"].([]interface{})
+	switch g["type"] {
+	case "Polygon":
+		return polygonContains(coordinates, x, y)
+	case "MultiPolygon":
+		for _, polygon := range coordinates {
+			if rings, ok := polygon.([]interface{}); ok && polygonContains(rings, x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// polygonContains returns whether the point is inside the outer ring and
+// outside the holes of a GeoJSON polygon.
+func polygonContains(rings []interface{}, x float64, y float64) bool {
+	for i, ring := range rings {
+		points, _ := ring.([]interface{})
+		inside := ringContains(points, x, y)
+		if i == 0 && !inside {
+			return false
+		} else if i > 0 && inside {
+			return false
+		}
+	}
+	return len(rings) > 0
+}
+
+// ringContains returns whether the point is inside the ring by ray casting.
+func ringContains(points []interface{}, x float64, y float64) bool {
+	inside := false
+	for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+		xi, yi, ok := coordinatePair(points[i])
+		xj, yj, ok2 := coordinatePair(points[j])
+		if !ok || !ok2 {
+			return false
+		}
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func coordinatePair(point interface{}) (float64, float64, bool) {
+	pair, ok := point.([]interface{})
+	if !ok || len(pair) < 2 {
+		return 0, 0, false
+	}
+	x, xok := toFloat64(pair[0])
+	y, yok := toFloat64(pair[1])
+	return x, y, xok && yok
+}
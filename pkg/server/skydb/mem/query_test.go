@@ -0,0 +1,55 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuery(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		for _, key := range []string{"note2", "note3", "note1"} {
+			note := skydb.Record{
+				ID:      skydb.NewRecordID("note", key),
+				OwnerID: "user1",
+				Data:    skydb.Data{"title": key},
+			}
+			So(db.Save(&note), ShouldBeNil)
+		}
+
+		Convey("queries all records in order of creation", func() {
+			records, err := exhaustRows(db.Query(&skydb.Query{Type: "note"}, &skydb.AccessControlOptions{
+				BypassAccessControl: true,
+			}))
+			So(err, ShouldBeNil)
+
+			keys := []string{}
+			for _, record := range records {
+				keys = append(keys, record.ID.Key)
+			}
+			So(keys, ShouldResemble, []string{"note2", "note3", "note1"})
+		})
+	})
+}
@@ -0,0 +1,907 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// recordRow is a record in the table of its record type. The data of the
+// record holds values in the form returned by storeValue. seq is the
+// order of creation of the record, which is the order of records not
+// sorted by a query.
+type recordRow struct {
+	record skydb.Record
+	seq    uint64
+}
+
+func sequenceKey(recordType string, field string) string {
+	return recordType + "/" + field
+}
+
+// nextSequence returns the next value of the sequence of a field.
+func (db *database) nextSequence(recordType string, field string) int64 {
+	var value int64
+	if v, ok := db.c.get(sequenceTable, sequenceKey(recordType, field)); ok {
+		value = v.(int64)
+	}
+	value++
+	db.c.put(sequenceTable, sequenceKey(recordType, field), value)
+	return value
+}
+
+// advanceSequence makes the sequence of a field continue after the value,
+// like the pq driver does when a value is saved to a sequence field.
+func (db *database) advanceSequence(recordType string, field string, value int64) {
+	if v, ok := db.c.get(sequenceTable, sequenceKey(recordType, field)); ok && v.(int64) >= value {
+		return
+	}
+	db.c.put(sequenceTable, sequenceKey(recordType, field), value)
+}
+
+// outputRecord returns a copy of the stored record.
+func outputRecord(row recordRow) skydb.Record {
+	record := copyRecord(row.record)
+	if record.Data == nil {
+		record.Data = skydb.Data{}
+	}
+	record.Transient = nil
+	return record
+}
+
+func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
+	schema, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+
+	if len(schema) == 0 { // record type has not been created
+		return skydb.ErrRecordNotFound
+	}
+
+	row, ok := db.c.get(recordTable(id.Type), id.Key)
+	if !ok {
+		return skydb.ErrRecordNotFound
+	}
+	r := row.(recordRow)
+	if !r.record.DeletedAt.IsZero() || !db.newEvaluator(id.Type, nil).inDatabase(&r.record) {
+		return skydb.ErrRecordNotFound
+	}
+
+	*record = outputRecord(r)
+	return nil
+}
+
+// GetForUpdate is the same as Get, as transactions are serialized.
+func (db *database) GetForUpdate(id skydb.RecordID, record *skydb.Record) error {
+	return db.Get(id, record)
+}
+
+// GetByIDs only support one type of records at a time. If you want to query
+// array of ids belongs to different type, you need to call this method multiple
+// time.
+func (db *database) GetByIDs(ids []skydb.RecordID, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("db.GetByIDs received empty array")
+	}
+	keys := map[string]bool{}
+	recordType := ""
+	for _, recordID := range ids {
+		if recordID.Key != "" {
+			keys[recordID.Key] = true
+		}
+		if recordID.Type != "" && recordType == "" {
+			recordType = recordID.Type
+		}
+	}
+
+	schema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return nil, err
+	}
+	if len(schema) == 0 {
+		return nil, skydb.ErrRecordNotFound
+	}
+
+	e := db.newEvaluator(recordType, accessControlOptions)
+	records := []skydb.Record{}
+	for _, row := range db.allRows(recordType) {
+		if !keys[row.record.ID.Key] || !row.record.DeletedAt.IsZero() || !e.inDatabase(&row.record) {
+			continue
+		}
+		if e.checksAccess() && !accessible(&row.record, e.viewAsUser(), skydb.ReadLevel) {
+			continue
+		}
+		records = append(records, outputRecord(row))
+	}
+	return newRows(records, nil), nil
+}
+
+// Save attempts to do a upsert
+func (db *database) Save(record *skydb.Record) error {
+	if record.ID.Key == "" {
+		return errors.New("db.save: got empty record id")
+	}
+	if record.ID.Type == "" {
+		return fmt.Errorf("db.save %s: got empty record type", record.ID.Key)
+	}
+	if record.OwnerID == "" {
+		return fmt.Errorf("db.save %s: got empty OwnerID", record.ID.Key)
+	}
+
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	schema, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return skyerr.MakeError(fmt.Errorf(`db.save %s: relation "%s" does not exist`, record.ID, record.ID.Type))
+	}
+
+	return db.c.write(func() error {
+		return db.save(schema, record)
+	})
+}
+
+func (db *database) save(schema skydb.RecordSchema, record *skydb.Record) error {
+	recordType := record.ID.Type
+	table := recordTable(recordType)
+
+	var row recordRow
+	stored, updating := db.c.get(table, record.ID.Key)
+	if updating {
+		row = stored.(recordRow)
+		if row.record.DatabaseID != db.userID {
+			return skyerr.NewErrorf(skyerr.Duplicated, "violate unique constraint")
+		}
+		row.record = copyRecord(row.record)
+	} else {
+		row = recordRow{
+			record: skydb.Record{
+				ID:         record.ID,
+				DatabaseID: db.userID,
+				OwnerID:    record.OwnerID,
+				CreatedAt:  record.CreatedAt.UTC(),
+				CreatorID:  record.CreatorID,
+			},
+			seq: db.c.store.nextSeq(),
+		}
+	}
+
+	r := &row.record
+	if r.Data == nil {
+		r.Data = skydb.Data{}
+	}
+	r.ACL = nil
+	if record.ACL != nil {
+		r.ACL = append(skydb.RecordACL{}, record.ACL...)
+	}
+	r.UpdatedAt = record.UpdatedAt.UTC()
+	r.UpdaterID = record.UpdaterID
+
+	for _, key := range sortedDataKeys(record.Data) {
+		fieldType, ok := schema[key]
+		if !ok || strings.HasPrefix(key, "_") {
+			return skyerr.MakeError(fmt.Errorf(`db.save %s: column "%s" of relation "%s" does not exist`, record.ID, key, recordType))
+		}
+
+		var value interface{}
+		var err error
+		switch v := record.Data[key].(type) {
+		case skydb.Unknown:
+			// Do not modify fields with unknown type because they are
+			// managed by the developer.
+			continue
+		case skydb.Sequence:
+			// The value is assigned from the sequence of the field.
+			continue
+		case skydb.FieldOperation:
+			value, err = applyFieldOperation(key, fieldType, r.Data[key], v)
+			if err != nil {
+				if _, ok := err.(skyerr.Error); ok {
+					return err
+				}
+			}
+		default:
+			value, err = storeValue(fieldType, v)
+		}
+		if err != nil {
+			return skyerr.NewErrorf(
+				skyerr.InvalidArgument,
+				fmt.Sprintf("failed to save %s: %s", record.ID, err),
+			)
+		}
+
+		if value == nil {
+			delete(r.Data, key)
+		} else {
+			r.Data[key] = value
+		}
+		if number, ok := value.(int64); ok && fieldType.Type == skydb.TypeSequence {
+			db.advanceSequence(recordType, key, number)
+		}
+	}
+
+	if !updating {
+		for _, field := range sortedSchemaKeys(schema) {
+			fieldType := schema[field]
+			if _, ok := r.Data[field]; ok || strings.HasPrefix(field, "_") {
+				continue
+			}
+			if _, ok := record.Data[field]; ok {
+				if _, isSequence := record.Data[field].(skydb.Sequence); !isSequence {
+					continue
+				}
+			}
+
+			if fieldType.Type == skydb.TypeSequence {
+				r.Data[field] = db.nextSequence(recordType, field)
+			} else if fieldType.Constraints != nil && fieldType.Constraints.Default != nil {
+				value, err := storeValue(fieldType, fieldType.Constraints.Default)
+				if err != nil {
+					return skyerr.MakeError(err)
+				}
+				r.Data[field] = value
+			}
+		}
+	}
+
+	if err := db.checkRow(schema, row); err != nil {
+		return err
+	}
+
+	db.c.put(table, record.ID.Key, row)
+	transient := record.Transient
+	*record = outputRecord(row)
+	record.Transient = transient
+
+	if updating {
+		db.c.emit(&row.record, skydb.RecordUpdated)
+	} else {
+		db.c.emit(&row.record, skydb.RecordCreated)
+	}
+	return nil
+}
+
+func sortedDataKeys(data skydb.Data) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSchemaKeys(schema skydb.RecordSchema) []string {
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyFieldOperation returns the new value of a field by applying the
+// field operation to its current value.
+func applyFieldOperation(field string, fieldType skydb.FieldType, current interface{}, op skydb.FieldOperation) (interface{}, error) {
+	if err := op.CheckFieldType(field, fieldType); err != nil {
+		return nil, err
+	}
+
+	switch op.Operator {
+	case skydb.IncrementOperator:
+		number, _ := toFloat64(op.Value)
+		currentNumber, _ := toFloat64(current)
+		return storeValue(fieldType, currentNumber+number)
+	case skydb.MaxOperator:
+		number, _ := toFloat64(op.Value)
+		if currentNumber, ok := toFloat64(current); ok && currentNumber > number {
+			number = currentNumber
+		}
+		return storeValue(fieldType, number)
+	}
+
+	items, err := jsonValue(op.Value)
+	if err != nil {
+		return nil, err
+	}
+	operands, _ := items.([]interface{})
+
+	var elements []interface{}
+	switch c := current.(type) {
+	case nil:
+		elements = []interface{}{}
+	case []interface{}:
+		elements = c
+	default:
+		elements = []interface{}{c}
+	}
+
+	result := []interface{}{}
+	if op.Operator == skydb.PushOperator {
+		result = append(result, elements...)
+		result = append(result, operands...)
+	} else {
+		for _, element := range elements {
+			pulled := false
+			for _, operand := range operands {
+				if reflect.DeepEqual(element, operand) {
+					pulled = true
+					break
+				}
+			}
+			if !pulled {
+				result = append(result, element)
+			}
+		}
+	}
+	return storeValue(fieldType, result)
+}
+
+// checkRow checks a record to be saved against the constraints of its
+// fields, the unique indexes of its record type and the existence of the
+// records and assets it references.
+func (db *database) checkRow(schema skydb.RecordSchema, row recordRow) error {
+	record := &row.record
+	recordType := record.ID.Type
+
+	for _, field := range sortedSchemaKeys(schema) {
+		fieldType := schema[field]
+		value := fieldValue(record, field)
+		if value == nil {
+			if fieldType.Constraints != nil {
+				if err := fieldType.Constraints.Validate(nil); err != nil {
+					return constraintViolationError(field, err)
+				}
+			}
+			continue
+		}
+
+		switch fieldType.Type {
+		case skydb.TypeReference:
+			ref := value.(skydb.Reference)
+			if _, ok := db.c.get(recordTable(fieldType.ReferenceType), ref.ID.Key); !ok {
+				return skyerr.MakeError(fmt.Errorf(
+					`insert or update on table "%s" violates foreign key constraint on "%s"`,
+					recordType, field,
+				))
+			}
+		case skydb.TypeAsset:
+			asset := value.(*skydb.Asset)
+			if _, ok := db.c.get(assetTable, asset.Name); !ok {
+				return skyerr.MakeError(fmt.Errorf(
+					`insert or update on table "%s" violates foreign key constraint on "%s"`,
+					recordType, field,
+				))
+			}
+		}
+
+		if fieldType.Constraints != nil {
+			if err := fieldType.Constraints.Validate(value); err != nil {
+				return constraintViolationError(field, err)
+			}
+		}
+	}
+
+	others := []recordRow{}
+	for key, other := range db.c.scan(recordTable(recordType)) {
+		if key != record.ID.Key {
+			others = append(others, other.(recordRow))
+		}
+	}
+	sortRows(others)
+
+	for _, field := range sortedSchemaKeys(schema) {
+		fieldType := schema[field]
+		if fieldType.Constraints == nil || !fieldType.Constraints.Unique {
+			continue
+		}
+		value := fieldValue(record, field)
+		if value == nil {
+			continue
+		}
+
+		key := uniqueKey(fieldType, value)
+		for _, other := range others {
+			if otherValue := fieldValue(&other.record, field); otherValue != nil && uniqueKey(fieldType, otherValue) == key {
+				return skyerr.NewErrorWithInfo(
+					skyerr.ConstraintViolated,
+					fmt.Sprintf("%s: value is not unique", field),
+					map[string]interface{}{
+						"field":      field,
+						"constraint": skydb.UniqueConstraint,
+					},
+				)
+			}
+		}
+	}
+
+	for _, index := range db.indexes(recordType) {
+		if !index.Unique {
+			continue
+		}
+		key, ok, err := db.indexKey(recordType, schema, index, record)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, other := range others {
+			otherKey, ok, err := db.indexKey(recordType, schema, index, &other.record)
+			if err != nil {
+				return err
+			}
+			if ok && otherKey == key {
+				return skyerr.NewErrorf(
+					skyerr.Duplicated,
+					fmt.Sprintf("violate unique constraint"),
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// constraintViolationError returns a ConstraintViolated error if err is
+// a skydb.ConstraintViolation on the specified field.
+func constraintViolationError(field string, err error) error {
+	violation, ok := err.(*skydb.ConstraintViolation)
+	if !ok {
+		return skyerr.MakeError(err)
+	}
+
+	return skyerr.NewErrorWithInfo(
+		skyerr.ConstraintViolated,
+		fmt.Sprintf("%s: %s", field, violation.Message),
+		map[string]interface{}{
+			"field":      field,
+			"constraint": violation.Constraint,
+		},
+	)
+}
+
+func (db *database) Delete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	schema, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return skydb.ErrRecordNotFound
+	}
+
+	return db.c.write(func() error {
+		stored, ok := db.c.get(recordTable(id.Type), id.Key)
+		if !ok {
+			return skydb.ErrRecordNotFound
+		}
+		row := stored.(recordRow)
+		if row.record.DatabaseID != db.userID {
+			return skydb.ErrRecordNotFound
+		}
+
+		if isSoftDeleteEnabled(schema) {
+			if !row.record.DeletedAt.IsZero() {
+				return skydb.ErrRecordNotFound
+			}
+			row.record = copyRecord(row.record)
+			row.record.DeletedAt = timeNow()
+			db.c.put(recordTable(id.Type), id.Key, row)
+			db.c.emit(&row.record, skydb.RecordUpdated)
+			return nil
+		}
+
+		return db.deleteRow(id, row)
+	})
+}
+
+// deleteRow removes a record from its table. Like a foreign key, records
+// referencing the deleted record are deleted or have the reference set to
+// null according to the on delete action of the reference field, and the
+// deletion fails if the action is restrict.
+func (db *database) deleteRow(id skydb.RecordID, row recordRow) error {
+	recordType := row.record.ID.Type
+	key := row.record.ID.Key
+	db.c.remove(recordTable(recordType), key)
+	db.c.emit(&row.record, skydb.RecordDeleted)
+
+	schemas := db.c.scan(schemaTable)
+	for _, referencingType := range sortedKeys(schemas) {
+		schema := schemas[referencingType].(skydb.RecordSchema)
+		for _, field := range sortedSchemaKeys(schema) {
+			fieldType := schema[field]
+			if fieldType.Type != skydb.TypeReference || fieldType.ReferenceType != recordType {
+				continue
+			}
+
+			var action skydb.ReferenceAction
+			if fieldType.Constraints != nil {
+				action = fieldType.Constraints.OnDelete
+			}
+
+			for _, referencing := range db.allRows(referencingType) {
+				ref, ok := referencing.record.Data[field].(skydb.Reference)
+				if !ok || ref.ID.Key != key {
+					continue
+				}
+
+				stored, ok := db.c.get(recordTable(referencingType), referencing.record.ID.Key)
+				if !ok {
+					// deleted by a cascade
+					continue
+				}
+				current := stored.(recordRow)
+
+				switch action {
+				case skydb.CascadeReference:
+					if err := db.deleteRow(id, current); err != nil {
+						return err
+					}
+				case skydb.SetNullReference:
+					current.record = copyRecord(current.record)
+					delete(current.record.Data, field)
+					db.c.put(recordTable(referencingType), current.record.ID.Key, current)
+					db.c.emit(&current.record, skydb.RecordUpdated)
+				default:
+					return skyerr.NewError(
+						skyerr.ConstraintViolated,
+						fmt.Sprintf("delete %s: failed to delete record because other records have reference to it", id),
+					)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (db *database) Undelete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	schema, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+	if !isSoftDeleteEnabled(schema) {
+		return skydb.ErrRecordNotFound
+	}
+
+	return db.c.write(func() error {
+		stored, ok := db.c.get(recordTable(id.Type), id.Key)
+		if !ok {
+			return skydb.ErrRecordNotFound
+		}
+		row := stored.(recordRow)
+		if row.record.DatabaseID != db.userID || row.record.DeletedAt.IsZero() {
+			return skydb.ErrRecordNotFound
+		}
+
+		row.record = copyRecord(row.record)
+		row.record.DeletedAt = time.Time{}
+		db.c.put(recordTable(id.Type), id.Key, row)
+		db.c.emit(&row.record, skydb.RecordUpdated)
+		return nil
+	})
+}
+
+// PurgeDeletedRecords removes the soft-deleted records one by one, so that
+// a record referenced by other records is skipped without failing the
+// others. It cannot be called in a transaction, like the pq driver.
+func (c *conn) PurgeDeletedRecords(deletedBefore time.Time) (uint64, error) {
+	logger := logging.CreateLogger(c.context, "skydb")
+	if c.tx != nil {
+		return 0, skydb.ErrDatabaseTxDidBegin
+	}
+
+	db := &database{c: c, databaseType: skydb.PublicDatabase}
+	schemas := c.scan(schemaTable)
+
+	var purged uint64
+	for _, recordType := range sortedKeys(schemas) {
+		if strings.HasPrefix(recordType, "_") || !isSoftDeleteEnabled(schemas[recordType].(skydb.RecordSchema)) {
+			continue
+		}
+
+		for _, row := range db.allRows(recordType) {
+			if row.record.DeletedAt.IsZero() || !row.record.DeletedAt.Before(deletedBefore) {
+				continue
+			}
+
+			id := row.record.ID
+			err := c.write(func() error {
+				stored, ok := c.get(recordTable(recordType), id.Key)
+				if !ok {
+					return skydb.ErrRecordNotFound
+				}
+				return db.deleteRow(id, stored.(recordRow))
+			})
+			if err == skydb.ErrRecordNotFound {
+				continue
+			} else if skyErr, ok := err.(skyerr.Error); ok && skyErr.Code() == skyerr.ConstraintViolated {
+				logger.WithField("record_id", id).
+					Infoln("Skipped purging record referenced by other records")
+				continue
+			} else if err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// queryRows returns the records matching the query in the sorted order,
+// before applying offset and limit. A nil schema is returned if the
+// record type has not been created.
+func (db *database) queryRows(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) ([]recordRow, skydb.RecordSchema, *evaluator, error) {
+	if query.Type == "" {
+		return nil, nil, nil, errors.New("got empty query type")
+	}
+
+	schema, err := db.RemoteColumnTypes(query.Type)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(schema) == 0 { // record type has not been created
+		return nil, nil, nil, nil
+	}
+
+	e := db.newEvaluator(query.Type, accessControlOptions)
+	if err := e.prepare(query.Predicate); err != nil {
+		return nil, nil, nil, err
+	}
+	for _, s := range query.Sorts {
+		switch s.Expression.Type {
+		case skydb.KeyPath:
+			if _, err := e.keyPathTypes(s.Expression.Value.(string)); err != nil {
+				return nil, nil, nil, err
+			}
+		case skydb.Function:
+			if _, ok := s.Expression.Value.(skydb.DistanceFunc); !ok {
+				return nil, nil, nil, fmt.Errorf("got unrecgonized skydb.Func = %T", s.Expression.Value)
+			}
+		default:
+			return nil, nil, nil, errors.New("invalid Sort: specify either KeyPath or Func")
+		}
+	}
+
+	rows := []recordRow{}
+	for _, row := range db.allRows(query.Type) {
+		if !row.record.DeletedAt.IsZero() && !query.IncludeDeleted {
+			continue
+		}
+		if !e.inDatabase(&row.record) {
+			continue
+		}
+		if e.checksAccess() && !accessible(&row.record, e.viewAsUser(), skydb.ReadLevel) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	rows, err = e.filter(rows, query.Predicate)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := e.sort(rows, query.Sorts); err != nil {
+		return nil, nil, nil, err
+	}
+	return rows, schema, e, nil
+}
+
+func (db *database) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	records, count, err := db.query(query, accessControlOptions)
+	if err != nil {
+		return nil, err
+	}
+	if records == nil { // record type has not been created
+		return skydb.EmptyRows, nil
+	}
+
+	var recordCount *uint64
+	if query.GetCount && len(records) > 0 {
+		recordCount = &count
+	}
+	return newRows(records, recordCount), nil
+}
+
+// query returns the records of a page of the query and the number of
+// records matching the query. The records are nil if the record type
+// has not been created.
+func (db *database) query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) ([]skydb.Record, uint64, error) {
+	rows, schema, e, err := db.queryRows(query, accessControlOptions)
+	if err != nil || schema == nil {
+		return nil, 0, err
+	}
+
+	if query.DesiredKeys != nil {
+		if schema, err = whitelistedRecordSchema(schema, query.DesiredKeys); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	count := uint64(len(rows))
+	if query.PartitionLimit != nil {
+		if rows, err = limitPartitions(rows, *query.PartitionLimit); err != nil {
+			return nil, 0, err
+		}
+	}
+	if query.Offset > 0 {
+		if query.Offset >= uint64(len(rows)) {
+			rows = rows[:0]
+		} else {
+			rows = rows[query.Offset:]
+		}
+	}
+	if query.Limit != nil && *query.Limit < uint64(len(rows)) {
+		rows = rows[:*query.Limit]
+	}
+
+	records := make([]skydb.Record, 0, len(rows))
+	for _, row := range rows {
+		record := outputRecord(row)
+		for key := range record.Data {
+			if _, ok := schema[key]; !ok {
+				delete(record.Data, key)
+			}
+		}
+
+		for key, expr := range query.ComputedKeys {
+			if expr.Type == skydb.KeyPath {
+				// recorddb does not support querying with computed keys
+				continue
+			}
+
+			value, err := e.evaluate(expr, &row.record)
+			if err != nil {
+				return nil, 0, err
+			}
+			if value.value != nil {
+				record.Set("_transient_"+key, value.value)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, count, nil
+}
+
+// limitPartitions returns the first rows of each value of the field of
+// the partition limit, keeping the order of the rows.
+func limitPartitions(rows []recordRow, partitionLimit skydb.PartitionLimit) ([]recordRow, error) {
+	counts := map[string]uint64{}
+	limited := []recordRow{}
+	for _, row := range rows {
+		key, err := jsonText(normalize(fieldValue(&row.record, partitionLimit.Field)))
+		if err != nil {
+			return nil, err
+		}
+		if counts[key] >= partitionLimit.Limit {
+			continue
+		}
+		counts[key]++
+		limited = append(limited, row)
+	}
+	return limited, nil
+}
+
+func whitelistedRecordSchema(schema skydb.RecordSchema, whitelistKeys []string) (skydb.RecordSchema, error) {
+	wlSchema := skydb.RecordSchema{}
+
+	for _, key := range whitelistKeys {
+		columnType, ok := schema[key]
+		if !ok {
+			return nil, fmt.Errorf(`unexpected key "%s"`, key)
+		}
+		wlSchema[key] = columnType
+	}
+	for key, value := range schema {
+		if strings.HasPrefix(key, "_") {
+			wlSchema[key] = value
+		}
+	}
+
+	return wlSchema, nil
+}
+
+func (db *database) QueryCount(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (uint64, error) {
+	rows, _, _, err := db.queryRows(&skydb.Query{
+		Type:           query.Type,
+		Predicate:      query.Predicate,
+		IncludeDeleted: query.IncludeDeleted,
+	}, accessControlOptions)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(rows)), nil
+}
+
+// ExplainQuery executes the query and describes how the records are
+// matched, as the in-memory database has no query plan.
+func (db *database) ExplainQuery(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (skydb.QueryExplanation, error) {
+	schema, err := db.RemoteColumnTypes(query.Type)
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	records, count, err := db.query(query, accessControlOptions)
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+	if records == nil { // record type has not been created
+		return skydb.QueryExplanation{}, nil
+	}
+
+	plan := map[string]interface{}{
+		"Node Type":     "Scan",
+		"Record Type":   query.Type,
+		"Soft Delete":   isSoftDeleteEnabled(schema),
+		"Scanned Rows":  len(db.c.scan(recordTable(query.Type))),
+		"Matched Rows":  count,
+		"Returned Rows": len(records),
+	}
+	if !query.Predicate.IsEmpty() {
+		plan["Filter"] = predicateCondition(query.Predicate)
+	}
+
+	return skydb.QueryExplanation{
+		Statement: fmt.Sprintf("SCAN %s", query.Type),
+		Args:      []interface{}{},
+		Plan:      plan,
+	}, nil
+}
+
+type rowsIter struct {
+	records     []skydb.Record
+	recordCount *uint64
+}
+
+func (rowsi *rowsIter) Close() error {
+	return nil
+}
+
+func (rowsi *rowsIter) Next(record *skydb.Record) error {
+	if len(rowsi.records) == 0 {
+		return io.EOF
+	}
+	*record = rowsi.records[0]
+	rowsi.records = rowsi.records[1:]
+	return nil
+}
+
+func (rowsi *rowsIter) OverallRecordCount() *uint64 {
+	return rowsi.recordCount
+}
+
+func newRows(records []skydb.Record, recordCount *uint64) *skydb.Rows {
+	return skydb.NewRows(&rowsIter{records, recordCount})
+}
@@ -0,0 +1,56 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordSave(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		db := c.PublicDB()
+
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"tags": skydb.FieldType{Type: skydb.TypeJSON},
+		})
+		So(err, ShouldBeNil)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "note1"),
+			OwnerID: "user1",
+			Data:    skydb.Data{"tags": []interface{}{"a", "b"}},
+		}
+		So(db.Save(&record), ShouldBeNil)
+
+		Convey("does not share data with the caller", func() {
+			record.Data["tags"].([]interface{})[0] = "changed"
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["tags"], ShouldResemble, []interface{}{"a", "b"})
+		})
+
+		Convey("rejects record of another database", func() {
+			record.Data = skydb.Data{}
+			err := c.PrivateDB("user1").Save(&record)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.Duplicated)
+		})
+	})
+}
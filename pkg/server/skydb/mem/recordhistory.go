@@ -0,0 +1,172 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+// recordHistoryRow keeps the record and diff of a revision serialized,
+// the same way the pq driver does, so that a revision read back is
+// identical between drivers.
+type recordHistoryRow struct {
+	Revision   skydb.RecordRevision
+	RecordData []byte
+	DiffData   []byte
+}
+
+func (c *conn) GetRecordHistoryTypes() ([]string, error) {
+	return sortedKeys(c.scan(recordHistoryTypeTable)), nil
+}
+
+func (c *conn) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	return c.write(func() error {
+		if enabled {
+			c.put(recordHistoryTypeTable, recordType, true)
+		} else {
+			c.remove(recordHistoryTypeTable, recordType)
+		}
+		return nil
+	})
+}
+
+func (c *conn) SaveRecordRevision(revision *skydb.RecordRevision) error {
+	if revision.RecordID.Type == "" || revision.RecordID.Key == "" {
+		return errors.New("invalid record revision: empty record id")
+	}
+
+	record := revision.Record
+	record.Transient = nil
+	recordData, err := json.Marshal((*skyconv.JSONRecord)(&record))
+	if err != nil {
+		return err
+	}
+
+	var diffData []byte
+	if revision.Diff != nil {
+		m := map[string]interface{}{}
+		for key, change := range revision.Diff {
+			m[key] = map[string]interface{}{
+				"old": skyconv.ToLiteral(change.Old),
+				"new": skyconv.ToLiteral(change.New),
+			}
+		}
+		if diffData, err = json.Marshal(m); err != nil {
+			return err
+		}
+	}
+
+	return c.write(func() error {
+		version := 0
+		for _, r := range c.recordRevisions(revision.DatabaseID, revision.RecordID) {
+			if r.Revision.Version > version {
+				version = r.Revision.Version
+			}
+		}
+
+		stored := skydb.RecordRevision{
+			ID:         uuid.New(),
+			RecordID:   revision.RecordID,
+			DatabaseID: revision.DatabaseID,
+			Version:    version + 1,
+			Action:     revision.Action,
+			ActorID:    revision.ActorID,
+			CreatedAt:  timeNow(),
+		}
+		c.put(recordHistoryTable, stored.ID, recordHistoryRow{
+			Revision:   stored,
+			RecordData: recordData,
+			DiffData:   diffData,
+		})
+
+		revision.ID = stored.ID
+		revision.Version = stored.Version
+		revision.CreatedAt = stored.CreatedAt
+		return nil
+	})
+}
+
+// recordRevisions returns the revisions of a record, the latest first.
+func (c *conn) recordRevisions(databaseID string, id skydb.RecordID) []recordHistoryRow {
+	rows := []recordHistoryRow{}
+	for _, row := range c.scan(recordHistoryTable) {
+		r := row.(recordHistoryRow)
+		if r.Revision.RecordID == id && r.Revision.DatabaseID == databaseID {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Revision.Version > rows[j].Revision.Version
+	})
+	return rows
+}
+
+func (row recordHistoryRow) scan(revision *skydb.RecordRevision) error {
+	*revision = row.Revision
+	revision.Record = skydb.Record{}
+	if err := json.Unmarshal(row.RecordData, (*skyconv.JSONRecord)(&revision.Record)); err != nil {
+		return err
+	}
+	revision.Record.DatabaseID = revision.DatabaseID
+
+	if row.DiffData == nil {
+		return nil
+	}
+	m := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(row.DiffData, &m); err != nil {
+		return err
+	}
+	revision.Diff = map[string]skydb.RecordFieldChange{}
+	for key, change := range m {
+		oldValue, err := skyconv.TryParseLiteral(change["old"])
+		if err != nil {
+			return fmt.Errorf("invalid old value of %s: %v", key, err)
+		}
+		newValue, err := skyconv.TryParseLiteral(change["new"])
+		if err != nil {
+			return fmt.Errorf("invalid new value of %s: %v", key, err)
+		}
+		revision.Diff[key] = skydb.RecordFieldChange{Old: oldValue, New: newValue}
+	}
+	return nil
+}
+
+func (c *conn) GetRecordRevisions(databaseID string, id skydb.RecordID) ([]skydb.RecordRevision, error) {
+	revisions := []skydb.RecordRevision{}
+	for _, row := range c.recordRevisions(databaseID, id) {
+		revision := skydb.RecordRevision{}
+		if err := row.scan(&revision); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+func (c *conn) GetRecordRevision(databaseID string, id skydb.RecordID, version int, revision *skydb.RecordRevision) error {
+	for _, row := range c.recordRevisions(databaseID, id) {
+		if row.Revision.Version == version {
+			return row.scan(revision)
+		}
+	}
+	return skydb.ErrRecordRevisionNotFound
+}
@@ -0,0 +1,891 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// deletedAtField is the field marking when a record is soft-deleted. A
+// record type is in soft delete mode if its schema has this field.
+const deletedAtField = "_deleted_at"
+
+func isSoftDeleteEnabled(schema skydb.RecordSchema) bool {
+	_, ok := schema[deletedAtField]
+	return ok
+}
+
+var errMigrationDisabled = skyerr.NewError(
+	skyerr.IncompatibleSchema,
+	"Record schema requires migration but migration is disabled.",
+)
+
+func copyConstraints(constraints *skydb.FieldConstraints) *skydb.FieldConstraints {
+	if constraints == nil || constraints.IsEmpty() {
+		return nil
+	}
+
+	c := *constraints
+	if c.Min != nil {
+		min := *c.Min
+		c.Min = &min
+	}
+	if c.Max != nil {
+		max := *c.Max
+		c.Max = &max
+	}
+	if c.Enum != nil {
+		c.Enum = append([]interface{}{}, c.Enum...)
+	}
+	return &c
+}
+
+func copySchema(schema skydb.RecordSchema) skydb.RecordSchema {
+	schemaCopy := make(skydb.RecordSchema, len(schema))
+	for field, fieldType := range schema {
+		fieldType.Constraints = copyConstraints(fieldType.Constraints)
+		schemaCopy[field] = fieldType
+	}
+	return schemaCopy
+}
+
+func (db *database) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	schema, ok := db.c.get(schemaTable, recordType)
+	if !ok {
+		return nil, nil
+	}
+	return copySchema(schema.(skydb.RecordSchema)), nil
+}
+
+func (db *database) GetSchema(recordType string) (skydb.RecordSchema, error) {
+	return db.RemoteColumnTypes(recordType)
+}
+
+func (db *database) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
+	result := map[string]skydb.RecordSchema{}
+	for recordType, schema := range db.c.scan(schemaTable) {
+		if strings.HasPrefix(recordType, "_") {
+			continue
+		}
+		result[recordType] = copySchema(schema.(skydb.RecordSchema))
+	}
+	return result, nil
+}
+
+func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (extended bool, err error) {
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+
+	// Find fields of which the constraints are changed. Constraints are
+	// not concerned if they are nil in the requested record schema.
+	constrainingSchema := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if fieldType.Constraints == nil {
+			continue
+		}
+		if remoteFieldType, ok := remoteRecordSchema[key]; ok && remoteFieldType.Constraints.Equal(fieldType.Constraints) {
+			continue
+		}
+		constrainingSchema[key] = fieldType
+	}
+
+	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) && len(constrainingSchema) == 0 {
+		// The current record schema is superset of requested record
+		// schema. There is no need to extend the schema.
+		return
+	}
+
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		err = errMigrationDisabled
+		return
+	}
+
+	err = db.c.write(func() error {
+		extended = false
+		schema := remoteRecordSchema
+		if len(schema) == 0 {
+			schema = reservedSchema()
+			extended = true
+		}
+
+		// Find new fields
+		fields := []string{}
+		for key, fieldType := range recordSchema {
+			if remoteFieldType, ok := schema[key]; ok {
+				if !remoteFieldType.DefinitionCompatibleTo(fieldType) {
+					return skyerr.NewError(
+						skyerr.IncompatibleSchema,
+						fmt.Sprintf("conflicting schema %v => %v", remoteFieldType, fieldType),
+					)
+				}
+				continue
+			}
+
+			if fieldType.Type == skydb.TypeReference {
+				if _, ok := db.c.get(schemaTable, fieldType.ReferenceType); !ok {
+					return fmt.Errorf(`failed to alter table: relation "%s" does not exist`, fieldType.ReferenceType)
+				}
+			}
+			fields = append(fields, key)
+		}
+		sort.Strings(fields)
+
+		for _, key := range fields {
+			fieldType := recordSchema[key]
+			newFieldType := skydb.FieldType{
+				Type:          fieldType.Type,
+				ReferenceType: fieldType.ReferenceType,
+			}
+			if fieldType.Type == skydb.TypeUnknown {
+				newFieldType.UnderlyingType = fieldType.UnderlyingType
+			}
+			schema[key] = newFieldType
+
+			if fieldType.Type == skydb.TypeSequence {
+				// Existing records are numbered like a new serial
+				// column.
+				for _, row := range db.allRows(recordType) {
+					row.record.Data[key] = db.nextSequence(recordType, key)
+					db.c.put(recordTable(recordType), row.record.ID.Key, row)
+				}
+			}
+			extended = true
+		}
+		db.c.put(schemaTable, recordType, schema)
+
+		constrainingFields := []string{}
+		for key := range constrainingSchema {
+			constrainingFields = append(constrainingFields, key)
+		}
+		sort.Strings(constrainingFields)
+
+		for _, key := range constrainingFields {
+			fieldType := schema[key]
+			fieldType.Constraints = constrainingSchema[key].Constraints
+			if err := db.setFieldConstraints(recordType, key, fieldType); err != nil {
+				return err
+			}
+			extended = true
+		}
+		return nil
+	})
+	if err != nil {
+		extended = false
+	}
+	return
+}
+
+// allRows returns the records of the record type in all databases,
+// including the soft-deleted ones, in the order of creation. The records
+// are copies which can be modified and put back to the table.
+func (db *database) allRows(recordType string) []recordRow {
+	rows := []recordRow{}
+	for _, row := range db.c.scan(recordTable(recordType)) {
+		r := row.(recordRow)
+		r.record = copyRecord(r.record)
+		rows = append(rows, r)
+	}
+	sortRows(rows)
+	return rows
+}
+
+// setFieldConstraints replaces the constraints of a field. Existing
+// records without a required field take the default value of the field,
+// and an error is returned if existing records violate the constraints.
+func (db *database) setFieldConstraints(recordType, field string, fieldType skydb.FieldType) error {
+	constraints := skydb.FieldConstraints{}
+	if fieldType.Constraints != nil {
+		constraints = *fieldType.Constraints
+	}
+
+	rows := db.allRows(recordType)
+	if constraints.Default != nil && constraints.Required {
+		// Existing records without the field take the default value,
+		// otherwise the field cannot be required.
+		value, err := storeValue(fieldType, constraints.Default)
+		if err != nil {
+			return fmt.Errorf("failed to set default of %s.%s: %s", recordType, field, err)
+		}
+		for i, row := range rows {
+			if row.record.Data[field] == nil {
+				row.record.Data[field] = copyValue(value)
+				db.c.put(recordTable(recordType), row.record.ID.Key, row)
+				rows[i] = row
+			}
+		}
+	}
+
+	violated := func() error {
+		return skyerr.NewErrorWithInfo(
+			skyerr.ConstraintViolated,
+			fmt.Sprintf("existing records of %s violate constraints of field %s", recordType, field),
+			map[string]interface{}{"field": field},
+		)
+	}
+
+	values := map[string]bool{}
+	for _, row := range rows {
+		value := fieldValue(&row.record, field)
+		if err := constraints.Validate(value); err != nil {
+			return violated()
+		}
+		if constraints.Unique && value != nil {
+			key := uniqueKey(fieldType, value)
+			if values[key] {
+				return violated()
+			}
+			values[key] = true
+		}
+	}
+
+	schema := db.schema(recordType)
+	fieldType.Constraints = copyConstraints(&constraints)
+	schema[field] = fieldType
+	db.c.put(schemaTable, recordType, schema)
+	return nil
+}
+
+// schema returns a copy of the schema of the record type, or nil if the
+// record type does not exist.
+func (db *database) schema(recordType string) skydb.RecordSchema {
+	schema, _ := db.RemoteColumnTypes(recordType)
+	return schema
+}
+
+// uniqueKey returns the key of a value for checking the uniqueness of the
+// value in a field.
+func uniqueKey(fieldType skydb.FieldType, value interface{}) string {
+	value = normalize(value)
+	if s, ok := value.(string); ok && fieldType.UnderlyingType == "citext" {
+		value = strings.ToLower(s)
+	}
+	return fmt.Sprintf("%T:%v", value, value)
+}
+
+func (db *database) RenameSchema(recordType, oldName, newName string) error {
+	if !db.c.canMigrate {
+		return errMigrationDisabled
+	}
+
+	return db.c.write(func() error {
+		schema := db.schema(recordType)
+		if schema == nil {
+			return fmt.Errorf(`failed to alter table: relation "%s" does not exist`, recordType)
+		}
+		fieldType, ok := schema[oldName]
+		if !ok {
+			return fmt.Errorf(`failed to alter table: column "%s" does not exist`, oldName)
+		}
+		if _, ok := schema[newName]; ok {
+			return fmt.Errorf(`failed to alter table: column "%s" of relation "%s" already exists`, newName, recordType)
+		}
+
+		delete(schema, oldName)
+		schema[newName] = fieldType
+		db.c.put(schemaTable, recordType, schema)
+
+		for _, row := range db.allRows(recordType) {
+			if value, ok := row.record.Data[oldName]; ok {
+				delete(row.record.Data, oldName)
+				row.record.Data[newName] = value
+				db.c.put(recordTable(recordType), row.record.ID.Key, row)
+			}
+		}
+
+		indexes := db.indexes(recordType)
+		for name, index := range indexes {
+			for i, field := range index.Fields {
+				if field == oldName {
+					index.Fields[i] = newName
+				}
+			}
+			indexes[name] = index
+		}
+		db.c.put(indexTable, recordType, indexes)
+
+		if value, ok := db.c.get(sequenceTable, sequenceKey(recordType, oldName)); ok {
+			db.c.remove(sequenceTable, sequenceKey(recordType, oldName))
+			db.c.put(sequenceTable, sequenceKey(recordType, newName), value)
+		}
+		return nil
+	})
+}
+
+func (db *database) DeleteSchema(recordType, columnName string) error {
+	if !db.c.canMigrate {
+		return errMigrationDisabled
+	}
+
+	return db.c.write(func() error {
+		schema := db.schema(recordType)
+		if schema == nil {
+			return fmt.Errorf(`failed to alter table: relation "%s" does not exist`, recordType)
+		}
+		if _, ok := schema[columnName]; !ok {
+			return fmt.Errorf(`failed to alter table: column "%s" does not exist`, columnName)
+		}
+
+		delete(schema, columnName)
+		db.c.put(schemaTable, recordType, schema)
+
+		for _, row := range db.allRows(recordType) {
+			if _, ok := row.record.Data[columnName]; ok {
+				delete(row.record.Data, columnName)
+				db.c.put(recordTable(recordType), row.record.ID.Key, row)
+			}
+		}
+
+		// Like PostgreSQL, indexes containing the column are dropped
+		// with the column.
+		indexes := db.indexes(recordType)
+		for name, index := range indexes {
+			if containsString(index.Fields, columnName) {
+				delete(indexes, name)
+			}
+		}
+		db.c.put(indexTable, recordType, indexes)
+
+		db.c.remove(sequenceTable, sequenceKey(recordType, columnName))
+		return nil
+	})
+}
+
+func (db *database) SetRecordSoftDelete(recordType string, enabled bool) error {
+	if !db.c.canMigrate {
+		return errMigrationDisabled
+	}
+
+	return db.c.write(func() error {
+		schema := db.schema(recordType)
+		if schema == nil {
+			return skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("record type %s does not exist", recordType))
+		}
+		if isSoftDeleteEnabled(schema) == enabled {
+			return nil
+		}
+
+		if enabled {
+			schema[deletedAtField] = skydb.FieldType{Type: skydb.TypeDateTime}
+		} else {
+			var count uint64
+			for _, row := range db.allRows(recordType) {
+				if !row.record.DeletedAt.IsZero() {
+					count++
+				}
+			}
+			if count > 0 {
+				return skyerr.NewError(
+					skyerr.IncompatibleSchema,
+					fmt.Sprintf("record type %s has %d soft-deleted records", recordType, count),
+				)
+			}
+			delete(schema, deletedAtField)
+		}
+		db.c.put(schemaTable, recordType, schema)
+		return nil
+	})
+}
+
+// typeConversion converts a value of a field from a type to another. The
+// second value returned is false if the value cannot be converted.
+type typeConversion func(value interface{}) (interface{}, bool)
+
+const (
+	minInteger  = -2147483648
+	maxInteger  = 2147483647
+	minDatetime = -62135596800
+	maxDatetime = 253402300799
+)
+
+var (
+	decimalPattern  = regexp.MustCompile(`^\s*[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?\s*$`)
+	integerPattern  = regexp.MustCompile(`^\s*[-+]?[0-9]{1,10}\s*$`)
+	datetimePattern = regexp.MustCompile(`^\s*([0-9]{4}-[0-9]{2}-[0-9]{2})(?:[T ]([0-9]{2}:[0-9]{2})(?::([0-9]{2})(\.[0-9]+)?)?)?\s*(Z|[-+][0-9]{2}(?::?[0-9]{2})?)?\s*$`)
+)
+
+var booleanStrings = map[string]bool{
+	"true": true, "t": true, "yes": true, "y": true, "on": true, "1": true,
+	"false": false, "f": false, "no": false, "n": false, "off": false, "0": false,
+}
+
+func inIntegerRange(number float64) bool {
+	return number >= minInteger && number <= maxInteger
+}
+
+func epochTime(seconds float64) time.Time {
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64(math.Round((seconds-whole)*1e6))*1e3).UTC()
+}
+
+func epochSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+var typeConversions = map[[2]skydb.DataType]typeConversion{
+	{skydb.TypeString, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		s := value.(string)
+		if !decimalPattern.MatchString(s) {
+			return nil, false
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return number, err == nil
+	},
+	{skydb.TypeString, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		s := value.(string)
+		if !integerPattern.MatchString(s) {
+			return nil, false
+		}
+		number, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		return number, err == nil && inIntegerRange(float64(number))
+	},
+	{skydb.TypeString, skydb.TypeBoolean}: func(value interface{}) (interface{}, bool) {
+		b, ok := booleanStrings[strings.ToLower(strings.TrimSpace(value.(string)))]
+		return b, ok
+	},
+	{skydb.TypeString, skydb.TypeDateTime}: func(value interface{}) (interface{}, bool) {
+		return parseDatetime(value.(string))
+	},
+	{skydb.TypeNumber, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return strconv.FormatFloat(value.(float64), 'g', -1, 64), true
+	},
+	{skydb.TypeNumber, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		number := math.Round(value.(float64))
+		return int64(number), inIntegerRange(number)
+	},
+	{skydb.TypeNumber, skydb.TypeDateTime}: func(value interface{}) (interface{}, bool) {
+		number := value.(float64)
+		if number < minDatetime || number > maxDatetime {
+			return nil, false
+		}
+		return epochTime(number), true
+	},
+	{skydb.TypeInteger, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return strconv.FormatInt(value.(int64), 10), true
+	},
+	{skydb.TypeInteger, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		return float64(value.(int64)), true
+	},
+	{skydb.TypeInteger, skydb.TypeBoolean}: func(value interface{}) (interface{}, bool) {
+		return value.(int64) != 0, true
+	},
+	{skydb.TypeInteger, skydb.TypeDateTime}: func(value interface{}) (interface{}, bool) {
+		return time.Unix(value.(int64), 0).UTC(), true
+	},
+	{skydb.TypeBoolean, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return strconv.FormatBool(value.(bool)), true
+	},
+	{skydb.TypeBoolean, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		if value.(bool) {
+			return float64(1), true
+		}
+		return float64(0), true
+	},
+	{skydb.TypeBoolean, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		if value.(bool) {
+			return int64(1), true
+		}
+		return int64(0), true
+	},
+	{skydb.TypeDateTime, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return value.(time.Time).UTC().Format("2006-01-02T15:04:05.000000Z"), true
+	},
+	{skydb.TypeDateTime, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		return epochSeconds(value.(time.Time)), true
+	},
+	{skydb.TypeDateTime, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		number := math.Floor(epochSeconds(value.(time.Time)))
+		return int64(number), inIntegerRange(number)
+	},
+	{skydb.TypeJSON, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+		data, err := jsonText(value)
+		return data, err == nil
+	},
+}
+
+// parseDatetime parses an ISO 8601 timestamp, which is in UTC if no time
+// zone is specified.
+func parseDatetime(s string) (interface{}, bool) {
+	m := datetimePattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+
+	layout := "2006-01-02"
+	value := m[1]
+	if m[2] != "" {
+		layout += "T15:04"
+		value += "T" + m[2]
+		if m[3] != "" {
+			layout += ":05"
+			value += ":" + m[3] + m[4]
+		}
+	}
+
+	zone := m[5]
+	switch {
+	case zone == "" || zone == "Z":
+		zone = "+00:00"
+	case len(zone) == 3:
+		zone += ":00"
+	case len(zone) == 5:
+		zone = zone[:3] + ":" + zone[3:]
+	}
+
+	t, err := time.Parse(layout+"-07:00", value+zone)
+	if err != nil {
+		return nil, false
+	}
+	return t.UTC(), true
+}
+
+// AlterSchemaType converts the values of a field to the new type, failing
+// if any value cannot be converted. Constraints of the field are kept if
+// they are applicable to the new type, otherwise they are removed.
+func (db *database) AlterSchemaType(recordType, columnName string, fieldType skydb.FieldType, dryRun bool) (report skydb.TypeConversionReport, err error) {
+	if !dryRun && !db.c.canMigrate {
+		err = errMigrationDisabled
+		return
+	}
+
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+	if remoteRecordSchema == nil {
+		err = skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("record type %s does not exist", recordType))
+		return
+	}
+	remoteFieldType, ok := remoteRecordSchema[columnName]
+	if !ok {
+		err = skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("field %s does not exist", columnName))
+		return
+	}
+
+	conversion, err := skydb.GetTypeConversion(remoteFieldType, fieldType)
+	if err != nil {
+		err = skyerr.NewError(skyerr.NotSupported, err.Error())
+		return
+	}
+	convert, ok := typeConversions[[2]skydb.DataType{conversion.From, conversion.To}]
+	if !ok {
+		err = skyerr.NewError(skyerr.NotSupported, fmt.Sprintf(
+			"conversion from %s to %s is not supported",
+			remoteFieldType.ToSimpleName(),
+			fieldType.ToSimpleName(),
+		))
+		return
+	}
+	report.Conversion = conversion
+
+	rows := db.allRows(recordType)
+	converted := map[string]interface{}{}
+	failedIDs := []string{}
+	for _, row := range rows {
+		value, ok := row.record.Data[columnName]
+		if !ok || value == nil {
+			continue
+		}
+		if newValue, ok := convert(value); ok {
+			converted[row.record.ID.Key] = newValue
+		} else {
+			failedIDs = append(failedIDs, row.record.ID.Key)
+		}
+	}
+
+	report.FailedCount = uint64(len(failedIDs))
+	sort.Strings(failedIDs)
+	for i, id := range failedIDs {
+		if i >= skydb.MaxReportedConversionFailures {
+			break
+		}
+		report.FailedRecordIDs = append(report.FailedRecordIDs, skydb.NewRecordID(recordType, id))
+	}
+
+	if dryRun {
+		return
+	}
+
+	if report.FailedCount > 0 {
+		failedRecords := make([]string, len(report.FailedRecordIDs))
+		for i, id := range report.FailedRecordIDs {
+			failedRecords[i] = id.String()
+		}
+		err = skyerr.NewErrorWithInfo(
+			skyerr.IncompatibleSchema,
+			fmt.Sprintf(
+				"%d records cannot be converted from %s to %s",
+				report.FailedCount,
+				remoteFieldType.ToSimpleName(),
+				fieldType.ToSimpleName(),
+			),
+			map[string]interface{}{
+				"failed_count":   report.FailedCount,
+				"failed_records": failedRecords,
+			},
+		)
+		return
+	}
+
+	err = db.c.write(func() error {
+		for _, row := range rows {
+			if newValue, ok := converted[row.record.ID.Key]; ok {
+				row.record.Data[columnName] = newValue
+				db.c.put(recordTable(recordType), row.record.ID.Key, row)
+			}
+		}
+
+		newFieldType := skydb.FieldType{Type: fieldType.Type}
+		schema := db.schema(recordType)
+		schema[columnName] = newFieldType
+		db.c.put(schemaTable, recordType, schema)
+
+		constraints := remoteFieldType.Constraints
+		if constraints != nil && constraints.ValidateDefinition(fieldType) == nil {
+			newFieldType.Constraints = constraints
+			return db.setFieldConstraints(recordType, columnName, newFieldType)
+		}
+		return nil
+	})
+	return
+}
+
+// indexes returns the indexes of the record type keyed by their names.
+func (db *database) indexes(recordType string) map[string]skydb.Index {
+	indexes := map[string]skydb.Index{}
+	if value, ok := db.c.get(indexTable, recordType); ok {
+		for name, index := range value.(map[string]skydb.Index) {
+			index.Fields = append([]string{}, index.Fields...)
+			indexes[name] = index
+		}
+	}
+	return indexes
+}
+
+func (db *database) GetIndexesByRecordType(recordType string) (indexes map[string]skydb.Index, err error) {
+	indexes = map[string]skydb.Index{}
+	for name, index := range db.indexes(recordType) {
+		if index.Predicate != nil {
+			index.Condition = predicateCondition(*index.Predicate)
+		}
+		index.Predicate = nil
+		indexes[name] = index
+	}
+	return indexes, nil
+}
+
+func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	if index.Predicate != nil && !index.Predicate.IsEmpty() {
+		if err := checkIndexPredicate(*index.Predicate); err != nil {
+			return err
+		}
+	}
+
+	return db.c.write(func() error {
+		schema := db.schema(recordType)
+		if schema == nil {
+			return fmt.Errorf(`relation "%s" does not exist`, recordType)
+		}
+		for _, field := range index.Fields {
+			if _, ok := schema[field]; !ok {
+				return fmt.Errorf(`column "%s" does not exist`, field)
+			}
+		}
+		for _, value := range db.c.scan(indexTable) {
+			if _, ok := value.(map[string]skydb.Index)[indexName]; ok {
+				return fmt.Errorf(`relation "%s" already exists`, indexName)
+			}
+		}
+
+		newIndex := skydb.Index{
+			Fields: append([]string{}, index.Fields...),
+			Unique: index.Unique,
+		}
+		if index.Predicate != nil && !index.Predicate.IsEmpty() {
+			predicate := *index.Predicate
+			newIndex.Predicate = &predicate
+			if err := db.newEvaluator(recordType, nil).prepare(predicate); err != nil {
+				return err
+			}
+		}
+
+		if newIndex.Unique {
+			keys := map[string]bool{}
+			for _, row := range db.allRows(recordType) {
+				key, ok, err := db.indexKey(recordType, schema, newIndex, &row.record)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				if keys[key] {
+					return fmt.Errorf(`could not create unique index "%s"`, indexName)
+				}
+				keys[key] = true
+			}
+		}
+
+		indexes := db.indexes(recordType)
+		indexes[indexName] = newIndex
+		db.c.put(indexTable, recordType, indexes)
+		return nil
+	})
+}
+
+// indexKey returns the key of the record in the index. The second value
+// returned is false if the record is not in the index, or has null in
+// any field of the index which is not considered in uniqueness.
+func (db *database) indexKey(recordType string, schema skydb.RecordSchema, index skydb.Index, record *skydb.Record) (string, bool, error) {
+	if index.Predicate != nil {
+		t, err := db.newEvaluator(recordType, nil).match(*index.Predicate, record)
+		if err != nil || t != triTrue {
+			return "", false, err
+		}
+	}
+
+	keys := make([]string, len(index.Fields))
+	for i, field := range index.Fields {
+		value := fieldValue(record, field)
+		if value == nil {
+			return "", false, nil
+		}
+		keys[i] = uniqueKey(schema[field], value)
+	}
+	return strings.Join(keys, "\x00"), true, nil
+}
+
+// checkIndexPredicate returns an error if the predicate cannot be used
+// as the condition of a partial index, which can only refer to fields
+// of the indexed record type.
+func checkIndexPredicate(predicate skydb.Predicate) error {
+	if predicate.Operator == skydb.Functional {
+		return skyerr.NewError(skyerr.NotSupported, "functional predicate is not supported in index")
+	}
+	if predicate.Operator == skydb.Exists {
+		return skyerr.NewError(skyerr.NotSupported, "exists predicate is not supported in index")
+	}
+
+	for _, child := range predicate.Children {
+		switch c := child.(type) {
+		case skydb.Predicate:
+			if err := checkIndexPredicate(c); err != nil {
+				return err
+			}
+		case skydb.Expression:
+			if c.IsKeyPath() && strings.Contains(c.Value.(string), ".") {
+				return skyerr.NewError(skyerr.NotSupported, "key path of referenced record is not supported in index")
+			}
+			if c.Type == skydb.Function {
+				return skyerr.NewError(skyerr.NotSupported, "function is not supported in index")
+			}
+			if c.IsSubquery() {
+				return skyerr.NewError(skyerr.NotSupported, "subquery is not supported in index")
+			}
+		}
+	}
+	return nil
+}
+
+var conditionOperators = map[skydb.Operator]string{
+	skydb.Equal:              "=",
+	skydb.NotEqual:           "<>",
+	skydb.GreaterThan:        ">",
+	skydb.GreaterThanOrEqual: ">=",
+	skydb.LessThan:           "<",
+	skydb.LessThanOrEqual:    "<=",
+	skydb.Like:               "LIKE",
+	skydb.ILike:              "ILIKE",
+	skydb.In:                 "IN",
+}
+
+// predicateCondition formats the predicate of a partial index like the
+// condition of an index in PostgreSQL.
+func predicateCondition(p skydb.Predicate) string {
+	switch p.Operator {
+	case skydb.And, skydb.Or:
+		conditions := []string{}
+		for _, child := range p.GetSubPredicates() {
+			conditions = append(conditions, predicateCondition(child))
+		}
+		return "(" + strings.Join(conditions, " "+strings.ToUpper(p.Operator.String())+" ") + ")"
+	case skydb.Not:
+		return "(NOT " + predicateCondition(p.Children[0].(skydb.Predicate)) + ")"
+	}
+
+	exprs := p.GetExpressions()
+	lhs, rhs := expressionCondition(exprs[0]), expressionCondition(exprs[1])
+	switch {
+	case p.Operator == skydb.Equal && exprs[1].IsLiteralNull():
+		return "(" + lhs + " IS NULL)"
+	case p.Operator == skydb.NotEqual && exprs[1].IsLiteralNull():
+		return "(" + lhs + " IS NOT NULL)"
+	}
+	return "(" + lhs + " " + conditionOperators[p.Operator] + " " + rhs + ")"
+}
+
+func expressionCondition(expr skydb.Expression) string {
+	if expr.IsKeyPath() {
+		return expr.Value.(string)
+	}
+	return literalCondition(expr.Value)
+}
+
+func literalCondition(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case time.Time:
+		return "'" + v.UTC().Format("2006-01-02 15:04:05.999999") + "'"
+	case skydb.Reference:
+		return literalCondition(v.ID.Key)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = literalCondition(item)
+		}
+		return "(" + strings.Join(items, ", ") + ")"
+	}
+	return fmt.Sprint(value)
+}
+
+func (db *database) DeleteIndex(recordType string, indexName string) error {
+	return db.c.write(func() error {
+		indexes := db.indexes(recordType)
+		if _, ok := indexes[indexName]; !ok {
+			return fmt.Errorf(`index "%s" does not exist`, indexName)
+		}
+		delete(indexes, indexName)
+		db.c.put(indexTable, recordType, indexes)
+		return nil
+	})
+}
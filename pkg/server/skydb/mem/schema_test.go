@@ -0,0 +1,39 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtend(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		db := c.PublicDB()
+
+		Convey("rejects extending when migration is disabled", func() {
+			c.canMigrate = false
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+		})
+	})
+}
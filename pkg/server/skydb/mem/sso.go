@@ -0,0 +1,150 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func oauthKey(provider string, principalID string) string {
+	return provider + "\x00" + principalID
+}
+
+func copyOAuthInfo(oauthinfo *skydb.OAuthInfo) skydb.OAuthInfo {
+	oauthinfoCopy := *oauthinfo
+	if oauthinfo.TokenResponse != nil {
+		oauthinfoCopy.TokenResponse = copyValue(map[string]interface{}(oauthinfo.TokenResponse)).(map[string]interface{})
+	}
+	if oauthinfo.ProviderProfile != nil {
+		oauthinfoCopy.ProviderProfile = copyValue(map[string]interface{}(oauthinfo.ProviderProfile)).(map[string]interface{})
+	}
+	oauthinfoCopy.CreatedAt = copyTime(oauthinfo.CreatedAt)
+	oauthinfoCopy.UpdatedAt = copyTime(oauthinfo.UpdatedAt)
+	return oauthinfoCopy
+}
+
+func (c *conn) CreateOAuthInfo(oauthinfo *skydb.OAuthInfo) error {
+	return c.write(func() error {
+		key := oauthKey(oauthinfo.Provider, oauthinfo.PrincipalID)
+		if _, ok := c.get(oauthTable, key); ok {
+			return skydb.ErrUserDuplicated
+		}
+		if _, ok := c.findOAuthInfo(oauthinfo.Provider, oauthinfo.UserID); ok {
+			return skydb.ErrUserDuplicated
+		}
+
+		c.put(oauthTable, key, copyOAuthInfo(oauthinfo))
+		return nil
+	})
+}
+
+func (c *conn) findOAuthInfo(provider string, userID string) (skydb.OAuthInfo, bool) {
+	for _, row := range c.scan(oauthTable) {
+		if stored := row.(skydb.OAuthInfo); stored.Provider == provider && stored.UserID == userID {
+			return stored, true
+		}
+	}
+	return skydb.OAuthInfo{}, false
+}
+
+func (c *conn) GetOAuthInfo(provider string, principalID string, oauthinfo *skydb.OAuthInfo) error {
+	row, ok := c.get(oauthTable, oauthKey(provider, principalID))
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+	stored := row.(skydb.OAuthInfo)
+	*oauthinfo = copyOAuthInfo(&stored)
+	return nil
+}
+
+func (c *conn) GetOAuthInfoByProviderAndUserID(provider string, userID string, oauthinfo *skydb.OAuthInfo) error {
+	stored, ok := c.findOAuthInfo(provider, userID)
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+	*oauthinfo = copyOAuthInfo(&stored)
+	return nil
+}
+
+func (c *conn) UpdateOAuthInfo(oauthinfo *skydb.OAuthInfo) error {
+	return c.write(func() error {
+		key := oauthKey(oauthinfo.Provider, oauthinfo.PrincipalID)
+		row, ok := c.get(oauthTable, key)
+		if !ok {
+			return skydb.ErrUserNotFound
+		}
+
+		stored := row.(skydb.OAuthInfo)
+		updated := copyOAuthInfo(oauthinfo)
+		stored.TokenResponse = updated.TokenResponse
+		stored.ProviderProfile = updated.ProviderProfile
+		stored.UpdatedAt = updated.UpdatedAt
+		c.put(oauthTable, key, stored)
+		return nil
+	})
+}
+
+func (c *conn) DeleteOAuth(provider string, principalID string) error {
+	return c.write(func() error {
+		if !c.remove(oauthTable, oauthKey(provider, principalID)) {
+			return skydb.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) CreateCustomTokenInfo(tokenInfo *skydb.CustomTokenInfo) error {
+	return c.write(func() error {
+		if _, ok := c.get(customTokenTable, tokenInfo.PrincipalID); ok {
+			return skydb.ErrUserDuplicated
+		}
+		for _, row := range c.scan(customTokenTable) {
+			if row.(skydb.CustomTokenInfo).UserID == tokenInfo.UserID {
+				return skydb.ErrUserDuplicated
+			}
+		}
+
+		createdAt := timeNow()
+		if tokenInfo.CreatedAt != nil && !tokenInfo.CreatedAt.IsZero() {
+			createdAt = tokenInfo.CreatedAt.UTC()
+		}
+		c.put(customTokenTable, tokenInfo.PrincipalID, skydb.CustomTokenInfo{
+			UserID:      tokenInfo.UserID,
+			PrincipalID: tokenInfo.PrincipalID,
+			CreatedAt:   &createdAt,
+		})
+		return nil
+	})
+}
+
+func (c *conn) GetCustomTokenInfo(principalID string, tokenInfo *skydb.CustomTokenInfo) error {
+	row, ok := c.get(customTokenTable, principalID)
+	if !ok {
+		return skydb.ErrUserNotFound
+	}
+	stored := row.(skydb.CustomTokenInfo)
+	*tokenInfo = stored
+	tokenInfo.CreatedAt = copyTime(stored.CreatedAt)
+	return nil
+}
+
+func (c *conn) DeleteCustomTokenInfo(principalID string) error {
+	return c.write(func() error {
+		if !c.remove(customTokenTable, principalID) {
+			return skydb.ErrUserNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// store holds the data of an app in tables. A table maps the key of a row
+// to its value. Stored values are never modified, so they are shared with
+// readers without copying.
+type store struct {
+	mutex  sync.RWMutex
+	tables map[string]map[string]interface{}
+
+	// writeMutex serializes write operations outside of a transaction
+	// and transactions from Begin to Commit or Rollback.
+	writeMutex sync.Mutex
+
+	// seq is the last sequence number of inserted records, which
+	// decides the order of records not sorted by a query.
+	seq uint64
+
+	eventMutex sync.Mutex
+	channels   []chan skydb.RecordEvent
+}
+
+func newStore() *store {
+	s := &store{
+		tables: map[string]map[string]interface{}{},
+	}
+	seed(s)
+	return s
+}
+
+func (s *store) nextSeq() uint64 {
+	return atomic.AddUint64(&s.seq, 1)
+}
+
+func (s *store) get(table string, key string) (interface{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.tables[table][key]
+	return value, ok
+}
+
+func (s *store) scan(table string) map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	rows := map[string]interface{}{}
+	for key, value := range s.tables[table] {
+		rows[key] = value
+	}
+	return rows
+}
+
+func (s *store) apply(tx *txn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for table, rows := range tx.tables {
+		t, ok := s.tables[table]
+		if !ok {
+			t = map[string]interface{}{}
+			s.tables[table] = t
+		}
+		for key, v := range rows {
+			if v.deleted {
+				delete(t, key)
+			} else {
+				t[key] = v.value
+			}
+		}
+	}
+}
+
+func (s *store) emit(events []skydb.RecordEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	s.eventMutex.Lock()
+	channels := s.channels
+	s.eventMutex.Unlock()
+
+	for _, event := range events {
+		for _, ch := range channels {
+			go func(ch chan skydb.RecordEvent, event skydb.RecordEvent) {
+				ch <- event
+			}(ch, event)
+		}
+	}
+}
+
+// txn is the changes to a store not yet applied.
+type txn struct {
+	tables map[string]map[string]txnValue
+	events []skydb.RecordEvent
+}
+
+type txnValue struct {
+	value   interface{}
+	deleted bool
+}
+
+func newTxn() *txn {
+	return &txn{
+		tables: map[string]map[string]txnValue{},
+	}
+}
+
+func (tx *txn) set(table string, key string, v txnValue) {
+	rows, ok := tx.tables[table]
+	if !ok {
+		rows = map[string]txnValue{}
+		tx.tables[table] = rows
+	}
+	rows[key] = v
+}
+
+func (tx *txn) copy() *txn {
+	txCopy := &txn{
+		tables: make(map[string]map[string]txnValue, len(tx.tables)),
+		events: append([]skydb.RecordEvent{}, tx.events...),
+	}
+	for table, rows := range tx.tables {
+		rowsCopy := make(map[string]txnValue, len(rows))
+		for key, v := range rows {
+			rowsCopy[key] = v
+		}
+		txCopy.tables[table] = rowsCopy
+	}
+	return txCopy
+}
+
+func sortedKeys(rows map[string]interface{}) []string {
+	keys := make([]string, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
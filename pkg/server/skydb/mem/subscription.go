@@ -0,0 +1,198 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+type subscriptionRow struct {
+	AuthID       string
+	Subscription skydb.Subscription
+}
+
+func subscriptionKey(authID string, deviceID string, id string) string {
+	return authID + "\x00" + deviceID + "\x00" + id
+}
+
+func copySubscription(subscription skydb.Subscription) skydb.Subscription {
+	subscriptionCopy := subscription
+	if subscription.NotificationInfo != nil {
+		info := *subscription.NotificationInfo
+		subscriptionCopy.NotificationInfo = &info
+	}
+	return subscriptionCopy
+}
+
+func (db *database) GetSubscription(key string, deviceID string, subscription *skydb.Subscription) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+
+	row, ok := db.c.get(subscriptionTable, subscriptionKey(db.userID, deviceID, key))
+	if !ok {
+		return skydb.ErrSubscriptionNotFound
+	}
+	*subscription = copySubscription(row.(subscriptionRow).Subscription)
+	return nil
+}
+
+func (db *database) SaveSubscription(subscription *skydb.Subscription) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+	if subscription.ID == "" {
+		return errors.New("empty id")
+	}
+	if subscription.Type == "" {
+		return errors.New("empty type")
+	}
+	if subscription.Query.Type == "" {
+		return errors.New("empty query type")
+	}
+	if subscription.DeviceID == "" {
+		return errors.New("empty device id")
+	}
+
+	return db.c.write(func() error {
+		if _, ok := db.c.get(deviceTable, subscription.DeviceID); !ok {
+			return skydb.ErrDeviceNotFound
+		}
+
+		db.c.put(
+			subscriptionTable,
+			subscriptionKey(db.userID, subscription.DeviceID, subscription.ID),
+			subscriptionRow{
+				AuthID:       db.userID,
+				Subscription: copySubscription(*subscription),
+			},
+		)
+		return nil
+	})
+}
+
+func (db *database) DeleteSubscription(key string, deviceID string) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+
+	return db.c.write(func() error {
+		if !db.c.remove(subscriptionTable, subscriptionKey(db.userID, deviceID, key)) {
+			return skydb.ErrSubscriptionNotFound
+		}
+		return nil
+	})
+}
+
+func (db *database) subscriptions(match func(*skydb.Subscription) bool) []skydb.Subscription {
+	rows := db.c.scan(subscriptionTable)
+	subscriptions := []skydb.Subscription{}
+	for _, key := range sortedKeys(rows) {
+		row := rows[key].(subscriptionRow)
+		if row.AuthID == db.userID && match(&row.Subscription) {
+			subscriptions = append(subscriptions, copySubscription(row.Subscription))
+		}
+	}
+	return subscriptions
+}
+
+func (db *database) GetSubscriptionsByDeviceID(deviceID string) []skydb.Subscription {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		logger := logging.CreateLogger(db.c.context, "skydb")
+		logger.Errorln("GetSubscriptionsByDeviceID on union database is not implemented")
+		return nil
+	}
+
+	return db.subscriptions(func(subscription *skydb.Subscription) bool {
+		return subscription.DeviceID == deviceID
+	})
+}
+
+func (db *database) GetMatchingSubscriptions(record *skydb.Record) []skydb.Subscription {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		logger := logging.CreateLogger(db.c.context, "skydb")
+		logger.Errorln("GetMatchingSubscriptions on union database is not implemented")
+		return nil
+	}
+
+	subscriptions := db.subscriptions(func(subscription *skydb.Subscription) bool {
+		return subscription.Query.Type == record.ID.Type &&
+			predMatchRecord(&subscription.Query.Predicate, record)
+	})
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions
+}
+
+// predMatchRecord matches a record against the predicate of a
+// subscription, which only supports equality and In comparisons.
+func predMatchRecord(p *skydb.Predicate, record *skydb.Record) bool {
+	if p == nil || p.IsEmpty() {
+		return true
+	}
+
+	switch p.Operator {
+	case skydb.And:
+		for _, childPred := range p.GetSubPredicates() {
+			if !predMatchRecord(&childPred, record) {
+				return false
+			}
+		}
+		return true
+	case skydb.Or:
+		for _, childPred := range p.GetSubPredicates() {
+			if predMatchRecord(&childPred, record) {
+				return true
+			}
+		}
+		return false
+	case skydb.Not:
+		return !predMatchRecord(&p.GetSubPredicates()[0], record)
+	case skydb.Equal:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		return reflect.DeepEqual(lv, rv)
+	case skydb.NotEqual:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		return !reflect.DeepEqual(lv, rv)
+	case skydb.In:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		haystack, _ := rv.([]interface{})
+		for _, hay := range haystack {
+			if reflect.DeepEqual(lv, hay) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func extractBinaryOperands(exprs []skydb.Expression, record *skydb.Record) (lv interface{}, rv interface{}) {
+	return extractValue(exprs[0], record), extractValue(exprs[1], record)
+}
+
+func extractValue(expr skydb.Expression, record *skydb.Record) interface{} {
+	if expr.Type == skydb.KeyPath {
+		return record.Get(expr.Value.(string))
+	}
+	return expr.Value
+}
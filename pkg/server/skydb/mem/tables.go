@@ -0,0 +1,181 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// Names of the tables of a store. Records of a record type and pairs of
+// a relation are kept in a table per record type and relation.
+const (
+	authTable                = "_auth"
+	passwordHistoryTable     = "_password_history"
+	roleTable                = "_role"
+	recordCreationTable      = "_record_creation"
+	recordDefaultAccessTable = "_record_default_access"
+	recordFieldAccessTable   = "_record_field_access"
+	assetTable               = "_asset"
+	uploadSessionTable       = "_upload_session"
+	deviceTable              = "_device"
+	subscriptionTable        = "_subscription"
+	scheduledPushTable       = "_scheduled_push"
+	recordHistoryTypeTable   = "_record_history_type"
+	recordHistoryTable       = "_record_history"
+	oauthTable               = "_sso_oauth"
+	customTokenTable         = "_sso_custom_token"
+	schemaTable              = "_schema"
+	indexTable               = "_index"
+	sequenceTable            = "_sequence"
+)
+
+func recordTable(recordType string) string {
+	return "record:" + recordType
+}
+
+func relationTable(name string) string {
+	return "relation:" + name
+}
+
+// seed populates a new store with the data created by the migrations of
+// the pq driver: the user record type with unique username and email, the
+// admin role and the field ACL of the user record type.
+func seed(s *store) {
+	citext := skydb.FieldType{Type: skydb.TypeString, UnderlyingType: "citext"}
+	userSchema := reservedSchema()
+	userSchema["username"] = citext
+	userSchema["email"] = citext
+	userSchema["last_login_at"] = skydb.FieldType{Type: skydb.TypeDateTime}
+
+	fieldACL := skydb.FieldACLEntryList{}
+	for _, field := range []string{"username", "email"} {
+		fieldACL = append(fieldACL, skydb.FieldACLEntry{
+			RecordType:   "user",
+			RecordField:  field,
+			UserRole:     skydb.NewFieldUserRole("_any_user"),
+			Writable:     false,
+			Readable:     true,
+			Comparable:   true,
+			Discoverable: true,
+		}, skydb.FieldACLEntry{
+			RecordType:   "user",
+			RecordField:  field,
+			UserRole:     skydb.NewFieldUserRole("_owner"),
+			Writable:     true,
+			Readable:     true,
+			Comparable:   true,
+			Discoverable: true,
+		})
+	}
+
+	s.tables = map[string]map[string]interface{}{
+		schemaTable: {
+			"user": userSchema,
+		},
+		indexTable: {
+			"user": map[string]skydb.Index{
+				"auth_record_keys_user_username_key": {Fields: []string{"username"}, Unique: true},
+				"auth_record_keys_user_email_key":    {Fields: []string{"email"}, Unique: true},
+			},
+		},
+		recordTable("user"): {},
+		roleTable: {
+			"Admin": roleRow{Admin: true},
+		},
+		recordFieldAccessTable: {
+			"": fieldACL,
+		},
+	}
+}
+
+// reservedSchema returns the schema of the fields every record type has.
+func reservedSchema() skydb.RecordSchema {
+	return skydb.RecordSchema{
+		"_id":          skydb.FieldType{Type: skydb.TypeString},
+		"_database_id": skydb.FieldType{Type: skydb.TypeString},
+		"_owner_id":    skydb.FieldType{Type: skydb.TypeString},
+		"_access":      skydb.FieldType{Type: skydb.TypeACL},
+		"_created_at":  skydb.FieldType{Type: skydb.TypeDateTime},
+		"_created_by":  skydb.FieldType{Type: skydb.TypeString},
+		"_updated_at":  skydb.FieldType{Type: skydb.TypeDateTime},
+		"_updated_by":  skydb.FieldType{Type: skydb.TypeString},
+	}
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	tCopy := t.UTC()
+	return &tCopy
+}
+
+// copyValue returns a deep copy of a value of record data so that the
+// value returned to or received from the caller is not shared with the
+// store.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, item := range v {
+			a[i] = copyValue(item)
+		}
+		return a
+	case skydb.Geometry:
+		return skydb.Geometry(copyValue(map[string]interface{}(v)).(map[string]interface{}))
+	case *skydb.Asset:
+		if v == nil {
+			return v
+		}
+		asset := *v
+		return &asset
+	case skydb.RecordACL:
+		if v == nil {
+			return v
+		}
+		return append(skydb.RecordACL{}, v...)
+	default:
+		return value
+	}
+}
+
+func copyData(data skydb.Data) skydb.Data {
+	if data == nil {
+		return nil
+	}
+	dataCopy := make(skydb.Data, len(data))
+	for key, value := range data {
+		dataCopy[key] = copyValue(value)
+	}
+	return dataCopy
+}
+
+func copyRecord(record skydb.Record) skydb.Record {
+	recordCopy := record
+	recordCopy.Data = copyData(record.Data)
+	recordCopy.Transient = copyData(record.Transient)
+	if record.ACL != nil {
+		recordCopy.ACL = append(skydb.RecordACL{}, record.ACL...)
+	}
+	return recordCopy
+}
@@ -0,0 +1,143 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// storeValue converts a value of record data to the form kept in the
+// store, which is also the form returned to the caller:
+//
+//	number       float64
+//	integer      int64
+//	sequence     int64
+//	datetime     time.Time in UTC
+//	json         decoded JSON
+//	reference    skydb.Reference of the referenced type
+//	asset        *skydb.Asset with only the name
+//	location     skydb.Location
+//	geometry     skydb.Geometry of decoded GeoJSON
+func storeValue(fieldType skydb.FieldType, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case skydb.TypeNumber:
+		if number, ok := toFloat64(value); ok {
+			return number, nil
+		}
+	case skydb.TypeInteger, skydb.TypeSequence:
+		if number, ok := toFloat64(value); ok && number == math.Trunc(number) {
+			return int64(number), nil
+		}
+	case skydb.TypeBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case skydb.TypeDateTime:
+		switch t := value.(type) {
+		case time.Time:
+			return t.UTC(), nil
+		case *time.Time:
+			if t == nil {
+				return nil, nil
+			}
+			return t.UTC(), nil
+		}
+	case skydb.TypeJSON:
+		return jsonValue(value)
+	case skydb.TypeReference:
+		switch ref := value.(type) {
+		case skydb.Reference:
+			return skydb.NewReference(fieldType.ReferenceType, ref.ID.Key), nil
+		case string:
+			return skydb.NewReference(fieldType.ReferenceType, ref), nil
+		}
+	case skydb.TypeAsset:
+		switch a := value.(type) {
+		case *skydb.Asset:
+			return &skydb.Asset{Name: a.Name}, nil
+		case skydb.Asset:
+			return &skydb.Asset{Name: a.Name}, nil
+		}
+	case skydb.TypeLocation:
+		switch loc := value.(type) {
+		case skydb.Location:
+			return loc, nil
+		case *skydb.Location:
+			return *loc, nil
+		}
+	case skydb.TypeGeometry:
+		if geometry, ok := value.(skydb.Geometry); ok {
+			v, err := jsonValue(map[string]interface{}(geometry))
+			if err != nil {
+				return nil, err
+			}
+			return skydb.Geometry(v.(map[string]interface{})), nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid input value %v for field of type %s", value, fieldType.ToSimpleName())
+}
+
+// jsonValue returns the value as decoded from its JSON encoding.
+func jsonValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// jsonText returns the JSON encoding of the value.
+func jsonText(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -316,6 +316,167 @@ func (_mr *MockConnMockRecorder) SaveAsset(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveAsset", reflect.TypeOf((*MockConn)(nil).SaveAsset), arg0)
 }
 
+// QueryUnreferencedAssets mocks base method
+func (_m *MockConn) QueryUnreferencedAssets(savedBefore time.Time) ([]Asset, error) {
+	ret := _m.ctrl.Call(_m, "QueryUnreferencedAssets", savedBefore)
+	ret0, _ := ret[0].([]Asset)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryUnreferencedAssets indicates an expected call of QueryUnreferencedAssets
+func (_mr *MockConnMockRecorder) QueryUnreferencedAssets(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "QueryUnreferencedAssets", reflect.TypeOf((*MockConn)(nil).QueryUnreferencedAssets), arg0)
+}
+
+// DeleteAsset mocks base method
+func (_m *MockConn) DeleteAsset(name string) error {
+	ret := _m.ctrl.Call(_m, "DeleteAsset", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAsset indicates an expected call of DeleteAsset
+func (_mr *MockConnMockRecorder) DeleteAsset(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteAsset", reflect.TypeOf((*MockConn)(nil).DeleteAsset), arg0)
+}
+
+// GetUploadSession mocks base method
+func (_m *MockConn) GetUploadSession(id string, session *UploadSession) error {
+	ret := _m.ctrl.Call(_m, "GetUploadSession", id, session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetUploadSession indicates an expected call of GetUploadSession
+func (_mr *MockConnMockRecorder) GetUploadSession(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetUploadSession", reflect.TypeOf((*MockConn)(nil).GetUploadSession), arg0, arg1)
+}
+
+// GetUploadSessionForUpdate mocks base method
+func (_m *MockConn) GetUploadSessionForUpdate(id string, session *UploadSession) error {
+	ret := _m.ctrl.Call(_m, "GetUploadSessionForUpdate", id, session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetUploadSessionForUpdate indicates an expected call of GetUploadSessionForUpdate
+func (_mr *MockConnMockRecorder) GetUploadSessionForUpdate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetUploadSessionForUpdate", reflect.TypeOf((*MockConn)(nil).GetUploadSessionForUpdate), arg0, arg1)
+}
+
+// SaveUploadSession mocks base method
+func (_m *MockConn) SaveUploadSession(session *UploadSession) error {
+	ret := _m.ctrl.Call(_m, "SaveUploadSession", session)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveUploadSession indicates an expected call of SaveUploadSession
+func (_mr *MockConnMockRecorder) SaveUploadSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveUploadSession", reflect.TypeOf((*MockConn)(nil).SaveUploadSession), arg0)
+}
+
+// DeleteUploadSession mocks base method
+func (_m *MockConn) DeleteUploadSession(id string) error {
+	ret := _m.ctrl.Call(_m, "DeleteUploadSession", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUploadSession indicates an expected call of DeleteUploadSession
+func (_mr *MockConnMockRecorder) DeleteUploadSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteUploadSession", reflect.TypeOf((*MockConn)(nil).DeleteUploadSession), arg0)
+}
+
+// QueryExpiredUploadSessions mocks base method
+func (_m *MockConn) QueryExpiredUploadSessions(t time.Time) ([]UploadSession, error) {
+	ret := _m.ctrl.Call(_m, "QueryExpiredUploadSessions", t)
+	ret0, _ := ret[0].([]UploadSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryExpiredUploadSessions indicates an expected call of QueryExpiredUploadSessions
+func (_mr *MockConnMockRecorder) QueryExpiredUploadSessions(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "QueryExpiredUploadSessions", reflect.TypeOf((*MockConn)(nil).QueryExpiredUploadSessions), arg0)
+}
+
+// GetRecordHistoryTypes mocks base method
+func (_m *MockConn) GetRecordHistoryTypes() ([]string, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordHistoryTypes")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecordHistoryTypes indicates an expected call of GetRecordHistoryTypes
+func (_mr *MockConnMockRecorder) GetRecordHistoryTypes() *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordHistoryTypes", reflect.TypeOf((*MockConn)(nil).GetRecordHistoryTypes))
+}
+
+// SetRecordHistoryEnabled mocks base method
+func (_m *MockConn) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordHistoryEnabled", recordType, enabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRecordHistoryEnabled indicates an expected call of SetRecordHistoryEnabled
+func (_mr *MockConnMockRecorder) SetRecordHistoryEnabled(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordHistoryEnabled", reflect.TypeOf((*MockConn)(nil).SetRecordHistoryEnabled), arg0, arg1)
+}
+
+// SaveRecordRevision mocks base method
+func (_m *MockConn) SaveRecordRevision(revision *RecordRevision) error {
+	ret := _m.ctrl.Call(_m, "SaveRecordRevision", revision)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRecordRevision indicates an expected call of SaveRecordRevision
+func (_mr *MockConnMockRecorder) SaveRecordRevision(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveRecordRevision", reflect.TypeOf((*MockConn)(nil).SaveRecordRevision), arg0)
+}
+
+// GetRecordRevisions mocks base method
+func (_m *MockConn) GetRecordRevisions(databaseID string, id RecordID) ([]RecordRevision, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordRevisions", databaseID, id)
+	ret0, _ := ret[0].([]RecordRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecordRevisions indicates an expected call of GetRecordRevisions
+func (_mr *MockConnMockRecorder) GetRecordRevisions(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordRevisions", reflect.TypeOf((*MockConn)(nil).GetRecordRevisions), arg0, arg1)
+}
+
+// GetRecordRevision mocks base method
+func (_m *MockConn) GetRecordRevision(databaseID string, id RecordID, version int, revision *RecordRevision) error {
+	ret := _m.ctrl.Call(_m, "GetRecordRevision", databaseID, id, version, revision)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetRecordRevision indicates an expected call of GetRecordRevision
+func (_mr *MockConnMockRecorder) GetRecordRevision(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordRevision", reflect.TypeOf((*MockConn)(nil).GetRecordRevision), arg0, arg1, arg2, arg3)
+}
+
+// PurgeDeletedRecords mocks base method
+func (_m *MockConn) PurgeDeletedRecords(deletedBefore time.Time) (uint64, error) {
+	ret := _m.ctrl.Call(_m, "PurgeDeletedRecords", deletedBefore)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeletedRecords indicates an expected call of PurgeDeletedRecords
+func (_mr *MockConnMockRecorder) PurgeDeletedRecords(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PurgeDeletedRecords", reflect.TypeOf((*MockConn)(nil).PurgeDeletedRecords), arg0)
+}
+
 // QueryRelation mocks base method
 func (_m *MockConn) QueryRelation(user string, name string, direction string, config QueryConfig) []AuthInfo {
 	ret := _m.ctrl.Call(_m, "QueryRelation", user, name, direction, config)
@@ -451,6 +612,79 @@ func (_mr *MockConnMockRecorder) DeleteEmptyDevicesByTime(arg0 interface{}) *gom
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteEmptyDevicesByTime", reflect.TypeOf((*MockConn)(nil).DeleteEmptyDevicesByTime), arg0)
 }
 
+// GetScheduledPush mocks base method
+func (_m *MockConn) GetScheduledPush(id string, push *ScheduledPush) error {
+	ret := _m.ctrl.Call(_m, "GetScheduledPush", id, push)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetScheduledPush indicates an expected call of GetScheduledPush
+func (_mr *MockConnMockRecorder) GetScheduledPush(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetScheduledPush", reflect.TypeOf((*MockConn)(nil).GetScheduledPush), arg0, arg1)
+}
+
+// SaveScheduledPush mocks base method
+func (_m *MockConn) SaveScheduledPush(push *ScheduledPush) error {
+	ret := _m.ctrl.Call(_m, "SaveScheduledPush", push)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveScheduledPush indicates an expected call of SaveScheduledPush
+func (_mr *MockConnMockRecorder) SaveScheduledPush(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveScheduledPush", reflect.TypeOf((*MockConn)(nil).SaveScheduledPush), arg0)
+}
+
+// CancelScheduledPush mocks base method
+func (_m *MockConn) CancelScheduledPush(id string, push *ScheduledPush) error {
+	ret := _m.ctrl.Call(_m, "CancelScheduledPush", id, push)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelScheduledPush indicates an expected call of CancelScheduledPush
+func (_mr *MockConnMockRecorder) CancelScheduledPush(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "CancelScheduledPush", reflect.TypeOf((*MockConn)(nil).CancelScheduledPush), arg0, arg1)
+}
+
+// ClaimDueScheduledPushes mocks base method
+func (_m *MockConn) ClaimDueScheduledPushes(t time.Time, claimUntil time.Time) ([]ScheduledPush, error) {
+	ret := _m.ctrl.Call(_m, "ClaimDueScheduledPushes", t, claimUntil)
+	ret0, _ := ret[0].([]ScheduledPush)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimDueScheduledPushes indicates an expected call of ClaimDueScheduledPushes
+func (_mr *MockConnMockRecorder) ClaimDueScheduledPushes(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ClaimDueScheduledPushes", reflect.TypeOf((*MockConn)(nil).ClaimDueScheduledPushes), arg0, arg1)
+}
+
+// MarkScheduledPushSent mocks base method
+func (_m *MockConn) MarkScheduledPushSent(id string, deviceIDs []string) error {
+	ret := _m.ctrl.Call(_m, "MarkScheduledPushSent", id, deviceIDs)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkScheduledPushSent indicates an expected call of MarkScheduledPushSent
+func (_mr *MockConnMockRecorder) MarkScheduledPushSent(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "MarkScheduledPushSent", reflect.TypeOf((*MockConn)(nil).MarkScheduledPushSent), arg0, arg1)
+}
+
+// ReleaseScheduledPush mocks base method
+func (_m *MockConn) ReleaseScheduledPush(id string, status ScheduledPushStatus, dueAt time.Time) error {
+	ret := _m.ctrl.Call(_m, "ReleaseScheduledPush", id, status, dueAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseScheduledPush indicates an expected call of ReleaseScheduledPush
+func (_mr *MockConnMockRecorder) ReleaseScheduledPush(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ReleaseScheduledPush", reflect.TypeOf((*MockConn)(nil).ReleaseScheduledPush), arg0, arg1, arg2)
+}
+
 // PublicDB mocks base method
 func (_m *MockConn) PublicDB() Database {
 	ret := _m.ctrl.Call(_m, "PublicDB")
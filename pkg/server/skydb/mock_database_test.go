@@ -141,6 +141,18 @@ func (_mr *MockDatabaseMockRecorder) GetByIDs(arg0, arg1 interface{}) *gomock.Ca
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetByIDs", reflect.TypeOf((*MockDatabase)(nil).GetByIDs), arg0, arg1)
 }
 
+// GetForUpdate mocks base method
+func (_m *MockDatabase) GetForUpdate(id RecordID, record *Record) error {
+	ret := _m.ctrl.Call(_m, "GetForUpdate", id, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetForUpdate indicates an expected call of GetForUpdate
+func (_mr *MockDatabaseMockRecorder) GetForUpdate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetForUpdate", reflect.TypeOf((*MockDatabase)(nil).GetForUpdate), arg0, arg1)
+}
+
 // Save mocks base method
 func (_m *MockDatabase) Save(record *Record) error {
 	ret := _m.ctrl.Call(_m, "Save", record)
@@ -178,6 +190,19 @@ func (_mr *MockDatabaseMockRecorder) Query(arg0, arg1 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Query", reflect.TypeOf((*MockDatabase)(nil).Query), arg0, arg1)
 }
 
+// ExplainQuery mocks base method
+func (_m *MockDatabase) ExplainQuery(query *Query, accessControlOptions *AccessControlOptions) (QueryExplanation, error) {
+	ret := _m.ctrl.Call(_m, "ExplainQuery", query, accessControlOptions)
+	ret0, _ := ret[0].(QueryExplanation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExplainQuery indicates an expected call of ExplainQuery
+func (_mr *MockDatabaseMockRecorder) ExplainQuery(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExplainQuery", reflect.TypeOf((*MockDatabase)(nil).ExplainQuery), arg0, arg1)
+}
+
 // QueryCount mocks base method
 func (_m *MockDatabase) QueryCount(query *Query, accessControlOptions *AccessControlOptions) (uint64, error) {
 	ret := _m.ctrl.Call(_m, "QueryCount", query, accessControlOptions)
@@ -228,6 +253,43 @@ func (_mr *MockDatabaseMockRecorder) DeleteSchema(arg0, arg1 interface{}) *gomoc
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteSchema", reflect.TypeOf((*MockDatabase)(nil).DeleteSchema), arg0, arg1)
 }
 
+// AlterSchemaType mocks base method
+func (_m *MockDatabase) AlterSchemaType(recordType string, columnName string, fieldType FieldType, dryRun bool) (TypeConversionReport, error) {
+	ret := _m.ctrl.Call(_m, "AlterSchemaType", recordType, columnName, fieldType, dryRun)
+	ret0, _ := ret[0].(TypeConversionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AlterSchemaType indicates an expected call of AlterSchemaType
+func (_mr *MockDatabaseMockRecorder) AlterSchemaType(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AlterSchemaType", reflect.TypeOf((*MockDatabase)(nil).AlterSchemaType), arg0, arg1, arg2, arg3)
+}
+
+// SetRecordSoftDelete mocks base method
+func (_m *MockDatabase) SetRecordSoftDelete(recordType string, enabled bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordSoftDelete", recordType, enabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRecordSoftDelete indicates an expected call of SetRecordSoftDelete
+func (_mr *MockDatabaseMockRecorder) SetRecordSoftDelete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordSoftDelete", reflect.TypeOf((*MockDatabase)(nil).SetRecordSoftDelete), arg0, arg1)
+}
+
+// Undelete mocks base method
+func (_m *MockDatabase) Undelete(id RecordID) error {
+	ret := _m.ctrl.Call(_m, "Undelete", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undelete indicates an expected call of Undelete
+func (_mr *MockDatabaseMockRecorder) Undelete(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Undelete", reflect.TypeOf((*MockDatabase)(nil).Undelete), arg0)
+}
+
 // GetSchema mocks base method
 func (_m *MockDatabase) GetSchema(recordType string) (RecordSchema, error) {
 	ret := _m.ctrl.Call(_m, "GetSchema", recordType)
@@ -579,6 +641,18 @@ func (_mr *MockTxDatabaseMockRecorder) GetByIDs(arg0, arg1 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetByIDs", reflect.TypeOf((*MockTxDatabase)(nil).GetByIDs), arg0, arg1)
 }
 
+// GetForUpdate mocks base method
+func (_m *MockTxDatabase) GetForUpdate(id RecordID, record *Record) error {
+	ret := _m.ctrl.Call(_m, "GetForUpdate", id, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetForUpdate indicates an expected call of GetForUpdate
+func (_mr *MockTxDatabaseMockRecorder) GetForUpdate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetForUpdate", reflect.TypeOf((*MockTxDatabase)(nil).GetForUpdate), arg0, arg1)
+}
+
 // Save mocks base method
 func (_m *MockTxDatabase) Save(record *Record) error {
 	ret := _m.ctrl.Call(_m, "Save", record)
@@ -616,6 +690,19 @@ func (_mr *MockTxDatabaseMockRecorder) Query(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Query", reflect.TypeOf((*MockTxDatabase)(nil).Query), arg0, arg1)
 }
 
+// ExplainQuery mocks base method
+func (_m *MockTxDatabase) ExplainQuery(query *Query, accessControlOptions *AccessControlOptions) (QueryExplanation, error) {
+	ret := _m.ctrl.Call(_m, "ExplainQuery", query, accessControlOptions)
+	ret0, _ := ret[0].(QueryExplanation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExplainQuery indicates an expected call of ExplainQuery
+func (_mr *MockTxDatabaseMockRecorder) ExplainQuery(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExplainQuery", reflect.TypeOf((*MockTxDatabase)(nil).ExplainQuery), arg0, arg1)
+}
+
 // QueryCount mocks base method
 func (_m *MockTxDatabase) QueryCount(query *Query, accessControlOptions *AccessControlOptions) (uint64, error) {
 	ret := _m.ctrl.Call(_m, "QueryCount", query, accessControlOptions)
@@ -666,6 +753,43 @@ func (_mr *MockTxDatabaseMockRecorder) DeleteSchema(arg0, arg1 interface{}) *gom
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteSchema", reflect.TypeOf((*MockTxDatabase)(nil).DeleteSchema), arg0, arg1)
 }
 
+// AlterSchemaType mocks base method
+func (_m *MockTxDatabase) AlterSchemaType(recordType string, columnName string, fieldType FieldType, dryRun bool) (TypeConversionReport, error) {
+	ret := _m.ctrl.Call(_m, "AlterSchemaType", recordType, columnName, fieldType, dryRun)
+	ret0, _ := ret[0].(TypeConversionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AlterSchemaType indicates an expected call of AlterSchemaType
+func (_mr *MockTxDatabaseMockRecorder) AlterSchemaType(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AlterSchemaType", reflect.TypeOf((*MockTxDatabase)(nil).AlterSchemaType), arg0, arg1, arg2, arg3)
+}
+
+// SetRecordSoftDelete mocks base method
+func (_m *MockTxDatabase) SetRecordSoftDelete(recordType string, enabled bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordSoftDelete", recordType, enabled)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRecordSoftDelete indicates an expected call of SetRecordSoftDelete
+func (_mr *MockTxDatabaseMockRecorder) SetRecordSoftDelete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordSoftDelete", reflect.TypeOf((*MockTxDatabase)(nil).SetRecordSoftDelete), arg0, arg1)
+}
+
+// Undelete mocks base method
+func (_m *MockTxDatabase) Undelete(id RecordID) error {
+	ret := _m.ctrl.Call(_m, "Undelete", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undelete indicates an expected call of Undelete
+func (_mr *MockTxDatabaseMockRecorder) Undelete(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Undelete", reflect.TypeOf((*MockTxDatabase)(nil).Undelete), arg0)
+}
+
 // GetSchema mocks base method
 func (_m *MockTxDatabase) GetSchema(recordType string) (RecordSchema, error) {
 	ret := _m.ctrl.Call(_m, "GetSchema", recordType)
@@ -57,6 +57,31 @@ func (_mr *MockConnMockRecorder) AssignRoles(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AssignRoles", reflect.TypeOf((*MockConn)(nil).AssignRoles), arg0, arg1)
 }
 
+// CancelScheduledPush mocks base method
+func (_m *MockConn) CancelScheduledPush(_param0 string, _param1 *skydb.ScheduledPush) error {
+	ret := _m.ctrl.Call(_m, "CancelScheduledPush", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelScheduledPush indicates an expected call of CancelScheduledPush
+func (_mr *MockConnMockRecorder) CancelScheduledPush(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "CancelScheduledPush", reflect.TypeOf((*MockConn)(nil).CancelScheduledPush), arg0, arg1)
+}
+
+// ClaimDueScheduledPushes mocks base method
+func (_m *MockConn) ClaimDueScheduledPushes(_param0 time.Time, _param1 time.Time) ([]skydb.ScheduledPush, error) {
+	ret := _m.ctrl.Call(_m, "ClaimDueScheduledPushes", _param0, _param1)
+	ret0, _ := ret[0].([]skydb.ScheduledPush)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimDueScheduledPushes indicates an expected call of ClaimDueScheduledPushes
+func (_mr *MockConnMockRecorder) ClaimDueScheduledPushes(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ClaimDueScheduledPushes", reflect.TypeOf((*MockConn)(nil).ClaimDueScheduledPushes), arg0, arg1)
+}
+
 // Close mocks base method
 func (_m *MockConn) Close() error {
 	ret := _m.ctrl.Call(_m, "Close")
@@ -105,6 +130,18 @@ func (_mr *MockConnMockRecorder) CreateOAuthInfo(arg0 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "CreateOAuthInfo", reflect.TypeOf((*MockConn)(nil).CreateOAuthInfo), arg0)
 }
 
+// DeleteAsset mocks base method
+func (_m *MockConn) DeleteAsset(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteAsset", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAsset indicates an expected call of DeleteAsset
+func (_mr *MockConnMockRecorder) DeleteAsset(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteAsset", reflect.TypeOf((*MockConn)(nil).DeleteAsset), arg0)
+}
+
 // DeleteAuth mocks base method
 func (_m *MockConn) DeleteAuth(_param0 string) error {
 	ret := _m.ctrl.Call(_m, "DeleteAuth", _param0)
@@ -177,6 +214,18 @@ func (_mr *MockConnMockRecorder) DeleteOAuth(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteOAuth", reflect.TypeOf((*MockConn)(nil).DeleteOAuth), arg0, arg1)
 }
 
+// DeleteUploadSession mocks base method
+func (_m *MockConn) DeleteUploadSession(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteUploadSession", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUploadSession indicates an expected call of DeleteUploadSession
+func (_mr *MockConnMockRecorder) DeleteUploadSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeleteUploadSession", reflect.TypeOf((*MockConn)(nil).DeleteUploadSession), arg0)
+}
+
 // EnsureAuthRecordKeysExist mocks base method
 func (_m *MockConn) EnsureAuthRecordKeysExist(_param0 [][]string) error {
 	ret := _m.ctrl.Call(_m, "EnsureAuthRecordKeysExist", _param0)
@@ -376,6 +425,44 @@ func (_mr *MockConnMockRecorder) GetRecordFieldAccess() *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordFieldAccess", reflect.TypeOf((*MockConn)(nil).GetRecordFieldAccess))
 }
 
+// GetRecordHistoryTypes mocks base method
+func (_m *MockConn) GetRecordHistoryTypes() ([]string, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordHistoryTypes")
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecordHistoryTypes indicates an expected call of GetRecordHistoryTypes
+func (_mr *MockConnMockRecorder) GetRecordHistoryTypes() *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordHistoryTypes", reflect.TypeOf((*MockConn)(nil).GetRecordHistoryTypes))
+}
+
+// GetRecordRevision mocks base method
+func (_m *MockConn) GetRecordRevision(_param0 string, _param1 skydb.RecordID, _param2 int, _param3 *skydb.RecordRevision) error {
+	ret := _m.ctrl.Call(_m, "GetRecordRevision", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetRecordRevision indicates an expected call of GetRecordRevision
+func (_mr *MockConnMockRecorder) GetRecordRevision(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordRevision", reflect.TypeOf((*MockConn)(nil).GetRecordRevision), arg0, arg1, arg2, arg3)
+}
+
+// GetRecordRevisions mocks base method
+func (_m *MockConn) GetRecordRevisions(_param0 string, _param1 skydb.RecordID) ([]skydb.RecordRevision, error) {
+	ret := _m.ctrl.Call(_m, "GetRecordRevisions", _param0, _param1)
+	ret0, _ := ret[0].([]skydb.RecordRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecordRevisions indicates an expected call of GetRecordRevisions
+func (_mr *MockConnMockRecorder) GetRecordRevisions(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRecordRevisions", reflect.TypeOf((*MockConn)(nil).GetRecordRevisions), arg0, arg1)
+}
+
 // GetRoles mocks base method
 func (_m *MockConn) GetRoles(_param0 []string) (map[string][]string, error) {
 	ret := _m.ctrl.Call(_m, "GetRoles", _param0)
@@ -389,6 +476,54 @@ func (_mr *MockConnMockRecorder) GetRoles(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetRoles", reflect.TypeOf((*MockConn)(nil).GetRoles), arg0)
 }
 
+// GetScheduledPush mocks base method
+func (_m *MockConn) GetScheduledPush(_param0 string, _param1 *skydb.ScheduledPush) error {
+	ret := _m.ctrl.Call(_m, "GetScheduledPush", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetScheduledPush indicates an expected call of GetScheduledPush
+func (_mr *MockConnMockRecorder) GetScheduledPush(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetScheduledPush", reflect.TypeOf((*MockConn)(nil).GetScheduledPush), arg0, arg1)
+}
+
+// GetUploadSession mocks base method
+func (_m *MockConn) GetUploadSession(_param0 string, _param1 *skydb.UploadSession) error {
+	ret := _m.ctrl.Call(_m, "GetUploadSession", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetUploadSession indicates an expected call of GetUploadSession
+func (_mr *MockConnMockRecorder) GetUploadSession(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetUploadSession", reflect.TypeOf((*MockConn)(nil).GetUploadSession), arg0, arg1)
+}
+
+// GetUploadSessionForUpdate mocks base method
+func (_m *MockConn) GetUploadSessionForUpdate(_param0 string, _param1 *skydb.UploadSession) error {
+	ret := _m.ctrl.Call(_m, "GetUploadSessionForUpdate", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetUploadSessionForUpdate indicates an expected call of GetUploadSessionForUpdate
+func (_mr *MockConnMockRecorder) GetUploadSessionForUpdate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetUploadSessionForUpdate", reflect.TypeOf((*MockConn)(nil).GetUploadSessionForUpdate), arg0, arg1)
+}
+
+// MarkScheduledPushSent mocks base method
+func (_m *MockConn) MarkScheduledPushSent(_param0 string, _param1 []string) error {
+	ret := _m.ctrl.Call(_m, "MarkScheduledPushSent", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkScheduledPushSent indicates an expected call of MarkScheduledPushSent
+func (_mr *MockConnMockRecorder) MarkScheduledPushSent(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "MarkScheduledPushSent", reflect.TypeOf((*MockConn)(nil).MarkScheduledPushSent), arg0, arg1)
+}
+
 // PrivateDB mocks base method
 func (_m *MockConn) PrivateDB(_param0 string) skydb.Database {
 	ret := _m.ctrl.Call(_m, "PrivateDB", _param0)
@@ -413,6 +548,19 @@ func (_mr *MockConnMockRecorder) PublicDB() *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PublicDB", reflect.TypeOf((*MockConn)(nil).PublicDB))
 }
 
+// PurgeDeletedRecords mocks base method
+func (_m *MockConn) PurgeDeletedRecords(_param0 time.Time) (uint64, error) {
+	ret := _m.ctrl.Call(_m, "PurgeDeletedRecords", _param0)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeletedRecords indicates an expected call of PurgeDeletedRecords
+func (_mr *MockConnMockRecorder) PurgeDeletedRecords(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PurgeDeletedRecords", reflect.TypeOf((*MockConn)(nil).PurgeDeletedRecords), arg0)
+}
+
 // QueryDevicesByUser mocks base method
 func (_m *MockConn) QueryDevicesByUser(_param0 string) ([]skydb.Device, error) {
 	ret := _m.ctrl.Call(_m, "QueryDevicesByUser", _param0)
@@ -439,6 +587,19 @@ func (_mr *MockConnMockRecorder) QueryDevicesByUserAndTopic(arg0, arg1 interface
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "QueryDevicesByUserAndTopic", reflect.TypeOf((*MockConn)(nil).QueryDevicesByUserAndTopic), arg0, arg1)
 }
 
+// QueryExpiredUploadSessions mocks base method
+func (_m *MockConn) QueryExpiredUploadSessions(_param0 time.Time) ([]skydb.UploadSession, error) {
+	ret := _m.ctrl.Call(_m, "QueryExpiredUploadSessions", _param0)
+	ret0, _ := ret[0].([]skydb.UploadSession)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryExpiredUploadSessions indicates an expected call of QueryExpiredUploadSessions
+func (_mr *MockConnMockRecorder) QueryExpiredUploadSessions(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "QueryExpiredUploadSessions", reflect.TypeOf((*MockConn)(nil).QueryExpiredUploadSessions), arg0)
+}
+
 // QueryRelation mocks base method
 func (_m *MockConn) QueryRelation(_param0 string, _param1 string, _param2 string, _param3 skydb.QueryConfig) []skydb.AuthInfo {
 	ret := _m.ctrl.Call(_m, "QueryRelation", _param0, _param1, _param2, _param3)
@@ -464,6 +625,31 @@ func (_mr *MockConnMockRecorder) QueryRelationCount(arg0, arg1, arg2 interface{}
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "QueryRelationCount", reflect.TypeOf((*MockConn)(nil).QueryRelationCount), arg0, arg1, arg2)
 }
 
+// QueryUnreferencedAssets mocks base method
+func (_m *MockConn) QueryUnreferencedAssets(_param0 time.Time) ([]skydb.Asset, error) {
+	ret := _m.ctrl.Call(_m, "QueryUnreferencedAssets", _param0)
+	ret0, _ := ret[0].([]skydb.Asset)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryUnreferencedAssets indicates an expected call of QueryUnreferencedAssets
+func (_mr *MockConnMockRecorder) QueryUnreferencedAssets(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "QueryUnreferencedAssets", reflect.TypeOf((*MockConn)(nil).QueryUnreferencedAssets), arg0)
+}
+
+// ReleaseScheduledPush mocks base method
+func (_m *MockConn) ReleaseScheduledPush(_param0 string, _param1 skydb.ScheduledPushStatus, _param2 time.Time) error {
+	ret := _m.ctrl.Call(_m, "ReleaseScheduledPush", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseScheduledPush indicates an expected call of ReleaseScheduledPush
+func (_mr *MockConnMockRecorder) ReleaseScheduledPush(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ReleaseScheduledPush", reflect.TypeOf((*MockConn)(nil).ReleaseScheduledPush), arg0, arg1, arg2)
+}
+
 // RemovePasswordHistory mocks base method
 func (_m *MockConn) RemovePasswordHistory(_param0 string, _param1 int, _param2 int) error {
 	ret := _m.ctrl.Call(_m, "RemovePasswordHistory", _param0, _param1, _param2)
@@ -524,6 +710,42 @@ func (_mr *MockConnMockRecorder) SaveDevice(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveDevice", reflect.TypeOf((*MockConn)(nil).SaveDevice), arg0)
 }
 
+// SaveRecordRevision mocks base method
+func (_m *MockConn) SaveRecordRevision(_param0 *skydb.RecordRevision) error {
+	ret := _m.ctrl.Call(_m, "SaveRecordRevision", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRecordRevision indicates an expected call of SaveRecordRevision
+func (_mr *MockConnMockRecorder) SaveRecordRevision(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveRecordRevision", reflect.TypeOf((*MockConn)(nil).SaveRecordRevision), arg0)
+}
+
+// SaveScheduledPush mocks base method
+func (_m *MockConn) SaveScheduledPush(_param0 *skydb.ScheduledPush) error {
+	ret := _m.ctrl.Call(_m, "SaveScheduledPush", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveScheduledPush indicates an expected call of SaveScheduledPush
+func (_mr *MockConnMockRecorder) SaveScheduledPush(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveScheduledPush", reflect.TypeOf((*MockConn)(nil).SaveScheduledPush), arg0)
+}
+
+// SaveUploadSession mocks base method
+func (_m *MockConn) SaveUploadSession(_param0 *skydb.UploadSession) error {
+	ret := _m.ctrl.Call(_m, "SaveUploadSession", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveUploadSession indicates an expected call of SaveUploadSession
+func (_mr *MockConnMockRecorder) SaveUploadSession(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveUploadSession", reflect.TypeOf((*MockConn)(nil).SaveUploadSession), arg0)
+}
+
 // SetAdminRoles mocks base method
 func (_m *MockConn) SetAdminRoles(_param0 []string) error {
 	ret := _m.ctrl.Call(_m, "SetAdminRoles", _param0)
@@ -584,6 +806,18 @@ func (_mr *MockConnMockRecorder) SetRecordFieldAccess(arg0 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordFieldAccess", reflect.TypeOf((*MockConn)(nil).SetRecordFieldAccess), arg0)
 }
 
+// SetRecordHistoryEnabled mocks base method
+func (_m *MockConn) SetRecordHistoryEnabled(_param0 string, _param1 bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordHistoryEnabled", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRecordHistoryEnabled indicates an expected call of SetRecordHistoryEnabled
+func (_mr *MockConnMockRecorder) SetRecordHistoryEnabled(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordHistoryEnabled", reflect.TypeOf((*MockConn)(nil).SetRecordHistoryEnabled), arg0, arg1)
+}
+
 // Subscribe mocks base method
 func (_m *MockConn) Subscribe(_param0 chan skydb.RecordEvent) error {
 	ret := _m.ctrl.Call(_m, "Subscribe", _param0)
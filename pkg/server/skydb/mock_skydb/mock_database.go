@@ -32,6 +32,19 @@ func (_m *MockDatabase) EXPECT() *MockDatabaseMockRecorder {
 	return _m.recorder
 }
 
+// AlterSchemaType mocks base method
+func (_m *MockDatabase) AlterSchemaType(_param0 string, _param1 string, _param2 skydb.FieldType, _param3 bool) (skydb.TypeConversionReport, error) {
+	ret := _m.ctrl.Call(_m, "AlterSchemaType", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(skydb.TypeConversionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AlterSchemaType indicates an expected call of AlterSchemaType
+func (_mr *MockDatabaseMockRecorder) AlterSchemaType(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AlterSchemaType", reflect.TypeOf((*MockDatabase)(nil).AlterSchemaType), arg0, arg1, arg2, arg3)
+}
+
 // Conn mocks base method
 func (_m *MockDatabase) Conn() skydb.Conn {
 	ret := _m.ctrl.Call(_m, "Conn")
@@ -142,6 +155,18 @@ func (_mr *MockDatabaseMockRecorder) GetByIDs(arg0, arg1 interface{}) *gomock.Ca
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetByIDs", reflect.TypeOf((*MockDatabase)(nil).GetByIDs), arg0, arg1)
 }
 
+// GetForUpdate mocks base method
+func (_m *MockDatabase) GetForUpdate(_param0 skydb.RecordID, _param1 *skydb.Record) error {
+	ret := _m.ctrl.Call(_m, "GetForUpdate", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetForUpdate indicates an expected call of GetForUpdate
+func (_mr *MockDatabaseMockRecorder) GetForUpdate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetForUpdate", reflect.TypeOf((*MockDatabase)(nil).GetForUpdate), arg0, arg1)
+}
+
 // GetIndexesByRecordType mocks base method
 func (_m *MockDatabase) GetIndexesByRecordType(_param0 string) (map[string]skydb.Index, error) {
 	ret := _m.ctrl.Call(_m, "GetIndexesByRecordType", _param0)
@@ -254,6 +279,19 @@ func (_mr *MockDatabaseMockRecorder) Query(arg0, arg1 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Query", reflect.TypeOf((*MockDatabase)(nil).Query), arg0, arg1)
 }
 
+// ExplainQuery mocks base method
+func (_m *MockDatabase) ExplainQuery(_param0 *skydb.Query, _param1 *skydb.AccessControlOptions) (skydb.QueryExplanation, error) {
+	ret := _m.ctrl.Call(_m, "ExplainQuery", _param0, _param1)
+	ret0, _ := ret[0].(skydb.QueryExplanation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExplainQuery indicates an expected call of ExplainQuery
+func (_mr *MockDatabaseMockRecorder) ExplainQuery(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExplainQuery", reflect.TypeOf((*MockDatabase)(nil).ExplainQuery), arg0, arg1)
+}
+
 // QueryCount mocks base method
 func (_m *MockDatabase) QueryCount(_param0 *skydb.Query, _param1 *skydb.AccessControlOptions) (uint64, error) {
 	ret := _m.ctrl.Call(_m, "QueryCount", _param0, _param1)
@@ -328,6 +366,18 @@ func (_mr *MockDatabaseMockRecorder) SaveSubscription(arg0 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveSubscription", reflect.TypeOf((*MockDatabase)(nil).SaveSubscription), arg0)
 }
 
+// SetRecordSoftDelete mocks base method
+func (_m *MockDatabase) SetRecordSoftDelete(_param0 string, _param1 bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordSoftDelete", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRecordSoftDelete indicates an expected call of SetRecordSoftDelete
+func (_mr *MockDatabaseMockRecorder) SetRecordSoftDelete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordSoftDelete", reflect.TypeOf((*MockDatabase)(nil).SetRecordSoftDelete), arg0, arg1)
+}
+
 // TableName mocks base method
 func (_m *MockDatabase) TableName(_param0 string) string {
 	ret := _m.ctrl.Call(_m, "TableName", _param0)
@@ -340,6 +390,18 @@ func (_mr *MockDatabaseMockRecorder) TableName(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "TableName", reflect.TypeOf((*MockDatabase)(nil).TableName), arg0)
 }
 
+// Undelete mocks base method
+func (_m *MockDatabase) Undelete(_param0 skydb.RecordID) error {
+	ret := _m.ctrl.Call(_m, "Undelete", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undelete indicates an expected call of Undelete
+func (_mr *MockDatabaseMockRecorder) Undelete(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Undelete", reflect.TypeOf((*MockDatabase)(nil).Undelete), arg0)
+}
+
 // UserRecordType mocks base method
 func (_m *MockDatabase) UserRecordType() string {
 	ret := _m.ctrl.Call(_m, "UserRecordType")
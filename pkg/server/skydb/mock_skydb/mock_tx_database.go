@@ -32,6 +32,19 @@ func (_m *MockTxDatabase) EXPECT() *MockTxDatabaseMockRecorder {
 	return _m.recorder
 }
 
+// AlterSchemaType mocks base method
+func (_m *MockTxDatabase) AlterSchemaType(_param0 string, _param1 string, _param2 skydb.FieldType, _param3 bool) (skydb.TypeConversionReport, error) {
+	ret := _m.ctrl.Call(_m, "AlterSchemaType", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(skydb.TypeConversionReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AlterSchemaType indicates an expected call of AlterSchemaType
+func (_mr *MockTxDatabaseMockRecorder) AlterSchemaType(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "AlterSchemaType", reflect.TypeOf((*MockTxDatabase)(nil).AlterSchemaType), arg0, arg1, arg2, arg3)
+}
+
 // Begin mocks base method
 func (_m *MockTxDatabase) Begin() error {
 	ret := _m.ctrl.Call(_m, "Begin")
@@ -166,6 +179,18 @@ func (_mr *MockTxDatabaseMockRecorder) GetByIDs(arg0, arg1 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetByIDs", reflect.TypeOf((*MockTxDatabase)(nil).GetByIDs), arg0, arg1)
 }
 
+// GetForUpdate mocks base method
+func (_m *MockTxDatabase) GetForUpdate(_param0 skydb.RecordID, _param1 *skydb.Record) error {
+	ret := _m.ctrl.Call(_m, "GetForUpdate", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetForUpdate indicates an expected call of GetForUpdate
+func (_mr *MockTxDatabaseMockRecorder) GetForUpdate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "GetForUpdate", reflect.TypeOf((*MockTxDatabase)(nil).GetForUpdate), arg0, arg1)
+}
+
 // GetIndexesByRecordType mocks base method
 func (_m *MockTxDatabase) GetIndexesByRecordType(_param0 string) (map[string]skydb.Index, error) {
 	ret := _m.ctrl.Call(_m, "GetIndexesByRecordType", _param0)
@@ -278,6 +303,19 @@ func (_mr *MockTxDatabaseMockRecorder) Query(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Query", reflect.TypeOf((*MockTxDatabase)(nil).Query), arg0, arg1)
 }
 
+// ExplainQuery mocks base method
+func (_m *MockTxDatabase) ExplainQuery(_param0 *skydb.Query, _param1 *skydb.AccessControlOptions) (skydb.QueryExplanation, error) {
+	ret := _m.ctrl.Call(_m, "ExplainQuery", _param0, _param1)
+	ret0, _ := ret[0].(skydb.QueryExplanation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExplainQuery indicates an expected call of ExplainQuery
+func (_mr *MockTxDatabaseMockRecorder) ExplainQuery(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ExplainQuery", reflect.TypeOf((*MockTxDatabase)(nil).ExplainQuery), arg0, arg1)
+}
+
 // QueryCount mocks base method
 func (_m *MockTxDatabase) QueryCount(_param0 *skydb.Query, _param1 *skydb.AccessControlOptions) (uint64, error) {
 	ret := _m.ctrl.Call(_m, "QueryCount", _param0, _param1)
@@ -364,6 +402,18 @@ func (_mr *MockTxDatabaseMockRecorder) SaveSubscription(arg0 interface{}) *gomoc
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SaveSubscription", reflect.TypeOf((*MockTxDatabase)(nil).SaveSubscription), arg0)
 }
 
+// SetRecordSoftDelete mocks base method
+func (_m *MockTxDatabase) SetRecordSoftDelete(_param0 string, _param1 bool) error {
+	ret := _m.ctrl.Call(_m, "SetRecordSoftDelete", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRecordSoftDelete indicates an expected call of SetRecordSoftDelete
+func (_mr *MockTxDatabaseMockRecorder) SetRecordSoftDelete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "SetRecordSoftDelete", reflect.TypeOf((*MockTxDatabase)(nil).SetRecordSoftDelete), arg0, arg1)
+}
+
 // TableName mocks base method
 func (_m *MockTxDatabase) TableName(_param0 string) string {
 	ret := _m.ctrl.Call(_m, "TableName", _param0)
@@ -376,6 +426,18 @@ func (_mr *MockTxDatabaseMockRecorder) TableName(arg0 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "TableName", reflect.TypeOf((*MockTxDatabase)(nil).TableName), arg0)
 }
 
+// Undelete mocks base method
+func (_m *MockTxDatabase) Undelete(_param0 skydb.RecordID) error {
+	ret := _m.ctrl.Call(_m, "Undelete", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Undelete indicates an expected call of Undelete
+func (_mr *MockTxDatabaseMockRecorder) Undelete(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Undelete", reflect.TypeOf((*MockTxDatabase)(nil).Undelete), arg0)
+}
+
 // UserRecordType mocks base method
 func (_m *MockTxDatabase) UserRecordType() string {
 	ret := _m.ctrl.Call(_m, "UserRecordType")
@@ -148,7 +148,9 @@ func (c *conn) GetRecordDefaultAccess(recordType string) (skydb.RecordACL, error
 
 	nullableACLString := sql.NullString{}
 	err := c.QueryRowWith(builder).Scan(&nullableACLString)
-	if err != nil {
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -161,7 +163,7 @@ func (c *conn) GetRecordDefaultAccess(recordType string) (skydb.RecordACL, error
 }
 
 func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) (err error) {
-	tx, err := c.db.Beginx()
+	tx, err := c.beginx()
 	if err != nil {
 		return
 	}
@@ -0,0 +1,273 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// typeConversionSQL is the SQL of a skydb.TypeConversion. In both
+// expressions, %[1]s is the quoted column name.
+type typeConversionSQL struct {
+	// Using is the expression converting the column to the new type.
+	Using string
+
+	// Valid is the predicate of non-null values that can be converted,
+	// empty if all values can be converted.
+	Valid string
+}
+
+const (
+	integerRange  = "BETWEEN -2147483648 AND 2147483647"
+	datetimeRange = "BETWEEN -62135596800 AND 253402300799"
+)
+
+var typeConversionSQLs = map[[2]skydb.DataType]typeConversionSQL{
+	{skydb.TypeString, skydb.TypeNumber}: {
+		Using: "trim(%[1]s)::double precision",
+		Valid: `%[1]s ~ '^\s*[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?\s*$'`,
+	},
+	{skydb.TypeString, skydb.TypeInteger}: {
+		Using: "trim(%[1]s)::integer",
+		Valid: `CASE WHEN %[1]s ~ '^\s*[-+]?[0-9]{1,10}\s*$' THEN trim(%[1]s)::numeric ` + integerRange + ` ELSE FALSE END`,
+	},
+	{skydb.TypeString, skydb.TypeBoolean}: {
+		Using: "lower(trim(%[1]s))::boolean",
+		Valid: "lower(trim(%[1]s)) IN ('true', 'false', 't', 'f', 'yes', 'no', 'y', 'n', 'on', 'off', '1', '0')",
+	},
+	{skydb.TypeString, skydb.TypeDateTime}: {
+		// The predicate checks the format only, an out of range date such
+		// as 2017-02-30 fails when the column is altered.
+		Using: "trim(%[1]s)::timestamp with time zone AT TIME ZONE 'UTC'",
+		Valid: `%[1]s ~ '^\s*[0-9]{4}-[0-9]{2}-[0-9]{2}([T ][0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]+)?)?)?\s*(Z|[-+][0-9]{2}(:?[0-9]{2})?)?\s*$'`,
+	},
+	{skydb.TypeNumber, skydb.TypeString}: {
+		Using: "%[1]s::text",
+	},
+	{skydb.TypeNumber, skydb.TypeInteger}: {
+		Using: "round(%[1]s)::integer",
+		Valid: "round(%[1]s) " + integerRange,
+	},
+	{skydb.TypeNumber, skydb.TypeDateTime}: {
+		Using: "to_timestamp(%[1]s) AT TIME ZONE 'UTC'",
+		Valid: "%[1]s " + datetimeRange,
+	},
+	{skydb.TypeInteger, skydb.TypeString}: {
+		Using: "%[1]s::text",
+	},
+	{skydb.TypeInteger, skydb.TypeNumber}: {
+		Using: "%[1]s::double precision",
+	},
+	{skydb.TypeInteger, skydb.TypeBoolean}: {
+		Using: "%[1]s <> 0",
+	},
+	{skydb.TypeInteger, skydb.TypeDateTime}: {
+		Using: "to_timestamp(%[1]s) AT TIME ZONE 'UTC'",
+	},
+	{skydb.TypeBoolean, skydb.TypeString}: {
+		Using: "%[1]s::text",
+	},
+	{skydb.TypeBoolean, skydb.TypeNumber}: {
+		Using: "%[1]s::integer::double precision",
+	},
+	{skydb.TypeBoolean, skydb.TypeInteger}: {
+		Using: "%[1]s::integer",
+	},
+	{skydb.TypeDateTime, skydb.TypeString}: {
+		Using: `to_char(%[1]s, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')`,
+	},
+	{skydb.TypeDateTime, skydb.TypeNumber}: {
+		Using: "extract(epoch FROM %[1]s)",
+	},
+	{skydb.TypeDateTime, skydb.TypeInteger}: {
+		Using: "floor(extract(epoch FROM %[1]s))::integer",
+		Valid: "floor(extract(epoch FROM %[1]s)) " + integerRange,
+	},
+	{skydb.TypeJSON, skydb.TypeString}: {
+		Using: "%[1]s #>> '{}'",
+	},
+}
+
+// AlterSchemaType alters the type of a column with ALTER COLUMN ... USING.
+// Constraints of the field are kept if they are applicable to the new
+// type, otherwise they are removed.
+func (db *database) AlterSchemaType(recordType, columnName string, fieldType skydb.FieldType, dryRun bool) (report skydb.TypeConversionReport, err error) {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+
+	if !dryRun && !db.c.canMigrate {
+		err = skyerr.NewError(
+			skyerr.IncompatibleSchema,
+			"Record schema requires migration but migration is disabled.",
+		)
+		return
+	}
+
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+	if remoteRecordSchema == nil {
+		err = skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("record type %s does not exist", recordType))
+		return
+	}
+	remoteFieldType, ok := remoteRecordSchema[columnName]
+	if !ok {
+		err = skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("field %s does not exist", columnName))
+		return
+	}
+
+	conversion, err := skydb.GetTypeConversion(remoteFieldType, fieldType)
+	if err != nil {
+		err = skyerr.NewError(skyerr.NotSupported, err.Error())
+		return
+	}
+	conversionSQL, ok := typeConversionSQLs[[2]skydb.DataType{conversion.From, conversion.To}]
+	if !ok {
+		err = skyerr.NewError(skyerr.NotSupported, fmt.Sprintf(
+			"conversion from %s to %s is not supported",
+			remoteFieldType.ToSimpleName(),
+			fieldType.ToSimpleName(),
+		))
+		return
+	}
+	report.Conversion = conversion
+
+	tableName := db.TableName(recordType)
+	column := pq.QuoteIdentifier(columnName)
+
+	if conversionSQL.Valid != "" {
+		if err = db.findConversionFailures(&report, recordType, column, conversionSQL.Valid); err != nil {
+			return
+		}
+	}
+
+	if dryRun {
+		return
+	}
+
+	if report.FailedCount > 0 {
+		failedRecords := make([]string, len(report.FailedRecordIDs))
+		for i, id := range report.FailedRecordIDs {
+			failedRecords[i] = id.String()
+		}
+		err = skyerr.NewErrorWithInfo(
+			skyerr.IncompatibleSchema,
+			fmt.Sprintf(
+				"%d records cannot be converted from %s to %s",
+				report.FailedCount,
+				remoteFieldType.ToSimpleName(),
+				fieldType.ToSimpleName(),
+			),
+			map[string]interface{}{
+				"failed_count":   report.FailedCount,
+				"failed_records": failedRecords,
+			},
+		)
+		return
+	}
+
+	// Begin transaction for schema migration. If the connection is in a
+	// transaction, the migration is committed or rolled back with it.
+	tx := db.c.tx
+	if tx == nil {
+		tx, err = db.c.beginx()
+		if err != nil {
+			return
+		}
+		defer tx.Rollback()
+	}
+
+	constraints := remoteFieldType.Constraints
+	if constraints != nil {
+		if err = db.setFieldConstraints(tx, recordType, columnName, skydb.FieldType{}); err != nil {
+			return
+		}
+	}
+
+	stmt := fmt.Sprintf(
+		"ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s",
+		tableName,
+		column,
+		pqDataType(fieldType.Type),
+		fmt.Sprintf(conversionSQL.Using, column),
+	)
+	logger.WithField("stmt", stmt).Debugln("Altering column type")
+	if _, err = tx.Exec(stmt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Class() == "22" {
+			err = skyerr.NewError(skyerr.IncompatibleSchema, fmt.Sprintf("failed to convert %s: %s", columnName, pqErr.Message))
+			return
+		}
+		err = fmt.Errorf("failed to alter table: %s", err)
+		return
+	}
+
+	if constraints != nil && constraints.ValidateDefinition(fieldType) == nil {
+		fieldType.Constraints = constraints
+		if err = db.setFieldConstraints(tx, recordType, columnName, fieldType); err != nil {
+			return
+		}
+	}
+
+	if tx != db.c.tx {
+		if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("unable to commit transaction for AlterSchemaType: %s", err)
+			return
+		}
+	}
+
+	delete(db.c.RecordSchema, recordType)
+	return
+}
+
+// findConversionFailures reports the records of which the column fails the
+// valid predicate. The SQL is not built with squirrel because the
+// predicate may contain question marks in regular expressions.
+func (db *database) findConversionFailures(report *skydb.TypeConversionReport, recordType, column, valid string) error {
+	where := fmt.Sprintf("%s IS NOT NULL AND NOT (%s)", column, fmt.Sprintf(valid, column))
+
+	countStmt := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s", db.TableName(recordType), where)
+	if err := db.c.QueryRowx(countStmt).Scan(&report.FailedCount); err != nil {
+		return err
+	}
+	if report.FailedCount == 0 {
+		return nil
+	}
+
+	stmt := fmt.Sprintf(
+		"SELECT _id FROM %s WHERE %s ORDER BY _id LIMIT %d",
+		db.TableName(recordType),
+		where,
+		skydb.MaxReportedConversionFailures,
+	)
+	rows, err := db.c.Queryx(stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		report.FailedRecordIDs = append(report.FailedRecordIDs, skydb.NewRecordID(recordType, id))
+	}
+	return rows.Err()
+}
@@ -16,8 +16,12 @@ package pq
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 
 	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
 	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
@@ -78,8 +82,108 @@ func (c *conn) SaveAsset(asset *skydb.Asset) error {
 	data := map[string]interface{}{
 		"content_type": asset.ContentType,
 		"size":         asset.Size,
+		"updated_at":   time.Now().UTC(),
 	}
 	upsert := builder.UpsertQuery(c.tableName("_asset"), pkData, data)
 	_, err := c.ExecWith(upsert)
 	return err
 }
+
+func (c *conn) QueryUnreferencedAssets(savedBefore time.Time) ([]skydb.Asset, error) {
+	schemas, err := c.PublicDB().GetRecordSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	recordTypes := []string{}
+	for recordType := range schemas {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+
+	builder := psql.Select("a.id", "a.content_type", "a.size").
+		From(c.tableName("_asset")+" AS a").
+		Where("a.updated_at < ?", savedBefore.UTC()).
+		Where("NOT " + c.assetInRecordHistoryCondition("a.id")).
+		OrderBy("a.id")
+
+	for _, recordType := range recordTypes {
+		columns := []string{}
+		for column, fieldType := range schemas[recordType] {
+			if fieldType.Type == skydb.TypeAsset {
+				columns = append(columns, column)
+			}
+		}
+		sort.Strings(columns)
+
+		for _, column := range columns {
+			builder = builder.Where(fmt.Sprintf(
+				"NOT EXISTS (SELECT 1 FROM %s WHERE %s = a.id)",
+				c.tableName(recordType),
+				pq.QuoteIdentifier(column),
+			))
+		}
+	}
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Asset{}
+	for rows.Next() {
+		a := skydb.Asset{}
+		if err := rows.Scan(&a.Name, &a.ContentType, &a.Size); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+
+	return results, rows.Err()
+}
+
+// assetInRecordHistoryCondition returns a condition which is true if
+// a revision in the record history refers to the asset of the column, so
+// that the asset is kept for restoring the revision.
+func (c *conn) assetInRecordHistoryCondition(column string) string {
+	return fmt.Sprintf(
+		`EXISTS (SELECT 1 FROM %s AS h, jsonb_each(h.record) AS f
+		WHERE f.value->>'$type' = 'asset' AND f.value->>'$name' = %s)`,
+		c.tableName("_record_history"),
+		column,
+	)
+}
+
+func (c *conn) DeleteAsset(name string) error {
+	// the record history has no foreign key to the asset
+	var inRecordHistory bool
+	err := c.QueryRowx("SELECT "+c.assetInRecordHistoryCondition("$1"), name).
+		Scan(&inRecordHistory)
+	if err != nil {
+		return err
+	}
+	if inRecordHistory {
+		return skydb.ErrAssetReferenced
+	}
+
+	builder := psql.Delete(c.tableName("_asset")).
+		Where("id = ?", name)
+
+	result, err := c.ExecWith(builder)
+	if isForeignKeyViolated(err) {
+		return skydb.ErrAssetReferenced
+	} else if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("asset not found")
+	}
+	return nil
+}
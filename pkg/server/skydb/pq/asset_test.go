@@ -0,0 +1,80 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnreferencedAsset(t *testing.T) {
+	Convey("Unreferenced Asset", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		So(c.SaveAsset(&skydb.Asset{
+			Name:        "referenced.png",
+			ContentType: "image/png",
+			Size:        1,
+		}), ShouldBeNil)
+		So(c.SaveAsset(&skydb.Asset{
+			Name:        "unreferenced.png",
+			ContentType: "image/png",
+			Size:        2,
+		}), ShouldBeNil)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"image": skydb.FieldType{Type: skydb.TypeAsset},
+		})
+		So(err, ShouldBeNil)
+		So(db.Save(&skydb.Record{
+			ID: skydb.NewRecordID("note", "id"),
+			Data: map[string]interface{}{
+				"image": &skydb.Asset{Name: "referenced.png"},
+			},
+			OwnerID: "user_id",
+		}), ShouldBeNil)
+
+		Convey("queries assets not referenced by any record", func() {
+			assets, err := c.QueryUnreferencedAssets(time.Now().Add(time.Minute))
+			So(err, ShouldBeNil)
+			So(assets, ShouldResemble, []skydb.Asset{
+				{Name: "unreferenced.png", ContentType: "image/png", Size: 2},
+			})
+		})
+
+		Convey("excludes assets saved after the specified time", func() {
+			assets, err := c.QueryUnreferencedAssets(time.Now().Add(-time.Minute))
+			So(err, ShouldBeNil)
+			So(assets, ShouldBeEmpty)
+		})
+
+		Convey("deletes unreferenced asset", func() {
+			So(c.DeleteAsset("unreferenced.png"), ShouldBeNil)
+
+			assets, err := c.GetAssets([]string{"unreferenced.png"})
+			So(err, ShouldBeNil)
+			So(assets, ShouldBeEmpty)
+		})
+
+		Convey("does not delete referenced asset", func() {
+			So(c.DeleteAsset("referenced.png"), ShouldEqual, skydb.ErrAssetReferenced)
+		})
+	})
+}
@@ -38,6 +38,25 @@ type sqlizerFactory struct {
 	primaryTable string
 	joinedTables []joinedTable
 	extraColumns map[string]skydb.FieldType
+
+	// alias is the alias of the primary table, which is the same as
+	// primaryTable except in a subquery.
+	alias string
+
+	// aliasPrefix is prepended to the alias of the tables joined and
+	// the subqueries created by the factory, so that they do not clash
+	// with the aliases of an enclosing query.
+	aliasPrefix string
+
+	// databaseID restricts the records of joined tables and subqueries
+	// to a database. Records of all databases are matched if it is nil.
+	databaseID *string
+
+	// accessControlOptions is applied to the records of joined tables
+	// and subqueries. No access control is applied if it is nil.
+	accessControlOptions *skydb.AccessControlOptions
+
+	subqueryCount int
 }
 
 func NewSqlizerFactory(db skydb.Database, primaryTable string) SqlizerFactory {
@@ -45,6 +64,22 @@ func NewSqlizerFactory(db skydb.Database, primaryTable string) SqlizerFactory {
 		db:           db,
 		primaryTable: primaryTable,
 		joinedTables: []joinedTable{},
+		alias:        primaryTable,
+	}
+}
+
+// NewQuerySqlizerFactory returns a SqlizerFactory for querying the records
+// of primaryTable. Records of joined tables and subqueries are restricted
+// to the database of databaseID, unless it is nil, and filtered with
+// accessControlOptions in the same way as the queried records.
+func NewQuerySqlizerFactory(db skydb.Database, primaryTable string, databaseID *string, accessControlOptions *skydb.AccessControlOptions) SqlizerFactory {
+	return &sqlizerFactory{
+		db:                   db,
+		primaryTable:         primaryTable,
+		joinedTables:         []joinedTable{},
+		alias:                primaryTable,
+		databaseID:           databaseID,
+		accessControlOptions: accessControlOptions,
 	}
 }
 
@@ -56,6 +91,9 @@ func (f *sqlizerFactory) NewPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, err
 	if p.Operator == skydb.Functional {
 		return f.newFunctionalPredicateSqlizer(p)
 	}
+	if p.Operator == skydb.Exists {
+		return f.newExistsPredicateSqlizer(p)
+	}
 	if p.Operator.IsCompound() {
 		return f.newCompoundPredicateSqlizer(p)
 	}
@@ -138,7 +176,7 @@ func (f *sqlizerFactory) newUserRelationFunctionalPredicateSqlizer(fn skydb.User
 
 func (f *sqlizerFactory) NewAccessControlSqlizer(user *skydb.AuthInfo, aclLevel skydb.RecordACLLevel) (sq.Sqlizer, error) {
 	return &accessPredicateSqlizer{
-		f.primaryTable,
+		f.alias,
 		user,
 		aclLevel,
 	}, nil
@@ -149,6 +187,10 @@ func (f *sqlizerFactory) newComparisonPredicateSqlizer(p skydb.Predicate) (sq.Sq
 		return sqlizer, nil
 	}
 
+	if p.Operator == skydb.In && p.Children[1].(skydb.Expression).IsSubquery() {
+		return f.newInSubqueryPredicateSqlizer(p)
+	}
+
 	sqlizers := []expressionSqlizer{}
 	for _, child := range p.Children {
 		sqlizer, err := f.newExpressionPredicateSqlizer(child.(skydb.Expression))
@@ -193,7 +235,7 @@ func (f *sqlizerFactory) tryOptimizeDistancePredicate(p skydb.Predicate) (sq.Sql
 	}
 
 	return &distancePredicateSqlizer{
-		f.primaryTable,
+		f.alias,
 		distanceFunc.Field,
 		distanceFunc.Location,
 		distanceValue,
@@ -215,7 +257,7 @@ func (f *sqlizerFactory) newExpressionPredicateSqlizer(expr skydb.Expression) (e
 			}
 		}
 
-		sqlizer := newExpressionSqlizer(f.primaryTable, fieldType, expr)
+		sqlizer := newExpressionSqlizer(f.alias, fieldType, expr)
 		return sqlizer, nil
 	}
 
@@ -224,7 +266,7 @@ func (f *sqlizerFactory) newExpressionPredicateSqlizer(expr skydb.Expression) (e
 		if !ok {
 			panic(`expression value is not a function`)
 		}
-		return newExpressionSqlizer(f.primaryTable, skydb.FieldType{Type: funcInterface.DataType()}, expr), nil
+		return newExpressionSqlizer(f.alias, skydb.FieldType{Type: funcInterface.DataType()}, expr), nil
 	}
 
 	return expressionSqlizer{}, skyerr.NewError(skyerr.RecordQueryInvalid,
@@ -236,26 +278,9 @@ func (f *sqlizerFactory) newExpressionPredicateSqlizerForKeyPath(expr skydb.Expr
 		panic("expression is not a key path")
 	}
 
-	components := expr.KeyPathComponents()
-	keyPath := expr.Value.(string)
-	if len(components) > 2 {
-		return expressionSqlizer{}, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
-			`keypath "%s" with more than 2 components is not supported`, keyPath)
-	}
-
-	alias := f.primaryTable
-	fields, err := skydb.TraverseColumnTypes(f.db, f.primaryTable, keyPath)
+	alias, field, err := f.joinKeyPath(expr)
 	if err != nil {
-		return expressionSqlizer{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
-	}
-
-	field := skydb.FieldType{}
-	for i, keyPathField := range fields {
-		isLast := (i == len(components)-1)
-		field = keyPathField
-		if field.Type == skydb.TypeReference && !isLast {
-			alias = f.createLeftJoin(field.ReferenceType, components[i], "_id")
-		}
+		return expressionSqlizer{}, err
 	}
 	return newExpressionSqlizer(alias, field, expr), nil
 }
@@ -265,17 +290,32 @@ func (f *sqlizerFactory) newExpressionSortForKeyPath(expr skydb.Expression) (str
 		panic("expression is not a key path")
 	}
 
+	alias, _, err := f.joinKeyPath(expr)
+	if err != nil {
+		return "", err
+	}
+
 	components := expr.KeyPathComponents()
-	keyPath := expr.Value.(string)
-	if len(components) > 2 {
-		return "", skyerr.NewErrorf(skyerr.RecordQueryInvalid,
-			`keypath "%s" with more than 2 components is not supported`, keyPath)
+	lastComponent := components[len(components)-1]
+	sortKey := fullQuoteIdentifier(alias, lastComponent)
+	if alias != f.alias {
+		sortKey = "_sort" + lastComponent + alias
+		f.addExtraColumn(sortKey, skydb.TypeReference, expr, alias)
 	}
+	return sortKey, nil
+}
 
-	alias := f.primaryTable
+// joinKeyPath joins the tables of the records referenced by each
+// component of the keypath except the last one, and returns the alias
+// of the table containing the last component and its field type.
+func (f *sqlizerFactory) joinKeyPath(expr skydb.Expression) (string, skydb.FieldType, error) {
+	components := expr.KeyPathComponents()
+	keyPath := expr.Value.(string)
+
+	alias := f.alias
 	fields, err := skydb.TraverseColumnTypes(f.db, f.primaryTable, keyPath)
 	if err != nil {
-		return "", skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+		return "", skydb.FieldType{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
 	}
 
 	field := skydb.FieldType{}
@@ -283,22 +323,28 @@ func (f *sqlizerFactory) newExpressionSortForKeyPath(expr skydb.Expression) (str
 		isLast := (i == len(components)-1)
 		field = keyPathField
 		if field.Type == skydb.TypeReference && !isLast {
-			alias = f.createLeftJoin(field.ReferenceType, components[i], "_id")
+			alias = f.createReferenceJoin(alias, field.ReferenceType, components[i])
 		}
 	}
-	lastComponent := components[len(components)-1]
-	sortKey := fullQuoteIdentifier(alias, lastComponent)
-	if alias != f.primaryTable {
-		sortKey = "_sort" + lastComponent + alias
-		f.addExtraColumn(sortKey, skydb.TypeReference, expr, alias)
-	}
-	return sortKey, nil
+	return alias, field, nil
 }
 
 // createLeftJoin create an alias of a table to be joined to the primary table
 // and return the alias for the joined table
 func (f *sqlizerFactory) createLeftJoin(secondaryTable string, primaryColumn string, secondaryColumn string) string {
-	newAlias := joinedTable{secondaryTable, primaryColumn, secondaryColumn}
+	return f.addJoinedTable(joinedTable{f.alias, secondaryTable, primaryColumn, secondaryColumn, false})
+}
+
+// createReferenceJoin joins the table of the record type referenced by
+// the column of the table of sourceAlias, and return the alias for the
+// joined table. Records of the joined table are filtered in the same way
+// as records in a subquery.
+func (f *sqlizerFactory) createReferenceJoin(sourceAlias string, recordType string, column string) string {
+	return f.addJoinedTable(joinedTable{sourceAlias, recordType, column, "_id", true})
+}
+
+func (f *sqlizerFactory) addJoinedTable(newAlias joinedTable) string {
+	secondaryTable := newAlias.secondaryTable
 	for i, alias := range f.joinedTables {
 		if alias.equal(newAlias) {
 			return f.aliasName(secondaryTable, i)
@@ -315,7 +361,7 @@ func (f *sqlizerFactory) aliasName(secondaryTable string, indexInJoinedTables in
 	if secondaryTable == "_auth" {
 		return "_auth"
 	}
-	return fmt.Sprintf("_t%d", indexInJoinedTables)
+	return fmt.Sprintf("%s_t%d", f.aliasPrefix, indexInJoinedTables)
 }
 
 // AddJoinsToSelectBuilder adds join clauses to a SelectBuilder
@@ -324,9 +370,23 @@ func (f *sqlizerFactory) AddJoinsToSelectBuilder(q sq.SelectBuilder) sq.SelectBu
 		aliasName := f.aliasName(alias.secondaryTable, i)
 		joinClause := fmt.Sprintf("%s AS %s ON %s = %s",
 			f.db.TableName(alias.secondaryTable), pq.QuoteIdentifier(aliasName),
-			fullQuoteIdentifier(f.primaryTable, alias.primaryColumn),
+			fullQuoteIdentifier(alias.sourceAlias, alias.primaryColumn),
 			fullQuoteIdentifier(aliasName, alias.secondaryColumn))
-		q = q.LeftJoin(joinClause)
+
+		if !alias.record {
+			q = q.LeftJoin(joinClause)
+			continue
+		}
+
+		// A referenced record not matching the conditions is joined
+		// as NULL, as if the reference does not exist.
+		joinArgs := []interface{}{}
+		for _, cond := range f.recordConditions(alias.secondaryTable, aliasName) {
+			condSQL, condArgs, _ := cond.ToSql()
+			joinClause += " AND " + condSQL
+			joinArgs = append(joinArgs, condArgs...)
+		}
+		q = q.LeftJoin(joinClause, joinArgs...)
 	}
 
 	if len(f.joinedTables) > 0 {
@@ -355,14 +415,19 @@ func (f *sqlizerFactory) UpdateTypemap(typemap skydb.RecordSchema) skydb.RecordS
 
 // joinedTable represents a specification for table join
 type joinedTable struct {
+	sourceAlias     string
 	secondaryTable  string
 	primaryColumn   string
 	secondaryColumn string
+
+	// record is true if the secondary table is the table of a record
+	// type joined by a reference.
+	record bool
 }
 
 // equal compares whether two specifications of table join are equal
 func (a joinedTable) equal(b joinedTable) bool {
-	return a.secondaryTable == b.secondaryTable && a.primaryColumn == b.primaryColumn && a.secondaryColumn == b.secondaryColumn
+	return a == b
 }
 
 func (f *sqlizerFactory) NewSort(s skydb.Sort) (string, error) {
@@ -376,7 +441,7 @@ func (f *sqlizerFactory) NewSort(s skydb.Sort) (string, error) {
 		}
 	case skydb.Function:
 		var err error
-		expr, err = funcOrderBySQL(f.primaryTable, s.Expression.Value.(skydb.Func))
+		expr, err = funcOrderBySQL(f.alias, s.Expression.Value.(skydb.Func))
 		if err != nil {
 			return "", err
 		}
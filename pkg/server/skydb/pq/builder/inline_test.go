@@ -0,0 +1,66 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInlineArgs(t *testing.T) {
+	Convey("InlineArgs", t, func() {
+		Convey("replaces placeholders with literals", func() {
+			sql, err := InlineArgs(
+				`"note"."title" = ? AND "note"."count" > ? AND "note"."done" = ?`,
+				[]interface{}{"it's", int64(1), false},
+			)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."title" = 'it''s' AND "note"."count" > 1 AND "note"."done" = FALSE`)
+		})
+
+		Convey("ignores question marks in quoted identifiers and literals", func() {
+			sql, err := InlineArgs(
+				`"note"."why?" = ? AND "note"."title" <> 'what?'`,
+				[]interface{}{1.5},
+			)
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"note"."why?" = 1.5 AND "note"."title" <> 'what?'`)
+		})
+
+		Convey("quotes backslashes and dates", func() {
+			sql, err := InlineArgs(`? ?`, []interface{}{
+				`C:\`,
+				time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+			})
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, ` E'C:\\' '2017-01-02T03:04:05Z'`)
+		})
+
+		Convey("errors on mismatched args", func() {
+			_, err := InlineArgs(`? = ?`, []interface{}{1})
+			So(err, ShouldNotBeNil)
+
+			_, err = InlineArgs(`?`, []interface{}{1, 2})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("errors on unsupported value", func() {
+			_, err := InlineArgs(`?`, []interface{}{struct{}{}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	sq "github.com/lann/squirrel"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/mock_skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+func TestJoinsAndSubqueries(t *testing.T) {
+	Convey("SqlizerFactory with access control", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		db := mock_skydb.NewMockDatabase(ctrl)
+		schemas := map[string]skydb.RecordSchema{
+			"order": {
+				"customer": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "customer"},
+				"total":    skydb.FieldType{Type: skydb.TypeNumber},
+			},
+			"customer": {
+				"_id":     skydb.FieldType{Type: skydb.TypeString},
+				"country": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "country"},
+				"vip":     skydb.FieldType{Type: skydb.TypeBoolean},
+			},
+			"country": {
+				"code": skydb.FieldType{Type: skydb.TypeString},
+			},
+			"comment": {
+				"post":        skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "order"},
+				"_owner_id":   skydb.FieldType{Type: skydb.TypeString},
+				"_deleted_at": skydb.FieldType{Type: skydb.TypeDateTime},
+			},
+		}
+		db.EXPECT().RemoteColumnTypes(gomock.Any()).
+			DoAndReturn(func(recordType string) (skydb.RecordSchema, error) {
+				return schemas[recordType], nil
+			}).AnyTimes()
+		db.EXPECT().TableName(gomock.Any()).
+			DoAndReturn(func(table string) string {
+				return `"app"."` + table + `"`
+			}).AnyTimes()
+		db.EXPECT().DatabaseType().Return(skydb.PublicDatabase).AnyTimes()
+
+		databaseID := ""
+		f := NewQuerySqlizerFactory(db, "order", &databaseID, &skydb.AccessControlOptions{
+			ViewAsUser: &skydb.AuthInfo{ID: "user0"},
+		})
+		aclSQL := func(alias string) string {
+			return `("` + alias + `"."_access" @> '[{"user_id": "user0"}]' OR ` +
+				`"` + alias + `"."_owner_id" = ? OR ` +
+				`"` + alias + `"."_access" @> '[{"public": true}]' OR ` +
+				`"` + alias + `"."_access" IS NULL)`
+		}
+
+		Convey("joins each reference of a nested keypath with access control", func() {
+			sqlizer, err := f.NewPredicateSqlizer(skydb.Predicate{
+				Operator: skydb.Equal,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "customer.country.code"},
+					skydb.Expression{Type: skydb.Literal, Value: "HK"},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			sql, args, err := f.AddJoinsToSelectBuilder(sq.Select("1").From(`"app"."order" AS "order"`)).
+				Where(sqlizer).
+				ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `SELECT DISTINCT 1 FROM "app"."order" AS "order"`+
+				` LEFT JOIN "app"."customer" AS "_t0" ON "order"."customer" = "_t0"."_id"`+
+				` AND "_t0"."_database_id" = ? AND `+aclSQL("_t0")+
+				` LEFT JOIN "app"."country" AS "_t1" ON "_t0"."country" = "_t1"."_id"`+
+				` AND "_t1"."_database_id" = ? AND `+aclSQL("_t1")+
+				` WHERE "_t1"."code"=?`)
+			So(args, ShouldResemble, []interface{}{"", "user0", "", "user0", "HK"})
+		})
+
+		Convey("generates exists subquery on referencing records", func() {
+			sqlizer, err := f.NewPredicateSqlizer(skydb.Predicate{
+				Operator: skydb.Exists,
+				Children: []interface{}{
+					skydb.Expression{
+						Type: skydb.Subquery,
+						Value: skydb.RecordSubquery{
+							Type:    "comment",
+							KeyPath: "post",
+							Predicate: skydb.Predicate{
+								Operator: skydb.Equal,
+								Children: []interface{}{
+									skydb.Expression{Type: skydb.KeyPath, Value: "_owner_id"},
+									skydb.Expression{Type: skydb.Literal, Value: "user0"},
+								},
+							},
+						},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM "app"."comment" AS "_s0"`+
+				` WHERE "_s0"."post" = "order"."_id"`+
+				` AND "_s0"."_owner_id"=?`+
+				` AND "_s0"."_deleted_at" IS NULL`+
+				` AND "_s0"."_database_id" = ?`+
+				` AND `+aclSQL("_s0")+`)`)
+			So(args, ShouldResemble, []interface{}{"user0", "", "user0"})
+		})
+
+		Convey("generates in subquery with joins inside the subquery", func() {
+			sqlizer, err := f.NewPredicateSqlizer(skydb.Predicate{
+				Operator: skydb.In,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "customer"},
+					skydb.Expression{
+						Type: skydb.Subquery,
+						Value: skydb.RecordSubquery{
+							Type:    "customer",
+							KeyPath: "_id",
+							Predicate: skydb.Predicate{
+								Operator: skydb.Equal,
+								Children: []interface{}{
+									skydb.Expression{Type: skydb.KeyPath, Value: "country.code"},
+									skydb.Expression{Type: skydb.Literal, Value: "HK"},
+								},
+							},
+						},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `"order"."customer" IN (SELECT DISTINCT "_s0"."_id" FROM "app"."customer" AS "_s0"`+
+				` LEFT JOIN "app"."country" AS "_s0_t0" ON "_s0"."country" = "_s0_t0"."_id"`+
+				` AND "_s0_t0"."_database_id" = ? AND `+aclSQL("_s0_t0")+
+				` WHERE "_s0_t0"."code"=?`+
+				` AND "_s0"."_database_id" = ?`+
+				` AND `+aclSQL("_s0")+`)`)
+			So(args, ShouldResemble, []interface{}{"", "user0", "HK", "", "user0"})
+		})
+
+		Convey("rejects exists subquery on a keypath not referencing the queried type", func() {
+			_, err := f.NewPredicateSqlizer(skydb.Predicate{
+				Operator: skydb.Exists,
+				Children: []interface{}{
+					skydb.Expression{
+						Type:  skydb.Subquery,
+						Value: skydb.RecordSubquery{Type: "customer", KeyPath: "country"},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.RecordQueryInvalid)
+		})
+	})
+}
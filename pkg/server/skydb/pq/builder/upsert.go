@@ -29,7 +29,7 @@ const upsertTemplateText = `
 WITH updated AS (
 	{{if .UpdateCols }}
 		UPDATE {{.Table}}
-		SET ({{template "commaSeparatedList" .UpdateCols}}) = ({{placeholderList (len .Keys) (len .UpdateCols) .WrappersAtIndex}})
+		SET ({{template "commaSeparatedList" .UpdateCols}}) = ({{placeholderList (len .Keys) (len .UpdateCols) .UpdateWrappersAtIndex}})
 		WHERE {{range $i, $_ := .Keys}}{{if $i}} AND {{end}}{{quoted .}} = ${{addOne $i}}{{end}}
 		RETURNING *
 	{{else}}
@@ -126,6 +126,7 @@ type UpsertQueryBuilder struct {
 	data           map[string]interface{}
 	updateIngnores map[string]struct{}
 	wrappers       map[string]func(string) string
+	updateWrappers map[string]func(string) string
 	selectColumns  map[string]sq.Sqlizer
 }
 
@@ -148,6 +149,7 @@ func UpsertQuery(table string, pkData, data map[string]interface{}) *UpsertQuery
 		data,
 		map[string]struct{}{},
 		map[string]func(string) string{},
+		map[string]func(string) string{},
 		map[string]sq.Sqlizer{},
 	}
 }
@@ -159,6 +161,7 @@ func UpsertQueryWithWrappers(table string, pkData, data map[string]interface{},
 		data,
 		map[string]struct{}{},
 		wrappers,
+		map[string]func(string) string{},
 		map[string]sq.Sqlizer{},
 	}
 }
@@ -168,6 +171,15 @@ func (upsert *UpsertQueryBuilder) IgnoreKeyOnUpdate(col string) *UpsertQueryBuil
 	return upsert
 }
 
+// WrapOnUpdate wraps the placeholder of the column with wrapper when the
+// row is updated, so that the new value can be an expression of the
+// current value of the column. The wrapper of the column specified in
+// UpsertQueryWithWrappers is still used when the row is inserted.
+func (upsert *UpsertQueryBuilder) WrapOnUpdate(col string, wrapper func(string) string) *UpsertQueryBuilder {
+	upsert.updateWrappers[col] = wrapper
+	return upsert
+}
+
 func (upsert *UpsertQueryBuilder) SelectColumn(col string, sqlizer sq.Sqlizer) *UpsertQueryBuilder {
 	upsert.selectColumns[col] = sqlizer
 	return upsert
@@ -185,27 +197,34 @@ func (upsert *UpsertQueryBuilder) ToSql() (sql string, args []interface{}, err e
 
 	insertCols := append(pks, cols...)
 	wrappers := map[int]func(string) string{}
+	updateWrappers := map[int]func(string) string{}
 
 	for i, col := range insertCols {
 		if wrapper, ok := upsert.wrappers[col]; ok {
 			wrappers[i+1] = wrapper
+			updateWrappers[i+1] = wrapper
+		}
+		if wrapper, ok := upsert.updateWrappers[col]; ok {
+			updateWrappers[i+1] = wrapper
 		}
 	}
 
 	err = upsertTemplate.Execute(&b, struct {
-		Table            string
-		Keys             []string
-		UpdateCols       []string
-		InsertCols       []string
-		WrappersAtIndex  map[int]func(string) string
-		SelectColumnsSQL string
+		Table                 string
+		Keys                  []string
+		UpdateCols            []string
+		InsertCols            []string
+		WrappersAtIndex       map[int]func(string) string
+		UpdateWrappersAtIndex map[int]func(string) string
+		SelectColumnsSQL      string
 	}{
-		Table:            upsert.table,
-		Keys:             pks,
-		UpdateCols:       updateCols,
-		InsertCols:       insertCols,
-		WrappersAtIndex:  wrappers,
-		SelectColumnsSQL: upsertSelectClause(upsert.selectColumns),
+		Table:                 upsert.table,
+		Keys:                  pks,
+		UpdateCols:            updateCols,
+		InsertCols:            insertCols,
+		WrappersAtIndex:       wrappers,
+		UpdateWrappersAtIndex: updateWrappers,
+		SelectColumnsSQL:      upsertSelectClause(upsert.selectColumns),
 	})
 	if err != nil {
 		panic(err)
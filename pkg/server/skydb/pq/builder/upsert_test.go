@@ -0,0 +1,64 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpsertQuery(t *testing.T) {
+	Convey("UpsertQuery", t, func() {
+		pkData := map[string]interface{}{"_id": "1"}
+
+		Convey("uses the same placeholders on insert and update", func() {
+			sql, args, err := UpsertQuery(`"note"`, pkData, map[string]interface{}{
+				"count": 1,
+			}).ToSql()
+			So(err, ShouldBeNil)
+			So(args, ShouldResemble, []interface{}{"1", 1})
+			So(sql, ShouldContainSubstring, `SET ("count") = ($2)`)
+			So(sql, ShouldContainSubstring, `SELECT $1,$2`)
+		})
+
+		Convey("wraps placeholders on insert and update", func() {
+			sql, _, err := UpsertQueryWithWrappers(`"note"`, pkData, map[string]interface{}{
+				"count": 1,
+			}, map[string]func(string) string{
+				"count": func(val string) string {
+					return fmt.Sprintf("abs(%s)", val)
+				},
+			}).ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `SET ("count") = (abs($2))`)
+			So(sql, ShouldContainSubstring, `SELECT $1,abs($2)`)
+		})
+
+		Convey("wraps placeholders on update only", func() {
+			sql, _, err := UpsertQuery(`"note"`, pkData, map[string]interface{}{
+				"count": 1,
+			}).WrapOnUpdate("count", func(val string) string {
+				return fmt.Sprintf(`"count" + %s`, val)
+			}).ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `SET ("count") = ("count" + $2)`)
+			So(sql, ShouldContainSubstring, `SELECT $1,$2`)
+			So(strings.Count(sql, `"count" + `), ShouldEqual, 1)
+		})
+	})
+}
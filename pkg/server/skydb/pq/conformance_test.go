@@ -0,0 +1,42 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skydbtest"
+)
+
+func TestConformance(t *testing.T) {
+	// getTestConn fails the test if the database is unreachable, so
+	// probe the database with the same connection defaults first.
+	setTestConnDefaults()
+	db, err := sqlx.Connect("postgres", "")
+	if err != nil {
+		t.Skipf("skipping conformance tests without database: %v", err)
+	}
+	db.Close()
+
+	skydbtest.RunConformanceTests(t, func(t *testing.T) (skydb.Conn, func()) {
+		c := getTestConn(t)
+		return c, func() {
+			cleanupConn(t, c)
+		}
+	})
+}
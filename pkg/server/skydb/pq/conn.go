@@ -88,17 +88,55 @@ type conn struct {
 	canMigrate             bool
 	passwordHistoryEnabled bool
 	context                context.Context
+
+	// replicas are the read replicas of the database, nil if there is
+	// no replica. Reads are routed to a replica when readRouting is
+	// non-zero, unless the conn has written to the primary.
+	replicas    *replicaSet
+	readRouting int
+	wrote       bool
 }
 
 // Db returns the current database wrapper, or a transaction wrapper when
 // a transaction is in effect.
+//
+// Within RouteReadsToReplica, a replica database wrapper is returned if a
+// replica is available and the conn has not written to the primary.
 func (c *conn) Db() ExtContext {
 	if c.tx != nil {
 		return c.tx
 	}
+	if c.readsReplica() {
+		if replica := c.replicas.pick(); replica != nil {
+			return replica
+		}
+	}
 	return c.db
 }
 
+// readsReplica returns whether reads of the conn may be served by a
+// replica, which may lag behind the primary.
+func (c *conn) readsReplica() bool {
+	return c.tx == nil && c.readRouting > 0 && !c.wrote
+}
+
+// RouteReadsToReplica routes the statements of the conn to a replica
+// until the returned function is called. It is for read-only operations
+// only.
+func (c *conn) RouteReadsToReplica() func() {
+	c.readRouting++
+	return func() {
+		c.readRouting--
+	}
+}
+
+// beginx begins a transaction on the primary that is not managed by
+// Begin. Reads of the conn stick to the primary afterwards.
+func (c *conn) beginx() (*sqlx.Tx, error) {
+	c.wrote = true
+	return c.db.Beginx()
+}
+
 // Begin begins a transaction.
 func (c *conn) Begin() error {
 	logger := logging.CreateLogger(c.context, "skydb")
@@ -221,8 +259,9 @@ func (db *database) TableName(table string) string {
 
 // this ensures that our structure conform to certain interfaces.
 var (
-	_ skydb.Conn     = &conn{}
-	_ skydb.Database = &database{}
+	_ skydb.Conn        = &conn{}
+	_ skydb.ReplicaConn = &conn{}
+	_ skydb.Database    = &database{}
 
 	_ driver.Valuer = providerInfoValue{}
 )
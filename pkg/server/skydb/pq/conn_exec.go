@@ -24,9 +24,18 @@ import (
 	"github.com/skygeario/skygear-server/pkg/server/logging"
 )
 
+// markWrite makes reads of the conn stick to the primary if the
+// statement writes to the database.
+func (c *conn) markWrite(query string) {
+	if !isReadStatement(query) {
+		c.wrote = true
+	}
+}
+
 func (c *conn) Get(dest interface{}, query string, args ...interface{}) (err error) {
 	logger := logging.CreateLogger(c.context, "skydb").WithField("tag", "sql")
 	c.statementCount++
+	c.markWrite(query)
 	err = c.Db().GetContext(c.context, dest, query, args...)
 	logFields := logrus.Fields{
 		"sql":            logging.StringValueFormatter(query),
@@ -52,6 +61,7 @@ func (c *conn) GetWith(dest interface{}, sqlizeri sq.Sqlizer) (err error) {
 func (c *conn) Exec(query string, args ...interface{}) (result sql.Result, err error) {
 	logger := logging.CreateLogger(c.context, "skydb").WithField("tag", "sql")
 	c.statementCount++
+	c.markWrite(query)
 	result, err = c.Db().ExecContext(c.context, query, args...)
 
 	var rowsAffected int64
@@ -90,6 +100,7 @@ func (c *conn) ExecWith(sqlizeri sq.Sqlizer) (sql.Result, error) {
 func (c *conn) Queryx(query string, args ...interface{}) (rows *sqlx.Rows, err error) {
 	logger := logging.CreateLogger(c.context, "skydb").WithField("tag", "sql")
 	c.statementCount++
+	c.markWrite(query)
 	rows, err = c.Db().QueryxContext(c.context, query, args...)
 	logFields := logrus.Fields{
 		"sql":            logging.StringValueFormatter(query),
@@ -115,6 +126,7 @@ func (c *conn) QueryWith(sqlizeri sq.Sqlizer) (*sqlx.Rows, error) {
 func (c *conn) QueryRowx(query string, args ...interface{}) (row *sqlx.Row) {
 	logger := logging.CreateLogger(c.context, "skydb").WithField("tag", "sql")
 	c.statementCount++
+	c.markWrite(query)
 	row = c.Db().QueryRowxContext(c.context, query, args...)
 	logger.WithFields(logrus.Fields{
 		"sql":            logging.StringValueFormatter(query),
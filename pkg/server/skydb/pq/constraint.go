@@ -0,0 +1,318 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// getFieldConstraints returns the constraints of fields of a record type
+// stored in _record_field_constraint.
+func (db *database) getFieldConstraints(recordType string) (map[string]*skydb.FieldConstraints, error) {
+	builder := psql.Select("record_field", "constraints").
+		From(db.TableName("_record_field_constraint")).
+		Where(sq.Eq{"record_type": recordType})
+
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[string]*skydb.FieldConstraints{}
+	for rows.Next() {
+		var field string
+		var data []byte
+		if err := rows.Scan(&field, &data); err != nil {
+			return nil, err
+		}
+
+		constraints := skydb.FieldConstraints{}
+		if err := json.Unmarshal(data, &constraints); err != nil {
+			return nil, err
+		}
+		result[field] = &constraints
+	}
+	return result, rows.Err()
+}
+
+// setFieldConstraints replaces the constraints of a field with the
+// constraints of the specified FieldType, both the PostgreSQL constraints
+// of the column and the definition stored in _record_field_constraint.
+//
+// Required is mirrored as NOT NULL, default as DEFAULT, unique as UNIQUE
+// constraint, and pattern, min, max and enum as a CHECK constraint. The
+// foreign key of a reference field is recreated with the ON DELETE action
+// of on_delete.
+func (db *database) setFieldConstraints(tx *sqlx.Tx, recordType, field string, fieldType skydb.FieldType) error {
+	tableName := db.TableName(recordType)
+	column := pq.QuoteIdentifier(field)
+	uniqueName := pq.QuoteIdentifier(uniqueConstraintName(recordType, field))
+	checkName := pq.QuoteIdentifier(checkConstraintName(recordType, field))
+
+	stmt := fmt.Sprintf(
+		"ALTER TABLE %[1]s ALTER COLUMN %[2]s DROP NOT NULL, ALTER COLUMN %[2]s DROP DEFAULT, "+
+			"DROP CONSTRAINT IF EXISTS %[3]s, DROP CONSTRAINT IF EXISTS %[4]s",
+		tableName, column, uniqueName, checkName,
+	)
+	if _, err := tx.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to drop constraints of %s.%s: %s", recordType, field, err)
+	}
+
+	constraints := skydb.FieldConstraints{}
+	if fieldType.Constraints != nil {
+		constraints = *fieldType.Constraints
+	}
+
+	alterations := []string{}
+	if constraints.Default != nil {
+		literal, err := builder.QuoteLiteral(constraints.Default)
+		if err != nil {
+			return err
+		}
+
+		if constraints.Required {
+			// Existing records without the field take the default value,
+			// otherwise NOT NULL cannot be set.
+			stmt := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %[2]s IS NULL", tableName, column, literal)
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to set default of %s.%s: %s", recordType, field, err)
+			}
+		}
+		alterations = append(alterations, fmt.Sprintf("ALTER COLUMN %s SET DEFAULT %s", column, literal))
+	}
+	if constraints.Required {
+		alterations = append(alterations, fmt.Sprintf("ALTER COLUMN %s SET NOT NULL", column))
+	}
+	if constraints.Unique {
+		alterations = append(alterations, fmt.Sprintf("ADD CONSTRAINT %s UNIQUE (%s)", uniqueName, column))
+	}
+
+	if fieldType.Type == skydb.TypeReference {
+		// Referencing records are deleted or updated by the server
+		// before the referenced record is deleted, the foreign key
+		// only applies to soft-deleted records which are not visible
+		// to the server.
+		fkName := pq.QuoteIdentifier(foreignKeyConstraintName(field, fieldType.ReferenceType, "_id"))
+		alterations = append(alterations, fmt.Sprintf(
+			"DROP CONSTRAINT IF EXISTS %[1]s, ADD CONSTRAINT %[1]s FOREIGN KEY (%[2]s) REFERENCES %[3]s (_id) ON DELETE %[4]s",
+			fkName, column, db.TableName(fieldType.ReferenceType), referenceActionSQL(constraints.OnDelete),
+		))
+	}
+
+	check, err := checkConstraintExpr(column, constraints)
+	if err != nil {
+		return err
+	}
+	if check != "" {
+		alterations = append(alterations, fmt.Sprintf("ADD CONSTRAINT %s CHECK (%s)", checkName, check))
+	}
+
+	if len(alterations) > 0 {
+		stmt := fmt.Sprintf("ALTER TABLE %s %s", tableName, strings.Join(alterations, ", "))
+		if _, err := tx.Exec(stmt); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Class() == "23" {
+				return skyerr.NewErrorWithInfo(
+					skyerr.ConstraintViolated,
+					fmt.Sprintf("existing records of %s violate constraints of field %s", recordType, field),
+					map[string]interface{}{"field": field},
+				)
+			}
+			return fmt.Errorf("failed to add constraints of %s.%s: %s", recordType, field, err)
+		}
+	}
+
+	if _, err := tx.Exec(
+		fmt.Sprintf("DELETE FROM %s WHERE record_type = $1 AND record_field = $2", db.TableName("_record_field_constraint")),
+		recordType, field,
+	); err != nil {
+		return err
+	}
+
+	if constraints.IsEmpty() {
+		return nil
+	}
+
+	data, err := json.Marshal(constraints)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		fmt.Sprintf("INSERT INTO %s (record_type, record_field, constraints) VALUES ($1, $2, $3)", db.TableName("_record_field_constraint")),
+		recordType, field, data,
+	)
+	return err
+}
+
+// renameFieldConstraints renames the constraints of a renamed field.
+func (db *database) renameFieldConstraints(recordType, oldName, newName string) error {
+	names := [][2]string{
+		{uniqueConstraintName(recordType, oldName), uniqueConstraintName(recordType, newName)},
+		{checkConstraintName(recordType, oldName), checkConstraintName(recordType, newName)},
+	}
+	for _, name := range names {
+		var exists bool
+		if err := db.c.QueryRowx(
+			"SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_constraint WHERE conname = $1 AND conrelid = $2::regclass)",
+			name[0], db.TableName(recordType),
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		stmt := fmt.Sprintf("ALTER TABLE %s RENAME CONSTRAINT %s TO %s",
+			db.TableName(recordType), pq.QuoteIdentifier(name[0]), pq.QuoteIdentifier(name[1]))
+		if _, err := db.c.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to rename constraint: %s", err)
+		}
+	}
+
+	builder := psql.Update(db.TableName("_record_field_constraint")).
+		Set("record_field", newName).
+		Where(sq.Eq{"record_type": recordType, "record_field": oldName})
+	_, err := db.c.ExecWith(builder)
+	return err
+}
+
+// deleteFieldConstraints deletes the definition of constraints of a
+// deleted field. The PostgreSQL constraints are dropped with the column.
+func (db *database) deleteFieldConstraints(recordType, field string) error {
+	builder := psql.Delete(db.TableName("_record_field_constraint")).
+		Where(sq.Eq{"record_type": recordType, "record_field": field})
+	_, err := db.c.ExecWith(builder)
+	return err
+}
+
+func checkConstraintExpr(column string, constraints skydb.FieldConstraints) (string, error) {
+	exprs := []string{}
+	if constraints.Pattern != "" {
+		literal, _ := builder.QuoteLiteral(constraints.Pattern)
+		exprs = append(exprs, fmt.Sprintf("%s ~ %s", column, literal))
+	}
+	if constraints.Min != nil {
+		literal, _ := builder.QuoteLiteral(*constraints.Min)
+		exprs = append(exprs, fmt.Sprintf("%s >= %s", column, literal))
+	}
+	if constraints.Max != nil {
+		literal, _ := builder.QuoteLiteral(*constraints.Max)
+		exprs = append(exprs, fmt.Sprintf("%s <= %s", column, literal))
+	}
+	if len(constraints.Enum) > 0 {
+		buf := bytes.Buffer{}
+		for i, value := range constraints.Enum {
+			literal, err := builder.QuoteLiteral(value)
+			if err != nil {
+				return "", err
+			}
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(literal)
+		}
+		exprs = append(exprs, fmt.Sprintf("%s IN (%s)", column, buf.String()))
+	}
+	return strings.Join(exprs, " AND "), nil
+}
+
+func referenceActionSQL(action skydb.ReferenceAction) string {
+	switch action {
+	case skydb.CascadeReference:
+		return "CASCADE"
+	case skydb.SetNullReference:
+		return "SET NULL"
+	default:
+		return "NO ACTION"
+	}
+}
+
+func uniqueConstraintName(recordType, field string) string {
+	return fmt.Sprintf("%s_%s_unique", recordType, field)
+}
+
+func checkConstraintName(recordType, field string) string {
+	return fmt.Sprintf("%s_%s_check", recordType, field)
+}
+
+// uniqueConstraintField returns the field of a unique constraint set by
+// setFieldConstraints, or an empty string if the constraint is not one.
+func uniqueConstraintField(recordType, constraintName string) string {
+	return constraintField(recordType, constraintName, "_unique")
+}
+
+// checkConstraintField returns the field of a check constraint set by
+// setFieldConstraints, or an empty string if the constraint is not one.
+func checkConstraintField(recordType, constraintName string) string {
+	return constraintField(recordType, constraintName, "_check")
+}
+
+func constraintField(recordType, constraintName, suffix string) string {
+	prefix := recordType + "_"
+	if !strings.HasPrefix(constraintName, prefix) || !strings.HasSuffix(constraintName, suffix) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(constraintName, prefix), suffix)
+}
+
+// violatedCheckConstraint returns the rule of the check constraint of a
+// field violated by the saved value. The value of a field operation is
+// known only to the database, so its rule is the bound the operation
+// moves the value towards.
+func violatedCheckConstraint(constraints *skydb.FieldConstraints, value interface{}) string {
+	op, ok := value.(skydb.FieldOperation)
+	if !ok {
+		if err, ok := constraints.Validate(value).(*skydb.ConstraintViolation); ok {
+			return err.Constraint
+		}
+		return ""
+	}
+
+	switch {
+	case op.Operator == skydb.MaxOperator && constraints.Max != nil:
+		return skydb.MaxConstraint
+	case op.Operator == skydb.IncrementOperator && constraints.Max != nil && !isNegative(op.Value):
+		return skydb.MaxConstraint
+	case op.Operator == skydb.IncrementOperator && constraints.Min != nil:
+		return skydb.MinConstraint
+	case len(constraints.Enum) > 0:
+		return skydb.EnumConstraint
+	}
+	return ""
+}
+
+func isNegative(number interface{}) bool {
+	switch number := number.(type) {
+	case float64:
+		return number < 0
+	case int64:
+		return number < 0
+	case int:
+		return number < 0
+	default:
+		return false
+	}
+}
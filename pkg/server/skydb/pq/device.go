@@ -25,18 +25,20 @@ import (
 )
 
 func (c *conn) GetDevice(id string, device *skydb.Device) error {
-	builder := psql.Select("type", "token", "auth_id", "topic", "last_registered_at").
+	builder := psql.Select("type", "token", "auth_id", "topic", "time_zone", "last_registered_at").
 		From(c.tableName("_device")).
 		Where("id = ?", id)
 
 	nullableToken := sql.NullString{}
 	nullableTopic := sql.NullString{}
 	nullableUserID := sql.NullString{}
+	nullableTimeZone := sql.NullString{}
 	err := c.QueryRowWith(builder).Scan(
 		&device.Type,
 		&nullableToken,
 		&nullableUserID,
 		&nullableTopic,
+		&nullableTimeZone,
 		&device.LastRegisteredAt,
 	)
 
@@ -48,6 +50,7 @@ func (c *conn) GetDevice(id string, device *skydb.Device) error {
 
 	device.Token = nullableToken.String
 	device.Topic = nullableTopic.String
+	device.TimeZone = nullableTimeZone.String
 	device.AuthInfoID = nullableUserID.String
 	device.LastRegisteredAt = device.LastRegisteredAt.In(time.UTC)
 	device.ID = id
@@ -56,7 +59,7 @@ func (c *conn) GetDevice(id string, device *skydb.Device) error {
 }
 
 func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
-	builder := psql.Select("id", "type", "token", "auth_id", "topic", "last_registered_at").
+	builder := psql.Select("id", "type", "token", "auth_id", "topic", "time_zone", "last_registered_at").
 		From(c.tableName("_device")).
 		Where("auth_id = ?", user)
 
@@ -69,6 +72,7 @@ func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
 	for rows.Next() {
 		nullableToken := sql.NullString{}
 		nullableTopic := sql.NullString{}
+		nullableTimeZone := sql.NullString{}
 		d := skydb.Device{}
 		if err := rows.Scan(
 			&d.ID,
@@ -76,12 +80,14 @@ func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
 			&nullableToken,
 			&d.AuthInfoID,
 			&nullableTopic,
+			&nullableTimeZone,
 			&d.LastRegisteredAt); err != nil {
 
 			panic(err)
 		}
 		d.Token = nullableToken.String
 		d.Topic = nullableTopic.String
+		d.TimeZone = nullableTimeZone.String
 		d.LastRegisteredAt = d.LastRegisteredAt.UTC()
 		results = append(results, d)
 	}
@@ -90,7 +96,7 @@ func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
 }
 
 func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, error) {
-	builder := psql.Select("id", "type", "token", "auth_id", "topic", "last_registered_at").
+	builder := psql.Select("id", "type", "token", "auth_id", "topic", "time_zone", "last_registered_at").
 		From(c.tableName("_device")).
 		Where("auth_id = ? AND topic = ?", user, topic)
 
@@ -102,6 +108,7 @@ func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, e
 	results := []skydb.Device{}
 	for rows.Next() {
 		var nullableToken sql.NullString
+		var nullableTimeZone sql.NullString
 		d := skydb.Device{}
 		if err := rows.Scan(
 			&d.ID,
@@ -109,11 +116,13 @@ func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, e
 			&nullableToken,
 			&d.AuthInfoID,
 			&d.Topic,
+			&nullableTimeZone,
 			&d.LastRegisteredAt); err != nil {
 
 			panic(err)
 		}
 		d.Token = nullableToken.String
+		d.TimeZone = nullableTimeZone.String
 		d.LastRegisteredAt = d.LastRegisteredAt.UTC()
 		results = append(results, d)
 	}
@@ -145,6 +154,10 @@ func (c *conn) SaveDevice(device *skydb.Device) error {
 		data["topic"] = device.Topic
 	}
 
+	if device.TimeZone != "" {
+		data["time_zone"] = device.TimeZone
+	}
+
 	upsert := builder.UpsertQuery(c.tableName("_device"), pkData, data)
 	_, err := c.ExecWith(upsert)
 	return err
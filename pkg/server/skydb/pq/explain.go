@@ -0,0 +1,56 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"encoding/json"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (db *database) ExplainQuery(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (skydb.QueryExplanation, error) {
+	q, typemap, err := db.selectQueryBuilder(query, accessControlOptions)
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	if typemap == nil { // record type has not been created
+		return skydb.QueryExplanation{}, nil
+	}
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	// ANALYZE executes the statement so that the plan carries the
+	// actual timing of each node.
+	var planJSON []byte
+	err = db.c.QueryRowx("EXPLAIN (ANALYZE, FORMAT JSON) "+sql, args...).Scan(&planJSON)
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	var plan interface{}
+	if err := json.Unmarshal(planJSON, &plan); err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	return skydb.QueryExplanation{
+		Statement: sql,
+		Args:      args,
+		Plan:      plan,
+	}, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+)
+
+// fieldOperationValue returns the argument of a field operation in the
+// upsert query.
+func fieldOperationValue(op skydb.FieldOperation) interface{} {
+	if items, ok := op.Value.([]interface{}); ok {
+		return jsonSliceValue(items)
+	}
+	return op.Value
+}
+
+// fieldOperationUpdateSQL returns the expression computing the new value
+// of column from its current value and the placeholder of the argument.
+func fieldOperationUpdateSQL(column string, op skydb.FieldOperation, placeholder string) string {
+	column = pq.QuoteIdentifier(column)
+	switch op.Operator {
+	case skydb.IncrementOperator:
+		return fmt.Sprintf(`COALESCE(%s, 0) + %s`, column, placeholder)
+	case skydb.MaxOperator:
+		return fmt.Sprintf(`GREATEST(%s, %s)`, column, placeholder)
+	case skydb.PushOperator:
+		return fmt.Sprintf(`COALESCE(%s, '[]'::jsonb) || %s::jsonb`, column, placeholder)
+	case skydb.PullOperator:
+		return fmt.Sprintf(
+			`COALESCE((SELECT jsonb_agg(_e.value ORDER BY _e.ordinality) FROM jsonb_array_elements(%s) WITH ORDINALITY AS _e WHERE _e.value NOT IN (SELECT jsonb_array_elements(%s::jsonb))), '[]'::jsonb)`,
+			column,
+			placeholder,
+		)
+	default:
+		panic(fmt.Sprintf("unknown field operator = %s", op.Operator))
+	}
+}
+
+// applyFieldOperations makes the upsert query compute the value of the
+// fields with field operations in the database, so that concurrent
+// saves to the same field do not overwrite each other. wrappers is the
+// map of wrappers used by the upsert query when the row is inserted.
+func applyFieldOperations(upsert *builder.UpsertQueryBuilder, typemap skydb.RecordSchema, wrappers map[string]func(string) string, record *skydb.Record) error {
+	for key, value := range record.Data {
+		op, ok := value.(skydb.FieldOperation)
+		if !ok {
+			continue
+		}
+
+		if err := op.CheckFieldType(key, typemap[key]); err != nil {
+			return err
+		}
+
+		column, fieldOp := key, op
+		upsert.WrapOnUpdate(column, func(placeholder string) string {
+			return fieldOperationUpdateSQL(column, fieldOp, placeholder)
+		})
+		if op.Operator == skydb.PullOperator {
+			// nothing to pull from a new record
+			wrappers[column] = func(string) string {
+				return `'[]'::jsonb`
+			}
+		}
+	}
+	return nil
+}
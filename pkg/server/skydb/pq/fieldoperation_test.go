@@ -0,0 +1,183 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/skygeario/skygear-server/pkg/server/skytest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordFieldOperation(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title":   skydb.FieldType{Type: skydb.TypeString},
+			"count":   skydb.FieldType{Type: skydb.TypeNumber},
+			"integer": skydb.FieldType{Type: skydb.TypeInteger},
+			"tags":    skydb.FieldType{Type: skydb.TypeJSON},
+		})
+		So(err, ShouldBeNil)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "1"),
+			OwnerID: "user_id",
+			Data: map[string]interface{}{
+				"title":   "Hello",
+				"count":   float64(1),
+				"integer": int64(1),
+				"tags":    []interface{}{"a", "b", "a"},
+			},
+		}
+		So(db.Save(&record), ShouldBeNil)
+
+		save := func(data map[string]interface{}) (skydb.Record, error) {
+			delta := skydb.Record{
+				ID:      skydb.NewRecordID("note", "1"),
+				OwnerID: "user_id",
+				Data:    data,
+			}
+			err := db.Save(&delta)
+			return delta, err
+		}
+
+		Convey("increments number in the database", func() {
+			saved, err := save(map[string]interface{}{
+				"count":   skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(2)},
+				"integer": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(3)},
+			})
+			So(err, ShouldBeNil)
+			So(saved.Data["count"], ShouldEqual, float64(3))
+			So(saved.Data["integer"], ShouldEqual, int64(4))
+			So(saved.Data["title"], ShouldEqual, "Hello")
+
+			var count float64
+			err = c.QueryRowx(`SELECT count FROM note WHERE _id = '1' and _database_id = ''`).
+				Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, float64(3))
+		})
+
+		Convey("takes the max number in the database", func() {
+			saved, err := save(map[string]interface{}{
+				"count": skydb.FieldOperation{Operator: skydb.MaxOperator, Value: float64(0)},
+			})
+			So(err, ShouldBeNil)
+			So(saved.Data["count"], ShouldEqual, float64(1))
+
+			saved, err = save(map[string]interface{}{
+				"count": skydb.FieldOperation{Operator: skydb.MaxOperator, Value: float64(5)},
+			})
+			So(err, ShouldBeNil)
+			So(saved.Data["count"], ShouldEqual, float64(5))
+		})
+
+		Convey("pushes and pulls items in the database", func() {
+			_, err := save(map[string]interface{}{
+				"tags": skydb.FieldOperation{Operator: skydb.PushOperator, Value: []interface{}{"c"}},
+			})
+			So(err, ShouldBeNil)
+
+			var jsonBytes []byte
+			err = c.QueryRowx(`SELECT tags FROM note WHERE _id = '1' and _database_id = ''`).
+				Scan(&jsonBytes)
+			So(err, ShouldBeNil)
+			So(jsonBytes, ShouldEqualJSON, `["a", "b", "a", "c"]`)
+
+			saved, err := save(map[string]interface{}{
+				"tags": skydb.FieldOperation{Operator: skydb.PullOperator, Value: []interface{}{"a", "d"}},
+			})
+			So(err, ShouldBeNil)
+			So(saved.Data["tags"], ShouldResemble, []interface{}{"b", "c"})
+		})
+
+		Convey("applies operations on new record", func() {
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", "2"),
+				OwnerID: "user_id",
+				Data: map[string]interface{}{
+					"count": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(2)},
+					"tags":  skydb.FieldOperation{Operator: skydb.PullOperator, Value: []interface{}{"a"}},
+				},
+			}
+			So(db.Save(&record), ShouldBeNil)
+			So(record.Data["count"], ShouldEqual, float64(2))
+			So(record.Data["tags"], ShouldResemble, []interface{}{})
+		})
+
+		Convey("rejects operation on field of wrong type", func() {
+			_, err := save(map[string]interface{}{
+				"title": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(1)},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.InvalidArgument)
+
+			_, err = save(map[string]interface{}{
+				"count": skydb.FieldOperation{Operator: skydb.PushOperator, Value: []interface{}{"a"}},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.InvalidArgument)
+			So(err.(skyerr.Error).Info(), ShouldResemble, map[string]interface{}{
+				"arguments": []string{"count"},
+				"field":     "count",
+				"operator":  "$push",
+				"type":      "number",
+			})
+		})
+
+		Convey("rejects increment past max in the database", func() {
+			max := float64(5)
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"score": skydb.FieldType{
+					Type:        skydb.TypeNumber,
+					Constraints: &skydb.FieldConstraints{Max: &max},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			_, err = save(map[string]interface{}{
+				"score": float64(1),
+			})
+			So(err, ShouldBeNil)
+
+			_, err = save(map[string]interface{}{
+				"score": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(3)},
+			})
+			So(err, ShouldBeNil)
+
+			_, err = save(map[string]interface{}{
+				"score": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: float64(2)},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ConstraintViolated)
+			So(err.(skyerr.Error).Info(), ShouldResemble, map[string]interface{}{
+				"field":      "score",
+				"constraint": skydb.MaxConstraint,
+			})
+
+			var score float64
+			err = c.QueryRowx(`SELECT score FROM note WHERE _id = '1' and _database_id = ''`).
+				Scan(&score)
+			So(err, ShouldBeNil)
+			So(score, ShouldEqual, float64(4))
+		})
+	})
+}
@@ -31,13 +31,29 @@ import (
 )
 
 var subscribeListenOnce sync.Once
-var appEventChannelsMap map[string][]chan skydb.RecordEvent
+var appSubscriptionsMutex sync.RWMutex
+var appSubscriptionsMap map[string]*appSubscription
+
+// appSubscription is the channels subscribed to the record events of an
+// app. The channels are closed when the app is unsubscribed, after the
+// events being sent to them are either received or dropped.
+type appSubscription struct {
+	channels []chan skydb.RecordEvent
+	closed   chan struct{}
+	sending  sync.WaitGroup
+}
 
 // Assume all app resist on one Database
 func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
 	appName := toLowerAndUnderscore(c.appName)
-	channels := appEventChannelsMap[appName]
-	appEventChannelsMap[appName] = append(channels, recordEventChan)
+	appSubscriptionsMutex.Lock()
+	subscription, ok := appSubscriptionsMap[appName]
+	if !ok {
+		subscription = &appSubscription{closed: make(chan struct{})}
+		appSubscriptionsMap[appName] = subscription
+	}
+	subscription.channels = append(subscription.channels, recordEventChan)
+	appSubscriptionsMutex.Unlock()
 
 	// TODO(limouren): Seems a start-up time config would be better?
 	subscribeListenOnce.Do(func() {
@@ -47,13 +63,44 @@ func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
 	return nil
 }
 
+// unsubscribeApp closes the channels subscribed to the record events of
+// the app, so that the subscribers stop receiving from them.
+func unsubscribeApp(appName string) {
+	appSubscriptionsMutex.Lock()
+	subscription, ok := appSubscriptionsMap[toLowerAndUnderscore(appName)]
+	delete(appSubscriptionsMap, toLowerAndUnderscore(appName))
+	appSubscriptionsMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	close(subscription.closed)
+	subscription.sending.Wait()
+	for _, channel := range subscription.channels {
+		close(channel)
+	}
+}
+
 func emit(n *notification) {
-	channels := appEventChannelsMap[n.AppName]
-	for _, channel := range channels {
+	appSubscriptionsMutex.RLock()
+	defer appSubscriptionsMutex.RUnlock()
+	subscription, ok := appSubscriptionsMap[n.AppName]
+	if !ok {
+		return
+	}
+
+	// the sends are added with the subscriptions locked, so that no send
+	// is added after the app is unsubscribed
+	for _, channel := range subscription.channels {
+		subscription.sending.Add(1)
 		go func(ch chan skydb.RecordEvent) {
-			ch <- skydb.RecordEvent{
+			defer subscription.sending.Done()
+			select {
+			case ch <- skydb.RecordEvent{
 				Record: &n.Record,
 				Event:  n.ChangeEvent,
+			}:
+			case <-subscription.closed:
 			}
 		}(channel)
 	}
@@ -257,5 +304,5 @@ func parseRecordData(data []byte, record *skydb.Record) error {
 }
 
 func init() {
-	appEventChannelsMap = map[string][]chan skydb.RecordEvent{}
+	appSubscriptionsMap = map[string]*appSubscription{}
 }
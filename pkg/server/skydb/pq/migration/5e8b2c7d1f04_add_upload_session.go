@@ -0,0 +1,50 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_5e8b2c7d1f04 struct {
+}
+
+func (r *revision_5e8b2c7d1f04) Version() string {
+	return "5e8b2c7d1f04"
+}
+
+func (r *revision_5e8b2c7d1f04) Up(tx *sqlx.Tx) error {
+	stmt := `
+	CREATE TABLE _upload_session (
+		id TEXT PRIMARY KEY,
+		asset_name TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		length BIGINT NOT NULL,
+		upload_offset BIGINT NOT NULL,
+		multipart_id TEXT,
+		parts JSONB,
+		created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+		updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+	);
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_5e8b2c7d1f04) Down(tx *sqlx.Tx) error {
+	stmt := `
+	DROP TABLE _upload_session;
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
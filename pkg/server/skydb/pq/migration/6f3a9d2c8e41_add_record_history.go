@@ -0,0 +1,58 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_6f3a9d2c8e41 struct {
+}
+
+func (r *revision_6f3a9d2c8e41) Version() string {
+	return "6f3a9d2c8e41"
+}
+
+func (r *revision_6f3a9d2c8e41) Up(tx *sqlx.Tx) error {
+	stmt := `
+	CREATE TABLE _record_history_type (
+		record_type text NOT NULL,
+		PRIMARY KEY (record_type)
+	);
+	CREATE TABLE _record_history (
+		id text NOT NULL,
+		record_type text NOT NULL,
+		record_id text NOT NULL,
+		database_id text NOT NULL,
+		version integer NOT NULL,
+		action text NOT NULL,
+		record jsonb,
+		diff jsonb,
+		actor_id text,
+		created_at timestamp without time zone NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE (record_type, record_id, database_id, version)
+	);
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_6f3a9d2c8e41) Down(tx *sqlx.Tx) error {
+	stmt := `
+	DROP TABLE _record_history;
+	DROP TABLE _record_history_type;
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
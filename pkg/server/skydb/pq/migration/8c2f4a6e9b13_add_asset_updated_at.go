@@ -0,0 +1,43 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_8c2f4a6e9b13 struct {
+}
+
+func (r *revision_8c2f4a6e9b13) Version() string {
+	return "8c2f4a6e9b13"
+}
+
+func (r *revision_8c2f4a6e9b13) Up(tx *sqlx.Tx) error {
+	// existing assets are regarded as saved at the time of migration
+	stmt := `
+	ALTER TABLE _asset ADD COLUMN updated_at TIMESTAMP WITHOUT TIME ZONE;
+	UPDATE _asset SET updated_at = (now() AT TIME ZONE 'UTC');
+	ALTER TABLE _asset ALTER COLUMN updated_at SET NOT NULL;
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_8c2f4a6e9b13) Down(tx *sqlx.Tx) error {
+	stmt := `
+	ALTER TABLE _asset DROP COLUMN updated_at;
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -0,0 +1,42 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_9d4e1b7c3a58 struct {
+}
+
+func (r *revision_9d4e1b7c3a58) Version() string {
+	return "9d4e1b7c3a58"
+}
+
+func (r *revision_9d4e1b7c3a58) Up(tx *sqlx.Tx) error {
+	stmt := `
+	ALTER TABLE _upload_session ADD COLUMN expires_at TIMESTAMP WITHOUT TIME ZONE;
+	UPDATE _upload_session SET expires_at = updated_at + INTERVAL '1 day';
+	CREATE INDEX _upload_session_expires_at_idx ON _upload_session (expires_at);
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_9d4e1b7c3a58) Down(tx *sqlx.Tx) error {
+	stmt := `
+	ALTER TABLE _upload_session DROP COLUMN expires_at;
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
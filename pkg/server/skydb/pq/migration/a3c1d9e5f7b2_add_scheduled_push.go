@@ -0,0 +1,56 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import "github.com/jmoiron/sqlx"
+
+type revision_a3c1d9e5f7b2 struct {
+}
+
+func (r *revision_a3c1d9e5f7b2) Version() string {
+	return "a3c1d9e5f7b2"
+}
+
+func (r *revision_a3c1d9e5f7b2) Up(tx *sqlx.Tx) error {
+	stmt := `
+	ALTER TABLE _device ADD COLUMN time_zone TEXT;
+	CREATE TABLE _scheduled_push (
+		id TEXT PRIMARY KEY,
+		target_type TEXT NOT NULL,
+		target_ids JSONB NOT NULL,
+		topic TEXT,
+		notification JSONB NOT NULL,
+		send_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+		local_time BOOLEAN NOT NULL DEFAULT FALSE,
+		time_zone TEXT,
+		due_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+		status TEXT NOT NULL,
+		sent_device_ids JSONB,
+		created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+	);
+	CREATE INDEX ON _scheduled_push (status, due_at);
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *revision_a3c1d9e5f7b2) Down(tx *sqlx.Tx) error {
+	stmt := `
+	DROP TABLE _scheduled_push;
+	ALTER TABLE _device DROP COLUMN time_zone;
+	`
+	_, err := tx.Exec(stmt)
+	return err
+}
@@ -23,7 +23,7 @@ import (
 type fullMigration struct {
 }
 
-func (r *fullMigration) Version() string { return "7469be11899e" }
+func (r *fullMigration) Version() string { return "9d4e1b7c3a58" }
 
 func (r *fullMigration) createTable(tx *sqlx.Tx) error {
 	const stmt = `
@@ -80,7 +80,8 @@ CREATE TABLE _auth_role (
 CREATE TABLE _asset (
 	id text PRIMARY KEY,
 	content_type text NOT NULL,
-	size bigint NOT NULL
+	size bigint NOT NULL,
+	updated_at timestamp without time zone NOT NULL
 );
 CREATE TABLE _device (
 	id text PRIMARY KEY,
@@ -88,6 +89,7 @@ CREATE TABLE _device (
 	type text NOT NULL,
 	token text,
 	topic text,
+	time_zone text,
 	last_registered_at timestamp without time zone NOT NULL,
 	UNIQUE (auth_id, type, token)
 );
@@ -134,6 +136,30 @@ CREATE TABLE _record_field_access (
     discoverable boolean NOT NULL,
     PRIMARY KEY (record_type, record_field, user_role)
 );
+CREATE TABLE _record_field_constraint (
+    record_type text NOT NULL,
+    record_field text NOT NULL,
+    constraints jsonb NOT NULL,
+    PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_history_type (
+    record_type text NOT NULL,
+    PRIMARY KEY (record_type)
+);
+CREATE TABLE _record_history (
+    id text NOT NULL,
+    record_type text NOT NULL,
+    record_id text NOT NULL,
+    database_id text NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL,
+    record jsonb,
+    diff jsonb,
+    actor_id text,
+    created_at timestamp without time zone NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE (record_type, record_id, database_id, version)
+);
 CREATE TABLE "user" (
     _id text,
     _database_id text,
@@ -220,6 +246,35 @@ CREATE TABLE _verify_code (
 	created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
 );
 CREATE INDEX ON _verify_code (auth_id, code, consumed);
+CREATE TABLE _scheduled_push (
+	id text PRIMARY KEY,
+	target_type text NOT NULL,
+	target_ids jsonb NOT NULL,
+	topic text,
+	notification jsonb NOT NULL,
+	send_at timestamp without time zone NOT NULL,
+	local_time boolean NOT NULL DEFAULT FALSE,
+	time_zone text,
+	due_at timestamp without time zone NOT NULL,
+	status text NOT NULL,
+	sent_device_ids jsonb,
+	created_at timestamp without time zone NOT NULL,
+	claimed_until timestamp without time zone
+);
+CREATE INDEX ON _scheduled_push (status, due_at);
+CREATE TABLE _upload_session (
+	id text PRIMARY KEY,
+	asset_name text NOT NULL,
+	content_type text NOT NULL,
+	length bigint NOT NULL,
+	upload_offset bigint NOT NULL,
+	multipart_id text,
+	parts jsonb,
+	created_at timestamp without time zone NOT NULL,
+	updated_at timestamp without time zone NOT NULL,
+	expires_at timestamp without time zone
+);
+CREATE INDEX _upload_session_expires_at_idx ON _upload_session (expires_at);
 `
 	_, err := tx.Exec(stmt)
 	return err
@@ -45,4 +45,11 @@ var revisions = []Revision{
 	&revision_94ffce762644{},
 	&revision_b3163d49bd6d{},
 	&revision_7469be11899e{},
+	&revision_a3c1d9e5f7b2{},
+	&revision_5e8b2c7d1f04{},
+	&revision_8c2f4a6e9b13{},
+	&revision_d41c7a0b92e5{},
+	&revision_6f3a9d2c8e41{},
+	&revision_2b7e5c9a1d36{},
+	&revision_9d4e1b7c3a58{},
 }
@@ -54,6 +54,11 @@ func isUniqueViolated(err error) bool {
 	return false
 }
 
+func isCheckViolated(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23514"
+}
+
 func isInvalidInputSyntax(err error) bool {
 	pqErr, ok := err.(*pq.Error)
 	return ok && (pqErr.Code == "22P02" || pqErr.Code == "22P03")
@@ -67,6 +72,14 @@ func isUndefinedTable(err error) bool {
 	return false
 }
 
+func isDuplicateTable(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42P07" {
+		return true
+	}
+
+	return false
+}
+
 func isNetworkError(err error) bool {
 	_, ok := err.(*net.OpError)
 	return ok
@@ -81,6 +94,10 @@ func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, co
 	if accessModel == skydb.RelationBasedAccess {
 		return nil, fmt.Errorf("Unsupported AccessModel: RelationBasedAccess")
 	}
+	replicas, err := getReplicaSet(appName, config.ReplicaOptions, config.ReplicaMaxLag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replica connection: %s", err)
+	}
 
 	return &conn{
 		db:                     db,
@@ -91,6 +108,7 @@ func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, co
 		canMigrate:             config.CanMigrate,
 		passwordHistoryEnabled: config.PasswordHistoryEnabled,
 		context:                ctx,
+		replicas:               replicas,
 	}, nil
 }
 
@@ -106,8 +124,22 @@ type getDBResp struct {
 	err error
 }
 
+type closeDBReq struct {
+	appName    string
+	connString string
+	done       chan error
+}
+
+// dbs are the connection pools keyed by dbKey. Each app has its own pool,
+// so that the schema of each app is initialized when it is first opened,
+// and closing the pool of an app does not affect other apps.
 var dbs = map[string]*sqlx.DB{}
 var getDBChan = make(chan getDBReq)
+var closeDBChan = make(chan closeDBReq)
+
+func dbKey(appName, connString string) string {
+	return appName + "\x00" + connString
+}
 
 func getDB(appName, connString string, migrate bool) (*sqlx.DB, error) {
 	ch := make(chan getDBResp)
@@ -116,31 +148,50 @@ func getDB(appName, connString string, migrate bool) (*sqlx.DB, error) {
 	return resp.db, resp.err
 }
 
+func closeDB(appName, connString string) error {
+	ch := make(chan error)
+	closeDBChan <- closeDBReq{appName, connString, ch}
+	return <-ch
+}
+
 // goroutine that initialize the database for use
 func dbInitializer() {
 	for {
-		req := <-getDBChan
-		db, ok := dbs[req.connString]
-		if !ok {
-			var err error
-			db, err = sqlx.Open("postgres", req.connString)
-			if err != nil {
-				req.done <- getDBResp{nil, fmt.Errorf("failed to open connection: %s", err)}
-				continue
+		select {
+		case req := <-getDBChan:
+			key := dbKey(req.appName, req.connString)
+			db, ok := dbs[key]
+			if !ok {
+				var err error
+				db, err = sqlx.Open("postgres", req.connString)
+				if err != nil {
+					req.done <- getDBResp{nil, fmt.Errorf("failed to open connection: %s", err)}
+					continue
+				}
+
+				db.SetMaxOpenConns(10)
+
+				if err := mustInitDB(db, req.appName, req.migrate); err != nil {
+					db.Close()
+					req.done <- getDBResp{nil, fmt.Errorf("failed to open connection: %s", err)}
+					continue
+				}
+
+				dbs[key] = db
 			}
 
-			db.SetMaxOpenConns(10)
-
-			if err := mustInitDB(db, req.appName, req.migrate); err != nil {
-				db.Close()
-				req.done <- getDBResp{nil, fmt.Errorf("failed to open connection: %s", err)}
+			req.done <- getDBResp{db, nil}
+		case req := <-closeDBChan:
+			key := dbKey(req.appName, req.connString)
+			db, ok := dbs[key]
+			if !ok {
+				req.done <- nil
 				continue
 			}
 
-			dbs[req.connString] = db
+			delete(dbs, key)
+			req.done <- db.Close()
 		}
-
-		req.done <- getDBResp{db, nil}
 	}
 }
 
@@ -163,7 +214,27 @@ func mustInitDB(db *sqlx.DB, appName string, migrate bool) error {
 	return nil
 }
 
+// pqDriver is the pq skydb driver, which keeps a connection pool for the
+// database of each app until the app is closed.
+type pqDriver struct{}
+
+func (pqDriver) Open(ctx context.Context, appName string, accessModel skydb.AccessModel, connString string, config skydb.DBConfig) (skydb.Conn, error) {
+	return Open(ctx, appName, accessModel, connString, config)
+}
+
+// CloseApp closes the connection pools of the database and the replicas
+// of the app, and stops sending record events to the channels subscribed
+// by the app.
+func (pqDriver) CloseApp(appName string, connString string) error {
+	unsubscribeApp(appName)
+	replicaErr := closeReplicaSets(appName)
+	if err := closeDB(appName, connString); err != nil {
+		return err
+	}
+	return replicaErr
+}
+
 func init() {
-	skydb.Register("pq", skydb.DriverFunc(Open))
+	skydb.Register("pq", pqDriver{})
 	go dbInitializer()
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
 )
 
 // NOTE(limouren): postgresql uses this error to signify a non-exist
@@ -45,13 +46,7 @@ func getTestConn(t *testing.T) *conn {
 	if runtime.GOMAXPROCS(0) > 1 {
 		t.Skip("skipping zmq test in GOMAXPROCS>1")
 	}
-	defaultTo := func(envvar string, value string) {
-		if os.Getenv(envvar) == "" {
-			os.Setenv(envvar, value)
-		}
-	}
-	defaultTo("PGDATABASE", "skygear_test")
-	defaultTo("PGSSLMODE", "disable")
+	setTestConnDefaults()
 	appName := testAppName()
 	dbConfig := skydb.DBConfig{
 		CanMigrate:             true,
@@ -70,6 +65,18 @@ func getTestConn(t *testing.T) *conn {
 	return c.(*conn)
 }
 
+// setTestConnDefaults sets the environment variables of the connection
+// to the test database if they are not set.
+func setTestConnDefaults() {
+	defaultTo := func(envvar string, value string) {
+		if os.Getenv(envvar) == "" {
+			os.Setenv(envvar, value)
+		}
+	}
+	defaultTo("PGDATABASE", "skygear_test")
+	defaultTo("PGSSLMODE", "disable")
+}
+
 func dropAllRecordTables(t *testing.T, c *conn) {
 	tx, err := c.db.Beginx()
 	if err != nil {
@@ -170,3 +177,49 @@ func exhaustRows(rows *skydb.Rows, errin error) (records []skydb.Record, err err
 	err = rows.Err()
 	return
 }
+
+func TestCloseApp(t *testing.T) {
+	Convey("Driver", t, func() {
+		c := getTestConn(t)
+
+		Convey("keeps a pool for each app", func() {
+			defer cleanupConn(t, c)
+
+			other, err := Open(context.Background(), c.appName+"_other", skydb.RoleBasedAccess, "", skydb.DBConfig{
+				CanMigrate: true,
+			})
+			So(err, ShouldBeNil)
+			defer cleanupConn(t, other.(*conn))
+
+			So(other.(*conn).db, ShouldNotEqual, c.db)
+		})
+
+		Convey("closes the pool of the app", func() {
+			So(pqDriver{}.CloseApp(c.appName, ""), ShouldBeNil)
+			So(c.db.Ping(), ShouldNotBeNil)
+
+			reopened, err := Open(context.Background(), c.appName, skydb.RoleBasedAccess, "", skydb.DBConfig{
+				CanMigrate: true,
+			})
+			So(err, ShouldBeNil)
+			defer cleanupConn(t, reopened.(*conn))
+
+			So(reopened.(*conn).db, ShouldNotEqual, c.db)
+			So(reopened.(*conn).db.Ping(), ShouldBeNil)
+		})
+
+		Convey("closes the channels subscribed by the app on unsubscribe", func() {
+			defer cleanupConn(t, c)
+
+			ch := make(chan skydb.RecordEvent)
+			So(c.Subscribe(ch), ShouldBeNil)
+			emit(&notification{AppName: toLowerAndUnderscore(c.appName)})
+
+			unsubscribeApp(c.appName)
+			_, ok := <-ch
+			So(ok, ShouldBeFalse)
+
+			emit(&notification{AppName: toLowerAndUnderscore(c.appName)})
+		})
+	})
+}
@@ -34,6 +34,15 @@ import (
 )
 
 func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
+	return db.get(id, record, false)
+}
+
+// GetForUpdate fetches the record with SELECT FOR UPDATE.
+func (db *database) GetForUpdate(id skydb.RecordID, record *skydb.Record) error {
+	return db.get(id, record, true)
+}
+
+func (db *database) get(id skydb.RecordID, record *skydb.Record, forUpdate bool) error {
 	typemap, err := db.RemoteColumnTypes(id.Type)
 	if err != nil {
 		return err
@@ -44,6 +53,12 @@ func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
 	}
 
 	builder := db.selectQuery(psql.Select(), id.Type, typemap).Where("_id = ?", id.Key)
+	if isSoftDeleteEnabled(typemap) {
+		builder = builder.Where(notDeletedSqlizer(id.Type))
+	}
+	if forUpdate {
+		builder = builder.Suffix("FOR UPDATE")
+	}
 	row := db.c.QueryRowWith(builder)
 	if err := newRecordScanner(id.Type, typemap, row).Scan(record); err == sql.ErrNoRows {
 		return skydb.ErrRecordNotFound
@@ -86,6 +101,9 @@ func (db *database) GetByIDs(ids []skydb.RecordID, accessControlOptions *skydb.A
 	inCause, inArgs := builder.LiteralToSQLOperand(idStrs)
 	query := db.selectQuery(psql.Select(), recordType, typemap).
 		Where(pq.QuoteIdentifier("_id")+" IN "+inCause, inArgs...)
+	if isSoftDeleteEnabled(typemap) {
+		query = query.Where(notDeletedSqlizer(recordType))
+	}
 
 	if db.DatabaseType() == skydb.PublicDatabase && !accessControlOptions.BypassAccessControl {
 		factory := builder.NewSqlizerFactory(db, recordType)
@@ -142,10 +160,15 @@ func (db *database) Save(record *skydb.Record) error {
 		}
 	}
 
-	upsert := builder.UpsertQueryWithWrappers(db.TableName(record.ID.Type), pkData, convert(record), wrappers).
+	data := convert(record)
+
+	upsert := builder.UpsertQueryWithWrappers(db.TableName(record.ID.Type), pkData, data, wrappers).
 		IgnoreKeyOnUpdate("_owner_id").
 		IgnoreKeyOnUpdate("_created_at").
 		IgnoreKeyOnUpdate("_created_by")
+	if err := applyFieldOperations(upsert, typemap, wrappers, record); err != nil {
+		return err
+	}
 
 	// record type is empty in the following statement because upsert
 	// only concerns with one record type, and that specifying the
@@ -161,12 +184,36 @@ func (db *database) Save(record *skydb.Record) error {
 	row := db.c.QueryRowWith(upsert)
 	if err = newRecordScanner(record.ID.Type, typemap, row).Scan(record); err != nil {
 		if isUniqueViolated(err) {
+			if field := uniqueConstraintField(record.ID.Type, err.(*pq.Error).Constraint); field != "" {
+				return skyerr.NewErrorWithInfo(
+					skyerr.ConstraintViolated,
+					fmt.Sprintf("%s: value is not unique", field),
+					map[string]interface{}{
+						"field":      field,
+						"constraint": skydb.UniqueConstraint,
+					},
+				)
+			}
 			return skyerr.NewErrorf(
 				skyerr.Duplicated,
 				fmt.Sprintf("violate unique constraint"),
 			)
 		}
 
+		if isCheckViolated(err) {
+			field := checkConstraintField(record.ID.Type, err.(*pq.Error).Constraint)
+			if fieldType, ok := typemap[field]; ok && fieldType.Constraints != nil {
+				return skyerr.NewErrorWithInfo(
+					skyerr.ConstraintViolated,
+					fmt.Sprintf("%s: value violates constraints", field),
+					map[string]interface{}{
+						"field":      field,
+						"constraint": violatedCheckConstraint(fieldType.Constraints, record.Data[field]),
+					},
+				)
+			}
+		}
+
 		if isInvalidInputSyntax(err) {
 			return skyerr.NewErrorf(
 				skyerr.InvalidArgument,
@@ -216,6 +263,8 @@ func convert(r *skydb.Record) map[string]interface{} {
 		case skydb.Unknown:
 			// Do not modify columns with unknown type because they are
 			// managed by the developer.
+		case skydb.FieldOperation:
+			m[key] = fieldOperationValue(value)
 		default:
 			m[key] = rawValue
 		}
@@ -231,6 +280,16 @@ func convert(r *skydb.Record) map[string]interface{} {
 
 func (db *database) Delete(id skydb.RecordID) error {
 	logger := logging.CreateLogger(db.c.context, "skydb")
+	if db.DatabaseType() != skydb.UnionDatabase {
+		typemap, err := db.RemoteColumnTypes(id.Type)
+		if err != nil {
+			return err
+		}
+		if isSoftDeleteEnabled(typemap) {
+			return db.softDelete(id)
+		}
+	}
+
 	builder := psql.Delete(db.TableName(id.Type)).
 		Where("_id = ?", id.Key)
 
@@ -274,6 +333,17 @@ func (db *database) Delete(id skydb.RecordID) error {
 	return err
 }
 
+// newQuerySqlizerFactory returns the SqlizerFactory for a query on the
+// records of recordType, which applies the database and access control
+// of the query to the records of joined tables and subqueries.
+func (db *database) newQuerySqlizerFactory(recordType string, accessControlOptions *skydb.AccessControlOptions) builder.SqlizerFactory {
+	var databaseID *string
+	if db.DatabaseType() != skydb.UnionDatabase {
+		databaseID = &db.userID
+	}
+	return builder.NewQuerySqlizerFactory(db, recordType, databaseID, accessControlOptions)
+}
+
 func (db *database) applyQueryPredicate(q sq.SelectBuilder, factory builder.SqlizerFactory, query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (sq.SelectBuilder, error) {
 	if p := query.Predicate; !p.IsEmpty() {
 		sqlizer, err := factory.NewPredicateSqlizer(p)
@@ -295,46 +365,72 @@ func (db *database) applyQueryPredicate(q sq.SelectBuilder, factory builder.Sqli
 }
 
 func (db *database) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	q, typemap, err := db.selectQueryBuilder(query, accessControlOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if typemap == nil { // record type has not been created
+		return skydb.EmptyRows, nil
+	}
+
+	rows, err := db.c.QueryWith(q)
+	return newRows(query.Type, typemap, rows, err)
+}
+
+// selectQueryBuilder returns the select statement of the supplied query
+// and the typemap of the selected columns. A nil typemap is returned if
+// the record type has not been created.
+func (db *database) selectQueryBuilder(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (sq.Sqlizer, skydb.RecordSchema, error) {
+	q := psql.Select()
 	if query.Type == "" {
-		return nil, errors.New("got empty query type")
+		return q, nil, errors.New("got empty query type")
 	}
 
 	typemap, err := db.RemoteColumnTypes(query.Type)
 	if err != nil {
-		return nil, err
+		return q, nil, err
 	}
 
 	if len(typemap) == 0 { // record type has not been created
-		return skydb.EmptyRows, nil
+		return q, nil, nil
 	}
 
-	q := psql.Select()
-	factory := builder.NewSqlizerFactory(db, query.Type)
+	if isSoftDeleteEnabled(typemap) && !query.IncludeDeleted {
+		q = q.Where(notDeletedSqlizer(query.Type))
+	}
+	factory := db.newQuerySqlizerFactory(query.Type, accessControlOptions)
 
 	q, err = db.applyQueryPredicate(q, factory, query, accessControlOptions)
 	if err != nil {
-		return nil, err
+		return q, nil, err
 	}
 
+	orderBys := []string{}
 	for _, sort := range query.Sorts {
 		orderBy, err := factory.NewSort(sort)
 		if err != nil {
-			return nil, err
+			return q, nil, err
 		}
-		q = q.OrderBy(orderBy)
+		orderBys = append(orderBys, orderBy)
 	}
 
 	q = factory.AddJoinsToSelectBuilder(q)
-	if err != nil {
-		return nil, err
-	}
 
-	if query.Limit != nil {
-		q = q.Limit(*query.Limit)
-	}
+	// the records within the partition limit are ordered and paged
+	// by partitionLimitQuery
+	if query.PartitionLimit == nil {
+		for _, orderBy := range orderBys {
+			q = q.OrderBy(orderBy)
+		}
 
-	if query.Offset > 0 {
-		q = q.Offset(query.Offset)
+		if query.Limit != nil {
+			q = q.Limit(*query.Limit)
+		}
+
+		if query.Offset > 0 {
+			q = q.Offset(query.Offset)
+		}
 	}
 
 	// Select columns to return, this is the last step so that predicate
@@ -342,13 +438,59 @@ func (db *database) Query(query *skydb.Query, accessControlOptions *skydb.Access
 	// depends on the alias name used in table joins.
 	typemap, err = updateTypemapForQuery(query, typemap)
 	if err != nil {
-		return nil, err
+		return q, nil, err
 	}
 	typemap = factory.UpdateTypemap(typemap)
 	q = db.selectQuery(q, query.Type, typemap)
 
-	rows, err := db.c.QueryWith(q)
-	return newRows(query.Type, typemap, rows, err)
+	if query.PartitionLimit != nil {
+		partitioned, err := partitionLimitQuery(q, query, orderBys, typemap)
+		return partitioned, typemap, err
+	}
+	return q, typemap, nil
+}
+
+// partitionLimitQuery returns the statement selecting the records of q
+// within the partition limit of the query. The records are numbered by
+// window functions in a subquery, which is not ordered, so the statement
+// orders the records by their numbers in the order of the query.
+func partitionLimitQuery(q sq.SelectBuilder, query *skydb.Query, orderBys []string, typemap skydb.RecordSchema) (sq.Sqlizer, error) {
+	over := ""
+	if len(orderBys) > 0 {
+		over = "ORDER BY " + strings.Join(orderBys, ", ")
+		q = q.Column("ROW_NUMBER() OVER (" + over + `) AS "_query_row"`)
+	}
+	field := pq.QuoteIdentifier(query.Type) + "." + pq.QuoteIdentifier(query.PartitionLimit.Field)
+	q = q.Column("ROW_NUMBER() OVER (PARTITION BY " + field + " " + over + `) AS "_partition_row"`)
+
+	sql, args, err := q.PlaceholderFormat(sq.Question).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(typemap))
+	for column := range typemap {
+		columns = append(columns, pq.QuoteIdentifier(column))
+	}
+	sql = fmt.Sprintf(`SELECT %s FROM (%s) AS "_partitioned" WHERE "_partition_row" <= ?`, strings.Join(columns, ", "), sql)
+	args = append(args, query.PartitionLimit.Limit)
+	if len(orderBys) > 0 {
+		sql += ` ORDER BY "_query_row"`
+	}
+	if query.Limit != nil {
+		sql += " LIMIT ?"
+		args = append(args, *query.Limit)
+	}
+	if query.Offset > 0 {
+		sql += " OFFSET ?"
+		args = append(args, query.Offset)
+	}
+
+	sql, err = sq.Dollar.ReplacePlaceholders(sql)
+	if err != nil {
+		return nil, err
+	}
+	return sq.Expr(sql, args...), nil
 }
 
 func (db *database) QueryCount(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (uint64, error) {
@@ -360,6 +502,7 @@ func (db *database) QueryCount(query *skydb.Query, accessControlOptions *skydb.A
 	if err != nil || len(typemap) == 0 { // error or record type has not been created
 		return 0, err
 	}
+	softDeleteEnabled := isSoftDeleteEnabled(typemap)
 
 	typemap = skydb.RecordSchema{
 		"_record_count": skydb.FieldType{
@@ -374,7 +517,10 @@ func (db *database) QueryCount(query *skydb.Query, accessControlOptions *skydb.A
 	}
 
 	q := db.selectQuery(psql.Select(), query.Type, typemap)
-	factory := builder.NewSqlizerFactory(db, query.Type)
+	if softDeleteEnabled && !query.IncludeDeleted {
+		q = q.Where(notDeletedSqlizer(query.Type))
+	}
+	factory := db.newQuerySqlizerFactory(query.Type, accessControlOptions)
 	q, err = db.applyQueryPredicate(q, factory, query, accessControlOptions)
 	if err != nil {
 		return 0, err
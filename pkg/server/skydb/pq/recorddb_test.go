@@ -1750,6 +1750,71 @@ func TestQueryCount(t *testing.T) {
 	})
 }
 
+func TestExplainQuery(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PrivateDB("userid")
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		err = db.Save(&skydb.Record{
+			ID:      skydb.NewRecordID("note", "id1"),
+			OwnerID: "user_id",
+			Data: map[string]interface{}{
+				"content": "Hello World",
+			},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("explains query with statement and plan", func() {
+			query := skydb.Query{
+				Type: "note",
+				Predicate: skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{
+							Type:  skydb.KeyPath,
+							Value: "content",
+						},
+						skydb.Expression{
+							Type:  skydb.Literal,
+							Value: "Hello World",
+						},
+					},
+				},
+			}
+			accessControlOptions := skydb.AccessControlOptions{}
+			explanation, err := db.ExplainQuery(&query, &accessControlOptions)
+
+			So(err, ShouldBeNil)
+			So(explanation.Statement, ShouldStartWith, "SELECT ")
+			So(explanation.Statement, ShouldContainSubstring, `"note"."content"`)
+			So(explanation.Args, ShouldContain, "Hello World")
+
+			plans, ok := explanation.Plan.([]interface{})
+			So(ok, ShouldBeTrue)
+			So(len(plans), ShouldEqual, 1)
+			So(plans[0], ShouldContainKey, "Plan")
+			So(plans[0], ShouldContainKey, "Execution Time")
+		})
+
+		Convey("returns empty explanation for non-existent record type", func() {
+			query := skydb.Query{
+				Type: "notexisttype",
+			}
+			accessControlOptions := skydb.AccessControlOptions{}
+			explanation, err := db.ExplainQuery(&query, &accessControlOptions)
+
+			So(err, ShouldBeNil)
+			So(explanation, ShouldResemble, skydb.QueryExplanation{})
+		})
+	})
+}
+
 func TestAggregateQuery(t *testing.T) {
 	Convey("Database", t, func() {
 		c := getTestConn(t)
@@ -0,0 +1,157 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordHistory(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		recordID := skydb.NewRecordID("note", "note1")
+
+		Convey("enables and disables history of record types", func() {
+			So(c.SetRecordHistoryEnabled("note", true), ShouldBeNil)
+			So(c.SetRecordHistoryEnabled("comment", true), ShouldBeNil)
+			So(c.SetRecordHistoryEnabled("note", true), ShouldBeNil)
+
+			recordTypes, err := c.GetRecordHistoryTypes()
+			So(err, ShouldBeNil)
+			So(recordTypes, ShouldResemble, []string{"comment", "note"})
+
+			So(c.SetRecordHistoryEnabled("note", false), ShouldBeNil)
+			recordTypes, err = c.GetRecordHistoryTypes()
+			So(err, ShouldBeNil)
+			So(recordTypes, ShouldResemble, []string{"comment"})
+		})
+
+		Convey("saves and gets revisions", func() {
+			first := skydb.RecordRevision{
+				RecordID:   recordID,
+				DatabaseID: "",
+				Action:     skydb.RecordRevisionSave,
+				Record: skydb.Record{
+					ID:      recordID,
+					OwnerID: "user0",
+					Data: skydb.Data{
+						"title": "Hello",
+						"ref":   skydb.NewReference("note", "note0"),
+					},
+				},
+				Diff: map[string]skydb.RecordFieldChange{
+					"title": {Old: nil, New: "Hello"},
+				},
+				ActorID: "user0",
+			}
+			So(c.SaveRecordRevision(&first), ShouldBeNil)
+			So(first.Version, ShouldEqual, 1)
+			So(first.ID, ShouldNotBeEmpty)
+
+			second := skydb.RecordRevision{
+				RecordID:   recordID,
+				DatabaseID: "",
+				Action:     skydb.RecordRevisionDelete,
+				Record:     first.Record,
+				ActorID:    "user1",
+			}
+			So(c.SaveRecordRevision(&second), ShouldBeNil)
+			So(second.Version, ShouldEqual, 2)
+
+			revisions, err := c.GetRecordRevisions("", recordID)
+			So(err, ShouldBeNil)
+			So(revisions, ShouldHaveLength, 2)
+			So(revisions[0].Version, ShouldEqual, 2)
+			So(revisions[0].Action, ShouldEqual, skydb.RecordRevisionDelete)
+			So(revisions[0].ActorID, ShouldEqual, "user1")
+			So(revisions[1].Version, ShouldEqual, 1)
+
+			fetched := skydb.RecordRevision{}
+			So(c.GetRecordRevision("", recordID, 1, &fetched), ShouldBeNil)
+			So(fetched.Record.OwnerID, ShouldEqual, "user0")
+			So(fetched.Record.Data, ShouldResemble, skydb.Data{
+				"title": "Hello",
+				"ref":   skydb.NewReference("note", "note0"),
+			})
+			So(fetched.Diff, ShouldResemble, map[string]skydb.RecordFieldChange{
+				"title": {Old: nil, New: "Hello"},
+			})
+		})
+
+		Convey("keeps revisions of databases apart", func() {
+			revision := skydb.RecordRevision{
+				RecordID:   recordID,
+				DatabaseID: "user0",
+				Action:     skydb.RecordRevisionSave,
+				Record:     skydb.Record{ID: recordID},
+			}
+			So(c.SaveRecordRevision(&revision), ShouldBeNil)
+
+			revisions, err := c.GetRecordRevisions("", recordID)
+			So(err, ShouldBeNil)
+			So(revisions, ShouldBeEmpty)
+		})
+
+		Convey("allocates distinct versions to concurrent transactions", func() {
+			other, err := Open(context.Background(), c.appName, skydb.RoleBasedAccess, "", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+			defer other.Close()
+
+			So(c.Begin(), ShouldBeNil)
+			first := skydb.RecordRevision{
+				RecordID: recordID,
+				Action:   skydb.RecordRevisionSave,
+				Record:   skydb.Record{ID: recordID},
+			}
+			So(c.SaveRecordRevision(&first), ShouldBeNil)
+
+			done := make(chan error)
+			second := skydb.RecordRevision{
+				RecordID: recordID,
+				Action:   skydb.RecordRevisionSave,
+				Record:   skydb.Record{ID: recordID},
+			}
+			go func() {
+				otherConn := other.(*conn)
+				if err := otherConn.Begin(); err != nil {
+					done <- err
+					return
+				}
+				if err := otherConn.SaveRecordRevision(&second); err != nil {
+					otherConn.Rollback()
+					done <- err
+					return
+				}
+				done <- otherConn.Commit()
+			}()
+
+			So(c.Commit(), ShouldBeNil)
+			So(<-done, ShouldBeNil)
+			So(first.Version, ShouldEqual, 1)
+			So(second.Version, ShouldEqual, 2)
+		})
+
+		Convey("returns ErrRecordRevisionNotFound", func() {
+			err := c.GetRecordRevision("", recordID, 1, &skydb.RecordRevision{})
+			So(err, ShouldEqual, skydb.ErrRecordRevisionNotFound)
+		})
+	})
+}
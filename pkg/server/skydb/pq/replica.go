@@ -0,0 +1,222 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+)
+
+// replicaCheckInterval is the interval between health checks of the
+// replicas.
+var replicaCheckInterval = 5 * time.Second
+
+// replicaLagQuery reports the replication lag of a replica in seconds.
+// A replica that has replayed all received WAL is not lagging even if
+// the primary has been idle.
+const replicaLagQuery = `
+SELECT CASE
+    WHEN NOT pg_is_in_recovery() THEN 0
+    WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+    ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END`
+
+type replica struct {
+	db      *sqlx.DB
+	healthy bool
+	lag     time.Duration
+}
+
+// replicaSet routes reads to the healthy replicas in a round-robin
+// manner. A replica is not routed to if it fails the health check or
+// it lags behind the primary longer than maxLag.
+type replicaSet struct {
+	replicas []*replica
+	maxLag   time.Duration
+	next     int
+	mutex    sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// pick returns the next replica available for reads, or nil if none of
+// the replicas are available.
+func (s *replicaSet) pick() *sqlx.DB {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 0; i < len(s.replicas); i++ {
+		r := s.replicas[(s.next+i)%len(s.replicas)]
+		if !r.healthy || (s.maxLag > 0 && r.lag > s.maxLag) {
+			continue
+		}
+		s.next = (s.next + i + 1) % len(s.replicas)
+		return r.db
+	}
+	return nil
+}
+
+func (s *replicaSet) setStatus(r *replica, healthy bool, lag time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r.healthy = healthy
+	r.lag = lag
+}
+
+// check updates the health and the lag of each replica.
+func (s *replicaSet) check(ctx context.Context) {
+	logger := logging.LoggerEntry("skydb")
+	for i, r := range s.replicas {
+		var lagSeconds float64
+		err := r.db.PingContext(ctx)
+		if err == nil {
+			err = r.db.QueryRowxContext(ctx, replicaLagQuery).Scan(&lagSeconds)
+		}
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"replica": i,
+			}).WithError(err).Warnln("Replica failed health check")
+			s.setStatus(r, false, 0)
+			continue
+		}
+
+		lag := time.Duration(lagSeconds * float64(time.Second))
+		if s.maxLag > 0 && lag > s.maxLag {
+			logger.WithFields(logrus.Fields{
+				"replica": i,
+				"lag":     lag.String(),
+			}).Warnln("Replica lags behind primary")
+		}
+		s.setStatus(r, true, lag)
+	}
+}
+
+// run checks the replicas periodically until close is called. Replicas
+// are not routed to until the first check completes.
+func (s *replicaSet) run() {
+	defer close(s.done)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+		s.check(ctx)
+		cancel()
+
+		select {
+		case <-time.After(replicaCheckInterval):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// close stops the health check and closes the connection pools of the
+// replicas. Conns still holding the set read from the primary
+// afterwards, as no replica is routed to.
+func (s *replicaSet) close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mutex.Lock()
+	for _, r := range s.replicas {
+		r.healthy = false
+	}
+	s.mutex.Unlock()
+
+	var err error
+	for _, r := range s.replicas {
+		if closeErr := r.db.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// replicaSets are the replica sets keyed by the app and the connection
+// strings, so that the replica sets of an app are closed with the app.
+var replicaSets = map[string]*replicaSet{}
+var replicaSetsMutex sync.Mutex
+
+// getReplicaSet returns the replica set of the supplied connection
+// strings for the app. Replica sets are shared among connections of the
+// app and are checked in the background once created, until closed by
+// closeReplicaSets.
+func getReplicaSet(appName string, connStrings []string, maxLag time.Duration) (*replicaSet, error) {
+	if len(connStrings) == 0 {
+		return nil, nil
+	}
+
+	replicaSetsMutex.Lock()
+	defer replicaSetsMutex.Unlock()
+
+	key := dbKey(appName, strings.Join(connStrings, "\n"))
+	if s, ok := replicaSets[key]; ok {
+		return s, nil
+	}
+
+	s := &replicaSet{
+		maxLag: maxLag,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, connString := range connStrings {
+		db, err := sqlx.Open("postgres", connString)
+		if err != nil {
+			for _, r := range s.replicas {
+				r.db.Close()
+			}
+			return nil, err
+		}
+		db.SetMaxOpenConns(10)
+		s.replicas = append(s.replicas, &replica{db: db})
+	}
+
+	replicaSets[key] = s
+	go s.run()
+	return s, nil
+}
+
+// closeReplicaSets closes the replica sets of the app.
+func closeReplicaSets(appName string) error {
+	replicaSetsMutex.Lock()
+	defer replicaSetsMutex.Unlock()
+
+	var err error
+	prefix := dbKey(appName, "")
+	for key, s := range replicaSets {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		delete(replicaSets, key)
+		if closeErr := s.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// isReadStatement returns whether the statement only reads data.
+func isReadStatement(query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	return strings.HasPrefix(query, "SELECT") || strings.HasPrefix(query, "EXPLAIN")
+}
@@ -0,0 +1,118 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReplicaSet(t *testing.T) {
+	Convey("replicaSet", t, func() {
+		primary := sqlx.NewDb(&sql.DB{}, "postgres")
+		replica1 := &replica{db: sqlx.NewDb(&sql.DB{}, "postgres"), healthy: true}
+		replica2 := &replica{db: sqlx.NewDb(&sql.DB{}, "postgres"), healthy: true}
+		s := &replicaSet{
+			replicas: []*replica{replica1, replica2},
+			maxLag:   time.Second,
+		}
+
+		Convey("picks healthy replicas in turn", func() {
+			So(s.pick(), ShouldEqual, replica1.db)
+			So(s.pick(), ShouldEqual, replica2.db)
+			So(s.pick(), ShouldEqual, replica1.db)
+		})
+
+		Convey("skips unhealthy and lagging replicas", func() {
+			s.setStatus(replica1, false, 0)
+			So(s.pick(), ShouldEqual, replica2.db)
+			So(s.pick(), ShouldEqual, replica2.db)
+
+			s.setStatus(replica2, true, 2*time.Second)
+			So(s.pick(), ShouldBeNil)
+		})
+
+		Convey("picks nothing from nil set", func() {
+			var nilSet *replicaSet
+			So(nilSet.pick(), ShouldBeNil)
+		})
+
+		Convey("routes reads of conn", func() {
+			c := &conn{
+				db:       primary,
+				replicas: s,
+			}
+
+			So(c.Db(), ShouldEqual, primary)
+
+			done := c.RouteReadsToReplica()
+			So(c.Db(), ShouldEqual, replica1.db)
+			done()
+			So(c.Db(), ShouldEqual, primary)
+
+			Convey("sticks to primary after write", func() {
+				c.markWrite(`SELECT 1`)
+				So(c.wrote, ShouldBeFalse)
+				c.markWrite(`INSERT INTO "note" ("_id") VALUES ($1)`)
+				So(c.wrote, ShouldBeTrue)
+
+				defer c.RouteReadsToReplica()()
+				So(c.Db(), ShouldEqual, primary)
+			})
+
+			Convey("reads primary in transaction", func() {
+				c.tx = &sqlx.Tx{}
+
+				defer c.RouteReadsToReplica()()
+				So(c.readsReplica(), ShouldBeFalse)
+				So(c.Db(), ShouldEqual, c.tx)
+			})
+
+			Convey("falls back to primary without available replica", func() {
+				s.setStatus(replica1, false, 0)
+				s.setStatus(replica2, false, 0)
+
+				defer c.RouteReadsToReplica()()
+				So(c.Db(), ShouldEqual, primary)
+			})
+		})
+	})
+}
+
+func TestCloseReplicaSets(t *testing.T) {
+	Convey("closeReplicaSets", t, func() {
+		// the replica is not connected until it is checked
+		connString := "host=127.0.0.1 port=1 sslmode=disable"
+		s, err := getReplicaSet("closingapp", []string{connString}, 0)
+		So(err, ShouldBeNil)
+		other, err := getReplicaSet("otherapp", []string{connString}, 0)
+		So(err, ShouldBeNil)
+		defer closeReplicaSets("otherapp")
+
+		So(closeReplicaSets("closingapp"), ShouldBeNil)
+		So(replicaSets, ShouldNotContainKey, dbKey("closingapp", connString))
+		So(replicaSets, ShouldContainKey, dbKey("otherapp", connString))
+
+		// the health check is stopped and the replicas are closed
+		<-s.done
+		So(s.pick(), ShouldBeNil)
+		So(s.replicas[0].db.Ping(), ShouldNotBeNil)
+		So(other.replicas[0].db, ShouldNotEqual, s.replicas[0].db)
+	})
+}
@@ -0,0 +1,227 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+)
+
+var scheduledPushColumns = []string{"id", "target_type", "target_ids",
+	"topic", "notification", "send_at", "local_time", "time_zone",
+	"status", "sent_device_ids", "created_at"}
+
+func (c *conn) scheduledPushBuilder() sq.SelectBuilder {
+	return psql.Select(scheduledPushColumns...).
+		From(c.tableName("_scheduled_push"))
+}
+
+func (c *conn) doScanScheduledPush(push *skydb.ScheduledPush, scanner sq.RowScanner) error {
+	var (
+		targetType    string
+		targetIDs     nullJSONStringSlice
+		topic         sql.NullString
+		notification  nullJSON
+		timeZone      sql.NullString
+		status        string
+		sentDeviceIDs nullJSONStringSlice
+	)
+
+	err := scanner.Scan(
+		&push.ID,
+		&targetType,
+		&targetIDs,
+		&topic,
+		&notification,
+		&push.SendAt,
+		&push.LocalTime,
+		&timeZone,
+		&status,
+		&sentDeviceIDs,
+		&push.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	push.TargetType = skydb.PushTargetType(targetType)
+	push.TargetIDs = targetIDs.slice
+	push.Topic = topic.String
+	push.Notification, _ = notification.JSON.(map[string]interface{})
+	push.SendAt = push.SendAt.UTC()
+	push.TimeZone = timeZone.String
+	push.Status = skydb.ScheduledPushStatus(status)
+	push.SentDeviceIDs = sentDeviceIDs.slice
+	push.CreatedAt = push.CreatedAt.UTC()
+	return nil
+}
+
+func (c *conn) GetScheduledPush(id string, push *skydb.ScheduledPush) error {
+	builder := c.scheduledPushBuilder().
+		Where("id = ?", id)
+
+	err := c.doScanScheduledPush(push, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrScheduledPushNotFound
+	}
+	return err
+}
+
+func (c *conn) SaveScheduledPush(push *skydb.ScheduledPush) error {
+	if push.ID == "" || push.TargetType == "" || push.SendAt.IsZero() {
+		return errors.New("invalid scheduled push: empty id, target type, or send at")
+	}
+
+	createdAt := push.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	pkData := map[string]interface{}{"id": push.ID}
+	data := map[string]interface{}{
+		"target_type":     string(push.TargetType),
+		"target_ids":      jsonStringSliceValue(push.TargetIDs),
+		"topic":           nil,
+		"notification":    jsonMapValue(push.Notification),
+		"send_at":         push.SendAt.UTC(),
+		"local_time":      push.LocalTime,
+		"time_zone":       nil,
+		"due_at":          push.EarliestDueAt().UTC(),
+		"status":          string(push.Status),
+		"sent_device_ids": jsonStringSliceValue(push.SentDeviceIDs),
+		"created_at":      createdAt.UTC(),
+	}
+
+	if push.Topic != "" {
+		data["topic"] = push.Topic
+	}
+
+	if push.TimeZone != "" {
+		data["time_zone"] = push.TimeZone
+	}
+
+	upsert := builder.UpsertQuery(c.tableName("_scheduled_push"), pkData, data).
+		IgnoreKeyOnUpdate("created_at")
+	if _, err := c.ExecWith(upsert); err != nil {
+		return err
+	}
+
+	push.CreatedAt = createdAt
+	return nil
+}
+
+func (c *conn) CancelScheduledPush(id string, push *skydb.ScheduledPush) error {
+	builder := psql.Update(c.tableName("_scheduled_push")).
+		Set("status", string(skydb.ScheduledPushCancelled)).
+		Set("claimed_until", nil).
+		Where("id = ?", id).
+		Where(sq.Eq{"status": []string{
+			string(skydb.ScheduledPushPending),
+			string(skydb.ScheduledPushDispatching),
+		}}).
+		Suffix("RETURNING " + strings.Join(scheduledPushColumns, ", "))
+
+	err := c.doScanScheduledPush(push, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrScheduledPushNotFound
+	}
+	return err
+}
+
+func (c *conn) ClaimDueScheduledPushes(t time.Time, claimUntil time.Time) ([]skydb.ScheduledPush, error) {
+	// Concurrent updates of a row are serialized by the row lock, and
+	// the condition is checked again after the lock is acquired, so a
+	// push is claimed by one conn only.
+	builder := psql.Update(c.tableName("_scheduled_push")).
+		Set("status", string(skydb.ScheduledPushDispatching)).
+		Set("claimed_until", claimUntil.UTC()).
+		Where("due_at <= ?", t.UTC()).
+		Where(sq.Or{
+			sq.Eq{"status": string(skydb.ScheduledPushPending)},
+			sq.And{
+				sq.Eq{"status": string(skydb.ScheduledPushDispatching)},
+				sq.Expr("claimed_until <= ?", t.UTC()),
+			},
+		}).
+		Suffix("RETURNING " + strings.Join(scheduledPushColumns, ", "))
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.ScheduledPush{}
+	for rows.Next() {
+		push := skydb.ScheduledPush{}
+		if err := c.doScanScheduledPush(&push, rows); err != nil {
+			return nil, err
+		}
+		results = append(results, push)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].EarliestDueAt().Equal(results[j].EarliestDueAt()) {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].EarliestDueAt().Before(results[j].EarliestDueAt())
+	})
+	return results, nil
+}
+
+func (c *conn) updateDispatchingScheduledPush(id string, data map[string]interface{}) error {
+	builder := psql.Update(c.tableName("_scheduled_push")).
+		SetMap(data).
+		Where("id = ? AND status = ?", id, string(skydb.ScheduledPushDispatching))
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrScheduledPushNotFound
+	}
+	return nil
+}
+
+func (c *conn) MarkScheduledPushSent(id string, deviceIDs []string) error {
+	return c.updateDispatchingScheduledPush(id, map[string]interface{}{
+		"sent_device_ids": jsonStringSliceValue(deviceIDs),
+	})
+}
+
+func (c *conn) ReleaseScheduledPush(id string, status skydb.ScheduledPushStatus, dueAt time.Time) error {
+	return c.updateDispatchingScheduledPush(id, map[string]interface{}{
+		"status":        string(status),
+		"due_at":        dueAt.UTC(),
+		"claimed_until": nil,
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduledPush(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		push := skydb.ScheduledPush{
+			ID:           "pushid",
+			TargetType:   skydb.PushTargetUser,
+			TargetIDs:    []string{"userid"},
+			Topic:        "topic",
+			Notification: map[string]interface{}{"acme": "interesting"},
+			SendAt:       time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC),
+			LocalTime:    true,
+			TimeZone:     "Asia/Hong_Kong",
+			Status:       skydb.ScheduledPushPending,
+			CreatedAt:    time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		Convey("saves and gets a ScheduledPush", func() {
+			So(c.SaveScheduledPush(&push), ShouldBeNil)
+
+			fetched := skydb.ScheduledPush{}
+			So(c.GetScheduledPush("pushid", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, skydb.ScheduledPush{
+				ID:            "pushid",
+				TargetType:    skydb.PushTargetUser,
+				TargetIDs:     []string{"userid"},
+				Topic:         "topic",
+				Notification:  map[string]interface{}{"acme": "interesting"},
+				SendAt:        time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC),
+				LocalTime:     true,
+				TimeZone:      "Asia/Hong_Kong",
+				Status:        skydb.ScheduledPushPending,
+				SentDeviceIDs: []string{},
+				CreatedAt:     time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+			})
+		})
+
+		Convey("updates a ScheduledPush", func() {
+			So(c.SaveScheduledPush(&push), ShouldBeNil)
+
+			push.Status = skydb.ScheduledPushSent
+			push.SentDeviceIDs = []string{"deviceid"}
+			So(c.SaveScheduledPush(&push), ShouldBeNil)
+
+			fetched := skydb.ScheduledPush{}
+			So(c.GetScheduledPush("pushid", &fetched), ShouldBeNil)
+			So(fetched.Status, ShouldEqual, skydb.ScheduledPushSent)
+			So(fetched.SentDeviceIDs, ShouldResemble, []string{"deviceid"})
+		})
+
+		Convey("returns ErrScheduledPushNotFound when the push does not exist", func() {
+			err := c.GetScheduledPush("notexist", &skydb.ScheduledPush{})
+			So(err, ShouldEqual, skydb.ErrScheduledPushNotFound)
+		})
+	})
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/lib/pq"
 	"github.com/skygeario/skygear-server/pkg/server/logging"
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
 	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 )
 
@@ -38,7 +39,20 @@ func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (
 		return
 	}
 
-	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) {
+	// Find fields of which the constraints are changed. Constraints are
+	// not concerned if they are nil in the requested record schema.
+	constrainingSchema := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if fieldType.Constraints == nil {
+			continue
+		}
+		if remoteFieldType, ok := remoteRecordSchema[key]; ok && remoteFieldType.Constraints.Equal(fieldType.Constraints) {
+			continue
+		}
+		constrainingSchema[key] = fieldType
+	}
+
+	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) && len(constrainingSchema) == 0 {
 		// The current record schema is superset of requested record
 		// schema. There is no need to extend the schema.
 		return
@@ -54,12 +68,16 @@ func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (
 		return
 	}
 
-	// Begin transaction for schema migration
-	tx, err := db.c.db.Beginx()
-	if err != nil {
-		return
+	// Begin transaction for schema migration. If the connection is in a
+	// transaction, the migration is committed or rolled back with it.
+	tx := db.c.tx
+	if tx == nil {
+		tx, err = db.c.beginx()
+		if err != nil {
+			return
+		}
+		defer tx.Rollback()
 	}
-	defer tx.Rollback()
 
 	if len(remoteRecordSchema) == 0 {
 		if err := createTable(db.c.context, tx, db.TableName(recordType)); err != nil {
@@ -94,8 +112,17 @@ func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (
 		extended = true
 	}
 
-	if err = tx.Commit(); err != nil {
-		return false, fmt.Errorf("unable to commit transaction for Extend: %s", err)
+	for key, fieldType := range constrainingSchema {
+		if err := db.setFieldConstraints(tx, recordType, key, fieldType); err != nil {
+			return false, err
+		}
+		extended = true
+	}
+
+	if tx != db.c.tx {
+		if err = tx.Commit(); err != nil {
+			return false, fmt.Errorf("unable to commit transaction for Extend: %s", err)
+		}
 	}
 
 	delete(db.c.RecordSchema, recordType)
@@ -111,14 +138,12 @@ func (db *database) RenameSchema(recordType, oldName, newName string) error {
 	}
 
 	tableName := db.TableName(recordType)
-	oldName = pq.QuoteIdentifier(oldName)
-	newName = pq.QuoteIdentifier(newName)
 
-	stmt := fmt.Sprintf("ALTER TABLE %s RENAME %s TO %s", tableName, oldName, newName)
+	stmt := fmt.Sprintf("ALTER TABLE %s RENAME %s TO %s", tableName, pq.QuoteIdentifier(oldName), pq.QuoteIdentifier(newName))
 	if _, err := db.c.Exec(stmt); err != nil {
 		return fmt.Errorf("failed to alter table: %s", err)
 	}
-	return nil
+	return db.renameFieldConstraints(recordType, oldName, newName)
 }
 
 func (db *database) DeleteSchema(recordType, columnName string) error {
@@ -129,13 +154,12 @@ func (db *database) DeleteSchema(recordType, columnName string) error {
 	}
 
 	tableName := db.TableName(recordType)
-	columnName = pq.QuoteIdentifier(columnName)
 
-	stmt := fmt.Sprintf("ALTER TABLE %s DROP %s", tableName, columnName)
+	stmt := fmt.Sprintf("ALTER TABLE %s DROP %s", tableName, pq.QuoteIdentifier(columnName))
 	if _, err := db.c.Exec(stmt); err != nil {
 		return fmt.Errorf("failed to alter table: %s", err)
 	}
-	return nil
+	return db.deleteFieldConstraints(recordType, columnName)
 }
 
 func (db *database) GetSchema(recordType string) (skydb.RecordSchema, error) {
@@ -313,8 +337,10 @@ WHERE c.relname = $1
 		recordType, db.schemaName()).Scan(&oid)
 
 	if err == sql.ErrNoRows {
-		db.c.RecordSchema[recordType] = nil
-		logger.Debugf("Cache remoteColumnTypes %s (no table)", recordType)
+		if !db.c.readsReplica() {
+			db.c.RecordSchema[recordType] = nil
+			logger.Debugf("Cache remoteColumnTypes %s (no table)", recordType)
+		}
 		return nil, nil
 	}
 	if err != nil {
@@ -444,8 +470,29 @@ WHERE a.attrelid = $1 AND a.attnum > 0 AND NOT a.attisdropped`,
 		typemap[primaryColumn] = s
 	}
 
-	db.c.RecordSchema[recordType] = typemap
-	logger.Debugf("Cache remoteColumnTypes %s", recordType)
+	// STEP 4: Field constraints stored in _record_field_constraint
+	constraintsMap, err := db.getFieldConstraints(recordType)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"recordType": recordType,
+			"err":        err,
+		}).Errorln("Failed to query field constraints")
+
+		return nil, err
+	}
+	for field, constraints := range constraintsMap {
+		if fieldType, ok := typemap[field]; ok {
+			fieldType.Constraints = constraints
+			typemap[field] = fieldType
+		}
+	}
+
+	// A schema read from a replica may be stale, so it is not cached for
+	// the writes of the conn.
+	if !db.c.readsReplica() {
+		db.c.RecordSchema[recordType] = typemap
+		logger.Debugf("Cache remoteColumnTypes %s", recordType)
+	}
 	return typemap, nil
 }
 
@@ -479,9 +526,13 @@ func (db *database) addColumnStmt(recordType string, recordSchema skydb.RecordSc
 	return buf.String()
 }
 
+func foreignKeyConstraintName(localCol, referent, remoteCol string) string {
+	return fmt.Sprintf(`fk_%s_%s_%s`, localCol, referent, remoteCol)
+}
+
 func (db *database) writeForeignKeyConstraint(buf *bytes.Buffer, localCol, referent, remoteCol string) {
 	buf.Write([]byte(`ADD CONSTRAINT `))
-	buf.WriteString(pq.QuoteIdentifier(fmt.Sprintf(`fk_%s_%s_%s`, localCol, referent, remoteCol)))
+	buf.WriteString(pq.QuoteIdentifier(foreignKeyConstraintName(localCol, referent, remoteCol)))
 	buf.Write([]byte(` FOREIGN KEY (`))
 	buf.WriteString(pq.QuoteIdentifier(localCol))
 	buf.Write([]byte(`) REFERENCES `))
@@ -495,9 +546,13 @@ func (db *database) GetIndexesByRecordType(recordType string) (indexes map[strin
 	schemaName := db.schemaName()
 	rows, err := db.c.Queryx(`
 SELECT
-    t.relname AS table_name,
     i.relname AS index_name,
-    array_to_string(array_agg(a.attname), ',') AS column_names
+    array_to_string(
+        array_agg(a.attname ORDER BY array_position(ix.indkey::int2[], a.attnum)),
+        ','
+    ) AS column_names,
+    ix.indisunique AS is_unique,
+    COALESCE(pg_get_expr(ix.indpred, ix.indrelid), '') AS condition
 FROM
     pg_class t,
     pg_class i,
@@ -512,61 +567,183 @@ WHERE
     AND a.attrelid = t.oid
     AND a.attnum = ANY(ix.indkey)
     AND t.relkind = 'r'
-    AND ix.indisunique = TRUE
+    AND ix.indisprimary = FALSE
     AND ns.nspname = $1
     AND t.relname = $2
 GROUP BY
-    ns.nspname,
-    t.relname,
-    i.relname;`,
+    i.relname,
+    ix.indisunique,
+    ix.indpred,
+    ix.indrelid;`,
 		schemaName, recordType)
 
 	if err != nil {
 		return
 	}
+	defer rows.Close()
 
 	indexes = map[string]skydb.Index{}
 	for rows.Next() {
-		var table string
 		var name string
 		var columnNames string
-		if err = rows.Scan(&table, &name, &columnNames); err != nil {
+		var index skydb.Index
+		if err = rows.Scan(&name, &columnNames, &index.Unique, &index.Condition); err != nil {
 			return
 		}
 
-		indexes[name] = skydb.Index{
-			Fields: strings.Split(columnNames, ","),
-		}
+		index.Fields = strings.Split(columnNames, ",")
+		indexes[name] = index
 	}
 
+	err = rows.Err()
 	return
 }
 
+// SaveIndex creates an index on the table of the record type. The index
+// is built concurrently unless the connection is in a transaction, so that
+// writes to the table are not blocked while building the index.
 func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
 	logger := logging.CreateLogger(db.c.context, "skydb")
 	quotedColumns := []string{}
 	for _, col := range index.Fields {
-		quotedColumns = append(quotedColumns, fmt.Sprintf("%s", col))
+		quotedColumns = append(quotedColumns, pq.QuoteIdentifier(col))
 	}
 
-	stmt := fmt.Sprintf(`
-		ALTER TABLE "%s"."%s" ADD CONSTRAINT %s UNIQUE (%s);
-	`, db.schemaName(), recordType, indexName, strings.Join(quotedColumns, ","))
-	fmt.Println("Save Index", stmt)
-	logger.WithField("stmt", stmt).Debugln("Creating unique constraint")
+	concurrently := db.c.tx == nil
+
+	buf := bytes.Buffer{}
+	buf.WriteString("CREATE ")
+	if index.Unique {
+		buf.WriteString("UNIQUE ")
+	}
+	buf.WriteString("INDEX ")
+	if concurrently {
+		buf.WriteString("CONCURRENTLY ")
+	}
+	buf.WriteString(pq.QuoteIdentifier(indexName))
+	buf.WriteString(" ON ")
+	buf.WriteString(db.TableName(recordType))
+	buf.WriteString(" (")
+	buf.WriteString(strings.Join(quotedColumns, ","))
+	buf.WriteString(")")
+
+	if index.Predicate != nil && !index.Predicate.IsEmpty() {
+		condition, err := db.indexCondition(recordType, *index.Predicate)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(" WHERE ")
+		buf.WriteString(condition)
+	}
+
+	stmt := buf.String()
+	logger.WithField("stmt", stmt).Debugln("Creating index")
 	if _, err := db.c.Exec(stmt); err != nil {
+		if concurrently && !isDuplicateTable(err) {
+			// a failed concurrent build leaves an invalid index behind
+			db.c.Exec(fmt.Sprintf(
+				"DROP INDEX CONCURRENTLY IF EXISTS %s.%s",
+				pq.QuoteIdentifier(db.schemaName()),
+				pq.QuoteIdentifier(indexName),
+			))
+		}
 		return err
 	}
 
 	return nil
 }
 
+// indexCondition returns the condition of a partial index in SQL. The
+// values are inlined because CREATE INDEX does not accept parameters.
+func (db *database) indexCondition(recordType string, predicate skydb.Predicate) (string, error) {
+	if err := checkIndexPredicate(predicate); err != nil {
+		return "", err
+	}
+
+	factory := builder.NewSqlizerFactory(db, recordType)
+	sqlizer, err := factory.NewPredicateSqlizer(predicate)
+	if err != nil {
+		return "", err
+	}
+
+	sql, args, err := sqlizer.ToSql()
+	if err != nil {
+		return "", err
+	}
+	return builder.InlineArgs(sql, args)
+}
+
+// checkIndexPredicate returns an error if the predicate cannot be used
+// as the condition of a partial index, which can only refer to columns
+// of the indexed table.
+func checkIndexPredicate(predicate skydb.Predicate) error {
+	if predicate.Operator == skydb.Functional {
+		return skyerr.NewError(skyerr.NotSupported, "functional predicate is not supported in index")
+	}
+	if predicate.Operator == skydb.Exists {
+		return skyerr.NewError(skyerr.NotSupported, "exists predicate is not supported in index")
+	}
+
+	for _, child := range predicate.Children {
+		switch c := child.(type) {
+		case skydb.Predicate:
+			if err := checkIndexPredicate(c); err != nil {
+				return err
+			}
+		case skydb.Expression:
+			if c.IsKeyPath() && strings.Contains(c.Value.(string), ".") {
+				return skyerr.NewError(skyerr.NotSupported, "key path of referenced record is not supported in index")
+			}
+			if c.Type == skydb.Function {
+				return skyerr.NewError(skyerr.NotSupported, "function is not supported in index")
+			}
+			if c.IsSubquery() {
+				return skyerr.NewError(skyerr.NotSupported, "subquery is not supported in index")
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteIndex drops an index of the table of the record type. Indexes
+// created as unique constraints are dropped with the constraint.
 func (db *database) DeleteIndex(recordType string, indexName string) error {
 	logger := logging.CreateLogger(db.c.context, "skydb")
-	stmt := fmt.Sprintf(`
-		ALTER TABLE "%s"."%s" DROP CONSTRAINT %s;
-	`, db.schemaName(), recordType, indexName)
-	logger.WithField("stmt", stmt).Debugln("Dropping unique constraint")
+
+	var isConstraint bool
+	err := db.c.QueryRowx(`
+		SELECT EXISTS (
+			SELECT 1 FROM pg_constraint c
+			JOIN pg_namespace ns ON ns.oid = c.connamespace
+			WHERE ns.nspname = $1 AND c.conname = $2
+		)`,
+		db.schemaName(), indexName,
+	).Scan(&isConstraint)
+	if err != nil {
+		return err
+	}
+
+	var stmt string
+	if isConstraint {
+		stmt = fmt.Sprintf(
+			"ALTER TABLE %s DROP CONSTRAINT %s",
+			db.TableName(recordType),
+			pq.QuoteIdentifier(indexName),
+		)
+	} else {
+		concurrently := ""
+		if db.c.tx == nil {
+			concurrently = "CONCURRENTLY "
+		}
+		stmt = fmt.Sprintf(
+			"DROP INDEX %s%s.%s",
+			concurrently,
+			pq.QuoteIdentifier(db.schemaName()),
+			pq.QuoteIdentifier(indexName),
+		)
+	}
+
+	logger.WithField("stmt", stmt).Debugln("Dropping index")
 	if _, err := db.c.Exec(stmt); err != nil {
 		return err
 	}
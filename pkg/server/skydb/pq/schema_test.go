@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -347,6 +348,130 @@ func TestExtend(t *testing.T) {
 				So(extended, ShouldBeFalse)
 			}
 		})
+
+		Convey("sets field constraints", func() {
+			max := 10.0
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{
+					Type: skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{
+						Required: true,
+						Unique:   true,
+						Default:  "untitled",
+					},
+				},
+				"rating": skydb.FieldType{
+					Type:        skydb.TypeNumber,
+					Constraints: &skydb.FieldConstraints{Max: &max},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["title"].Constraints, ShouldResemble, &skydb.FieldConstraints{
+				Required: true,
+				Unique:   true,
+				Default:  "untitled",
+			})
+			So(schema["rating"].Constraints, ShouldResemble, &skydb.FieldConstraints{Max: &max})
+
+			c.canMigrate = false
+			extended, err = db.Extend("note", schema)
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeFalse)
+			c.canMigrate = true
+
+			_, err = c.Exec(`INSERT INTO "note" ` +
+				`(_id, _database_id, _owner_id, _created_at, _created_by, _updated_at, _updated_by, "rating") ` +
+				`VALUES ('1', '', 'owner', '1988-02-06', 'creator', '1988-02-06', 'updater', 11)`)
+			So(err, ShouldNotBeNil)
+
+			record := skydb.Record{
+				ID:      skydb.NewRecordID("note", "1"),
+				OwnerID: "owner",
+				Data:    skydb.Data{"title": "Hello"},
+			}
+			So(db.Save(&record), ShouldBeNil)
+
+			record.ID = skydb.NewRecordID("note", "2")
+			err = db.Save(&record)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ConstraintViolated)
+			So(err.(skyerr.Error).Info(), ShouldResemble, map[string]interface{}{
+				"field":      "title",
+				"constraint": "unique",
+			})
+
+			extended, err = db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{
+					Type:        skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+
+			schema, err = db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["title"].Constraints, ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+		})
+
+		Convey("sets on_delete of reference field", func() {
+			_, err := db.Extend("collection", skydb.RecordSchema{
+				"name": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Extend("note", skydb.RecordSchema{
+				"collection": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "collection",
+					Constraints:   &skydb.FieldConstraints{OnDelete: skydb.SetNullReference},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["collection"].Constraints, ShouldResemble, &skydb.FieldConstraints{
+				OnDelete: skydb.SetNullReference,
+			})
+
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("collection", "c"),
+				OwnerID: "owner",
+			}), ShouldBeNil)
+			So(db.Save(&skydb.Record{
+				ID:      skydb.NewRecordID("note", "n"),
+				OwnerID: "owner",
+				Data:    skydb.Data{"collection": skydb.NewReference("collection", "c")},
+			}), ShouldBeNil)
+
+			_, err = c.Exec(`DELETE FROM "collection" WHERE _id = 'c'`)
+			So(err, ShouldBeNil)
+
+			var collection *string
+			So(c.QueryRowx(`SELECT "collection" FROM "note" WHERE _id = 'n'`).Scan(&collection), ShouldBeNil)
+			So(collection, ShouldBeNil)
+		})
+
+		Convey("rolls back with the transaction of the connection", func() {
+			txDB := db.(*database)
+			So(txDB.Begin(), ShouldBeNil)
+
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+			So(txDB.Rollback(), ShouldBeNil)
+
+			schemas, err := db.GetRecordSchemas()
+			So(err, ShouldBeNil)
+			So(schemas, ShouldNotContainKey, "note")
+		})
 	})
 
 	Convey("RenameSchema", t, func() {
@@ -559,4 +684,209 @@ func TestExtend(t *testing.T) {
 			So(err, ShouldNotBeNil)
 		})
 	})
+
+	Convey("AlterSchemaType", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		insertContent := func(id string, content string) {
+			_, err := c.Exec(
+				`INSERT INTO "note" `+
+					`(_id, _database_id, _owner_id, _created_at, _created_by, _updated_at, _updated_by, "content") `+
+					`VALUES ($1, '', 'owner', '1988-02-06', 'creator', '1988-02-06', 'updater', $2)`,
+				id, content)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("converts string to integer", func() {
+			insertContent("1", " 42 ")
+
+			report, err := db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeInteger}, false)
+			So(err, ShouldBeNil)
+			So(report.FailedCount, ShouldEqual, 0)
+			So(report.Conversion.From, ShouldEqual, skydb.TypeString)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["content"].Type, ShouldEqual, skydb.TypeInteger)
+
+			var content int
+			So(c.QueryRowx(`SELECT "content" FROM "note" WHERE _id = '1'`).Scan(&content), ShouldBeNil)
+			So(content, ShouldEqual, 42)
+		})
+
+		Convey("reports failing records in dry run", func() {
+			insertContent("1", "42")
+			insertContent("2", "forty-two")
+			insertContent("3", "4.2?")
+
+			report, err := db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeNumber}, true)
+			So(err, ShouldBeNil)
+			So(report.FailedCount, ShouldEqual, 2)
+			So(report.FailedRecordIDs, ShouldResemble, []skydb.RecordID{
+				skydb.NewRecordID("note", "2"),
+				skydb.NewRecordID("note", "3"),
+			})
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["content"].Type, ShouldEqual, skydb.TypeString)
+		})
+
+		Convey("refuses to convert with failing records", func() {
+			insertContent("1", "forty-two")
+
+			_, err := db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeNumber}, false)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["content"].Type, ShouldEqual, skydb.TypeString)
+		})
+
+		Convey("keeps applicable constraints", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{
+					Type:        skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{Required: true, Unique: true},
+				},
+			})
+			So(err, ShouldBeNil)
+			insertContent("1", "42")
+
+			_, err = db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeInteger}, false)
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["content"].Constraints, ShouldResemble, &skydb.FieldConstraints{Required: true, Unique: true})
+		})
+
+		Convey("removes constraints not applicable to the new type", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"content": skydb.FieldType{
+					Type:        skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{Required: true, Pattern: "^[0-9]+$"},
+				},
+			})
+			So(err, ShouldBeNil)
+			insertContent("1", "42")
+
+			_, err = db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeInteger}, false)
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["content"].Constraints, ShouldBeNil)
+		})
+
+		Convey("rejects unsupported conversion", func() {
+			_, err := db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeLocation}, true)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.NotSupported)
+		})
+
+		Convey("rejects unexisting column", func() {
+			_, err := db.AlterSchemaType("note", "notExist", skydb.FieldType{Type: skydb.TypeNumber}, true)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ResourceNotFound)
+		})
+
+		Convey("should not alter column if schema is locked", func() {
+			c.canMigrate = false
+
+			_, err := db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeNumber}, false)
+			So(err, ShouldNotBeNil)
+
+			_, err = db.AlterSchemaType("note", "content", skydb.FieldType{Type: skydb.TypeNumber}, true)
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestIndex(t *testing.T) {
+	Convey("Index", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title":    skydb.FieldType{Type: skydb.TypeString},
+			"archived": skydb.FieldType{Type: skydb.TypeBoolean},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("creates and deletes multi-column index", func() {
+			err := db.SaveIndex("note", "note_title_archived_idx", skydb.Index{
+				Fields: []string{"title", "archived"},
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes["note_title_archived_idx"], ShouldResemble, skydb.Index{
+				Fields: []string{"title", "archived"},
+			})
+
+			err = db.DeleteIndex("note", "note_title_archived_idx")
+			So(err, ShouldBeNil)
+
+			indexes, err = db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldNotContainKey, "note_title_archived_idx")
+		})
+
+		Convey("creates partial unique index", func() {
+			err := db.SaveIndex("note", "note_title_key", skydb.Index{
+				Fields: []string{"title"},
+				Unique: true,
+				Predicate: &skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "archived"},
+						skydb.Expression{Type: skydb.Literal, Value: false},
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes["note_title_key"].Unique, ShouldBeTrue)
+			So(indexes["note_title_key"].Condition, ShouldEqual, "(archived = false)")
+
+			insert := `INSERT INTO "note" ` +
+				`(_id, _database_id, _owner_id, _created_at, _created_by, _updated_at, _updated_by, "title", "archived") ` +
+				`VALUES ($1, '', 'owner', '1988-02-06', 'creator', '1988-02-06', 'updater', 'hello', $2)`
+			_, err = c.Exec(insert, "1", true)
+			So(err, ShouldBeNil)
+			_, err = c.Exec(insert, "2", true)
+			So(err, ShouldBeNil)
+			_, err = c.Exec(insert, "3", false)
+			So(err, ShouldBeNil)
+			_, err = c.Exec(insert, "4", false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects index predicate on referenced record", func() {
+			err := db.SaveIndex("note", "note_title_idx", skydb.Index{
+				Fields: []string{"title"},
+				Predicate: &skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "category.name"},
+						skydb.Expression{Type: skydb.Literal, Value: "a"},
+					},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
@@ -0,0 +1,223 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"fmt"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// deletedAtColumn is the column marking when a record is soft-deleted. A
+// record type is in soft delete mode if its table has this column.
+const deletedAtColumn = "_deleted_at"
+
+func isSoftDeleteEnabled(typemap skydb.RecordSchema) bool {
+	_, ok := typemap[deletedAtColumn]
+	return ok
+}
+
+// notDeletedSqlizer returns the predicate excluding soft-deleted records
+// of the record type.
+func notDeletedSqlizer(recordType string) sq.Sqlizer {
+	return sq.Expr(fmt.Sprintf(`%s.%s IS NULL`, pq.QuoteIdentifier(recordType), pq.QuoteIdentifier(deletedAtColumn)))
+}
+
+func (db *database) SetRecordSoftDelete(recordType string, enabled bool) error {
+	if !db.c.canMigrate {
+		return skyerr.NewError(skyerr.IncompatibleSchema, "Record schema requires migration but migration is disabled.")
+	}
+
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return err
+	}
+	if typemap == nil {
+		return skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("record type %s does not exist", recordType))
+	}
+	if isSoftDeleteEnabled(typemap) == enabled {
+		return nil
+	}
+
+	tableName := db.TableName(recordType)
+	column := pq.QuoteIdentifier(deletedAtColumn)
+
+	var stmt string
+	if enabled {
+		stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s timestamp without time zone", tableName, column)
+	} else {
+		var count uint64
+		countStmt := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s IS NOT NULL", tableName, column)
+		if err := db.c.QueryRowx(countStmt).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return skyerr.NewError(
+				skyerr.IncompatibleSchema,
+				fmt.Sprintf("record type %s has %d soft-deleted records", recordType, count),
+			)
+		}
+		stmt = fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", tableName, column)
+	}
+
+	if _, err := db.c.Exec(stmt); err != nil {
+		return fmt.Errorf("failed to alter table: %s", err)
+	}
+
+	delete(db.c.RecordSchema, recordType)
+	return nil
+}
+
+// softDelete marks a record as deleted instead of removing it.
+func (db *database) softDelete(id skydb.RecordID) error {
+	builder := psql.Update(db.TableName(id.Type)).
+		Set(deletedAtColumn, timeNow()).
+		Where("_id = ?", id.Key).
+		Where("_database_id = ?", db.userID).
+		Where(pq.QuoteIdentifier(deletedAtColumn) + " IS NULL")
+
+	result, err := db.c.ExecWith(builder)
+	if err != nil {
+		return fmt.Errorf("delete %s: failed to delete record", id)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("delete %s: failed to retrieve deletion status", id)
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (db *database) Undelete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+	if !isSoftDeleteEnabled(typemap) {
+		return skydb.ErrRecordNotFound
+	}
+
+	builder := psql.Update(db.TableName(id.Type)).
+		Set(deletedAtColumn, nil).
+		Where("_id = ?", id.Key).
+		Where("_database_id = ?", db.userID).
+		Where(pq.QuoteIdentifier(deletedAtColumn) + " IS NOT NULL")
+
+	result, err := db.c.ExecWith(builder)
+	if err != nil {
+		return fmt.Errorf("undelete %s: failed to undelete record", id)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("undelete %s: failed to retrieve undeletion status", id)
+	}
+	if rowsAffected == 0 {
+		return skydb.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeDeletedRecords removes the soft-deleted records one by one, so that
+// a record referenced by other records is skipped without failing the
+// others. It cannot be called in a transaction because a failed statement
+// aborts the transaction.
+func (c *conn) PurgeDeletedRecords(deletedBefore time.Time) (uint64, error) {
+	logger := logging.CreateLogger(c.context, "skydb")
+	if c.tx != nil {
+		return 0, skydb.ErrDatabaseTxDidBegin
+	}
+
+	recordTypes := []string{}
+	rows, err := c.Queryx(`
+	SELECT table_name
+	FROM information_schema.columns
+	WHERE (table_name NOT LIKE '\_%') AND (table_schema=$1) AND (column_name=$2)
+	`, c.schemaName(), deletedAtColumn)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var recordType string
+		if err := rows.Scan(&recordType); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		recordTypes = append(recordTypes, recordType)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var purged uint64
+	for _, recordType := range recordTypes {
+		ids, err := c.deletedRecordIDs(recordType, deletedBefore)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, id := range ids {
+			_, err := c.ExecWith(psql.Delete(c.tableName(recordType)).
+				Where("_id = ? AND _database_id = ?", id[0], id[1]))
+			if isForeignKeyViolated(err) {
+				logger.WithField("record_id", skydb.NewRecordID(recordType, id[0])).
+					Infoln("Skipped purging record referenced by other records")
+				continue
+			} else if err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// deletedRecordIDs returns the pairs of _id and _database_id of records
+// soft-deleted before the specified time.
+func (c *conn) deletedRecordIDs(recordType string, deletedBefore time.Time) ([][2]string, error) {
+	stmt := fmt.Sprintf(
+		"SELECT _id, _database_id FROM %s WHERE %s < $1",
+		c.tableName(recordType),
+		pq.QuoteIdentifier(deletedAtColumn),
+	)
+	rows, err := c.Queryx(stmt, deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := [][2]string{}
+	for rows.Next() {
+		var id [2]string
+		if err := rows.Scan(&id[0], &id[1]); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
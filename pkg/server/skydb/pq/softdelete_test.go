@@ -0,0 +1,119 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSoftDelete(t *testing.T) {
+	Convey("Database", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		So(db.SetRecordSoftDelete("note", true), ShouldBeNil)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "someid"),
+			OwnerID: "user_id",
+			Data: map[string]interface{}{
+				"content": "some content",
+			},
+		}
+		So(db.Save(&record), ShouldBeNil)
+		So(db.Delete(record.ID), ShouldBeNil)
+
+		Convey("keeps the deleted record in the table", func() {
+			var count int
+			err := c.QueryRowx(`SELECT count(*) FROM note WHERE _id = 'someid' AND _deleted_at IS NOT NULL`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("excludes the deleted record from Get and Query", func() {
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.Delete(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+
+			query := skydb.Query{Type: "note"}
+			records, err := exhaustRows(db.Query(&query, &skydb.AccessControlOptions{BypassAccessControl: true}))
+			So(err, ShouldBeNil)
+			So(records, ShouldBeEmpty)
+
+			count, err := db.QueryCount(&query, &skydb.AccessControlOptions{BypassAccessControl: true})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("includes the deleted record in Query if requested", func() {
+			query := skydb.Query{Type: "note", IncludeDeleted: true}
+			records, err := exhaustRows(db.Query(&query, &skydb.AccessControlOptions{BypassAccessControl: true}))
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 1)
+			So(records[0].ID, ShouldResemble, record.ID)
+			So(records[0].DeletedAt.IsZero(), ShouldBeFalse)
+		})
+
+		Convey("undeletes the deleted record", func() {
+			So(db.Undelete(record.ID), ShouldBeNil)
+
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["content"], ShouldEqual, "some content")
+
+			So(db.Undelete(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("restores the deleted record on save", func() {
+			So(db.Save(&record), ShouldBeNil)
+
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+		})
+
+		Convey("cannot disable soft delete with deleted records", func() {
+			err := db.SetRecordSoftDelete("note", false)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+
+			So(db.Undelete(record.ID), ShouldBeNil)
+			So(db.SetRecordSoftDelete("note", false), ShouldBeNil)
+			So(db.Delete(record.ID), ShouldBeNil)
+
+			So(db.Undelete(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("purges records deleted before the specified time", func() {
+			purged, err := c.PurgeDeletedRecords(time.Now().UTC().Add(-time.Hour))
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 0)
+
+			purged, err = c.PurgeDeletedRecords(time.Now().UTC().Add(time.Hour))
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 1)
+
+			So(db.Undelete(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+	})
+}
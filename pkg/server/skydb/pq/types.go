@@ -212,6 +212,15 @@ func (s jsonSliceValue) Value() (driver.Value, error) {
 	return json.Marshal([]interface{}(s))
 }
 
+type jsonStringSliceValue []string
+
+func (s jsonStringSliceValue) Value() (driver.Value, error) {
+	if s == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal([]string(s))
+}
+
 type jsonMapValue map[string]interface{}
 
 func (m jsonMapValue) Value() (driver.Value, error) {
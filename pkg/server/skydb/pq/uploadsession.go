@@ -0,0 +1,197 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	sq "github.com/lann/squirrel"
+	"github.com/lib/pq"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/pq/builder"
+)
+
+type uploadedPartsValue []asset.UploadedPart
+
+func (p uploadedPartsValue) Value() (driver.Value, error) {
+	if p == nil {
+		return json.Marshal([]asset.UploadedPart{})
+	}
+	return json.Marshal([]asset.UploadedPart(p))
+}
+
+func (p *uploadedPartsValue) Scan(value interface{}) error {
+	data, ok := value.([]byte)
+	if value == nil || !ok {
+		*p = nil
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]asset.UploadedPart)(p))
+}
+
+func (c *conn) uploadSessionBuilder() sq.SelectBuilder {
+	return psql.Select("id", "asset_name", "content_type", "length",
+		"upload_offset", "multipart_id", "parts", "created_at", "updated_at",
+		"expires_at").
+		From(c.tableName("_upload_session"))
+}
+
+func (c *conn) doScanUploadSession(session *skydb.UploadSession, scanner sq.RowScanner) error {
+	var (
+		multipartID sql.NullString
+		parts       uploadedPartsValue
+		expiresAt   pq.NullTime
+	)
+
+	err := scanner.Scan(
+		&session.ID,
+		&session.Name,
+		&session.ContentType,
+		&session.Length,
+		&session.Offset,
+		&multipartID,
+		&parts,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	session.MultipartID = multipartID.String
+	session.Parts = []asset.UploadedPart(parts)
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.UpdatedAt = session.UpdatedAt.UTC()
+	session.ExpiresAt = time.Time{}
+	if expiresAt.Valid {
+		session.ExpiresAt = expiresAt.Time.UTC()
+	}
+	return nil
+}
+
+func (c *conn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	builder := c.uploadSessionBuilder().
+		Where("id = ?", id)
+
+	err := c.doScanUploadSession(session, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrUploadSessionNotFound
+	}
+	return err
+}
+
+func (c *conn) GetUploadSessionForUpdate(id string, session *skydb.UploadSession) error {
+	builder := c.uploadSessionBuilder().
+		Where("id = ?", id).
+		Suffix("FOR UPDATE")
+
+	err := c.doScanUploadSession(session, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrUploadSessionNotFound
+	}
+	return err
+}
+
+func (c *conn) SaveUploadSession(session *skydb.UploadSession) error {
+	if session.ID == "" || session.Name == "" {
+		return errors.New("invalid upload session: empty id or asset name")
+	}
+
+	now := time.Now()
+	createdAt := session.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+
+	pkData := map[string]interface{}{"id": session.ID}
+	data := map[string]interface{}{
+		"asset_name":    session.Name,
+		"content_type":  session.ContentType,
+		"length":        session.Length,
+		"upload_offset": session.Offset,
+		"multipart_id":  nil,
+		"parts":         uploadedPartsValue(session.Parts),
+		"created_at":    createdAt.UTC(),
+		"updated_at":    now.UTC(),
+		"expires_at":    nil,
+	}
+
+	if session.MultipartID != "" {
+		data["multipart_id"] = session.MultipartID
+	}
+	if !session.ExpiresAt.IsZero() {
+		data["expires_at"] = session.ExpiresAt.UTC()
+	}
+
+	upsert := builder.UpsertQuery(c.tableName("_upload_session"), pkData, data).
+		IgnoreKeyOnUpdate("created_at")
+	if _, err := c.ExecWith(upsert); err != nil {
+		return err
+	}
+
+	session.CreatedAt = createdAt
+	session.UpdatedAt = now
+	return nil
+}
+
+func (c *conn) DeleteUploadSession(id string) error {
+	builder := psql.Delete(c.tableName("_upload_session")).
+		Where("id = ?", id)
+
+	result, err := c.ExecWith(builder)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return skydb.ErrUploadSessionNotFound
+	}
+	return nil
+}
+
+func (c *conn) QueryExpiredUploadSessions(t time.Time) ([]skydb.UploadSession, error) {
+	builder := c.uploadSessionBuilder().
+		Where("expires_at <= ?", t.UTC()).
+		OrderBy("expires_at", "id")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []skydb.UploadSession{}
+	for rows.Next() {
+		session := skydb.UploadSession{}
+		if err := c.doScanUploadSession(&session, rows); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
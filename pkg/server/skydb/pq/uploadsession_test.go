@@ -0,0 +1,91 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUploadSession(t *testing.T) {
+	Convey("Conn", t, func() {
+		c := getTestConn(t)
+		defer cleanupConn(t, c)
+
+		session := skydb.UploadSession{
+			ChunkUpload: asset.ChunkUpload{
+				ID:          "sessionid",
+				Name:        "asset-name",
+				ContentType: "image/png",
+				Length:      1024,
+			},
+			CreatedAt: time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		Convey("saves and gets an UploadSession", func() {
+			So(c.SaveUploadSession(&session), ShouldBeNil)
+
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSession("sessionid", &fetched), ShouldBeNil)
+			So(fetched.ChunkUpload, ShouldResemble, asset.ChunkUpload{
+				ID:          "sessionid",
+				Name:        "asset-name",
+				ContentType: "image/png",
+				Length:      1024,
+				Parts:       []asset.UploadedPart{},
+			})
+			So(fetched.CreatedAt, ShouldResemble, time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC))
+		})
+
+		Convey("updates an UploadSession", func() {
+			So(c.SaveUploadSession(&session), ShouldBeNil)
+
+			session.Offset = 512
+			session.MultipartID = "multipartid"
+			session.Parts = []asset.UploadedPart{
+				{Number: 1, ETag: "etag", Size: 512},
+			}
+			So(c.SaveUploadSession(&session), ShouldBeNil)
+
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSession("sessionid", &fetched), ShouldBeNil)
+			So(fetched.Offset, ShouldEqual, 512)
+			So(fetched.MultipartID, ShouldEqual, "multipartid")
+			So(fetched.Parts, ShouldResemble, []asset.UploadedPart{
+				{Number: 1, ETag: "etag", Size: 512},
+			})
+		})
+
+		Convey("deletes an UploadSession", func() {
+			So(c.SaveUploadSession(&session), ShouldBeNil)
+			So(c.DeleteUploadSession("sessionid"), ShouldBeNil)
+
+			err := c.GetUploadSession("sessionid", &skydb.UploadSession{})
+			So(err, ShouldEqual, skydb.ErrUploadSessionNotFound)
+		})
+
+		Convey("returns ErrUploadSessionNotFound when the session does not exist", func() {
+			err := c.GetUploadSession("notexist", &skydb.UploadSession{})
+			So(err, ShouldEqual, skydb.ErrUploadSessionNotFound)
+
+			err = c.DeleteUploadSession("notexist")
+			So(err, ShouldEqual, skydb.ErrUploadSessionNotFound)
+		})
+	})
+}
@@ -417,6 +417,7 @@ func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error
 	for _, keys := range authRecordKeys {
 		requiredIndexes = append(requiredIndexes, skydb.Index{
 			Fields: keys,
+			Unique: true,
 		})
 	}
 
@@ -425,9 +426,12 @@ func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error
 		return err
 	}
 
+	// only a unique index on all rows guarantees unique auth record keys
 	indexes := []skydb.Index{}
 	for _, index := range indexesByName {
-		indexes = append(indexes, index)
+		if index.Unique && index.Condition == "" {
+			indexes = append(indexes, index)
+		}
 	}
 
 	requiredIndexesByFields := groupIndexesByFields(requiredIndexes)
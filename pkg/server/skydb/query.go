@@ -73,6 +73,7 @@ const (
 	ILike
 	In
 	Functional
+	Exists
 )
 
 // IsCompound checks whether the Operator is a compound operator, meaning the
@@ -116,6 +117,7 @@ const (
 	Literal ExpressionType = iota + 1
 	KeyPath
 	Function
+	Subquery
 )
 
 // An Expression represents value to be compared against.
@@ -167,6 +169,10 @@ func (expr Expression) IsLiteralNull() bool {
 	return expr.Value == nil
 }
 
+func (expr Expression) IsSubquery() bool {
+	return expr.Type == Subquery
+}
+
 func (expr Expression) KeyPathComponents() []string {
 	if expr.Type != KeyPath {
 		panic("expression is not a keypath")
@@ -183,6 +189,32 @@ func (expr Expression) Accept(visitor Visitor) {
 	}
 }
 
+// RecordSubquery is the value of a Subquery expression, which selects
+// the value at KeyPath of the records of another record type matching
+// Predicate.
+//
+// In an Exists predicate, KeyPath is a reference to the queried record
+// type, and the predicate matches records referenced by at least one
+// record of the subquery. On the right hand side of an In predicate,
+// the predicate matches records with a value on the left hand side
+// equal to the value at KeyPath of any record of the subquery.
+type RecordSubquery struct {
+	Type      string
+	KeyPath   string
+	Predicate Predicate
+}
+
+func (subquery RecordSubquery) validate() skyerr.Error {
+	if subquery.Type == "" || subquery.KeyPath == "" {
+		return skyerr.NewError(skyerr.RecordQueryInvalid,
+			"subquery must have record type and keypath")
+	}
+	if subquery.Predicate.IsEmpty() {
+		return nil
+	}
+	return subquery.Predicate.validate(nil)
+}
+
 // Predicate is a representation of used in query for filtering records.
 type Predicate struct {
 	Operator Operator
@@ -213,6 +245,10 @@ func (p Predicate) validate(parentPredicate *Predicate) skyerr.Error {
 		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
 			"functional predicate must have 1 operand, got %d", len(p.Children))
 	}
+	if p.Operator == Exists && len(p.Children) != 1 {
+		return skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			"exists predicate must have 1 operand, got %d", len(p.Children))
+	}
 
 	if p.Operator.IsCompound() {
 		for _, child := range p.Children {
@@ -227,12 +263,18 @@ func (p Predicate) validate(parentPredicate *Predicate) skyerr.Error {
 			}
 		}
 	} else {
-		for _, child := range p.Children {
-			_, ok := child.(Expression)
+		for i, child := range p.Children {
+			expr, ok := child.(Expression)
 			if !ok {
 				return skyerr.NewError(skyerr.RecordQueryInvalid,
 					"children of simple predicate must be an expression")
 			}
+
+			if expr.IsSubquery() {
+				if err := p.validateSubquery(i, expr); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
@@ -241,10 +283,32 @@ func (p Predicate) validate(parentPredicate *Predicate) skyerr.Error {
 		return p.validateFunctionalPredicate(parentPredicate)
 	case Equal:
 		return p.validateEqualPredicate(parentPredicate)
+	case Exists:
+		if !p.Children[0].(Expression).IsSubquery() {
+			return skyerr.NewError(skyerr.RecordQueryInvalid,
+				`exists predicate must contain subquery`)
+		}
 	}
 	return nil
 }
 
+// validateSubquery validates the subquery at the specified index of the
+// children of the predicate.
+func (p Predicate) validateSubquery(index int, expr Expression) skyerr.Error {
+	inPredicate := p.Operator == In && index == 1 && p.Children[0].(Expression).IsKeyPath()
+	if p.Operator != Exists && !inPredicate {
+		return skyerr.NewError(skyerr.NotSupported,
+			`subquery is only supported in exists predicate and on the right hand side of in predicate`)
+	}
+
+	subquery, ok := expr.Value.(RecordSubquery)
+	if !ok {
+		return skyerr.NewError(skyerr.RecordQueryInvalid,
+			"subquery expression must contain subquery")
+	}
+	return subquery.validate()
+}
+
 func (p Predicate) validateFunctionalPredicate(parentPredicate *Predicate) skyerr.Error {
 	expr := p.Children[0].(Expression)
 	if expr.Type != Function {
@@ -354,6 +418,57 @@ type Query struct {
 	GetCount     bool
 	Limit        *uint64
 	Offset       uint64
+
+	// IncludeDeleted includes soft-deleted records in the result.
+	IncludeDeleted bool
+
+	// PartitionLimit limits the number of records of each value of a
+	// field. Limit and Offset apply to the records within the limit.
+	PartitionLimit *PartitionLimit
+
+	// ReverseIncludes are the records referencing the queried records
+	// to be included in the result, keyed by the name of the transient
+	// field holding them.
+	ReverseIncludes map[string]ReverseInclude
+}
+
+// PartitionLimit limits the records of a Query to the first records of
+// each value of Field in the order of the Sorts of the Query, such as the
+// latest comments of each post.
+type PartitionLimit struct {
+	Field string
+	Limit uint64
+}
+
+// ReverseInclude specifies the records of another record type which
+// reference a queried record, such as the comments of a post.
+//
+// Unlike an included keypath in ComputedKeys, a ReverseInclude resolves
+// to a list of records.
+type ReverseInclude struct {
+	// Type is the record type of the referencing records.
+	Type string
+
+	// Field is the reference field of the referencing records.
+	Field string
+
+	Sorts []Sort
+
+	// Limit is the maximum number of records included for each queried
+	// record. All referencing records are included if Limit is nil.
+	Limit *uint64
+}
+
+// QueryExplanation describes how a Query is executed by a Database.
+type QueryExplanation struct {
+	// Statement is the statement generated for the Query, with Args
+	// bound to its placeholders.
+	Statement string
+	Args      []interface{}
+
+	// Plan is the execution plan of the statement reported by the
+	// underlying storage.
+	Plan interface{}
 }
 
 // Accept implements the Visitor pattern.
@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -214,3 +215,86 @@ func TestMalformedPredicate(t *testing.T) {
 		})
 	})
 }
+
+func TestSubqueryPredicate(t *testing.T) {
+	subquery := Expression{
+		Type: Subquery,
+		Value: RecordSubquery{
+			Type:    "comment",
+			KeyPath: "post",
+			Predicate: Predicate{
+				Operator: Equal,
+				Children: []interface{}{
+					Expression{Type: KeyPath, Value: "_owner_id"},
+					Expression{Type: Literal, Value: "user0"},
+				},
+			},
+		},
+	}
+
+	Convey("Predicate with subquery", t, func() {
+		Convey("exists", func() {
+			predicate := Predicate{
+				Operator: Exists,
+				Children: []interface{}{subquery},
+			}
+			So(predicate.Validate(), ShouldBeNil)
+		})
+
+		Convey("in", func() {
+			predicate := Predicate{
+				Operator: In,
+				Children: []interface{}{
+					Expression{Type: KeyPath, Value: "_id"},
+					subquery,
+				},
+			}
+			So(predicate.Validate(), ShouldBeNil)
+		})
+
+		Convey("exists without subquery", func() {
+			predicate := Predicate{
+				Operator: Exists,
+				Children: []interface{}{
+					Expression{Type: KeyPath, Value: "post"},
+				},
+			}
+			So(predicate.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("subquery in equal", func() {
+			predicate := Predicate{
+				Operator: Equal,
+				Children: []interface{}{
+					Expression{Type: KeyPath, Value: "_id"},
+					subquery,
+				},
+			}
+			err := predicate.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Code(), ShouldEqual, skyerr.NotSupported)
+		})
+
+		Convey("subquery with malformed predicate", func() {
+			predicate := Predicate{
+				Operator: Exists,
+				Children: []interface{}{
+					Expression{
+						Type: Subquery,
+						Value: RecordSubquery{
+							Type:    "comment",
+							KeyPath: "post",
+							Predicate: Predicate{
+								Operator: Equal,
+								Children: []interface{}{
+									Expression{Type: KeyPath, Value: "tags"},
+								},
+							},
+						},
+					},
+				},
+			}
+			So(predicate.Validate(), ShouldNotBeNil)
+		})
+	})
+}
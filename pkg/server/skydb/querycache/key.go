@@ -0,0 +1,164 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querycache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// dependencyCollector collects the record types a query depends on, which
+// are the queried record type, the record types joined through keypaths
+// and the record types of subqueries.
+//
+// A query is not cacheable if it depends on data other than records,
+// such as a user relation.
+type dependencyCollector struct {
+	db        skydb.Database
+	types     map[string]bool
+	cacheable bool
+	err       error
+}
+
+func queryDependencies(db skydb.Database, query *skydb.Query) ([]string, bool, error) {
+	c := &dependencyCollector{
+		db:        db,
+		types:     map[string]bool{},
+		cacheable: true,
+	}
+	c.query(query)
+	if c.err != nil || !c.cacheable {
+		return nil, false, c.err
+	}
+
+	types := make([]string, 0, len(c.types))
+	for recordType := range c.types {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+	return types, true, nil
+}
+
+func (c *dependencyCollector) query(query *skydb.Query) {
+	c.types[query.Type] = true
+	c.predicate(query.Type, query.Predicate)
+	for _, sort := range query.Sorts {
+		c.expression(query.Type, sort.Expression)
+	}
+	for _, expr := range query.ComputedKeys {
+		c.expression(query.Type, expr)
+	}
+}
+
+func (c *dependencyCollector) predicate(recordType string, predicate skydb.Predicate) {
+	for _, child := range predicate.Children {
+		switch child := child.(type) {
+		case skydb.Predicate:
+			c.predicate(recordType, child)
+		case skydb.Expression:
+			c.expression(recordType, child)
+		}
+	}
+}
+
+func (c *dependencyCollector) expression(recordType string, expr skydb.Expression) {
+	switch expr.Type {
+	case skydb.KeyPath:
+		keyPath, _ := expr.Value.(string)
+		c.keyPath(recordType, keyPath)
+	case skydb.Function:
+		if _, ok := expr.Value.(skydb.UserRelationFunc); ok {
+			c.cacheable = false
+			return
+		}
+		if f, ok := expr.Value.(skydb.KeyPathFunc); ok {
+			for _, keyPath := range f.ReferencedKeyPaths() {
+				c.keyPath(recordType, keyPath)
+			}
+		}
+	case skydb.Subquery:
+		subquery, _ := expr.Value.(skydb.RecordSubquery)
+		c.types[subquery.Type] = true
+		c.keyPath(subquery.Type, subquery.KeyPath)
+		c.predicate(subquery.Type, subquery.Predicate)
+	}
+}
+
+// keyPath adds the record types referenced by each component of the
+// keypath except the last one.
+func (c *dependencyCollector) keyPath(recordType string, keyPath string) {
+	components := strings.Split(keyPath, ".")
+	for _, component := range components[:len(components)-1] {
+		if c.err != nil {
+			return
+		}
+
+		schema, err := c.db.GetSchema(recordType)
+		if err != nil {
+			c.err = err
+			return
+		}
+		field, ok := schema[component]
+		if !ok || field.Type != skydb.TypeReference {
+			return
+		}
+		recordType = field.ReferenceType
+		c.types[recordType] = true
+	}
+}
+
+// aclIdentity returns the identity of the viewer the access control of
+// a query is evaluated against.
+func aclIdentity(accessControlOptions *skydb.AccessControlOptions) string {
+	if accessControlOptions == nil {
+		return ""
+	}
+	if accessControlOptions.BypassAccessControl {
+		return "_master"
+	}
+	authInfo := accessControlOptions.ViewAsUser
+	if authInfo == nil {
+		return "_anonymous"
+	}
+
+	roles := append([]string{}, authInfo.Roles...)
+	sort.Strings(roles)
+	return authInfo.ID + "/" + strings.Join(roles, ",")
+}
+
+// cacheKeySource is hashed into a cache key. Changing any of the
+// versions makes previously cached results unreachable.
+type cacheKeySource struct {
+	Kind          string            `json:"kind"`
+	DatabaseID    string            `json:"database_id"`
+	Query         *skydb.Query      `json:"query"`
+	ACLIdentity   string            `json:"acl_identity"`
+	SchemaVersion uint64            `json:"schema_version"`
+	TypeVersions  map[string]uint64 `json:"type_versions"`
+}
+
+func (s cacheKeySource) hash() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return s.Kind + ":" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,496 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package querycache caches the results of Database.Query and
+// Database.QueryCount of the public database.
+//
+// Cached results are keyed by the normalized query, the identity of the
+// viewer and the versions of the schema and of the record types the query
+// depends on. Saving or deleting a record, or receiving a record change
+// event from the Conn, increments the version of its record type so that
+// stale results are no longer reachable. Versions are incremented again
+// when a transaction ends, as results cached by other Conns before the
+// transaction is committed are stale.
+//
+// Results are neither cached nor read from cache in a transaction, after
+// the Conn has written, or when reads are routed to a read replica, which
+// may lag behind the primary. Results of a query without a limit, or with
+// a limit over maxCachedLimit, are not cached as they may be arbitrarily
+// large, nor are queries of a Conn bypassing the cache, such as when
+// exporting records.
+package querycache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+)
+
+var log = logging.LoggerEntryWithTag("skydb", "querycache")
+
+// maxCachedLimit is the maximum limit of a query whose results are cached.
+const maxCachedLimit = 1000
+
+// Cache caches query results in a Store.
+type Cache struct {
+	Store Store
+
+	// Expiry is the duration a cached result lives. Cached results do
+	// not expire if Expiry is zero.
+	Expiry time.Duration
+
+	subscribedApps map[string]bool
+	mutex          sync.Mutex
+}
+
+// NewCache creates a new Cache.
+func NewCache(store Store, expiry time.Duration) *Cache {
+	return &Cache{
+		Store:          store,
+		Expiry:         expiry,
+		subscribedApps: map[string]bool{},
+	}
+}
+
+// Opener returns a DBOpener which opens Conn with the supplied opener,
+// such that queries against the public database of the Conn are cached.
+func (c *Cache) Opener(opener skydb.DBOpener) skydb.DBOpener {
+	return func(ctx context.Context, implName string, appName string, accessString string, optionString string, config skydb.DBConfig) (skydb.Conn, error) {
+		conn, err := opener(ctx, implName, appName, accessString, optionString, config)
+		if err != nil {
+			return nil, err
+		}
+
+		c.subscribe(appName, conn)
+		return &cachedConn{
+			Conn:    conn,
+			cache:   c,
+			appName: appName,
+		}, nil
+	}
+}
+
+// subscribe invalidates the cached results of an app on the record change
+// events of the Conn. Each app is subscribed once until the driver closes
+// the channel of the events, such as when the app is removed, so that the
+// app is subscribed again if it is added back.
+func (c *Cache) subscribe(appName string, conn skydb.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.subscribedApps[appName] {
+		return
+	}
+
+	ch := make(chan skydb.RecordEvent)
+	if err := conn.Subscribe(ch); err != nil {
+		log.WithError(err).Errorln("Unable to subscribe to record change events")
+		return
+	}
+	c.subscribedApps[appName] = true
+
+	go func() {
+		for event := range ch {
+			if event.Record != nil {
+				c.invalidateRecordType(appName, event.Record.ID.Type)
+			}
+		}
+
+		c.mutex.Lock()
+		delete(c.subscribedApps, appName)
+		c.mutex.Unlock()
+	}()
+}
+
+func typeVersionName(appName string, recordType string) string {
+	return appName + ":type:" + recordType
+}
+
+func schemaVersionName(appName string) string {
+	return appName + ":schema"
+}
+
+func (c *Cache) invalidateRecordType(appName string, recordType string) {
+	if err := c.Store.IncrVersion(typeVersionName(appName, recordType)); err != nil {
+		log.WithFields(logrus.Fields{
+			"app":         appName,
+			"record_type": recordType,
+		}).WithError(err).Errorln("Unable to invalidate cached queries")
+	}
+}
+
+func (c *Cache) invalidateSchema(appName string) {
+	if err := c.Store.IncrVersion(schemaVersionName(appName)); err != nil {
+		log.WithField("app", appName).WithError(err).Errorln("Unable to invalidate cached queries")
+	}
+}
+
+// key returns the cache key of a query, or false if the query is not
+// cacheable.
+func (c *Cache) key(kind string, appName string, db skydb.Database, query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (string, bool) {
+	types, ok, err := queryDependencies(db, query)
+	if err != nil || !ok {
+		return "", false
+	}
+
+	source := cacheKeySource{
+		Kind:         kind,
+		DatabaseID:   db.ID(),
+		Query:        query,
+		ACLIdentity:  aclIdentity(accessControlOptions),
+		TypeVersions: map[string]uint64{},
+	}
+	if source.SchemaVersion, err = c.Store.Version(schemaVersionName(appName)); err != nil {
+		log.WithError(err).Errorln("Unable to get schema version")
+		return "", false
+	}
+	for _, recordType := range types {
+		version, err := c.Store.Version(typeVersionName(appName, recordType))
+		if err != nil {
+			log.WithError(err).Errorln("Unable to get record type version")
+			return "", false
+		}
+		source.TypeVersions[recordType] = version
+	}
+
+	key, err := source.hash()
+	if err != nil {
+		return "", false
+	}
+	return appName + ":" + key, true
+}
+
+func (c *Cache) get(key string, value interface{}) bool {
+	data, err := c.Store.Get(key)
+	if err != nil {
+		log.WithError(err).Errorln("Unable to get cached query result")
+		return false
+	}
+	if data == nil {
+		return false
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		log.WithError(err).Errorln("Unable to decode cached query result")
+		return false
+	}
+	return true
+}
+
+func (c *Cache) set(key string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.WithError(err).Errorln("Unable to encode query result")
+		return
+	}
+	if err := c.Store.Set(key, data, c.Expiry); err != nil {
+		log.WithError(err).Errorln("Unable to cache query result")
+	}
+}
+
+type cachedConn struct {
+	skydb.Conn
+	cache   *Cache
+	appName string
+
+	// inTx is true when a transaction is in effect.
+	inTx bool
+
+	// txTypes and txSchema are the record types and whether the schema
+	// are modified in the transaction in effect.
+	txTypes  map[string]bool
+	txSchema bool
+
+	// wrote is true if records or schema are modified through the Conn.
+	wrote bool
+
+	// replicaReads is non-zero when reads are routed to a read replica.
+	replicaReads int
+
+	// bypasses is non-zero when the cache is bypassed.
+	bypasses int
+}
+
+// RouteReadsToReplica routes the reads of the wrapped Conn to a read
+// replica, if supported, until the returned function is called.
+func (c *cachedConn) RouteReadsToReplica() func() {
+	done := skydb.RouteReadsToReplica(c.Conn)
+	c.replicaReads++
+	return func() {
+		c.replicaReads--
+		done()
+	}
+}
+
+// BypassQueryCache makes queries of the Conn not cached until the
+// returned function is called.
+func (c *cachedConn) BypassQueryCache() func() {
+	c.bypasses++
+	return func() {
+		c.bypasses--
+	}
+}
+
+// cacheable returns whether query results of the Conn may be cached or
+// read from cache.
+func (c *cachedConn) cacheable() bool {
+	return !c.inTx && !c.wrote && c.replicaReads == 0 && c.bypasses == 0
+}
+
+func (c *cachedConn) PublicDB() skydb.Database {
+	db := &cachedDatabase{
+		Database: c.Conn.PublicDB(),
+		conn:     c,
+	}
+	if tx, ok := db.Database.(skydb.Transactional); ok {
+		return &cachedTxDatabase{
+			cachedDatabase: db,
+			tx:             tx,
+		}
+	}
+	return db
+}
+
+// cachedDatabase caches the query results of the wrapped Database and
+// invalidates them when records or schema are modified through it.
+type cachedDatabase struct {
+	skydb.Database
+	conn *cachedConn
+}
+
+// queryResult is the cached result of Database.Query.
+type queryResult struct {
+	Records     []cachedRecord `json:"records"`
+	RecordCount *uint64        `json:"record_count,omitempty"`
+}
+
+type cachedRecord struct {
+	DatabaseID string              `json:"database_id"`
+	Record     *skyconv.JSONRecord `json:"record"`
+}
+
+func (db *cachedDatabase) Conn() skydb.Conn {
+	return db.conn
+}
+
+func (db *cachedDatabase) cacheKey(kind string, query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (string, bool) {
+	if !db.conn.cacheable() {
+		return "", false
+	}
+	return db.conn.cache.key(kind, db.conn.appName, db.Database, query, accessControlOptions)
+}
+
+func (db *cachedDatabase) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	if query.Limit == nil || *query.Limit > maxCachedLimit {
+		return db.Database.Query(query, accessControlOptions)
+	}
+
+	key, ok := db.cacheKey("query", query, accessControlOptions)
+	if !ok {
+		return db.Database.Query(query, accessControlOptions)
+	}
+
+	result := queryResult{}
+	if db.conn.cache.get(key, &result) {
+		return skydb.NewRows(result.rowsIter()), nil
+	}
+
+	rows, err := db.Database.Query(query, accessControlOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Scan() {
+		record := rows.Record()
+		result.Records = append(result.Records, cachedRecord{
+			DatabaseID: record.DatabaseID,
+			Record:     (*skyconv.JSONRecord)(&record),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result.RecordCount = rows.OverallRecordCount()
+
+	db.conn.cache.set(key, result)
+	return skydb.NewRows(result.rowsIter()), nil
+}
+
+func (db *cachedDatabase) QueryCount(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (uint64, error) {
+	key, ok := db.cacheKey("count", query, accessControlOptions)
+	if !ok {
+		return db.Database.QueryCount(query, accessControlOptions)
+	}
+
+	var count uint64
+	if db.conn.cache.get(key, &count) {
+		return count, nil
+	}
+
+	count, err := db.Database.QueryCount(query, accessControlOptions)
+	if err != nil {
+		return 0, err
+	}
+
+	db.conn.cache.set(key, count)
+	return count, nil
+}
+
+func (db *cachedDatabase) invalidateRecordType(recordType string) {
+	db.conn.wrote = true
+	if db.conn.inTx {
+		db.conn.txTypes[recordType] = true
+	}
+	db.conn.cache.invalidateRecordType(db.conn.appName, recordType)
+}
+
+func (db *cachedDatabase) invalidateSchema() {
+	db.conn.wrote = true
+	if db.conn.inTx {
+		db.conn.txSchema = true
+	}
+	db.conn.cache.invalidateSchema(db.conn.appName)
+}
+
+func (db *cachedDatabase) Save(record *skydb.Record) error {
+	defer db.invalidateRecordType(record.ID.Type)
+	return db.Database.Save(record)
+}
+
+func (db *cachedDatabase) Delete(id skydb.RecordID) error {
+	defer db.invalidateRecordType(id.Type)
+	return db.Database.Delete(id)
+}
+
+func (db *cachedDatabase) Undelete(id skydb.RecordID) error {
+	defer db.invalidateRecordType(id.Type)
+	return db.Database.Undelete(id)
+}
+
+func (db *cachedDatabase) Extend(recordType string, schema skydb.RecordSchema) (bool, error) {
+	extended, err := db.Database.Extend(recordType, schema)
+	if extended {
+		db.invalidateSchema()
+	}
+	return extended, err
+}
+
+func (db *cachedDatabase) RenameSchema(recordType, oldColumnName, newColumnName string) error {
+	defer db.invalidateSchema()
+	return db.Database.RenameSchema(recordType, oldColumnName, newColumnName)
+}
+
+func (db *cachedDatabase) DeleteSchema(recordType, columnName string) error {
+	defer db.invalidateSchema()
+	return db.Database.DeleteSchema(recordType, columnName)
+}
+
+func (db *cachedDatabase) AlterSchemaType(recordType, columnName string, fieldType skydb.FieldType, dryRun bool) (skydb.TypeConversionReport, error) {
+	if !dryRun {
+		defer db.invalidateSchema()
+	}
+	return db.Database.AlterSchemaType(recordType, columnName, fieldType, dryRun)
+}
+
+func (db *cachedDatabase) SetRecordSoftDelete(recordType string, enabled bool) error {
+	defer db.invalidateSchema()
+	return db.Database.SetRecordSoftDelete(recordType, enabled)
+}
+
+// cachedTxDatabase is a cachedDatabase wrapping a transactional Database.
+type cachedTxDatabase struct {
+	*cachedDatabase
+	tx skydb.Transactional
+}
+
+func (db *cachedTxDatabase) Begin() error {
+	if err := db.tx.Begin(); err != nil {
+		return err
+	}
+	db.conn.inTx = true
+	db.conn.txTypes = map[string]bool{}
+	db.conn.txSchema = false
+	return nil
+}
+
+func (db *cachedTxDatabase) Commit() error {
+	defer db.endTx()
+	return db.tx.Commit()
+}
+
+func (db *cachedTxDatabase) Rollback() error {
+	defer db.endTx()
+	return db.tx.Rollback()
+}
+
+// endTx invalidates the record types and schema modified in the
+// transaction again, because other Conns may have cached the results
+// committed before the transaction under the versions incremented in
+// the transaction.
+func (db *cachedTxDatabase) endTx() {
+	conn := db.conn
+	conn.inTx = false
+	for recordType := range conn.txTypes {
+		conn.cache.invalidateRecordType(conn.appName, recordType)
+	}
+	if conn.txSchema {
+		conn.cache.invalidateSchema(conn.appName)
+	}
+	conn.txTypes = nil
+	conn.txSchema = false
+}
+
+// rowsIter returns a RowsIter enumerating the records of the result.
+func (result queryResult) rowsIter() skydb.RowsIter {
+	records := make([]skydb.Record, len(result.Records))
+	for i, cached := range result.Records {
+		records[i] = skydb.Record(*cached.Record)
+		records[i].DatabaseID = cached.DatabaseID
+	}
+	return &resultRows{
+		records:     records,
+		recordCount: result.RecordCount,
+	}
+}
+
+// resultRows implements skydb.RowsIter for cached query results.
+type resultRows struct {
+	records     []skydb.Record
+	index       int
+	recordCount *uint64
+}
+
+func (rs *resultRows) Close() error {
+	return nil
+}
+
+func (rs *resultRows) Next(record *skydb.Record) error {
+	if rs.index >= len(rs.records) {
+		return io.EOF
+	}
+	*record = rs.records[rs.index]
+	rs.index++
+	return nil
+}
+
+func (rs *resultRows) OverallRecordCount() *uint64 {
+	return rs.recordCount
+}
@@ -0,0 +1,384 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querycache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeConn struct {
+	db      *fakeDatabase
+	eventCh chan skydb.RecordEvent
+	skydb.Conn
+}
+
+func (c *fakeConn) PublicDB() skydb.Database {
+	return c.db
+}
+
+func (c *fakeConn) PrivateDB(userKey string) skydb.Database {
+	return c.db
+}
+
+func (c *fakeConn) Subscribe(ch chan skydb.RecordEvent) error {
+	c.eventCh = ch
+	return nil
+}
+
+type fakeDatabase struct {
+	records    []skydb.Record
+	schemas    map[string]skydb.RecordSchema
+	queryCount int
+	countCount int
+	began      bool
+	skydb.Database
+}
+
+func (db *fakeDatabase) ID() string {
+	return skydb.PublicDatabaseIdentifier
+}
+
+func (db *fakeDatabase) GetSchema(recordType string) (skydb.RecordSchema, error) {
+	return db.schemas[recordType], nil
+}
+
+func (db *fakeDatabase) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	db.queryCount++
+	return skydb.NewRows(skydb.NewMemoryRows(db.records)), nil
+}
+
+func (db *fakeDatabase) QueryCount(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (uint64, error) {
+	db.countCount++
+	return uint64(len(db.records)), nil
+}
+
+func (db *fakeDatabase) Save(record *skydb.Record) error {
+	return nil
+}
+
+func (db *fakeDatabase) Begin() error {
+	db.began = true
+	return nil
+}
+
+func (db *fakeDatabase) Commit() error {
+	db.began = false
+	return nil
+}
+
+func (db *fakeDatabase) Rollback() error {
+	db.began = false
+	return nil
+}
+
+func TestCache(t *testing.T) {
+	Convey("Cache", t, func() {
+		underlyingDB := &fakeDatabase{
+			records: []skydb.Record{
+				{
+					ID:        skydb.NewRecordID("comment", "comment1"),
+					OwnerID:   "user0",
+					CreatedAt: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+					ACL:       skydb.RecordACL{skydb.NewRecordACLEntryPublic(skydb.ReadLevel)},
+					Data: skydb.Data{
+						"content": "hello",
+						"post":    skydb.NewReference("post", "post1"),
+					},
+				},
+			},
+			schemas: map[string]skydb.RecordSchema{
+				"comment": skydb.RecordSchema{
+					"content": skydb.FieldType{Type: skydb.TypeString},
+					"post": skydb.FieldType{
+						Type:          skydb.TypeReference,
+						ReferenceType: "post",
+					},
+				},
+				"post": skydb.RecordSchema{
+					"title": skydb.FieldType{Type: skydb.TypeString},
+				},
+			},
+		}
+		underlyingConn := &fakeConn{db: underlyingDB}
+		opener := func(ctx context.Context, implName string, appName string, accessString string, optionString string, config skydb.DBConfig) (skydb.Conn, error) {
+			return underlyingConn, nil
+		}
+
+		cache := NewCache(NewMemoryStore(), time.Minute)
+		conn, err := cache.Opener(opener)(context.Background(), "fake", "app", "", "", skydb.DBConfig{})
+		So(err, ShouldBeNil)
+		db := conn.PublicDB()
+		So(db.Conn(), ShouldEqual, conn)
+
+		limit := uint64(10)
+		query := &skydb.Query{Type: "comment", Limit: &limit}
+		aco := &skydb.AccessControlOptions{
+			ViewAsUser: &skydb.AuthInfo{ID: "user0"},
+		}
+		queryRecords := func(query *skydb.Query, aco *skydb.AccessControlOptions) []skydb.Record {
+			rows, err := db.Query(query, aco)
+			So(err, ShouldBeNil)
+			records := []skydb.Record{}
+			for rows.Scan() {
+				records = append(records, rows.Record())
+			}
+			So(rows.Err(), ShouldBeNil)
+			return records
+		}
+
+		Convey("caches query results", func() {
+			So(queryRecords(query, aco), ShouldResemble, underlyingDB.records)
+			So(queryRecords(query, aco), ShouldResemble, underlyingDB.records)
+			So(underlyingDB.queryCount, ShouldEqual, 1)
+		})
+
+		Convey("caches query count", func() {
+			count, err := db.QueryCount(query, aco)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			count, err = db.QueryCount(query, aco)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			So(underlyingDB.countCount, ShouldEqual, 1)
+		})
+
+		Convey("keys results by viewer", func() {
+			queryRecords(query, aco)
+			queryRecords(query, &skydb.AccessControlOptions{
+				ViewAsUser: &skydb.AuthInfo{ID: "user0", Roles: []string{"admin"}},
+			})
+			queryRecords(query, &skydb.AccessControlOptions{BypassAccessControl: true})
+			So(underlyingDB.queryCount, ShouldEqual, 3)
+		})
+
+		Convey("invalidates on save", func() {
+			queryRecords(query, aco)
+			So(db.Save(&skydb.Record{ID: skydb.NewRecordID("comment", "comment2")}), ShouldBeNil)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+
+		Convey("invalidates on record change event", func() {
+			queryRecords(query, aco)
+			underlyingConn.eventCh <- skydb.RecordEvent{
+				Record: &skydb.Record{ID: skydb.NewRecordID("comment", "comment2")},
+				Event:  skydb.RecordCreated,
+			}
+			So(func() uint64 {
+				for i := 0; i < 100; i++ {
+					version, _ := cache.Store.Version(typeVersionName("app", "comment"))
+					if version > 0 {
+						return version
+					}
+					time.Sleep(time.Millisecond)
+				}
+				return 0
+			}(), ShouldEqual, 1)
+
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+
+		Convey("subscribes again after the events are closed", func() {
+			closed := underlyingConn.eventCh
+			close(closed)
+			So(func() bool {
+				for i := 0; i < 100; i++ {
+					_, err := cache.Opener(opener)(context.Background(), "fake", "app", "", "", skydb.DBConfig{})
+					So(err, ShouldBeNil)
+					if underlyingConn.eventCh != closed {
+						return true
+					}
+					time.Sleep(time.Millisecond)
+				}
+				return false
+			}(), ShouldBeTrue)
+		})
+
+		Convey("invalidates on change of record type joined by keypath", func() {
+			query := &skydb.Query{
+				Type: "comment",
+				Predicate: skydb.Predicate{
+					Operator: skydb.Equal,
+					Children: []interface{}{
+						skydb.Expression{Type: skydb.KeyPath, Value: "post.title"},
+						skydb.Expression{Type: skydb.Literal, Value: "hello"},
+					},
+				},
+				Limit: &limit,
+			}
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 1)
+
+			So(db.Save(&skydb.Record{ID: skydb.NewRecordID("post", "post1")}), ShouldBeNil)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+
+		Convey("does not cache query with user relation", func() {
+			query := &skydb.Query{
+				Type: "comment",
+				Predicate: skydb.Predicate{
+					Operator: skydb.Functional,
+					Children: []interface{}{
+						skydb.Expression{
+							Type: skydb.Function,
+							Value: skydb.UserRelationFunc{
+								KeyPath:           "_owner",
+								RelationName:      "_friend",
+								RelationDirection: "outward",
+								User:              "user0",
+							},
+						},
+					},
+				},
+				Limit: &limit,
+			}
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+
+		Convey("does not cache query in transaction", func() {
+			txDB, ok := db.(skydb.Transactional)
+			So(ok, ShouldBeTrue)
+			So(txDB.Begin(), ShouldBeNil)
+			So(underlyingDB.began, ShouldBeTrue)
+
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+
+			So(txDB.Commit(), ShouldBeNil)
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 3)
+		})
+
+		Convey("invalidates again after commit", func() {
+			otherConn, err := cache.Opener(opener)(context.Background(), "fake", "app", "", "", skydb.DBConfig{})
+			So(err, ShouldBeNil)
+			otherDB := otherConn.PublicDB()
+
+			txDB := db.(skydb.Transactional)
+			So(txDB.Begin(), ShouldBeNil)
+			So(db.Save(&skydb.Record{ID: skydb.NewRecordID("comment", "comment2")}), ShouldBeNil)
+
+			_, err = otherDB.Query(query, aco)
+			So(err, ShouldBeNil)
+			_, err = otherDB.Query(query, aco)
+			So(err, ShouldBeNil)
+			So(underlyingDB.queryCount, ShouldEqual, 1)
+
+			So(txDB.Commit(), ShouldBeNil)
+			_, err = otherDB.Query(query, aco)
+			So(err, ShouldBeNil)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+
+		Convey("does not cache query after write", func() {
+			So(db.Save(&skydb.Record{ID: skydb.NewRecordID("post", "post1")}), ShouldBeNil)
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+
+		Convey("does not cache query routed to replica", func() {
+			done := skydb.RouteReadsToReplica(conn)
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+
+			done()
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 3)
+		})
+
+		Convey("does not cache query without limit or with large limit", func() {
+			queryRecords(&skydb.Query{Type: "comment"}, aco)
+			queryRecords(&skydb.Query{Type: "comment"}, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+
+			largeLimit := uint64(maxCachedLimit + 1)
+			queryRecords(&skydb.Query{Type: "comment", Limit: &largeLimit}, aco)
+			queryRecords(&skydb.Query{Type: "comment", Limit: &largeLimit}, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 4)
+		})
+
+		Convey("does not cache query bypassing the cache", func() {
+			done := skydb.BypassQueryCache(conn)
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+
+			done()
+			queryRecords(query, aco)
+			queryRecords(query, aco)
+			So(underlyingDB.queryCount, ShouldEqual, 3)
+		})
+
+		Convey("does not cache private database", func() {
+			privateDB := conn.PrivateDB("user0")
+			_, err := privateDB.Query(query, aco)
+			So(err, ShouldBeNil)
+			_, err = privateDB.Query(query, aco)
+			So(err, ShouldBeNil)
+			So(underlyingDB.queryCount, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	Convey("MemoryStore", t, func() {
+		store := NewMemoryStore()
+
+		Convey("gets and sets value", func() {
+			value, err := store.Get("key")
+			So(err, ShouldBeNil)
+			So(value, ShouldBeNil)
+
+			So(store.Set("key", []byte("value"), time.Minute), ShouldBeNil)
+			value, err = store.Get("key")
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, []byte("value"))
+		})
+
+		Convey("expires value", func() {
+			So(store.Set("key", []byte("value"), time.Nanosecond), ShouldBeNil)
+			time.Sleep(time.Millisecond)
+			value, err := store.Get("key")
+			So(err, ShouldBeNil)
+			So(value, ShouldBeNil)
+		})
+
+		Convey("increments version", func() {
+			version, err := store.Version("name")
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 0)
+
+			So(store.IncrVersion("name"), ShouldBeNil)
+			version, err = store.Version("name")
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,209 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package querycache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Store persists the cached query results and the versions that cache
+// keys are derived from.
+type Store interface {
+	// Get returns the value of the key, or nil if the key does not
+	// exist or has expired.
+	Get(key string) ([]byte, error)
+
+	// Set sets the value of the key. The key does not expire if expiry
+	// is zero.
+	Set(key string, value []byte, expiry time.Duration) error
+
+	// Version returns the current version of the name, which is zero
+	// before IncrVersion is called with the name.
+	Version(name string) (uint64, error)
+
+	// IncrVersion increments the version of the name.
+	IncrVersion(name string) error
+}
+
+// maxMemoryEntries is the maximum number of values kept in a MemoryStore.
+const maxMemoryEntries = 10000
+
+type memoryEntry struct {
+	value    []byte
+	expireAt time.Time
+}
+
+// MemoryStore implements Store in memory of the current process.
+type MemoryStore struct {
+	entries  map[string]memoryEntry
+	versions map[string]uint64
+	mutex    sync.Mutex
+}
+
+// NewMemoryStore creates a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:  map[string]memoryEntry{},
+		versions: map[string]uint64{},
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !entry.expireAt.IsZero() && !entry.expireAt.After(time.Now()) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+// Set implements Store.
+func (s *MemoryStore) Set(key string, value []byte, expiry time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= maxMemoryEntries {
+		s.evict()
+	}
+
+	entry := memoryEntry{value: value}
+	if expiry > 0 {
+		entry.expireAt = time.Now().Add(expiry)
+	}
+	s.entries[key] = entry
+	return nil
+}
+
+// evict removes the expired entries, or an arbitrary entry if none of
+// the entries has expired.
+func (s *MemoryStore) evict() {
+	now := time.Now()
+	for key, entry := range s.entries {
+		if !entry.expireAt.IsZero() && !entry.expireAt.After(now) {
+			delete(s.entries, key)
+		}
+	}
+	if len(s.entries) < maxMemoryEntries {
+		return
+	}
+	for key := range s.entries {
+		delete(s.entries, key)
+		return
+	}
+}
+
+// Version implements Store.
+func (s *MemoryStore) Version(name string) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.versions[name], nil
+}
+
+// IncrVersion implements Store.
+func (s *MemoryStore) IncrVersion(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.versions[name]++
+	return nil
+}
+
+// RedisStore implements Store in a redis server, which can be shared
+// among multiple server processes.
+type RedisStore struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedisStore creates a redis query cache store.
+//
+// address is url to the redis server
+//
+// prefix is a string prepending to the keys in redis
+func NewRedisStore(address string, prefix string) *RedisStore {
+	store := RedisStore{}
+
+	if prefix != "" {
+		store.prefix = prefix + ":"
+	}
+
+	store.pool = &redis.Pool{
+		MaxIdle: 50,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(address)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	return &store
+}
+
+// Get implements Store.
+func (s *RedisStore) Get(key string) ([]byte, error) {
+	c := s.pool.Get()
+	defer c.Close()
+
+	value, err := redis.Bytes(c.Do("GET", s.prefix+"query:"+key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return value, err
+}
+
+// Set implements Store.
+func (s *RedisStore) Set(key string, value []byte, expiry time.Duration) error {
+	c := s.pool.Get()
+	defer c.Close()
+
+	args := redis.Args{}.Add(s.prefix+"query:"+key, value)
+	if expiry > 0 {
+		args = args.Add("PX", int64(expiry/time.Millisecond))
+	}
+	_, err := c.Do("SET", args...)
+	return err
+}
+
+// Version implements Store.
+func (s *RedisStore) Version(name string) (uint64, error) {
+	c := s.pool.Get()
+	defer c.Close()
+
+	version, err := redis.Uint64(c.Do("GET", s.prefix+"version:"+name))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// IncrVersion implements Store.
+func (s *RedisStore) IncrVersion(name string) error {
+	c := s.pool.Get()
+	defer c.Close()
+
+	_, err := c.Do("INCR", s.prefix+"version:"+name)
+	return err
+}
@@ -171,6 +171,7 @@ type Record struct {
 	CreatorID  string
 	UpdatedAt  time.Time
 	UpdaterID  string
+	DeletedAt  time.Time
 	ACL        RecordACL
 	Data       Data
 	Transient  Data `json:"-"`
@@ -211,6 +212,8 @@ func (r *Record) Get(key string) interface{} {
 			return r.UpdatedAt
 		case "_updated_by":
 			return r.UpdaterID
+		case "_deleted_at":
+			return r.DeletedAt
 		case "_transient":
 			return r.Transient
 		default:
@@ -249,6 +252,8 @@ func (r *Record) Set(key string, i interface{}) {
 			r.UpdatedAt = i.(time.Time)
 		case "_updated_by":
 			r.UpdaterID = i.(string)
+		case "_deleted_at":
+			r.DeletedAt = i.(time.Time)
 		case "_transient":
 			r.Transient = i.(Data)
 		default:
@@ -317,6 +322,10 @@ func (r *Record) Copy() Record {
 }
 
 // Apply modifies the content of the record with the specified record.
+//
+// A FieldOperation in the data of the specified record is applied on
+// the current value of the field. The field is left unchanged if the
+// operation cannot be applied.
 func (r *Record) Apply(src *Record) {
 	r.ACL = src.ACL
 
@@ -325,6 +334,13 @@ func (r *Record) Apply(src *Record) {
 			r.Data = Data{}
 		}
 		for key, value := range src.Data {
+			if op, ok := value.(FieldOperation); ok {
+				applied, err := op.Apply(r.Data[key])
+				if err != nil {
+					continue
+				}
+				value = applied
+			}
 			r.Data[key] = value
 		}
 	}
@@ -359,9 +375,21 @@ func (r *Record) SanitizeForInput() {
 	r.Transient = nil
 }
 
-// Index indicates the value of fields within a record type cannot be duplicated
+// Index is an index on fields of a record type.
 type Index struct {
 	Fields []string
+
+	// Unique indicates the value of fields within a record type cannot
+	// be duplicated.
+	Unique bool
+
+	// Predicate limits the index to records matching the predicate. It is
+	// only used when saving an index.
+	Predicate *Predicate
+
+	// Condition is the condition of a partial index as reported by the
+	// database. It is only set on indexes returned by the database.
+	Condition string
 }
 
 // RecordSchema is a mapping of record key to its value's data type or reference
@@ -406,12 +434,22 @@ func (schema RecordSchema) HasFields(fields []string) bool {
 	return true
 }
 
+// SoftDeleteEnabled returns whether soft delete is enabled for the record
+// type, if the schema is returned by Database.RemoteColumnTypes.
+func (schema RecordSchema) SoftDeleteEnabled() bool {
+	return schema.HasField("_deleted_at")
+}
+
 // FieldType represents the kind of data living within a field of a RecordSchema.
 type FieldType struct {
 	Type           DataType
 	ReferenceType  string     // used only by TypeReference
 	Expression     Expression // used by Computed Keys
 	UnderlyingType string     // indicates the underlying (pq) type
+
+	// Constraints are the rules of the field value, nil if there is no
+	// rule or the rules are not concerned.
+	Constraints *FieldConstraints
 }
 
 // DefinitionCompatibleTo returns if a value of the specified FieldType can
@@ -610,6 +648,8 @@ func DeriveFieldType(value interface{}) (fieldType FieldType, err error) {
 			Type:           TypeUnknown,
 			UnderlyingType: val.UnderlyingType,
 		}
+	case FieldOperation:
+		fieldType = val.FieldType()
 	}
 	return
 }
@@ -0,0 +1,84 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"reflect"
+	"time"
+)
+
+// RecordRevisionAction is the kind of change that produced a
+// RecordRevision.
+type RecordRevisionAction string
+
+// List of RecordRevisionAction
+const (
+	RecordRevisionSave   RecordRevisionAction = "save"
+	RecordRevisionDelete RecordRevisionAction = "delete"
+)
+
+// RecordFieldChange is the value of a record field before and after
+// a change.
+type RecordFieldChange struct {
+	Old interface{}
+	New interface{}
+}
+
+// RecordRevision is a snapshot of a record kept in the history of
+// a record type with history enabled.
+//
+// Version starts from 1 and is assigned by Conn.SaveRecordRevision.
+// For a delete revision, Record is the snapshot of the record before
+// it is deleted.
+type RecordRevision struct {
+	ID         string
+	RecordID   RecordID
+	DatabaseID string
+	Version    int
+	Action     RecordRevisionAction
+	Record     Record
+	Diff       map[string]RecordFieldChange
+	ActorID    string
+	CreatedAt  time.Time
+}
+
+// DiffRecordData returns the changes of data fields from oldData to
+// newData. A field absent on one side has nil as its value.
+func DiffRecordData(oldData, newData Data) map[string]RecordFieldChange {
+	diff := map[string]RecordFieldChange{}
+	for key, oldValue := range oldData {
+		newValue := newData[key]
+		if !dataValueEqual(oldValue, newValue) {
+			diff[key] = RecordFieldChange{Old: oldValue, New: newValue}
+		}
+	}
+	for key, newValue := range newData {
+		if _, ok := oldData[key]; !ok && newValue != nil {
+			diff[key] = RecordFieldChange{Old: nil, New: newValue}
+		}
+	}
+	return diff
+}
+
+// dataValueEqual compares two values of a data field. Assets are compared
+// by name so that a signer injected into either of them is ignored.
+func dataValueEqual(a, b interface{}) bool {
+	assetA, okA := a.(*Asset)
+	assetB, okB := b.(*Asset)
+	if okA && okB {
+		return assetA.Name == assetB.Name
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,62 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDiffRecordData(t *testing.T) {
+	Convey("DiffRecordData", t, func() {
+		Convey("returns changed, added and removed fields", func() {
+			diff := DiffRecordData(Data{
+				"title":   "Hello",
+				"content": "World",
+				"count":   float64(1),
+			}, Data{
+				"title": "Bonjour",
+				"count": float64(1),
+				"tags":  []interface{}{"a"},
+			})
+			So(diff, ShouldResemble, map[string]RecordFieldChange{
+				"title":   {Old: "Hello", New: "Bonjour"},
+				"content": {Old: "World", New: nil},
+				"tags":    {Old: nil, New: []interface{}{"a"}},
+			})
+		})
+
+		Convey("returns all fields of new record", func() {
+			diff := DiffRecordData(nil, Data{"title": "Hello", "empty": nil})
+			So(diff, ShouldResemble, map[string]RecordFieldChange{
+				"title": {Old: nil, New: "Hello"},
+			})
+		})
+
+		Convey("compares assets by name", func() {
+			diff := DiffRecordData(Data{
+				"image": &Asset{Name: "a.png"},
+				"file":  &Asset{Name: "b.txt"},
+			}, Data{
+				"image": &Asset{Name: "a.png", ContentType: "image/png"},
+				"file":  &Asset{Name: "c.txt"},
+			})
+			So(diff, ShouldResemble, map[string]RecordFieldChange{
+				"file": {Old: &Asset{Name: "b.txt"}, New: &Asset{Name: "c.txt"}},
+			})
+		})
+	})
+}
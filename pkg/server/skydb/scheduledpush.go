@@ -0,0 +1,138 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"time"
+)
+
+// PushTargetType is the type of recipients of a ScheduledPush.
+type PushTargetType string
+
+// See the definition of PushTargetType
+const (
+	PushTargetUser   PushTargetType = "user"
+	PushTargetDevice PushTargetType = "device"
+)
+
+// ScheduledPushStatus is the state of a ScheduledPush.
+type ScheduledPushStatus string
+
+// See the definition of ScheduledPushStatus
+const (
+	ScheduledPushPending     ScheduledPushStatus = "pending"
+	ScheduledPushDispatching ScheduledPushStatus = "dispatching"
+	ScheduledPushSent        ScheduledPushStatus = "sent"
+	ScheduledPushCancelled   ScheduledPushStatus = "cancelled"
+)
+
+// Time zone offsets in use range from UTC-12:00 to UTC+14:00. A local-time
+// push is delivered across this whole range of offsets.
+const (
+	maxTimeZoneAheadOfUTC  = 14 * time.Hour
+	maxTimeZoneBehindOfUTC = 12 * time.Hour
+)
+
+// ScheduledPush is a push notification persisted to be sent at a later time.
+//
+// If LocalTime is false, SendAt is the absolute time the notification
+// is sent to all recipients.
+//
+// If LocalTime is true, SendAt holds a wall-clock time in UTC location.
+// The notification is sent to each device when its local time reaches
+// the wall-clock time, according to the TimeZone of the device. TimeZone
+// of ScheduledPush is used for devices without a time zone.
+type ScheduledPush struct {
+	ID            string
+	TargetType    PushTargetType
+	TargetIDs     []string
+	Topic         string
+	Notification  map[string]interface{}
+	SendAt        time.Time
+	LocalTime     bool
+	TimeZone      string
+	Status        ScheduledPushStatus
+	SentDeviceIDs []string
+	CreatedAt     time.Time
+}
+
+// Location returns the fallback location of the ScheduledPush for devices
+// without a time zone.
+func (p *ScheduledPush) Location() *time.Location {
+	return loadLocation(p.TimeZone)
+}
+
+// DueAt returns the time at which the notification should be sent to
+// a device of the specified time zone.
+func (p *ScheduledPush) DueAt(timeZone string) time.Time {
+	if !p.LocalTime {
+		return p.SendAt
+	}
+
+	loc := p.Location()
+	if timeZone != "" {
+		loc = loadLocation(timeZone)
+	}
+
+	t := p.SendAt.UTC()
+	return time.Date(
+		t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+		loc,
+	).UTC()
+}
+
+// EarliestDueAt returns the earliest time at which the notification should
+// be sent to any device.
+func (p *ScheduledPush) EarliestDueAt() time.Time {
+	if !p.LocalTime {
+		return p.SendAt
+	}
+
+	return p.SendAt.Add(-maxTimeZoneAheadOfUTC)
+}
+
+// LatestDueAt returns the time after which the notification should have
+// been sent to all devices.
+func (p *ScheduledPush) LatestDueAt() time.Time {
+	if !p.LocalTime {
+		return p.SendAt
+	}
+
+	return p.SendAt.Add(maxTimeZoneBehindOfUTC)
+}
+
+// IsSentToDevice returns whether the notification has been sent to the
+// specified device.
+func (p *ScheduledPush) IsSentToDevice(deviceID string) bool {
+	for _, id := range p.SentDeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+func loadLocation(timeZone string) *time.Location {
+	if timeZone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
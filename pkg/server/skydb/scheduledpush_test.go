@@ -0,0 +1,54 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestScheduledPush(t *testing.T) {
+	Convey("ScheduledPush", t, func() {
+		Convey("is due at SendAt for all devices", func() {
+			push := ScheduledPush{
+				SendAt: time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC),
+			}
+			So(push.DueAt("Asia/Hong_Kong"), ShouldResemble, push.SendAt)
+			So(push.EarliestDueAt(), ShouldResemble, push.SendAt)
+			So(push.LatestDueAt(), ShouldResemble, push.SendAt)
+		})
+
+		Convey("is due at device local time", func() {
+			push := ScheduledPush{
+				SendAt:    time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC),
+				LocalTime: true,
+				TimeZone:  "America/New_York",
+			}
+			So(push.DueAt("Asia/Hong_Kong"), ShouldResemble, time.Date(2006, 1, 2, 1, 0, 0, 0, time.UTC))
+			So(push.DueAt(""), ShouldResemble, time.Date(2006, 1, 2, 14, 0, 0, 0, time.UTC))
+			So(push.DueAt("Unknown/Zone"), ShouldResemble, time.Date(2006, 1, 2, 9, 0, 0, 0, time.UTC))
+			So(push.EarliestDueAt(), ShouldResemble, time.Date(2006, 1, 1, 19, 0, 0, 0, time.UTC))
+			So(push.LatestDueAt(), ShouldResemble, time.Date(2006, 1, 2, 21, 0, 0, 0, time.UTC))
+		})
+
+		Convey("checks sent devices", func() {
+			push := ScheduledPush{SentDeviceIDs: []string{"device1"}}
+			So(push.IsSentToDevice("device1"), ShouldBeTrue)
+			So(push.IsSentToDevice("device2"), ShouldBeFalse)
+		})
+	})
+}
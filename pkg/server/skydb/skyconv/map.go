@@ -467,6 +467,72 @@ func (t *MapWrappedRecord) ToMap(m map[string]interface{}) {
 	m["$record"] = mm
 }
 
+// MapFieldOperation is skydb.FieldOperation that can be converted from
+// and to a map, such as {"$inc": 1}.
+type MapFieldOperation skydb.FieldOperation
+
+// FromMap implements FromMapper
+func (op *MapFieldOperation) FromMap(m map[string]interface{}) error {
+	if len(m) != 1 {
+		return errors.New("field operation expects exactly one operator")
+	}
+
+	for key, value := range m {
+		if !strings.HasPrefix(key, "$") {
+			return fmt.Errorf("unknown field operator %s", key)
+		}
+		fieldOp := skydb.FieldOperation{
+			Operator: skydb.FieldOperator(key[1:]),
+			Value:    value,
+		}
+		if err := fieldOp.Validate(); err != nil {
+			return err
+		}
+		*op = MapFieldOperation(fieldOp)
+	}
+	return nil
+}
+
+// ToMap implements ToMapper
+func (op MapFieldOperation) ToMap(m map[string]interface{}) {
+	m["$"+string(op.Operator)] = ToLiteral(op.Value)
+}
+
+// isFieldOperationMap returns whether m is a single-key map keyed by a
+// field operator.
+func isFieldOperationMap(m map[string]interface{}) bool {
+	if len(m) != 1 {
+		return false
+	}
+	for key := range m {
+		switch key {
+		case "$inc", "$push", "$pull", "$max":
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFieldOperations replaces the values in data which are field
+// operations, such as {"$inc": 1}, with skydb.FieldOperation. Only the
+// top-level values are considered, so a nested dictionary is always
+// saved as is.
+func ParseFieldOperations(data map[string]interface{}) error {
+	for key, value := range data {
+		m, ok := value.(map[string]interface{})
+		if !ok || !isFieldOperationMap(m) {
+			continue
+		}
+
+		var op skydb.FieldOperation
+		if err := (*MapFieldOperation)(&op).FromMap(m); err != nil {
+			return fmt.Errorf("invalid field operation of %s: %v", key, err)
+		}
+		data[key] = op
+	}
+	return nil
+}
+
 func walkMap(m map[string]interface{}, fn func(interface{}) interface{}) map[string]interface{} {
 	for key, value := range m {
 		m[key] = fn(value)
@@ -611,6 +677,8 @@ func ToLiteral(i interface{}) interface{} {
 		return ToMap(&value)
 	case *JSONRecord:
 		return ToMap(value)
+	case skydb.FieldOperation:
+		return ToMap(MapFieldOperation(value))
 	}
 }
 
@@ -61,6 +61,9 @@ func (record *JSONRecord) ToMap(m map[string]interface{}) {
 	if record.UpdaterID != "" {
 		m["_updated_by"] = record.UpdaterID
 	}
+	if !record.DeletedAt.IsZero() {
+		m["_deleted_at"] = record.DeletedAt
+	}
 
 	transient := record.marshalTransient(record.Transient)
 	if len(transient) > 0 {
@@ -94,6 +97,7 @@ func (record *JSONRecord) FromMap(m map[string]interface{}) error {
 		creatorID        string
 		updatedAt        time.Time
 		updaterID        string
+		deletedAt        time.Time
 		dataMap          map[string]interface{}
 		transientDataMap map[string]interface{}
 	)
@@ -144,6 +148,10 @@ func (record *JSONRecord) FromMap(m map[string]interface{}) error {
 		updaterID = s
 		return nil
 	}, false)
+	extractor.DoTime("_deleted_at", func(t time.Time) error {
+		deletedAt = t
+		return nil
+	}, false)
 	extractor.DoSliceMap("_access", func(slice []map[string]interface{}) error {
 		if slice == nil {
 			return nil
@@ -180,6 +188,7 @@ func (record *JSONRecord) FromMap(m map[string]interface{}) error {
 	record.CreatorID = creatorID
 	record.UpdatedAt = updatedAt
 	record.UpdaterID = updaterID
+	record.DeletedAt = deletedAt
 	record.ACL = acl
 	record.Transient = transientDataMap
 	record.Data = dataMap
@@ -0,0 +1,144 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// ConnFunc returns a Conn to a new and empty database of a driver, and a
+// function which closes the Conn and removes the database. The Conn must
+// be allowed to migrate the database.
+type ConnFunc func(t *testing.T) (skydb.Conn, func())
+
+// RunConformanceTests runs the tests of the Conn and Database contracts
+// against the Conns returned by open, so that a skydb driver can be
+// validated by calling it from a test of the driver package:
+//
+//	func TestConformance(t *testing.T) {
+//		skydbtest.RunConformanceTests(t, func(t *testing.T) (skydb.Conn, func()) {
+//			c, err := Open(context.Background(), "app", skydb.RoleBasedAccess, option, skydb.DBConfig{
+//				CanMigrate: true,
+//			})
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return c, func() { ... }
+//		})
+//	}
+//
+// Each test opens a new database, so the tests do not depend on each
+// other.
+func RunConformanceTests(t *testing.T, open ConnFunc) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, open ConnFunc)
+	}{
+		{"Auth", testAuth},
+		{"Role", testRole},
+		{"Relation", testRelation},
+		{"RecordAccess", testRecordAccess},
+		{"Asset", testAsset},
+		{"UploadSession", testUploadSession},
+		{"Device", testDevice},
+		{"ScheduledPush", testScheduledPush},
+		{"Record", testRecord},
+		{"RecordUpdate", testRecordUpdate},
+		{"Reference", testReference},
+		{"SoftDelete", testSoftDelete},
+		{"Transaction", testTransaction},
+		{"RecordEvent", testRecordEvent},
+		{"Subscription", testSubscription},
+		{"QueryOperator", testQueryOperator},
+		{"QueryReference", testQueryReference},
+		{"QuerySort", testQuerySort},
+		{"QueryACL", testQueryACL},
+		{"Schema", testSchema},
+		{"FieldConstraint", testFieldConstraint},
+		{"Index", testIndex},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, open)
+		})
+	}
+}
+
+// recordEventTimeout is the time to wait for a record event. It is long
+// because record events may be delivered asynchronously, like those of
+// LISTEN/NOTIFY of the pq driver.
+const recordEventTimeout = 5 * time.Second
+
+// bypassAccessControl are the options of a query ignoring record ACL.
+var bypassAccessControl = &skydb.AccessControlOptions{BypassAccessControl: true}
+
+func keyPath(path string) skydb.Expression {
+	return skydb.Expression{Type: skydb.KeyPath, Value: path}
+}
+
+func literal(value interface{}) skydb.Expression {
+	return skydb.Expression{Type: skydb.Literal, Value: value}
+}
+
+func predicate(operator skydb.Operator, children ...interface{}) skydb.Predicate {
+	return skydb.Predicate{Operator: operator, Children: children}
+}
+
+// queryKeys returns the keys of the records of a query in order.
+func queryKeys(db skydb.Database, query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) ([]string, error) {
+	rows, err := db.Query(query, accessControlOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Scan() {
+		keys = append(keys, rows.Record().ID.Key)
+	}
+	return keys, rows.Err()
+}
+
+// saveRecords saves the records to the database, failing the test on
+// error.
+func saveRecords(t *testing.T, db skydb.Database, records ...skydb.Record) {
+	for i := range records {
+		if err := db.Save(&records[i]); err != nil {
+			t.Fatalf("failed to save %s: %v", records[i].ID, err)
+		}
+	}
+}
+
+// waitRecordEvent waits for the event of the record on the channel,
+// skipping the events of other records. It returns false on timeout.
+func waitRecordEvent(ch chan skydb.RecordEvent, id skydb.RecordID, event skydb.RecordHookEvent, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			if e.Record != nil && e.Record.ID == id && e.Event == event {
+				return true
+			}
+		case <-timer.C:
+			return false
+		}
+	}
+}
@@ -0,0 +1,505 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbtest
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func testAuth(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		authinfo := skydb.AuthInfo{
+			ID:             "user1",
+			HashedPassword: []byte("password"),
+			Roles:          []string{"writer"},
+		}
+		So(c.CreateAuth(&authinfo), ShouldBeNil)
+
+		Convey("gets an auth", func() {
+			fetched := skydb.AuthInfo{}
+			So(c.GetAuth("user1", &fetched), ShouldBeNil)
+			So(fetched.ID, ShouldEqual, "user1")
+			So(fetched.HashedPassword, ShouldResemble, []byte("password"))
+			So(fetched.Roles, ShouldResemble, []string{"writer"})
+		})
+
+		Convey("returns ErrUserDuplicated on creating an existing auth", func() {
+			So(c.CreateAuth(&skydb.AuthInfo{ID: "user1"}), ShouldEqual, skydb.ErrUserDuplicated)
+		})
+
+		Convey("returns ErrUserNotFound on missing auth", func() {
+			So(c.GetAuth("missing", &skydb.AuthInfo{}), ShouldEqual, skydb.ErrUserNotFound)
+			So(c.UpdateAuth(&skydb.AuthInfo{ID: "missing"}), ShouldEqual, skydb.ErrUserNotFound)
+			So(c.DeleteAuth("missing"), ShouldEqual, skydb.ErrUserNotFound)
+		})
+
+		Convey("updates an auth", func() {
+			authinfo.HashedPassword = []byte("new password")
+			authinfo.Roles = []string{"reader"}
+			So(c.UpdateAuth(&authinfo), ShouldBeNil)
+
+			fetched := skydb.AuthInfo{}
+			So(c.GetAuth("user1", &fetched), ShouldBeNil)
+			So(fetched.HashedPassword, ShouldResemble, []byte("new password"))
+			So(fetched.Roles, ShouldResemble, []string{"reader"})
+		})
+
+		Convey("deletes an auth", func() {
+			So(c.DeleteAuth("user1"), ShouldBeNil)
+			So(c.GetAuth("user1", &skydb.AuthInfo{}), ShouldEqual, skydb.ErrUserNotFound)
+		})
+	})
+}
+
+func testRole(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		for _, id := range []string{"user1", "user2"} {
+			So(c.CreateAuth(&skydb.AuthInfo{ID: id}), ShouldBeNil)
+		}
+
+		Convey("sets admin roles", func() {
+			So(c.SetAdminRoles([]string{"god", "admin"}), ShouldBeNil)
+			roles, err := c.GetAdminRoles()
+			So(err, ShouldBeNil)
+			sort.Strings(roles)
+			So(roles, ShouldResemble, []string{"admin", "god"})
+		})
+
+		Convey("sets default roles", func() {
+			So(c.SetDefaultRoles([]string{"user"}), ShouldBeNil)
+			roles, err := c.GetDefaultRoles()
+			So(err, ShouldBeNil)
+			So(roles, ShouldResemble, []string{"user"})
+		})
+
+		Convey("assigns and revokes roles", func() {
+			So(c.AssignRoles([]string{"user1", "user2"}, []string{"editor", "viewer"}), ShouldBeNil)
+			So(c.RevokeRoles([]string{"user2"}, []string{"editor"}), ShouldBeNil)
+
+			userRoles, err := c.GetRoles([]string{"user1", "user2"})
+			So(err, ShouldBeNil)
+			sort.Strings(userRoles["user1"])
+			So(userRoles["user1"], ShouldResemble, []string{"editor", "viewer"})
+			So(userRoles["user2"], ShouldResemble, []string{"viewer"})
+
+			authinfo := skydb.AuthInfo{}
+			So(c.GetAuth("user2", &authinfo), ShouldBeNil)
+			So(authinfo.Roles, ShouldResemble, []string{"viewer"})
+		})
+	})
+}
+
+func testRelation(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		for _, id := range []string{"user1", "user2", "user3"} {
+			So(c.CreateAuth(&skydb.AuthInfo{ID: id}), ShouldBeNil)
+		}
+		So(c.AddRelation("user1", "_friend", "user2"), ShouldBeNil)
+		So(c.AddRelation("user2", "_friend", "user1"), ShouldBeNil)
+		So(c.AddRelation("user1", "_friend", "user3"), ShouldBeNil)
+
+		relatedIDs := func(direction string) []string {
+			ids := []string{}
+			for _, authinfo := range c.QueryRelation("user1", "_friend", direction, skydb.QueryConfig{}) {
+				ids = append(ids, authinfo.ID)
+			}
+			return ids
+		}
+
+		Convey("queries related users", func() {
+			So(relatedIDs("outward"), ShouldResemble, []string{"user2", "user3"})
+			So(relatedIDs("inward"), ShouldResemble, []string{"user2"})
+			So(relatedIDs("mutual"), ShouldResemble, []string{"user2"})
+
+			count, err := c.QueryRelationCount("user1", "_friend", "outward")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("removes a relation", func() {
+			So(c.RemoveRelation("user2", "_friend", "user1"), ShouldBeNil)
+			So(relatedIDs("mutual"), ShouldBeEmpty)
+
+			count, err := c.QueryRelationCount("user1", "_friend", "mutual")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("returns error on relation to a missing user", func() {
+			So(c.AddRelation("user1", "_friend", "nobody"), ShouldNotBeNil)
+		})
+	})
+}
+
+func testRecordAccess(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		roles := func(acl skydb.RecordACL) []string {
+			roles := []string{}
+			for _, ace := range acl {
+				roles = append(roles, ace.Role)
+			}
+			sort.Strings(roles)
+			return roles
+		}
+
+		Convey("sets record creation access", func() {
+			So(c.SetRecordAccess("note", skydb.NewRecordACL([]skydb.RecordACLEntry{
+				skydb.NewRecordACLEntryRole("writer", skydb.CreateLevel),
+				skydb.NewRecordACLEntryRole("admin", skydb.CreateLevel),
+			})), ShouldBeNil)
+			So(c.SetRecordAccess("note", skydb.NewRecordACL([]skydb.RecordACLEntry{
+				skydb.NewRecordACLEntryRole("writer", skydb.CreateLevel),
+				skydb.NewRecordACLEntryRole("editor", skydb.CreateLevel),
+			})), ShouldBeNil)
+
+			acl, err := c.GetRecordAccess("note")
+			So(err, ShouldBeNil)
+			So(roles(acl), ShouldResemble, []string{"editor", "writer"})
+		})
+
+		Convey("sets record default access", func() {
+			So(c.SetRecordDefaultAccess("note", skydb.NewRecordACL([]skydb.RecordACLEntry{
+				skydb.NewRecordACLEntryRole("writer", skydb.WriteLevel),
+			})), ShouldBeNil)
+
+			acl, err := c.GetRecordDefaultAccess("note")
+			So(err, ShouldBeNil)
+			So(acl, ShouldHaveLength, 1)
+			So(acl[0].Role, ShouldEqual, "writer")
+			So(acl[0].Level, ShouldEqual, skydb.WriteLevel)
+		})
+
+		Convey("sets record field access", func() {
+			anyUserRole := skydb.FieldUserRole{Type: skydb.AnyUserFieldUserRoleType}
+			publicRole := skydb.FieldUserRole{Type: skydb.PublicFieldUserRoleType}
+			entries := skydb.FieldACLEntryList{
+				{
+					RecordType:  "note",
+					RecordField: "*",
+					UserRole:    publicRole,
+					Writable:    true,
+					Readable:    true,
+				},
+				{
+					RecordType:   "*",
+					RecordField:  "content",
+					UserRole:     anyUserRole,
+					Comparable:   true,
+					Discoverable: true,
+				},
+				{
+					RecordType:  "*",
+					RecordField: "*",
+					UserRole:    publicRole,
+				},
+			}
+			So(c.SetRecordFieldAccess(skydb.NewFieldACL(entries)), ShouldBeNil)
+
+			acl, err := c.GetRecordFieldAccess()
+			So(err, ShouldBeNil)
+			fetched := acl.AllEntries()
+			sort.Stable(fetched)
+			So(fetched, ShouldResemble, entries)
+		})
+	})
+}
+
+func testAsset(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		asset := skydb.Asset{
+			Name:        "picture.png",
+			ContentType: "image/png",
+			Size:        1024,
+		}
+		So(c.SaveAsset(&asset), ShouldBeNil)
+
+		Convey("gets an asset", func() {
+			fetched := skydb.Asset{}
+			So(c.GetAsset("picture.png", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, asset)
+		})
+
+		Convey("returns error on missing asset", func() {
+			So(c.GetAsset("missing.png", &skydb.Asset{}), ShouldNotBeNil)
+		})
+
+		Convey("gets existing assets of names", func() {
+			assets, err := c.GetAssets([]string{"picture.png", "missing.png"})
+			So(err, ShouldBeNil)
+			So(assets, ShouldResemble, []skydb.Asset{asset})
+		})
+
+		Convey("deletes an unreferenced asset", func() {
+			So(c.DeleteAsset("picture.png"), ShouldBeNil)
+			So(c.GetAsset("picture.png", &skydb.Asset{}), ShouldNotBeNil)
+		})
+
+		Convey("keeps an asset referenced by a record revision", func() {
+			recordID := skydb.NewRecordID("note", "deleted")
+			So(c.SaveRecordRevision(&skydb.RecordRevision{
+				RecordID:   recordID,
+				DatabaseID: c.PublicDB().ID(),
+				Action:     skydb.RecordRevisionDelete,
+				Record: skydb.Record{
+					ID: recordID,
+					Data: map[string]interface{}{
+						"image": &skydb.Asset{Name: "picture.png"},
+					},
+				},
+			}), ShouldBeNil)
+
+			assets, err := c.QueryUnreferencedAssets(time.Now().Add(time.Minute))
+			So(err, ShouldBeNil)
+			So(assets, ShouldBeEmpty)
+			So(c.DeleteAsset("picture.png"), ShouldEqual, skydb.ErrAssetReferenced)
+		})
+	})
+}
+
+func testUploadSession(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		session := skydb.UploadSession{
+			ChunkUpload: asset.ChunkUpload{
+				ID:          "session1",
+				Name:        "video.mp4",
+				ContentType: "video/mp4",
+				Length:      1024,
+				Offset:      512,
+				MultipartID: "multipart1",
+				Parts: []asset.UploadedPart{
+					{Number: 1, ETag: "etag1", Size: 512},
+				},
+			},
+			ExpiresAt: now,
+		}
+		So(c.SaveUploadSession(&session), ShouldBeNil)
+		So(c.SaveUploadSession(&skydb.UploadSession{
+			ChunkUpload: asset.ChunkUpload{
+				ID:     "session2",
+				Name:   "audio.mp3",
+				Length: 1024,
+			},
+			ExpiresAt: now.Add(time.Hour),
+		}), ShouldBeNil)
+
+		Convey("gets an upload session", func() {
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSession("session1", &fetched), ShouldBeNil)
+			So(fetched.ChunkUpload, ShouldResemble, session.ChunkUpload)
+			So(fetched.ExpiresAt, ShouldResemble, now)
+		})
+
+		Convey("gets an upload session for update in a transaction", func() {
+			db := c.PublicDB().(skydb.Transactional)
+			So(db.Begin(), ShouldBeNil)
+
+			fetched := skydb.UploadSession{}
+			So(c.GetUploadSessionForUpdate("session1", &fetched), ShouldBeNil)
+			So(fetched.ChunkUpload, ShouldResemble, session.ChunkUpload)
+			So(c.GetUploadSessionForUpdate("missing", &skydb.UploadSession{}), ShouldEqual, skydb.ErrUploadSessionNotFound)
+			So(db.Commit(), ShouldBeNil)
+		})
+
+		Convey("queries expired upload sessions", func() {
+			sessions, err := c.QueryExpiredUploadSessions(now.Add(-time.Second))
+			So(err, ShouldBeNil)
+			So(sessions, ShouldBeEmpty)
+
+			sessions, err = c.QueryExpiredUploadSessions(now)
+			So(err, ShouldBeNil)
+			So(sessions, ShouldHaveLength, 1)
+			So(sessions[0].ID, ShouldEqual, "session1")
+		})
+
+		Convey("deletes an upload session", func() {
+			So(c.DeleteUploadSession("session1"), ShouldBeNil)
+			So(c.GetUploadSession("session1", &skydb.UploadSession{}), ShouldEqual, skydb.ErrUploadSessionNotFound)
+			So(c.DeleteUploadSession("session1"), ShouldEqual, skydb.ErrUploadSessionNotFound)
+		})
+	})
+}
+
+func testDevice(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		So(c.CreateAuth(&skydb.AuthInfo{ID: "user1"}), ShouldBeNil)
+		device := skydb.Device{
+			ID:               "device1",
+			Type:             "ios",
+			Token:            "token1",
+			AuthInfoID:       "user1",
+			Topic:            "com.example.app",
+			LastRegisteredAt: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		So(c.SaveDevice(&device), ShouldBeNil)
+
+		Convey("gets a device", func() {
+			fetched := skydb.Device{}
+			So(c.GetDevice("device1", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, device)
+		})
+
+		Convey("queries devices of a user", func() {
+			devices, err := c.QueryDevicesByUser("user1")
+			So(err, ShouldBeNil)
+			So(devices, ShouldResemble, []skydb.Device{device})
+
+			devices, err = c.QueryDevicesByUserAndTopic("user1", "com.example.other")
+			So(err, ShouldBeNil)
+			So(devices, ShouldBeEmpty)
+		})
+
+		Convey("deletes a device", func() {
+			So(c.DeleteDevice("device1"), ShouldBeNil)
+			So(c.GetDevice("device1", &skydb.Device{}), ShouldEqual, skydb.ErrDeviceNotFound)
+		})
+
+		Convey("deletes devices by token", func() {
+			So(c.DeleteDevicesByToken("token1", skydb.ZeroTime), ShouldBeNil)
+			So(c.GetDevice("device1", &skydb.Device{}), ShouldEqual, skydb.ErrDeviceNotFound)
+		})
+
+		Convey("returns ErrDeviceNotFound on missing device", func() {
+			So(c.GetDevice("missing", &skydb.Device{}), ShouldEqual, skydb.ErrDeviceNotFound)
+			So(c.DeleteDevice("missing"), ShouldEqual, skydb.ErrDeviceNotFound)
+		})
+	})
+}
+
+func testScheduledPush(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		push := skydb.ScheduledPush{
+			ID:           "push1",
+			TargetType:   skydb.PushTargetUser,
+			TargetIDs:    []string{"user1"},
+			Notification: map[string]interface{}{"apns": map[string]interface{}{}},
+			SendAt:       now.Add(-time.Minute),
+			Status:       skydb.ScheduledPushPending,
+			CreatedAt:    now.Add(-time.Hour),
+		}
+		So(c.SaveScheduledPush(&push), ShouldBeNil)
+		So(c.SaveScheduledPush(&skydb.ScheduledPush{
+			ID:         "push2",
+			TargetType: skydb.PushTargetUser,
+			TargetIDs:  []string{"user1"},
+			SendAt:     now.Add(time.Hour),
+			Status:     skydb.ScheduledPushPending,
+			CreatedAt:  now.Add(-time.Hour),
+		}), ShouldBeNil)
+
+		claimIDs := func(t time.Time) []string {
+			pushes, err := c.ClaimDueScheduledPushes(t, t.Add(10*time.Minute))
+			So(err, ShouldBeNil)
+			ids := []string{}
+			for _, p := range pushes {
+				ids = append(ids, p.ID)
+			}
+			return ids
+		}
+
+		Convey("claims due pending pushes", func() {
+			So(claimIDs(now), ShouldResemble, []string{"push1"})
+
+			fetched := skydb.ScheduledPush{}
+			So(c.GetScheduledPush("push1", &fetched), ShouldBeNil)
+			So(fetched.Status, ShouldEqual, skydb.ScheduledPushDispatching)
+		})
+
+		Convey("does not claim a claimed push until the claim expires", func() {
+			So(claimIDs(now), ShouldResemble, []string{"push1"})
+			So(claimIDs(now.Add(time.Minute)), ShouldBeEmpty)
+			So(claimIDs(now.Add(10*time.Minute)), ShouldResemble, []string{"push1"})
+		})
+
+		Convey("marks devices sent and releases a claimed push", func() {
+			So(claimIDs(now), ShouldResemble, []string{"push1"})
+			So(c.MarkScheduledPushSent("push1", []string{"device1"}), ShouldBeNil)
+			So(c.ReleaseScheduledPush("push1", skydb.ScheduledPushSent, now), ShouldBeNil)
+
+			fetched := skydb.ScheduledPush{}
+			So(c.GetScheduledPush("push1", &fetched), ShouldBeNil)
+			So(fetched.SentDeviceIDs, ShouldResemble, []string{"device1"})
+			So(fetched.Status, ShouldEqual, skydb.ScheduledPushSent)
+			So(claimIDs(now.Add(time.Hour)), ShouldResemble, []string{"push2"})
+		})
+
+		Convey("claims a released pending push again when it is due", func() {
+			So(claimIDs(now), ShouldResemble, []string{"push1"})
+			So(c.ReleaseScheduledPush("push1", skydb.ScheduledPushPending, now.Add(30*time.Minute)), ShouldBeNil)
+
+			So(claimIDs(now.Add(time.Minute)), ShouldBeEmpty)
+			So(claimIDs(now.Add(30*time.Minute)), ShouldResemble, []string{"push1"})
+		})
+
+		Convey("does not cancel a sent push", func() {
+			So(claimIDs(now), ShouldResemble, []string{"push1"})
+			So(c.ReleaseScheduledPush("push1", skydb.ScheduledPushSent, now), ShouldBeNil)
+
+			So(c.CancelScheduledPush("push1", &skydb.ScheduledPush{}), ShouldEqual, skydb.ErrScheduledPushNotFound)
+			So(c.CancelScheduledPush("missing", &skydb.ScheduledPush{}), ShouldEqual, skydb.ErrScheduledPushNotFound)
+
+			fetched := skydb.ScheduledPush{}
+			So(c.GetScheduledPush("push1", &fetched), ShouldBeNil)
+			So(fetched.Status, ShouldEqual, skydb.ScheduledPushSent)
+		})
+
+		Convey("does not update a push cancelled after claimed", func() {
+			So(claimIDs(now), ShouldResemble, []string{"push1"})
+			cancelled := skydb.ScheduledPush{}
+			So(c.CancelScheduledPush("push1", &cancelled), ShouldBeNil)
+			So(cancelled.Status, ShouldEqual, skydb.ScheduledPushCancelled)
+
+			So(c.MarkScheduledPushSent("push1", []string{"device1"}), ShouldEqual, skydb.ErrScheduledPushNotFound)
+			So(c.ReleaseScheduledPush("push1", skydb.ScheduledPushSent, now), ShouldEqual, skydb.ErrScheduledPushNotFound)
+
+			fetched := skydb.ScheduledPush{}
+			So(c.GetScheduledPush("push1", &fetched), ShouldBeNil)
+			So(fetched.Status, ShouldEqual, skydb.ScheduledPushCancelled)
+			So(fetched.SentDeviceIDs, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,462 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbtest
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// saveQueryNotes saves the notes queried by the query tests.
+func saveQueryNotes(t *testing.T, db skydb.Database) {
+	if _, err := db.Extend("note", noteSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	saveRecords(t, db,
+		newNote("note1", "user1", skydb.Data{
+			"title":    "Hello World",
+			"rank":     3.0,
+			"done":     true,
+			"tags":     []interface{}{"a"},
+			"location": skydb.NewLocation(0, 0),
+		}),
+		newNote("note2", "user2", skydb.Data{
+			"title":    "hello there",
+			"rank":     1.0,
+			"done":     false,
+			"tags":     []interface{}{"b"},
+			"location": skydb.NewLocation(1, 1),
+		}),
+		newNote("note3", "user2", skydb.Data{
+			"title": "Goodbye",
+			"rank":  2.0,
+			"done":  false,
+		}),
+	)
+}
+
+// byRank sorts the records by rank, which is unique among the notes.
+var byRank = []skydb.Sort{{Expression: keyPath("rank"), Order: skydb.Ascending}}
+
+func testQueryOperator(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		saveQueryNotes(t, db)
+
+		query := func(p skydb.Predicate) []string {
+			keys, err := queryKeys(db, &skydb.Query{
+				Type:      "note",
+				Predicate: p,
+				Sorts:     byRank,
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			return keys
+		}
+
+		Convey("matches equality", func() {
+			So(query(predicate(skydb.Equal, keyPath("title"), literal("Goodbye"))), ShouldResemble, []string{"note3"})
+			So(query(predicate(skydb.NotEqual, keyPath("done"), literal(true))), ShouldResemble, []string{"note2", "note3"})
+		})
+
+		Convey("matches ordering comparisons", func() {
+			So(query(predicate(skydb.GreaterThan, keyPath("rank"), literal(1.0))), ShouldResemble, []string{"note3", "note1"})
+			So(query(predicate(skydb.GreaterThanOrEqual, keyPath("rank"), literal(2.0))), ShouldResemble, []string{"note3", "note1"})
+			So(query(predicate(skydb.LessThan, keyPath("rank"), literal(2.0))), ShouldResemble, []string{"note2"})
+			So(query(predicate(skydb.LessThanOrEqual, keyPath("rank"), literal(2.0))), ShouldResemble, []string{"note2", "note3"})
+		})
+
+		Convey("matches null", func() {
+			So(query(predicate(skydb.Equal, keyPath("tags"), literal(nil))), ShouldResemble, []string{"note3"})
+			So(query(predicate(skydb.NotEqual, keyPath("location"), literal(nil))), ShouldResemble, []string{"note2", "note1"})
+		})
+
+		Convey("matches like and ilike", func() {
+			So(query(predicate(skydb.Like, keyPath("title"), literal("hello%"))), ShouldResemble, []string{"note2"})
+			So(query(predicate(skydb.ILike, keyPath("title"), literal("hello%"))), ShouldResemble, []string{"note2", "note1"})
+		})
+
+		Convey("matches in", func() {
+			So(query(predicate(skydb.In,
+				keyPath("title"),
+				literal([]interface{}{"Goodbye", "hello there"}),
+			)), ShouldResemble, []string{"note2", "note3"})
+			So(query(predicate(skydb.In, literal("b"), keyPath("tags"))), ShouldResemble, []string{"note2"})
+		})
+
+		Convey("matches compound predicates", func() {
+			So(query(predicate(skydb.And,
+				predicate(skydb.GreaterThan, keyPath("rank"), literal(1.0)),
+				predicate(skydb.Equal, keyPath("done"), literal(true)),
+			)), ShouldResemble, []string{"note1"})
+			So(query(predicate(skydb.Or,
+				predicate(skydb.Equal, keyPath("rank"), literal(1.0)),
+				predicate(skydb.Equal, keyPath("title"), literal("Goodbye")),
+			)), ShouldResemble, []string{"note2", "note3"})
+			So(query(predicate(skydb.Not,
+				predicate(skydb.Equal, keyPath("rank"), literal(1.0)),
+			)), ShouldResemble, []string{"note3", "note1"})
+		})
+
+		Convey("matches distance", func() {
+			So(query(predicate(skydb.LessThan,
+				skydb.Expression{
+					Type: skydb.Function,
+					Value: skydb.DistanceFunc{
+						Field:    "location",
+						Location: skydb.NewLocation(0, 0.001),
+					},
+				},
+				literal(1000.0),
+			)), ShouldResemble, []string{"note1"})
+		})
+
+		Convey("counts matching records", func() {
+			count, err := db.QueryCount(&skydb.Query{
+				Type:      "note",
+				Predicate: predicate(skydb.GreaterThanOrEqual, keyPath("rank"), literal(2.0)),
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("returns error on keypath of missing field", func() {
+			_, err := queryKeys(db, &skydb.Query{
+				Type:      "note",
+				Predicate: predicate(skydb.Equal, keyPath("missing"), literal(1.0)),
+			}, bypassAccessControl)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns no records of record type not created", func() {
+			keys, err := queryKeys(db, &skydb.Query{Type: "comment"}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+
+		Convey("matches user relation", func() {
+			So(c.CreateAuth(&skydb.AuthInfo{ID: "user1"}), ShouldBeNil)
+			So(c.CreateAuth(&skydb.AuthInfo{ID: "user2"}), ShouldBeNil)
+			So(c.AddRelation("user1", "_follow", "user2"), ShouldBeNil)
+
+			So(query(predicate(skydb.Functional, skydb.Expression{
+				Type: skydb.Function,
+				Value: skydb.UserRelationFunc{
+					KeyPath:           "_owner_id",
+					RelationName:      "_follow",
+					RelationDirection: "outward",
+					User:              "user1",
+				},
+			})), ShouldResemble, []string{"note2", "note3"})
+		})
+	})
+}
+
+func testQueryReference(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		saveQueryNotes(t, db)
+		_, err := db.Extend("category", skydb.RecordSchema{
+			"name": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Extend("note", skydb.RecordSchema{
+			"category": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "category"},
+		})
+		So(err, ShouldBeNil)
+
+		saveRecords(t, db,
+			skydb.Record{ID: skydb.NewRecordID("category", "news"), OwnerID: "user1", Data: skydb.Data{"name": "News"}},
+			skydb.Record{ID: skydb.NewRecordID("category", "misc"), OwnerID: "user1", Data: skydb.Data{"name": "Misc"}},
+		)
+		for key, category := range map[string]string{"note1": "news", "note2": "misc"} {
+			note := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", key), &note), ShouldBeNil)
+			note.Data["category"] = skydb.NewReference("category", category)
+			So(db.Save(&note), ShouldBeNil)
+		}
+
+		query := func(recordType string, p skydb.Predicate, sorts []skydb.Sort) []string {
+			keys, err := queryKeys(db, &skydb.Query{
+				Type:      recordType,
+				Predicate: p,
+				Sorts:     sorts,
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			return keys
+		}
+
+		Convey("matches keypath of referenced record", func() {
+			So(query("note", predicate(skydb.Equal, keyPath("category.name"), literal("News")), byRank), ShouldResemble, []string{"note1"})
+		})
+
+		Convey("sorts by keypath of referenced record with null values last in ascending order", func() {
+			So(query("note", skydb.Predicate{}, []skydb.Sort{
+				{Expression: keyPath("category.name"), Order: skydb.Ascending},
+			}), ShouldResemble, []string{"note2", "note1", "note3"})
+			So(query("note", skydb.Predicate{}, []skydb.Sort{
+				{Expression: keyPath("category.name"), Order: skydb.Descending},
+			}), ShouldResemble, []string{"note3", "note1", "note2"})
+		})
+
+		Convey("matches in subquery", func() {
+			So(query("note", predicate(skydb.In,
+				keyPath("category"),
+				skydb.Expression{
+					Type: skydb.Subquery,
+					Value: skydb.RecordSubquery{
+						Type:      "category",
+						KeyPath:   "_id",
+						Predicate: predicate(skydb.Equal, keyPath("name"), literal("Misc")),
+					},
+				},
+			), byRank), ShouldResemble, []string{"note2"})
+		})
+
+		Convey("matches exists subquery", func() {
+			So(query("category", predicate(skydb.Exists,
+				skydb.Expression{
+					Type: skydb.Subquery,
+					Value: skydb.RecordSubquery{
+						Type:      "note",
+						KeyPath:   "category",
+						Predicate: predicate(skydb.Equal, keyPath("rank"), literal(3.0)),
+					},
+				},
+			), nil), ShouldResemble, []string{"news"})
+		})
+	})
+}
+
+func testQuerySort(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		saveQueryNotes(t, db)
+
+		Convey("sorts in ascending and descending order", func() {
+			keys, err := queryKeys(db, &skydb.Query{Type: "note", Sorts: byRank}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note2", "note3", "note1"})
+
+			keys, err = queryKeys(db, &skydb.Query{
+				Type:  "note",
+				Sorts: []skydb.Sort{{Expression: keyPath("rank"), Order: skydb.Descending}},
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note1", "note3", "note2"})
+		})
+
+		Convey("sorts by multiple keys", func() {
+			keys, err := queryKeys(db, &skydb.Query{
+				Type: "note",
+				Sorts: []skydb.Sort{
+					{Expression: keyPath("done"), Order: skydb.Ascending},
+					{Expression: keyPath("rank"), Order: skydb.Descending},
+				},
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note3", "note2", "note1"})
+		})
+
+		Convey("sorts by distance", func() {
+			keys, err := queryKeys(db, &skydb.Query{
+				Type:      "note",
+				Predicate: predicate(skydb.NotEqual, keyPath("location"), literal(nil)),
+				Sorts: []skydb.Sort{{
+					Expression: skydb.Expression{
+						Type: skydb.Function,
+						Value: skydb.DistanceFunc{
+							Field:    "location",
+							Location: skydb.NewLocation(1, 1),
+						},
+					},
+					Order: skydb.Ascending,
+				}},
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note2", "note1"})
+		})
+
+		Convey("applies limit and offset", func() {
+			limit := uint64(1)
+			keys, err := queryKeys(db, &skydb.Query{
+				Type:   "note",
+				Sorts:  byRank,
+				Limit:  &limit,
+				Offset: 1,
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note3"})
+
+			keys, err = queryKeys(db, &skydb.Query{
+				Type:   "note",
+				Sorts:  byRank,
+				Offset: 2,
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note1"})
+		})
+
+		Convey("limits records of each value of a field", func() {
+			keys, err := queryKeys(db, &skydb.Query{
+				Type:           "note",
+				Sorts:          byRank,
+				PartitionLimit: &skydb.PartitionLimit{Field: "done", Limit: 1},
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note2", "note1"})
+
+			keys, err = queryKeys(db, &skydb.Query{
+				Type: "note",
+				Sorts: []skydb.Sort{
+					{Expression: keyPath("rank"), Order: skydb.Descending},
+				},
+				PartitionLimit: &skydb.PartitionLimit{Field: "done", Limit: 1},
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note1", "note3"})
+
+			limit := uint64(1)
+			keys, err = queryKeys(db, &skydb.Query{
+				Type:           "note",
+				Sorts:          byRank,
+				PartitionLimit: &skydb.PartitionLimit{Field: "done", Limit: 2},
+				Limit:          &limit,
+				Offset:         2,
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note1"})
+		})
+
+		Convey("returns overall record count", func() {
+			limit := uint64(1)
+			rows, err := db.Query(&skydb.Query{
+				Type:     "note",
+				Sorts:    byRank,
+				Limit:    &limit,
+				GetCount: true,
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			defer rows.Close()
+
+			So(rows.Scan(), ShouldBeTrue)
+			So(rows.Record().ID.Key, ShouldEqual, "note2")
+			So(rows.OverallRecordCount(), ShouldNotBeNil)
+			So(*rows.OverallRecordCount(), ShouldEqual, 3)
+		})
+
+		Convey("returns desired keys only", func() {
+			rows, err := db.Query(&skydb.Query{
+				Type:        "note",
+				Sorts:       byRank,
+				DesiredKeys: []string{"title"},
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			defer rows.Close()
+
+			So(rows.Scan(), ShouldBeTrue)
+			record := rows.Record()
+			So(record.Data["title"], ShouldEqual, "hello there")
+			So(record.Data, ShouldNotContainKey, "rank")
+		})
+	})
+}
+
+func testQueryACL(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+
+		withACL := func(key string, ownerID string, acl skydb.RecordACL) skydb.Record {
+			record := newNote(key, ownerID, skydb.Data{"title": key})
+			record.ACL = acl
+			return record
+		}
+		saveRecords(t, db,
+			withACL("public", "user1", skydb.RecordACL{
+				skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+			}),
+			withACL("role", "user1", skydb.RecordACL{
+				skydb.NewRecordACLEntryRole("editor", skydb.ReadLevel),
+			}),
+			withACL("direct", "user1", skydb.RecordACL{
+				skydb.NewRecordACLEntryDirect("user2", skydb.ReadLevel),
+			}),
+			withACL("owned", "user2", skydb.RecordACL{}),
+			withACL("unrestricted", "user1", nil),
+		)
+
+		queryAs := func(user *skydb.AuthInfo) []string {
+			keys, err := queryKeys(db, &skydb.Query{
+				Type:  "note",
+				Sorts: []skydb.Sort{{Expression: keyPath("title"), Order: skydb.Ascending}},
+			}, &skydb.AccessControlOptions{ViewAsUser: user})
+			So(err, ShouldBeNil)
+			return keys
+		}
+
+		Convey("returns records readable by user", func() {
+			So(queryAs(&skydb.AuthInfo{ID: "user2"}), ShouldResemble, []string{"direct", "owned", "public", "unrestricted"})
+		})
+
+		Convey("returns records readable by role", func() {
+			So(queryAs(&skydb.AuthInfo{ID: "user3", Roles: []string{"editor"}}), ShouldResemble, []string{"public", "role", "unrestricted"})
+		})
+
+		Convey("returns public records to anonymous user", func() {
+			So(queryAs(nil), ShouldResemble, []string{"public", "unrestricted"})
+		})
+
+		Convey("counts records readable by user", func() {
+			count, err := db.QueryCount(&skydb.Query{Type: "note"}, &skydb.AccessControlOptions{
+				ViewAsUser: &skydb.AuthInfo{ID: "user2"},
+			})
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 4)
+		})
+
+		Convey("returns all records bypassing access control", func() {
+			keys, err := queryKeys(db, &skydb.Query{Type: "note"}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldHaveLength, 5)
+		})
+
+		Convey("does not filter records of private database", func() {
+			privateDB := c.PrivateDB("user3")
+			saveRecords(t, privateDB, withACL("private", "user3", skydb.RecordACL{}))
+			keys, err := queryKeys(privateDB, &skydb.Query{Type: "note"}, &skydb.AccessControlOptions{
+				ViewAsUser: &skydb.AuthInfo{ID: "user4"},
+			})
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"private"})
+		})
+	})
+}
@@ -0,0 +1,517 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbtest
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// noteSchema is the schema of the records saved by the tests.
+var noteSchema = skydb.RecordSchema{
+	"title":    skydb.FieldType{Type: skydb.TypeString},
+	"rank":     skydb.FieldType{Type: skydb.TypeNumber},
+	"done":     skydb.FieldType{Type: skydb.TypeBoolean},
+	"due":      skydb.FieldType{Type: skydb.TypeDateTime},
+	"tags":     skydb.FieldType{Type: skydb.TypeJSON},
+	"location": skydb.FieldType{Type: skydb.TypeLocation},
+}
+
+func newNote(key string, ownerID string, data skydb.Data) skydb.Record {
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	return skydb.Record{
+		ID:        skydb.NewRecordID("note", key),
+		OwnerID:   ownerID,
+		CreatedAt: now,
+		CreatorID: ownerID,
+		UpdatedAt: now,
+		UpdaterID: ownerID,
+		Data:      data,
+	}
+}
+
+func testRecord(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+
+		due := time.Date(2017, 2, 3, 4, 5, 6, 0, time.UTC)
+		record := newNote("note1", "user1", skydb.Data{
+			"title":    "hello",
+			"rank":     1.5,
+			"done":     true,
+			"due":      due,
+			"tags":     []interface{}{"a", "b"},
+			"location": skydb.NewLocation(1, 2),
+		})
+		record.ACL = skydb.RecordACL{
+			skydb.NewRecordACLEntryRole("admin", skydb.WriteLevel),
+			skydb.NewRecordACLEntryPublic(skydb.ReadLevel),
+		}
+		So(db.Save(&record), ShouldBeNil)
+
+		Convey("gets a saved record", func() {
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.ID, ShouldResemble, record.ID)
+			So(fetched.OwnerID, ShouldEqual, "user1")
+			So(fetched.CreatedAt, ShouldResemble, record.CreatedAt)
+			So(fetched.UpdatedAt, ShouldResemble, record.UpdatedAt)
+			So(fetched.ACL, ShouldResemble, record.ACL)
+			So(fetched.Data["title"], ShouldEqual, "hello")
+			So(fetched.Data["rank"], ShouldEqual, 1.5)
+			So(fetched.Data["done"], ShouldEqual, true)
+			So(fetched.Data["due"], ShouldResemble, due)
+			So(fetched.Data["tags"], ShouldResemble, []interface{}{"a", "b"})
+			So(fetched.Data["location"], ShouldResemble, skydb.NewLocation(1, 2))
+		})
+
+		Convey("updates a saved record", func() {
+			record.Data["title"] = "updated"
+			delete(record.Data, "tags")
+			So(db.Save(&record), ShouldBeNil)
+
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["title"], ShouldEqual, "updated")
+			So(fetched.Data["rank"], ShouldEqual, 1.5)
+		})
+
+		Convey("gets records by IDs", func() {
+			other := newNote("note2", "user1", skydb.Data{"title": "world"})
+			saveRecords(t, db, other)
+
+			rows, err := db.GetByIDs([]skydb.RecordID{
+				record.ID,
+				other.ID,
+				skydb.NewRecordID("note", "missing"),
+			}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			keys := []string{}
+			for rows.Scan() {
+				keys = append(keys, rows.Record().ID.Key)
+			}
+			So(rows.Err(), ShouldBeNil)
+			So(keys, ShouldHaveLength, 2)
+			So(keys, ShouldContain, "note1")
+			So(keys, ShouldContain, "note2")
+		})
+
+		Convey("deletes a record", func() {
+			So(db.Delete(record.ID), ShouldBeNil)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("returns ErrRecordNotFound on missing record", func() {
+			So(db.Get(skydb.NewRecordID("note", "missing"), &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.Delete(skydb.NewRecordID("note", "missing")), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("returns ErrRecordNotFound on record type not created", func() {
+			So(db.Get(skydb.NewRecordID("comment", "comment1"), &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("keeps records of private databases apart", func() {
+			privateDB := c.PrivateDB("user1")
+			So(privateDB.Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+
+			private := newNote("note2", "user1", skydb.Data{"title": "secret"})
+			So(privateDB.Save(&private), ShouldBeNil)
+			So(private.DatabaseID, ShouldEqual, "user1")
+			So(db.Get(private.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+
+			fetched := skydb.Record{}
+			So(c.UnionDB().Get(private.ID, &fetched), ShouldBeNil)
+			So(fetched.DatabaseID, ShouldEqual, "user1")
+		})
+
+		Convey("returns ErrDatabaseIsReadOnly on writing to union database", func() {
+			So(c.UnionDB().IsReadOnly(), ShouldBeTrue)
+			So(c.UnionDB().Save(&record), ShouldEqual, skydb.ErrDatabaseIsReadOnly)
+			So(c.UnionDB().Delete(record.ID), ShouldEqual, skydb.ErrDatabaseIsReadOnly)
+		})
+	})
+}
+
+func testRecordUpdate(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"title":  skydb.FieldType{Type: skydb.TypeString},
+			"rank":   skydb.FieldType{Type: skydb.TypeNumber},
+			"count":  skydb.FieldType{Type: skydb.TypeInteger},
+			"tags":   skydb.FieldType{Type: skydb.TypeJSON},
+			"serial": skydb.FieldType{Type: skydb.TypeSequence},
+		})
+		So(err, ShouldBeNil)
+
+		record := newNote("note1", "user1", skydb.Data{
+			"title": "hello",
+			"rank":  1,
+			"count": 2,
+			"tags":  []interface{}{"a", "b"},
+		})
+		So(db.Save(&record), ShouldBeNil)
+
+		Convey("returns the saved record", func() {
+			So(record.DatabaseID, ShouldEqual, "")
+			So(record.Data["rank"], ShouldEqual, float64(1))
+			So(record.Data["count"], ShouldEqual, int64(2))
+			So(record.Data["serial"], ShouldEqual, int64(1))
+		})
+
+		Convey("numbers new records by sequence", func() {
+			other := newNote("note2", "user1", skydb.Data{"serial": skydb.Sequence{}})
+			So(db.Save(&other), ShouldBeNil)
+			So(other.Data["serial"], ShouldEqual, int64(2))
+		})
+
+		Convey("updates only the saved fields", func() {
+			update := newNote("note1", "user2", skydb.Data{"title": "world"})
+			update.CreatedAt = record.CreatedAt.Add(time.Hour)
+			update.UpdatedAt = record.UpdatedAt.Add(time.Hour)
+			So(db.Save(&update), ShouldBeNil)
+			So(update.OwnerID, ShouldEqual, "user1")
+			So(update.CreatedAt, ShouldResemble, record.CreatedAt)
+			So(update.UpdaterID, ShouldEqual, "user2")
+			So(update.Data["title"], ShouldEqual, "world")
+			So(update.Data["count"], ShouldEqual, int64(2))
+		})
+
+		Convey("applies field operations", func() {
+			update := newNote("note1", "user1", skydb.Data{
+				"count": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: 3},
+				"rank":  skydb.FieldOperation{Operator: skydb.MaxOperator, Value: 0.5},
+				"tags":  skydb.FieldOperation{Operator: skydb.PushOperator, Value: []interface{}{"c"}},
+			})
+			So(db.Save(&update), ShouldBeNil)
+			So(update.Data["count"], ShouldEqual, int64(5))
+			So(update.Data["rank"], ShouldEqual, float64(1))
+			So(update.Data["tags"], ShouldResemble, []interface{}{"a", "b", "c"})
+
+			update.Data = skydb.Data{
+				"tags": skydb.FieldOperation{Operator: skydb.PullOperator, Value: []interface{}{"a"}},
+			}
+			So(db.Save(&update), ShouldBeNil)
+			So(update.Data["tags"], ShouldResemble, []interface{}{"b", "c"})
+		})
+
+		Convey("returns InvalidArgument on field operation on field of wrong type", func() {
+			update := newNote("note1", "user1", skydb.Data{
+				"title": skydb.FieldOperation{Operator: skydb.IncrementOperator, Value: 1},
+			})
+			err := db.Save(&update)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
+		Convey("returns InvalidArgument on value of wrong type", func() {
+			record.Data = skydb.Data{"count": "one"}
+			err := db.Save(&record)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.InvalidArgument)
+		})
+
+		Convey("saves datetime given as pointer", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"due": skydb.FieldType{Type: skydb.TypeDateTime},
+			})
+			So(err, ShouldBeNil)
+
+			due := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+			record.Data = skydb.Data{"due": &due}
+			So(db.Save(&record), ShouldBeNil)
+			So(record.Data["due"], ShouldResemble, due)
+		})
+	})
+}
+
+func testReference(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("category", skydb.RecordSchema{
+			"name": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+		_, err = db.Extend("note", skydb.RecordSchema{
+			"category": skydb.FieldType{Type: skydb.TypeReference, ReferenceType: "category"},
+		})
+		So(err, ShouldBeNil)
+
+		category := skydb.Record{
+			ID:      skydb.NewRecordID("category", "category1"),
+			OwnerID: "user1",
+			Data:    skydb.Data{"name": "news"},
+		}
+		note := newNote("note1", "user1", skydb.Data{
+			"category": skydb.NewReference("category", "category1"),
+		})
+		saveRecords(t, db, category, note)
+
+		extendOnDelete := func(action skydb.ReferenceAction) {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"category": skydb.FieldType{
+					Type:          skydb.TypeReference,
+					ReferenceType: "category",
+					Constraints:   &skydb.FieldConstraints{OnDelete: action},
+				},
+			})
+			So(err, ShouldBeNil)
+		}
+
+		Convey("returns ConstraintViolated on deleting a referenced record", func() {
+			err := db.Delete(category.ID)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ConstraintViolated)
+		})
+
+		Convey("deletes referencing records with cascade", func() {
+			extendOnDelete(skydb.CascadeReference)
+			So(db.Delete(category.ID), ShouldBeNil)
+			So(db.Get(note.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("sets references to null with set_null", func() {
+			extendOnDelete(skydb.SetNullReference)
+			So(db.Delete(category.ID), ShouldBeNil)
+
+			fetched := skydb.Record{}
+			So(db.Get(note.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["category"], ShouldBeNil)
+		})
+
+		Convey("returns error on saving a reference to a missing record", func() {
+			note.Data["category"] = skydb.NewReference("category", "missing")
+			So(db.Save(&note), ShouldNotBeNil)
+		})
+	})
+}
+
+func testSoftDelete(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+		So(db.SetRecordSoftDelete("note", true), ShouldBeNil)
+
+		record := newNote("note1", "user1", skydb.Data{"title": "hello"})
+		So(db.Save(&record), ShouldBeNil)
+		So(db.Delete(record.ID), ShouldBeNil)
+
+		Convey("hides a soft deleted record", func() {
+			So(db.Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+
+			keys, err := queryKeys(db, &skydb.Query{Type: "note"}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+
+			keys, err = queryKeys(db, &skydb.Query{Type: "note", IncludeDeleted: true}, bypassAccessControl)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"note1"})
+		})
+
+		Convey("undeletes a record", func() {
+			So(db.Undelete(record.ID), ShouldBeNil)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+			So(db.Undelete(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("does not restore a deleted record on save", func() {
+			saved := newNote("note1", "user1", skydb.Data{"title": "world"})
+			So(db.Save(&saved), ShouldBeNil)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+
+			So(db.Undelete(record.ID), ShouldBeNil)
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["title"], ShouldEqual, "world")
+		})
+
+		Convey("purges deleted records", func() {
+			purged, err := c.PurgeDeletedRecords(time.Now().Add(time.Hour))
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 1)
+			So(db.Undelete(record.ID), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+	})
+}
+
+func testTransaction(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+		txDB, ok := db.(skydb.Transactional)
+		So(ok, ShouldBeTrue)
+
+		record := newNote("note1", "user1", skydb.Data{"title": "hello"})
+
+		Convey("commits changes", func() {
+			So(txDB.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+			So(txDB.Commit(), ShouldBeNil)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+		})
+
+		Convey("rolls back changes", func() {
+			So(txDB.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+			So(txDB.Rollback(), ShouldBeNil)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("rolls back schema changes", func() {
+			So(txDB.Begin(), ShouldBeNil)
+			_, err := db.Extend("comment", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			So(txDB.Rollback(), ShouldBeNil)
+
+			schemas, err := db.GetRecordSchemas()
+			So(err, ShouldBeNil)
+			So(schemas, ShouldNotContainKey, "comment")
+		})
+
+		Convey("returns error on nested or missing transactions", func() {
+			So(txDB.Commit(), ShouldEqual, skydb.ErrDatabaseTxDidNotBegin)
+			So(txDB.Rollback(), ShouldEqual, skydb.ErrDatabaseTxDidNotBegin)
+			So(txDB.Begin(), ShouldBeNil)
+			So(txDB.Begin(), ShouldEqual, skydb.ErrDatabaseTxDidBegin)
+			So(txDB.Rollback(), ShouldBeNil)
+		})
+	})
+}
+
+func testRecordEvent(t *testing.T, open ConnFunc) {
+	Convey("Conn", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+
+		ch := make(chan skydb.RecordEvent, 16)
+		So(c.Subscribe(ch), ShouldBeNil)
+
+		record := newNote("note1", "user1", skydb.Data{"title": "hello"})
+
+		Convey("sends events of saved and deleted records", func() {
+			So(db.Save(&record), ShouldBeNil)
+			So(waitRecordEvent(ch, record.ID, skydb.RecordCreated, recordEventTimeout), ShouldBeTrue)
+
+			record.Data["title"] = "updated"
+			So(db.Save(&record), ShouldBeNil)
+			So(waitRecordEvent(ch, record.ID, skydb.RecordUpdated, recordEventTimeout), ShouldBeTrue)
+
+			So(db.Delete(record.ID), ShouldBeNil)
+			So(waitRecordEvent(ch, record.ID, skydb.RecordDeleted, recordEventTimeout), ShouldBeTrue)
+		})
+
+		Convey("sends no events of rolled back changes", func() {
+			txDB := db.(skydb.Transactional)
+			So(txDB.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+			So(txDB.Rollback(), ShouldBeNil)
+			So(waitRecordEvent(ch, record.ID, skydb.RecordCreated, 100*time.Millisecond), ShouldBeFalse)
+		})
+	})
+}
+
+func testSubscription(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		So(c.CreateAuth(&skydb.AuthInfo{ID: "user1"}), ShouldBeNil)
+		So(c.SaveDevice(&skydb.Device{
+			ID:               "device1",
+			Type:             "ios",
+			Token:            "token1",
+			AuthInfoID:       "user1",
+			LastRegisteredAt: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+		}), ShouldBeNil)
+
+		db := c.PrivateDB("user1")
+		subscription := skydb.Subscription{
+			ID:       "subscription1",
+			Type:     "query",
+			DeviceID: "device1",
+			Query: skydb.Query{
+				Type: "note",
+				Predicate: predicate(skydb.Equal,
+					keyPath("title"),
+					literal("hello"),
+				),
+			},
+		}
+		So(db.SaveSubscription(&subscription), ShouldBeNil)
+
+		Convey("gets a subscription", func() {
+			fetched := skydb.Subscription{}
+			So(db.GetSubscription("subscription1", "device1", &fetched), ShouldBeNil)
+			So(fetched, ShouldResemble, subscription)
+		})
+
+		Convey("gets subscriptions of a device", func() {
+			subscriptions := db.GetSubscriptionsByDeviceID("device1")
+			So(subscriptions, ShouldResemble, []skydb.Subscription{subscription})
+		})
+
+		Convey("gets subscriptions matching record type", func() {
+			record := newNote("note1", "user1", skydb.Data{"title": "hello"})
+			subscriptions := db.GetMatchingSubscriptions(&record)
+			So(subscriptions, ShouldHaveLength, 1)
+			So(subscriptions[0].ID, ShouldEqual, "subscription1")
+
+			other := skydb.Record{ID: skydb.NewRecordID("comment", "comment1")}
+			So(db.GetMatchingSubscriptions(&other), ShouldBeEmpty)
+		})
+
+		Convey("deletes a subscription", func() {
+			So(db.DeleteSubscription("subscription1", "device1"), ShouldBeNil)
+			So(db.GetSubscription("subscription1", "device1", &skydb.Subscription{}), ShouldEqual, skydb.ErrSubscriptionNotFound)
+			So(db.DeleteSubscription("subscription1", "device1"), ShouldEqual, skydb.ErrSubscriptionNotFound)
+		})
+
+		Convey("returns ErrDeviceNotFound on saving subscription of missing device", func() {
+			subscription.DeviceID = "missing"
+			So(db.SaveSubscription(&subscription), ShouldEqual, skydb.ErrDeviceNotFound)
+		})
+	})
+}
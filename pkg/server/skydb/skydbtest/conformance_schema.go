@@ -0,0 +1,270 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydbtest
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+func testSchema(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		extended, err := db.Extend("note", skydb.RecordSchema{
+			"title": skydb.FieldType{Type: skydb.TypeString},
+			"rank":  skydb.FieldType{Type: skydb.TypeNumber},
+		})
+		So(err, ShouldBeNil)
+		So(extended, ShouldBeTrue)
+
+		Convey("does not extend with existing schema", func() {
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeFalse)
+		})
+
+		Convey("extends with new field", func() {
+			extended, err := db.Extend("note", skydb.RecordSchema{
+				"done": skydb.FieldType{Type: skydb.TypeBoolean},
+			})
+			So(err, ShouldBeNil)
+			So(extended, ShouldBeTrue)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["title"].Type, ShouldEqual, skydb.TypeString)
+			So(schema["rank"].Type, ShouldEqual, skydb.TypeNumber)
+			So(schema["done"].Type, ShouldEqual, skydb.TypeBoolean)
+		})
+
+		Convey("returns error on extending with conflicting type", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{Type: skydb.TypeNumber},
+			})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns schemas of all record types", func() {
+			_, err := db.Extend("comment", skydb.RecordSchema{
+				"content": skydb.FieldType{Type: skydb.TypeString},
+			})
+			So(err, ShouldBeNil)
+
+			schemas, err := db.GetRecordSchemas()
+			So(err, ShouldBeNil)
+			So(schemas, ShouldContainKey, "note")
+			So(schemas, ShouldContainKey, "comment")
+			So(schemas["comment"]["content"].Type, ShouldEqual, skydb.TypeString)
+		})
+
+		Convey("renames field", func() {
+			record := newNote("note1", "user1", skydb.Data{"title": "hello"})
+			So(db.Save(&record), ShouldBeNil)
+
+			So(db.RenameSchema("note", "title", "name"), ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema, ShouldNotContainKey, "title")
+			So(schema["name"].Type, ShouldEqual, skydb.TypeString)
+
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["name"], ShouldEqual, "hello")
+		})
+
+		Convey("returns error on renaming missing field", func() {
+			So(db.RenameSchema("note", "missing", "name"), ShouldNotBeNil)
+		})
+
+		Convey("deletes field", func() {
+			So(db.DeleteSchema("note", "rank"), ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema, ShouldNotContainKey, "rank")
+			So(schema, ShouldContainKey, "title")
+		})
+
+		Convey("returns error on deleting missing field", func() {
+			So(db.DeleteSchema("note", "missing"), ShouldNotBeNil)
+		})
+
+		Convey("alters field type", func() {
+			record := newNote("note1", "user1", skydb.Data{"rank": 1.5})
+			So(db.Save(&record), ShouldBeNil)
+
+			report, err := db.AlterSchemaType("note", "rank", skydb.FieldType{Type: skydb.TypeString}, false)
+			So(err, ShouldBeNil)
+			So(report.FailedCount, ShouldEqual, 0)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["rank"].Type, ShouldEqual, skydb.TypeString)
+
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["rank"], ShouldEqual, "1.5")
+		})
+
+		Convey("does not alter field type on dry run", func() {
+			_, err := db.AlterSchemaType("note", "rank", skydb.FieldType{Type: skydb.TypeString}, true)
+			So(err, ShouldBeNil)
+
+			schema, err := db.GetSchema("note")
+			So(err, ShouldBeNil)
+			So(schema["rank"].Type, ShouldEqual, skydb.TypeNumber)
+		})
+
+		Convey("reports records failing the type conversion", func() {
+			saveRecords(t, db,
+				newNote("note1", "user1", skydb.Data{"title": " 12 "}),
+				newNote("note2", "user1", skydb.Data{"title": "abc"}),
+			)
+
+			report, err := db.AlterSchemaType("note", "title", skydb.FieldType{Type: skydb.TypeInteger}, true)
+			So(err, ShouldBeNil)
+			So(report.FailedCount, ShouldEqual, 1)
+			So(report.FailedRecordIDs, ShouldResemble, []skydb.RecordID{skydb.NewRecordID("note", "note2")})
+
+			_, err = db.AlterSchemaType("note", "title", skydb.FieldType{Type: skydb.TypeInteger}, false)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.IncompatibleSchema)
+
+			So(db.Delete(skydb.NewRecordID("note", "note2")), ShouldBeNil)
+			_, err = db.AlterSchemaType("note", "title", skydb.FieldType{Type: skydb.TypeInteger}, false)
+			So(err, ShouldBeNil)
+
+			fetched := skydb.Record{}
+			So(db.Get(skydb.NewRecordID("note", "note1"), &fetched), ShouldBeNil)
+			So(fetched.Data["title"], ShouldEqual, int64(12))
+		})
+	})
+}
+
+func testFieldConstraint(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+		record := newNote("note1", "user1", skydb.Data{"title": "hello"})
+		So(db.Save(&record), ShouldBeNil)
+
+		Convey("fills default of required field in existing records", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"status": skydb.FieldType{
+					Type:        skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{Required: true, Default: "draft"},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["status"], ShouldEqual, "draft")
+
+			other := newNote("note2", "user1", skydb.Data{})
+			So(db.Save(&other), ShouldBeNil)
+			So(other.Data["status"], ShouldEqual, "draft")
+		})
+
+		Convey("returns ConstraintViolated on non-unique value of unique field", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{
+					Type:        skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{Unique: true},
+				},
+			})
+			So(err, ShouldBeNil)
+
+			other := newNote("note2", "user1", skydb.Data{"title": "hello"})
+			err = db.Save(&other)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.ConstraintViolated)
+		})
+
+		Convey("returns error on constraint violated by existing records", func() {
+			_, err := db.Extend("note", skydb.RecordSchema{
+				"title": skydb.FieldType{
+					Type:        skydb.TypeString,
+					Constraints: &skydb.FieldConstraints{Pattern: "^[A-Z]"},
+				},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func testIndex(t *testing.T, open ConnFunc) {
+	Convey("Database", t, func() {
+		c, cleanup := open(t)
+		defer cleanup()
+
+		db := c.PublicDB()
+		_, err := db.Extend("note", noteSchema)
+		So(err, ShouldBeNil)
+
+		index := skydb.Index{Fields: []string{"title"}, Unique: true}
+		So(db.SaveIndex("note", "note_title_key", index), ShouldBeNil)
+
+		Convey("returns saved index", func() {
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldContainKey, "note_title_key")
+			So(indexes["note_title_key"], ShouldResemble, index)
+		})
+
+		Convey("rejects duplicated values of unique index", func() {
+			saveRecords(t, db, newNote("note1", "user1", skydb.Data{"title": "hello"}))
+
+			record := newNote("note2", "user1", skydb.Data{"title": "hello"})
+			So(db.Save(&record), ShouldNotBeNil)
+		})
+
+		Convey("returns error on unique index violated by existing records", func() {
+			So(db.DeleteIndex("note", "note_title_key"), ShouldBeNil)
+			saveRecords(t, db,
+				newNote("note1", "user1", skydb.Data{"title": "hello"}),
+				newNote("note2", "user1", skydb.Data{"title": "hello"}),
+			)
+			So(db.SaveIndex("note", "note_title_key", index), ShouldNotBeNil)
+		})
+
+		Convey("deletes index", func() {
+			So(db.DeleteIndex("note", "note_title_key"), ShouldBeNil)
+
+			indexes, err := db.GetIndexesByRecordType("note")
+			So(err, ShouldBeNil)
+			So(indexes, ShouldNotContainKey, "note_title_key")
+
+			saveRecords(t, db,
+				newNote("note1", "user1", skydb.Data{"title": "hello"}),
+				newNote("note2", "user1", skydb.Data{"title": "hello"}),
+			)
+		})
+	})
+}
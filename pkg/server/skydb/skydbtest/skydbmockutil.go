@@ -29,6 +29,12 @@ func ExpectDBSaveUser(db *mock_skydb.MockTxDatabase, extendedSchema *skydb.Recor
 		Return(skydb.ErrRecordNotFound).
 		AnyTimes()
 
+	// soft delete is not enabled
+	db.EXPECT().
+		RemoteColumnTypes(gomock.Any()).
+		Return(skydb.RecordSchema{}, nil).
+		AnyTimes()
+
 	// extend Schema
 	if extendedSchema != nil {
 		ExpectDBExtendSchema(db, *extendedSchema)
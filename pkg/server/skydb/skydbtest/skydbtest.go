@@ -17,6 +17,7 @@ package skydbtest
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/skygeario/skygear-server/pkg/server/skydb"
@@ -32,6 +33,8 @@ type MapConn struct {
 	fieldAccess            skydb.FieldACL
 	OAuthMap               map[string]skydb.OAuthInfo
 	CustomTokenInfoMap     map[string]skydb.CustomTokenInfo
+	RecordRevisions        []skydb.RecordRevision
+	recordHistoryTypes     map[string]bool
 	skydb.Conn
 }
 
@@ -45,6 +48,7 @@ func NewMapConn() *MapConn {
 		AssetMap:               map[string]skydb.Asset{},
 		OAuthMap:               map[string]skydb.OAuthInfo{},
 		CustomTokenInfoMap:     map[string]skydb.CustomTokenInfo{},
+		recordHistoryTypes:     map[string]bool{},
 	}
 }
 
@@ -189,6 +193,66 @@ func (conn *MapConn) GetAssets(names []string) ([]skydb.Asset, error) {
 	return assets, nil
 }
 
+// GetRecordHistoryTypes returns the record types with history enabled.
+func (conn *MapConn) GetRecordHistoryTypes() ([]string, error) {
+	recordTypes := []string{}
+	for recordType := range conn.recordHistoryTypes {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+	return recordTypes, nil
+}
+
+// SetRecordHistoryEnabled enables or disables history of a record type.
+func (conn *MapConn) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	if enabled {
+		conn.recordHistoryTypes[recordType] = true
+	} else {
+		delete(conn.recordHistoryTypes, recordType)
+	}
+	return nil
+}
+
+// SaveRecordRevision appends a revision to RecordRevisions.
+func (conn *MapConn) SaveRecordRevision(revision *skydb.RecordRevision) error {
+	version := 0
+	for _, r := range conn.RecordRevisions {
+		if r.DatabaseID == revision.DatabaseID && r.RecordID == revision.RecordID && r.Version > version {
+			version = r.Version
+		}
+	}
+
+	revision.Version = version + 1
+	revision.ID = fmt.Sprintf("%s/%s/%d", revision.DatabaseID, revision.RecordID, revision.Version)
+	revision.CreatedAt = time.Now().UTC()
+	conn.RecordRevisions = append(conn.RecordRevisions, *revision)
+	return nil
+}
+
+// GetRecordRevisions returns revisions of a record in RecordRevisions,
+// latest first.
+func (conn *MapConn) GetRecordRevisions(databaseID string, id skydb.RecordID) ([]skydb.RecordRevision, error) {
+	revisions := []skydb.RecordRevision{}
+	for i := len(conn.RecordRevisions) - 1; i >= 0; i-- {
+		r := conn.RecordRevisions[i]
+		if r.DatabaseID == databaseID && r.RecordID == id {
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+// GetRecordRevision returns a revision of a record in RecordRevisions.
+func (conn *MapConn) GetRecordRevision(databaseID string, id skydb.RecordID, version int, revision *skydb.RecordRevision) error {
+	for _, r := range conn.RecordRevisions {
+		if r.DatabaseID == databaseID && r.RecordID == id && r.Version == version {
+			*revision = r
+			return nil
+		}
+	}
+	return skydb.ErrRecordRevisionNotFound
+}
+
 // QueryRelation is not implemented.
 func (conn *MapConn) QueryRelation(user string, name string, direction string, config skydb.QueryConfig) []skydb.AuthInfo {
 	panic("not implemented")
@@ -366,6 +430,8 @@ type MapDB struct {
 	RecordMap       RecordMap
 	SubscriptionMap SubscriptionMap
 	RecordSchemaMap RecordSchemaMap
+	IndexMap        map[string]map[string]skydb.Index
+	SoftDeleteTypes map[string]bool
 	DBConn          skydb.Conn
 	skydb.Database
 }
@@ -376,10 +442,15 @@ func NewMapDB() *MapDB {
 		RecordMap:       RecordMap{},
 		SubscriptionMap: SubscriptionMap{},
 		RecordSchemaMap: RecordSchemaMap{},
-		DBConn:          &MapConn{},
+		DBConn:          NewMapConn(),
 	}
 }
 
+// Conn returns DBConn.
+func (db *MapDB) Conn() skydb.Conn {
+	return db.DBConn
+}
+
 func (db *MapDB) IsReadOnly() bool { return false }
 
 func (db *MapDB) DatabaseType() skydb.DatabaseType { return skydb.PublicDatabase }
@@ -393,17 +464,36 @@ func (db *MapDB) UserRecordType() string {
 	return "user"
 }
 
-// Get returns a Record from RecordMap.
+// Get returns a Record from RecordMap. Soft-deleted records are not
+// returned.
 func (db *MapDB) Get(id skydb.RecordID, record *skydb.Record) error {
 	r, ok := db.RecordMap[id.String()]
-	if !ok {
+	if !ok || !r.DeletedAt.IsZero() {
 		return skydb.ErrRecordNotFound
 	}
-	*record = r
+	*record = r.Copy()
 	return nil
 
 }
 
+// GetForUpdate is the same as Get, as MapDB has no concurrent writes.
+func (db *MapDB) GetForUpdate(id skydb.RecordID, record *skydb.Record) error {
+	return db.Get(id, record)
+}
+
+// GetByIDs returns the Records of the IDs in RecordMap. Soft-deleted
+// records are not returned.
+func (db *MapDB) GetByIDs(ids []skydb.RecordID, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	records := []skydb.Record{}
+	for _, id := range ids {
+		r, ok := db.RecordMap[id.String()]
+		if ok && r.DeletedAt.IsZero() {
+			records = append(records, r.Copy())
+		}
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
 // Save assigns Record to RecordMap.
 func (db *MapDB) Save(record *skydb.Record) error {
 	recordID := record.ID.String()
@@ -412,36 +502,119 @@ func (db *MapDB) Save(record *skydb.Record) error {
 		// keep the meta-data of record, only update record.Data
 		origRecordMergedCopy := origRecord.MergedCopy(record)
 		record.Apply(&origRecordMergedCopy)
+		// a soft-deleted record is restored only by Undelete
+		record.DeletedAt = origRecord.DeletedAt
+	} else {
+		// apply field operations on a record without data
+		newRecord := skydb.Record{}
+		newRecord.Apply(record)
+		record.Data = newRecord.Data
 	}
 
 	db.RecordMap[recordID] = *record
 	return nil
 }
 
-// Delete remove the specified key from RecordMap.
+// Delete remove the specified key from RecordMap. If soft delete of the
+// record type is enabled, the record is marked as deleted instead.
 func (db *MapDB) Delete(id skydb.RecordID) error {
-	_, ok := db.RecordMap[id.String()]
-	if !ok {
+	r, ok := db.RecordMap[id.String()]
+	if !ok || !r.DeletedAt.IsZero() {
 		return skydb.ErrRecordNotFound
 	}
+	if db.SoftDeleteTypes[id.Type] {
+		r.DeletedAt = time.Now().UTC()
+		db.RecordMap[id.String()] = r
+		return nil
+	}
 	delete(db.RecordMap, id.String())
 	return nil
 }
 
-// Query is not implemented.
+// SetRecordSoftDelete sets whether soft delete of a record type is
+// enabled in SoftDeleteTypes.
+func (db *MapDB) SetRecordSoftDelete(recordType string, enabled bool) error {
+	if !enabled {
+		for _, r := range db.RecordMap {
+			if r.ID.Type == recordType && !r.DeletedAt.IsZero() {
+				return fmt.Errorf("record type %s has soft-deleted records", recordType)
+			}
+		}
+		delete(db.SoftDeleteTypes, recordType)
+		return nil
+	}
+
+	if db.SoftDeleteTypes == nil {
+		db.SoftDeleteTypes = map[string]bool{}
+	}
+	db.SoftDeleteTypes[recordType] = true
+	return nil
+}
+
+// Undelete clears the deleted time of a soft-deleted record in RecordMap.
+func (db *MapDB) Undelete(id skydb.RecordID) error {
+	r, ok := db.RecordMap[id.String()]
+	if !ok || r.DeletedAt.IsZero() {
+		return skydb.ErrRecordNotFound
+	}
+	r.DeletedAt = time.Time{}
+	db.RecordMap[id.String()] = r
+	return nil
+}
+
+// Query returns the records in RecordMap identified by the key of the
+// query predicate, which must be an equality of _id. Other queries are not
+// supported.
 func (db *MapDB) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
-	panic("skydbtest: MapDB.Query not supported")
+	predicate := query.Predicate
+	if predicate.Operator != skydb.Equal || len(predicate.Children) != 2 {
+		panic("skydbtest: MapDB.Query not supported")
+	}
+	keyPath, ok := predicate.Children[0].(skydb.Expression)
+	if !ok || keyPath.Type != skydb.KeyPath || keyPath.Value != "_id" {
+		panic("skydbtest: MapDB.Query not supported")
+	}
+	key, ok := predicate.Children[1].(skydb.Expression)
+	if !ok || key.Type != skydb.Literal {
+		panic("skydbtest: MapDB.Query not supported")
+	}
+
+	records := []skydb.Record{}
+	if r, ok := db.RecordMap[skydb.NewRecordID(query.Type, key.Value.(string)).String()]; ok {
+		if r.DeletedAt.IsZero() || query.IncludeDeleted {
+			records = append(records, r.Copy())
+		}
+	}
+	return skydb.NewRows(skydb.NewMemoryRows(records)), nil
+}
+
+// RemoteColumnTypes returns the schema of the record type in
+// RecordSchemaMap, with the _deleted_at field if soft delete of the record
+// type is enabled in SoftDeleteTypes.
+func (db *MapDB) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	schema := skydb.RecordSchema{}
+	for field, fieldType := range db.RecordSchemaMap[recordType] {
+		schema[field] = fieldType
+	}
+	if db.SoftDeleteTypes[recordType] {
+		schema["_deleted_at"] = skydb.FieldType{Type: skydb.TypeDateTime}
+	}
+	return schema, nil
 }
 
 // Extend store the type of the field.
 func (db *MapDB) Extend(recordType string, schema skydb.RecordSchema) (bool, error) {
 	if _, ok := db.RecordSchemaMap[recordType]; ok {
 		for fieldName, fieldType := range schema {
-			if _, ok := db.RecordSchemaMap[recordType][fieldName]; ok {
-				ft := db.RecordSchemaMap[recordType][fieldName]
+			if ft, ok := db.RecordSchemaMap[recordType][fieldName]; ok {
+				constraints := ft.Constraints
+				ft.Constraints = fieldType.Constraints
 				if !reflect.DeepEqual(ft, fieldType) {
 					return false, fmt.Errorf("Wrong type")
 				}
+				if fieldType.Constraints == nil {
+					fieldType.Constraints = constraints
+				}
 			}
 			db.RecordSchemaMap[recordType][fieldName] = fieldType
 		}
@@ -483,6 +656,28 @@ func (db *MapDB) DeleteSchema(recordType, columnName string) error {
 	return nil
 }
 
+// AlterSchemaType changes the type of a column in RecordSchemaMap. Values
+// in RecordMap are not converted and no failures are reported.
+func (db *MapDB) AlterSchemaType(recordType, columnName string, fieldType skydb.FieldType, dryRun bool) (skydb.TypeConversionReport, error) {
+	if _, ok := db.RecordSchemaMap[recordType]; !ok {
+		return skydb.TypeConversionReport{}, fmt.Errorf("record type %s does not exist", recordType)
+	}
+	remoteFieldType, ok := db.RecordSchemaMap[recordType][columnName]
+	if !ok {
+		return skydb.TypeConversionReport{}, fmt.Errorf("column %s does not exist", columnName)
+	}
+
+	conversion, err := skydb.GetTypeConversion(remoteFieldType, fieldType)
+	if err != nil {
+		return skydb.TypeConversionReport{}, err
+	}
+
+	if !dryRun {
+		db.RecordSchemaMap[recordType][columnName] = fieldType
+	}
+	return skydb.TypeConversionReport{Conversion: conversion}, nil
+}
+
 // GetSchema returns the record schema of a record type
 func (db *MapDB) GetSchema(recordType string) (skydb.RecordSchema, error) {
 	if _, ok := db.RecordSchemaMap[recordType]; !ok {
@@ -496,6 +691,36 @@ func (db *MapDB) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
 	return db.RecordSchemaMap, nil
 }
 
+// GetIndexesByRecordType returns the indexes of a record type from IndexMap.
+func (db *MapDB) GetIndexesByRecordType(recordType string) (map[string]skydb.Index, error) {
+	indexes := map[string]skydb.Index{}
+	for name, index := range db.IndexMap[recordType] {
+		indexes[name] = index
+	}
+	return indexes, nil
+}
+
+// SaveIndex assigns to IndexMap.
+func (db *MapDB) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	if db.IndexMap == nil {
+		db.IndexMap = map[string]map[string]skydb.Index{}
+	}
+	if _, ok := db.IndexMap[recordType]; !ok {
+		db.IndexMap[recordType] = map[string]skydb.Index{}
+	}
+	db.IndexMap[recordType][indexName] = index
+	return nil
+}
+
+// DeleteIndex deletes the specified index from IndexMap.
+func (db *MapDB) DeleteIndex(recordType string, indexName string) error {
+	if _, ok := db.IndexMap[recordType][indexName]; !ok {
+		return fmt.Errorf("index %s does not exist", indexName)
+	}
+	delete(db.IndexMap[recordType], indexName)
+	return nil
+}
+
 // GetSubscription return a Subscription from SubscriptionMap.
 func (db *MapDB) GetSubscription(name string, deviceID string, subscription *skydb.Subscription) error {
 	s, ok := db.SubscriptionMap[deviceID+"/"+name]
@@ -527,6 +752,7 @@ func (db *MapDB) DeleteSubscription(name string, deviceID string) error {
 // calls to underlying Database
 type MockTxDatabase struct {
 	DidBegin, DidCommit, DidRollback bool
+	inTx                             bool
 	skydb.Database
 }
 
@@ -535,17 +761,23 @@ func NewMockTxDatabase(backingDB skydb.Database) *MockTxDatabase {
 }
 
 func (db *MockTxDatabase) Begin() error {
+	if db.inTx {
+		return skydb.ErrDatabaseTxDidBegin
+	}
 	db.DidBegin = true
+	db.inTx = true
 	return nil
 }
 
 func (db *MockTxDatabase) Commit() error {
 	db.DidCommit = true
+	db.inTx = false
 	return nil
 }
 
 func (db *MockTxDatabase) Rollback() error {
 	db.DidRollback = true
+	db.inTx = false
 	return nil
 }
 
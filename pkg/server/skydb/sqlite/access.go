@@ -0,0 +1,319 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) SetRecordAccess(recordType string, acl skydb.RecordACL) error {
+	creationRoles := []string{}
+	for _, ace := range acl {
+		if ace.Role != "" {
+			creationRoles = append(creationRoles, ace.Role)
+		}
+	}
+
+	return c.write(func() error {
+		if err := c.ensureRole(creationRoles); err != nil {
+			return err
+		}
+
+		deleteBuilder := sqlBuilder.Delete("_record_creation").
+			Where("record_type = ?", recordType)
+		if _, err := c.ExecWith(deleteBuilder); err != nil {
+			return err
+		}
+
+		for _, role := range creationRoles {
+			_, err := c.Exec(
+				"INSERT OR IGNORE INTO _record_creation (record_type, role_id) VALUES (?, ?)",
+				recordType, role)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *conn) GetRecordAccess(recordType string) (skydb.RecordACL, error) {
+	builder := sqlBuilder.Select("role_id").
+		From("_record_creation").
+		Where(sq.Eq{"record_type": recordType}).
+		OrderBy("role_id")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currentCreationRoles := []skydb.RecordACLEntry{}
+	for rows.Next() {
+		roleStr := ""
+		if err := rows.Scan(&roleStr); err != nil {
+			return nil, err
+		}
+		currentCreationRoles = append(currentCreationRoles,
+			skydb.NewRecordACLEntryRole(roleStr, skydb.CreateLevel))
+	}
+
+	return skydb.NewRecordACL(currentCreationRoles), rows.Err()
+}
+
+// aclValue returns the record ACL as JSON text, or nil if the ACL is nil,
+// which grants access to everyone.
+func aclValue(acl skydb.RecordACL) (interface{}, error) {
+	if acl == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(acl)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (c *conn) SetRecordDefaultAccess(recordType string, acl skydb.RecordACL) error {
+	defaultAccess, err := aclValue(acl)
+	if err != nil {
+		return err
+	}
+
+	return c.write(func() error {
+		_, err := c.Exec(
+			"INSERT OR REPLACE INTO _record_default_access (record_type, default_access) VALUES (?, ?)",
+			recordType, defaultAccess)
+		return err
+	})
+}
+
+func (c *conn) GetRecordDefaultAccess(recordType string) (skydb.RecordACL, error) {
+	builder := sqlBuilder.Select("default_access").
+		From("_record_default_access").
+		Where(sq.Eq{"record_type": recordType})
+
+	nullableACLString := sql.NullString{}
+	err := c.QueryRowWith(builder).Scan(&nullableACLString)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !nullableACLString.Valid {
+		return nil, nil
+	}
+
+	acl := skydb.RecordACL{}
+	if err := json.Unmarshal([]byte(nullableACLString.String), &acl); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (c *conn) SetRecordFieldAccess(acl skydb.FieldACL) error {
+	err := c.write(func() error {
+		if _, err := c.Exec("DELETE FROM _record_field_access"); err != nil {
+			return err
+		}
+
+		for _, entry := range acl.AllEntries() {
+			builder := sqlBuilder.Insert("_record_field_access").
+				Columns(
+					"record_type",
+					"record_field",
+					"user_role",
+					"writable",
+					"readable",
+					"comparable",
+					"discoverable",
+				).
+				Values(
+					entry.RecordType,
+					entry.RecordField,
+					entry.UserRole.String(),
+					entry.Writable,
+					entry.Readable,
+					entry.Comparable,
+					entry.Discoverable,
+				)
+			if _, err := c.ExecWith(builder); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	c.FieldACL = nil // invalidate cached FieldACL
+	return err
+}
+
+func (c *conn) GetRecordFieldAccess() (skydb.FieldACL, error) {
+	if c.FieldACL != nil {
+		return *c.FieldACL, nil
+	}
+
+	builder := sqlBuilder.Select(
+		"record_type",
+		"record_field",
+		"user_role",
+		"writable",
+		"readable",
+		"comparable",
+		"discoverable",
+	).From("_record_field_access")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return skydb.FieldACL{}, err
+	}
+	defer rows.Close()
+
+	entries := []skydb.FieldACLEntry{}
+	for rows.Next() {
+		var entry skydb.FieldACLEntry
+		var userRole string
+		err := rows.Scan(
+			&entry.RecordType,
+			&entry.RecordField,
+			&userRole,
+			&entry.Writable,
+			&entry.Readable,
+			&entry.Comparable,
+			&entry.Discoverable,
+		)
+		if err != nil {
+			return skydb.FieldACL{}, err
+		}
+		entry.UserRole = skydb.NewFieldUserRole(userRole)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return skydb.FieldACL{}, err
+	}
+
+	acl := skydb.NewFieldACL(skydb.FieldACLEntryList(entries))
+	c.FieldACL = &acl
+	return acl, nil
+}
+
+// relationBuilder returns the ids of the users related to the user in the
+// direction, or in both directions if the direction is neither outward
+// nor inward.
+func relationBuilder(user string, name string, direction string) sq.SelectBuilder {
+	table := quoteIdentifier(name)
+	selectBuilder := sqlBuilder.Select("u.id").
+		From("_auth AS u")
+	switch direction {
+	case "outward":
+		selectBuilder = selectBuilder.
+			Join(table+" AS relation ON relation.right_id = u.id").
+			Where("relation.left_id = ?", user)
+	case "inward":
+		selectBuilder = selectBuilder.
+			Join(table+" AS relation ON relation.left_id = u.id").
+			Where("relation.right_id = ?", user)
+	default:
+		selectBuilder = selectBuilder.
+			Join(table+" AS inward_relation ON inward_relation.left_id = u.id").
+			Join(table+" AS outward_relation ON outward_relation.right_id = u.id").
+			Where("inward_relation.right_id = ?", user).
+			Where("outward_relation.left_id = ?", user)
+	}
+	return selectBuilder
+}
+
+func (c *conn) QueryRelation(user string, name string, direction string, config skydb.QueryConfig) []skydb.AuthInfo {
+	logger := logging.CreateLogger(c.context, "skydb")
+	logger.Debugf("Query Relation: %v, %v", user, name)
+
+	selectBuilder := limitOffset(
+		relationBuilder(user, name, direction).OrderBy("u.id"),
+		config.Limit,
+		config.Offset,
+	)
+
+	results := []skydb.AuthInfo{}
+	rows, err := c.QueryWith(selectBuilder)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			panic(err)
+		}
+		results = append(results, skydb.AuthInfo{
+			ID: id,
+		})
+	}
+	return results
+}
+
+func (c *conn) QueryRelationCount(user string, name string, direction string) (uint64, error) {
+	logger := logging.CreateLogger(c.context, "skydb")
+	logger.Debugf("Query Relation Count: %v, %v, %v", user, name, direction)
+
+	query := sqlBuilder.Select("COUNT(*)").
+		FromSelect(relationBuilder(user, name, direction), "related")
+	var count uint64
+	err := c.GetWith(&count, query)
+	return count, err
+}
+
+func (c *conn) AddRelation(user string, name string, targetUser string) error {
+	return c.write(func() error {
+		_, err := c.Exec(
+			fmt.Sprintf("INSERT OR IGNORE INTO %s (left_id, right_id) VALUES (?, ?)", quoteIdentifier(name)),
+			user, targetUser)
+		if isForeignKeyViolated(err) {
+			return fmt.Errorf("userID not exist")
+		}
+		return err
+	})
+}
+
+func (c *conn) RemoveRelation(user string, name string, targetUser string) error {
+	return c.write(func() error {
+		builder := sqlBuilder.Delete(quoteIdentifier(name)).
+			Where("left_id = ? AND right_id = ?", user, targetUser)
+		result, err := c.ExecWith(builder)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("%v relation not exist {%v} => {%v}",
+				name, user, targetUser)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,344 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/sqlite/builder"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// typeConversion converts a value of a field from a type to another. The
+// second value returned is false if the value cannot be converted.
+//
+// SQLite has no column types to alter, so values are converted by the
+// driver with the rules of the USING expressions of the pq driver.
+type typeConversion func(value interface{}) (interface{}, bool)
+
+const (
+	minInteger  = -2147483648
+	maxInteger  = 2147483647
+	minDatetime = -62135596800
+	maxDatetime = 253402300799
+)
+
+var (
+	decimalPattern  = regexp.MustCompile(`^\s*[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?\s*$`)
+	integerPattern  = regexp.MustCompile(`^\s*[-+]?[0-9]{1,10}\s*$`)
+	datetimePattern = regexp.MustCompile(`^\s*([0-9]{4}-[0-9]{2}-[0-9]{2})(?:[T ]([0-9]{2}:[0-9]{2})(?::([0-9]{2})(\.[0-9]+)?)?)?\s*(Z|[-+][0-9]{2}(?::?[0-9]{2})?)?\s*$`)
+)
+
+var booleanStrings = map[string]bool{
+	"true": true, "t": true, "yes": true, "y": true, "on": true, "1": true,
+	"false": false, "f": false, "no": false, "n": false, "off": false, "0": false,
+}
+
+func inIntegerRange(number float64) bool {
+	return number >= minInteger && number <= maxInteger
+}
+
+func epochTime(seconds float64) time.Time {
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64(math.Round((seconds-whole)*1e6))*1e3).UTC()
+}
+
+func epochSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+var typeConversions = map[[2]skydb.DataType]typeConversion{
+	{skydb.TypeString, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		s := value.(string)
+		if !decimalPattern.MatchString(s) {
+			return nil, false
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return number, err == nil
+	},
+	{skydb.TypeString, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		s := value.(string)
+		if !integerPattern.MatchString(s) {
+			return nil, false
+		}
+		number, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		return number, err == nil && inIntegerRange(float64(number))
+	},
+	{skydb.TypeString, skydb.TypeBoolean}: func(value interface{}) (interface{}, bool) {
+		b, ok := booleanStrings[strings.ToLower(strings.TrimSpace(value.(string)))]
+		return b, ok
+	},
+	{skydb.TypeString, skydb.TypeDateTime}: func(value interface{}) (interface{}, bool) {
+		return parseDatetime(value.(string))
+	},
+	{skydb.TypeNumber, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return strconv.FormatFloat(value.(float64), 'g', -1, 64), true
+	},
+	{skydb.TypeNumber, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		number := math.Round(value.(float64))
+		return int64(number), inIntegerRange(number)
+	},
+	{skydb.TypeNumber, skydb.TypeDateTime}: func(value interface{}) (interface{}, bool) {
+		number := value.(float64)
+		if number < minDatetime || number > maxDatetime {
+			return nil, false
+		}
+		return epochTime(number), true
+	},
+	{skydb.TypeInteger, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return strconv.FormatInt(value.(int64), 10), true
+	},
+	{skydb.TypeInteger, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		return float64(value.(int64)), true
+	},
+	{skydb.TypeInteger, skydb.TypeBoolean}: func(value interface{}) (interface{}, bool) {
+		return value.(int64) != 0, true
+	},
+	{skydb.TypeInteger, skydb.TypeDateTime}: func(value interface{}) (interface{}, bool) {
+		return time.Unix(value.(int64), 0).UTC(), true
+	},
+	{skydb.TypeBoolean, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return strconv.FormatBool(value.(bool)), true
+	},
+	{skydb.TypeBoolean, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		if value.(bool) {
+			return float64(1), true
+		}
+		return float64(0), true
+	},
+	{skydb.TypeBoolean, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		if value.(bool) {
+			return int64(1), true
+		}
+		return int64(0), true
+	},
+	{skydb.TypeDateTime, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		return builder.FormatDatetime(value.(time.Time)), true
+	},
+	{skydb.TypeDateTime, skydb.TypeNumber}: func(value interface{}) (interface{}, bool) {
+		return epochSeconds(value.(time.Time)), true
+	},
+	{skydb.TypeDateTime, skydb.TypeInteger}: func(value interface{}) (interface{}, bool) {
+		number := math.Floor(epochSeconds(value.(time.Time)))
+		return int64(number), inIntegerRange(number)
+	},
+	{skydb.TypeJSON, skydb.TypeString}: func(value interface{}) (interface{}, bool) {
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+		data, err := jsonText(value)
+		return data, err == nil
+	},
+}
+
+// parseDatetime parses an ISO 8601 timestamp, which is in UTC if no time
+// zone is specified.
+func parseDatetime(s string) (interface{}, bool) {
+	m := datetimePattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+
+	layout := "2006-01-02"
+	value := m[1]
+	if m[2] != "" {
+		layout += "T15:04"
+		value += "T" + m[2]
+		if m[3] != "" {
+			layout += ":05"
+			value += ":" + m[3] + m[4]
+		}
+	}
+
+	zone := m[5]
+	switch {
+	case zone == "" || zone == "Z":
+		zone = "+00:00"
+	case len(zone) == 3:
+		zone += ":00"
+	case len(zone) == 5:
+		zone = zone[:3] + ":" + zone[3:]
+	}
+
+	t, err := time.Parse(layout+"-07:00", value+zone)
+	if err != nil {
+		return nil, false
+	}
+	return t.UTC(), true
+}
+
+// AlterSchemaType converts the values of a field to the new type, failing
+// if any value cannot be converted. Constraints of the field are kept if
+// they are applicable to the new type, otherwise they are removed.
+func (db *database) AlterSchemaType(recordType, columnName string, fieldType skydb.FieldType, dryRun bool) (report skydb.TypeConversionReport, err error) {
+	if !dryRun && !db.c.canMigrate {
+		err = errMigrationDisabled
+		return
+	}
+
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+	if remoteRecordSchema == nil {
+		err = skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("record type %s does not exist", recordType))
+		return
+	}
+	remoteFieldType, ok := remoteRecordSchema[columnName]
+	if !ok {
+		err = skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("field %s does not exist", columnName))
+		return
+	}
+
+	conversion, err := skydb.GetTypeConversion(remoteFieldType, fieldType)
+	if err != nil {
+		err = skyerr.NewError(skyerr.NotSupported, err.Error())
+		return
+	}
+	convert, ok := typeConversions[[2]skydb.DataType{conversion.From, conversion.To}]
+	if !ok {
+		err = skyerr.NewError(skyerr.NotSupported, fmt.Sprintf(
+			"conversion from %s to %s is not supported",
+			remoteFieldType.ToSimpleName(),
+			fieldType.ToSimpleName(),
+		))
+		return
+	}
+	report.Conversion = conversion
+
+	converted, failedIDs, err := db.convertColumn(recordType, columnName, remoteFieldType, convert)
+	if err != nil {
+		return
+	}
+
+	report.FailedCount = uint64(len(failedIDs))
+	sort.Strings(failedIDs)
+	for i, id := range failedIDs {
+		if i >= skydb.MaxReportedConversionFailures {
+			break
+		}
+		report.FailedRecordIDs = append(report.FailedRecordIDs, skydb.NewRecordID(recordType, id))
+	}
+
+	if dryRun {
+		return
+	}
+
+	if report.FailedCount > 0 {
+		failedRecords := make([]string, len(report.FailedRecordIDs))
+		for i, id := range report.FailedRecordIDs {
+			failedRecords[i] = id.String()
+		}
+		err = skyerr.NewErrorWithInfo(
+			skyerr.IncompatibleSchema,
+			fmt.Sprintf(
+				"%d records cannot be converted from %s to %s",
+				report.FailedCount,
+				remoteFieldType.ToSimpleName(),
+				fieldType.ToSimpleName(),
+			),
+			map[string]interface{}{
+				"failed_count":   report.FailedCount,
+				"failed_records": failedRecords,
+			},
+		)
+		return
+	}
+
+	newFieldType := skydb.FieldType{Type: fieldType.Type}
+	err = db.c.write(func() error {
+		defer delete(db.c.RecordSchema, recordType)
+
+		constraints := remoteFieldType.Constraints
+		if constraints != nil {
+			if err := db.setFieldConstraints(recordType, columnName, skydb.FieldType{}); err != nil {
+				return err
+			}
+		}
+
+		stmt := fmt.Sprintf("UPDATE %s SET %s = ? WHERE _id = ?", db.TableName(recordType), quoteIdentifier(columnName))
+		for key, value := range converted {
+			sqlVal, err := sqlValue(newFieldType, value)
+			if err != nil {
+				return skyerr.NewError(skyerr.IncompatibleSchema, fmt.Sprintf("failed to convert %s: %s", columnName, err))
+			}
+			if _, err := db.c.Exec(stmt, sqlVal, key); err != nil {
+				return err
+			}
+		}
+
+		if _, err := db.c.ExecWith(sqlBuilder.Update("_record_field_type").
+			Set("type", fieldTypeName(newFieldType)).
+			Set("reference_type", nil).
+			Set("underlying_type", nil).
+			Where(sq.Eq{"record_type": recordType, "record_field": columnName})); err != nil {
+			return err
+		}
+
+		if constraints != nil && constraints.ValidateDefinition(fieldType) == nil {
+			newFieldType.Constraints = constraints
+			return db.setFieldConstraints(recordType, columnName, newFieldType)
+		}
+		return nil
+	})
+	return
+}
+
+// convertColumn converts the non-null values of a column, returning the
+// converted values keyed by record ID, and the IDs of the records of which
+// the value cannot be converted.
+func (db *database) convertColumn(recordType, columnName string, fieldType skydb.FieldType, convert typeConversion) (map[string]interface{}, []string, error) {
+	rows, err := db.c.Queryx(fmt.Sprintf(
+		"SELECT _id, %[1]s FROM %[2]s WHERE %[1]s IS NOT NULL",
+		quoteIdentifier(columnName), db.TableName(recordType),
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	converted := map[string]interface{}{}
+	failedIDs := []string{}
+	for rows.Next() {
+		var key string
+		var raw interface{}
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, nil, err
+		}
+
+		value, err := scanValue(fieldType, raw)
+		if err != nil {
+			failedIDs = append(failedIDs, key)
+			continue
+		}
+		if newValue, ok := convert(value); ok {
+			converted[key] = newValue
+		} else {
+			failedIDs = append(failedIDs, key)
+		}
+	}
+	return converted, failedIDs, rows.Err()
+}
@@ -0,0 +1,313 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) GetAsset(name string, a *skydb.Asset) error {
+	assets, err := c.GetAssets([]string{name})
+	if err != nil {
+		return err
+	}
+
+	if len(assets) == 0 {
+		return errors.New("asset not found")
+	}
+
+	*a = assets[0]
+	return nil
+}
+
+func (c *conn) GetAssets(names []string) ([]skydb.Asset, error) {
+	results := []skydb.Asset{}
+	if len(names) == 0 {
+		return results, nil
+	}
+
+	builder := sqlBuilder.Select("id", "content_type", "size").
+		From("_asset").
+		Where("id IN ("+sq.Placeholders(len(names))+")", stringArgs(names)...).
+		OrderBy("id")
+	return c.queryAssets(builder)
+}
+
+func (c *conn) queryAssets(builder sq.SelectBuilder) ([]skydb.Asset, error) {
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Asset{}
+	for rows.Next() {
+		a := skydb.Asset{}
+		if err := rows.Scan(&a.Name, &a.ContentType, &a.Size); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+	return results, rows.Err()
+}
+
+func (c *conn) SaveAsset(a *skydb.Asset) error {
+	return c.write(func() error {
+		_, err := c.Exec(
+			"INSERT OR REPLACE INTO _asset (id, content_type, size, updated_at) VALUES (?, ?, ?, ?)",
+			a.Name, a.ContentType, a.Size, timeNow())
+		return err
+	})
+}
+
+// assetReferencedCondition returns a condition which is true if an asset
+// field of a record, including a soft-deleted record, or of a revision in
+// the record history refers to the asset of the column.
+func (c *conn) assetReferencedCondition(column string) (string, error) {
+	rows, err := c.Queryx(
+		"SELECT record_type, record_field FROM _record_field_type WHERE type = 'asset' ORDER BY record_type, record_field")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	conditions := []string{}
+	for rows.Next() {
+		var recordType, field string
+		if err := rows.Scan(&recordType, &field); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM %s WHERE %s = %s)",
+			quoteIdentifier(recordType), quoteIdentifier(field), column))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// an asset in a revision is serialized as an object with "$type" and
+	// "$name", whose path is given by json_tree
+	conditions = append(conditions, fmt.Sprintf(
+		`EXISTS (SELECT 1 FROM _record_history AS h, json_tree(h.record) AS n
+		WHERE n.key = '$name' AND n.atom = %s
+		AND json_extract(h.record, n.path || '."$type"') = 'asset')`,
+		column))
+	return "(" + strings.Join(conditions, " OR ") + ")", nil
+}
+
+func (c *conn) QueryUnreferencedAssets(savedBefore time.Time) ([]skydb.Asset, error) {
+	referenced, err := c.assetReferencedCondition("_asset.id")
+	if err != nil {
+		return nil, err
+	}
+
+	builder := sqlBuilder.Select("id", "content_type", "size").
+		From("_asset").
+		Where("updated_at < ?", savedBefore.UTC()).
+		Where("NOT " + referenced).
+		OrderBy("id")
+	return c.queryAssets(builder)
+}
+
+func (c *conn) DeleteAsset(name string) error {
+	return c.write(func() error {
+		referenced, err := c.assetReferencedCondition("?")
+		if err != nil {
+			return err
+		}
+
+		var isReferenced bool
+		args := make([]interface{}, strings.Count(referenced, "?"))
+		for i := range args {
+			args[i] = name
+		}
+		if err := c.Get(&isReferenced, "SELECT "+referenced, args...); err != nil {
+			return err
+		}
+		if isReferenced {
+			return skydb.ErrAssetReferenced
+		}
+
+		result, err := c.Exec("DELETE FROM _asset WHERE id = ?", name)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rowsAffected == 0 {
+			return errors.New("asset not found")
+		}
+		return nil
+	})
+}
+
+func (c *conn) uploadSessionBuilder() sq.SelectBuilder {
+	return sqlBuilder.Select("id", "asset_name", "content_type", "length",
+		"upload_offset", "multipart_id", "parts", "created_at", "updated_at",
+		"expires_at").
+		From("_upload_session")
+}
+
+func (c *conn) doScanUploadSession(session *skydb.UploadSession, scanner sq.RowScanner) error {
+	var (
+		multipartID sql.NullString
+		parts       sql.NullString
+		expiresAt   *time.Time
+	)
+
+	err := scanner.Scan(
+		&session.ID,
+		&session.Name,
+		&session.ContentType,
+		&session.Length,
+		&session.Offset,
+		&multipartID,
+		&parts,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	session.MultipartID = multipartID.String
+	session.Parts = nil
+	if parts.Valid {
+		if err := json.Unmarshal([]byte(parts.String), &session.Parts); err != nil {
+			return err
+		}
+	}
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.UpdatedAt = session.UpdatedAt.UTC()
+	session.ExpiresAt = time.Time{}
+	if expiresAt != nil {
+		session.ExpiresAt = expiresAt.UTC()
+	}
+	return nil
+}
+
+func (c *conn) GetUploadSession(id string, session *skydb.UploadSession) error {
+	builder := c.uploadSessionBuilder().
+		Where("id = ?", id)
+
+	err := c.doScanUploadSession(session, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrUploadSessionNotFound
+	}
+	return err
+}
+
+// GetUploadSessionForUpdate fetches the UploadSession like
+// GetUploadSession. Transactions are begun with the write lock of the
+// database, so the UploadSession is already locked in a transaction.
+func (c *conn) GetUploadSessionForUpdate(id string, session *skydb.UploadSession) error {
+	return c.GetUploadSession(id, session)
+}
+
+func (c *conn) SaveUploadSession(session *skydb.UploadSession) error {
+	if session.ID == "" || session.Name == "" {
+		return errors.New("invalid upload session: empty id or asset name")
+	}
+
+	parts := session.Parts
+	if parts == nil {
+		parts = []asset.UploadedPart{}
+	}
+	partsJSON, err := json.Marshal(parts)
+	if err != nil {
+		return err
+	}
+
+	now := timeNow()
+	return c.write(func() error {
+		var createdAt time.Time
+		err := c.Get(&createdAt, "SELECT created_at FROM _upload_session WHERE id = ?", session.ID)
+		if err == sql.ErrNoRows {
+			createdAt = session.CreatedAt
+			if createdAt.IsZero() {
+				createdAt = now
+			}
+		} else if err != nil {
+			return err
+		}
+		createdAt = createdAt.UTC()
+
+		builder := sqlBuilder.Insert("_upload_session").
+			Options("OR REPLACE").
+			Columns("id", "asset_name", "content_type", "length",
+				"upload_offset", "multipart_id", "parts", "created_at", "updated_at",
+				"expires_at").
+			Values(session.ID, session.Name, session.ContentType, session.Length,
+				session.Offset, nullString(session.MultipartID), string(partsJSON),
+				createdAt, now, nullTime(&session.ExpiresAt))
+		if _, err := c.ExecWith(builder); err != nil {
+			return err
+		}
+
+		session.CreatedAt = createdAt
+		session.UpdatedAt = now
+		return nil
+	})
+}
+
+func (c *conn) DeleteUploadSession(id string) error {
+	return c.write(func() error {
+		result, err := c.Exec("DELETE FROM _upload_session WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rowsAffected == 0 {
+			return skydb.ErrUploadSessionNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) QueryExpiredUploadSessions(t time.Time) ([]skydb.UploadSession, error) {
+	builder := c.uploadSessionBuilder().
+		Where("expires_at <= ?", t.UTC()).
+		OrderBy("expires_at", "id")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []skydb.UploadSession{}
+	for rows.Next() {
+		session := skydb.UploadSession{}
+		if err := c.doScanUploadSession(&session, rows); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,603 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+// nullTime returns the time in UTC to be saved, or nil if the time is
+// not set. Times are compared as text in SQLite, so they must be saved
+// in the same time zone.
+func nullTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	tCopy := t.UTC()
+	return &tCopy
+}
+
+func (c *conn) CreateAuth(authinfo *skydb.AuthInfo) error {
+	return c.write(func() error {
+		providerInfo, err := json.Marshal(authinfo.ProviderInfo)
+		if err != nil {
+			return err
+		}
+
+		builder := sqlBuilder.Insert("_auth").Columns(
+			"id",
+			"password",
+			"provider_info",
+			"token_valid_since",
+			"last_seen_at",
+			"disabled",
+			"disabled_message",
+			"disabled_expiry",
+		).Values(
+			authinfo.ID,
+			authinfo.HashedPassword,
+			string(providerInfo),
+			nullTime(authinfo.TokenValidSince),
+			nullTime(authinfo.LastSeenAt),
+			authinfo.Disabled,
+			nullString(authinfo.DisabledMessage),
+			nullTime(authinfo.DisabledExpiry),
+		)
+
+		if _, err := c.ExecWith(builder); err != nil {
+			if isUniqueViolated(err) {
+				return skydb.ErrUserDuplicated
+			}
+			return err
+		}
+
+		return c.saveAuthRolesAndPasswordHistory(authinfo)
+	})
+}
+
+func (c *conn) UpdateAuth(authinfo *skydb.AuthInfo) error {
+	return c.write(func() error {
+		providerInfo, err := json.Marshal(authinfo.ProviderInfo)
+		if err != nil {
+			return err
+		}
+
+		builder := sqlBuilder.Update("_auth").
+			Set("password", authinfo.HashedPassword).
+			Set("provider_info", string(providerInfo)).
+			Set("token_valid_since", nullTime(authinfo.TokenValidSince)).
+			Set("last_seen_at", nullTime(authinfo.LastSeenAt)).
+			Set("disabled", authinfo.Disabled).
+			Set("disabled_message", nullString(authinfo.DisabledMessage)).
+			Set("disabled_expiry", nullTime(authinfo.DisabledExpiry)).
+			Where("id = ?", authinfo.ID)
+
+		result, err := c.ExecWith(builder)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrUserNotFound
+		}
+
+		return c.saveAuthRolesAndPasswordHistory(authinfo)
+	})
+}
+
+func (c *conn) saveAuthRolesAndPasswordHistory(authinfo *skydb.AuthInfo) error {
+	if err := c.updateUserRoles(authinfo.ID, authinfo.Roles); err != nil {
+		return skydb.ErrRoleUpdatesFailed
+	}
+
+	if c.passwordHistoryEnabled && authinfo.IsPasswordChanged() {
+		builder := sqlBuilder.Insert("_password_history").Columns(
+			"id",
+			"auth_id",
+			"password",
+			"logged_at",
+		).Values(
+			uuid.New(),
+			authinfo.ID,
+			authinfo.HashedPassword,
+			nullTime(authinfo.TokenValidSince),
+		)
+		if _, err := c.ExecWith(builder); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *conn) baseUserBuilder() sq.SelectBuilder {
+	return sqlBuilder.Select("id", "password", "provider_info",
+		"token_valid_since", "last_seen_at",
+		"disabled", "disabled_message", "disabled_expiry",
+		"(SELECT json_group_array(role_id) FROM _auth_role WHERE auth_id = id) AS roles").
+		From("_auth")
+}
+
+func (c *conn) doScanAuth(authinfo *skydb.AuthInfo, scanner sq.RowScanner) error {
+	var (
+		id              string
+		password        []byte
+		providerInfo    sql.NullString
+		tokenValidSince *time.Time
+		lastSeenAt      *time.Time
+		disabled        bool
+		disabledMessage sql.NullString
+		disabledExpiry  *time.Time
+		roles           string
+	)
+
+	err := scanner.Scan(
+		&id,
+		&password,
+		&providerInfo,
+		&tokenValidSince,
+		&lastSeenAt,
+		&disabled,
+		&disabledMessage,
+		&disabledExpiry,
+		&roles,
+	)
+	if err == sql.ErrNoRows {
+		return skydb.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	*authinfo = skydb.AuthInfo{
+		ID:              id,
+		HashedPassword:  password,
+		TokenValidSince: copyTime(tokenValidSince),
+		LastSeenAt:      copyTime(lastSeenAt),
+		Disabled:        disabled,
+	}
+	if providerInfo.Valid {
+		if err := json.Unmarshal([]byte(providerInfo.String), &authinfo.ProviderInfo); err != nil {
+			return err
+		}
+	}
+	if disabled {
+		authinfo.DisabledMessage = disabledMessage.String
+		authinfo.DisabledExpiry = copyTime(disabledExpiry)
+	}
+
+	authinfo.Roles = []string{}
+	if err := json.Unmarshal([]byte(roles), &authinfo.Roles); err != nil {
+		return err
+	}
+	sort.Strings(authinfo.Roles)
+
+	return nil
+}
+
+func (c *conn) GetAuth(id string, authinfo *skydb.AuthInfo) error {
+	builder := c.baseUserBuilder().Where("id = ?", id)
+	scanner := c.QueryRowWith(builder)
+	return c.doScanAuth(authinfo, scanner)
+}
+
+func (c *conn) GetAuthByPrincipalID(principalID string, authinfo *skydb.AuthInfo) error {
+	builder := c.baseUserBuilder().
+		Where("EXISTS (SELECT 1 FROM json_each(provider_info) WHERE key = ?)", principalID).
+		OrderBy("id").
+		Limit(1)
+	scanner := c.QueryRowWith(builder)
+	return c.doScanAuth(authinfo, scanner)
+}
+
+func (c *conn) DeleteAuth(id string) error {
+	return c.write(func() error {
+		builder := sqlBuilder.Delete("_auth").
+			Where("id = ?", id)
+
+		result, err := c.ExecWith(builder)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrUserNotFound
+		}
+
+		return nil
+	})
+}
+
+func (c *conn) basePasswordHistoryBuilder(authID string) sq.SelectBuilder {
+	return sqlBuilder.Select("id", "auth_id", "password", "logged_at").
+		From("_password_history").
+		Where("auth_id = ?", authID).
+		OrderBy("logged_at DESC")
+}
+
+func (c *conn) doQueryPasswordHistory(builder sq.SelectBuilder) ([]skydb.PasswordHistory, error) {
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []skydb.PasswordHistory{}
+	for rows.Next() {
+		var h skydb.PasswordHistory
+		if err := rows.Scan(&h.ID, &h.AuthID, &h.HashedPassword, &h.LoggedAt); err != nil {
+			return nil, err
+		}
+		h.LoggedAt = h.LoggedAt.UTC()
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+func (c *conn) GetPasswordHistory(authID string, historySize, historyDays int) ([]skydb.PasswordHistory, error) {
+	var err error
+	var sizeHistory, daysHistory []skydb.PasswordHistory
+	t := timeNow()
+
+	if historySize > 0 {
+		sizeBuilder := c.basePasswordHistoryBuilder(authID).Limit(uint64(historySize))
+		sizeHistory, err = c.doQueryPasswordHistory(sizeBuilder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if historyDays > 0 {
+		startOfDay := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		since := startOfDay.AddDate(0, 0, -historyDays)
+		daysBuilder := c.basePasswordHistoryBuilder(authID).
+			Where("logged_at >= ?", since.UTC())
+		daysHistory, err = c.doQueryPasswordHistory(daysBuilder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(sizeHistory) > len(daysHistory) {
+		return sizeHistory, nil
+	}
+
+	return daysHistory, nil
+}
+
+func (c *conn) RemovePasswordHistory(authID string, historySize, historyDays int) error {
+	history, err := c.GetPasswordHistory(authID, historySize, historyDays)
+	if err != nil {
+		return err
+	}
+
+	if len(history) <= 0 {
+		return nil
+	}
+
+	oldestTime := history[len(history)-1].LoggedAt
+	ids := []interface{}{}
+	for _, h := range history {
+		ids = append(ids, h.ID)
+	}
+
+	return c.write(func() error {
+		builder := sqlBuilder.Delete("_password_history").
+			Where("auth_id = ?", authID).
+			Where("id NOT IN ("+sq.Placeholders(len(ids))+")", ids...).
+			Where("logged_at < ?", oldestTime.UTC())
+
+		_, err := c.ExecWith(builder)
+		return err
+	})
+}
+
+func stringArgs(strs []string) []interface{} {
+	args := make([]interface{}, len(strs))
+	for i, s := range strs {
+		args[i] = s
+	}
+	return args
+}
+
+// ensureRole creates the roles not existed yet.
+func (c *conn) ensureRole(roles []string) error {
+	for _, role := range roles {
+		_, err := c.Exec("INSERT OR IGNORE INTO _role (id) VALUES (?)", role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) updateUserRoles(authID string, roles []string) error {
+	if err := c.ensureRole(roles); err != nil {
+		return err
+	}
+
+	if _, err := c.Exec("DELETE FROM _auth_role WHERE auth_id = ?", authID); err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		_, err := c.Exec("INSERT OR IGNORE INTO _auth_role (auth_id, role_id) VALUES (?, ?)", authID, role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) getRolesByType(col string) ([]string, error) {
+	builder := sqlBuilder.Select("id").
+		From("_role").
+		Where(col).
+		OrderBy("id")
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (c *conn) setRoleType(roles []string, col string) error {
+	return c.write(func() error {
+		if err := c.ensureRole(roles); err != nil {
+			return err
+		}
+
+		resetSQL := sqlBuilder.Update("_role").
+			Where(col+" = ?", true).
+			Set(col, false)
+		if _, err := c.ExecWith(resetSQL); err != nil {
+			return err
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+
+		updateSQL := sqlBuilder.Update("_role").
+			Where("id IN ("+sq.Placeholders(len(roles))+")", stringArgs(roles)...).
+			Set(col, true)
+		_, err := c.ExecWith(updateSQL)
+		return err
+	})
+}
+
+func (c *conn) GetAdminRoles() ([]string, error) {
+	return c.getRolesByType("is_admin")
+}
+
+func (c *conn) SetAdminRoles(roles []string) error {
+	return c.setRoleType(roles, "is_admin")
+}
+
+func (c *conn) GetDefaultRoles() ([]string, error) {
+	return c.getRolesByType("by_default")
+}
+
+func (c *conn) SetDefaultRoles(roles []string) error {
+	return c.setRoleType(roles, "by_default")
+}
+
+func (c *conn) AssignRoles(userIDs []string, roles []string) error {
+	return c.write(func() error {
+		if err := c.ensureRole(roles); err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			for _, role := range roles {
+				_, err := c.Exec(`
+INSERT OR IGNORE INTO _auth_role (auth_id, role_id)
+SELECT id, ? FROM _auth WHERE id = ?`, role, userID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (c *conn) RevokeRoles(userIDs []string, roles []string) error {
+	if len(userIDs) == 0 || len(roles) == 0 {
+		return nil
+	}
+
+	return c.write(func() error {
+		builder := sqlBuilder.Delete("_auth_role").
+			Where("auth_id IN ("+sq.Placeholders(len(userIDs))+")", stringArgs(userIDs)...).
+			Where("role_id IN ("+sq.Placeholders(len(roles))+")", stringArgs(roles)...)
+		_, err := c.ExecWith(builder)
+		return err
+	})
+}
+
+func (c *conn) GetRoles(userIDs []string) (map[string][]string, error) {
+	roleMap := map[string][]string{}
+	for _, userID := range userIDs {
+		// keep an empty array even no roles found for that user
+		roleMap[userID] = []string{}
+	}
+	if len(userIDs) == 0 {
+		return roleMap, nil
+	}
+
+	builder := sqlBuilder.Select("auth_id", "role_id").
+		From("_auth_role").
+		Where("auth_id IN ("+sq.Placeholders(len(userIDs))+")", stringArgs(userIDs)...).
+		OrderBy("auth_id", "role_id")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var authID, role string
+		if err := rows.Scan(&authID, &role); err != nil {
+			return nil, err
+		}
+		roleMap[authID] = append(roleMap[authID], role)
+	}
+
+	return roleMap, rows.Err()
+}
+
+func (c *conn) EnsureAuthRecordKeysExist(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+	schema, err := db.GetSchema(userRecordType)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve user record schema")
+	}
+
+	schemaToExtend := skydb.RecordSchema{}
+	for _, keys := range authRecordKeys {
+		for _, key := range keys {
+			if _, ok := schema[key]; ok {
+				continue
+			}
+
+			schemaToExtend[key] = skydb.FieldType{
+				Type: skydb.TypeString,
+			}
+		}
+	}
+
+	if _, err := db.Extend(userRecordType, schemaToExtend); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *conn) EnsureAuthRecordKeysIndexesMatch(authRecordKeys [][]string) error {
+	db := c.PublicDB().(*database)
+	userRecordType := db.UserRecordType()
+
+	required := map[string]skydb.Index{}
+	for _, keys := range authRecordKeys {
+		index := skydb.Index{
+			Fields: append([]string{}, keys...),
+			Unique: true,
+		}
+		required[joinFields(index.Fields)] = index
+	}
+
+	indexesByName, err := db.GetIndexesByRecordType(userRecordType)
+	if err != nil {
+		return err
+	}
+
+	// only a unique index on all rows guarantees unique auth record keys
+	existing := map[string]bool{}
+	for _, index := range indexesByName {
+		if index.Unique && index.Condition == "" {
+			existing[joinFields(index.Fields)] = true
+		}
+	}
+
+	for _, fieldsString := range sortedIndexKeys(required) {
+		if existing[fieldsString] {
+			continue
+		}
+		index := required[fieldsString]
+		if !c.canMigrate {
+			return fmt.Errorf("Index of %v is required in user record schema", index.Fields)
+		}
+
+		if err = db.SaveIndex(userRecordType, managedIndexName(userRecordType, index), index); err != nil {
+			return err
+		}
+	}
+
+	// cleanup unused unique constraint
+	if c.canMigrate {
+		for indexName, index := range indexesByName {
+			_, isRequired := required[joinFields(index.Fields)]
+			if !isRequired && indexName == managedIndexName(userRecordType, index) {
+				db.DeleteIndex(userRecordType, indexName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func joinFields(fields []string) string {
+	sorted := append([]string{}, fields...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func sortedIndexKeys(indexes map[string]skydb.Index) []string {
+	keys := make([]string, 0, len(indexes))
+	for key := range indexes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func managedIndexName(recordType string, index skydb.Index) string {
+	fields := append([]string{}, index.Fields...)
+	sort.Strings(fields)
+	return fmt.Sprintf("auth_record_keys_%s_%s_key", recordType, strings.Join(fields, "_"))
+}
@@ -0,0 +1,96 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var ErrCannotCompareUsingInOperator = errors.New(`cannot use "in" operator to compare the specified values`)
+
+// DatetimeLayout is the layout of datetime values stored in SQLite. The
+// values are in UTC with a fixed number of fractional digits, so that
+// they are ordered correctly when compared as text.
+const DatetimeLayout = "2006-01-02T15:04:05.000000Z"
+
+// FormatDatetime returns the text of a datetime value stored in SQLite.
+func FormatDatetime(t time.Time) string {
+	return t.UTC().Format(DatetimeLayout)
+}
+
+// LocationJSON returns the GeoJSON Point of a location, which is how
+// locations are stored in SQLite.
+func LocationJSON(location skydb.Location) string {
+	return fmt.Sprintf(
+		`{"type":"Point","coordinates":[%s,%s]}`,
+		strconv.FormatFloat(location.Lng(), 'g', -1, 64),
+		strconv.FormatFloat(location.Lat(), 'g', -1, 64),
+	)
+}
+
+// QuoteIdentifier quotes an identifier, such as the name of a table or a
+// column, to be used in a statement.
+func QuoteIdentifier(name string) string {
+	if end := strings.IndexRune(name, 0); end > -1 {
+		name = name[:end]
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func fullQuoteIdentifier(aliasName string, columnName string) string {
+	// If aliasName is empty, generate a identifier without qualifying
+	// it with an alias name.
+	if aliasName == "" {
+		return QuoteIdentifier(columnName)
+	}
+	return QuoteIdentifier(aliasName) + "." + QuoteIdentifier(columnName)
+}
+
+// SQLValue converts a literal of a predicate to the value it is stored
+// as in SQLite.
+func SQLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return FormatDatetime(v)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return FormatDatetime(*v)
+	case skydb.Reference:
+		return v.ID.Key
+	case *skydb.Asset:
+		return v.Name
+	case skydb.Location:
+		return LocationJSON(v)
+	case *skydb.Location:
+		return LocationJSON(*v)
+	case skydb.Geometry, map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			panic(fmt.Sprintf("unable to marshal %T: %s", v, err))
+		}
+		return string(data)
+	default:
+		return value
+	}
+}
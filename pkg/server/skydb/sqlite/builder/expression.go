@@ -0,0 +1,116 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+type expressionSqlizer struct {
+	// Alias is the name to qualify a SQL identifier. Could be empty
+	// if there is no identifier or if the identifier does not need
+	// to be qualified. This is usually the table name of the table alias.
+	alias string
+
+	// FieldType contains the database field type when available. If the
+	// expression is a literal or is a computed value (such as function),
+	// the field type maybe derived from the expression value. If not
+	// available, the field type may be empty.
+	fieldType skydb.FieldType
+
+	skydb.Expression
+}
+
+func NewExpressionSqlizer(alias string, fieldType skydb.FieldType, expr skydb.Expression) sq.Sqlizer {
+	return newExpressionSqlizer(alias, fieldType, expr)
+}
+
+func newExpressionSqlizer(alias string, fieldType skydb.FieldType, expr skydb.Expression) expressionSqlizer {
+	return expressionSqlizer{
+		alias,
+		fieldType,
+		expr,
+	}
+}
+
+// caseInsensitive returns true if the expression is a case-insensitive
+// string field.
+func (expr expressionSqlizer) caseInsensitive() bool {
+	return expr.Type == skydb.KeyPath && expr.fieldType.UnderlyingType == "citext"
+}
+
+func (expr expressionSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	switch expr.Type {
+	case skydb.KeyPath:
+		components := expr.KeyPathComponents()
+		lastComponent := components[len(components)-1]
+		sql = fullQuoteIdentifier(expr.alias, lastComponent)
+		args = []interface{}{}
+	case skydb.Function:
+		sql, args = funcToSQLOperand(expr.alias, expr.Value.(skydb.Func))
+	default:
+		sql, args = LiteralToSQLOperand(expr.Value)
+	}
+	return
+}
+
+func funcToSQLOperand(alias string, fun skydb.Func) (string, []interface{}) {
+	switch f := fun.(type) {
+	case skydb.DistanceFunc:
+		sql := fmt.Sprintf("skygear_distance(%s, ?, ?)",
+			fullQuoteIdentifier(alias, f.Field))
+		args := []interface{}{f.Location.Lng(), f.Location.Lat()}
+		return sql, args
+	case skydb.CountFunc:
+		var sql string
+		if f.OverallRecords {
+			sql = fmt.Sprintf("COUNT(*) OVER()")
+		} else {
+			sql = fmt.Sprintf("COUNT(*)")
+		}
+		args := []interface{}{}
+		return sql, args
+	default:
+		panic(fmt.Errorf("got unrecgonized skydb.Func = %T", fun))
+	}
+}
+
+func LiteralToSQLOperand(literal interface{}) (string, []interface{}) {
+	// Array detection is borrowed from squirrel's expr.go
+	switch literalValue := literal.(type) {
+	case []interface{}:
+		argCount := len(literalValue)
+		if argCount > 0 {
+			args := make([]interface{}, len(literalValue))
+			for i, val := range literalValue {
+				args[i] = SQLValue(val)
+			}
+			return "(" + sq.Placeholders(len(literalValue)) + ")", args
+		}
+
+		// NOTE(limouren): trick to make `field IN (...)` work for empty list
+		// NULL field won't match the condition since NULL == NULL is falsy,
+		// which renders `field IN(NULL)` equivalent to FALSE
+		return "(NULL)", nil
+	default:
+		if literal == nil {
+			return "NULL", []interface{}{}
+		}
+		return sq.Placeholders(1), []interface{}{SQLValue(literal)}
+	}
+}
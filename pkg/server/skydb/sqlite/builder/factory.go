@@ -0,0 +1,468 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+type SqlizerFactory interface {
+	NewPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error)
+	NewAccessControlSqlizer(user *skydb.AuthInfo, aclLevel skydb.RecordACLLevel) (sq.Sqlizer, error)
+	NewSort(s skydb.Sort) (string, error)
+	UpdateTypemap(typemap skydb.RecordSchema) skydb.RecordSchema
+	AddJoinsToSelectBuilder(q sq.SelectBuilder) sq.SelectBuilder
+}
+
+// sqlizerFactory is a factory for creating sqlizer for predicate & sort
+type sqlizerFactory struct {
+	db           skydb.Database
+	primaryTable string
+	joinedTables []joinedTable
+	extraColumns map[string]skydb.FieldType
+
+	// alias is the alias of the primary table, which is the same as
+	// primaryTable except in a subquery.
+	alias string
+
+	// aliasPrefix is prepended to the alias of the tables joined and
+	// the subqueries created by the factory, so that they do not clash
+	// with the aliases of an enclosing query.
+	aliasPrefix string
+
+	// databaseID restricts the records of joined tables and subqueries
+	// to a database. Records of all databases are matched if it is nil.
+	databaseID *string
+
+	// accessControlOptions is applied to the records of joined tables
+	// and subqueries. No access control is applied if it is nil.
+	accessControlOptions *skydb.AccessControlOptions
+
+	subqueryCount int
+}
+
+func NewSqlizerFactory(db skydb.Database, primaryTable string) SqlizerFactory {
+	return &sqlizerFactory{
+		db:           db,
+		primaryTable: primaryTable,
+		joinedTables: []joinedTable{},
+		alias:        primaryTable,
+	}
+}
+
+// NewIndexSqlizerFactory returns a SqlizerFactory for the condition of a
+// partial index on primaryTable, in which columns are not qualified with
+// the table name.
+func NewIndexSqlizerFactory(db skydb.Database, primaryTable string) SqlizerFactory {
+	return &sqlizerFactory{
+		db:           db,
+		primaryTable: primaryTable,
+		joinedTables: []joinedTable{},
+	}
+}
+
+// NewQuerySqlizerFactory returns a SqlizerFactory for querying the records
+// of primaryTable. Records of joined tables and subqueries are restricted
+// to the database of databaseID, unless it is nil, and filtered with
+// accessControlOptions in the same way as the queried records.
+func NewQuerySqlizerFactory(db skydb.Database, primaryTable string, databaseID *string, accessControlOptions *skydb.AccessControlOptions) SqlizerFactory {
+	return &sqlizerFactory{
+		db:                   db,
+		primaryTable:         primaryTable,
+		joinedTables:         []joinedTable{},
+		alias:                primaryTable,
+		databaseID:           databaseID,
+		accessControlOptions: accessControlOptions,
+	}
+}
+
+func (f *sqlizerFactory) NewPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error) {
+	if p.IsEmpty() {
+		panic("no sqlizer can be created from an empty predicate")
+	}
+
+	if p.Operator == skydb.Functional {
+		return f.newFunctionalPredicateSqlizer(p)
+	}
+	if p.Operator == skydb.Exists {
+		return f.newExistsPredicateSqlizer(p)
+	}
+	if p.Operator.IsCompound() {
+		return f.newCompoundPredicateSqlizer(p)
+	}
+	return f.newComparisonPredicateSqlizer(p)
+}
+
+func (f *sqlizerFactory) newCompoundPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error) {
+	switch p.Operator {
+	default:
+		err := fmt.Errorf("compound operator `%v` is not supported", p.Operator)
+		return nil, err
+	case skydb.And:
+		and := make(sq.And, len(p.Children))
+		for i, child := range p.Children {
+			sqlizer, err := f.NewPredicateSqlizer(child.(skydb.Predicate))
+			if err != nil {
+				return nil, err
+			}
+			and[i] = sqlizer
+		}
+		return and, nil
+	case skydb.Or:
+		or := make(sq.Or, len(p.Children))
+		for i, child := range p.Children {
+			sqlizer, err := f.NewPredicateSqlizer(child.(skydb.Predicate))
+			if err != nil {
+				return nil, err
+			}
+			or[i] = sqlizer
+		}
+		return or, nil
+	case skydb.Not:
+		pred := p.Children[0].(skydb.Predicate)
+		sqlizer, err := f.NewPredicateSqlizer(pred)
+		if err != nil {
+			return nil, err
+		}
+		return NotSqlizer{sqlizer}, nil
+	}
+}
+
+func (f *sqlizerFactory) newFunctionalPredicateSqlizer(predicate skydb.Predicate) (sq.Sqlizer, error) {
+	expr := predicate.Children[0].(skydb.Expression)
+	if expr.Type != skydb.Function {
+		panic("unexpected expression in functional predicate")
+	}
+	switch fn := expr.Value.(type) {
+	case skydb.UserRelationFunc:
+		return f.newUserRelationFunctionalPredicateSqlizer(fn)
+	default:
+		panic("the specified function cannot be used as a functional predicate")
+	}
+}
+
+func (f *sqlizerFactory) newUserRelationFunctionalPredicateSqlizer(fn skydb.UserRelationFunc) (sq.Sqlizer, error) {
+	table := fn.RelationName
+	direction := fn.RelationDirection
+	if direction == "" {
+		direction = "outward"
+	}
+	primaryColumn := fn.KeyPath
+	if primaryColumn == "_owner" || primaryColumn == "" {
+		primaryColumn = "_owner_id"
+	}
+
+	var outwardAlias, inwardAlias string
+	if direction == "outward" || direction == "mutual" {
+		outwardAlias = f.createLeftJoin(table, primaryColumn, "right_id")
+	}
+	if direction == "inward" || direction == "mutual" {
+		inwardAlias = f.createLeftJoin(table, primaryColumn, "left_id")
+	}
+
+	return userRelationPredicateSqlizer{
+		outwardAlias: outwardAlias,
+		inwardAlias:  inwardAlias,
+		user:         fn.User,
+	}, nil
+}
+
+func (f *sqlizerFactory) NewAccessControlSqlizer(user *skydb.AuthInfo, aclLevel skydb.RecordACLLevel) (sq.Sqlizer, error) {
+	return &accessPredicateSqlizer{
+		f.alias,
+		user,
+		aclLevel,
+	}, nil
+}
+
+func (f *sqlizerFactory) newComparisonPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error) {
+	if sqlizer, ok := f.tryOptimizeDistancePredicate(p); ok {
+		return sqlizer, nil
+	}
+
+	if p.Operator == skydb.In && p.Children[1].(skydb.Expression).IsSubquery() {
+		return f.newInSubqueryPredicateSqlizer(p)
+	}
+
+	sqlizers := []expressionSqlizer{}
+	for _, child := range p.Children {
+		sqlizer, err := f.newExpressionPredicateSqlizer(child.(skydb.Expression))
+		if err != nil {
+			return nil, err
+		}
+		sqlizers = append(sqlizers, sqlizer)
+	}
+
+	if p.Operator == skydb.In {
+		return &containsComparisonPredicateSqlizer{sqlizers}, nil
+	}
+	return &comparisonPredicateSqlizer{sqlizers, p.Operator}, nil
+}
+
+// tryOptimizeDistancePredicate returns a sqlizer that is more efficient
+// at querying whether two points are within certain distance.
+//
+// If the predicate cannot be optimize or an error occurred generating
+// an optimized sqlizer, the second value returned is false.
+func (f *sqlizerFactory) tryOptimizeDistancePredicate(p skydb.Predicate) (sq.Sqlizer, bool) {
+	var tryFunc skydb.Expression
+	var tryValue skydb.Expression
+	if p.Operator == skydb.LessThan {
+		tryFunc = p.Children[0].(skydb.Expression)
+		tryValue = p.Children[1].(skydb.Expression)
+	} else if p.Operator == skydb.GreaterThan {
+		tryFunc = p.Children[1].(skydb.Expression)
+		tryValue = p.Children[0].(skydb.Expression)
+	} else {
+		return nil, false
+	}
+
+	distanceFunc, ok := tryFunc.Value.(skydb.DistanceFunc)
+	if !ok {
+		return nil, false
+	}
+
+	distanceValue, err := f.newExpressionPredicateSqlizer(tryValue)
+	if err != nil {
+		return nil, false
+	}
+
+	return &distancePredicateSqlizer{
+		f.alias,
+		distanceFunc.Field,
+		distanceFunc.Location,
+		distanceValue,
+	}, true
+}
+
+func (f *sqlizerFactory) newExpressionPredicateSqlizer(expr skydb.Expression) (expressionSqlizer, error) {
+	if expr.IsKeyPath() {
+		return f.newExpressionPredicateSqlizerForKeyPath(expr)
+	}
+
+	if expr.Type == skydb.Literal {
+		var fieldType skydb.FieldType
+		if expr.Value != nil {
+			var err error
+			fieldType, err = skydb.DeriveFieldType(expr.Value)
+			if err != nil {
+				return expressionSqlizer{}, err
+			}
+		}
+
+		sqlizer := newExpressionSqlizer(f.alias, fieldType, expr)
+		return sqlizer, nil
+	}
+
+	if expr.Type == skydb.Function {
+		funcInterface, ok := expr.Value.(skydb.Func)
+		if !ok {
+			panic(`expression value is not a function`)
+		}
+		return newExpressionSqlizer(f.alias, skydb.FieldType{Type: funcInterface.DataType()}, expr), nil
+	}
+
+	return expressionSqlizer{}, skyerr.NewError(skyerr.RecordQueryInvalid,
+		`unexpected expression type`)
+}
+
+func (f *sqlizerFactory) newExpressionPredicateSqlizerForKeyPath(expr skydb.Expression) (expressionSqlizer, error) {
+	if !expr.IsKeyPath() {
+		panic("expression is not a key path")
+	}
+
+	alias, field, err := f.joinKeyPath(expr)
+	if err != nil {
+		return expressionSqlizer{}, err
+	}
+	return newExpressionSqlizer(alias, field, expr), nil
+}
+
+func (f *sqlizerFactory) newExpressionSortForKeyPath(expr skydb.Expression) (string, error) {
+	if !expr.IsKeyPath() {
+		panic("expression is not a key path")
+	}
+
+	alias, _, err := f.joinKeyPath(expr)
+	if err != nil {
+		return "", err
+	}
+
+	components := expr.KeyPathComponents()
+	lastComponent := components[len(components)-1]
+	sortKey := fullQuoteIdentifier(alias, lastComponent)
+	if alias != f.alias {
+		sortKey = "_sort" + lastComponent + alias
+		f.addExtraColumn(sortKey, skydb.TypeReference, expr, alias)
+	}
+	return sortKey, nil
+}
+
+// joinKeyPath joins the tables of the records referenced by each
+// component of the keypath except the last one, and returns the alias
+// of the table containing the last component and its field type.
+func (f *sqlizerFactory) joinKeyPath(expr skydb.Expression) (string, skydb.FieldType, error) {
+	components := expr.KeyPathComponents()
+	keyPath := expr.Value.(string)
+
+	alias := f.alias
+	fields, err := skydb.TraverseColumnTypes(f.db, f.primaryTable, keyPath)
+	if err != nil {
+		return "", skydb.FieldType{}, skyerr.NewError(skyerr.RecordQueryInvalid, err.Error())
+	}
+
+	field := skydb.FieldType{}
+	for i, keyPathField := range fields {
+		isLast := (i == len(components)-1)
+		field = keyPathField
+		if field.Type == skydb.TypeReference && !isLast {
+			alias = f.createReferenceJoin(alias, field.ReferenceType, components[i])
+		}
+	}
+	return alias, field, nil
+}
+
+// createLeftJoin create an alias of a table to be joined to the primary table
+// and return the alias for the joined table
+func (f *sqlizerFactory) createLeftJoin(secondaryTable string, primaryColumn string, secondaryColumn string) string {
+	return f.addJoinedTable(joinedTable{f.alias, secondaryTable, primaryColumn, secondaryColumn, false})
+}
+
+// createReferenceJoin joins the table of the record type referenced by
+// the column of the table of sourceAlias, and return the alias for the
+// joined table. Records of the joined table are filtered in the same way
+// as records in a subquery.
+func (f *sqlizerFactory) createReferenceJoin(sourceAlias string, recordType string, column string) string {
+	return f.addJoinedTable(joinedTable{sourceAlias, recordType, column, "_id", true})
+}
+
+func (f *sqlizerFactory) addJoinedTable(newAlias joinedTable) string {
+	secondaryTable := newAlias.secondaryTable
+	for i, alias := range f.joinedTables {
+		if alias.equal(newAlias) {
+			return f.aliasName(secondaryTable, i)
+		}
+	}
+
+	f.joinedTables = append(f.joinedTables, newAlias)
+	return f.aliasName(secondaryTable, len(f.joinedTables)-1)
+}
+
+func (f *sqlizerFactory) aliasName(secondaryTable string, indexInJoinedTables int) string {
+	// The _auth table always have the same alias name for
+	// getting user info in user discovery
+	if secondaryTable == "_auth" {
+		return "_auth"
+	}
+	return fmt.Sprintf("%s_t%d", f.aliasPrefix, indexInJoinedTables)
+}
+
+// AddJoinsToSelectBuilder adds join clauses to a SelectBuilder
+func (f *sqlizerFactory) AddJoinsToSelectBuilder(q sq.SelectBuilder) sq.SelectBuilder {
+	for i, alias := range f.joinedTables {
+		aliasName := f.aliasName(alias.secondaryTable, i)
+		joinClause := fmt.Sprintf("%s AS %s ON %s = %s",
+			f.db.TableName(alias.secondaryTable), QuoteIdentifier(aliasName),
+			fullQuoteIdentifier(alias.sourceAlias, alias.primaryColumn),
+			fullQuoteIdentifier(aliasName, alias.secondaryColumn))
+
+		if !alias.record {
+			q = q.LeftJoin(joinClause)
+			continue
+		}
+
+		// A referenced record not matching the conditions is joined
+		// as NULL, as if the reference does not exist.
+		joinArgs := []interface{}{}
+		for _, cond := range f.recordConditions(alias.secondaryTable, aliasName) {
+			condSQL, condArgs, _ := cond.ToSql()
+			joinClause += " AND " + condSQL
+			joinArgs = append(joinArgs, condArgs...)
+		}
+		q = q.LeftJoin(joinClause, joinArgs...)
+	}
+
+	if len(f.joinedTables) > 0 {
+		q = q.Distinct()
+	}
+	return q
+}
+
+func (f *sqlizerFactory) addExtraColumn(key string, fieldType skydb.DataType, expr skydb.Expression, refrenceType string) {
+	if f.extraColumns == nil {
+		f.extraColumns = map[string]skydb.FieldType{}
+	}
+	f.extraColumns[key] = skydb.FieldType{
+		Type:          fieldType,
+		Expression:    expr,
+		ReferenceType: refrenceType,
+	}
+}
+
+func (f *sqlizerFactory) UpdateTypemap(typemap skydb.RecordSchema) skydb.RecordSchema {
+	for key, field := range f.extraColumns {
+		typemap[key] = field
+	}
+	return typemap
+}
+
+// joinedTable represents a specification for table join
+type joinedTable struct {
+	sourceAlias     string
+	secondaryTable  string
+	primaryColumn   string
+	secondaryColumn string
+
+	// record is true if the secondary table is the table of a record
+	// type joined by a reference.
+	record bool
+}
+
+// equal compares whether two specifications of table join are equal
+func (a joinedTable) equal(b joinedTable) bool {
+	return a == b
+}
+
+func (f *sqlizerFactory) NewSort(s skydb.Sort) (string, error) {
+	var expr string
+	switch s.Expression.Type {
+	case skydb.KeyPath:
+		var err error
+		expr, err = f.newExpressionSortForKeyPath(s.Expression)
+		if err != nil {
+			return "", err
+		}
+	case skydb.Function:
+		var err error
+		expr, err = funcOrderBySQL(f.alias, s.Expression.Value.(skydb.Func))
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("invalid Sort: specify either KeyPath or Func")
+	}
+
+	order, err := sortOrderOrderBySQL(s.Order)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(expr + " " + order), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InlineArgs replaces the placeholders in the SQL with the quoted literal
+// of the args. It is used for statements that do not accept parameters,
+// such as the condition of a partial index.
+func InlineArgs(sql string, args []interface{}) (string, error) {
+	buf := bytes.Buffer{}
+	argIndex := 0
+	var quote rune
+	for _, r := range sql {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			if argIndex >= len(args) {
+				return "", fmt.Errorf("got more placeholders than %d args", len(args))
+			}
+			literal, err := QuoteLiteral(args[argIndex])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(literal)
+			argIndex++
+			continue
+		}
+		buf.WriteRune(r)
+	}
+
+	if argIndex != len(args) {
+		return "", fmt.Errorf("got %d placeholders, want %d", argIndex, len(args))
+	}
+	return buf.String(), nil
+}
+
+// QuoteLiteral quotes a value as an SQL literal. Values are converted to
+// the form stored in SQLite by SQLValue before quoting.
+func QuoteLiteral(value interface{}) (string, error) {
+	switch v := SQLValue(value).(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		return quoteString(v), nil
+	case []byte:
+		return quoteString(string(v)), nil
+	default:
+		return "", fmt.Errorf("unable to quote value of type %T as literal", value)
+	}
+}
+
+func quoteString(s string) string {
+	return `'` + strings.Replace(s, `'`, `''`, -1) + `'`
+}
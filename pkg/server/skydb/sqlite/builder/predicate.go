@@ -0,0 +1,264 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// accessPredicateSqlizer build the json matching expression base on user's
+// role, the builded express will filter out record which user is not accessible.
+//
+// The ACL entries in "_access" are matched with json_each, for example the
+// sql for record accessible by user rickmak or admin role is
+// `EXISTS (SELECT 1 FROM json_each("_access") WHERE
+// json_extract(value, '$.role') IN ('admin') OR
+// json_extract(value, '$.user_id') = 'rickmak')`
+type accessPredicateSqlizer struct {
+	alias string
+	user  *skydb.AuthInfo
+	level skydb.RecordACLLevel
+}
+
+func (p accessPredicateSqlizer) ToSql() (string, []interface{}, error) {
+	accessColumn := fullQuoteIdentifier(p.alias, "_access")
+	entryConds := []string{}
+	args := []interface{}{}
+
+	if p.user != nil {
+		if p.user.ID == "" {
+			panic("cannot build access predicate without user")
+		}
+
+		if len(p.user.Roles) > 0 {
+			entryConds = append(entryConds, fmt.Sprintf(
+				"json_extract(value, '$.role') IN (%s)",
+				sq.Placeholders(len(p.user.Roles)),
+			))
+			for _, role := range p.user.Roles {
+				args = append(args, role)
+			}
+		}
+		entryConds = append(entryConds, "json_extract(value, '$.user_id') = ?")
+		args = append(args, p.user.ID)
+	}
+
+	if p.level == skydb.ReadLevel {
+		entryConds = append(entryConds, "json_extract(value, '$.public') = 1")
+	} else if p.level == skydb.WriteLevel {
+		entryConds = append(entryConds, "(json_extract(value, '$.public') = 1 AND json_extract(value, '$.level') = 'write')")
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`(`)
+	if len(entryConds) > 0 {
+		b.WriteString(fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM json_each(%s) WHERE %s) OR ",
+			accessColumn,
+			strings.Join(entryConds, " OR "),
+		))
+	}
+	if p.user != nil {
+		b.WriteString(fmt.Sprintf(`%s = ? OR `, fullQuoteIdentifier(p.alias, "_owner_id")))
+		args = append(args, p.user.ID)
+	}
+	b.WriteString(fmt.Sprintf(`%s IS NULL)`, accessColumn))
+
+	return b.String(), args, nil
+}
+
+type userRelationPredicateSqlizer struct {
+	outwardAlias string
+	inwardAlias  string
+	user         string
+}
+
+func (p userRelationPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	if p.outwardAlias != "" && p.inwardAlias != "" {
+		sql = fmt.Sprintf("%s = %s AND %s = ?",
+			fullQuoteIdentifier(p.outwardAlias, "left_id"),
+			fullQuoteIdentifier(p.inwardAlias, "right_id"),
+			fullQuoteIdentifier(p.outwardAlias, "left_id"))
+	} else if p.outwardAlias != "" {
+		sql = fmt.Sprintf("%s = ?",
+			fullQuoteIdentifier(p.outwardAlias, "left_id"))
+	} else if p.inwardAlias != "" {
+		sql = fmt.Sprintf("%s = ?",
+			fullQuoteIdentifier(p.inwardAlias, "right_id"))
+	} else {
+		panic("unexpected value in sqlizer")
+	}
+	args = []interface{}{p.user}
+	err = nil
+	return
+}
+
+type containsComparisonPredicateSqlizer struct {
+	sqlizers []expressionSqlizer
+}
+
+func (p *containsComparisonPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	lhs := p.sqlizers[0]
+	rhs := p.sqlizers[1]
+
+	lhsSQL, lhsArgs, err := lhs.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	rhsSQL, rhsArgs, err := rhs.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if lhs.fieldType.Type.IsGeometryCompatibleType() && rhs.fieldType.Type.IsGeometryCompatibleType() {
+		// The geometry of rhs contains the point of lhs.
+		sql = fmt.Sprintf("skygear_contains(%s, %s)", rhsSQL, lhsSQL)
+		args = append(rhsArgs, lhsArgs...)
+		return sql, args, nil
+	} else if lhs.Type == skydb.Literal && rhs.Type == skydb.KeyPath {
+		// The JSON array of rhs contains the value of lhs.
+		sql = fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value = %s)", rhsSQL, lhsSQL)
+		args = append(rhsArgs, lhsArgs...)
+		return sql, args, nil
+	} else if lhs.Type == skydb.KeyPath && rhs.Type == skydb.Literal {
+		sql = fmt.Sprintf("%s IN %s", lhsSQL, rhsSQL)
+		args = append(lhsArgs, rhsArgs...)
+		return sql, args, nil
+	}
+
+	// Note: "In" operator may be used to compare other types of values
+	// but the generated SQL depends on the types of values being compared.
+	// It is currently not supported to compare two keypaths,
+	// unless they are geometry types.  cf. #345
+	return "", []interface{}{}, ErrCannotCompareUsingInOperator
+}
+
+type comparisonPredicateSqlizer struct {
+	sqlizers []expressionSqlizer
+	operator skydb.Operator
+}
+
+func (p *comparisonPredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	args = []interface{}{}
+	if !p.operator.IsBinary() {
+		err = fmt.Errorf("comparison operator `%v` is not supported", p.operator)
+		return
+	}
+
+	lhs := p.sqlizers[0]
+	rhs := p.sqlizers[1]
+
+	if p.operator.IsCommutative() {
+		if lhs.Expression.IsLiteralNull() && !rhs.Expression.IsLiteralNull() {
+			// In SQL, NULL must be on the right side of a comparison
+			// operator.
+			lhs, rhs = rhs, lhs
+		}
+	}
+
+	lhsSQL, lhsArgs, err := lhs.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	rhsSQL, rhsArgs, err := rhs.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, lhsArgs...)
+	args = append(args, rhsArgs...)
+
+	if rhs.IsLiteralNull() {
+		switch p.operator {
+		case skydb.Equal:
+			return lhsSQL + " IS " + rhsSQL, args, nil
+		case skydb.NotEqual:
+			return lhsSQL + " IS NOT " + rhsSQL, args, nil
+		}
+	}
+
+	switch p.operator {
+	default:
+		return "", nil, fmt.Errorf("comparison operator `%v` is not supported", p.operator)
+	case skydb.Equal:
+		sql = lhsSQL + `=` + rhsSQL
+	case skydb.GreaterThan:
+		sql = lhsSQL + `>` + rhsSQL
+	case skydb.LessThan:
+		sql = lhsSQL + `<` + rhsSQL
+	case skydb.GreaterThanOrEqual:
+		sql = lhsSQL + `>=` + rhsSQL
+	case skydb.LessThanOrEqual:
+		sql = lhsSQL + `<=` + rhsSQL
+	case skydb.NotEqual:
+		sql = lhsSQL + `<>` + rhsSQL
+	case skydb.Like:
+		// LIKE is case sensitive in the connections of the driver,
+		// except for case-insensitive strings as in PostgreSQL.
+		if lhs.caseInsensitive() {
+			sql = fmt.Sprintf(`lower(%s) LIKE lower(%s) ESCAPE '\'`, lhsSQL, rhsSQL)
+		} else {
+			sql = fmt.Sprintf(`%s LIKE %s ESCAPE '\'`, lhsSQL, rhsSQL)
+		}
+	case skydb.ILike:
+		sql = fmt.Sprintf(`lower(%s) LIKE lower(%s) ESCAPE '\'`, lhsSQL, rhsSQL)
+	}
+	return sql, args, nil
+}
+
+// NotSqlizer generates SQL condition that negates a boolean condition
+type NotSqlizer struct {
+	Predicate sq.Sqlizer
+}
+
+// ToSql generates SQL for NotSqlizer
+func (s NotSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	sql, args, err = s.Predicate.ToSql()
+	if err != nil {
+		return
+	}
+	sql = fmt.Sprintf("NOT (%s)", sql)
+	return
+}
+
+// distancePredicateSqlizer generates SQL condition that calculates if a
+// location is within a certain distance.
+type distancePredicateSqlizer struct {
+	alias    string
+	field    string
+	location skydb.Location
+	distance expressionSqlizer
+}
+
+// ToSql generates SQL for distancePredicateSqlizer
+func (s distancePredicateSqlizer) ToSql() (sql string, args []interface{}, err error) {
+	distanceSQL, distanceArgs, err := s.distance.ToSql()
+	if err != nil {
+		return
+	}
+
+	sql = fmt.Sprintf(
+		"skygear_distance(%s, ?, ?) <= %s",
+		fullQuoteIdentifier(s.alias, s.field),
+		distanceSQL,
+	)
+	args = []interface{}{s.location.Lng(), s.location.Lat()}
+	args = append(args, distanceArgs...)
+	return
+}
@@ -0,0 +1,152 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/mock_skydb"
+)
+
+func TestPredicateSqlizerFactory(t *testing.T) {
+	Convey("Predicate", t, func() {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		db := mock_skydb.NewMockDatabase(ctrl)
+		db.EXPECT().RemoteColumnTypes(gomock.Eq("note")).
+			Return(
+				skydb.RecordSchema{
+					"title":    skydb.FieldType{Type: skydb.TypeString},
+					"tags":     skydb.FieldType{Type: skydb.TypeJSON},
+					"location": skydb.FieldType{Type: skydb.TypeLocation},
+					"area":     skydb.FieldType{Type: skydb.TypeGeometry},
+					"email":    skydb.FieldType{Type: skydb.TypeUnknown, UnderlyingType: "citext"},
+				}, nil,
+			).AnyTimes()
+		db.EXPECT().TableName(gomock.Any()).DoAndReturn(QuoteIdentifier).AnyTimes()
+
+		f := NewSqlizerFactory(db, "note")
+		toSQL := func(p skydb.Predicate) (string, []interface{}) {
+			sqlizer, err := f.NewPredicateSqlizer(p)
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			return sql, args
+		}
+
+		Convey("compares with null", func() {
+			sql, args := toSQL(skydb.Predicate{
+				Operator: skydb.NotEqual,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "title"},
+					skydb.Expression{Type: skydb.Literal, Value: nil},
+				},
+			})
+			So(sql, ShouldEqual, `"note"."title" IS NOT NULL`)
+			So(args, ShouldBeEmpty)
+		})
+
+		Convey("matches like with escape", func() {
+			sql, args := toSQL(skydb.Predicate{
+				Operator: skydb.Like,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "title"},
+					skydb.Expression{Type: skydb.Literal, Value: "hello%"},
+				},
+			})
+			So(sql, ShouldEqual, `"note"."title" LIKE ? ESCAPE '\'`)
+			So(args, ShouldResemble, []interface{}{"hello%"})
+		})
+
+		Convey("matches ilike and like of citext by lower case", func() {
+			sql, _ := toSQL(skydb.Predicate{
+				Operator: skydb.ILike,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "title"},
+					skydb.Expression{Type: skydb.Literal, Value: "hello%"},
+				},
+			})
+			So(sql, ShouldEqual, `lower("note"."title") LIKE lower(?) ESCAPE '\'`)
+
+			sql, _ = toSQL(skydb.Predicate{
+				Operator: skydb.Like,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.KeyPath, Value: "email"},
+					skydb.Expression{Type: skydb.Literal, Value: "john%"},
+				},
+			})
+			So(sql, ShouldEqual, `lower("note"."email") LIKE lower(?) ESCAPE '\'`)
+		})
+
+		Convey("matches literal in json array", func() {
+			sql, args := toSQL(skydb.Predicate{
+				Operator: skydb.In,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.Literal, Value: "a"},
+					skydb.Expression{Type: skydb.KeyPath, Value: "tags"},
+				},
+			})
+			So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM json_each("note"."tags") WHERE value = ?)`)
+			So(args, ShouldResemble, []interface{}{"a"})
+		})
+
+		Convey("approximates distance", func() {
+			sql, args := toSQL(skydb.Predicate{
+				Operator: skydb.LessThan,
+				Children: []interface{}{
+					skydb.Expression{
+						Type: skydb.Function,
+						Value: skydb.DistanceFunc{
+							Field:    "location",
+							Location: skydb.NewLocation(1, 2),
+						},
+					},
+					skydb.Expression{Type: skydb.Literal, Value: 500.0},
+				},
+			})
+			So(sql, ShouldEqual, `skygear_distance("note"."location", ?, ?) <= ?`)
+			So(args, ShouldResemble, []interface{}{1.0, 2.0, 500.0})
+		})
+
+		Convey("matches geometry containing a point", func() {
+			sql, args := toSQL(skydb.Predicate{
+				Operator: skydb.In,
+				Children: []interface{}{
+					skydb.Expression{Type: skydb.Literal, Value: skydb.NewLocation(1, 2)},
+					skydb.Expression{Type: skydb.KeyPath, Value: "area"},
+				},
+			})
+			So(sql, ShouldEqual, `skygear_contains("note"."area", ?)`)
+			So(args, ShouldResemble, []interface{}{`{"type":"Point","coordinates":[1,2]}`})
+		})
+
+		Convey("filters by access control list", func() {
+			sqlizer, err := f.NewAccessControlSqlizer(&skydb.AuthInfo{
+				ID:    "user1",
+				Roles: []string{"admin"},
+			}, skydb.ReadLevel)
+			So(err, ShouldBeNil)
+			sql, args, err := sqlizer.ToSql()
+			So(err, ShouldBeNil)
+			So(sql, ShouldContainSubstring, `json_each("note"."_access")`)
+			So(args, ShouldResemble, []interface{}{"admin", "user1", "user1"})
+		})
+	})
+}
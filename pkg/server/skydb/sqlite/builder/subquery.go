@@ -0,0 +1,164 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package builder
+
+import (
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// subquerySqlizer generates an EXISTS predicate, or an IN predicate if
+// lhs is not nil, on the records selected by a subquery.
+type subquerySqlizer struct {
+	lhs      sq.Sqlizer
+	subquery sq.SelectBuilder
+}
+
+func (s *subquerySqlizer) ToSql() (sql string, args []interface{}, err error) {
+	subquerySQL, subqueryArgs, err := s.subquery.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if s.lhs == nil {
+		return fmt.Sprintf("EXISTS (%s)", subquerySQL), subqueryArgs, nil
+	}
+
+	lhsSQL, lhsArgs, err := s.lhs.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%s IN (%s)", lhsSQL, subquerySQL), append(lhsArgs, subqueryArgs...), nil
+}
+
+func (f *sqlizerFactory) newExistsPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error) {
+	subquery := p.Children[0].(skydb.Expression).Value.(skydb.RecordSubquery)
+	sub := f.newSubqueryFactory(subquery.Type)
+
+	key, err := sub.newExpressionPredicateSqlizerForKeyPath(skydb.Expression{
+		Type:  skydb.KeyPath,
+		Value: subquery.KeyPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if key.fieldType.Type != skydb.TypeReference || key.fieldType.ReferenceType != f.primaryTable {
+		return nil, skyerr.NewErrorf(skyerr.RecordQueryInvalid,
+			`keypath "%s" of subquery is not a reference to "%s"`, subquery.KeyPath, f.primaryTable)
+	}
+
+	keySQL, keyArgs, err := key.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	q := sq.Select("1").Where(
+		fmt.Sprintf("%s = %s", keySQL, fullQuoteIdentifier(f.alias, "_id")),
+		keyArgs...,
+	)
+
+	q, err = sub.selectSubquery(q, subquery)
+	if err != nil {
+		return nil, err
+	}
+	return &subquerySqlizer{subquery: q}, nil
+}
+
+func (f *sqlizerFactory) newInSubqueryPredicateSqlizer(p skydb.Predicate) (sq.Sqlizer, error) {
+	lhs, err := f.newExpressionPredicateSqlizer(p.Children[0].(skydb.Expression))
+	if err != nil {
+		return nil, err
+	}
+
+	subquery := p.Children[1].(skydb.Expression).Value.(skydb.RecordSubquery)
+	sub := f.newSubqueryFactory(subquery.Type)
+
+	key, err := sub.newExpressionPredicateSqlizerForKeyPath(skydb.Expression{
+		Type:  skydb.KeyPath,
+		Value: subquery.KeyPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keySQL, keyArgs, err := key.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := sub.selectSubquery(sq.Select().Column(keySQL, keyArgs...), subquery)
+	if err != nil {
+		return nil, err
+	}
+	return &subquerySqlizer{lhs: lhs, subquery: q}, nil
+}
+
+// newSubqueryFactory returns a factory for a subquery on the records of
+// recordType, with an alias distinct from the tables of this factory.
+func (f *sqlizerFactory) newSubqueryFactory(recordType string) *sqlizerFactory {
+	alias := fmt.Sprintf("%s_s%d", f.aliasPrefix, f.subqueryCount)
+	f.subqueryCount++
+	return &sqlizerFactory{
+		db:                   f.db,
+		primaryTable:         recordType,
+		joinedTables:         []joinedTable{},
+		alias:                alias,
+		aliasPrefix:          alias,
+		databaseID:           f.databaseID,
+		accessControlOptions: f.accessControlOptions,
+	}
+}
+
+// selectSubquery adds the table, the predicate and the joins of the
+// subquery to q.
+func (f *sqlizerFactory) selectSubquery(q sq.SelectBuilder, subquery skydb.RecordSubquery) (sq.SelectBuilder, error) {
+	q = q.From(fmt.Sprintf("%s AS %s", f.db.TableName(f.primaryTable), QuoteIdentifier(f.alias)))
+
+	if !subquery.Predicate.IsEmpty() {
+		sqlizer, err := f.NewPredicateSqlizer(subquery.Predicate)
+		if err != nil {
+			return q, err
+		}
+		q = q.Where(sqlizer)
+	}
+
+	for _, cond := range f.recordConditions(f.primaryTable, f.alias) {
+		q = q.Where(cond)
+	}
+	return f.AddJoinsToSelectBuilder(q), nil
+}
+
+// recordConditions returns the conditions on the records of recordType
+// in a joined table or a subquery, which exclude soft-deleted records
+// and records not in the database or not accessible to the user.
+func (f *sqlizerFactory) recordConditions(recordType string, alias string) []sq.Sqlizer {
+	conds := []sq.Sqlizer{}
+
+	if typemap, err := f.db.RemoteColumnTypes(recordType); err == nil {
+		if _, ok := typemap["_deleted_at"]; ok {
+			conds = append(conds, sq.Expr(fmt.Sprintf("%s IS NULL", fullQuoteIdentifier(alias, "_deleted_at"))))
+		}
+	}
+
+	if f.databaseID != nil {
+		conds = append(conds, sq.Expr(fmt.Sprintf("%s = ?", fullQuoteIdentifier(alias, "_database_id")), *f.databaseID))
+	}
+
+	if opts := f.accessControlOptions; opts != nil && !opts.BypassAccessControl && f.db.DatabaseType() == skydb.PublicDatabase {
+		conds = append(conds, accessPredicateSqlizer{alias, opts.ViewAsUser, skydb.ReadLevel})
+	}
+	return conds
+}
@@ -0,0 +1,375 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/sqlite/builder"
+)
+
+var sqlBuilder = sq.StatementBuilder.PlaceholderFormat(sq.Question)
+
+type conn struct {
+	db                     *sqlx.DB // database wrapper
+	tx                     *sqlx.Tx // transaction wrapper, nil when no transaction
+	hub                    *eventHub
+	RecordSchema           map[string]skydb.RecordSchema
+	FieldACL               *skydb.FieldACL
+	appName                string
+	option                 string
+	statementCount         uint64
+	accessModel            skydb.AccessModel
+	canMigrate             bool
+	passwordHistoryEnabled bool
+	context                context.Context
+
+	// txBegun is true if tx is begun by Begin, as opposed to the
+	// transaction of a write operation outside of a transaction.
+	txBegun bool
+
+	// savepointCount numbers the savepoints of write operations in a
+	// transaction.
+	savepointCount int
+
+	// events are the record events to be emitted when tx is committed.
+	events []skydb.RecordEvent
+}
+
+// Db returns the current database wrapper, or a transaction wrapper when
+// a transaction is in effect.
+func (c *conn) Db() sqlx.ExtContext {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.db
+}
+
+// Begin begins a transaction.
+func (c *conn) Begin() error {
+	logger := logging.CreateLogger(c.context, "skydb")
+	logger.Debugf("%p: Beginning transaction", c)
+	if c.tx != nil {
+		return skydb.ErrDatabaseTxDidBegin
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		logger.Debugf("%p: Unable to begin transaction: %v", c, err)
+		return err
+	}
+	c.tx = tx
+	c.txBegun = true
+	logger.Debugf("%p: Done beginning transaction %p", c, c.tx)
+	return nil
+}
+
+// Commit commits a transaction.
+func (c *conn) Commit() error {
+	logger := logging.CreateLogger(c.context, "skydb")
+	if c.tx == nil || !c.txBegun {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		logger.Errorf("%p: Unable to commit transaction %p: %v", c, c.tx, err)
+		return err
+	}
+	c.endTx()
+	logger.Debugf("%p: Committed transaction", c)
+
+	events := c.events
+	c.events = nil
+	c.hub.emit(events)
+	return nil
+}
+
+// Rollback rollbacks a transaction.
+func (c *conn) Rollback() error {
+	logger := logging.CreateLogger(c.context, "skydb")
+	if c.tx == nil || !c.txBegun {
+		return skydb.ErrDatabaseTxDidNotBegin
+	}
+
+	if err := c.tx.Rollback(); err != nil {
+		logger.Errorf("%p: Unable to rollback transaction %p: %v", c, c.tx, err)
+		return err
+	}
+	c.endTx()
+	c.events = nil
+
+	// The schemas cached in the transaction may have been rolled back.
+	c.clearSchemaCache()
+	logger.Debugf("%p: Rolled back transaction", c)
+	return nil
+}
+
+func (c *conn) endTx() {
+	c.tx = nil
+	c.txBegun = false
+	c.savepointCount = 0
+}
+
+// write runs fn in a transaction, so that the changes made by fn are
+// applied atomically and only if fn returns no error. In a transaction
+// begun by Begin, fn runs in a savepoint, which is rolled back if fn
+// returns an error, like a failed statement in a transaction of the pq
+// driver.
+func (c *conn) write(fn func() error) (err error) {
+	if c.tx != nil {
+		c.savepointCount++
+		savepoint := fmt.Sprintf("skygear_%d", c.savepointCount)
+		if _, err = c.Exec("SAVEPOINT " + savepoint); err != nil {
+			return err
+		}
+
+		eventCount := len(c.events)
+		if err = fn(); err != nil {
+			c.events = c.events[:eventCount]
+			if _, rbErr := c.Exec("ROLLBACK TO " + savepoint); rbErr != nil {
+				return rbErr
+			}
+		}
+		if _, releaseErr := c.Exec("RELEASE " + savepoint); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+		return err
+	}
+
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return err
+	}
+	c.tx = tx
+	defer func() {
+		c.endTx()
+		events := c.events
+		c.events = nil
+
+		if err != nil {
+			tx.Rollback()
+			c.clearSchemaCache()
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			c.clearSchemaCache()
+			return
+		}
+		c.hub.emit(events)
+	}()
+
+	err = fn()
+	return
+}
+
+func (c *conn) emit(record *skydb.Record, event skydb.RecordHookEvent) {
+	recordCopy := *record
+	recordCopy.Data = copyData(record.Data)
+	recordCopy.Transient = copyData(record.Transient)
+	c.events = append(c.events, skydb.RecordEvent{
+		Record: &recordCopy,
+		Event:  event,
+	})
+}
+
+func copyData(data skydb.Data) skydb.Data {
+	if data == nil {
+		return nil
+	}
+
+	dataCopy := make(skydb.Data, len(data))
+	for key, value := range data {
+		dataCopy[key] = value
+	}
+	return dataCopy
+}
+
+func (c *conn) clearSchemaCache() {
+	c.RecordSchema = map[string]skydb.RecordSchema{}
+	c.FieldACL = nil
+}
+
+func (c *conn) PublicDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PublicDatabase,
+	}
+}
+
+func (c *conn) PrivateDB(userKey string) skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.PrivateDatabase,
+		userID:       userKey,
+	}
+}
+
+func (c *conn) UnionDB() skydb.Database {
+	return &database{
+		c:            c,
+		databaseType: skydb.UnionDatabase,
+	}
+}
+
+// Subscribe registers the channel to receive the record events of the
+// database file. Only the events of the changes made in this process
+// are delivered.
+func (c *conn) Subscribe(recordEventChan chan skydb.RecordEvent) error {
+	c.hub.subscribe(recordEventChan)
+	return nil
+}
+
+func (c *conn) Close() error { return nil }
+
+type database struct {
+	c            *conn
+	userID       string
+	databaseType skydb.DatabaseType
+}
+
+func (db *database) Conn() skydb.Conn       { return db.c }
+func (db *database) UserRecordType() string { return "user" }
+
+func (db *database) ID() string {
+	if db.DatabaseType() == skydb.PublicDatabase {
+		return skydb.PublicDatabaseIdentifier
+	} else if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.UnionDatabaseIdentifier
+	}
+
+	if db.userID == "" {
+		panic("Private database but userID is empty")
+	}
+	return db.userID
+}
+
+func (db *database) DatabaseType() skydb.DatabaseType { return db.databaseType }
+func (db *database) IsReadOnly() bool                 { return db.DatabaseType() == skydb.UnionDatabase }
+
+// TableName returns the quoted name of the table of a record type.
+func (db *database) TableName(table string) string {
+	return quoteIdentifier(table)
+}
+
+func (db *database) Begin() error {
+	return db.c.Begin()
+}
+
+func (db *database) Commit() error {
+	return db.c.Commit()
+}
+
+func (db *database) Rollback() error {
+	return db.c.Rollback()
+}
+
+// limitOffset sets the limit and offset of a select statement. A limit
+// of 0 means no limit. SQLite requires a limit to go with an offset, so
+// the largest limit is used if there is an offset but no limit.
+func limitOffset(selectBuilder sq.SelectBuilder, limit uint64, offset uint64) sq.SelectBuilder {
+	if limit != 0 {
+		selectBuilder = selectBuilder.Limit(limit)
+	} else if offset != 0 {
+		selectBuilder = selectBuilder.Limit(math.MaxInt64)
+	}
+	if offset != 0 {
+		selectBuilder = selectBuilder.Offset(offset)
+	}
+	return selectBuilder
+}
+
+// upsertQuery returns a statement which inserts a row of pkData and data,
+// or updates the row of pkData with data if it already exists. Columns in
+// keepOnUpdate are inserted but not updated.
+func upsertQuery(table string, pkData map[string]interface{}, data map[string]interface{}, keepOnUpdate ...string) sq.Sqlizer {
+	pkColumns := sortedColumns(pkData)
+	columns := sortedColumns(data)
+
+	insertColumns := []string{}
+	placeholders := []string{}
+	args := []interface{}{}
+	for _, column := range pkColumns {
+		insertColumns = append(insertColumns, quoteIdentifier(column))
+		placeholders = append(placeholders, "?")
+		args = append(args, pkData[column])
+	}
+
+	updates := []string{}
+	for _, column := range columns {
+		insertColumns = append(insertColumns, quoteIdentifier(column))
+		placeholders = append(placeholders, "?")
+		args = append(args, data[column])
+
+		keep := false
+		for _, keptColumn := range keepOnUpdate {
+			keep = keep || keptColumn == column
+		}
+		if !keep {
+			updates = append(updates, fmt.Sprintf("%[1]s = excluded.%[1]s", quoteIdentifier(column)))
+		}
+	}
+
+	conflictColumns := []string{}
+	for _, column := range pkColumns {
+		conflictColumns = append(conflictColumns, quoteIdentifier(column))
+	}
+
+	onConflict := "DO NOTHING"
+	if len(updates) > 0 {
+		onConflict = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+
+	return sq.Expr(fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		quoteIdentifier(table),
+		strings.Join(insertColumns, ", "),
+		strings.Join(placeholders, ", "),
+		strings.Join(conflictColumns, ", "),
+		onConflict,
+	), args...)
+}
+
+func sortedColumns(data map[string]interface{}) []string {
+	columns := make([]string, 0, len(data))
+	for column := range data {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// quoteIdentifier quotes an identifier, such as the name of a table or
+// a column, to be used in a statement.
+func quoteIdentifier(name string) string {
+	return builder.QuoteIdentifier(name)
+}
+
+// this ensures that our structure conform to certain interfaces.
+var (
+	_ skydb.Conn          = &conn{}
+	_ skydb.Database      = &database{}
+	_ skydb.Transactional = &database{}
+)
@@ -0,0 +1,411 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func (c *conn) deviceBuilder() sq.SelectBuilder {
+	return sqlBuilder.Select("id", "type", "token", "auth_id", "topic", "time_zone", "last_registered_at").
+		From("_device")
+}
+
+func (c *conn) doScanDevice(device *skydb.Device, scanner sq.RowScanner) error {
+	var (
+		token    sql.NullString
+		authID   sql.NullString
+		topic    sql.NullString
+		timeZone sql.NullString
+	)
+
+	err := scanner.Scan(
+		&device.ID,
+		&device.Type,
+		&token,
+		&authID,
+		&topic,
+		&timeZone,
+		&device.LastRegisteredAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	device.Token = token.String
+	device.AuthInfoID = authID.String
+	device.Topic = topic.String
+	device.TimeZone = timeZone.String
+	device.LastRegisteredAt = device.LastRegisteredAt.UTC()
+	return nil
+}
+
+func (c *conn) GetDevice(id string, device *skydb.Device) error {
+	builder := c.deviceBuilder().Where("id = ?", id)
+	err := c.doScanDevice(device, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrDeviceNotFound
+	}
+	return err
+}
+
+func (c *conn) queryDevices(builder sq.SelectBuilder) ([]skydb.Device, error) {
+	rows, err := c.QueryWith(builder.OrderBy("id"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []skydb.Device{}
+	for rows.Next() {
+		device := skydb.Device{}
+		if err := c.doScanDevice(&device, rows); err != nil {
+			return nil, err
+		}
+		results = append(results, device)
+	}
+	return results, rows.Err()
+}
+
+func (c *conn) QueryDevicesByUser(user string) ([]skydb.Device, error) {
+	return c.queryDevices(c.deviceBuilder().
+		Where("auth_id = ?", user))
+}
+
+func (c *conn) QueryDevicesByUserAndTopic(user, topic string) ([]skydb.Device, error) {
+	return c.queryDevices(c.deviceBuilder().
+		Where("auth_id = ? AND topic = ?", user, topic))
+}
+
+func (c *conn) SaveDevice(device *skydb.Device) error {
+	if device.ID == "" || device.Type == "" || device.LastRegisteredAt.IsZero() {
+		return errors.New("invalid device: empty id, type, or last registered at")
+	}
+
+	pkData := map[string]interface{}{"id": device.ID}
+	data := map[string]interface{}{
+		"type":               device.Type,
+		"auth_id":            nullString(device.AuthInfoID),
+		"last_registered_at": device.LastRegisteredAt.UTC(),
+	}
+
+	if device.Token != "" {
+		data["token"] = device.Token
+	}
+
+	if device.Topic != "" {
+		data["topic"] = device.Topic
+	}
+
+	if device.TimeZone != "" {
+		data["time_zone"] = device.TimeZone
+	}
+
+	return c.write(func() error {
+		_, err := c.ExecWith(upsertQuery("_device", pkData, data))
+		return err
+	})
+}
+
+// deleteDevices deletes the devices matching the builder, and their
+// subscriptions by the foreign key.
+func (c *conn) deleteDevices(builder sq.DeleteBuilder) error {
+	return c.write(func() error {
+		result, err := c.ExecWith(builder)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrDeviceNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) DeleteDevice(id string) error {
+	return c.deleteDevices(sqlBuilder.Delete("_device").
+		Where("id = ?", id))
+}
+
+func (c *conn) DeleteDevicesByToken(token string, t time.Time) error {
+	builder := sqlBuilder.Delete("_device").
+		Where("token = ?", token)
+	if t != skydb.ZeroTime {
+		builder = builder.Where("last_registered_at < ?", t.UTC())
+	}
+	return c.deleteDevices(builder)
+}
+
+func (c *conn) DeleteEmptyDevicesByTime(t time.Time) error {
+	builder := sqlBuilder.Delete("_device").
+		Where("token IS NULL")
+	if t != skydb.ZeroTime {
+		builder = builder.Where("last_registered_at < ?", t.UTC())
+	}
+	return c.deleteDevices(builder)
+}
+
+var scheduledPushColumns = []string{"id", "target_type", "target_ids",
+	"topic", "notification", "send_at", "local_time", "time_zone",
+	"status", "sent_device_ids", "created_at"}
+
+func (c *conn) scheduledPushBuilder() sq.SelectBuilder {
+	return sqlBuilder.Select(scheduledPushColumns...).
+		From("_scheduled_push")
+}
+
+func (c *conn) doScanScheduledPush(push *skydb.ScheduledPush, scanner sq.RowScanner) error {
+	var (
+		targetType    string
+		targetIDs     string
+		topic         sql.NullString
+		notification  string
+		timeZone      sql.NullString
+		status        string
+		sentDeviceIDs sql.NullString
+	)
+
+	err := scanner.Scan(
+		&push.ID,
+		&targetType,
+		&targetIDs,
+		&topic,
+		&notification,
+		&push.SendAt,
+		&push.LocalTime,
+		&timeZone,
+		&status,
+		&sentDeviceIDs,
+		&push.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	push.TargetType = skydb.PushTargetType(targetType)
+	push.Topic = topic.String
+	push.TimeZone = timeZone.String
+	push.Status = skydb.ScheduledPushStatus(status)
+	push.SendAt = push.SendAt.UTC()
+	push.CreatedAt = push.CreatedAt.UTC()
+
+	push.TargetIDs = nil
+	if err := json.Unmarshal([]byte(targetIDs), &push.TargetIDs); err != nil {
+		return err
+	}
+	push.Notification = nil
+	if err := json.Unmarshal([]byte(notification), &push.Notification); err != nil {
+		return err
+	}
+	push.SentDeviceIDs = nil
+	if sentDeviceIDs.Valid {
+		if err := json.Unmarshal([]byte(sentDeviceIDs.String), &push.SentDeviceIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) GetScheduledPush(id string, push *skydb.ScheduledPush) error {
+	builder := c.scheduledPushBuilder().
+		Where("id = ?", id)
+
+	err := c.doScanScheduledPush(push, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrScheduledPushNotFound
+	}
+	return err
+}
+
+func jsonStringSlice(s []string) (string, error) {
+	if s == nil {
+		s = []string{}
+	}
+	data, err := json.Marshal(s)
+	return string(data), err
+}
+
+func (c *conn) SaveScheduledPush(push *skydb.ScheduledPush) error {
+	if push.ID == "" || push.TargetType == "" || push.SendAt.IsZero() {
+		return errors.New("invalid scheduled push: empty id, target type, or send at")
+	}
+
+	targetIDs, err := jsonStringSlice(push.TargetIDs)
+	if err != nil {
+		return err
+	}
+	sentDeviceIDs, err := jsonStringSlice(push.SentDeviceIDs)
+	if err != nil {
+		return err
+	}
+	notification, err := json.Marshal(push.Notification)
+	if err != nil {
+		return err
+	}
+
+	createdAt := push.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = timeNow()
+	}
+
+	pkData := map[string]interface{}{"id": push.ID}
+	data := map[string]interface{}{
+		"target_type":     string(push.TargetType),
+		"target_ids":      targetIDs,
+		"topic":           nullString(push.Topic),
+		"notification":    string(notification),
+		"send_at":         push.SendAt.UTC(),
+		"local_time":      push.LocalTime,
+		"time_zone":       nullString(push.TimeZone),
+		"due_at":          push.EarliestDueAt().UTC(),
+		"status":          string(push.Status),
+		"sent_device_ids": sentDeviceIDs,
+		"created_at":      createdAt.UTC(),
+	}
+
+	err = c.write(func() error {
+		_, err := c.ExecWith(upsertQuery("_scheduled_push", pkData, data, "created_at"))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	push.CreatedAt = createdAt
+	return nil
+}
+
+func (c *conn) CancelScheduledPush(id string, push *skydb.ScheduledPush) error {
+	return c.write(func() error {
+		builder := sqlBuilder.Update("_scheduled_push").
+			Set("status", string(skydb.ScheduledPushCancelled)).
+			Set("claimed_until", nil).
+			Where("id = ?", id).
+			Where(sq.Eq{"status": []string{
+				string(skydb.ScheduledPushPending),
+				string(skydb.ScheduledPushDispatching),
+			}}).
+			Suffix("RETURNING " + strings.Join(scheduledPushColumns, ", "))
+
+		err := c.doScanScheduledPush(push, c.QueryRowWith(builder))
+		if err == sql.ErrNoRows {
+			return skydb.ErrScheduledPushNotFound
+		}
+		return err
+	})
+}
+
+func (c *conn) ClaimDueScheduledPushes(t time.Time, claimUntil time.Time) ([]skydb.ScheduledPush, error) {
+	results := []skydb.ScheduledPush{}
+	err := c.write(func() error {
+		// writes to the database file are serialized, so a push is
+		// claimed by one conn only
+		builder := sqlBuilder.Update("_scheduled_push").
+			Set("status", string(skydb.ScheduledPushDispatching)).
+			Set("claimed_until", claimUntil.UTC()).
+			Where("due_at <= ?", t.UTC()).
+			Where(sq.Or{
+				sq.Eq{"status": string(skydb.ScheduledPushPending)},
+				sq.And{
+					sq.Eq{"status": string(skydb.ScheduledPushDispatching)},
+					sq.Expr("claimed_until <= ?", t.UTC()),
+				},
+			}).
+			Suffix("RETURNING " + strings.Join(scheduledPushColumns, ", "))
+
+		rows, err := c.QueryWith(builder)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			push := skydb.ScheduledPush{}
+			if err := c.doScanScheduledPush(&push, rows); err != nil {
+				return err
+			}
+			results = append(results, push)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].EarliestDueAt().Equal(results[j].EarliestDueAt()) {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].EarliestDueAt().Before(results[j].EarliestDueAt())
+	})
+	return results, nil
+}
+
+func (c *conn) updateDispatchingScheduledPush(id string, data map[string]interface{}) error {
+	return c.write(func() error {
+		builder := sqlBuilder.Update("_scheduled_push").
+			SetMap(data).
+			Where("id = ? AND status = ?", id, string(skydb.ScheduledPushDispatching))
+
+		result, err := c.ExecWith(builder)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrScheduledPushNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) MarkScheduledPushSent(id string, deviceIDs []string) error {
+	sentDeviceIDs, err := jsonStringSlice(deviceIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.updateDispatchingScheduledPush(id, map[string]interface{}{
+		"sent_device_ids": sentDeviceIDs,
+	})
+}
+
+func (c *conn) ReleaseScheduledPush(id string, status skydb.ScheduledPushStatus, dueAt time.Time) error {
+	return c.updateDispatchingScheduledPush(id, map[string]interface{}{
+		"status":        string(status),
+		"due_at":        dueAt.UTC(),
+		"claimed_until": nil,
+	})
+}
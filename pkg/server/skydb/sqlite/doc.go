@@ -0,0 +1,64 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package sqlite implements a skydb driver backed by SQLite, for embedded
+and single-node deployments where running PostgreSQL is not possible.
+
+The driver requires cgo and is built only with the "sqlite" build tag,
+for example by building with WITH_SQLITE=1. Without the tag, the package
+is empty and the driver is not registered.
+
+The driver is registered as "sqlite" and the option string is the path of
+the database. If the path is an existing directory, each app is stored in
+its own file named "app_<app name>.db" in the directory, which is the
+counterpart of the per-app schema of the pq driver. Otherwise the path is
+used as the database file of a single app, and opening it for another app
+in the same process is an error, because the tables of the apps would be
+shared. The tables are created and migrated by the migration package,
+which has its own revisions.
+
+Compared to the pq driver, the following features degrade:
+
+Record events. There is no LISTEN/NOTIFY in SQLite. Record events are
+delivered to the channels subscribed in the same process only, after the
+transaction making the change is committed. Events of changes made by
+another process on the same database file are not delivered.
+
+Location. Locations are stored as GeoJSON text. Distance is computed with
+the haversine formula on a sphere, which differs from the distance on the
+spheroid used by PostGIS by up to about 0.5%, so records close to the
+boundary of a distance predicate may be included or excluded differently.
+Distance predicates cannot use an index and scan all records.
+
+Geometry. Geometries are stored as GeoJSON text. Only the containment of a
+point in a Polygon or MultiPolygon is supported in predicates, and it is
+computed on a plane; the other geometry types never contain a point.
+
+Constraints. SQLite cannot add constraints to existing tables, so
+references, assets and field constraints are checked by the driver
+before saving a record, and the delete actions of references are
+performed by the driver. The checks run in the transaction of the save
+and are as strict as those of the pq driver for a single process.
+
+Concurrency. All write operations are serialized by the database lock.
+A transaction begun with Begin holds the lock until it is committed or
+rolled back, so transactions should be short.
+
+Types. Columns of records are not typed. The type of each field is
+recorded in the _record_field_type table, and values are converted to
+the representation of the type when saved, so queries compare values of
+the same type.
+*/
+package sqlite
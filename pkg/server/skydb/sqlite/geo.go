@@ -0,0 +1,154 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// earthRadius is the radius in meters used by ST_Distance_Sphere of
+// PostGIS, so that distances are close to those of the pq driver.
+const earthRadius = 6370986
+
+// distanceFunc implements skygear_distance(location, lng, lat), which
+// returns the distance in meters between a location stored as GeoJSON
+// Point and the point of lng and lat on a sphere, or NULL if the location
+// is NULL.
+func distanceFunc(location interface{}, lng interface{}, lat interface{}) interface{} {
+	x, y, ok := pointCoordinates(location)
+	if !ok {
+		return nil
+	}
+	lng2, ok := toFloat64(lng)
+	if !ok {
+		return nil
+	}
+	lat2, ok := toFloat64(lat)
+	if !ok {
+		return nil
+	}
+	return distance(x, y, lng2, lat2)
+}
+
+// distance returns the distance in meters between two points by the
+// haversine formula.
+func distance(lng1, lat1, lng2, lat2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := phi2 - phi1
+	dLambda := (lng2 - lng1) * math.Pi / 180
+
+	h := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// containsFunc implements skygear_contains(geometry, point), which returns
+// whether a GeoJSON geometry contains a GeoJSON point. Only a point in a
+// polygon or a multipolygon is supported, which is computed on a plane.
+func containsFunc(geometry interface{}, point interface{}) bool {
+	x, y, ok := pointCoordinates(point)
+	if !ok {
+		return false
+	}
+
+	var g struct {
+		Type        string        `json:"type"`
+		Coordinates []interface{} `json:"coordinates"`
+	}
+	if !decodeGeoJSON(geometry, &g) {
+		return false
+	}
+	switch g.Type {
+	case "Polygon":
+		return polygonContains(g.Coordinates, x, y)
+	case "MultiPolygon":
+		for _, polygon := range g.Coordinates {
+			if rings, ok := polygon.([]interface{}); ok && polygonContains(rings, x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pointCoordinates returns the coordinates of a GeoJSON Point.
+func pointCoordinates(point interface{}) (float64, float64, bool) {
+	var p struct {
+		Type        string        `json:"type"`
+		Coordinates []interface{} `json:"coordinates"`
+	}
+	if !decodeGeoJSON(point, &p) || p.Type != "Point" {
+		return 0, 0, false
+	}
+	return coordinatePair(p.Coordinates)
+}
+
+func decodeGeoJSON(value interface{}, v interface{}) bool {
+	var data []byte
+	switch value := value.(type) {
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return false
+	}
+	return len(data) > 0 && json.Unmarshal(data, v) == nil
+}
+
+// polygonContains returns whether the point is inside the outer ring and
+// outside the holes of a GeoJSON polygon.
+func polygonContains(rings []interface{}, x float64, y float64) bool {
+	for i, ring := range rings {
+		points, _ := ring.([]interface{})
+		inside := ringContains(points, x, y)
+		if i == 0 && !inside {
+			return false
+		} else if i > 0 && inside {
+			return false
+		}
+	}
+	return len(rings) > 0
+}
+
+// ringContains returns whether the point is inside the ring by ray casting.
+func ringContains(points []interface{}, x float64, y float64) bool {
+	inside := false
+	for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+		xi, yi, ok := coordinatePair(points[i])
+		xj, yj, ok2 := coordinatePair(points[j])
+		if !ok || !ok2 {
+			return false
+		}
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func coordinatePair(point interface{}) (float64, float64, bool) {
+	pair, ok := point.([]interface{})
+	if !ok || len(pair) < 2 {
+		return 0, 0, false
+	}
+	x, xok := toFloat64(pair[0])
+	y, yok := toFloat64(pair[1])
+	return x, y, xok && yok
+}
@@ -0,0 +1,114 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGeo(t *testing.T) {
+	Convey("distanceFunc", t, func() {
+		Convey("returns distance in meters", func() {
+			d := distanceFunc(`{"type":"Point","coordinates":[0,0]}`, 0.0, 1.0)
+			So(d, ShouldAlmostEqual, 111194.7, 0.1)
+		})
+
+		Convey("returns null on null location", func() {
+			So(distanceFunc([]byte(nil), 0.0, 0.0), ShouldBeNil)
+		})
+	})
+
+	Convey("containsFunc", t, func() {
+		polygon := `{"type":"Polygon","coordinates":[` +
+			`[[0,0],[10,0],[10,10],[0,10],[0,0]],` +
+			`[[4,4],[6,4],[6,6],[4,6],[4,4]]]}`
+
+		Convey("contains point inside polygon", func() {
+			So(containsFunc(polygon, `{"type":"Point","coordinates":[1,1]}`), ShouldBeTrue)
+		})
+
+		Convey("does not contain point in hole or outside", func() {
+			So(containsFunc(polygon, `{"type":"Point","coordinates":[5,5]}`), ShouldBeFalse)
+			So(containsFunc(polygon, `{"type":"Point","coordinates":[11,1]}`), ShouldBeFalse)
+		})
+
+		Convey("contains point inside multipolygon", func() {
+			multipolygon := `{"type":"MultiPolygon","coordinates":[` +
+				`[[[0,0],[1,0],[1,1],[0,1],[0,0]]],` +
+				`[[[2,2],[3,2],[3,3],[2,3],[2,2]]]]}`
+			So(containsFunc(multipolygon, `{"type":"Point","coordinates":[2.5,2.5]}`), ShouldBeTrue)
+		})
+	})
+}
+
+func TestGeometry(t *testing.T) {
+	Convey("Database", t, func() {
+		c, cleanup := getTestConn(t)
+		defer cleanup()
+		db := c.PublicDB()
+
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"area": skydb.FieldType{Type: skydb.TypeGeometry},
+		})
+		So(err, ShouldBeNil)
+
+		area := skydb.Geometry{
+			"type": "Polygon",
+			"coordinates": []interface{}{
+				[]interface{}{
+					[]interface{}{0.0, 0.0}, []interface{}{2.0, 0.0},
+					[]interface{}{2.0, 2.0}, []interface{}{0.0, 2.0},
+					[]interface{}{0.0, 0.0},
+				},
+			},
+		}
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "note1"),
+			OwnerID: "user1",
+			Data:    skydb.Data{"area": area},
+		}
+		So(db.Save(&record), ShouldBeNil)
+
+		Convey("round trips geometry", func() {
+			fetched := skydb.Record{}
+			So(db.Get(record.ID, &fetched), ShouldBeNil)
+			So(fetched.Data["area"], ShouldResemble, area)
+		})
+
+		Convey("matches geometry containing a location", func() {
+			contains := func(location skydb.Location) []skydb.Record {
+				records, err := exhaustRows(db.Query(&skydb.Query{
+					Type: "note",
+					Predicate: skydb.Predicate{
+						Operator: skydb.In,
+						Children: []interface{}{
+							skydb.Expression{Type: skydb.Literal, Value: location},
+							skydb.Expression{Type: skydb.KeyPath, Value: "area"},
+						},
+					},
+				}, &skydb.AccessControlOptions{BypassAccessControl: true}))
+				So(err, ShouldBeNil)
+				return records
+			}
+			So(contains(skydb.NewLocation(1, 1)), ShouldHaveLength, 1)
+			So(contains(skydb.NewLocation(3, 1)), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,282 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migration
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const adminRoleDefaultName = "Admin"
+
+type fullMigration struct {
+}
+
+func (r *fullMigration) Version() string { return "3c6f0a1d8b72" }
+
+// createTable creates the tables of the base revision. Compared to the
+// tables of the pq driver, timestamps are stored as text in UTC, JSON as
+// text and booleans as integers. The field types of record types, which
+// cannot be told from the columns in SQLite, are kept in
+// _record_field_type, and sequences of sequence fields in _sequence.
+func (r *fullMigration) createTable(tx *sqlx.Tx) error {
+	const stmt = `
+CREATE TABLE _auth (
+	id text PRIMARY KEY,
+	password text,
+	provider_info text,
+	token_valid_since timestamp,
+	last_seen_at timestamp,
+	disabled boolean NOT NULL DEFAULT FALSE,
+	disabled_message text,
+	disabled_expiry timestamp
+);
+
+CREATE TABLE _role (
+	id text PRIMARY KEY,
+	by_default boolean NOT NULL DEFAULT FALSE,
+	is_admin boolean NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE _auth_role (
+	auth_id text NOT NULL REFERENCES _auth (id) ON DELETE CASCADE,
+	role_id text NOT NULL REFERENCES _role (id),
+	PRIMARY KEY (auth_id, role_id)
+);
+
+CREATE TABLE _asset (
+	id text PRIMARY KEY,
+	content_type text NOT NULL,
+	size integer NOT NULL,
+	updated_at timestamp NOT NULL
+);
+CREATE TABLE _device (
+	id text PRIMARY KEY,
+	auth_id text REFERENCES _auth (id),
+	type text NOT NULL,
+	token text,
+	topic text,
+	time_zone text,
+	last_registered_at timestamp NOT NULL,
+	UNIQUE (auth_id, type, token)
+);
+CREATE INDEX _device_token_last_registered_at ON _device (token, last_registered_at);
+CREATE TABLE _subscription (
+	id text NOT NULL,
+	auth_id text NOT NULL,
+	device_id text NOT NULL REFERENCES _device (id) ON DELETE CASCADE,
+	type text NOT NULL,
+	notification_info text,
+	query text,
+	PRIMARY KEY(auth_id, device_id, id)
+);
+CREATE TABLE _friend (
+	left_id text NOT NULL,
+	right_id text NOT NULL REFERENCES _auth (id),
+	PRIMARY KEY(left_id, right_id)
+);
+CREATE TABLE _follow (
+	left_id text NOT NULL,
+	right_id text NOT NULL REFERENCES _auth (id),
+	PRIMARY KEY(left_id, right_id)
+);
+CREATE TABLE _record_creation (
+	record_type text NOT NULL,
+	role_id text REFERENCES _role (id),
+	UNIQUE (record_type, role_id)
+);
+CREATE TABLE _record_default_access (
+	record_type text PRIMARY KEY,
+	default_access text
+);
+CREATE TABLE _record_field_access (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	user_role text NOT NULL,
+	writable boolean NOT NULL,
+	readable boolean NOT NULL,
+	comparable boolean NOT NULL,
+	discoverable boolean NOT NULL,
+	PRIMARY KEY (record_type, record_field, user_role)
+);
+CREATE TABLE _record_field_type (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	type text NOT NULL,
+	reference_type text,
+	underlying_type text,
+	PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_field_constraint (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	constraints text NOT NULL,
+	PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _sequence (
+	record_type text NOT NULL,
+	record_field text NOT NULL,
+	value integer NOT NULL,
+	PRIMARY KEY (record_type, record_field)
+);
+CREATE TABLE _record_history_type (
+	record_type text PRIMARY KEY
+);
+CREATE TABLE _record_history (
+	id text PRIMARY KEY,
+	record_type text NOT NULL,
+	record_id text NOT NULL,
+	database_id text NOT NULL,
+	version integer NOT NULL,
+	action text NOT NULL,
+	record text,
+	diff text,
+	actor_id text,
+	created_at timestamp NOT NULL,
+	UNIQUE (record_type, record_id, database_id, version)
+);
+CREATE TABLE "user" (
+	_id text NOT NULL,
+	_database_id text NOT NULL,
+	_owner_id text NOT NULL,
+	_access text,
+	_created_at text NOT NULL,
+	_created_by text,
+	_updated_at text NOT NULL,
+	_updated_by text,
+	username COLLATE NOCASE,
+	email COLLATE NOCASE,
+	last_login_at,
+	PRIMARY KEY(_id, _database_id, _owner_id),
+	UNIQUE (_id)
+);
+CREATE UNIQUE INDEX auth_record_keys_user_username_key ON "user" (username);
+CREATE UNIQUE INDEX auth_record_keys_user_email_key ON "user" (email);
+
+INSERT INTO _record_field_type
+	(record_type, record_field, type, reference_type, underlying_type)
+VALUES
+	('user', 'username', 'string', NULL, 'citext'),
+	('user', 'email', 'string', NULL, 'citext'),
+	('user', 'last_login_at', 'datetime', NULL, NULL);
+
+INSERT INTO _record_field_access
+	(record_type, record_field, user_role, writable, readable, comparable, discoverable)
+VALUES
+	('user', 'username', '_any_user', 0, 1, 1, 1),
+	('user', 'username', '_owner', 1, 1, 1, 1),
+	('user', 'email', '_any_user', 0, 1, 1, 1),
+	('user', 'email', '_owner', 1, 1, 1, 1);
+
+CREATE TABLE _sso_oauth (
+	user_id text NOT NULL,
+	provider text NOT NULL,
+	principal_id text NOT NULL,
+	token_response text,
+	profile text,
+	_created_at timestamp NOT NULL,
+	_updated_at timestamp NOT NULL,
+	PRIMARY KEY (provider, principal_id),
+	UNIQUE (user_id, provider)
+);
+CREATE TABLE _sso_custom_token (
+	user_id text NOT NULL PRIMARY KEY,
+	principal_id text NOT NULL,
+	_created_at timestamp NOT NULL,
+	UNIQUE (principal_id)
+);
+
+CREATE TABLE _password_history (
+	id text PRIMARY KEY,
+	auth_id text NOT NULL,
+	password text NOT NULL,
+	logged_at timestamp NOT NULL
+);
+CREATE INDEX _password_history_auth_id_logged_at ON _password_history (auth_id, logged_at DESC);
+
+CREATE TABLE _verify_code (
+	id text PRIMARY KEY,
+	auth_id text NOT NULL,
+	record_key text NOT NULL,
+	record_value text NOT NULL,
+	code text NOT NULL,
+	consumed boolean NOT NULL DEFAULT FALSE,
+	created_at timestamp NOT NULL
+);
+CREATE INDEX _verify_code_auth_id_code_consumed ON _verify_code (auth_id, code, consumed);
+CREATE TABLE _scheduled_push (
+	id text PRIMARY KEY,
+	target_type text NOT NULL,
+	target_ids text NOT NULL,
+	topic text,
+	notification text NOT NULL,
+	send_at timestamp NOT NULL,
+	local_time boolean NOT NULL DEFAULT FALSE,
+	time_zone text,
+	due_at timestamp NOT NULL,
+	status text NOT NULL,
+	sent_device_ids text,
+	created_at timestamp NOT NULL,
+	claimed_until timestamp
+);
+CREATE INDEX _scheduled_push_status_due_at ON _scheduled_push (status, due_at);
+CREATE TABLE _upload_session (
+	id text PRIMARY KEY,
+	asset_name text NOT NULL,
+	content_type text NOT NULL,
+	length integer NOT NULL,
+	upload_offset integer NOT NULL,
+	multipart_id text,
+	parts text,
+	created_at timestamp NOT NULL,
+	updated_at timestamp NOT NULL,
+	expires_at timestamp
+);
+CREATE INDEX _upload_session_expires_at ON _upload_session (expires_at);
+`
+	_, err := tx.Exec(stmt)
+	return err
+}
+
+func (r *fullMigration) insertSeedData(tx *sqlx.Tx) error {
+	stmts := []string{
+		fmt.Sprintf(
+			`INSERT INTO _role (id, is_admin) VALUES ('%s', TRUE)`,
+			adminRoleDefaultName,
+		),
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *fullMigration) Up(tx *sqlx.Tx) error {
+	var err error
+	if err = r.createTable(tx); err != nil {
+		return err
+	}
+
+	return r.insertSeedData(tx)
+}
+
+func (r *fullMigration) Down(tx *sqlx.Tx) error {
+	panic("cannot downgrade from a base revision")
+}
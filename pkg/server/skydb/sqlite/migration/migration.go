@@ -0,0 +1,219 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migration creates and upgrades the tables of a SQLite database
+// used by the sqlite skydb driver. The revisions are independent of those
+// of the pq driver, as the tables of the two drivers are different.
+package migration
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+)
+
+var log = logging.LoggerEntryWithTag("skydb", "migration")
+
+const VersionTableName = "_version"
+
+var ErrMigrationDisabled = errors.New("skydb/sqlite/migration: migration disabled")
+
+type Revision interface {
+	Version() string
+	Up(tx *sqlx.Tx) error
+	Down(tx *sqlx.Tx) error
+}
+
+func findRevisionIndex(needle string) int {
+	for i := range revisions {
+		if revisions[i].Version() == needle {
+			return i
+		}
+	}
+	return -1
+}
+
+var findRevisions = func(current string, target string) []Revision {
+	full := &fullMigration{}
+	if current == "" && target == full.Version() {
+		return []Revision{full}
+	}
+
+	currentIndex := -1
+	targetIndex := -1
+
+	if current != "" {
+		currentIndex = findRevisionIndex(current)
+		if currentIndex == -1 {
+			panic("not found")
+		}
+	}
+
+	if target != "" {
+		targetIndex = findRevisionIndex(target)
+		if targetIndex == -1 {
+			panic("not found")
+		}
+	}
+
+	if currentIndex == targetIndex {
+		return []Revision{}
+	}
+
+	var results []Revision
+	if targetIndex > currentIndex {
+		for i := currentIndex + 1; i <= targetIndex; i++ {
+			results = append(results, revisions[i])
+		}
+	} else {
+		for i := currentIndex; i >= targetIndex+1; i-- {
+			results = append(results, revisions[i])
+		}
+	}
+	return results
+}
+
+func executeSchemaMigrations(tx *sqlx.Tx, original string, target string, downgrade bool) (err error) {
+	currentRevision := original
+	revs := findRevisions(original, target)
+	for i := range revs {
+		revision := revs[i]
+		if downgrade {
+			err = revision.Down(tx)
+		} else {
+			err = revision.Up(tx)
+		}
+
+		if err != nil {
+			log.Errorf(`Error executing schema migration "%s" -> "%s": %v`,
+				currentRevision, revision.Version(), err)
+			return err
+		}
+		log.Infof(`Executed schema migration "%s" -> "%s".`,
+			currentRevision, revision.Version())
+
+		currentRevision = revision.Version()
+	}
+
+	if err = ensureVersionTable(tx); err != nil {
+		return err
+	}
+
+	return setVersionNum(tx, original, target)
+}
+
+// EnsureLatest migrates the database to the latest revision. Like the
+// other statements of SQLite, the migration is transactional.
+func EnsureLatest(db *sqlx.DB, allowMigration bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Errorf(`Unable to begin transaction for schema migration: %v`, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	versionNum, err := currentVersionNum(tx)
+	if err != nil {
+		log.Errorf(`Unable to detetermine current schema version: %v`, err)
+		return err
+	}
+
+	full := &fullMigration{}
+	if versionNum == "" {
+		log.Debugf(`Database schema is uninitialized. Latest schema: "%s"`, full.Version())
+	} else if versionNum == full.Version() {
+		log.Debugf(`Database schema "%s" matches the latest schema "%s".`, versionNum, full.Version())
+	} else {
+		log.Debugf(`Database schema "%s" does not match the latest schema "%s".`, versionNum, full.Version())
+	}
+
+	if versionNum == full.Version() {
+		// no migration required
+		return nil
+	}
+
+	if !allowMigration {
+		log.Warnf(`Database schema does not match latest schema but migration is disabled.`)
+		return ErrMigrationDisabled
+	}
+
+	log.Infof(`Database schema requires migration.`)
+
+	if err := executeSchemaMigrations(tx, versionNum, full.Version(), false); err != nil {
+		return fmt.Errorf("skydb/sqlite: failed to init database: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Errorf(`Unable to commit transaction for schema migration: %v`, err)
+		return fmt.Errorf("skydb/sqlite: failed to commit DDL: %v", err)
+	}
+
+	return nil
+}
+
+func ensureVersionTable(tx *sqlx.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	version_num text NOT NULL
+);`, VersionTableName))
+	return err
+}
+
+func tableExists(tx *sqlx.Tx, table string) (bool, error) {
+	var exists bool
+	err := tx.QueryRowx(
+		`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`,
+		table,
+	).Scan(&exists)
+	return exists, err
+}
+
+func versionTableExists(tx *sqlx.Tx) (bool, error) {
+	return tableExists(tx, VersionTableName)
+}
+
+func currentVersionNum(tx *sqlx.Tx) (string, error) {
+	exists, err := versionTableExists(tx)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		log.Debugf(`Version table "%s" does not exist.`, VersionTableName)
+		return "", nil
+	}
+
+	var versionNum string
+	err = tx.QueryRowx(fmt.Sprintf(`SELECT version_num FROM %s`, VersionTableName)).
+		Scan(&versionNum)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	log.Debugf(`Current version of database schema is "%s".`, versionNum)
+	return versionNum, nil
+}
+
+func setVersionNum(tx *sqlx.Tx, original string, target string) error {
+	if original == "" {
+		_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (version_num) VALUES (?);`, VersionTableName), target)
+		return err
+	}
+
+	_, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET version_num = ? WHERE version_num = ?;`, VersionTableName), target, original)
+	return err
+}
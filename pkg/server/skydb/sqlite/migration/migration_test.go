@@ -0,0 +1,74 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package migration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func getTestDB(t *testing.T) (*sqlx.DB, func()) {
+	dir, err := ioutil.TempDir("", "skygear-sqlite-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEnsureLatest(t *testing.T) {
+	Convey("EnsureLatest", t, func() {
+		db, cleanup := getTestDB(t)
+		defer cleanup()
+
+		Convey("creates tables of the latest version", func() {
+			So(EnsureLatest(db, true), ShouldBeNil)
+
+			var versionNum string
+			So(db.QueryRowx("SELECT version_num FROM _version").Scan(&versionNum), ShouldBeNil)
+			So(versionNum, ShouldEqual, (&fullMigration{}).Version())
+
+			var count int
+			So(db.QueryRowx(`SELECT COUNT(*) FROM _role WHERE id = 'Admin' AND is_admin`).Scan(&count), ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			So(db.QueryRowx(`SELECT COUNT(*) FROM "user"`).Scan(&count), ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+
+		Convey("does nothing if the database is latest", func() {
+			So(EnsureLatest(db, true), ShouldBeNil)
+			So(EnsureLatest(db, false), ShouldBeNil)
+		})
+
+		Convey("returns error if migration is disabled", func() {
+			So(EnsureLatest(db, false), ShouldEqual, ErrMigrationDisabled)
+		})
+	})
+}
@@ -0,0 +1,1230 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/sqlite/builder"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+func (db *database) Get(id skydb.RecordID, record *skydb.Record) error {
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+
+	if len(typemap) == 0 { // record type has not been created
+		return skydb.ErrRecordNotFound
+	}
+
+	q := db.selectQuery(sqlBuilder.Select(), id.Type, typemap).Where(`"_id" = ?`, id.Key)
+	if isSoftDeleteEnabled(typemap) {
+		q = q.Where(notDeletedSqlizer(id.Type))
+	}
+
+	records, err := db.selectRecords(q, id.Type, typemap)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return skydb.ErrRecordNotFound
+	}
+	*record = records[0]
+	return nil
+}
+
+// GetForUpdate is the same as Get, as a transaction holds the write lock
+// of the database from the beginning with the immediate transaction lock.
+func (db *database) GetForUpdate(id skydb.RecordID, record *skydb.Record) error {
+	return db.Get(id, record)
+}
+
+// GetByIDs using SQL IN cause
+// GetByIDs only support one type of records at a time. If you want to query
+// array of ids belongs to different type, you need to call this method multiple
+// time.
+func (db *database) GetByIDs(ids []skydb.RecordID, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("db.GetByIDs received empty array")
+	}
+	idStrs := []interface{}{}
+	recordType := ""
+	for _, recordID := range ids {
+		if recordID.Key != "" {
+			idStrs = append(idStrs, recordID.Key)
+		}
+		if recordID.Type != "" && recordType == "" {
+			recordType = recordID.Type
+		}
+	}
+
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return nil, err
+	}
+	if len(typemap) == 0 {
+		return nil, skydb.ErrRecordNotFound
+	}
+
+	inCause, inArgs := builder.LiteralToSQLOperand(idStrs)
+	q := db.selectQuery(sqlBuilder.Select(), recordType, typemap).
+		Where(`"_id" IN `+inCause, inArgs...)
+	if isSoftDeleteEnabled(typemap) {
+		q = q.Where(notDeletedSqlizer(recordType))
+	}
+
+	if db.DatabaseType() == skydb.PublicDatabase && !accessControlOptions.BypassAccessControl {
+		factory := builder.NewSqlizerFactory(db, recordType)
+		aclSqlizer, err := factory.NewAccessControlSqlizer(accessControlOptions.ViewAsUser, skydb.ReadLevel)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(aclSqlizer)
+	}
+
+	rows, err := db.c.QueryWith(q)
+	return newRows(recordType, typemap, rows, err)
+}
+
+// notDeletedSqlizer returns the predicate excluding soft-deleted records
+// of the record type.
+func notDeletedSqlizer(recordType string) sq.Sqlizer {
+	return sq.Expr(fmt.Sprintf(`%s.%s IS NULL`, quoteIdentifier(recordType), quoteIdentifier(deletedAtColumn)))
+}
+
+// Save attempts to do a upsert
+func (db *database) Save(record *skydb.Record) error {
+	if record.ID.Key == "" {
+		return errors.New("db.save: got empty record id")
+	}
+	if record.ID.Type == "" {
+		return fmt.Errorf("db.save %s: got empty record type", record.ID.Key)
+	}
+	if record.OwnerID == "" {
+		return fmt.Errorf("db.save %s: got empty OwnerID", record.ID.Key)
+	}
+
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	typemap, err := db.RemoteColumnTypes(record.ID.Type)
+	if err != nil {
+		return err
+	}
+	if typemap == nil {
+		return skyerr.MakeError(fmt.Errorf(`db.save %s: relation "%s" does not exist`, record.ID, record.ID.Type))
+	}
+
+	return db.c.write(func() error {
+		return db.save(typemap, record)
+	})
+}
+
+// save computes the record to be saved from the current record, so that
+// field operations, sequences, defaults and constraints are applied by
+// the driver, and writes the columns of the record.
+// nolint: gocyclo
+func (db *database) save(typemap skydb.RecordSchema, record *skydb.Record) error {
+	recordType := record.ID.Type
+
+	current, err := db.getRow(recordType, typemap, record.ID.Key, nil)
+	if err != nil {
+		return err
+	}
+	updating := current != nil
+
+	var r skydb.Record
+	if updating {
+		if current.DatabaseID != db.userID {
+			return skyerr.NewErrorf(skyerr.Duplicated, "violate unique constraint")
+		}
+		r = *current
+	} else {
+		r = skydb.Record{
+			ID:         record.ID,
+			DatabaseID: db.userID,
+			OwnerID:    record.OwnerID,
+			CreatedAt:  record.CreatedAt.UTC(),
+			CreatorID:  record.CreatorID,
+			Data:       skydb.Data{},
+		}
+	}
+
+	r.ACL = nil
+	if record.ACL != nil {
+		r.ACL = append(skydb.RecordACL{}, record.ACL...)
+	}
+	r.UpdatedAt = record.UpdatedAt.UTC()
+	r.UpdaterID = record.UpdaterID
+
+	for _, key := range sortedDataKeys(record.Data) {
+		fieldType, ok := typemap[key]
+		if !ok || strings.HasPrefix(key, "_") {
+			return skyerr.MakeError(fmt.Errorf(`db.save %s: column "%s" of relation "%s" does not exist`, record.ID, key, recordType))
+		}
+
+		var value interface{}
+		var err error
+		switch v := record.Data[key].(type) {
+		case skydb.Unknown:
+			// Do not modify columns with unknown type because they are
+			// managed by the developer.
+			continue
+		case skydb.Sequence:
+			// The value is assigned from the sequence of the field.
+			continue
+		case skydb.FieldOperation:
+			value, err = applyFieldOperation(key, fieldType, r.Data[key], v)
+			if err != nil {
+				if _, ok := err.(skyerr.Error); ok {
+					return err
+				}
+			}
+		default:
+			value, err = storeValue(fieldType, v)
+		}
+		if err != nil {
+			return skyerr.NewErrorf(
+				skyerr.InvalidArgument,
+				fmt.Sprintf("failed to save %s: %s", record.ID, err),
+			)
+		}
+
+		if value == nil {
+			delete(r.Data, key)
+		} else {
+			r.Data[key] = value
+		}
+		if number, ok := value.(int64); ok && fieldType.Type == skydb.TypeSequence {
+			if err := db.advanceSequence(recordType, key, number); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !updating {
+		for _, field := range sortedSchemaKeys(typemap) {
+			fieldType := typemap[field]
+			if _, ok := r.Data[field]; ok || strings.HasPrefix(field, "_") {
+				continue
+			}
+			if _, ok := record.Data[field]; ok {
+				if _, isSequence := record.Data[field].(skydb.Sequence); !isSequence {
+					continue
+				}
+			}
+
+			if fieldType.Type == skydb.TypeSequence {
+				value, err := db.nextSequence(recordType, field)
+				if err != nil {
+					return err
+				}
+				r.Data[field] = value
+			} else if fieldType.Constraints != nil && fieldType.Constraints.Default != nil {
+				value, err := storeValue(fieldType, fieldType.Constraints.Default)
+				if err != nil {
+					return skyerr.MakeError(err)
+				}
+				r.Data[field] = value
+			}
+		}
+	}
+
+	if err := db.checkRecord(typemap, &r); err != nil {
+		return err
+	}
+
+	if err := db.writeRow(typemap, &r, updating); err != nil {
+		if isUniqueViolated(err) {
+			columns := uniqueViolatedColumns(err)
+			if len(columns) == 1 {
+				field := columns[0]
+				if fieldType, ok := typemap[field]; ok && fieldType.Constraints != nil && fieldType.Constraints.Unique {
+					return skyerr.NewErrorWithInfo(
+						skyerr.ConstraintViolated,
+						fmt.Sprintf("%s: value is not unique", field),
+						map[string]interface{}{
+							"field":      field,
+							"constraint": skydb.UniqueConstraint,
+						},
+					)
+				}
+			}
+			return skyerr.NewErrorf(
+				skyerr.Duplicated,
+				fmt.Sprintf("violate unique constraint"),
+			)
+		}
+		return skyerr.MakeError(err)
+	}
+
+	transient := record.Transient
+	*record = r
+	record.Transient = transient
+
+	if updating {
+		db.c.emit(&r, skydb.RecordUpdated)
+	} else {
+		db.c.emit(&r, skydb.RecordCreated)
+	}
+	return nil
+}
+
+// writeRow inserts the record, or updates the existing row of the record.
+// Columns of unknown type are not modified.
+func (db *database) writeRow(typemap skydb.RecordSchema, r *skydb.Record, updating bool) error {
+	acl, err := aclValue(r.ACL)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"_access":     acl,
+		"_updated_at": builder.FormatDatetime(r.UpdatedAt),
+		"_updated_by": r.UpdaterID,
+	}
+	for field, fieldType := range typemap {
+		if strings.HasPrefix(field, "_") || fieldType.Type == skydb.TypeUnknown {
+			continue
+		}
+		value, err := sqlValue(fieldType, r.Data[field])
+		if err != nil {
+			return err
+		}
+		data[field] = value
+	}
+
+	if updating {
+		q := sqlBuilder.Update(db.TableName(r.ID.Type)).Where(`"_id" = ?`, r.ID.Key)
+		for _, column := range sortedColumns(data) {
+			q = q.Set(quoteIdentifier(column), data[column])
+		}
+		_, err = db.c.ExecWith(q)
+		return err
+	}
+
+	data["_id"] = r.ID.Key
+	data["_database_id"] = r.DatabaseID
+	data["_owner_id"] = r.OwnerID
+	data["_created_at"] = builder.FormatDatetime(r.CreatedAt)
+	data["_created_by"] = r.CreatorID
+
+	q := sqlBuilder.Insert(db.TableName(r.ID.Type))
+	columns := sortedColumns(data)
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = data[column]
+		columns[i] = quoteIdentifier(column)
+	}
+	_, err = db.c.ExecWith(q.Columns(columns...).Values(values...))
+	return err
+}
+
+func sortedDataKeys(data skydb.Data) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSchemaKeys(schema skydb.RecordSchema) []string {
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyFieldOperation returns the new value of a field by applying the
+// field operation to its current value.
+func applyFieldOperation(field string, fieldType skydb.FieldType, current interface{}, op skydb.FieldOperation) (interface{}, error) {
+	if err := op.CheckFieldType(field, fieldType); err != nil {
+		return nil, err
+	}
+
+	switch op.Operator {
+	case skydb.IncrementOperator:
+		number, _ := toFloat64(op.Value)
+		currentNumber, _ := toFloat64(current)
+		return storeValue(fieldType, currentNumber+number)
+	case skydb.MaxOperator:
+		number, _ := toFloat64(op.Value)
+		if currentNumber, ok := toFloat64(current); ok && currentNumber > number {
+			number = currentNumber
+		}
+		return storeValue(fieldType, number)
+	}
+
+	items, err := jsonValue(op.Value)
+	if err != nil {
+		return nil, err
+	}
+	operands, _ := items.([]interface{})
+
+	var elements []interface{}
+	switch c := current.(type) {
+	case nil:
+		elements = []interface{}{}
+	case []interface{}:
+		elements = c
+	default:
+		elements = []interface{}{c}
+	}
+
+	result := []interface{}{}
+	if op.Operator == skydb.PushOperator {
+		result = append(result, elements...)
+		result = append(result, operands...)
+	} else {
+		for _, element := range elements {
+			pulled := false
+			for _, operand := range operands {
+				if reflect.DeepEqual(element, operand) {
+					pulled = true
+					break
+				}
+			}
+			if !pulled {
+				result = append(result, element)
+			}
+		}
+	}
+	return storeValue(fieldType, result)
+}
+
+// checkRecord checks a record to be saved against the constraints of its
+// fields and the existence of the records and assets it references.
+// Uniqueness is checked by the unique indexes of the table.
+func (db *database) checkRecord(typemap skydb.RecordSchema, record *skydb.Record) error {
+	recordType := record.ID.Type
+
+	for _, field := range sortedSchemaKeys(typemap) {
+		if strings.HasPrefix(field, "_") {
+			continue
+		}
+		fieldType := typemap[field]
+		value := record.Data[field]
+		if value == nil {
+			if fieldType.Constraints != nil {
+				if err := fieldType.Constraints.Validate(nil); err != nil {
+					return constraintViolationError(field, err)
+				}
+			}
+			continue
+		}
+
+		var exists bool
+		var err error
+		switch fieldType.Type {
+		case skydb.TypeReference:
+			exists, err = db.rowExists(
+				fmt.Sprintf(`SELECT count(*) FROM %s WHERE "_id" = ?`, db.TableName(fieldType.ReferenceType)),
+				value.(skydb.Reference).ID.Key,
+			)
+		case skydb.TypeAsset:
+			exists, err = db.rowExists("SELECT count(*) FROM _asset WHERE id = ?", value.(*skydb.Asset).Name)
+		default:
+			exists = true
+		}
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return skyerr.MakeError(fmt.Errorf(
+				`insert or update on table "%s" violates foreign key constraint on "%s"`,
+				recordType, field,
+			))
+		}
+
+		if fieldType.Constraints != nil {
+			if err := fieldType.Constraints.Validate(value); err != nil {
+				return constraintViolationError(field, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *database) rowExists(query string, args ...interface{}) (bool, error) {
+	var count int
+	if err := db.c.Get(&count, query, args...); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// constraintViolationError returns a ConstraintViolated error if err is
+// a skydb.ConstraintViolation on the specified field.
+func constraintViolationError(field string, err error) error {
+	violation, ok := err.(*skydb.ConstraintViolation)
+	if !ok {
+		return skyerr.MakeError(err)
+	}
+
+	return skyerr.NewErrorWithInfo(
+		skyerr.ConstraintViolated,
+		fmt.Sprintf("%s: %s", field, violation.Message),
+		map[string]interface{}{
+			"field":      field,
+			"constraint": violation.Constraint,
+		},
+	)
+}
+
+// getRow returns the record of the key in all databases, including a
+// soft-deleted record, or nil if there is no such record. The record is
+// restricted to the database of databaseID if it is not nil.
+func (db *database) getRow(recordType string, typemap skydb.RecordSchema, key string, databaseID *string) (*skydb.Record, error) {
+	q := sqlBuilder.Select().From(db.TableName(recordType)).Where(`"_id" = ?`, key)
+	if databaseID != nil {
+		q = q.Where(`"_database_id" = ?`, *databaseID)
+	}
+	q = selectColumns(q, recordType, typemap)
+
+	records, err := db.selectRecords(q, recordType, typemap)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+func (db *database) selectRecords(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema) ([]skydb.Record, error) {
+	rows, err := db.c.QueryWith(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := newRecordScanner(recordType, typemap, rows)
+	records := []skydb.Record{}
+	for rows.Next() {
+		var record skydb.Record
+		if err := rs.Scan(&record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (db *database) Delete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+	if typemap == nil {
+		return skydb.ErrRecordNotFound
+	}
+
+	return db.c.write(func() error {
+		record, err := db.getRow(id.Type, typemap, id.Key, &db.userID)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return skydb.ErrRecordNotFound
+		}
+
+		if isSoftDeleteEnabled(typemap) {
+			if !record.DeletedAt.IsZero() {
+				return skydb.ErrRecordNotFound
+			}
+			record.DeletedAt = timeNow()
+			if _, err := db.c.ExecWith(sqlBuilder.Update(db.TableName(id.Type)).
+				Set(deletedAtColumn, builder.FormatDatetime(record.DeletedAt)).
+				Where(`"_id" = ?`, id.Key)); err != nil {
+				return fmt.Errorf("delete %s: failed to delete record", id)
+			}
+			db.c.emit(record, skydb.RecordUpdated)
+			return nil
+		}
+
+		return db.deleteRow(id, record)
+	})
+}
+
+// deleteRow removes a record from its table. Like a foreign key, records
+// referencing the deleted record are deleted or have the reference set to
+// null according to the on delete action of the reference field, and the
+// deletion fails if the action is restrict.
+func (db *database) deleteRow(id skydb.RecordID, record *skydb.Record) error {
+	recordType := record.ID.Type
+	key := record.ID.Key
+
+	result, err := db.c.ExecWith(sqlBuilder.Delete(db.TableName(recordType)).Where(`"_id" = ?`, key))
+	if err != nil {
+		return fmt.Errorf("delete %s: failed to delete record", id)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("delete %s: failed to retrieve deletion status", id)
+	} else if rowsAffected == 0 {
+		// deleted by a cascade
+		return nil
+	}
+	db.c.emit(record, skydb.RecordDeleted)
+
+	type referencingField struct {
+		RecordType string `db:"record_type"`
+		Field      string `db:"record_field"`
+	}
+	referencingFields := []referencingField{}
+	if err := db.c.Select(&referencingFields, `
+		SELECT record_type, record_field FROM _record_field_type
+		WHERE type = 'ref' AND reference_type = ?
+		ORDER BY record_type, record_field`,
+		recordType,
+	); err != nil {
+		return err
+	}
+
+	for _, ref := range referencingFields {
+		typemap, err := db.RemoteColumnTypes(ref.RecordType)
+		if err != nil {
+			return err
+		}
+		fieldType, ok := typemap[ref.Field]
+		if !ok {
+			continue
+		}
+
+		var action skydb.ReferenceAction
+		if fieldType.Constraints != nil {
+			action = fieldType.Constraints.OnDelete
+		}
+
+		q := sqlBuilder.Select().From(db.TableName(ref.RecordType)).
+			Where(fmt.Sprintf("%s = ?", quoteIdentifier(ref.Field)), key).
+			OrderBy("rowid")
+		referencingRecords, err := db.selectRecords(selectColumns(q, ref.RecordType, typemap), ref.RecordType, typemap)
+		if err != nil {
+			return err
+		}
+
+		for i := range referencingRecords {
+			referencing := &referencingRecords[i]
+			switch action {
+			case skydb.CascadeReference:
+				if err := db.deleteRow(id, referencing); err != nil {
+					return err
+				}
+			case skydb.SetNullReference:
+				if _, err := db.c.ExecWith(sqlBuilder.Update(db.TableName(ref.RecordType)).
+					Set(quoteIdentifier(ref.Field), nil).
+					Where(`"_id" = ?`, referencing.ID.Key)); err != nil {
+					return err
+				}
+				delete(referencing.Data, ref.Field)
+				db.c.emit(referencing, skydb.RecordUpdated)
+			default:
+				return skyerr.NewError(
+					skyerr.ConstraintViolated,
+					fmt.Sprintf("delete %s: failed to delete record because other records have reference to it", id),
+				)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *database) Undelete(id skydb.RecordID) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return skydb.ErrDatabaseIsReadOnly
+	}
+
+	typemap, err := db.RemoteColumnTypes(id.Type)
+	if err != nil {
+		return err
+	}
+	if !isSoftDeleteEnabled(typemap) {
+		return skydb.ErrRecordNotFound
+	}
+
+	return db.c.write(func() error {
+		record, err := db.getRow(id.Type, typemap, id.Key, &db.userID)
+		if err != nil {
+			return err
+		}
+		if record == nil || record.DeletedAt.IsZero() {
+			return skydb.ErrRecordNotFound
+		}
+
+		if _, err := db.c.ExecWith(sqlBuilder.Update(db.TableName(id.Type)).
+			Set(deletedAtColumn, nil).
+			Where(`"_id" = ?`, id.Key)); err != nil {
+			return fmt.Errorf("undelete %s: failed to undelete record", id)
+		}
+		record.DeletedAt = time.Time{}
+		db.c.emit(record, skydb.RecordUpdated)
+		return nil
+	})
+}
+
+// PurgeDeletedRecords removes the soft-deleted records one by one, so that
+// a record referenced by other records is skipped without failing the
+// others. It cannot be called in a transaction, like the pq driver.
+func (c *conn) PurgeDeletedRecords(deletedBefore time.Time) (uint64, error) {
+	logger := logging.CreateLogger(c.context, "skydb")
+	if c.tx != nil {
+		return 0, skydb.ErrDatabaseTxDidBegin
+	}
+
+	db := &database{c: c, databaseType: skydb.PublicDatabase}
+	schemas, err := db.GetRecordSchemas()
+	if err != nil {
+		return 0, err
+	}
+
+	var purged uint64
+	for _, recordType := range sortedRecordTypes(schemas) {
+		typemap := schemas[recordType]
+		if !isSoftDeleteEnabled(typemap) {
+			continue
+		}
+
+		keys := []string{}
+		if err := c.Select(&keys, fmt.Sprintf(
+			`SELECT "_id" FROM %s WHERE %s < ? ORDER BY rowid`,
+			db.TableName(recordType), quoteIdentifier(deletedAtColumn),
+		), builder.FormatDatetime(deletedBefore)); err != nil {
+			return purged, err
+		}
+
+		for _, key := range keys {
+			id := skydb.NewRecordID(recordType, key)
+			err := c.write(func() error {
+				record, err := db.getRow(recordType, typemap, key, nil)
+				if err != nil {
+					return err
+				}
+				if record == nil {
+					return skydb.ErrRecordNotFound
+				}
+				return db.deleteRow(id, record)
+			})
+			if err == skydb.ErrRecordNotFound {
+				continue
+			} else if skyErr, ok := err.(skyerr.Error); ok && skyErr.Code() == skyerr.ConstraintViolated {
+				logger.WithField("record_id", id).
+					Infoln("Skipped purging record referenced by other records")
+				continue
+			} else if err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func sortedRecordTypes(schemas map[string]skydb.RecordSchema) []string {
+	recordTypes := make([]string, 0, len(schemas))
+	for recordType := range schemas {
+		recordTypes = append(recordTypes, recordType)
+	}
+	sort.Strings(recordTypes)
+	return recordTypes
+}
+
+// newQuerySqlizerFactory returns the SqlizerFactory for a query on the
+// records of recordType, which applies the database and access control
+// of the query to the records of joined tables and subqueries.
+func (db *database) newQuerySqlizerFactory(recordType string, accessControlOptions *skydb.AccessControlOptions) builder.SqlizerFactory {
+	var databaseID *string
+	if db.DatabaseType() != skydb.UnionDatabase {
+		databaseID = &db.userID
+	}
+	return builder.NewQuerySqlizerFactory(db, recordType, databaseID, accessControlOptions)
+}
+
+func (db *database) applyQueryPredicate(q sq.SelectBuilder, factory builder.SqlizerFactory, query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (sq.SelectBuilder, error) {
+	if p := query.Predicate; !p.IsEmpty() {
+		sqlizer, err := factory.NewPredicateSqlizer(p)
+		if err != nil {
+			return q, err
+		}
+		q = q.Where(sqlizer)
+	}
+
+	if db.DatabaseType() == skydb.PublicDatabase && !accessControlOptions.BypassAccessControl {
+		aclSqlizer, err := factory.NewAccessControlSqlizer(accessControlOptions.ViewAsUser, skydb.ReadLevel)
+		if err != nil {
+			return q, err
+		}
+		q = q.Where(aclSqlizer)
+	}
+
+	return q, nil
+}
+
+func (db *database) Query(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (*skydb.Rows, error) {
+	q, typemap, err := db.selectQueryBuilder(query, accessControlOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if typemap == nil { // record type has not been created
+		return skydb.EmptyRows, nil
+	}
+
+	rows, err := db.c.QueryWith(q)
+	return newRows(query.Type, typemap, rows, err)
+}
+
+// selectQueryBuilder returns the select statement of the supplied query
+// and the typemap of the selected columns. A nil typemap is returned if
+// the record type has not been created.
+func (db *database) selectQueryBuilder(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (sq.Sqlizer, skydb.RecordSchema, error) {
+	q := sqlBuilder.Select()
+	if query.Type == "" {
+		return q, nil, errors.New("got empty query type")
+	}
+
+	typemap, err := db.RemoteColumnTypes(query.Type)
+	if err != nil {
+		return q, nil, err
+	}
+
+	if len(typemap) == 0 { // record type has not been created
+		return q, nil, nil
+	}
+
+	if isSoftDeleteEnabled(typemap) && !query.IncludeDeleted {
+		q = q.Where(notDeletedSqlizer(query.Type))
+	}
+	factory := db.newQuerySqlizerFactory(query.Type, accessControlOptions)
+
+	q, err = db.applyQueryPredicate(q, factory, query, accessControlOptions)
+	if err != nil {
+		return q, nil, err
+	}
+
+	orderBys := []string{}
+	for _, sort := range query.Sorts {
+		orderBy, err := factory.NewSort(sort)
+		if err != nil {
+			return q, nil, err
+		}
+		orderBys = append(orderBys, orderBy)
+	}
+
+	q = factory.AddJoinsToSelectBuilder(q)
+
+	// the records within the partition limit are ordered and paged
+	// by partitionLimitQuery
+	if query.PartitionLimit == nil {
+		for _, orderBy := range orderBys {
+			q = q.OrderBy(orderBy)
+		}
+
+		if query.Limit != nil {
+			q = q.Limit(*query.Limit)
+		} else if query.Offset > 0 {
+			// SQLite requires a limit to go with an offset.
+			q = q.Limit(math.MaxInt64)
+		}
+
+		if query.Offset > 0 {
+			q = q.Offset(query.Offset)
+		}
+	}
+
+	// Select columns to return, this is the last step so that predicate
+	// have a chance to change typemap, and the generated sql
+	// depends on the alias name used in table joins.
+	typemap, err = updateTypemapForQuery(query, typemap)
+	if err != nil {
+		return q, nil, err
+	}
+	typemap = factory.UpdateTypemap(typemap)
+	q = db.selectQuery(q, query.Type, typemap)
+
+	if query.PartitionLimit != nil {
+		partitioned, err := partitionLimitQuery(q, query, orderBys, typemap)
+		return partitioned, typemap, err
+	}
+	return q, typemap, nil
+}
+
+// partitionLimitQuery returns the statement selecting the records of q
+// within the partition limit of the query. The records are numbered by
+// window functions in a subquery, which is not ordered, so the statement
+// orders the records by their numbers in the order of the query.
+func partitionLimitQuery(q sq.SelectBuilder, query *skydb.Query, orderBys []string, typemap skydb.RecordSchema) (sq.Sqlizer, error) {
+	over := ""
+	if len(orderBys) > 0 {
+		over = "ORDER BY " + strings.Join(orderBys, ", ")
+		q = q.Column("ROW_NUMBER() OVER (" + over + `) AS "_query_row"`)
+	}
+	field := quoteIdentifier(query.Type) + "." + quoteIdentifier(query.PartitionLimit.Field)
+	q = q.Column("ROW_NUMBER() OVER (PARTITION BY " + field + " " + over + `) AS "_partition_row"`)
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(typemap))
+	for column := range typemap {
+		columns = append(columns, quoteIdentifier(column))
+	}
+	sql = fmt.Sprintf(`SELECT %s FROM (%s) AS "_partitioned" WHERE "_partition_row" <= ?`, strings.Join(columns, ", "), sql)
+	args = append(args, query.PartitionLimit.Limit)
+	if len(orderBys) > 0 {
+		sql += ` ORDER BY "_query_row"`
+	}
+	if query.Limit != nil {
+		sql += " LIMIT ?"
+		args = append(args, *query.Limit)
+	} else if query.Offset > 0 {
+		// SQLite requires a limit to go with an offset.
+		sql += " LIMIT ?"
+		args = append(args, math.MaxInt64)
+	}
+	if query.Offset > 0 {
+		sql += " OFFSET ?"
+		args = append(args, query.Offset)
+	}
+	return sq.Expr(sql, args...), nil
+}
+
+func (db *database) QueryCount(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (uint64, error) {
+	if query.Type == "" {
+		return 0, errors.New("got empty query type")
+	}
+
+	typemap, err := db.RemoteColumnTypes(query.Type)
+	if err != nil || len(typemap) == 0 { // error or record type has not been created
+		return 0, err
+	}
+	softDeleteEnabled := isSoftDeleteEnabled(typemap)
+
+	factory := db.newQuerySqlizerFactory(query.Type, accessControlOptions)
+	q := sqlBuilder.Select().From(db.TableName(query.Type))
+	q = db.whereDatabase(q, query.Type)
+	if softDeleteEnabled && !query.IncludeDeleted {
+		q = q.Where(notDeletedSqlizer(query.Type))
+	}
+	q, err = db.applyQueryPredicate(q, factory, query, accessControlOptions)
+	if err != nil {
+		return 0, err
+	}
+	q = factory.AddJoinsToSelectBuilder(q)
+
+	// The records are counted in a subquery because the joins may
+	// require distinct records.
+	sql, args, err := q.Column(quoteIdentifier(query.Type) + `."_id"`).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var recordCount uint64
+	if err := db.c.Get(&recordCount, "SELECT count(*) FROM ("+sql+")", args...); err != nil {
+		return 0, err
+	}
+	return recordCount, nil
+}
+
+// ExplainQuery returns the statement of the query and the plan reported
+// by EXPLAIN QUERY PLAN, which is not executed.
+func (db *database) ExplainQuery(query *skydb.Query, accessControlOptions *skydb.AccessControlOptions) (skydb.QueryExplanation, error) {
+	q, typemap, err := db.selectQueryBuilder(query, accessControlOptions)
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	if typemap == nil { // record type has not been created
+		return skydb.QueryExplanation{}, nil
+	}
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	rows, err := db.c.Queryx("EXPLAIN QUERY PLAN "+sql, args...)
+	if err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+	defer rows.Close()
+
+	plan := []interface{}{}
+	for rows.Next() {
+		var id, parent, notUsed int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return skydb.QueryExplanation{}, err
+		}
+		plan = append(plan, map[string]interface{}{
+			"id":     id,
+			"parent": parent,
+			"detail": detail,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return skydb.QueryExplanation{}, err
+	}
+
+	return skydb.QueryExplanation{
+		Statement: sql,
+		Args:      args,
+		Plan:      plan,
+	}, nil
+}
+
+// columnsScanner wraps over sqlx.Rows and sqlx.Row to provide
+// a consistent interface for column scanning.
+type columnsScanner interface {
+	Columns() ([]string, error)
+	Scan(dest ...interface{}) error
+}
+
+type recordScanner struct {
+	recordType  string
+	typemap     skydb.RecordSchema
+	cs          columnsScanner
+	columns     []string
+	err         error
+	recordCount *uint64
+}
+
+func newRecordScanner(recordType string, typemap skydb.RecordSchema, cs columnsScanner) *recordScanner {
+	columns, err := cs.Columns()
+	return &recordScanner{recordType, typemap, cs, columns, err, nil}
+}
+
+func (rs *recordScanner) Scan(record *skydb.Record) error {
+	if rs.err != nil {
+		return rs.err
+	}
+
+	values := make([]interface{}, len(rs.columns))
+	pointers := make([]interface{}, len(rs.columns))
+	for i, column := range rs.columns {
+		if _, ok := rs.typemap[column]; !ok {
+			return fmt.Errorf("received unknown column = %s", column)
+		}
+		pointers[i] = &values[i]
+	}
+
+	if err := rs.cs.Scan(pointers...); err != nil {
+		rs.err = err
+		return err
+	}
+
+	*record = skydb.Record{
+		ID:   skydb.NewRecordID(rs.recordType, ""),
+		Data: skydb.Data{},
+	}
+
+	for i, column := range rs.columns {
+		if column == "_record_count" {
+			count, ok := toFloat64(values[i])
+			if !ok {
+				panic("Unexpected missing column or column is null for _record_count.")
+			}
+
+			rs.recordCount = new(uint64)
+			*rs.recordCount = uint64(count)
+			continue
+		}
+
+		if strings.HasPrefix(column, "_sort") {
+			// Used for sorting in distinct query, shouldn't be included in record
+			continue
+		}
+
+		value, err := scanValue(rs.typemap[column], values[i])
+		if err != nil {
+			return fmt.Errorf("failed to scan column %s: %s", column, err)
+		}
+		if value != nil {
+			record.Set(column, value)
+		}
+	}
+
+	return nil
+}
+
+type rowsIter struct {
+	rows *sqlx.Rows
+	rs   *recordScanner
+}
+
+func (rowsi rowsIter) Close() error {
+	return rowsi.rows.Close()
+}
+
+func (rowsi rowsIter) Next(record *skydb.Record) error {
+	if rowsi.rows.Next() {
+		return rowsi.rs.Scan(record)
+	} else if rowsi.rows.Err() != nil {
+		return rowsi.rows.Err()
+	} else {
+		return io.EOF
+	}
+}
+
+func (rowsi rowsIter) OverallRecordCount() *uint64 {
+	return rowsi.rs.recordCount
+}
+
+func newRows(recordType string, typemap skydb.RecordSchema, rows *sqlx.Rows, err error) (*skydb.Rows, error) {
+	if err != nil {
+		return nil, err
+	}
+	rs := newRecordScanner(recordType, typemap, rows)
+	return skydb.NewRows(rowsIter{rows, rs}), nil
+}
+
+func columnSqlizersForSelect(recordType string, typemap skydb.RecordSchema) map[string]sq.Sqlizer {
+	sqlizers := map[string]sq.Sqlizer{}
+	for column, fieldType := range typemap {
+		expr := fieldType.Expression
+		if expr.IsEmpty() {
+			expr = skydb.Expression{
+				Type:  skydb.KeyPath,
+				Value: column,
+			}
+		}
+
+		if expr.IsKeyPath() && len(expr.KeyPathComponents()) >= 2 {
+			// For field like category.name should be prefixed by reference table name
+			sqlizers[column] = builder.NewExpressionSqlizer(fieldType.ReferenceType, fieldType, expr)
+		} else {
+			sqlizers[column] = builder.NewExpressionSqlizer(recordType, fieldType, expr)
+		}
+	}
+	return sqlizers
+}
+
+// selectColumns adds the columns of typemap to q.
+func selectColumns(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema) sq.SelectBuilder {
+	for column, e := range columnSqlizersForSelect(recordType, typemap) {
+		sqlOperand, opArgs, _ := e.ToSql()
+		q = q.Column(sqlOperand+" AS "+quoteIdentifier(column), opArgs...)
+	}
+	return q
+}
+
+func (db *database) selectQuery(q sq.SelectBuilder, recordType string, typemap skydb.RecordSchema) sq.SelectBuilder {
+	q = selectColumns(q, recordType, typemap)
+	q = q.From(db.TableName(recordType))
+	return db.whereDatabase(q, recordType)
+}
+
+// whereDatabase restricts the records to the database, except for the
+// union database.
+func (db *database) whereDatabase(q sq.SelectBuilder, recordType string) sq.SelectBuilder {
+	switch db.DatabaseType() {
+	case skydb.UnionDatabase:
+		// no filter on `_database_id` column
+	case skydb.PublicDatabase:
+		fallthrough
+	case skydb.PrivateDatabase:
+		q = q.Where(fmt.Sprintf(`%s."_database_id" = ?`, quoteIdentifier(recordType)), db.userID)
+	}
+	return q
+}
+
+func updateTypemapForQuery(query *skydb.Query, typemap skydb.RecordSchema) (skydb.RecordSchema, error) {
+	if query.DesiredKeys != nil {
+		newtypemap, err := whitelistedRecordSchema(typemap, query.DesiredKeys)
+		if err != nil {
+			return nil, err
+		}
+		typemap = newtypemap
+	} else {
+		typemap = copyTypemap(typemap)
+	}
+
+	for key, value := range query.ComputedKeys {
+		if value.Type == skydb.KeyPath {
+			// recorddb does not support querying with computed keys
+			continue
+		}
+
+		v := value // because value will be overwritten in the next loop
+		typemap["_transient_"+key] = skydb.FieldType{
+			Type:       skydb.TypeNumber,
+			Expression: v,
+		}
+	}
+
+	if query.GetCount {
+		typemap["_record_count"] = skydb.FieldType{
+			Type: skydb.TypeNumber,
+			Expression: skydb.Expression{
+				Type: skydb.Function,
+				Value: skydb.CountFunc{
+					OverallRecords: true,
+				},
+			},
+		}
+	}
+	return typemap, nil
+}
+
+// copyTypemap returns a copy of a typemap, so that the typemap cached in
+// the connection is not modified by a query.
+func copyTypemap(typemap skydb.RecordSchema) skydb.RecordSchema {
+	typemapCopy := make(skydb.RecordSchema, len(typemap))
+	for column, fieldType := range typemap {
+		typemapCopy[column] = fieldType
+	}
+	return typemapCopy
+}
+
+func whitelistedRecordSchema(schema skydb.RecordSchema, whitelistKeys []string) (skydb.RecordSchema, error) {
+	wlSchema := skydb.RecordSchema{}
+
+	for _, key := range whitelistKeys {
+		columnType, ok := schema[key]
+		if !ok {
+			return nil, fmt.Errorf(`unexpected key "%s"`, key)
+		}
+		wlSchema[key] = columnType
+	}
+	for key, value := range schema {
+		if strings.HasPrefix(key, "_") {
+			wlSchema[key] = value
+		}
+	}
+
+	return wlSchema, nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/skyconv"
+	"github.com/skygeario/skygear-server/pkg/server/uuid"
+)
+
+func revisionRecordJSON(record skydb.Record) (string, error) {
+	record.Transient = nil
+	data, err := json.Marshal((*skyconv.JSONRecord)(&record))
+	return string(data), err
+}
+
+func revisionDiffJSON(d map[string]skydb.RecordFieldChange) (string, error) {
+	m := map[string]interface{}{}
+	for key, change := range d {
+		m[key] = map[string]interface{}{
+			"old": skyconv.ToLiteral(change.Old),
+			"new": skyconv.ToLiteral(change.New),
+		}
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func parseRevisionDiff(data []byte) (map[string]skydb.RecordFieldChange, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	m := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	diff := map[string]skydb.RecordFieldChange{}
+	for key, change := range m {
+		oldValue, err := skyconv.TryParseLiteral(change["old"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid old value of %s: %v", key, err)
+		}
+		newValue, err := skyconv.TryParseLiteral(change["new"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid new value of %s: %v", key, err)
+		}
+		diff[key] = skydb.RecordFieldChange{Old: oldValue, New: newValue}
+	}
+	return diff, nil
+}
+
+func (c *conn) GetRecordHistoryTypes() ([]string, error) {
+	builder := sqlBuilder.Select("record_type").
+		From("_record_history_type").
+		OrderBy("record_type")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recordTypes := []string{}
+	for rows.Next() {
+		var recordType string
+		if err := rows.Scan(&recordType); err != nil {
+			return nil, err
+		}
+		recordTypes = append(recordTypes, recordType)
+	}
+	return recordTypes, rows.Err()
+}
+
+func (c *conn) SetRecordHistoryEnabled(recordType string, enabled bool) error {
+	return c.write(func() error {
+		deleteBuilder := sqlBuilder.Delete("_record_history_type").
+			Where("record_type = ?", recordType)
+		if _, err := c.ExecWith(deleteBuilder); err != nil {
+			return err
+		}
+
+		if !enabled {
+			return nil
+		}
+
+		insertBuilder := sqlBuilder.Insert("_record_history_type").
+			Columns("record_type").
+			Values(recordType)
+		_, err := c.ExecWith(insertBuilder)
+		return err
+	})
+}
+
+func (c *conn) SaveRecordRevision(revision *skydb.RecordRevision) error {
+	if revision.RecordID.Type == "" || revision.RecordID.Key == "" {
+		return errors.New("invalid record revision: empty record id")
+	}
+
+	id := uuid.New()
+	createdAt := timeNow()
+
+	record, err := revisionRecordJSON(revision.Record)
+	if err != nil {
+		return err
+	}
+
+	var diff interface{}
+	if revision.Diff != nil {
+		if diff, err = revisionDiffJSON(revision.Diff); err != nil {
+			return err
+		}
+	}
+
+	// Version is assigned in the same statement, which is serialized with
+	// the other writes by the database lock.
+	const stmt = `
+		INSERT INTO _record_history (id, record_type, record_id, database_id, version,
+			action, record, diff, actor_id, created_at)
+		SELECT ?1, ?2, ?3, ?4, COALESCE(MAX(version), 0) + 1, ?5, ?6, ?7, ?8, ?9
+		FROM _record_history
+		WHERE record_type = ?2 AND record_id = ?3 AND database_id = ?4
+		RETURNING version`
+
+	var version int
+	err = c.write(func() error {
+		return c.QueryRowx(stmt,
+			id,
+			revision.RecordID.Type,
+			revision.RecordID.Key,
+			revision.DatabaseID,
+			string(revision.Action),
+			record,
+			diff,
+			nullString(revision.ActorID),
+			createdAt,
+		).Scan(&version)
+	})
+	if err != nil {
+		return err
+	}
+
+	revision.ID = id
+	revision.Version = version
+	revision.CreatedAt = createdAt
+	return nil
+}
+
+func (c *conn) recordRevisionBuilder(databaseID string, id skydb.RecordID) sq.SelectBuilder {
+	return sqlBuilder.Select("id", "version", "action", "record", "diff",
+		"actor_id", "created_at").
+		From("_record_history").
+		Where("record_type = ? AND record_id = ? AND database_id = ?",
+			id.Type, id.Key, databaseID)
+}
+
+func (c *conn) doScanRecordRevision(revision *skydb.RecordRevision, scanner sq.RowScanner) error {
+	var (
+		action     string
+		recordData sql.NullString
+		diffData   sql.NullString
+		actorID    sql.NullString
+	)
+
+	err := scanner.Scan(
+		&revision.ID,
+		&revision.Version,
+		&action,
+		&recordData,
+		&diffData,
+		&actorID,
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	revision.Record = skydb.Record{}
+	if recordData.Valid {
+		if err := json.Unmarshal([]byte(recordData.String), (*skyconv.JSONRecord)(&revision.Record)); err != nil {
+			return err
+		}
+		revision.Record.DatabaseID = revision.DatabaseID
+	}
+
+	var diff map[string]skydb.RecordFieldChange
+	if diffData.Valid {
+		diff, err = parseRevisionDiff([]byte(diffData.String))
+	}
+	if err != nil {
+		return err
+	}
+
+	revision.Action = skydb.RecordRevisionAction(action)
+	revision.Diff = diff
+	revision.ActorID = actorID.String
+	revision.CreatedAt = revision.CreatedAt.UTC()
+	return nil
+}
+
+func (c *conn) GetRecordRevisions(databaseID string, id skydb.RecordID) ([]skydb.RecordRevision, error) {
+	builder := c.recordRevisionBuilder(databaseID, id).
+		OrderBy("version DESC")
+
+	rows, err := c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []skydb.RecordRevision{}
+	for rows.Next() {
+		revision := skydb.RecordRevision{
+			RecordID:   id,
+			DatabaseID: databaseID,
+		}
+		if err := c.doScanRecordRevision(&revision, rows); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (c *conn) GetRecordRevision(databaseID string, id skydb.RecordID, version int, revision *skydb.RecordRevision) error {
+	builder := c.recordRevisionBuilder(databaseID, id).
+		Where("version = ?", version)
+
+	revision.RecordID = id
+	revision.DatabaseID = databaseID
+	err := c.doScanRecordRevision(revision, c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrRecordRevisionNotFound
+	}
+	return err
+}
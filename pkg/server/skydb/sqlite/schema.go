@@ -0,0 +1,796 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/sqlite/builder"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// deletedAtColumn is the column marking when a record is soft-deleted. A
+// record type is in soft delete mode if its table has this column.
+const deletedAtColumn = "_deleted_at"
+
+func isSoftDeleteEnabled(typemap skydb.RecordSchema) bool {
+	_, ok := typemap[deletedAtColumn]
+	return ok
+}
+
+var errMigrationDisabled = skyerr.NewError(
+	skyerr.IncompatibleSchema,
+	"Record schema requires migration but migration is disabled.",
+)
+
+// reservedSchema returns the types of the columns every record table has,
+// which are not recorded in _record_field_type.
+func reservedSchema() skydb.RecordSchema {
+	return skydb.RecordSchema{
+		"_id":           skydb.FieldType{Type: skydb.TypeString},
+		"_database_id":  skydb.FieldType{Type: skydb.TypeString},
+		"_owner_id":     skydb.FieldType{Type: skydb.TypeString},
+		"_access":       skydb.FieldType{Type: skydb.TypeACL},
+		"_created_at":   skydb.FieldType{Type: skydb.TypeDateTime},
+		"_created_by":   skydb.FieldType{Type: skydb.TypeString},
+		"_updated_at":   skydb.FieldType{Type: skydb.TypeDateTime},
+		"_updated_by":   skydb.FieldType{Type: skydb.TypeString},
+		deletedAtColumn: skydb.FieldType{Type: skydb.TypeDateTime},
+	}
+}
+
+// fieldTypeName returns the name of the type of a field recorded in
+// _record_field_type. The referenced record type of a reference is
+// recorded in its own column.
+func fieldTypeName(fieldType skydb.FieldType) string {
+	if fieldType.Type == skydb.TypeReference {
+		return "ref"
+	}
+	return fieldType.ToSimpleName()
+}
+
+func (db *database) tableExists(name string) (bool, error) {
+	var count int
+	err := db.c.Get(&count, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name)
+	return count > 0, err
+}
+
+// tableColumns returns the columns of a table in the order of definition.
+func (db *database) tableColumns(recordType string) ([]string, error) {
+	rows, err := db.c.Queryx("SELECT name FROM pragma_table_info(?) ORDER BY cid", recordType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// RemoteColumnTypes returns the types of the columns of the table of the
+// record type. The types of fields are recorded in _record_field_type as
+// the columns are not typed; columns added to the table by other means
+// are of unknown type.
+func (db *database) RemoteColumnTypes(recordType string) (skydb.RecordSchema, error) {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	if schema, ok := db.c.RecordSchema[recordType]; ok {
+		logger.Debugf("Using cached remoteColumnTypes %s", recordType)
+		return schema, nil
+	}
+	logger.Debugf("Querying remoteColumnTypes %s", recordType)
+
+	columns, err := db.tableColumns(recordType)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		db.c.RecordSchema[recordType] = nil
+		return nil, nil
+	}
+
+	fieldTypes := map[string]skydb.FieldType{}
+	rows, err := db.c.QueryWith(sqlBuilder.Select("record_field", "type", "reference_type", "underlying_type").
+		From("_record_field_type").
+		Where("record_type = ?", recordType))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var field, typeName string
+		var referenceType, underlyingType *string
+		if err := rows.Scan(&field, &typeName, &referenceType, &underlyingType); err != nil {
+			return nil, err
+		}
+
+		var fieldType skydb.FieldType
+		if typeName == "ref" && referenceType != nil {
+			fieldType = skydb.FieldType{Type: skydb.TypeReference, ReferenceType: *referenceType}
+		} else if fieldType, err = skydb.SimpleNameToFieldType(typeName); err != nil {
+			return nil, err
+		}
+		if underlyingType != nil {
+			fieldType.UnderlyingType = *underlyingType
+		}
+		fieldTypes[field] = fieldType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reserved := reservedSchema()
+	typemap := skydb.RecordSchema{}
+	for _, column := range columns {
+		if fieldType, ok := reserved[column]; ok {
+			typemap[column] = fieldType
+		} else if fieldType, ok := fieldTypes[column]; ok {
+			typemap[column] = fieldType
+		} else {
+			typemap[column] = skydb.FieldType{Type: skydb.TypeUnknown}
+		}
+	}
+
+	constraintsMap, err := db.getFieldConstraints(recordType)
+	if err != nil {
+		return nil, err
+	}
+	for field, constraints := range constraintsMap {
+		if fieldType, ok := typemap[field]; ok {
+			fieldType.Constraints = constraints
+			typemap[field] = fieldType
+		}
+	}
+
+	db.c.RecordSchema[recordType] = typemap
+	logger.Debugf("Cache remoteColumnTypes %s", recordType)
+	return typemap, nil
+}
+
+// getFieldConstraints returns the constraints of fields of a record type
+// stored in _record_field_constraint.
+func (db *database) getFieldConstraints(recordType string) (map[string]*skydb.FieldConstraints, error) {
+	rows, err := db.c.QueryWith(sqlBuilder.Select("record_field", "constraints").
+		From("_record_field_constraint").
+		Where("record_type = ?", recordType))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[string]*skydb.FieldConstraints{}
+	for rows.Next() {
+		var field, data string
+		if err := rows.Scan(&field, &data); err != nil {
+			return nil, err
+		}
+
+		constraints := skydb.FieldConstraints{}
+		if err := json.Unmarshal([]byte(data), &constraints); err != nil {
+			return nil, err
+		}
+		result[field] = &constraints
+	}
+	return result, rows.Err()
+}
+
+func (db *database) GetSchema(recordType string) (skydb.RecordSchema, error) {
+	return db.RemoteColumnTypes(recordType)
+}
+
+func (db *database) GetRecordSchemas() (map[string]skydb.RecordSchema, error) {
+	recordTypes := []string{}
+	err := db.c.Select(&recordTypes, `
+	SELECT name
+	FROM sqlite_master
+	WHERE type = 'table' AND name NOT LIKE '\_%' ESCAPE '\' AND name NOT LIKE 'sqlite\_%' ESCAPE '\'
+	ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]skydb.RecordSchema{}
+	for _, recordType := range recordTypes {
+		schema, err := db.GetSchema(recordType)
+		if err != nil {
+			return nil, err
+		}
+		result[recordType] = schema
+	}
+	return result, nil
+}
+
+func (db *database) Extend(recordType string, recordSchema skydb.RecordSchema) (extended bool, err error) {
+	remoteRecordSchema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return
+	}
+
+	// Find fields of which the constraints are changed. Constraints are
+	// not concerned if they are nil in the requested record schema.
+	constrainingSchema := skydb.RecordSchema{}
+	for key, fieldType := range recordSchema {
+		if fieldType.Constraints == nil {
+			continue
+		}
+		if remoteFieldType, ok := remoteRecordSchema[key]; ok && remoteFieldType.Constraints.Equal(fieldType.Constraints) {
+			continue
+		}
+		constrainingSchema[key] = fieldType
+	}
+
+	if len(remoteRecordSchema) > 0 && remoteRecordSchema.DefinitionCompatibleTo(recordSchema) && len(constrainingSchema) == 0 {
+		// The current record schema is superset of requested record
+		// schema. There is no need to extend the schema.
+		return
+	}
+
+	if !db.c.canMigrate {
+		// The record schemas are different, but the database connection
+		// does not allow migration.
+		err = errMigrationDisabled
+		return
+	}
+
+	err = db.c.write(func() error {
+		extended = false
+		defer delete(db.c.RecordSchema, recordType)
+
+		if len(remoteRecordSchema) == 0 {
+			if err := db.createTable(recordType); err != nil {
+				return fmt.Errorf("failed to create table: %s", err)
+			}
+			extended = true
+		}
+
+		// Find new columns
+		fields := []string{}
+		for key, fieldType := range recordSchema {
+			if remoteFieldType, ok := remoteRecordSchema[key]; ok {
+				if !remoteFieldType.DefinitionCompatibleTo(fieldType) {
+					return skyerr.NewError(
+						skyerr.IncompatibleSchema,
+						fmt.Sprintf("conflicting schema %v => %v", remoteFieldType, fieldType),
+					)
+				}
+				continue
+			}
+
+			if fieldType.Type == skydb.TypeReference && fieldType.ReferenceType != recordType {
+				exists, err := db.tableExists(fieldType.ReferenceType)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return fmt.Errorf(`failed to alter table: relation "%s" does not exist`, fieldType.ReferenceType)
+				}
+			}
+			fields = append(fields, key)
+		}
+		sort.Strings(fields)
+
+		for _, key := range fields {
+			if err := db.addColumn(recordType, key, recordSchema[key]); err != nil {
+				return fmt.Errorf("failed to alter table: %s", err)
+			}
+			extended = true
+		}
+
+		constrainingFields := []string{}
+		for key := range constrainingSchema {
+			constrainingFields = append(constrainingFields, key)
+		}
+		sort.Strings(constrainingFields)
+
+		for _, key := range constrainingFields {
+			fieldType := constrainingSchema[key]
+			if remoteFieldType, ok := remoteRecordSchema[key]; ok {
+				remoteFieldType.Constraints = fieldType.Constraints
+				fieldType = remoteFieldType
+			}
+			if err := db.setFieldConstraints(recordType, key, fieldType); err != nil {
+				return err
+			}
+			extended = true
+		}
+		return nil
+	})
+	if err != nil {
+		extended = false
+	}
+	return
+}
+
+func (db *database) createTable(recordType string) error {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	stmt := createTableStmt(db.TableName(recordType))
+	logger.WithField("stmt", stmt).Debugln("Creating table")
+	_, err := db.c.Exec(stmt)
+	return err
+}
+
+func createTableStmt(tableName string) string {
+	return fmt.Sprintf(`
+CREATE TABLE %s (
+    _id text NOT NULL,
+    _database_id text NOT NULL,
+    _owner_id text NOT NULL,
+    _access text,
+    _created_at text NOT NULL,
+    _created_by text,
+    _updated_at text NOT NULL,
+    _updated_by text,
+    PRIMARY KEY(_id, _database_id, _owner_id),
+    UNIQUE (_id)
+);
+`, tableName)
+}
+
+// addColumn adds the column of a field to the table of the record type
+// and records the type of the field. Existing records are numbered if the
+// field is a sequence, like a new serial column.
+func (db *database) addColumn(recordType, field string, fieldType skydb.FieldType) error {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", db.TableName(recordType), quoteIdentifier(field))
+	logger.WithField("stmt", stmt).Debugln("Adding column to table")
+	if _, err := db.c.Exec(stmt); err != nil {
+		return err
+	}
+
+	var referenceType, underlyingType interface{}
+	if fieldType.Type == skydb.TypeReference {
+		referenceType = fieldType.ReferenceType
+	}
+	if fieldType.Type == skydb.TypeUnknown && fieldType.UnderlyingType != "" {
+		underlyingType = fieldType.UnderlyingType
+	}
+	if _, err := db.c.ExecWith(sqlBuilder.Insert("_record_field_type").
+		Options("OR REPLACE").
+		Columns("record_type", "record_field", "type", "reference_type", "underlying_type").
+		Values(recordType, field, fieldTypeName(fieldType), referenceType, underlyingType)); err != nil {
+		return err
+	}
+
+	if fieldType.Type != skydb.TypeSequence {
+		return nil
+	}
+
+	keys := []string{}
+	if err := db.c.Select(&keys, fmt.Sprintf("SELECT _id FROM %s ORDER BY rowid", db.TableName(recordType))); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value, err := db.nextSequence(recordType, field)
+		if err != nil {
+			return err
+		}
+		if _, err := db.c.Exec(
+			fmt.Sprintf("UPDATE %s SET %s = ? WHERE _id = ?", db.TableName(recordType), quoteIdentifier(field)),
+			value, key,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextSequence returns the next value of the sequence of a field.
+func (db *database) nextSequence(recordType string, field string) (int64, error) {
+	var value int64
+	err := db.c.Get(&value, `
+		INSERT INTO _sequence (record_type, record_field, value) VALUES (?, ?, 1)
+		ON CONFLICT (record_type, record_field) DO UPDATE SET value = value + 1
+		RETURNING value`,
+		recordType, field)
+	return value, err
+}
+
+// advanceSequence makes the sequence of a field continue after the value,
+// like the pq driver does when a value is saved to a sequence field.
+func (db *database) advanceSequence(recordType string, field string, value int64) error {
+	_, err := db.c.Exec(`
+		INSERT INTO _sequence (record_type, record_field, value) VALUES (?, ?, ?)
+		ON CONFLICT (record_type, record_field) DO UPDATE SET value = max(value, excluded.value)`,
+		recordType, field, value)
+	return err
+}
+
+// setFieldConstraints replaces the constraints of a field. Existing
+// records without a required field take the default value of the field,
+// and an error is returned if existing records violate the constraints.
+//
+// Unique is enforced by a unique index named like the unique constraint
+// of the pq driver. The other constraints are checked by the driver when
+// a record is saved.
+func (db *database) setFieldConstraints(recordType, field string, fieldType skydb.FieldType) error {
+	tableName := db.TableName(recordType)
+	column := quoteIdentifier(field)
+
+	constraints := skydb.FieldConstraints{}
+	if fieldType.Constraints != nil {
+		constraints = *fieldType.Constraints
+	}
+
+	violated := func() error {
+		return skyerr.NewErrorWithInfo(
+			skyerr.ConstraintViolated,
+			fmt.Sprintf("existing records of %s violate constraints of field %s", recordType, field),
+			map[string]interface{}{"field": field},
+		)
+	}
+
+	if constraints.Default != nil && constraints.Required {
+		// Existing records without the field take the default value,
+		// otherwise the field cannot be required.
+		value, err := storeValue(fieldType, constraints.Default)
+		if err == nil {
+			value, err = sqlValue(fieldType, value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set default of %s.%s: %s", recordType, field, err)
+		}
+		stmt := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %[2]s IS NULL", tableName, column)
+		if _, err := db.c.Exec(stmt, value); err != nil {
+			return fmt.Errorf("failed to set default of %s.%s: %s", recordType, field, err)
+		}
+	}
+
+	values := []interface{}{}
+	if err := db.c.Select(&values, fmt.Sprintf("SELECT %s FROM %s", column, tableName)); err != nil {
+		return err
+	}
+	for _, v := range values {
+		value, err := scanValue(fieldType, v)
+		if err != nil {
+			return err
+		}
+		if err := constraints.Validate(value); err != nil {
+			return violated()
+		}
+	}
+
+	uniqueName := quoteIdentifier(uniqueConstraintName(recordType, field))
+	if _, err := db.c.Exec("DROP INDEX IF EXISTS " + uniqueName); err != nil {
+		return fmt.Errorf("failed to drop constraints of %s.%s: %s", recordType, field, err)
+	}
+	if constraints.Unique {
+		stmt := fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", uniqueName, tableName, column)
+		if _, err := db.c.Exec(stmt); isUniqueViolated(err) {
+			return violated()
+		} else if err != nil {
+			return fmt.Errorf("failed to add constraints of %s.%s: %s", recordType, field, err)
+		}
+	}
+
+	if _, err := db.c.ExecWith(sqlBuilder.Delete("_record_field_constraint").
+		Where(sq.Eq{"record_type": recordType, "record_field": field})); err != nil {
+		return err
+	}
+
+	if constraints.IsEmpty() {
+		return nil
+	}
+
+	data, err := json.Marshal(constraints)
+	if err != nil {
+		return err
+	}
+	_, err = db.c.ExecWith(sqlBuilder.Insert("_record_field_constraint").
+		Columns("record_type", "record_field", "constraints").
+		Values(recordType, field, string(data)))
+	return err
+}
+
+func uniqueConstraintName(recordType, field string) string {
+	return fmt.Sprintf("%s_%s_unique", recordType, field)
+}
+
+func (db *database) RenameSchema(recordType, oldName, newName string) error {
+	if !db.c.canMigrate {
+		return errMigrationDisabled
+	}
+
+	schema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return fmt.Errorf(`failed to alter table: relation "%s" does not exist`, recordType)
+	}
+	fieldType, ok := schema[oldName]
+	if !ok {
+		return fmt.Errorf(`failed to alter table: column "%s" does not exist`, oldName)
+	}
+	if _, ok := schema[newName]; ok {
+		return fmt.Errorf(`failed to alter table: column "%s" of relation "%s" already exists`, newName, recordType)
+	}
+
+	return db.c.write(func() error {
+		defer delete(db.c.RecordSchema, recordType)
+
+		stmt := fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+			db.TableName(recordType), quoteIdentifier(oldName), quoteIdentifier(newName))
+		if _, err := db.c.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+
+		for _, table := range []string{"_record_field_type", "_record_field_constraint", "_sequence"} {
+			if _, err := db.c.ExecWith(sqlBuilder.Update(table).
+				Set("record_field", newName).
+				Where(sq.Eq{"record_type": recordType, "record_field": oldName})); err != nil {
+				return err
+			}
+		}
+
+		if fieldType.Constraints != nil && fieldType.Constraints.Unique {
+			// The unique index is recreated with the name of the
+			// renamed field.
+			return db.setFieldConstraints(recordType, newName, fieldType)
+		}
+		return nil
+	})
+}
+
+func (db *database) DeleteSchema(recordType, columnName string) error {
+	if !db.c.canMigrate {
+		return errMigrationDisabled
+	}
+
+	schema, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return fmt.Errorf(`failed to alter table: relation "%s" does not exist`, recordType)
+	}
+	if _, ok := schema[columnName]; !ok {
+		return fmt.Errorf(`failed to alter table: column "%s" does not exist`, columnName)
+	}
+
+	return db.c.write(func() error {
+		defer delete(db.c.RecordSchema, recordType)
+
+		// Like PostgreSQL, indexes containing the column are dropped
+		// with the column.
+		indexes, err := db.GetIndexesByRecordType(recordType)
+		if err != nil {
+			return err
+		}
+		for name, index := range indexes {
+			if containsString(index.Fields, columnName) {
+				if _, err := db.c.Exec("DROP INDEX " + quoteIdentifier(name)); err != nil {
+					return err
+				}
+			}
+		}
+
+		stmt := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", db.TableName(recordType), quoteIdentifier(columnName))
+		if _, err := db.c.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+
+		for _, table := range []string{"_record_field_type", "_record_field_constraint", "_sequence"} {
+			if _, err := db.c.ExecWith(sqlBuilder.Delete(table).
+				Where(sq.Eq{"record_type": recordType, "record_field": columnName})); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *database) SetRecordSoftDelete(recordType string, enabled bool) error {
+	if !db.c.canMigrate {
+		return errMigrationDisabled
+	}
+
+	typemap, err := db.RemoteColumnTypes(recordType)
+	if err != nil {
+		return err
+	}
+	if typemap == nil {
+		return skyerr.NewError(skyerr.ResourceNotFound, fmt.Sprintf("record type %s does not exist", recordType))
+	}
+	if isSoftDeleteEnabled(typemap) == enabled {
+		return nil
+	}
+
+	tableName := db.TableName(recordType)
+	column := quoteIdentifier(deletedAtColumn)
+
+	return db.c.write(func() error {
+		defer delete(db.c.RecordSchema, recordType)
+
+		var stmt string
+		if enabled {
+			stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", tableName, column)
+		} else {
+			var count uint64
+			countStmt := fmt.Sprintf("SELECT count(*) FROM %s WHERE %s IS NOT NULL", tableName, column)
+			if err := db.c.Get(&count, countStmt); err != nil {
+				return err
+			}
+			if count > 0 {
+				return skyerr.NewError(
+					skyerr.IncompatibleSchema,
+					fmt.Sprintf("record type %s has %d soft-deleted records", recordType, count),
+				)
+			}
+			stmt = fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", tableName, column)
+		}
+
+		if _, err := db.c.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to alter table: %s", err)
+		}
+		return nil
+	})
+}
+
+// GetIndexesByRecordType returns the indexes of the table of the record
+// type, except those created for the primary key and the unique _id
+// column.
+func (db *database) GetIndexesByRecordType(recordType string) (indexes map[string]skydb.Index, err error) {
+	type indexInfo struct {
+		Name   string `db:"name"`
+		SQL    string `db:"sql"`
+		Unique bool   `db:"is_unique"`
+	}
+
+	infos := []indexInfo{}
+	err = db.c.Select(&infos, `
+	SELECT m.name, m.sql, l."unique" AS is_unique
+	FROM sqlite_master m
+	JOIN pragma_index_list(m.tbl_name) l ON l.name = m.name
+	WHERE m.type = 'index' AND m.tbl_name = ? AND m.sql IS NOT NULL
+	`, recordType)
+	if err != nil {
+		return
+	}
+
+	indexes = map[string]skydb.Index{}
+	for _, info := range infos {
+		index := skydb.Index{
+			Fields: []string{},
+			Unique: info.Unique,
+		}
+		if err = db.c.Select(&index.Fields, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", info.Name); err != nil {
+			return
+		}
+		if i := strings.Index(info.SQL, " WHERE "); i != -1 {
+			index.Condition = info.SQL[i+len(" WHERE "):]
+		}
+		indexes[info.Name] = index
+	}
+	return
+}
+
+// SaveIndex creates an index on the table of the record type.
+func (db *database) SaveIndex(recordType, indexName string, index skydb.Index) error {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	quotedColumns := []string{}
+	for _, col := range index.Fields {
+		quotedColumns = append(quotedColumns, quoteIdentifier(col))
+	}
+
+	stmt := "CREATE "
+	if index.Unique {
+		stmt += "UNIQUE "
+	}
+	stmt += fmt.Sprintf("INDEX %s ON %s (%s)",
+		quoteIdentifier(indexName), db.TableName(recordType), strings.Join(quotedColumns, ","))
+
+	if index.Predicate != nil && !index.Predicate.IsEmpty() {
+		condition, err := db.indexCondition(recordType, *index.Predicate)
+		if err != nil {
+			return err
+		}
+		stmt += " WHERE " + condition
+	}
+
+	logger.WithField("stmt", stmt).Debugln("Creating index")
+	if _, err := db.c.Exec(stmt); isUniqueViolated(err) {
+		return fmt.Errorf(`could not create unique index "%s"`, indexName)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// indexCondition returns the condition of a partial index in SQL. The
+// values are inlined because CREATE INDEX does not accept parameters.
+func (db *database) indexCondition(recordType string, predicate skydb.Predicate) (string, error) {
+	if err := checkIndexPredicate(predicate); err != nil {
+		return "", err
+	}
+
+	factory := builder.NewIndexSqlizerFactory(db, recordType)
+	sqlizer, err := factory.NewPredicateSqlizer(predicate)
+	if err != nil {
+		return "", err
+	}
+
+	sql, args, err := sqlizer.ToSql()
+	if err != nil {
+		return "", err
+	}
+	return builder.InlineArgs(sql, args)
+}
+
+// checkIndexPredicate returns an error if the predicate cannot be used
+// as the condition of a partial index, which can only refer to columns
+// of the indexed table.
+func checkIndexPredicate(predicate skydb.Predicate) error {
+	if predicate.Operator == skydb.Functional {
+		return skyerr.NewError(skyerr.NotSupported, "functional predicate is not supported in index")
+	}
+	if predicate.Operator == skydb.Exists {
+		return skyerr.NewError(skyerr.NotSupported, "exists predicate is not supported in index")
+	}
+
+	for _, child := range predicate.Children {
+		switch c := child.(type) {
+		case skydb.Predicate:
+			if err := checkIndexPredicate(c); err != nil {
+				return err
+			}
+		case skydb.Expression:
+			if c.IsKeyPath() && strings.Contains(c.Value.(string), ".") {
+				return skyerr.NewError(skyerr.NotSupported, "key path of referenced record is not supported in index")
+			}
+			if c.Type == skydb.Function {
+				return skyerr.NewError(skyerr.NotSupported, "function is not supported in index")
+			}
+			if c.IsSubquery() {
+				return skyerr.NewError(skyerr.NotSupported, "subquery is not supported in index")
+			}
+		}
+	}
+	return nil
+}
+
+func (db *database) DeleteIndex(recordType string, indexName string) error {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	stmt := "DROP INDEX " + quoteIdentifier(indexName)
+	logger.WithField("stmt", stmt).Debugln("Deleting index")
+	if _, err := db.c.Exec(stmt); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/sqlite/migration"
+)
+
+// driverName is the name of the database/sql driver, which is the
+// go-sqlite3 driver with the functions used by the driver registered.
+const driverName = "skygear_sqlite3"
+
+var timeNow = func() time.Time { return time.Now().UTC() }
+
+var underscoreRe = regexp.MustCompile(`[.:]`)
+
+func toLowerAndUnderscore(s string) string {
+	return underscoreRe.ReplaceAllLiteralString(strings.ToLower(s), "_")
+}
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(c *sqlite3.SQLiteConn) error {
+			if err := c.RegisterFunc("skygear_distance", distanceFunc, true); err != nil {
+				return err
+			}
+			if err := c.RegisterFunc("skygear_contains", containsFunc, true); err != nil {
+				return err
+			}
+			_, err := c.Exec("PRAGMA case_sensitive_like = ON; PRAGMA foreign_keys = ON;", nil)
+			return err
+		},
+	})
+	skydb.Register("sqlite", sqliteDriver{})
+}
+
+func isUniqueViolated(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+func isForeignKeyViolated(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+// uniqueViolatedColumns returns the columns of a violated unique
+// constraint, which SQLite reports as "UNIQUE constraint failed:
+// table.column1, table.column2".
+func uniqueViolatedColumns(err error) []string {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return nil
+	}
+
+	msg := sqliteErr.Error()
+	i := strings.Index(msg, "constraint failed: ")
+	if i == -1 {
+		return nil
+	}
+
+	columns := []string{}
+	for _, column := range strings.Split(msg[i+len("constraint failed: "):], ", ") {
+		if j := strings.LastIndex(column, "."); j != -1 {
+			column = column[j+1:]
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// Open returns a Conn to the SQLite database of the app at the path
+// specified by the option string.
+func Open(ctx context.Context, appName string, accessModel skydb.AccessModel, optionString string, config skydb.DBConfig) (skydb.Conn, error) {
+	if accessModel == skydb.RelationBasedAccess {
+		return nil, fmt.Errorf("Unsupported AccessModel: RelationBasedAccess")
+	}
+
+	path := databasePath(optionString, appName)
+	db, err := getDB(path, appName, config.CanMigrate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		db:                     db.DB,
+		hub:                    db.hub,
+		RecordSchema:           map[string]skydb.RecordSchema{},
+		appName:                appName,
+		option:                 optionString,
+		accessModel:            accessModel,
+		canMigrate:             config.CanMigrate,
+		passwordHistoryEnabled: config.PasswordHistoryEnabled,
+		context:                ctx,
+	}, nil
+}
+
+// sqliteDriver is the sqlite skydb driver, which keeps the database file of an
+// app open until the app is closed.
+type sqliteDriver struct{}
+
+func (sqliteDriver) Open(ctx context.Context, appName string, accessModel skydb.AccessModel, optionString string, config skydb.DBConfig) (skydb.Conn, error) {
+	return Open(ctx, appName, accessModel, optionString, config)
+}
+
+// CloseApp closes the database file of the app.
+func (sqliteDriver) CloseApp(appName string, optionString string) error {
+	return closeDB(databasePath(optionString, appName), appName)
+}
+
+// databasePath returns the path of the database file of the app.
+func databasePath(optionString string, appName string) string {
+	if info, err := os.Stat(optionString); err == nil && info.IsDir() {
+		return filepath.Join(optionString, "app_"+toLowerAndUnderscore(appName)+".db")
+	}
+	return optionString
+}
+
+// sqliteDB is an opened database file shared by the conns of the file.
+type sqliteDB struct {
+	*sqlx.DB
+	hub     *eventHub
+	appName string
+}
+
+var (
+	dbsMutex sync.Mutex
+	dbs      = map[string]*sqliteDB{}
+)
+
+// getDB returns the opened database file at the path. A database file
+// holds the tables of a single app, so opening it for another app is an
+// error.
+func getDB(path string, appName string, migrate bool) (*sqliteDB, error) {
+	dbsMutex.Lock()
+	defer dbsMutex.Unlock()
+
+	if db, ok := dbs[path]; ok {
+		if db.appName != appName {
+			return nil, fmt.Errorf("failed to open connection: database file %s is used by app %s", path, db.appName)
+		}
+		return db, nil
+	}
+
+	if path == "" || path == ":memory:" || strings.HasPrefix(path, "file::memory:") {
+		return nil, fmt.Errorf("failed to open connection: in-memory SQLite database is not supported, use the mem driver instead")
+	}
+
+	dsn := "file:" + path + "?_busy_timeout=10000&_txlock=immediate&_journal_mode=WAL"
+	db, err := sqlx.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open connection: %s", err)
+	}
+
+	if err := mustInitDB(db, migrate); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open connection: %s", err)
+	}
+
+	sdb := &sqliteDB{
+		DB:      db,
+		hub:     &eventHub{},
+		appName: appName,
+	}
+	dbs[path] = sdb
+	return sdb, nil
+}
+
+// closeDB closes the database file at the path if it is opened for the
+// app.
+func closeDB(path string, appName string) error {
+	dbsMutex.Lock()
+	defer dbsMutex.Unlock()
+
+	db, ok := dbs[path]
+	if !ok || db.appName != appName {
+		return nil
+	}
+
+	delete(dbs, path)
+	return db.Close()
+}
+
+func mustInitDB(db *sqlx.DB, migrate bool) error {
+	err := migration.EnsureLatest(db, migrate)
+	if err != nil {
+		if err == migration.ErrMigrationDisabled {
+			logrus.Warnf(`Schema does not match required version and migration ` +
+				`is disabled. Database schema can only be modified in dev-mode.`)
+			return fmt.Errorf("skydb/sqlite: unable to open database because schema does not match required version")
+		}
+		return fmt.Errorf("skydb/sqlite: unable to migrate database because of an error = %v", err)
+	}
+	return nil
+}
+
+// eventHub delivers record events to the channels subscribed to a
+// database file. It replaces LISTEN/NOTIFY of the pq driver and works
+// within the process only.
+type eventHub struct {
+	mutex    sync.Mutex
+	channels []chan skydb.RecordEvent
+}
+
+func (h *eventHub) subscribe(ch chan skydb.RecordEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.channels = append(h.channels, ch)
+}
+
+func (h *eventHub) emit(events []skydb.RecordEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	channels := h.channels
+	h.mutex.Unlock()
+
+	for _, event := range events {
+		for _, ch := range channels {
+			go func(ch chan skydb.RecordEvent, event skydb.RecordEvent) {
+				ch <- event
+			}(ch, event)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// getTestConn returns a Conn to a new database file, and a function to
+// close and remove the database.
+func getTestConn(t *testing.T) (*conn, func()) {
+	dir, err := ioutil.TempDir("", "skygear-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(context.Background(), "com.oursky.skygear", skydb.RoleBasedAccess, dir, skydb.DBConfig{
+		CanMigrate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*conn), func() {
+		closeDB(databasePath(dir, "com.oursky.skygear"), "com.oursky.skygear")
+		os.RemoveAll(dir)
+	}
+}
+
+func exhaustRows(rows *skydb.Rows, errin error) (records []skydb.Record, err error) {
+	if errin != nil {
+		err = errin
+		return
+	}
+
+	for rows.Scan() {
+		records = append(records, rows.Record())
+	}
+
+	err = rows.Err()
+	return
+}
+
+func TestOpen(t *testing.T) {
+	Convey("Open", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-sqlite")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		defer closeDB(databasePath(dir, "app"), "app")
+		defer closeDB(databasePath(dir, "another-app"), "another-app")
+
+		Convey("shares the database file of the app in a directory", func() {
+			c1, err := Open(context.Background(), "app", skydb.RoleBasedAccess, dir, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+			c2, err := Open(context.Background(), "app", skydb.RoleBasedAccess, dir, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+			c3, err := Open(context.Background(), "another-app", skydb.RoleBasedAccess, dir, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+
+			So(c1.CreateAuth(&skydb.AuthInfo{ID: "shared-user"}), ShouldBeNil)
+			So(c2.GetAuth("shared-user", &skydb.AuthInfo{}), ShouldBeNil)
+			So(c3.GetAuth("shared-user", &skydb.AuthInfo{}), ShouldEqual, skydb.ErrUserNotFound)
+
+			_, err = os.Stat(filepath.Join(dir, "app_app.db"))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("rejects database file of another app", func() {
+			path := filepath.Join(dir, "shared.db")
+			defer closeDB(path, "app")
+
+			_, err := Open(context.Background(), "app", skydb.RoleBasedAccess, path, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+			_, err = Open(context.Background(), "another-app", skydb.RoleBasedAccess, path, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("closes the database file of the app", func() {
+			c, err := Open(context.Background(), "app", skydb.RoleBasedAccess, dir, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+
+			So(skydb.CloseApp("sqlite", "another-app", dir), ShouldBeNil)
+			So(c.(*conn).db.Ping(), ShouldBeNil)
+
+			So(skydb.CloseApp("sqlite", "app", dir), ShouldBeNil)
+			So(c.(*conn).db.Ping(), ShouldNotBeNil)
+
+			reopened, err := Open(context.Background(), "app", skydb.RoleBasedAccess, dir, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+			So(reopened.(*conn).db.Ping(), ShouldBeNil)
+		})
+
+		Convey("is registered as sqlite", func() {
+			c, err := skydb.Open(context.Background(), "sqlite", "app", "role", dir, skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldBeNil)
+			So(c, ShouldHaveSameTypeAs, &conn{})
+		})
+
+		Convey("rejects in-memory database", func() {
+			_, err := Open(context.Background(), "app", skydb.RoleBasedAccess, ":memory:", skydb.DBConfig{CanMigrate: true})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects new database when migration is disabled", func() {
+			_, err := Open(context.Background(), "app", skydb.RoleBasedAccess, dir, skydb.DBConfig{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects relation based access", func() {
+			_, err := Open(context.Background(), "app", skydb.RelationBasedAccess, dir, skydb.DBConfig{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	Convey("Conn", t, func() {
+		c, cleanup := getTestConn(t)
+		defer cleanup()
+		db := c.PublicDB()
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		record := skydb.Record{
+			ID:      skydb.NewRecordID("note", "note1"),
+			OwnerID: "user1",
+			Data:    skydb.Data{"content": "hello"},
+		}
+
+		Convey("hides uncommitted changes from other conns", func() {
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+
+			other := getTestConnOf(c)
+			So(other.PublicDB().Get(record.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+
+			So(c.Commit(), ShouldBeNil)
+			So(other.PublicDB().Get(record.ID, &skydb.Record{}), ShouldBeNil)
+		})
+
+		Convey("keeps a transaction after a failed write", func() {
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+
+			invalid := skydb.Record{
+				ID:      skydb.NewRecordID("note", "note2"),
+				OwnerID: "user1",
+				Data:    skydb.Data{"content": 1},
+			}
+			So(db.Save(&invalid), ShouldNotBeNil)
+			So(c.Commit(), ShouldBeNil)
+
+			So(db.Get(record.ID, &skydb.Record{}), ShouldBeNil)
+			So(db.Get(invalid.ID, &skydb.Record{}), ShouldEqual, skydb.ErrRecordNotFound)
+		})
+
+		Convey("sends record events after commit", func() {
+			ch := make(chan skydb.RecordEvent)
+			So(c.Subscribe(ch), ShouldBeNil)
+
+			So(c.Begin(), ShouldBeNil)
+			So(db.Save(&record), ShouldBeNil)
+			select {
+			case <-ch:
+				t.Fatal("unexpected record event before commit")
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			So(c.Commit(), ShouldBeNil)
+			select {
+			case event := <-ch:
+				So(event.Event, ShouldEqual, skydb.RecordCreated)
+				So(event.Record.ID, ShouldResemble, record.ID)
+			case <-time.After(time.Second):
+				t.Fatal("expected record event")
+			}
+		})
+	})
+}
+
+func TestExplainQuery(t *testing.T) {
+	Convey("Database", t, func() {
+		c, cleanup := getTestConn(t)
+		defer cleanup()
+		db := c.PublicDB()
+
+		_, err := db.Extend("note", skydb.RecordSchema{
+			"content": skydb.FieldType{Type: skydb.TypeString},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("explains query plan", func() {
+			explanation, err := db.ExplainQuery(&skydb.Query{Type: "note"}, &skydb.AccessControlOptions{BypassAccessControl: true})
+			So(err, ShouldBeNil)
+			So(explanation.Statement, ShouldStartWith, "SELECT ")
+			So(explanation.Plan, ShouldNotBeEmpty)
+		})
+	})
+}
+
+// getTestConnOf returns another Conn to the database of the Conn.
+func getTestConnOf(c *conn) *conn {
+	return &conn{
+		db:           c.db,
+		hub:          c.hub,
+		RecordSchema: map[string]skydb.RecordSchema{},
+		appName:      c.appName,
+		option:       c.option,
+		accessModel:  c.accessModel,
+		canMigrate:   c.canMigrate,
+		context:      c.context,
+	}
+}
+
+func TestAuth(t *testing.T) {
+	Convey("Conn", t, func() {
+		c, cleanup := getTestConn(t)
+		defer cleanup()
+
+		Convey("rejects duplicated auth record keys", func() {
+			db := c.PublicDB()
+			user1 := skydb.Record{
+				ID:      skydb.NewRecordID("user", "user1"),
+				OwnerID: "user1",
+				Data:    skydb.Data{"username": "John"},
+			}
+			So(db.Save(&user1), ShouldBeNil)
+
+			user2 := skydb.Record{
+				ID:      skydb.NewRecordID("user", "user2"),
+				OwnerID: "user2",
+				Data:    skydb.Data{"username": "john"},
+			}
+			err := db.Save(&user2)
+			So(err, ShouldNotBeNil)
+			So(err.(skyerr.Error).Code(), ShouldEqual, skyerr.Duplicated)
+		})
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	sq "github.com/lann/squirrel"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+func jsonMap(m map[string]interface{}) (string, error) {
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func (c *conn) CreateOAuthInfo(oauthinfo *skydb.OAuthInfo) error {
+	tokenResponse, err := jsonMap(oauthinfo.TokenResponse)
+	if err != nil {
+		return err
+	}
+	profile, err := jsonMap(oauthinfo.ProviderProfile)
+	if err != nil {
+		return err
+	}
+
+	builder := sqlBuilder.Insert("_sso_oauth").Columns(
+		"user_id",
+		"provider",
+		"principal_id",
+		"token_response",
+		"profile",
+		"_created_at",
+		"_updated_at",
+	).Values(
+		oauthinfo.UserID,
+		oauthinfo.Provider,
+		oauthinfo.PrincipalID,
+		tokenResponse,
+		profile,
+		nullTime(oauthinfo.CreatedAt),
+		nullTime(oauthinfo.UpdatedAt),
+	)
+
+	return c.write(func() error {
+		_, err := c.ExecWith(builder)
+		if isUniqueViolated(err) {
+			return skydb.ErrUserDuplicated
+		}
+		return err
+	})
+}
+
+func (c *conn) oauthInfoBuilder() sq.SelectBuilder {
+	return sqlBuilder.Select("user_id", "provider", "principal_id",
+		"token_response", "profile", "_created_at", "_updated_at").
+		From("_sso_oauth")
+}
+
+func (c *conn) doScanOAuthInfo(oauthinfo *skydb.OAuthInfo, scanner sq.RowScanner) error {
+	var (
+		tokenResponse sql.NullString
+		profile       sql.NullString
+		createdAt     *time.Time
+		updatedAt     *time.Time
+	)
+
+	err := scanner.Scan(
+		&oauthinfo.UserID,
+		&oauthinfo.Provider,
+		&oauthinfo.PrincipalID,
+		&tokenResponse,
+		&profile,
+		&createdAt,
+		&updatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return skydb.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	oauthinfo.TokenResponse = nil
+	if tokenResponse.Valid {
+		if err := json.Unmarshal([]byte(tokenResponse.String), &oauthinfo.TokenResponse); err != nil {
+			return err
+		}
+	}
+	oauthinfo.ProviderProfile = nil
+	if profile.Valid {
+		if err := json.Unmarshal([]byte(profile.String), &oauthinfo.ProviderProfile); err != nil {
+			return err
+		}
+	}
+	oauthinfo.CreatedAt = copyTime(createdAt)
+	oauthinfo.UpdatedAt = copyTime(updatedAt)
+	return nil
+}
+
+func (c *conn) GetOAuthInfo(provider string, principalID string, oauthinfo *skydb.OAuthInfo) error {
+	builder := c.oauthInfoBuilder().
+		Where("provider = ? AND principal_id = ?", provider, principalID)
+	return c.doScanOAuthInfo(oauthinfo, c.QueryRowWith(builder))
+}
+
+func (c *conn) GetOAuthInfoByProviderAndUserID(provider string, userID string, oauthinfo *skydb.OAuthInfo) error {
+	builder := c.oauthInfoBuilder().
+		Where("provider = ? AND user_id = ?", provider, userID)
+	return c.doScanOAuthInfo(oauthinfo, c.QueryRowWith(builder))
+}
+
+func (c *conn) UpdateOAuthInfo(oauthinfo *skydb.OAuthInfo) error {
+	tokenResponse, err := jsonMap(oauthinfo.TokenResponse)
+	if err != nil {
+		return err
+	}
+	profile, err := jsonMap(oauthinfo.ProviderProfile)
+	if err != nil {
+		return err
+	}
+
+	builder := sqlBuilder.Update("_sso_oauth").
+		Set("token_response", tokenResponse).
+		Set("profile", profile).
+		Set("_updated_at", nullTime(oauthinfo.UpdatedAt)).
+		Where("provider = ? AND principal_id = ?", oauthinfo.Provider, oauthinfo.PrincipalID)
+
+	return c.write(func() error {
+		result, err := c.ExecWith(builder)
+		if err != nil {
+			if isUniqueViolated(err) {
+				return skydb.ErrUserDuplicated
+			}
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) DeleteOAuth(provider string, principalID string) error {
+	return c.write(func() error {
+		result, err := c.Exec("DELETE FROM _sso_oauth WHERE provider = ? AND principal_id = ?",
+			provider, principalID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+func (c *conn) CreateCustomTokenInfo(tokenInfo *skydb.CustomTokenInfo) error {
+	createdAt := timeNow()
+	if tokenInfo.CreatedAt != nil && !tokenInfo.CreatedAt.IsZero() {
+		createdAt = tokenInfo.CreatedAt.UTC()
+	}
+
+	builder := sqlBuilder.Insert("_sso_custom_token").Columns(
+		"user_id",
+		"principal_id",
+		"_created_at",
+	).Values(
+		tokenInfo.UserID,
+		tokenInfo.PrincipalID,
+		createdAt,
+	)
+
+	return c.write(func() error {
+		_, err := c.ExecWith(builder)
+		if isUniqueViolated(err) {
+			return skydb.ErrUserDuplicated
+		}
+		return err
+	})
+}
+
+func (c *conn) GetCustomTokenInfo(principalID string, tokenInfo *skydb.CustomTokenInfo) error {
+	var createdAt time.Time
+	builder := sqlBuilder.Select("user_id", "principal_id", "_created_at").
+		From("_sso_custom_token").
+		Where("principal_id = ?", principalID)
+
+	err := c.QueryRowWith(builder).Scan(
+		&tokenInfo.UserID,
+		&tokenInfo.PrincipalID,
+		&createdAt,
+	)
+	if err == sql.ErrNoRows {
+		return skydb.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	createdAt = createdAt.UTC()
+	tokenInfo.CreatedAt = &createdAt
+	return nil
+}
+
+func (c *conn) DeleteCustomTokenInfo(principalID string) error {
+	return c.write(func() error {
+		result, err := c.Exec("DELETE FROM _sso_custom_token WHERE principal_id = ?", principalID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrUserNotFound
+		}
+		return nil
+	})
+}
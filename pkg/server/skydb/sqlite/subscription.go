@@ -0,0 +1,347 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	sq "github.com/lann/squirrel"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+// queryJSON is the JSON representation of a skydb.Query saved in a
+// subscription.
+type queryJSON struct {
+	Type         string
+	Predicate    jsonPredicate
+	Sorts        []skydb.Sort
+	ComputedKeys map[string]skydb.Expression
+	DesiredKeys  []string
+	Limit        *uint64
+	Offset       uint64
+}
+
+func (q queryJSON) query() skydb.Query {
+	return skydb.Query{
+		Type:         q.Type,
+		Predicate:    skydb.Predicate(q.Predicate),
+		Sorts:        q.Sorts,
+		ComputedKeys: q.ComputedKeys,
+		DesiredKeys:  q.DesiredKeys,
+		Limit:        q.Limit,
+		Offset:       q.Offset,
+	}
+}
+
+type jsonPredicate skydb.Predicate
+
+func (p *jsonPredicate) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Operator skydb.Operator
+		Children json.RawMessage
+	}{}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	p.Operator = v.Operator
+
+	if v.Operator.IsCompound() {
+		predicates := []jsonPredicate{}
+		if err := json.Unmarshal(v.Children, &predicates); err != nil {
+			return err
+		}
+		for _, pred := range predicates {
+			p.Children = append(p.Children, skydb.Predicate(pred))
+		}
+	} else {
+		expressions := []skydb.Expression{}
+		if err := json.Unmarshal(v.Children, &expressions); err != nil {
+			return err
+		}
+		for _, expr := range expressions {
+			p.Children = append(p.Children, expr)
+		}
+	}
+
+	return nil
+}
+
+func (db *database) subscriptionBuilder() sq.SelectBuilder {
+	return sqlBuilder.Select("id", "device_id", "type", "notification_info", "query").
+		From("_subscription").
+		Where("auth_id = ?", db.userID).
+		OrderBy("id", "device_id")
+}
+
+func doScanSubscription(subscription *skydb.Subscription, scanner sq.RowScanner) error {
+	var (
+		notificationInfo sql.NullString
+		query            sql.NullString
+	)
+
+	err := scanner.Scan(
+		&subscription.ID,
+		&subscription.DeviceID,
+		&subscription.Type,
+		&notificationInfo,
+		&query,
+	)
+	if err != nil {
+		return err
+	}
+
+	subscription.NotificationInfo = nil
+	if notificationInfo.Valid {
+		info := skydb.NotificationInfo{}
+		if err := json.Unmarshal([]byte(notificationInfo.String), &info); err != nil {
+			return err
+		}
+		subscription.NotificationInfo = &info
+	}
+
+	subscription.Query = skydb.Query{}
+	if query.Valid {
+		q := queryJSON{}
+		if err := json.Unmarshal([]byte(query.String), &q); err != nil {
+			return err
+		}
+		subscription.Query = q.query()
+	}
+	return nil
+}
+
+func (db *database) querySubscriptions(builder sq.SelectBuilder) ([]skydb.Subscription, error) {
+	rows, err := db.c.QueryWith(builder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []skydb.Subscription{}
+	for rows.Next() {
+		s := skydb.Subscription{}
+		if err := doScanSubscription(&s, rows); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+func (db *database) GetSubscription(key string, deviceID string, subscription *skydb.Subscription) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+
+	builder := db.subscriptionBuilder().
+		Where("device_id = ? AND id = ?", deviceID, key)
+	err := doScanSubscription(subscription, db.c.QueryRowWith(builder))
+	if err == sql.ErrNoRows {
+		return skydb.ErrSubscriptionNotFound
+	}
+	return err
+}
+
+func (db *database) SaveSubscription(subscription *skydb.Subscription) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+	if subscription.ID == "" {
+		return errors.New("empty id")
+	}
+	if subscription.Type == "" {
+		return errors.New("empty type")
+	}
+	if subscription.Query.Type == "" {
+		return errors.New("empty query type")
+	}
+	if subscription.DeviceID == "" {
+		return errors.New("empty device id")
+	}
+
+	var notificationInfo interface{}
+	if subscription.NotificationInfo != nil {
+		data, err := json.Marshal(subscription.NotificationInfo)
+		if err != nil {
+			return err
+		}
+		notificationInfo = string(data)
+	}
+	query, err := json.Marshal(subscription.Query)
+	if err != nil {
+		return err
+	}
+
+	pkData := map[string]interface{}{
+		"id":        subscription.ID,
+		"auth_id":   db.userID,
+		"device_id": subscription.DeviceID,
+	}
+
+	data := map[string]interface{}{
+		"type":              subscription.Type,
+		"notification_info": notificationInfo,
+		"query":             string(query),
+	}
+
+	return db.c.write(func() error {
+		_, err := db.c.ExecWith(upsertQuery("_subscription", pkData, data))
+		if isForeignKeyViolated(err) {
+			return skydb.ErrDeviceNotFound
+		}
+		return err
+	})
+}
+
+func (db *database) DeleteSubscription(key string, deviceID string) error {
+	if db.DatabaseType() == skydb.UnionDatabase {
+		return errors.New("union database does not implement subscription")
+	}
+
+	return db.c.write(func() error {
+		result, err := db.c.Exec(
+			"DELETE FROM _subscription WHERE auth_id = ? AND device_id = ? AND id = ?",
+			db.userID, deviceID, key)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return skydb.ErrSubscriptionNotFound
+		}
+		return nil
+	})
+}
+
+func (db *database) GetSubscriptionsByDeviceID(deviceID string) []skydb.Subscription {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	if db.DatabaseType() == skydb.UnionDatabase {
+		logger.WithFields(logrus.Fields{
+			"auth_id":  db.userID,
+			"deviceID": deviceID,
+		}).Errorln("GetSubscriptionsByDeviceID on union database is not implemented")
+		return nil
+	}
+
+	subscriptions, err := db.querySubscriptions(db.subscriptionBuilder().
+		Where("device_id = ?", deviceID))
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"auth_id":  db.userID,
+			"deviceID": deviceID,
+			"err":      err,
+		}).Errorln("failed to query subscriptions by device id")
+		return nil
+	}
+	return subscriptions
+}
+
+func (db *database) GetMatchingSubscriptions(record *skydb.Record) []skydb.Subscription {
+	logger := logging.CreateLogger(db.c.context, "skydb")
+	if db.DatabaseType() == skydb.UnionDatabase {
+		logger.WithFields(logrus.Fields{
+			"auth_id": db.userID,
+		}).Errorln("GetMatchingSubscriptions on union database is not implemented")
+		return nil
+	}
+
+	subscriptions, err := db.querySubscriptions(db.subscriptionBuilder().
+		Where("json_extract(query, '$.Type') = ?", record.ID.Type))
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"record": record,
+			"userID": db.userID,
+			"err":    err,
+		}).Errorln("failed to select subscriptions")
+		return nil
+	}
+
+	// filter without allocation
+	matchingSubs := subscriptions[:0]
+	for _, subscription := range subscriptions {
+		if predMatchRecord(&subscription.Query.Predicate, record) {
+			matchingSubs = append(matchingSubs, subscription)
+		}
+	}
+	return matchingSubs
+}
+
+// predMatchRecord matches a record against the predicate of a
+// subscription, which only supports equality and In comparisons.
+func predMatchRecord(p *skydb.Predicate, record *skydb.Record) bool {
+	if p == nil || p.IsEmpty() {
+		return true
+	}
+
+	switch p.Operator {
+	case skydb.And:
+		for _, childPred := range p.GetSubPredicates() {
+			if !predMatchRecord(&childPred, record) {
+				return false
+			}
+		}
+		return true
+	case skydb.Or:
+		for _, childPred := range p.GetSubPredicates() {
+			if predMatchRecord(&childPred, record) {
+				return true
+			}
+		}
+		return false
+	case skydb.Not:
+		return !predMatchRecord(&p.GetSubPredicates()[0], record)
+	case skydb.Equal:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		return reflect.DeepEqual(lv, rv)
+	case skydb.NotEqual:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		return !reflect.DeepEqual(lv, rv)
+	case skydb.In:
+		lv, rv := extractBinaryOperands(p.GetExpressions(), record)
+		haystack, _ := rv.([]interface{})
+		for _, hay := range haystack {
+			if reflect.DeepEqual(lv, hay) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func extractBinaryOperands(exprs []skydb.Expression, record *skydb.Record) (lv interface{}, rv interface{}) {
+	return extractValue(exprs[0], record), extractValue(exprs[1], record)
+}
+
+func extractValue(expr skydb.Expression, record *skydb.Record) interface{} {
+	if expr.Type == skydb.KeyPath {
+		return record.Get(expr.Value.(string))
+	}
+	return expr.Value
+}
@@ -0,0 +1,259 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build sqlite
+
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/skydb/sqlite/builder"
+)
+
+// storeValue converts a value of record data to the form returned to
+// the caller, which is converted by sqlValue to be saved:
+//
+//	number       float64
+//	integer      int64
+//	sequence     int64
+//	datetime     time.Time in UTC
+//	json         decoded JSON
+//	reference    skydb.Reference of the referenced type
+//	asset        *skydb.Asset with only the name
+//	location     skydb.Location
+//	geometry     skydb.Geometry of decoded GeoJSON
+func storeValue(fieldType skydb.FieldType, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case skydb.TypeNumber:
+		if number, ok := toFloat64(value); ok {
+			return number, nil
+		}
+	case skydb.TypeInteger, skydb.TypeSequence:
+		if number, ok := toFloat64(value); ok && number == math.Trunc(number) {
+			return int64(number), nil
+		}
+	case skydb.TypeBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case skydb.TypeDateTime:
+		switch t := value.(type) {
+		case time.Time:
+			return t.UTC(), nil
+		case *time.Time:
+			if t == nil {
+				return nil, nil
+			}
+			return t.UTC(), nil
+		}
+	case skydb.TypeJSON:
+		return jsonValue(value)
+	case skydb.TypeReference:
+		switch ref := value.(type) {
+		case skydb.Reference:
+			return skydb.NewReference(fieldType.ReferenceType, ref.ID.Key), nil
+		case string:
+			return skydb.NewReference(fieldType.ReferenceType, ref), nil
+		}
+	case skydb.TypeAsset:
+		switch a := value.(type) {
+		case *skydb.Asset:
+			return &skydb.Asset{Name: a.Name}, nil
+		case skydb.Asset:
+			return &skydb.Asset{Name: a.Name}, nil
+		}
+	case skydb.TypeLocation:
+		switch loc := value.(type) {
+		case skydb.Location:
+			return loc, nil
+		case *skydb.Location:
+			return *loc, nil
+		}
+	case skydb.TypeGeometry:
+		if geometry, ok := value.(skydb.Geometry); ok {
+			v, err := jsonValue(map[string]interface{}(geometry))
+			if err != nil {
+				return nil, err
+			}
+			return skydb.Geometry(v.(map[string]interface{})), nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid input value %v for field of type %s", value, fieldType.ToSimpleName())
+}
+
+// jsonValue returns the value as decoded from its JSON encoding.
+func jsonValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// jsonText returns the JSON encoding of the value.
+func jsonText(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// sqlValue converts a value returned by storeValue to the value saved in
+// the column of the field:
+//
+//	datetime     text in builder.DatetimeLayout
+//	boolean      0 or 1
+//	json         JSON text
+//	reference    key of the referenced record
+//	asset        name of the asset
+//	location     GeoJSON Point text
+//	geometry     GeoJSON text
+func sqlValue(fieldType skydb.FieldType, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeDateTime:
+		return builder.FormatDatetime(value.(time.Time)), nil
+	case skydb.TypeJSON, skydb.TypeGeometry:
+		return jsonText(value)
+	case skydb.TypeReference:
+		return value.(skydb.Reference).ID.Key, nil
+	case skydb.TypeAsset:
+		return value.(*skydb.Asset).Name, nil
+	case skydb.TypeLocation:
+		return builder.LocationJSON(value.(skydb.Location)), nil
+	}
+	return value, nil
+}
+
+// scanValue converts a value scanned from the column of a field to the
+// form returned by storeValue.
+func scanValue(fieldType skydb.FieldType, value interface{}) (interface{}, error) {
+	if data, ok := value.([]byte); ok {
+		value = string(data)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch fieldType.Type {
+	case skydb.TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	case skydb.TypeNumber:
+		if number, ok := toFloat64(value); ok {
+			return number, nil
+		}
+	case skydb.TypeInteger, skydb.TypeSequence:
+		if number, ok := toFloat64(value); ok {
+			return int64(number), nil
+		}
+	case skydb.TypeBoolean:
+		switch b := value.(type) {
+		case bool:
+			return b, nil
+		case int64:
+			return b != 0, nil
+		}
+	case skydb.TypeDateTime:
+		switch t := value.(type) {
+		case time.Time:
+			return t.UTC(), nil
+		case string:
+			if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+				return parsed.UTC(), nil
+			}
+		}
+	case skydb.TypeJSON:
+		if s, ok := value.(string); ok {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+				return nil, err
+			}
+			return decoded, nil
+		}
+	case skydb.TypeReference:
+		if s, ok := value.(string); ok {
+			return skydb.NewReference(fieldType.ReferenceType, s), nil
+		}
+	case skydb.TypeAsset:
+		if s, ok := value.(string); ok {
+			return &skydb.Asset{Name: s}, nil
+		}
+	case skydb.TypeLocation:
+		if x, y, ok := pointCoordinates(value); ok {
+			return skydb.NewLocation(x, y), nil
+		}
+	case skydb.TypeGeometry:
+		if s, ok := value.(string); ok {
+			geometry := skydb.Geometry{}
+			if err := json.Unmarshal([]byte(s), &geometry); err != nil {
+				return nil, err
+			}
+			return geometry, nil
+		}
+	case skydb.TypeACL:
+		if s, ok := value.(string); ok {
+			acl := skydb.RecordACL{}
+			if err := json.Unmarshal([]byte(s), &acl); err != nil {
+				return nil, err
+			}
+			return acl, nil
+		}
+	case skydb.TypeUnknown:
+		return skydb.Unknown{UnderlyingType: fieldType.UnderlyingType}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected value %v for field of type %s", value, fieldType.ToSimpleName())
+}
@@ -0,0 +1,39 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skydb
+
+import (
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/asset"
+)
+
+// UploadSession is a resumable upload of an asset, of which the content is
+// uploaded in chunks. The ID of the embedded ChunkUpload is the ID of the
+// session.
+//
+// An UploadSession expires at ExpiresAt, after which its upload is
+// aborted. A zero ExpiresAt means the UploadSession never expires.
+type UploadSession struct {
+	asset.ChunkUpload
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IsExpired returns whether the UploadSession has expired at t.
+func (s *UploadSession) IsExpired(t time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !t.Before(s.ExpiresAt)
+}
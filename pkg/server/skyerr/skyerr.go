@@ -161,6 +161,18 @@ const (
 	// than limit
 	AssetSizeTooLarge
 
+	// AssetPolicyViolated is returned when the asset to be uploaded
+	// violates a configured policy.
+	AssetPolicyViolated
+
+	// RecordConflict is returned when a record to be saved has been
+	// modified since the client last fetched it.
+	RecordConflict
+
+	// ResourceConflict is returned when a resource cannot be modified
+	// because of its current state.
+	ResourceConflict
+
 	// Error codes for expected error condition should be placed
 	// above this line.
 )
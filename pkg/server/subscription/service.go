@@ -48,7 +48,11 @@ func (s *Service) Run() {
 
 	for {
 		select {
-		case event := <-recordEventCh:
+		case event, ok := <-recordEventCh:
+			if !ok {
+				log.Infoln("subscription: record events are closed, stopping the service")
+				return
+			}
 			switch event.Event {
 			case skydb.RecordCreated, skydb.RecordUpdated, skydb.RecordDeleted:
 				conn, err := s.ConnOpener()
@@ -82,7 +86,9 @@ func (s *Service) Run() {
 
 // Stop stops the running subscription service
 func (s *Service) Stop() {
-	s.stop <- struct{}{}
+	if s.stop != nil {
+		s.stop <- struct{}{}
+	}
 }
 
 func (s *Service) subscribe() chan skydb.RecordEvent {
@@ -0,0 +1,146 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tenant hosts multiple apps in one server process.
+//
+// Each app has its own configuration, which is the configuration of the
+// server overridden by a JSON document of the app, such as:
+//
+//	{
+//	    "app": {"name": "blog", "api_key": "...", "master_key": "..."},
+//	    "asset_store": {"implementation": "s3", "s3": {...}},
+//	    "hosts": ["blog.example.com"],
+//	    "plugins": {
+//	        "main": {"transport": "http", "path": "http://blog-plugin:8000"}
+//	    }
+//	}
+//
+// The app name, which defaults to the file name or the name in the
+// registry, namespaces the database of the app. A request is served by
+// the app of the API key or the master key in the X-Skygear-Api-Key
+// header, the api_key query parameter or the api_key of a JSON body, or
+// else by the app of the Host header. An app removed from the directory
+// or the registry is stopped.
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
+)
+
+var log = logging.LoggerEntry("tenant")
+
+// App is an app hosted by the server.
+type App struct {
+	Config skyconfig.Configuration
+
+	// Hosts are the host names of the requests served by the app.
+	Hosts []string
+}
+
+// appDocument is the part of the JSON document of an app other than the
+// configuration.
+type appDocument struct {
+	Hosts   []string                           `json:"hosts"`
+	Plugins map[string]*skyconfig.PluginConfig `json:"plugins"`
+}
+
+// decodeApp returns the App of the JSON document data, with the
+// configuration of the server base as the default.
+func decodeApp(base skyconfig.Configuration, name string, data []byte) (App, error) {
+	config := appBaseConfig(base, name)
+	if err := json.Unmarshal(data, &config); err != nil {
+		return App{}, fmt.Errorf("tenant: failed to parse config of app %s: %v", name, err)
+	}
+
+	doc := appDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return App{}, fmt.Errorf("tenant: failed to parse config of app %s: %v", name, err)
+	}
+	for name, plugin := range doc.Plugins {
+		config.Plugin[name] = plugin
+	}
+
+	if config.TokenStore.Secret == "" {
+		config.TokenStore.Secret = config.App.MasterKey
+	}
+
+	if err := config.Validate(); err != nil {
+		return App{}, fmt.Errorf("tenant: invalid config of app %s: %v", config.App.Name, err)
+	}
+
+	return App{
+		Config: config,
+		Hosts:  doc.Hosts,
+	}, nil
+}
+
+// appBaseConfig returns a copy of the configuration of the server to be
+// overridden by the configuration of an app.
+//
+// The keys and the plugins of the server are not copied, and the token
+// store and the
+// file system asset store are namespaced by the app name, so that the
+// users and the assets of an app are not accessible by another app.
+func appBaseConfig(base skyconfig.Configuration, name string) skyconfig.Configuration {
+	config := base
+	config.Tenant.AppDir = ""
+	config.Tenant.RegistryTable = ""
+
+	config.App.Name = name
+	config.App.APIKey = ""
+	config.App.MasterKey = ""
+
+	if base.TokenStore.Secret == base.App.MasterKey {
+		config.TokenStore.Secret = ""
+	}
+	config.TokenStore.Path = filepath.Join(base.TokenStore.Path, name)
+	config.TokenStore.Prefix = name
+	if base.TokenStore.Prefix != "" {
+		config.TokenStore.Prefix = base.TokenStore.Prefix + ":" + name
+	}
+	config.AssetStore.FileSystemStore.Path = filepath.Join(base.AssetStore.FileSystemStore.Path, name)
+
+	// Maps are merged into and slices are appended to by json.Unmarshal,
+	// so they are copied to keep the configuration of the server intact.
+	config.App.AuthRecordKeys = make([][]string, len(base.App.AuthRecordKeys))
+	for i, keys := range base.App.AuthRecordKeys {
+		config.App.AuthRecordKeys[i] = copyStrings(keys)
+	}
+	config.DB.Replicas = copyStrings(base.DB.Replicas)
+	config.UserAudit.PwExcludedKeywords = copyStrings(base.UserAudit.PwExcludedKeywords)
+	config.UserAudit.PwExcludedFields = copyStrings(base.UserAudit.PwExcludedFields)
+	config.AssetStore.Policies = map[string]*skyconfig.AssetPolicyConfig{}
+	for field, policy := range base.AssetStore.Policies {
+		config.AssetStore.Policies[field] = policy
+	}
+	config.Plugin = map[string]*skyconfig.PluginConfig{}
+	config.LOG.LoggersLevel = map[string]string{}
+	for name, level := range base.LOG.LoggersLevel {
+		config.LOG.LoggersLevel[name] = level
+	}
+
+	return config
+}
+
+func copyStrings(strs []string) []string {
+	if strs == nil {
+		return nil
+	}
+	return append([]string{}, strs...)
+}
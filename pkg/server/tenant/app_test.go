@@ -0,0 +1,101 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
+)
+
+func newBaseConfig() skyconfig.Configuration {
+	config := skyconfig.NewConfiguration()
+	config.Tenant.AppDir = "apps"
+	config.App.APIKey = "server-api-key"
+	config.App.MasterKey = "server-master-key"
+	config.TokenStore.Secret = config.App.MasterKey
+	config.DB.Replicas = []string{"replica"}
+	return config
+}
+
+func TestDecodeApp(t *testing.T) {
+	Convey("decodeApp", t, func() {
+		base := newBaseConfig()
+
+		Convey("overrides server config", func() {
+			app, err := decodeApp(base, "blog", []byte(`{
+				"app": {"api_key": "blog-api-key", "master_key": "blog-master-key"},
+				"database": {"replicas": ["blog-replica"]},
+				"asset_store": {"public": true},
+				"hosts": ["blog.example.com"],
+				"plugins": {
+					"main": {"transport": "http", "path": "http://blog-plugin:8000"}
+				}
+			}`))
+			So(err, ShouldBeNil)
+			So(app.Hosts, ShouldResemble, []string{"blog.example.com"})
+
+			config := app.Config
+			So(config.MultiTenant(), ShouldBeFalse)
+			So(config.App.Name, ShouldEqual, "blog")
+			So(config.App.APIKey, ShouldEqual, "blog-api-key")
+			So(config.App.MasterKey, ShouldEqual, "blog-master-key")
+			So(config.DB.ImplName, ShouldEqual, base.DB.ImplName)
+			So(config.DB.Replicas, ShouldResemble, []string{"blog-replica"})
+			So(config.AssetStore.Public, ShouldBeTrue)
+			So(config.Plugin, ShouldResemble, map[string]*skyconfig.PluginConfig{
+				"main": &skyconfig.PluginConfig{
+					Transport: "http",
+					Path:      "http://blog-plugin:8000",
+				},
+			})
+
+			So(base.DB.Replicas, ShouldResemble, []string{"replica"})
+		})
+
+		Convey("namespaces token store and asset store", func() {
+			app, err := decodeApp(base, "blog", []byte(`{
+				"app": {"api_key": "blog-api-key", "master_key": "blog-master-key"}
+			}`))
+			So(err, ShouldBeNil)
+
+			config := app.Config
+			So(config.TokenStore.Path, ShouldEqual, "data/token/blog")
+			So(config.TokenStore.Prefix, ShouldEqual, "blog")
+			So(config.TokenStore.Secret, ShouldEqual, "blog-master-key")
+			So(config.AssetStore.FileSystemStore.Path, ShouldEqual, "data/asset/blog")
+		})
+
+		Convey("overrides app name", func() {
+			app, err := decodeApp(base, "blog", []byte(`{
+				"app": {"name": "news", "api_key": "news-api-key", "master_key": "news-master-key"}
+			}`))
+			So(err, ShouldBeNil)
+			So(app.Config.App.Name, ShouldEqual, "news")
+		})
+
+		Convey("does not inherit keys of server", func() {
+			_, err := decodeApp(base, "blog", []byte(`{}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("returns error on invalid JSON", func() {
+			_, err := decodeApp(base, "blog", []byte(`{`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
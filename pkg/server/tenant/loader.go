@@ -0,0 +1,124 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
+)
+
+// Loader loads the apps hosted by the server.
+type Loader interface {
+	// Load returns the apps sorted by name. Apps of invalid config are
+	// logged and skipped, so that they do not affect the other apps.
+	Load() ([]App, error)
+}
+
+// DirLoader loads the apps from the JSON files in a directory. The name
+// of an app defaults to the name of its file without the .json
+// extension.
+type DirLoader struct {
+	Dir  string
+	Base skyconfig.Configuration
+}
+
+// Load implements Loader.
+func (l *DirLoader) Load() ([]App, error) {
+	paths, err := filepath.Glob(filepath.Join(l.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	apps := []App{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("tenant: failed to read config of app: %v", err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		app, err := decodeApp(l.Base, name, data)
+		if err != nil {
+			log.WithField("err", err).WithField("path", path).
+				Errorln("tenant: skipping app of invalid config")
+			continue
+		}
+		apps = append(apps, app)
+	}
+
+	sortApps(apps)
+	return apps, nil
+}
+
+// RegistryLoader loads the apps from a registry table, which has the
+// columns name and config:
+//
+//	CREATE TABLE skygear_app (
+//	    name text PRIMARY KEY,
+//	    config text NOT NULL
+//	);
+//
+// The config column holds the JSON document of the app.
+type RegistryLoader struct {
+	DB    *sql.DB
+	Table string
+	Base  skyconfig.Configuration
+}
+
+// Load implements Loader.
+func (l *RegistryLoader) Load() ([]App, error) {
+	rows, err := l.DB.Query(fmt.Sprintf("SELECT name, config FROM %s", l.Table))
+	if err != nil {
+		return nil, fmt.Errorf("tenant: failed to query app registry: %v", err)
+	}
+	defer rows.Close()
+
+	apps := []App{}
+	for rows.Next() {
+		var (
+			name string
+			data []byte
+		)
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, fmt.Errorf("tenant: failed to query app registry: %v", err)
+		}
+
+		app, err := decodeApp(l.Base, name, data)
+		if err != nil {
+			log.WithField("err", err).WithField("app", name).
+				Errorln("tenant: skipping app of invalid config")
+			continue
+		}
+		apps = append(apps, app)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("tenant: failed to query app registry: %v", err)
+	}
+
+	sortApps(apps)
+	return apps, nil
+}
+
+func sortApps(apps []App) {
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Config.App.Name < apps[j].Config.App.Name
+	})
+}
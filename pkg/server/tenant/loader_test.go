@@ -0,0 +1,102 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func appNames(apps []App) []string {
+	names := []string{}
+	for _, app := range apps {
+		names = append(names, app.Config.App.Name)
+	}
+	return names
+}
+
+func TestDirLoader(t *testing.T) {
+	Convey("DirLoader", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-tenant")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		writeApp := func(filename string, data string) {
+			err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(data), 0644)
+			So(err, ShouldBeNil)
+		}
+		writeApp("news.json", `{"app": {"api_key": "news-api-key", "master_key": "news-master-key"}}`)
+		writeApp("blog.json", `{"app": {"api_key": "blog-api-key", "master_key": "blog-master-key"}}`)
+		writeApp("README", `not an app`)
+
+		loader := &DirLoader{Dir: dir, Base: newBaseConfig()}
+
+		Convey("loads apps sorted by name", func() {
+			apps, err := loader.Load()
+			So(err, ShouldBeNil)
+			So(appNames(apps), ShouldResemble, []string{"blog", "news"})
+		})
+
+		Convey("skips apps of invalid config", func() {
+			writeApp("broken.json", `{`)
+			writeApp("nokey.json", `{}`)
+
+			apps, err := loader.Load()
+			So(err, ShouldBeNil)
+			So(appNames(apps), ShouldResemble, []string{"blog", "news"})
+		})
+	})
+}
+
+func TestRegistryLoader(t *testing.T) {
+	Convey("RegistryLoader", t, func() {
+		dir, err := ioutil.TempDir("", "skygear-tenant")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		db, err := sql.Open("sqlite3", filepath.Join(dir, "registry.db"))
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		_, err = db.Exec(`CREATE TABLE skygear_app (name text PRIMARY KEY, config text NOT NULL)`)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`INSERT INTO skygear_app (name, config) VALUES
+			('news', '{"app": {"api_key": "news-api-key", "master_key": "news-master-key"}}'),
+			('blog', '{"app": {"api_key": "blog-api-key", "master_key": "blog-master-key"}, "hosts": ["blog.example.com"]}'),
+			('broken', '{')`)
+		So(err, ShouldBeNil)
+
+		loader := &RegistryLoader{DB: db, Table: "skygear_app", Base: newBaseConfig()}
+
+		Convey("loads apps sorted by name", func() {
+			apps, err := loader.Load()
+			So(err, ShouldBeNil)
+			So(appNames(apps), ShouldResemble, []string{"blog", "news"})
+			So(apps[0].Hosts, ShouldResemble, []string{"blog.example.com"})
+		})
+
+		Convey("returns error on missing table", func() {
+			loader.Table = "missing"
+			_, err := loader.Load()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,388 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skyerr"
+)
+
+// Handler serves the requests of an app until it is stopped.
+type Handler interface {
+	http.Handler
+
+	// Stop stops the services of the app, such as its background jobs
+	// and plugins.
+	Stop()
+}
+
+// Mux serves a request by the handler of the app of the request.
+//
+// The apps are loaded by Reload, which sets up the handlers of the newly
+// added apps, and stops the handlers of the removed apps. A changed
+// config of an app is passed to Reconfigure, which applies what can be
+// changed while the server is running. The rest, such as the plugins and
+// the keys of an app, takes effect only after the server is restarted.
+type Mux struct {
+	Loader Loader
+
+	// NewHandler sets up the services of an app and returns the handler
+	// of its requests, which is stopped when the app is removed.
+	NewHandler func(app App) (Handler, error)
+
+	// Reconfigure applies the changed config of an app to the handler
+	// returned by NewHandler. The changes are not applied if it is nil.
+	Reconfigure func(handler Handler, app App)
+
+	// NotFound handles the requests of no app. It defaults to
+	// AppNotFound.
+	NotFound http.Handler
+
+	// Interval is the interval of reloading apps of Run.
+	Interval time.Duration
+
+	reloadMutex sync.Mutex
+	mutex       sync.RWMutex
+	apps        map[string]*hostedApp
+	appsByKey   map[string]*hostedApp
+	appsByHost  map[string]*hostedApp
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+type hostedApp struct {
+	App
+	handler Handler
+
+	// loaded is the app last loaded, which differs from App if the
+	// config of the app is changed after it is set up.
+	loaded App
+
+	// requests are the requests being served by handler. A request is
+	// added when the app is found for it with the routes locked, so that
+	// no request is added after the app is removed from the routes.
+	requests sync.WaitGroup
+}
+
+// Reload loads the apps, sets up the handlers of the apps not loaded
+// before, and stops the handlers of the apps no longer loaded after the
+// requests being served by them are finished.
+//
+// An added app is skipped if its name, keys or hosts are used by
+// another app.
+func (m *Mux) Reload() error {
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+
+	apps, err := m.Loader.Load()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.RLock()
+	current := m.apps
+	m.mutex.RUnlock()
+
+	loaded := map[string]bool{}
+	for _, app := range apps {
+		loaded[app.Config.App.Name] = true
+	}
+
+	routes := newRoutes()
+	for _, app := range apps {
+		if hosted, ok := current[app.Config.App.Name]; ok {
+			if !reflect.DeepEqual(hosted.loaded.Hosts, app.Hosts) {
+				log.Warnf("tenant: hosts of app %s are changed, restart to apply the changes", app.Config.App.Name)
+			}
+			if !reflect.DeepEqual(hosted.loaded.Config, app.Config) {
+				if m.Reconfigure != nil {
+					m.Reconfigure(hosted.handler, app)
+				} else {
+					log.Warnf("tenant: config of app %s is changed, restart to apply the changes", app.Config.App.Name)
+				}
+			}
+			hosted.loaded = app
+			routes.add(hosted)
+		}
+	}
+
+	for _, app := range apps {
+		name := app.Config.App.Name
+		if _, ok := current[name]; ok {
+			continue
+		}
+		if conflict := routes.conflict(app); conflict != "" {
+			log.Errorf("tenant: skipping app %s of %s used by another app", name, conflict)
+			continue
+		}
+
+		handler, err := m.NewHandler(app)
+		if err != nil {
+			log.WithField("err", err).Errorf("tenant: failed to set up app %s", name)
+			continue
+		}
+
+		routes.add(&hostedApp{App: app, handler: handler, loaded: app})
+		log.Infof("tenant: app %s is added", name)
+	}
+
+	m.mutex.Lock()
+	m.apps = routes.apps
+	m.appsByKey = routes.appsByKey
+	m.appsByHost = routes.appsByHost
+	m.mutex.Unlock()
+
+	// the removed apps are stopped after no more requests are routed to
+	// them and the requests being served are finished, so that an app
+	// added again is set up by NewHandler afresh
+	for name, hosted := range current {
+		if !loaded[name] {
+			hosted.requests.Wait()
+			hosted.handler.Stop()
+			log.Infof("tenant: app %s is removed", name)
+		}
+	}
+	return nil
+}
+
+// Run reloads the apps periodically until Stop is called.
+func (m *Mux) Run() {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stop := m.stopChan()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				log.WithField("err", err).Errorln("tenant: failed to reload apps")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Stop stops reloading the apps started by Run. A Mux stopped before
+// Run does not reload the apps.
+func (m *Mux) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan())
+	})
+}
+
+func (m *Mux) stopChan() chan struct{} {
+	m.initOnce.Do(func() {
+		m.stop = make(chan struct{})
+	})
+	return m.stop
+}
+
+// maxPeekedBodySize is the maximum size of a JSON body read for its
+// api_key.
+const maxPeekedBodySize = 1 << 20
+
+var errBodyTooLarge = errors.New("request body is too large to find its app")
+
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	app, err := m.appOf(r)
+	if err == errBodyTooLarge {
+		bodyTooLarge(w, r)
+		return
+	}
+	if app != nil {
+		defer app.requests.Done()
+		app.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if m.NotFound != nil {
+		m.NotFound.ServeHTTP(w, r)
+		return
+	}
+	AppNotFound(w, r)
+}
+
+// appOf returns the app of the key in the header or the query of the
+// request, or else the app of the host of the request, or else the app
+// of the key in the JSON body of the request. The request is added to
+// the requests of the returned app.
+func (m *Mux) appOf(r *http.Request) (*hostedApp, error) {
+	key := r.Header.Get("X-Skygear-Api-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if app := m.appByKey(key); app != nil {
+		return app, nil
+	}
+	if app := m.appByHost(r.Host); app != nil {
+		return app, nil
+	}
+	if key != "" {
+		return nil, nil
+	}
+
+	key, err := peekAPIKey(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.appByKey(key), nil
+}
+
+func (m *Mux) appByKey(key string) *hostedApp {
+	if key == "" {
+		return nil
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return addRequest(m.appsByKey[key])
+}
+
+func (m *Mux) appByHost(host string) *hostedApp {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return addRequest(m.appsByHost[normalizeHost(host)])
+}
+
+// addRequest adds a request to the requests of app if it is found.
+func addRequest(app *hostedApp) *hostedApp {
+	if app != nil {
+		app.requests.Add(1)
+	}
+	return app
+}
+
+// peekAPIKey returns the api_key of the JSON body of the request. The
+// body is restored so that it is read again by the handler of the app.
+// A body larger than maxPeekedBodySize is not read, and errBodyTooLarge
+// is returned.
+func peekAPIKey(r *http.Request) (string, error) {
+	if r.Body == nil {
+		return "", nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return "", nil
+	}
+	if r.ContentLength > maxPeekedBodySize {
+		return "", errBodyTooLarge
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPeekedBodySize+1))
+	if err != nil {
+		return "", nil
+	}
+	if len(body) > maxPeekedBodySize {
+		return "", errBodyTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	data := struct {
+		APIKey string `json:"api_key"`
+	}{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", nil
+	}
+	return data.APIKey, nil
+}
+
+// bodyTooLarge replies to a request whose body is too large to be read
+// for its api_key with an error.
+func bodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(&router.Response{
+		Err: skyerr.NewError(skyerr.BadRequest, "Request body is too large to find the app of the request"),
+	})
+}
+
+// AppNotFound replies to a request of no app with an error.
+func AppNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(&router.Response{
+		Err: skyerr.NewError(skyerr.AccessKeyNotAccepted, "Cannot find the app of the request"),
+	})
+}
+
+// routes are the apps by name, by key and by host.
+type routes struct {
+	apps       map[string]*hostedApp
+	appsByKey  map[string]*hostedApp
+	appsByHost map[string]*hostedApp
+}
+
+func newRoutes() *routes {
+	return &routes{
+		apps:       map[string]*hostedApp{},
+		appsByKey:  map[string]*hostedApp{},
+		appsByHost: map[string]*hostedApp{},
+	}
+}
+
+func (r *routes) add(app *hostedApp) {
+	r.apps[app.Config.App.Name] = app
+	r.appsByKey[app.Config.App.APIKey] = app
+	r.appsByKey[app.Config.App.MasterKey] = app
+	for _, host := range app.Hosts {
+		r.appsByHost[normalizeHost(host)] = app
+	}
+}
+
+// conflict returns what of the app is used by another app, or an empty
+// string if there is no conflict.
+func (r *routes) conflict(app App) string {
+	if _, ok := r.apps[app.Config.App.Name]; ok {
+		return "name"
+	}
+	for _, key := range []string{app.Config.App.APIKey, app.Config.App.MasterKey} {
+		if _, ok := r.appsByKey[key]; ok {
+			return "key"
+		}
+	}
+	for _, host := range app.Hosts {
+		if _, ok := r.appsByHost[normalizeHost(host)]; ok {
+			return "host " + host
+		}
+	}
+	return ""
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
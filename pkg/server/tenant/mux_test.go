@@ -0,0 +1,308 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type loaderFunc func() ([]App, error)
+
+func (f loaderFunc) Load() ([]App, error) {
+	return f()
+}
+
+func newApp(name string, hosts ...string) App {
+	app := App{Config: newBaseConfig(), Hosts: hosts}
+	app.Config.Tenant.AppDir = ""
+	app.Config.App.Name = name
+	app.Config.App.APIKey = name + "-api-key"
+	app.Config.App.MasterKey = name + "-master-key"
+	return app
+}
+
+// testHandler replies the name of its app. If release is set, it
+// signals serving and waits for release before replying.
+type testHandler struct {
+	name    string
+	stopped bool
+	serving chan struct{}
+	release chan struct{}
+}
+
+func (h *testHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.release != nil {
+		h.serving <- struct{}{}
+		<-h.release
+	}
+	w.Write([]byte(h.name))
+}
+
+func (h *testHandler) Stop() {
+	h.stopped = true
+}
+
+// countingReader counts the bytes read from the Reader.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestMux(t *testing.T) {
+	Convey("Mux", t, func() {
+		apps := []App{
+			newApp("blog", "blog.example.com"),
+			newApp("news"),
+		}
+		var loadErr error
+		setUpCount := map[string]int{}
+		handlers := map[string]*testHandler{}
+
+		mux := &Mux{
+			Loader: loaderFunc(func() ([]App, error) {
+				return apps, loadErr
+			}),
+			NewHandler: func(app App) (Handler, error) {
+				name := app.Config.App.Name
+				if name == "broken" {
+					return nil, errors.New("failed to set up")
+				}
+				setUpCount[name]++
+				handlers[name] = &testHandler{name: name}
+				return handlers[name], nil
+			},
+		}
+		So(mux.Reload(), ShouldBeNil)
+
+		serve := func(r *http.Request) *httptest.ResponseRecorder {
+			resp := httptest.NewRecorder()
+			mux.ServeHTTP(resp, r)
+			return resp
+		}
+		serveKey := func(key string) string {
+			r, _ := http.NewRequest("POST", "http://localhost:3000/", nil)
+			r.Header.Set("X-Skygear-Api-Key", key)
+			return serve(r).Body.String()
+		}
+
+		Convey("serves request by app of key", func() {
+			So(serveKey("blog-api-key"), ShouldEqual, "blog")
+			So(serveKey("news-api-key"), ShouldEqual, "news")
+			So(serveKey("news-master-key"), ShouldEqual, "news")
+		})
+
+		Convey("serves request by app of key in query", func() {
+			r, _ := http.NewRequest("GET", "http://localhost:3000/files/a.png?api_key=news-api-key", nil)
+			So(serve(r).Body.String(), ShouldEqual, "news")
+		})
+
+		Convey("serves request by app of key in JSON body", func() {
+			body := `{"action": "me", "api_key": "news-api-key"}`
+			r, _ := http.NewRequest("POST", "http://localhost:3000/", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json; charset=utf-8")
+			So(serve(r).Body.String(), ShouldEqual, "news")
+
+			restored, err := ioutil.ReadAll(r.Body)
+			So(err, ShouldBeNil)
+			So(string(restored), ShouldEqual, body)
+		})
+
+		Convey("serves request by app of host without reading body", func() {
+			body := &countingReader{Reader: strings.NewReader(`{"api_key": "news-api-key"}`)}
+			r, _ := http.NewRequest("POST", "http://blog.example.com/", body)
+			r.Header.Set("Content-Type", "application/json")
+			So(serve(r).Body.String(), ShouldEqual, "blog")
+			So(body.n, ShouldEqual, 0)
+		})
+
+		Convey("rejects JSON body too large to read key", func() {
+			body := `{"api_key": "news-api-key", "data": "` + strings.Repeat("a", maxPeekedBodySize) + `"}`
+			r, _ := http.NewRequest("POST", "http://localhost:3000/", ioutil.NopCloser(strings.NewReader(body)))
+			r.Header.Set("Content-Type", "application/json")
+			resp := serve(r)
+			So(resp.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(resp.Body.String(), ShouldContainSubstring, `"name":"BadRequest"`)
+		})
+
+		Convey("does not read key in body of other content type", func() {
+			body := `{"api_key": "news-api-key"}`
+			r, _ := http.NewRequest("POST", "http://localhost:3000/records/import", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-ndjson")
+			So(serve(r).Body.String(), ShouldContainSubstring, "AccessKeyNotAccepted")
+		})
+
+		Convey("serves request by app of host", func() {
+			r, _ := http.NewRequest("POST", "http://Blog.Example.com:3000/", nil)
+			So(serve(r).Body.String(), ShouldEqual, "blog")
+
+			r, _ = http.NewRequest("POST", "http://blog.example.com/", nil)
+			r.Header.Set("X-Skygear-Api-Key", "unknown-key")
+			So(serve(r).Body.String(), ShouldEqual, "blog")
+		})
+
+		Convey("replies error to request of no app", func() {
+			r, _ := http.NewRequest("POST", "http://localhost:3000/", nil)
+			r.Header.Set("X-Skygear-Api-Key", "unknown-key")
+			resp := serve(r)
+			So(resp.Code, ShouldEqual, http.StatusUnauthorized)
+			So(resp.Body.String(), ShouldContainSubstring, `"name":"AccessKeyNotAccepted"`)
+		})
+
+		Convey("adds app on reload", func() {
+			apps = append(apps, newApp("shop"))
+			So(mux.Reload(), ShouldBeNil)
+
+			So(serveKey("shop-api-key"), ShouldEqual, "shop")
+			So(serveKey("blog-api-key"), ShouldEqual, "blog")
+			So(setUpCount, ShouldResemble, map[string]int{"blog": 1, "news": 1, "shop": 1})
+		})
+
+		Convey("removes app on reload", func() {
+			apps = apps[:1]
+			So(mux.Reload(), ShouldBeNil)
+
+			So(serveKey("blog-api-key"), ShouldEqual, "blog")
+			So(serveKey("news-api-key"), ShouldContainSubstring, "AccessKeyNotAccepted")
+			So(handlers["news"].stopped, ShouldBeTrue)
+			So(handlers["blog"].stopped, ShouldBeFalse)
+		})
+
+		Convey("stops removed app after its requests are served", func() {
+			slow := handlers["news"]
+			slow.serving = make(chan struct{})
+			slow.release = make(chan struct{})
+			served := make(chan string)
+			go func() {
+				served <- serveKey("news-api-key")
+			}()
+			<-slow.serving
+
+			apps = apps[:1]
+			reloaded := make(chan error)
+			go func() {
+				reloaded <- mux.Reload()
+			}()
+			select {
+			case <-reloaded:
+				t.Fatal("unexpected reload finished before request served")
+			case <-time.After(10 * time.Millisecond):
+			}
+			So(serveKey("news-api-key"), ShouldContainSubstring, "AccessKeyNotAccepted")
+
+			close(slow.release)
+			So(<-served, ShouldEqual, "news")
+			So(<-reloaded, ShouldBeNil)
+			So(slow.stopped, ShouldBeTrue)
+		})
+
+		Convey("sets up app added again after removed", func() {
+			removed := handlers["news"]
+			apps = apps[:1]
+			So(mux.Reload(), ShouldBeNil)
+			apps = append(apps, newApp("news"))
+			So(mux.Reload(), ShouldBeNil)
+
+			So(serveKey("news-api-key"), ShouldEqual, "news")
+			So(setUpCount["news"], ShouldEqual, 2)
+			So(removed.stopped, ShouldBeTrue)
+			So(handlers["news"].stopped, ShouldBeFalse)
+		})
+
+		Convey("keeps app of changed config until restart", func() {
+			changed := newApp("news")
+			changed.Config.App.APIKey = "changed-api-key"
+			apps = []App{apps[0], changed}
+			So(mux.Reload(), ShouldBeNil)
+
+			So(serveKey("news-api-key"), ShouldEqual, "news")
+			So(setUpCount["news"], ShouldEqual, 1)
+		})
+
+		Convey("reconfigures app of changed config", func() {
+			reconfigured := []App{}
+			mux.Reconfigure = func(handler Handler, app App) {
+				reconfigured = append(reconfigured, app)
+			}
+
+			changed := newApp("news")
+			changed.Config.App.CORSHost = "news.example.com"
+			apps = []App{apps[0], changed}
+			So(mux.Reload(), ShouldBeNil)
+			So(mux.Reload(), ShouldBeNil)
+
+			So(reconfigured, ShouldResemble, []App{changed})
+			So(serveKey("news-api-key"), ShouldEqual, "news")
+			So(setUpCount["news"], ShouldEqual, 1)
+		})
+
+		Convey("skips app of conflicting key or host", func() {
+			conflictKey := newApp("shop")
+			conflictKey.Config.App.APIKey = "blog-api-key"
+			conflictHost := newApp("store", "BLOG.example.com")
+			apps = append(apps, conflictKey, conflictHost)
+			So(mux.Reload(), ShouldBeNil)
+
+			So(serveKey("blog-api-key"), ShouldEqual, "blog")
+			So(serveKey("store-api-key"), ShouldContainSubstring, "AccessKeyNotAccepted")
+			So(setUpCount, ShouldNotContainKey, "shop")
+			So(setUpCount, ShouldNotContainKey, "store")
+		})
+
+		Convey("skips app failed to set up", func() {
+			apps = append(apps, newApp("broken"))
+			So(mux.Reload(), ShouldBeNil)
+
+			So(serveKey("broken-api-key"), ShouldContainSubstring, "AccessKeyNotAccepted")
+			So(serveKey("news-api-key"), ShouldEqual, "news")
+		})
+
+		Convey("keeps apps on load error", func() {
+			loadErr = errors.New("failed to load")
+			So(mux.Reload(), ShouldEqual, loadErr)
+
+			So(serveKey("news-api-key"), ShouldEqual, "news")
+		})
+
+		Convey("stops reloading stopped before it runs", func() {
+			mux.Stop()
+
+			done := make(chan struct{})
+			go func() {
+				mux.Run()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected mux stopped")
+			}
+		})
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trash removes soft-deleted records after a retention period.
+package trash
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+)
+
+var log = logging.LoggerEntry("trash")
+
+var timeNow = time.Now
+
+// DefaultRetention is the retention period of a Purger if not specified.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Purger removes records that have been soft-deleted for longer than the
+// retention period, so that they can no longer be undeleted.
+type Purger struct {
+	ConnOpener func() (skydb.Conn, error)
+	Retention  time.Duration
+	Interval   time.Duration
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Purge removes the records soft-deleted before the retention period and
+// returns the number of records removed.
+func (p *Purger) Purge(conn skydb.Conn) (uint64, error) {
+	retention := p.Retention
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	return conn.PurgeDeletedRecords(timeNow().Add(-retention))
+}
+
+// Run purges soft-deleted records periodically until Stop is called.
+func (p *Purger) Run() {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stop := p.stopChan()
+	for {
+		select {
+		case <-ticker.C:
+			p.purge()
+		case <-stop:
+			log.Infoln("trash: stopping the purger")
+			return
+		}
+	}
+}
+
+// Stop stops the purger started by Run. A purger stopped before it runs
+// does not purge.
+func (p *Purger) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan())
+	})
+}
+
+func (p *Purger) stopChan() chan struct{} {
+	p.initOnce.Do(func() {
+		p.stop = make(chan struct{})
+	})
+	return p.stop
+}
+
+func (p *Purger) purge() {
+	conn, err := p.ConnOpener()
+	if err != nil {
+		log.WithField("err", err).Errorln("trash: failed to open skydb.Conn")
+		return
+	}
+	defer conn.Close()
+
+	purged, err := p.Purge(conn)
+	if err != nil {
+		log.WithField("err", err).Errorln("trash: failed to purge soft-deleted records")
+		return
+	}
+
+	if purged > 0 {
+		log.Infof("trash: purged %d soft-deleted records", purged)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type trashConn struct {
+	deletedAt map[string]time.Time
+	skydb.Conn
+}
+
+func (conn *trashConn) PurgeDeletedRecords(deletedBefore time.Time) (uint64, error) {
+	var purged uint64
+	for id, t := range conn.deletedAt {
+		if t.Before(deletedBefore) {
+			delete(conn.deletedAt, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func TestPurger(t *testing.T) {
+	Convey("Purger", t, func() {
+		now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		originalTimeNow := timeNow
+		timeNow = func() time.Time { return now }
+		defer func() {
+			timeNow = originalTimeNow
+		}()
+
+		conn := &trashConn{
+			deletedAt: map[string]time.Time{
+				"note/old":    now.Add(-31 * 24 * time.Hour),
+				"note/recent": now.Add(-time.Hour),
+			},
+		}
+
+		Convey("purges records deleted before the default retention", func() {
+			purger := &Purger{}
+			purged, err := purger.Purge(conn)
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 1)
+			So(conn.deletedAt, ShouldContainKey, "note/recent")
+			So(conn.deletedAt, ShouldNotContainKey, "note/old")
+		})
+
+		Convey("purges records deleted before the retention", func() {
+			purger := &Purger{Retention: 30 * time.Minute}
+			purged, err := purger.Purge(conn)
+			So(err, ShouldBeNil)
+			So(purged, ShouldEqual, 2)
+			So(conn.deletedAt, ShouldBeEmpty)
+		})
+
+		Convey("stops purger stopped before it runs", func() {
+			purger := &Purger{
+				ConnOpener: func() (skydb.Conn, error) { return conn, nil },
+				Interval:   time.Hour,
+			}
+			purger.Stop()
+
+			done := make(chan struct{})
+			go func() {
+				purger.Run()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected purger stopped")
+			}
+		})
+	})
+}
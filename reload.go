@@ -0,0 +1,226 @@
+// Copyright 2015-present Oursky Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skygeario/skygear-server/pkg/server/audit"
+	"github.com/skygeario/skygear-server/pkg/server/authtoken"
+	"github.com/skygeario/skygear-server/pkg/server/logging"
+	"github.com/skygeario/skygear-server/pkg/server/push"
+	"github.com/skygeario/skygear-server/pkg/server/router"
+	"github.com/skygeario/skygear-server/pkg/server/skyconfig"
+	"github.com/skygeario/skygear-server/pkg/server/skydb"
+	"github.com/skygeario/skygear-server/pkg/server/tenant"
+)
+
+// apnsStopDelay is the delay of stopping a replaced APNS pusher, so that
+// the notifications being sent by it are not dropped.
+const apnsStopDelay = time.Minute
+
+// tenantSettings are the settings of a server hosting multiple apps
+// which can be applied without restarting the server. The settings of
+// the apps are reloaded with the apps.
+var tenantSettings = append(append([]string{}, skyconfig.LoggerSettings...), "App.CORSHost")
+
+// appServer serves the requests of an app, whose configuration can be
+// reloaded by reloader.
+type appServer struct {
+	http.Handler
+	reloader *appReloader
+	stops    []func()
+}
+
+// Stop stops the services of the app and closes its database, after
+// which the app can be set up again by initApp.
+func (s *appServer) Stop() {
+	for _, stop := range s.stops {
+		stop()
+	}
+}
+
+// appReloader applies the settings in live of a reloaded configuration
+// to the services of an app.
+type appReloader struct {
+	mutex           sync.Mutex
+	config          skyconfig.Configuration
+	live            []string
+	connOpener      func() (skydb.Conn, error)
+	cors            *router.CORSMiddleware
+	passwordChecker *audit.PasswordChecker
+	tokenStore      authtoken.Store
+	pushSender      *push.ReloadableSender
+	apnsPusher      push.APNSPusher
+}
+
+// Reload applies the changed settings of config. Nothing is applied if
+// the push services of config cannot be set up.
+func (r *appReloader) Reload(config skyconfig.Configuration) (skyconfig.ReloadReport, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	running, report := skyconfig.Reload(r.config, config, r.live)
+	if pushConfigChanged(r.config, running) {
+		routeSender, apnsPusher, err := initPushSender(running, r.connOpener)
+		if err != nil {
+			return skyconfig.ReloadReport{}, err
+		}
+
+		r.pushSender.Replace(routeSender)
+		if r.apnsPusher != nil {
+			time.AfterFunc(apnsStopDelay, r.apnsPusher.Stop)
+		}
+		r.apnsPusher = apnsPusher
+	}
+
+	r.cors.SetOrigin(running.App.CORSHost)
+	r.passwordChecker.UpdatePolicy(initPasswordChecker(running))
+	if store, ok := r.tokenStore.(authtoken.ExpirySetter); ok {
+		store.SetExpiry(running.TokenStore.Expiry)
+	}
+
+	r.config = running
+	return report, nil
+}
+
+// Stop stops the APNS pusher of the app.
+func (r *appReloader) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.apnsPusher != nil {
+		r.apnsPusher.Stop()
+		r.apnsPusher = nil
+	}
+}
+
+func pushConfigChanged(running skyconfig.Configuration, config skyconfig.Configuration) bool {
+	return !reflect.DeepEqual(running.APNS, config.APNS) ||
+		!reflect.DeepEqual(running.GCM, config.GCM) ||
+		!reflect.DeepEqual(running.Baidu, config.Baidu)
+}
+
+// serverReloader reloads the configuration of the server from the
+// environment, and applies it to the loggers and the app of the server,
+// or the apps hosted by the server.
+type serverReloader struct {
+	mutex  sync.Mutex
+	config skyconfig.Configuration
+
+	// app is the app of a server hosting a single app.
+	app *appReloader
+
+	// mux serves the apps of a server hosting multiple apps.
+	mux *tenant.Mux
+}
+
+// Listen reloads the configuration whenever the server receives SIGHUP.
+func (r *serverReloader) Listen() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		r.ReloadConfig()
+	}
+}
+
+// ReloadConfig reloads the configuration of the server.
+func (r *serverReloader) ReloadConfig() (skyconfig.ReloadReport, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	logger := logging.LoggerEntryWithTag("main", "config")
+	config := skyconfig.NewConfiguration()
+	config.ReadFromEnv()
+	if err := config.Validate(); err != nil {
+		logger.WithField("err", err).Errorln("Failed to reload config")
+		return skyconfig.ReloadReport{}, err
+	}
+
+	var running skyconfig.Configuration
+	var report skyconfig.ReloadReport
+	if r.mux != nil {
+		running, report = skyconfig.Reload(r.config, config, tenantSettings)
+		r.mux.NotFound.(*router.CORSMiddleware).SetOrigin(running.App.CORSHost)
+	} else {
+		running, report = skyconfig.Reload(r.config, config, skyconfig.LiveSettings)
+		if _, err := r.app.Reload(config); err != nil {
+			logger.WithField("err", err).Errorln("Failed to reload config")
+			return skyconfig.ReloadReport{}, err
+		}
+	}
+
+	reloadLogger(running)
+	r.config = running
+	logReloadReport(logger, report)
+
+	if r.mux != nil {
+		// the apps are reloaded with the changed settings of each app
+		// logged by the mux
+		if err := r.mux.Reload(); err != nil {
+			logger.WithField("err", err).Errorln("Failed to reload apps")
+			return skyconfig.ReloadReport{}, err
+		}
+	}
+	return report, nil
+}
+
+// tenantAppReloader reloads the configuration of an app hosted by a
+// server hosting multiple apps.
+type tenantAppReloader struct {
+	loader tenant.Loader
+	name   string
+	app    *appReloader
+}
+
+// ReloadConfig reloads the configuration of the app.
+func (r *tenantAppReloader) ReloadConfig() (skyconfig.ReloadReport, error) {
+	apps, err := r.loader.Load()
+	if err != nil {
+		return skyconfig.ReloadReport{}, err
+	}
+
+	for _, app := range apps {
+		if app.Config.App.Name != r.name {
+			continue
+		}
+
+		report, err := r.app.Reload(app.Config)
+		if err != nil {
+			return skyconfig.ReloadReport{}, err
+		}
+		logReloadReport(logging.LoggerEntryWithTag("main", "tenant").WithField("app", r.name), report)
+		return report, nil
+	}
+	return skyconfig.ReloadReport{}, fmt.Errorf("app %s is not found", r.name)
+}
+
+func logReloadReport(logger *logrus.Entry, report skyconfig.ReloadReport) {
+	if len(report.Applied) > 0 {
+		logger.Infof("Config reloaded, applied changes of %v", report.Applied)
+	}
+	if len(report.RestartRequired) > 0 {
+		logger.Warnf("Config reloaded, restart to apply changes of %v", report.RestartRequired)
+	}
+}